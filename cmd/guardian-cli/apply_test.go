@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/dollarshaveclub/guardian/pkg/guardian"
+	"github.com/go-redis/redis"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+)
+
+const testYAMLConfDocument = `# applied by TestApplyYAML
+whitelist:
+  - 10.0.0.0/8
+blacklist: [192.168.9.0/24]
+limit:
+  count: 10
+  duration: 1m
+  enabled: true
+report_only: false
+rules:
+  block-admin:
+    when: req.path.startsWith("/admin") && req.method == "POST"
+    action: block
+`
+
+func TestApplyYAML(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer s.Close()
+
+	logger := &logrus.Logger{Out: ioutil.Discard}
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	store := guardian.NewRedisConfStore(client, []net.IPNet{}, []net.IPNet{}, guardian.Limit{}, false, nil, false, logger, guardian.NullReporter{})
+
+	dir, err := ioutil.TempDir("", "guardian")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "conf.yaml")
+	if err := ioutil.WriteFile(path, []byte(testYAMLConfDocument), 0644); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	before, err := store.ExportConfDocument()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	planned, err := apply(store, path, true, guardian.ConfChunks{})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if len(planned) == 0 {
+		t.Fatalf("expected the dry run to plan changes")
+	}
+
+	afterDryRun, err := store.ExportConfDocument()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if diff := cmp.Diff(before, afterDryRun); diff != "" {
+		t.Errorf("dry run changed the stored conf (-before +after):\n%s", diff)
+	}
+
+	applied, err := apply(store, path, false, guardian.ConfChunks{})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if diff := cmp.Diff(planned, applied); diff != "" {
+		t.Errorf("applied changes differ from the dry run (-planned +applied):\n%s", diff)
+	}
+
+	expected, err := guardian.LoadConfDocument(path)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	got, err := store.ExportConfDocument()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if diff := cmp.Diff(expected.Rules, got.Rules); diff != "" {
+		t.Errorf("unexpected rules (-expected +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(expected.Blacklist, got.Blacklist); diff != "" {
+		t.Errorf("unexpected blacklist (-expected +got):\n%s", diff)
+	}
+
+	remaining, err := apply(store, path, true, guardian.ConfChunks{})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("expected no changes after applying, received %v", remaining)
+	}
+}
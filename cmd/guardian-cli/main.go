@@ -1,9 +1,16 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dollarshaveclub/guardian/pkg/guardian"
 	"github.com/go-redis/redis"
@@ -15,7 +22,14 @@ import (
 func main() {
 	app := kingpin.New("guardian-cli", "cli interface for controlling guardian")
 	logLevel := app.Flag("log-level", "log level.").Short('l').Default("error").OverrideDefaultFromEnvar("LOG_LEVEL").String()
-	redisAddress := app.Flag("redis-address", "host:port.").Short('r').OverrideDefaultFromEnvar("REDIS_ADDRESS").Required().String()
+	redisAddress := app.Flag("redis-address", "host:port.").Short('r').OverrideDefaultFromEnvar("REDIS_ADDRESS").String()
+	redisUsername := app.Flag("redis-username", "redis acl username, requires redis-password").OverrideDefaultFromEnvar("REDIS_USERNAME").String()
+	redisPassword := app.Flag("redis-password", "redis auth password").OverrideDefaultFromEnvar("REDIS_PASSWORD").String()
+	redisTLS := app.Flag("redis-tls", "connect to redis with tls").Default("false").OverrideDefaultFromEnvar("REDIS_TLS").Bool()
+	redisTLSCAFile := app.Flag("redis-tls-ca-file", "pem file of cas trusted to sign the redis server certificate. the system cas are trusted if empty.").OverrideDefaultFromEnvar("REDIS_TLS_CA_FILE").String()
+	redisTLSServerName := app.Flag("redis-tls-server-name", "name the redis server certificate is verified against. defaults to the host of redis-address.").OverrideDefaultFromEnvar("REDIS_TLS_SERVER_NAME").String()
+	redisTLSSkipVerify := app.Flag("redis-tls-skip-verify", "skip verifying the redis server certificate").Default("false").OverrideDefaultFromEnvar("REDIS_TLS_SKIP_VERIFY").Bool()
+	signingKeyFile := app.Flag("signing-key-file", "pem encoded ed25519 private key used to sign the conf after changing it").OverrideDefaultFromEnvar("SIGNING_KEY_FILE").String()
 
 	// Whitelisting
 	addWhitelistCmd := app.Command("add-whitelist", "Add CIDRs to the IP Whitelist")
@@ -25,6 +39,16 @@ func main() {
 	removeCidrStrings := removeWhitelistCmd.Arg("cidr", "CIDR").Required().Strings()
 
 	getWhitelistCmd := app.Command("get-whitelist", "Get whitelisted CIDRs")
+	getWhitelistOutput := outputFlags(getWhitelistCmd, true)
+
+	addWhitelistHostCmd := app.Command("add-whitelist-host", "Whitelist the addresses hostnames resolve to, refreshed periodically")
+	addWhitelistHosts := addWhitelistHostCmd.Arg("host", "hostname, e.g. partner-gateway.example.com").Required().Strings()
+
+	removeWhitelistHostCmd := app.Command("remove-whitelist-host", "Remove hostnames from the whitelist")
+	removeWhitelistHosts := removeWhitelistHostCmd.Arg("host", "hostname").Required().Strings()
+
+	getWhitelistHostsCmd := app.Command("get-whitelist-hosts", "Get whitelisted hostnames")
+	getWhitelistHostsOutput := outputFlags(getWhitelistHostsCmd, true)
 
 	// Blacklisting
 	addBlacklistCmd := app.Command("add-blacklist", "Add CIDRs to the IP Blacklist")
@@ -34,26 +58,178 @@ func main() {
 	removeBlacklistCidrStrings := removeBlacklistCmd.Arg("cidr", "CIDR").Required().Strings()
 
 	getBlacklistCmd := app.Command("get-blacklist", "Get blacklisted CIDRs")
+	getBlacklistOutput := outputFlags(getBlacklistCmd, true)
+
+	checkConflictsCmd := app.Command("check-conflicts", "Lists whitelisted CIDRs overlapping blacklisted CIDRs, exiting with an error if there are any. Requests from the overlaps are whitelisted")
+	checkConflictsOutput := outputFlags(checkConflictsCmd, true)
+
+	checkIPCmd := app.Command("check-ip", "Lists the whitelisted and blacklisted CIDRs containing an IP, including those not yet synced by instances. Requests from IPs that are both are whitelisted")
+	checkIP := checkIPCmd.Arg("ip", "IP address").Required().String()
+	checkIPOutput := outputFlags(checkIPCmd, true)
 
 	// Rate limiting
 	setLimitCmd := app.Command("set-limit", "Sets the IP rate limit")
 	limitCount := setLimitCmd.Arg("count", "limit count").Required().Uint64()
 	limitDuration := setLimitCmd.Arg("duration", "limit duration").Required().Duration()
 	limitEnabled := setLimitCmd.Arg("enabled", "limit enabled").Required().Bool()
+	limitAlgorithm := setLimitCmd.Flag("algorithm", "limit algorithm, one of fixed_window, leaky_bucket, or day_buckets").Default(string(guardian.FixedWindowAlgorithm)).String()
+	limitEnforcePercent := setLimitCmd.Flag("enforce-percent", "percentage of clients the limit blocks, 0 enforces for all clients").Default("0").Uint()
+	limitCalendar := setLimitCmd.Flag("calendar", "align fixed windows to a calendar minute, hour, or day instead of the duration").Default("").Enum("", "minute", "hour", "day")
+	limitTimeZone := setLimitCmd.Flag("time-zone", "IANA time zone calendar windows are aligned in, defaults to UTC").Default("").String()
+	limitRollover := setLimitCmd.Flag("rollover", "max unused count of a client's previous fixed window carried into its next, 0 disables rollover").Default("0").Uint64()
 
 	getLimitCmd := app.Command("get-limit", "Gets the IP rate limit")
+	getLimitOutput := outputFlags(getLimitCmd, false)
+
+	// Route rate limiting
+	setRouteLimitCmd := app.Command("set-route-limit", "Sets the rate limit for a route. Segments of the form {name} or {name:regexp} match any path segment, or those matching regexp")
+	routeLimitRoute := setRouteLimitCmd.Arg("route", "route, e.g. /users/{id}/orders, or api.example.com/users/{id}/orders for requests to that authority only").Required().String()
+	routeLimitCount := setRouteLimitCmd.Arg("count", "limit count").Required().Uint64()
+	routeLimitDuration := setRouteLimitCmd.Arg("duration", "limit duration").Required().Duration()
+	routeLimitEnabled := setRouteLimitCmd.Arg("enabled", "limit enabled").Required().Bool()
+	routeLimitAlgorithm := setRouteLimitCmd.Flag("algorithm", "limit algorithm, one of fixed_window, leaky_bucket, or day_buckets").Default(string(guardian.FixedWindowAlgorithm)).String()
+	routeLimitCalendar := setRouteLimitCmd.Flag("calendar", "align fixed windows to a calendar minute, hour, or day instead of the duration").Default("").Enum("", "minute", "hour", "day")
+	routeLimitTimeZone := setRouteLimitCmd.Flag("time-zone", "IANA time zone calendar windows are aligned in, defaults to UTC").Default("").String()
+	routeLimitRollover := setRouteLimitCmd.Flag("rollover", "max unused count of a client's previous fixed window carried into its next, 0 disables rollover").Default("0").Uint64()
+	routeLimitMetadata := metadataFlags(setRouteLimitCmd)
+
+	removeRouteLimitCmd := app.Command("remove-route-limit", "Removes the rate limit for a route")
+	removeRouteLimitRoute := removeRouteLimitCmd.Arg("route", "route").Required().String()
+
+	getRouteLimitsCmd := app.Command("get-route-limits", "Gets the route rate limits")
+	getRouteLimitsOutput := outputFlags(getRouteLimitsCmd, true)
+
+	// Scoped rate limiting
+	setScopedLimitCmd := app.Command("set-scoped-limit", "Sets the rate limit for requests to an authority or from a key. Fields that aren't set are inherited from the broader scopes")
+	scopedLimitScope := setScopedLimitCmd.Arg("scope", "scope, authority or key").Required().Enum(string(guardian.AuthorityLimitScope), string(guardian.KeyLimitScope))
+	scopedLimitName := setScopedLimitCmd.Arg("name", "authority, e.g. api.example.com, or key, e.g. 192.168.1.2").Required().String()
+	scopedLimitCount := setScopedLimitCmd.Flag("count", "limit count").String()
+	scopedLimitDuration := setScopedLimitCmd.Flag("duration", "limit duration").String()
+	scopedLimitEnabled := setScopedLimitCmd.Flag("enabled", "limit enabled").Default("").Enum("", "true", "false")
+	scopedLimitAlgorithm := setScopedLimitCmd.Flag("algorithm", "limit algorithm, one of fixed_window, leaky_bucket, or day_buckets").String()
+	scopedLimitEnforcePercent := setScopedLimitCmd.Flag("enforce-percent", "percentage of clients the limit blocks, 0 enforces for all clients").String()
+	scopedLimitCalendar := setScopedLimitCmd.Flag("calendar", "align fixed windows to a calendar minute, hour, or day instead of the duration").Default("").Enum("", "minute", "hour", "day")
+	scopedLimitTimeZone := setScopedLimitCmd.Flag("time-zone", "IANA time zone calendar windows are aligned in, defaults to UTC").Default("").String()
+	scopedLimitRollover := setScopedLimitCmd.Flag("rollover", "max unused count of a client's previous fixed window carried into its next, 0 disables rollover").String()
+	scopedLimitMetadata := metadataFlags(setScopedLimitCmd)
+
+	removeScopedLimitCmd := app.Command("remove-scoped-limit", "Removes the rate limit for requests to an authority or from a key")
+	removeScopedLimitScope := removeScopedLimitCmd.Arg("scope", "scope, authority or key").Required().Enum(string(guardian.AuthorityLimitScope), string(guardian.KeyLimitScope))
+	removeScopedLimitName := removeScopedLimitCmd.Arg("name", "authority or key").Required().String()
+
+	getScopedLimitsCmd := app.Command("get-scoped-limits", "Gets the rate limits for requests to authorities or from keys")
+	getScopedLimitsOutput := outputFlags(getScopedLimitsCmd, true)
+	getScopedLimitsScope := getScopedLimitsCmd.Arg("scope", "scope, authority or key").Required().Enum(string(guardian.AuthorityLimitScope), string(guardian.KeyLimitScope))
+
+	// Limit experiments
+	setLimitExperimentCmd := app.Command("set-limit-experiment", "Limits a percentage of clients with an alternate limit, counting them against both limits and reporting both decisions for comparison. Fields that aren't set are those of the limit resolved for each request")
+	limitExperimentPercent := setLimitExperimentCmd.Arg("percent", "percentage of clients evaluated by the alternate limit").Required().Uint()
+	limitExperimentCount := setLimitExperimentCmd.Flag("count", "alternate limit count").String()
+	limitExperimentDuration := setLimitExperimentCmd.Flag("duration", "alternate limit duration").String()
+	limitExperimentAlgorithm := setLimitExperimentCmd.Flag("algorithm", "alternate limit algorithm, one of fixed_window, leaky_bucket, or day_buckets").String()
+	limitExperimentCalendar := setLimitExperimentCmd.Flag("calendar", "align the alternate limit's fixed windows to a calendar minute, hour, or day instead of the duration").Default("").Enum("", "minute", "hour", "day")
+	limitExperimentTimeZone := setLimitExperimentCmd.Flag("time-zone", "IANA time zone calendar windows are aligned in, defaults to UTC").Default("").String()
+	limitExperimentRollover := setLimitExperimentCmd.Flag("rollover", "max unused count of a client's previous fixed window carried into its next, 0 disables rollover").String()
+	limitExperimentMetadata := metadataFlags(setLimitExperimentCmd)
+
+	removeLimitExperimentCmd := app.Command("remove-limit-experiment", "Ends the limit experiment")
+
+	getLimitExperimentCmd := app.Command("get-limit-experiment", "Gets the limit experiment")
+	getLimitExperimentOutput := outputFlags(getLimitExperimentCmd, false)
+
+	// Rules
+	setRuleCmd := app.Command("set-rule", "Sets a rule applying an action to requests matching an expression. Rules are evaluated in order of descending priority, and then name")
+	ruleName := setRuleCmd.Arg("name", "rule name").Required().String()
+	ruleWhen := setRuleCmd.Arg("when", `expression matching requests, e.g. req.path.startsWith("/api") && req.method == "POST" && !ip.inCIDR("10.0.0.0/8")`).Required().String()
+	ruleAction := setRuleCmd.Flag("action", "action for matching requests, one of limit, block, allow, serve, observe, or challenge").Default(string(guardian.LimitAction)).Enum(string(guardian.LimitAction), string(guardian.BlockAction), string(guardian.AllowAction), string(guardian.ServeAction), string(guardian.ObserveAction), string(guardian.ChallengeAction))
+	ruleLimitCount := setRuleCmd.Flag("limit-count", "limit count for the limit and observe actions").Uint64()
+	ruleLimitDuration := setRuleCmd.Flag("limit-duration", "limit duration for the limit and observe actions").Default("1m").Duration()
+	ruleLimitKey := setRuleCmd.Flag("limit-key", "request attribute the limit and observe actions count requests by instead of remote address, e.g. metadata.user_id or header.x-api-key").String()
+	ruleKeyTemplate := setRuleCmd.Flag("key-template", "template of the key the limit and observe actions count requests by, e.g. {ip}:{route}:{window}. Placeholders are {ip}, {key}, {route}, {window}, and request attributes such as {header.x-api-key}").String()
+	ruleSchedules := setRuleCmd.Flag("schedule", `limit of the limit and observe actions while a cron schedule is active, as count/duration@schedule, e.g. "10/1m@* 0-6 * * *". May be repeated, the first active schedule applies`).Strings()
+	ruleScheduleTimeZone := setRuleCmd.Flag("schedule-time-zone", "time zone schedules are evaluated in, UTC by default").String()
+	ruleBaselineMultiplier := setRuleCmd.Flag("baseline-multiplier", "raises the limit of the limit and observe actions for each key to this multiple of its average count per limit duration over --baseline-period, at least --limit-count. 0 disables the baseline").Default("0").Float64()
+	ruleBaselinePeriod := setRuleCmd.Flag("baseline-period", "period before the current window a key's average count is taken over, a whole number of limit durations").Default("1h").Duration()
+	ruleCooldownWindows := setRuleCmd.Flag("cooldown-windows", "keeps keys blocked by the limit action blocked until they've counted at most --cooldown-percent of the limit count for this many consecutive windows. 0 disables the cooldown").Default("0").Uint()
+	ruleCooldownPercent := setRuleCmd.Flag("cooldown-percent", "percent of the limit count a window may count and still be quiet for the cooldown").Default("50").Uint()
+	ruleNotify := setRuleCmd.Flag("notify", "post the keys the limit action throttles to the throttle webhook of guardian instances running with --throttle-webhook-url").Bool()
+	ruleDistinct := setRuleCmd.Flag("distinct", "request attribute, such as path, whose distinct values per key and window the limit and observe actions count instead of requests, approximately. Paths are counted without their query").String()
+	ruleResponseStatus := setRuleCmd.Flag("response-status", "status of the static response of the serve action, ignored by Envoy").Default("200").Int()
+	ruleResponseBody := setRuleCmd.Flag("response-body", "body of the static response of the serve action").String()
+	ruleChallengeURL := setRuleCmd.Flag("challenge-url", "url the challenge action redirects requests to, ignored by Envoy").String()
+	ruleLimitAlgorithm := setRuleCmd.Flag("limit-algorithm", "limit algorithm for the limit and observe actions, one of fixed_window, leaky_bucket, or day_buckets").Default(string(guardian.FixedWindowAlgorithm)).String()
+	rulePriority := setRuleCmd.Flag("priority", "priority between -1000 and 1000, rules with higher priorities are evaluated first").Default("0").Int()
+	ruleTags := setRuleCmd.Flag("tag", "datadog tag, e.g. team:payments, added to the metrics of requests matching the rule. May be repeated").Strings()
+	ruleMetadata := metadataFlags(setRuleCmd)
+
+	removeRuleCmd := app.Command("remove-rule", "Removes a rule")
+	removeRuleName := removeRuleCmd.Arg("name", "rule name").Required().String()
+
+	getRulesCmd := app.Command("get-rules", "Gets the rules")
+	getRulesOutput := outputFlags(getRulesCmd, true)
 
 	// Report Only
 	setReportOnlyCmd := app.Command("set-report-only", "Sets the report only flag")
 	reportOnly := setReportOnlyCmd.Arg("report-only", "report only enabled").Required().Bool()
 
 	getReportOnlyCmd := app.Command("get-report-only", "Gets the report only flag")
+	getReportOnlyOutput := outputFlags(getReportOnlyCmd, false)
+
+	// Schema
+	migrateCmd := app.Command("migrate", "Migrates the conf stored in Redis to the latest schema version")
+
+	// Signing
+	signConfCmd := app.Command("sign-conf", "Signs the conf stored in Redis with the signing key")
+	exportConfCmd := app.Command("export-conf", "Exports the conf stored in Redis as a JSON conf document")
+	getLimitRecommendationsCmd := app.Command("get-limit-recommendations", "Gets the limits recommended by a Guardian instance running with --limit-analysis-window, from its admin server")
+	getLimitRecommendationsOutput := outputFlags(getLimitRecommendationsCmd, true)
+	adminURL := getLimitRecommendationsCmd.Flag("admin-url", "url of the guardian admin server").Default("http://localhost:6060").OverrideDefaultFromEnvar("ADMIN_URL").String()
+	blockReportCmd := app.Command("block-report", "Reports the requests blocked per rule and for the most blocked keys, from the block stats recorded by Guardian instances running with --block-stats-interval")
+	blockReportDuration := blockReportCmd.Flag("duration", "Window of the report, ending now. At most 192h.").Default("24h").Duration()
+	blockReportTop := blockReportCmd.Flag("top", "Number of most blocked keys to report").Default("20").Int()
+	blockReportFormat := blockReportCmd.Flag("format", "Format of the report").Default("json").Enum("json", "csv")
+	blockReportOutput := blockReportCmd.Flag("output", "Path to write the report to, stdout if empty").Short('o').String()
+	applyCmd := app.Command("apply", "Applies a conf document to the conf stored in Redis, printing the changes made. Fields omitted from the document are left unchanged")
+	applyFile := applyCmd.Flag("file", "Path of the conf document to apply, YAML if it has a .yaml or .yml extension and JSON otherwise").Short('f').Required().String()
+	applyDryRun := applyCmd.Flag("dry-run", "Validate the conf document and print the changes it would make without applying them").Bool()
+	applyChunks := chunkFlags(applyCmd)
+
+	applyRouteLimitsCmd := app.Command("apply-route-limits", "Applies the route limits of a CSV manifest, with a header row naming its columns route, count, duration, enabled, algorithm, enforce_percent, rollover, description, owner, and ticket, in a single transaction unless --chunk-size is set, printing the changes made. Empty cells inherit the global limit")
+	applyRouteLimitsFile := applyRouteLimitsCmd.Flag("file", "Path of the manifest to apply").Short('f').Required().String()
+	applyRouteLimitsReplace := applyRouteLimitsCmd.Flag("replace", "Remove the route limits missing from the manifest").Bool()
+	applyRouteLimitsDryRun := applyRouteLimitsCmd.Flag("dry-run", "Validate the manifest and print the changes it would make without applying them").Bool()
+	applyRouteLimitsChunks := chunkFlags(applyRouteLimitsCmd)
+
+	// Policies
+	policyCmd := app.Command("policy", "Validates policies before they're applied")
+	policyTestCmd := policyCmd.Command("test", "Runs a suite of example requests against a conf document, exiting with an error if any is decided differently than expected. Requests are counted in memory, so Redis isn't needed")
+	policyTestFile := policyTestCmd.Flag("file", "Path of the conf document to test, YAML if it has a .yaml or .yml extension and JSON otherwise").Short('f').Required().String()
+	policyTestSuite := policyTestCmd.Flag("suite", "Path of the suite of example requests and their expected decisions, YAML if it has a .yaml or .yml extension and JSON otherwise").Short('s').Required().String()
+	policyTestOutput := outputFlags(policyTestCmd, true)
 
 	selectedCmd := kingpin.MustParse(app.Parse(os.Args[1:]))
+	// every command but policy test reads or writes the conf stored in Redis
+	if len(*redisAddress) == 0 && selectedCmd != policyTestCmd.FullCommand() {
+		app.Fatalf("required flag --redis-address not provided, try --help")
+	}
 	redisOpts := &redis.Options{Addr: *redisAddress}
+	redisConnOpts := guardian.RedisConnOptions{
+		Username:      *redisUsername,
+		Password:      *redisPassword,
+		TLS:           *redisTLS,
+		TLSCAFile:     *redisTLSCAFile,
+		TLSServerName: *redisTLSServerName,
+		TLSSkipVerify: *redisTLSSkipVerify,
+	}
+
+	if err := redisConnOpts.Apply(redisOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid redis connection options: %v\n", err)
+		os.Exit(1)
+	}
+
 	redis := redis.NewClient(redisOpts)
 	logger := logrus.StandardLogger()
-	redisConfStore := guardian.NewRedisConfStore(redis, []net.IPNet{}, []net.IPNet{}, guardian.Limit{}, false, logger)
+	redisConfStore := guardian.NewRedisConfStore(redis, []net.IPNet{}, []net.IPNet{}, guardian.Limit{}, false, nil, false, logger, guardian.NullReporter{})
 
 	level, err := logrus.ParseLevel(*logLevel)
 	if err != nil {
@@ -61,6 +237,38 @@ func main() {
 	}
 	logger.SetLevel(level)
 
+	var signingKey ed25519.PrivateKey
+	if len(*signingKeyFile) > 0 {
+		signingKey, err = guardian.LoadConfSigningKey(*signingKeyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading signing key: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// commands changing the conf, which must be signed again afterwards
+	confChangingCmds := map[string]bool{
+		addWhitelistCmd.FullCommand():          true,
+		removeWhitelistCmd.FullCommand():       true,
+		addWhitelistHostCmd.FullCommand():      true,
+		removeWhitelistHostCmd.FullCommand():   true,
+		addBlacklistCmd.FullCommand():          true,
+		removeBlacklistCmd.FullCommand():       true,
+		setLimitCmd.FullCommand():              true,
+		setRouteLimitCmd.FullCommand():         true,
+		removeRouteLimitCmd.FullCommand():      true,
+		setScopedLimitCmd.FullCommand():        true,
+		removeScopedLimitCmd.FullCommand():     true,
+		setLimitExperimentCmd.FullCommand():    true,
+		removeLimitExperimentCmd.FullCommand(): true,
+		setRuleCmd.FullCommand():               true,
+		removeRuleCmd.FullCommand():            true,
+		setReportOnlyCmd.FullCommand():         true,
+		migrateCmd.FullCommand():               true,
+		applyCmd.FullCommand():                 !*applyDryRun,
+		applyRouteLimitsCmd.FullCommand():      !*applyRouteLimitsDryRun,
+	}
+
 	switch selectedCmd {
 	case addWhitelistCmd.FullCommand():
 		err := addWhitelist(redisConfStore, *addCidrStrings, logger)
@@ -82,8 +290,30 @@ func main() {
 			os.Exit(1)
 		}
 
-		for _, cidr := range whitelist {
-			fmt.Println(cidr.String())
+		if err := getWhitelistOutput.print(os.Stdout, cidrListing(whitelist)); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing CIDRS: %v\n", err)
+			os.Exit(1)
+		}
+	case addWhitelistHostCmd.FullCommand():
+		if err := redisConfStore.AddWhitelistHosts(*addWhitelistHosts); err != nil {
+			fmt.Fprintf(os.Stderr, "error adding hosts: %v\n", err)
+			os.Exit(1)
+		}
+	case removeWhitelistHostCmd.FullCommand():
+		if err := redisConfStore.RemoveWhitelistHosts(*removeWhitelistHosts); err != nil {
+			fmt.Fprintf(os.Stderr, "error removing hosts: %v\n", err)
+			os.Exit(1)
+		}
+	case getWhitelistHostsCmd.FullCommand():
+		hosts, err := redisConfStore.FetchWhitelistHosts()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error listing hosts: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := getWhitelistHostsOutput.print(os.Stdout, hostListing(hosts)); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing hosts: %v\n", err)
+			os.Exit(1)
 		}
 	case addBlacklistCmd.FullCommand():
 		err := addBlacklist(redisConfStore, *addBlacklistCidrStrings, logger)
@@ -105,12 +335,75 @@ func main() {
 			os.Exit(1)
 		}
 
-		for _, cidr := range blacklist {
-			fmt.Println(cidr.String())
+		if err := getBlacklistOutput.print(os.Stdout, cidrListing(blacklist)); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing CIDRS: %v\n", err)
+			os.Exit(1)
+		}
+	case checkConflictsCmd.FullCommand():
+		conflicts, err := redisConfStore.FetchCIDRConflicts()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error checking conflicts: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := checkConflictsOutput.print(os.Stdout, conflictListing(conflicts)); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing conflicts: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(conflicts) > 0 {
+			os.Exit(1)
+		}
+	case checkIPCmd.FullCommand():
+		matches, err := redisConfStore.FetchIPListMatches(*checkIP)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error checking ip: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := checkIPOutput.print(os.Stdout, ipMatchListing(*checkIP, matches)); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing matches: %v\n", err)
+			os.Exit(1)
 		}
 	case setLimitCmd.FullCommand():
-		limit := guardian.Limit{Count: *limitCount, Duration: *limitDuration, Enabled: *limitEnabled}
-		err := setLimit(redisConfStore, limit)
+		algorithm, err := guardian.ParseAlgorithm(*limitAlgorithm)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing algorithm: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := guardian.ValidateEnforcePercent(*limitEnforcePercent); err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing enforce percent: %v\n", err)
+			os.Exit(1)
+		}
+
+		limit := guardian.Limit{
+			Count:          *limitCount,
+			Duration:       *limitDuration,
+			Enabled:        *limitEnabled,
+			Algorithm:      algorithm,
+			EnforcePercent: *limitEnforcePercent,
+			Calendar:       guardian.CalendarWindow(*limitCalendar),
+			TimeZone:       *limitTimeZone,
+			Rollover:       *limitRollover,
+		}
+
+		if err := guardian.ValidateCalendar(limit); err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing calendar: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := guardian.ValidateDayBuckets(limit); err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing duration: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := guardian.ValidateRollover(limit); err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing rollover: %v\n", err)
+			os.Exit(1)
+		}
+
+		err = setLimit(redisConfStore, limit)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error setting limit: %v\n", err)
 			os.Exit(1)
@@ -121,7 +414,181 @@ func main() {
 			fmt.Fprintf(os.Stderr, "error getting limit: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("%v\n", limit)
+		if err := getLimitOutput.print(os.Stdout, limitListing(limit)); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing limit: %v\n", err)
+			os.Exit(1)
+		}
+	case setRouteLimitCmd.FullCommand():
+		algorithm, err := guardian.ParseAlgorithm(*routeLimitAlgorithm)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing algorithm: %v\n", err)
+			os.Exit(1)
+		}
+
+		limit := guardian.Limit{
+			Count:     *routeLimitCount,
+			Duration:  *routeLimitDuration,
+			Enabled:   *routeLimitEnabled,
+			Algorithm: algorithm,
+			Calendar:  guardian.CalendarWindow(*routeLimitCalendar),
+			TimeZone:  *routeLimitTimeZone,
+			Rollover:  *routeLimitRollover,
+		}
+
+		if err := guardian.ValidateCalendar(limit); err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing calendar: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := guardian.ValidateDayBuckets(limit); err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing duration: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := guardian.ValidateRollover(limit); err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing rollover: %v\n", err)
+			os.Exit(1)
+		}
+
+		override := guardian.LimitOverrideFromLimit(limit)
+		override.Metadata = *routeLimitMetadata
+		err = setRouteLimit(redisConfStore, *routeLimitRoute, override)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error setting route limit: %v\n", err)
+			os.Exit(1)
+		}
+	case removeRouteLimitCmd.FullCommand():
+		err := removeRouteLimit(redisConfStore, *removeRouteLimitRoute)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error removing route limit: %v\n", err)
+			os.Exit(1)
+		}
+	case getRouteLimitsCmd.FullCommand():
+		routeLimits, err := getRouteLimits(redisConfStore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error getting route limits: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := getRouteLimitsOutput.print(os.Stdout, routeLimitListing(routeLimits)); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing route limits: %v\n", err)
+			os.Exit(1)
+		}
+	case setScopedLimitCmd.FullCommand():
+		doc, err := limitOverrideDocument(*scopedLimitCount, *scopedLimitDuration, *scopedLimitEnabled, *scopedLimitAlgorithm, *scopedLimitEnforcePercent, *scopedLimitCalendar, *scopedLimitTimeZone, *scopedLimitRollover)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing limit: %v\n", err)
+			os.Exit(1)
+		}
+
+		doc.Metadata = *scopedLimitMetadata
+		err = setScopedLimit(redisConfStore, *scopedLimitScope, *scopedLimitName, doc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error setting scoped limit: %v\n", err)
+			os.Exit(1)
+		}
+	case removeScopedLimitCmd.FullCommand():
+		err := removeScopedLimit(redisConfStore, *removeScopedLimitScope, *removeScopedLimitName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error removing scoped limit: %v\n", err)
+			os.Exit(1)
+		}
+	case getScopedLimitsCmd.FullCommand():
+		limits, err := getScopedLimits(redisConfStore, *getScopedLimitsScope)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error getting scoped limits: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := getScopedLimitsOutput.print(os.Stdout, scopedLimitListing(limits)); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing scoped limits: %v\n", err)
+			os.Exit(1)
+		}
+	case setLimitExperimentCmd.FullCommand():
+		doc, err := limitOverrideDocument(*limitExperimentCount, *limitExperimentDuration, "", *limitExperimentAlgorithm, "", *limitExperimentCalendar, *limitExperimentTimeZone, *limitExperimentRollover)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing limit: %v\n", err)
+			os.Exit(1)
+		}
+
+		doc.Metadata = *limitExperimentMetadata
+		err = setLimitExperiment(redisConfStore, guardian.LimitExperimentDocument{Percent: *limitExperimentPercent, Limit: doc})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error setting limit experiment: %v\n", err)
+			os.Exit(1)
+		}
+	case removeLimitExperimentCmd.FullCommand():
+		err := removeLimitExperiment(redisConfStore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error removing limit experiment: %v\n", err)
+			os.Exit(1)
+		}
+	case getLimitExperimentCmd.FullCommand():
+		experiment, err := getLimitExperiment(redisConfStore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error getting limit experiment: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := getLimitExperimentOutput.print(os.Stdout, limitExperimentListing(experiment)); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing limit experiment: %v\n", err)
+			os.Exit(1)
+		}
+	case setRuleCmd.FullCommand():
+		doc := guardian.RuleDocument{When: *ruleWhen, Action: *ruleAction, Priority: *rulePriority, Tags: *ruleTags, Metadata: *ruleMetadata}
+		if action := guardian.RuleAction(*ruleAction); action == guardian.LimitAction || action == guardian.ObserveAction {
+			doc.Limit = &guardian.LimitDocument{Count: *ruleLimitCount, Duration: ruleLimitDuration.String(), Enabled: true, Algorithm: *ruleLimitAlgorithm}
+			doc.LimitKey = *ruleLimitKey
+			doc.KeyTemplate = *ruleKeyTemplate
+			for _, s := range *ruleSchedules {
+				sd, err := parseScheduleFlag(s, *ruleScheduleTimeZone, *ruleLimitAlgorithm)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error parsing schedule: %v\n", err)
+					os.Exit(1)
+				}
+				doc.Schedules = append(doc.Schedules, sd)
+			}
+			if *ruleBaselineMultiplier != 0 {
+				doc.Baseline = &guardian.BaselineDocument{Multiplier: *ruleBaselineMultiplier, Period: ruleBaselinePeriod.String()}
+			}
+			if *ruleCooldownWindows != 0 {
+				doc.Cooldown = &guardian.Cooldown{Percent: *ruleCooldownPercent, Windows: *ruleCooldownWindows}
+			}
+			doc.Distinct = *ruleDistinct
+			doc.Notify = *ruleNotify
+		}
+
+		if guardian.RuleAction(*ruleAction) == guardian.ServeAction {
+			doc.Response = &guardian.StaticResponse{Status: *ruleResponseStatus, Body: *ruleResponseBody}
+		}
+
+		if guardian.RuleAction(*ruleAction) == guardian.ChallengeAction {
+			doc.ChallengeURL = *ruleChallengeURL
+		}
+
+		err := setRule(redisConfStore, *ruleName, doc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error setting rule: %v\n", err)
+			os.Exit(1)
+		}
+		warnStaticResponse(*ruleName, guardian.RuleAction(*ruleAction))
+	case removeRuleCmd.FullCommand():
+		err := removeRule(redisConfStore, *removeRuleName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error removing rule: %v\n", err)
+			os.Exit(1)
+		}
+	case getRulesCmd.FullCommand():
+		rules, err := getRules(redisConfStore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error getting rules: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := getRulesOutput.print(os.Stdout, ruleListing(rules)); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing rules: %v\n", err)
+			os.Exit(1)
+		}
 	case setReportOnlyCmd.FullCommand():
 		err := setReportOnly(redisConfStore, *reportOnly)
 		if err != nil {
@@ -134,7 +601,111 @@ func main() {
 			fmt.Fprintf(os.Stderr, "error getting report only flag: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println(reportOnly)
+		if err := getReportOnlyOutput.print(os.Stdout, listing{value: reportOnly, text: strconv.FormatBool(reportOnly)}); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing report only flag: %v\n", err)
+			os.Exit(1)
+		}
+	case migrateCmd.FullCommand():
+		from, to, err := migrate(redisConfStore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error migrating schema: %v\n", err)
+			os.Exit(1)
+		}
+
+		if from == to {
+			fmt.Printf("schema already at version %d\n", to)
+		} else {
+			fmt.Printf("migrated schema from version %d to %d\n", from, to)
+		}
+	case signConfCmd.FullCommand():
+		if signingKey == nil {
+			fmt.Fprintf(os.Stderr, "signing the conf requires --signing-key-file\n")
+			os.Exit(1)
+		}
+	case exportConfCmd.FullCommand():
+		doc, err := exportConf(redisConfStore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error exporting conf: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(doc))
+	case getLimitRecommendationsCmd.FullCommand():
+		recommendations, err := getLimitRecommendations(*adminURL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error getting limit recommendations: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := getLimitRecommendationsOutput.print(os.Stdout, recommendationListing(recommendations)); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing limit recommendations: %v\n", err)
+			os.Exit(1)
+		}
+	case blockReportCmd.FullCommand():
+		if err := blockReport(redis, *blockReportDuration, *blockReportTop, *blockReportFormat, *blockReportOutput); err != nil {
+			fmt.Fprintf(os.Stderr, "error generating block report: %v\n", err)
+			os.Exit(1)
+		}
+	case applyCmd.FullCommand():
+		changes, err := apply(redisConfStore, *applyFile, *applyDryRun, *applyChunks)
+		if err != nil {
+			if len(changes) > 0 {
+				fmt.Fprintf(os.Stderr, "applied %d changes before the error, apply it again to resume\n", len(changes))
+			}
+			fmt.Fprintf(os.Stderr, "error applying conf: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(changes) == 0 {
+			fmt.Println("no changes")
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+	case applyRouteLimitsCmd.FullCommand():
+		changes, err := applyRouteLimits(redisConfStore, *applyRouteLimitsFile, *applyRouteLimitsReplace, *applyRouteLimitsDryRun, *applyRouteLimitsChunks)
+		if err != nil {
+			if len(changes) > 0 {
+				fmt.Fprintf(os.Stderr, "applied %d changes before the error, apply them again to resume\n", len(changes))
+			}
+			fmt.Fprintf(os.Stderr, "error applying route limits: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(changes) == 0 {
+			fmt.Println("no changes")
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+	case policyTestCmd.FullCommand():
+		results, err := testPolicy(*policyTestFile, *policyTestSuite)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error testing policy: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := policyTestOutput.print(os.Stdout, policyResultListing(results)); err != nil {
+			fmt.Fprintf(os.Stderr, "error printing policy results: %v\n", err)
+			os.Exit(1)
+		}
+
+		failed := 0
+		for _, result := range results {
+			if !result.Passed {
+				failed++
+			}
+		}
+		if failed > 0 {
+			fmt.Fprintf(os.Stderr, "%d of %d policy cases failed\n", failed, len(results))
+			os.Exit(1)
+		}
+	}
+
+	if signingKey != nil && (confChangingCmds[selectedCmd] || selectedCmd == signConfCmd.FullCommand()) {
+		if err := signConf(redisConfStore, signingKey); err != nil {
+			fmt.Fprintf(os.Stderr, "error signing conf: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 }
@@ -154,6 +725,10 @@ func addWhitelist(store *guardian.RedisConfStore, cidrStrings []string, logger l
 	}
 	logger.Debugf("Added CIDRs to Redis")
 
+	if blacklist, err := store.FetchBlacklist(); err == nil {
+		warnCIDRConflicts(guardian.FindCIDRConflicts(cidrs, blacklist))
+	}
+
 	return nil
 }
 
@@ -201,6 +776,10 @@ func addBlacklist(store *guardian.RedisConfStore, cidrStrings []string, logger l
 	}
 	logger.Debugf("Added CIDRs to Redis")
 
+	if whitelist, err := store.FetchWhitelist(); err == nil {
+		warnCIDRConflicts(guardian.FindCIDRConflicts(whitelist, cidrs))
+	}
+
 	return nil
 }
 
@@ -233,6 +812,21 @@ func getBlacklist(store *guardian.RedisConfStore, logger logrus.FieldLogger) ([]
 	return blacklist, nil
 }
 
+// warnCIDRConflicts warns of conflicts between the whitelist and blacklist, which are resolved by whitelisting
+func warnCIDRConflicts(conflicts []guardian.CIDRConflict) {
+	for _, conflict := range conflicts {
+		fmt.Fprintf(os.Stderr, "warning: %v, requests from the overlap are whitelisted\n", conflict)
+	}
+}
+
+// warnStaticResponse warns that a rule answering requests with a static response is rejected by instances that
+// aren't run with --static-responses, such as those behind Envoy
+func warnStaticResponse(name string, action guardian.RuleAction) {
+	if action.StaticResponse() {
+		fmt.Fprintf(os.Stderr, "warning: %v rule %v answers requests with a static response, which Envoy ignores. Instances not run with --static-responses reject the conf\n", action, name)
+	}
+}
+
 func convertCIDRStrings(cidrStrings []string) ([]net.IPNet, error) {
 	cidrs := []net.IPNet{}
 	for _, cidrString := range cidrStrings {
@@ -255,6 +849,334 @@ func getLimit(store *guardian.RedisConfStore) (guardian.Limit, error) {
 	return store.FetchLimit()
 }
 
+func setRouteLimit(store *guardian.RedisConfStore, template string, limit guardian.LimitOverride) error {
+	route, err := guardian.ParseRoutePattern(template)
+	if err != nil {
+		return errors.Wrap(err, "error parsing route")
+	}
+
+	if limit.Duration != nil && *limit.Duration < time.Second {
+		return fmt.Errorf("limit duration %v must be at least 1s", *limit.Duration)
+	}
+
+	return store.SetRouteLimit(route, limit)
+}
+
+func removeRouteLimit(store *guardian.RedisConfStore, template string) error {
+	route, err := guardian.ParseRoutePattern(template)
+	if err != nil {
+		return errors.Wrap(err, "error parsing route")
+	}
+
+	return store.RemoveRouteLimit(route)
+}
+
+func getRouteLimits(store *guardian.RedisConfStore) ([]guardian.RouteLimit, error) {
+	return store.FetchRouteLimits()
+}
+
+// limitOverrideDocument builds a limit override from flags, leaving those that are empty to be inherited
+func limitOverrideDocument(count string, duration string, enabled string, algorithm string, enforcePercent string, calendar string, timeZone string, rollover string) (guardian.LimitOverrideDocument, error) {
+	doc := guardian.LimitOverrideDocument{Duration: duration, Algorithm: algorithm, TimeZone: timeZone}
+	if len(count) > 0 {
+		c, err := strconv.ParseUint(count, 10, 64)
+		if err != nil {
+			return guardian.LimitOverrideDocument{}, errors.Wrap(err, "error parsing count")
+		}
+		doc.Count = &c
+	}
+
+	if len(enabled) > 0 {
+		e := enabled == "true"
+		doc.Enabled = &e
+	}
+
+	if len(enforcePercent) > 0 {
+		p, err := strconv.ParseUint(enforcePercent, 10, 32)
+		if err != nil {
+			return guardian.LimitOverrideDocument{}, errors.Wrap(err, "error parsing enforce percent")
+		}
+		percent := uint(p)
+		doc.EnforcePercent = &percent
+	}
+
+	if len(calendar) > 0 {
+		doc.Calendar = &calendar
+	}
+
+	if len(rollover) > 0 {
+		r, err := strconv.ParseUint(rollover, 10, 64)
+		if err != nil {
+			return guardian.LimitOverrideDocument{}, errors.Wrap(err, "error parsing rollover")
+		}
+		doc.Rollover = &r
+	}
+
+	return doc, nil
+}
+
+func setScopedLimit(store *guardian.RedisConfStore, scope string, name string, doc guardian.LimitOverrideDocument) error {
+	limit, err := doc.Override()
+	if err != nil {
+		return errors.Wrap(err, "error parsing limit")
+	}
+
+	return store.SetScopedLimit(guardian.LimitScope(scope), name, limit)
+}
+
+func removeScopedLimit(store *guardian.RedisConfStore, scope string, name string) error {
+	return store.RemoveScopedLimit(guardian.LimitScope(scope), name)
+}
+
+func getScopedLimits(store *guardian.RedisConfStore, scope string) (map[string]guardian.LimitOverride, error) {
+	return store.FetchScopedLimits(guardian.LimitScope(scope))
+}
+
+func setLimitExperiment(store *guardian.RedisConfStore, doc guardian.LimitExperimentDocument) error {
+	experiment, err := doc.Experiment()
+	if err != nil {
+		return errors.Wrap(err, "error parsing limit experiment")
+	}
+
+	return store.SetLimitExperiment(experiment)
+}
+
+func removeLimitExperiment(store *guardian.RedisConfStore) error {
+	return store.RemoveLimitExperiment()
+}
+
+func getLimitExperiment(store *guardian.RedisConfStore) (guardian.LimitExperiment, error) {
+	return store.FetchLimitExperiment()
+}
+
+func setRule(store *guardian.RedisConfStore, name string, doc guardian.RuleDocument) error {
+	rule, err := doc.Rule(name)
+	if err != nil {
+		return errors.Wrap(err, "error parsing rule")
+	}
+
+	return store.SetRule(rule)
+}
+
+// parseScheduleFlag parses a schedule of the form count/duration@schedule
+func parseScheduleFlag(s string, timeZone string, algorithm string) (guardian.ScheduleDocument, error) {
+	parts := strings.SplitN(s, "@", 2)
+	if len(parts) != 2 {
+		return guardian.ScheduleDocument{}, fmt.Errorf("schedule %q must be of the form count/duration@schedule", s)
+	}
+
+	limitParts := strings.SplitN(parts[0], "/", 2)
+	if len(limitParts) != 2 {
+		return guardian.ScheduleDocument{}, fmt.Errorf("schedule limit %q must be of the form count/duration", parts[0])
+	}
+
+	count, err := strconv.ParseUint(limitParts[0], 10, 64)
+	if err != nil {
+		return guardian.ScheduleDocument{}, errors.Wrap(err, "error parsing schedule limit count")
+	}
+
+	limit := guardian.LimitDocument{Count: count, Duration: limitParts[1], Enabled: true, Algorithm: algorithm}
+	return guardian.ScheduleDocument{Cron: parts[1], TimeZone: timeZone, Limit: limit}, nil
+}
+
+// metadataFlags adds the flags documenting a limit or rule to cmd
+func metadataFlags(cmd *kingpin.CmdClause) *guardian.Metadata {
+	m := &guardian.Metadata{}
+	cmd.Flag("description", "why the limit or rule exists").StringVar(&m.Description)
+	cmd.Flag("owner", "person or team responsible for the limit or rule").StringVar(&m.Owner)
+	cmd.Flag("ticket", "link to the ticket or discussion the limit or rule was added in").StringVar(&m.Ticket)
+	return m
+}
+
+// metadataColumn returns the metadata to print in a table column, or nothing if it has none
+func metadataColumn(m guardian.Metadata) string {
+	if m.Empty() {
+		return ""
+	}
+
+	return m.String()
+}
+
+func cidrListing(cidrs []net.IPNet) listing {
+	l := listing{header: []string{"CIDR"}}
+	for _, cidr := range cidrs {
+		l.ids = append(l.ids, cidr.String())
+		l.rows = append(l.rows, []string{cidr.String()})
+	}
+	l.value = append([]string{}, l.ids...)
+	return l
+}
+
+func hostListing(hosts []string) listing {
+	l := listing{value: append([]string{}, hosts...), ids: hosts, header: []string{"HOST"}}
+	for _, host := range hosts {
+		l.rows = append(l.rows, []string{host})
+	}
+	return l
+}
+
+// conflictListing lists conflicts without ids, so check-conflicts --quiet only exits with an error if there are any
+func conflictListing(conflicts []guardian.CIDRConflict) listing {
+	type conflictDocument struct {
+		Whitelisted string `json:"whitelisted"`
+		Blacklisted string `json:"blacklisted"`
+	}
+
+	docs := []conflictDocument{}
+	l := listing{header: []string{"WHITELISTED", "BLACKLISTED"}}
+	for _, conflict := range conflicts {
+		docs = append(docs, conflictDocument{Whitelisted: conflict.Whitelisted.String(), Blacklisted: conflict.Blacklisted.String()})
+		l.rows = append(l.rows, []string{conflict.Whitelisted.String(), conflict.Blacklisted.String()})
+	}
+	l.value = docs
+	return l
+}
+
+// ipMatchListing lists the entries containing ip, or says it's on neither list if there are none
+func ipMatchListing(ip string, matches []guardian.IPListMatch) listing {
+	type matchDocument struct {
+		List string `json:"list"`
+		CIDR string `json:"cidr"`
+	}
+
+	type checkDocument struct {
+		IP          string          `json:"ip"`
+		Whitelisted bool            `json:"whitelisted"`
+		Blacklisted bool            `json:"blacklisted"`
+		Matches     []matchDocument `json:"matches"`
+	}
+
+	doc := checkDocument{IP: ip, Matches: []matchDocument{}}
+	l := listing{value: &doc, text: fmt.Sprintf("%v is neither whitelisted nor blacklisted", ip)}
+	for _, match := range matches {
+		doc.Whitelisted = doc.Whitelisted || match.List == guardian.WhitelistIPList
+		doc.Blacklisted = doc.Blacklisted || match.List == guardian.BlacklistIPList
+		doc.Matches = append(doc.Matches, matchDocument{List: string(match.List), CIDR: match.CIDR.String()})
+		l.ids = append(l.ids, match.CIDR.String())
+		l.rows = append(l.rows, []string{string(match.List), match.CIDR.String()})
+	}
+
+	if len(matches) > 0 {
+		l.header = []string{"LIST", "CIDR"}
+	}
+
+	return l
+}
+
+func limitListing(limit guardian.Limit) listing {
+	return listing{value: guardian.LimitDocumentFromLimit(limit), text: limit.String()}
+}
+
+// routeLimitListing lists route limits in the order they're matched, and as route_limits of a conf document for
+// the json and yaml formats
+func routeLimitListing(routeLimits []guardian.RouteLimit) listing {
+	docs := map[string]guardian.LimitOverrideDocument{}
+	l := listing{value: docs, header: []string{"ROUTE", "LIMIT", "METADATA"}}
+	for _, routeLimit := range routeLimits {
+		route := routeLimit.Route.String()
+		docs[route] = guardian.LimitOverrideDocumentFromOverride(routeLimit.Limit)
+		l.ids = append(l.ids, route)
+		l.rows = append(l.rows, []string{route, routeLimit.Limit.String(), metadataColumn(routeLimit.Limit.Metadata)})
+	}
+	return l
+}
+
+func scopedLimitListing(limits map[string]guardian.LimitOverride) listing {
+	names := []string{}
+	for name := range limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	docs := map[string]guardian.LimitOverrideDocument{}
+	l := listing{value: docs, ids: names, header: []string{"NAME", "LIMIT", "METADATA"}}
+	for _, name := range names {
+		docs[name] = guardian.LimitOverrideDocumentFromOverride(limits[name])
+		l.rows = append(l.rows, []string{name, limits[name].String(), metadataColumn(limits[name].Metadata)})
+	}
+	return l
+}
+
+// limitExperimentListing prints null for the json and yaml formats when no experiment is running
+func limitExperimentListing(experiment guardian.LimitExperiment) listing {
+	if !experiment.Enabled() {
+		return listing{value: nil, text: "no limit experiment"}
+	}
+
+	doc := guardian.LimitExperimentDocumentFromExperiment(experiment)
+	text := experiment.String()
+	if metadata := metadataColumn(experiment.Limit.Metadata); len(metadata) > 0 {
+		text += " " + metadata
+	}
+	return listing{value: doc, text: text}
+}
+
+// ruleListing lists rules in the order they're evaluated, and as rules of a conf document for the json and yaml
+// formats
+func ruleListing(rules []guardian.Rule) listing {
+	docs := map[string]guardian.RuleDocument{}
+	l := listing{value: docs, header: []string{"NAME", "PRIORITY", "ACTION", "WHEN", "LIMIT", "TAGS", "METADATA"}}
+	for _, rule := range rules {
+		docs[rule.Name] = guardian.RuleDocumentFromRule(rule)
+		l.ids = append(l.ids, rule.Name)
+
+		limit := ""
+		if rule.Action == guardian.LimitAction || rule.Action == guardian.ObserveAction {
+			limit = rule.Limit.String()
+			if rule.Baseline != nil {
+				limit += " raised to " + rule.Baseline.String()
+			}
+			if rule.Cooldown != nil {
+				limit += " with a " + rule.Cooldown.String()
+			}
+			if len(rule.Distinct) > 0 {
+				limit += " distinct " + rule.Distinct + " values"
+			}
+			if rule.Notify {
+				limit += ", notifying throttled keys"
+			}
+		}
+		if rule.Action == guardian.ServeAction {
+			limit = fmt.Sprintf("status %d body %q", rule.Response.Status, rule.Response.Body)
+		}
+		if rule.Action == guardian.ChallengeAction {
+			limit = "redirect to " + rule.ChallengeURL
+		}
+		l.rows = append(l.rows, []string{rule.Name, strconv.Itoa(rule.Priority), string(rule.Action), rule.When.String(), limit, strings.Join(rule.Tags, ","), metadataColumn(rule.Metadata)})
+	}
+	return l
+}
+
+func recommendationListing(recommendations []guardian.LimitRecommendation) listing {
+	l := listing{value: append([]guardian.LimitRecommendation{}, recommendations...), header: []string{"ROUTE", "COUNT", "DURATION", "P99.9", "MAX", "SAMPLES"}}
+	for _, r := range recommendations {
+		l.ids = append(l.ids, r.Route)
+		l.rows = append(l.rows, []string{r.Route, strconv.FormatUint(r.Count, 10), r.Duration, strconv.FormatUint(r.P999, 10), strconv.FormatUint(r.Max, 10), strconv.FormatUint(r.Samples, 10)})
+	}
+	return l
+}
+
+func policyResultListing(results []guardian.PolicyResult) listing {
+	l := listing{value: append([]guardian.PolicyResult{}, results...), header: []string{"CASE", "RESULT", "DECISION", "REASON", "RULE", "FAILURE"}}
+	for _, r := range results {
+		result := "PASS"
+		if !r.Passed {
+			result = "FAIL"
+		}
+		l.ids = append(l.ids, r.Case)
+		l.rows = append(l.rows, []string{r.Case, result, string(r.Decision), r.Reason, r.Rule, r.Failure})
+	}
+	return l
+}
+
+func removeRule(store *guardian.RedisConfStore, name string) error {
+	return store.RemoveRule(name)
+}
+
+func getRules(store *guardian.RedisConfStore) ([]guardian.Rule, error) {
+	return store.FetchRules()
+}
+
 func setReportOnly(store *guardian.RedisConfStore, reportOnly bool) error {
 	return store.SetReportOnly(reportOnly)
 }
@@ -262,3 +1184,145 @@ func setReportOnly(store *guardian.RedisConfStore, reportOnly bool) error {
 func getReportOnly(store *guardian.RedisConfStore) (bool, error) {
 	return store.FetchReportOnly()
 }
+
+func migrate(store *guardian.RedisConfStore) (int, int, error) {
+	return store.Migrate()
+}
+
+func signConf(store *guardian.RedisConfStore, key ed25519.PrivateKey) error {
+	return store.SignConf(key)
+}
+
+func exportConf(store *guardian.RedisConfStore) ([]byte, error) {
+	doc, err := store.ExportConfDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func getLimitRecommendations(adminURL string) ([]guardian.LimitRecommendation, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(adminURL, "/") + "/v1/limit-recommendations")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin server responded with status %v", resp.Status)
+	}
+
+	body := struct {
+		Recommendations []guardian.LimitRecommendation `json:"recommendations"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, errors.Wrap(err, "error decoding limit recommendations")
+	}
+
+	return body.Recommendations, nil
+}
+
+func blockReport(client *redis.Client, duration time.Duration, top int, format string, output string) error {
+	report, err := guardian.FetchBlockReport(client, time.Now(), duration, top)
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if len(output) > 0 {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if format == "csv" {
+		return report.WriteCSV(w)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// chunkFlags adds the flags pacing the changes of an apply command to cmd, which print their progress to stderr
+func chunkFlags(cmd *kingpin.CmdClause) *guardian.ConfChunks {
+	c := &guardian.ConfChunks{}
+	cmd.Flag("chunk-size", "Apply the changes in transactions of this many changes rather than all at once, printing progress to stderr. Instances may sync a partially applied conf between chunks. 0 applies them in a single transaction").Default("0").IntVar(&c.Size)
+	cmd.Flag("chunk-interval", "Pause between chunks, bounding the rate changes are written to Redis at").Default("0").DurationVar(&c.Interval)
+	c.Progress = func(applied int, total int) {
+		if c.Size > 0 {
+			fmt.Fprintf(os.Stderr, "applied %d of %d changes\n", applied, total)
+		}
+	}
+	return c
+}
+
+func applyRouteLimits(store *guardian.RedisConfStore, path string, replace bool, dryRun bool, chunks guardian.ConfChunks) ([]guardian.ConfChange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	limits, err := guardian.ParseRouteLimitManifest(f)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error parsing manifest %v", path))
+	}
+
+	if !dryRun {
+		return store.ApplyRouteLimitsInChunks(limits, replace, chunks)
+	}
+
+	live, err := store.ExportConfDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	return guardian.DiffRouteLimits(live, limits, replace), nil
+}
+
+func apply(store *guardian.RedisConfStore, path string, dryRun bool, chunks guardian.ConfChunks) ([]guardian.ConfChange, error) {
+	doc, err := guardian.LoadConfDocument(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range doc.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		warnStaticResponse(name, guardian.RuleAction(doc.Rules[name].Action))
+	}
+
+	if !dryRun {
+		return store.ApplyConfDocumentInChunks(doc, chunks)
+	}
+
+	live, err := store.ExportConfDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	return guardian.DiffConfDocuments(live, doc), nil
+}
+
+func testPolicy(path string, suitePath string) ([]guardian.PolicyResult, error) {
+	doc, err := guardian.LoadConfDocument(path)
+	if err != nil {
+		return nil, err
+	}
+
+	suite, err := guardian.LoadPolicySuite(suitePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return guardian.RunPolicySuite(doc, suite)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	tableOutput = "table"
+	jsonOutput  = "json"
+	yamlOutput  = "yaml"
+)
+
+// outputOptions select how a get command prints what it gets
+type outputOptions struct {
+	format string
+	quiet  bool
+}
+
+// outputFlags adds the flags selecting the output format to cmd, and --quiet to commands listing several items
+func outputFlags(cmd *kingpin.CmdClause, list bool) *outputOptions {
+	o := &outputOptions{}
+	cmd.Flag("output", "output format, one of table, json, or yaml").Short('o').Default(tableOutput).EnumVar(&o.format, tableOutput, jsonOutput, yamlOutput)
+	if list {
+		cmd.Flag("quiet", "print only the identifiers of the items listed, one per line").Short('q').BoolVar(&o.quiet)
+	}
+	return o
+}
+
+// listing is what a get command prints. Value is printed by the json and yaml formats, and the header and rows by
+// the table format, or text for commands getting a single value. Quiet prints only ids.
+type listing struct {
+	value  interface{}
+	ids    []string
+	header []string
+	rows   [][]string
+	text   string
+}
+
+// print writes l to w in the selected format
+func (o *outputOptions) print(w io.Writer, l listing) error {
+	if o.quiet {
+		for _, id := range l.ids {
+			if _, err := fmt.Fprintln(w, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch o.format {
+	case jsonOutput:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(l.value)
+	case yamlOutput:
+		b, err := marshalYAML(l.value)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+
+	if l.header == nil {
+		_, err := fmt.Fprintln(w, l.text)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(l.header, "\t"))
+	for _, row := range l.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// marshalYAML encodes v as YAML. It's encoded as JSON first, so json struct tags name the fields and omitempty
+// applies, and then rewritten in YAML's block style.
+func marshalYAML(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if inline, ok := yamlInline(decoded); ok {
+		buf.WriteString(inline + "\n")
+		return buf.Bytes(), nil
+	}
+
+	for _, line := range yamlLines(decoded) {
+		buf.WriteString(line + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlLines returns the lines of a non-empty map or slice in block style, without indentation
+func yamlLines(v interface{}) []string {
+	lines := []string{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if inline, ok := yamlInline(v[k]); ok {
+				lines = append(lines, fmt.Sprintf("%v: %v", yamlString(k), inline))
+				continue
+			}
+
+			lines = append(lines, yamlString(k)+":")
+			for _, line := range yamlLines(v[k]) {
+				lines = append(lines, "  "+line)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if inline, ok := yamlInline(item); ok {
+				lines = append(lines, "- "+inline)
+				continue
+			}
+
+			for i, line := range yamlLines(item) {
+				if i == 0 {
+					lines = append(lines, "- "+line)
+				} else {
+					lines = append(lines, "  "+line)
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// yamlInline returns v encoded on a single line, if it's a scalar or an empty map or slice
+func yamlInline(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "null", true
+	case bool:
+		return strconv.FormatBool(v), true
+	case json.Number:
+		return v.String(), true
+	case string:
+		return yamlString(v), true
+	case map[string]interface{}:
+		return "{}", len(v) == 0
+	case []interface{}:
+		return "[]", len(v) == 0
+	}
+	return "", false
+}
+
+// yamlPlainString matches the strings YAML reads back as strings when unquoted, other than the keywords yamlString
+// quotes. They can't start with a digit, nor with a dot followed by a digit or underscore, as in .5 or ._1, which are
+// numbers.
+var yamlPlainString = regexp.MustCompile(`^([A-Za-z_/]|\.($|[A-Za-z/.-]))[A-Za-z0-9_/.-]*$`)
+
+// yamlString returns s unquoted if YAML would read it back as the same string, or double quoted otherwise
+func yamlString(s string) string {
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n", "null", "~", ".inf", ".nan":
+		return strconv.Quote(s)
+	}
+
+	if yamlPlainString.MatchString(s) {
+		return s
+	}
+	return strconv.Quote(s)
+}
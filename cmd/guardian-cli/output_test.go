@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMarshalYAMLRoundTrips(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{name: "Scalars", value: map[string]interface{}{"count": 10, "ratio": 0.5, "enabled": true, "reason": nil, "name": "scrapers"}},
+		{name: "NumericStrings", value: []string{".5", "._1", "1.5", "10", "-1", "+1", "1e3", "0x1f", ".inf", "-.inf", ".NaN", "1:30"}},
+		{name: "KeywordStrings", value: []string{"true", "False", "yes", "No", "on", "OFF", "y", "N", "null", "~", ""}},
+		{name: "PlainStrings", value: []string{"scrapers", "/admin", ".", ".com", "./conf", "rate_limit", "x-api-key"}},
+		{name: "SpecialStrings", value: []string{"a: b", "a #b", "#b", "- a", "[a]", "{a}", "'a'", `"a"`, " a", "a\nb", "é", "*a", "&a", "!a", "%a", "@a", "|", ">"}},
+		{name: "Keys", value: map[string]string{".5": "a", "true": "b", "a: b": "c", "": "d", "route": "/login"}},
+		{name: "Nested", value: map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{"name": "posts", "limit": map[string]interface{}{"count": 3, "duration": "1m"}, "tags": []string{"a", ".5"}},
+				[]interface{}{"nested", []interface{}{}},
+				map[string]interface{}{},
+			},
+			"empty": map[string]interface{}{},
+			"none":  []interface{}{},
+		}},
+		{name: "String", value: ".5"},
+		{name: "EmptyList", value: []interface{}{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := marshalYAML(test.value)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			got, err := decodeTestYAML(string(b))
+			if err != nil {
+				t.Fatalf("error decoding %q: %v", b, err)
+			}
+
+			if diff := cmp.Diff(jsonValue(t, test.value), got); diff != "" {
+				t.Errorf("unexpected round trip of %q (-want +got):\n%s", b, diff)
+			}
+		})
+	}
+}
+
+func TestMarshalYAMLQuotesNumericStrings(t *testing.T) {
+	b, err := marshalYAML(map[string]interface{}{"count": 5, "ratio": ".5", "window": "._1", "suffix": ".com"})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	expected := "count: 5\nratio: \".5\"\nsuffix: .com\nwindow: \"._1\"\n"
+	if string(b) != expected {
+		t.Errorf("expected: %q received: %q", expected, b)
+	}
+}
+
+// jsonValue returns v as marshalYAML sees it, decoded from JSON with numbers kept as json.Number
+func jsonValue(t *testing.T, v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	return decoded
+}
+
+// The scalars YAML 1.1 and 1.2 resolve to null, bools, and numbers when unquoted
+var (
+	testYAMLNull   = regexp.MustCompile(`^(~|null|Null|NULL|)$`)
+	testYAMLBool   = regexp.MustCompile(`^(?i:y|yes|n|no|true|false|on|off)$`)
+	testYAMLNumber = regexp.MustCompile(`^([-+]?(0b[0-1_]+|0[0-7_]+|(0|[1-9][0-9_]*)|0x[0-9a-fA-F_]+|[1-9][0-9_]*(:[0-5]?[0-9])+)` +
+		`|[-+]?([0-9][0-9_]*\.[0-9_]*|\.[0-9_]+)([eE][-+]?[0-9]+)?|[-+]?[0-9][0-9_]*(:[0-5]?[0-9])+\.[0-9_]*` +
+		`|[-+]?[0-9]+([eE][-+]?[0-9]+)?|[-+]?\.(inf|Inf|INF)|\.(nan|NaN|NAN))$`)
+)
+
+type testYAMLLine struct {
+	indent int
+	text   string
+}
+
+// testYAMLDecoder decodes the block style YAML written by marshalYAML, resolving unquoted scalars as YAML does, so a
+// string written unquoted that YAML would read as another type fails to round trip
+type testYAMLDecoder struct {
+	lines []testYAMLLine
+	pos   int
+}
+
+func decodeTestYAML(s string) (interface{}, error) {
+	d := &testYAMLDecoder{}
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		text := strings.TrimLeft(line, " ")
+		d.lines = append(d.lines, testYAMLLine{indent: len(line) - len(text), text: text})
+	}
+
+	if _, _, isKey, err := splitTestYAMLKey(d.lines[0].text); err != nil {
+		return nil, err
+	} else if len(d.lines) == 1 && !isKey && !strings.HasPrefix(d.lines[0].text, "- ") {
+		return decodeTestYAMLScalar(d.lines[0].text)
+	}
+
+	v, err := d.block(0)
+	if err == nil && d.pos < len(d.lines) {
+		err = fmt.Errorf("unexpected line %q", d.lines[d.pos].text)
+	}
+	return v, err
+}
+
+// block decodes the map or list starting at the current line, whose lines are indented by indent
+func (d *testYAMLDecoder) block(indent int) (interface{}, error) {
+	if d.pos >= len(d.lines) || d.lines[d.pos].indent != indent {
+		return nil, fmt.Errorf("expected a block indented by %d", indent)
+	}
+
+	if strings.HasPrefix(d.lines[d.pos].text, "- ") {
+		list := []interface{}{}
+		for d.pos < len(d.lines) && d.lines[d.pos].indent == indent && strings.HasPrefix(d.lines[d.pos].text, "- ") {
+			// the item is read as if it started on a line of its own, indented past the dash
+			item := strings.TrimPrefix(d.lines[d.pos].text, "- ")
+			d.lines[d.pos] = testYAMLLine{indent: indent + 2, text: item}
+
+			_, _, isKey, err := splitTestYAMLKey(item)
+			if err != nil {
+				return nil, err
+			}
+
+			var v interface{}
+			if isKey || strings.HasPrefix(item, "- ") {
+				v, err = d.block(indent + 2)
+			} else {
+				v, err = decodeTestYAMLScalar(item)
+				d.pos++
+			}
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+
+	m := map[string]interface{}{}
+	for d.pos < len(d.lines) && d.lines[d.pos].indent == indent {
+		key, value, isKey, err := splitTestYAMLKey(d.lines[d.pos].text)
+		if err != nil {
+			return nil, err
+		}
+		if !isKey {
+			return nil, fmt.Errorf("expected a key in %q", d.lines[d.pos].text)
+		}
+		d.pos++
+
+		var v interface{}
+		if len(value) == 0 {
+			v, err = d.block(indent + 2)
+		} else {
+			v, err = decodeTestYAMLScalar(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// splitTestYAMLKey splits a "key: value" or "key:" line, returning whether it has a key
+func splitTestYAMLKey(text string) (string, string, bool, error) {
+	key, rest := "", text
+	if strings.HasPrefix(text, `"`) {
+		quoted, err := strconv.QuotedPrefix(text)
+		if err != nil {
+			return "", "", false, err
+		}
+		if key, err = strconv.Unquote(quoted); err != nil {
+			return "", "", false, err
+		}
+		rest = text[len(quoted):]
+	} else {
+		i := strings.Index(text, ":")
+		if i < 0 {
+			return "", "", false, nil
+		}
+		key, rest = text[:i], text[i:]
+	}
+
+	switch {
+	case rest == ":":
+		return key, "", true, nil
+	case strings.HasPrefix(rest, ": "):
+		return key, rest[2:], true, nil
+	case len(rest) == 0:
+		return "", "", false, nil
+	}
+	return "", "", false, fmt.Errorf("invalid line %q", text)
+}
+
+func decodeTestYAMLScalar(text string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		return strconv.Unquote(text)
+	case text == "{}":
+		return map[string]interface{}{}, nil
+	case text == "[]":
+		return []interface{}{}, nil
+	case testYAMLNull.MatchString(text):
+		return nil, nil
+	case testYAMLBool.MatchString(text):
+		switch strings.ToLower(text) {
+		case "y", "yes", "true", "on":
+			return true, nil
+		}
+		return false, nil
+	case testYAMLNumber.MatchString(text):
+		return json.Number(text), nil
+	case strings.ContainsAny(text[:1], "-?:,[]{}#&*!|>'\"%@`"):
+		return nil, fmt.Errorf("invalid plain scalar %q", text)
+	}
+	return text, nil
+}
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/dollarshaveclub/guardian/pkg/guardian"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// config is the configuration of the guardian server, grouped the way it is in config files. Each field is bound to
+// a flag, named by its flag tag, and is set from the flag, its GUARDIAN_FLAG_ environment variable, the config file,
+// or the flag's default, in that order of precedence.
+type config struct {
+	LogLevel        string                `json:"log_level" flag:"log-level"`
+	Server          serverConfig          `json:"server"`
+	GRPC            grpcConfig            `json:"grpc"`
+	Redis           redisConfig           `json:"redis"`
+	Conf            confConfig            `json:"conf"`
+	LeaderElection  leaderElectionConfig  `json:"leader_election"`
+	Defaults        defaultsConfig        `json:"defaults"`
+	WhitelistHosts  whitelistHostsConfig  `json:"whitelist_hosts"`
+	WhitelistCache  whitelistCacheConfig  `json:"whitelist_cache"`
+	Rules           rulesConfig           `json:"rules"`
+	Reputation      reputationConfig      `json:"reputation"`
+	Plan            planConfig            `json:"plan"`
+	OpenAPI         openAPIConfig         `json:"openapi"`
+	InternalNetwork internalNetworkConfig `json:"internal_network"`
+	Feedback        feedbackConfig        `json:"feedback"`
+	Challenge       challengeConfig       `json:"challenge"`
+	TrustedCDNs     trustedCDNsConfig     `json:"trusted_cdns"`
+	DecisionStream  decisionStreamConfig  `json:"decision_stream"`
+	DecisionCache   decisionCacheConfig   `json:"decision_cache"`
+	LimitAnalysis   limitAnalysisConfig   `json:"limit_analysis"`
+	TrafficProfile  trafficProfileConfig  `json:"traffic_profile"`
+	SelfTest        selfTestConfig        `json:"self_test"`
+	WarmBlockedKeys warmBlockedKeysConfig `json:"warm_blocked_keys"`
+	Admin           adminConfig           `json:"admin"`
+	Metrics         metricsConfig         `json:"metrics"`
+	SLO             sloConfig             `json:"slo"`
+	ThrottleWebhook throttleWebhookConfig `json:"throttle_webhook"`
+	Profiler        profilerConfig        `json:"profiler"`
+}
+
+type serverConfig struct {
+	Address             string           `json:"address" flag:"address"`
+	Network             string           `json:"network" flag:"network"`
+	Mode                string           `json:"mode" flag:"mode"`
+	Domains             []string         `json:"domains" flag:"domain"`
+	UnknownDomainAction string           `json:"unknown_domain_action" flag:"unknown-domain-action"`
+	DebugToken          string           `json:"debug_token" flag:"debug-token" secret:"true"`
+	BlockedHintMax      time.Duration    `json:"blocked_hint_max" flag:"blocked-hint-max"`
+	StaticResponses     bool             `json:"static_responses" flag:"static-responses"`
+	Exemptions          []string         `json:"exemptions" flag:"exemption" secret:"true"`
+	Warmup              warmupConfig     `json:"warmup"`
+	Drain               drainConfig      `json:"drain"`
+	Validation          validationConfig `json:"validation"`
+}
+
+type validationConfig struct {
+	Action         string `json:"action" flag:"invalid-request-action"`
+	MaxDescriptors int    `json:"max_descriptors" flag:"request-max-descriptors"`
+	MaxEntries     int    `json:"max_entries" flag:"request-max-descriptor-entries"`
+	MaxValueLength int    `json:"max_value_length" flag:"request-max-value-length"`
+	MaxHitsAddend  uint32 `json:"max_hits_addend" flag:"request-max-hits-addend"`
+}
+
+type drainConfig struct {
+	Delay    time.Duration `json:"delay" flag:"shutdown-drain-delay"`
+	Decision string        `json:"decision" flag:"shutdown-drain-decision"`
+}
+
+type warmupConfig struct {
+	ReportOnly time.Duration `json:"report_only" flag:"warmup-report-only"`
+	Ramp       time.Duration `json:"ramp" flag:"warmup-ramp"`
+}
+
+type grpcConfig struct {
+	MaxRecvMsgSize        int           `json:"max_recv_msg_size" flag:"grpc-max-recv-msg-size"`
+	MaxSendMsgSize        int           `json:"max_send_msg_size" flag:"grpc-max-send-msg-size"`
+	RequestTimeout        time.Duration `json:"request_timeout" flag:"grpc-request-timeout"`
+	MaxConnectionAge      time.Duration `json:"max_connection_age" flag:"grpc-max-connection-age"`
+	MaxConnectionAgeGrace time.Duration `json:"max_connection_age_grace" flag:"grpc-max-connection-age-grace"`
+	StreamingMethods      []string      `json:"streaming_methods" flag:"grpc-streaming-method"`
+}
+
+type redisConfig struct {
+	Address        string             `json:"address" flag:"redis-address"`
+	LimitAddress   string             `json:"limit_address" flag:"redis-limit-address"`
+	Username       string             `json:"username" flag:"redis-username"`
+	Password       string             `json:"password" flag:"redis-password" secret:"true"`
+	PoolSize       int                `json:"pool_size" flag:"redis-pool-size"`
+	ReplicaAddress string             `json:"replica_address" flag:"redis-replica-address"`
+	HedgeThreshold time.Duration      `json:"hedge_threshold" flag:"redis-hedge-threshold"`
+	BulkheadLimit  int                `json:"bulkhead_limit" flag:"redis-bulkhead-limit"`
+	Synchronous    bool               `json:"synchronous" flag:"synchronous"`
+	TLS            redisTLSConfig     `json:"tls"`
+	Circuit        redisCircuitConfig `json:"circuit"`
+	Time           redisTimeConfig    `json:"time"`
+	Janitor        redisJanitorConfig `json:"janitor"`
+	Memory         redisMemoryConfig  `json:"memory"`
+}
+
+type redisTLSConfig struct {
+	Enabled    bool   `json:"enabled" flag:"redis-tls"`
+	CAFile     string `json:"ca_file" flag:"redis-tls-ca-file"`
+	ServerName string `json:"server_name" flag:"redis-tls-server-name"`
+	SkipVerify bool   `json:"skip_verify" flag:"redis-tls-skip-verify"`
+}
+
+type redisCircuitConfig struct {
+	FailureThreshold int           `json:"failure_threshold" flag:"redis-circuit-failure-threshold"`
+	OpenDuration     time.Duration `json:"open_duration" flag:"redis-circuit-open-duration"`
+}
+
+type redisTimeConfig struct {
+	Enabled      bool          `json:"enabled" flag:"redis-time"`
+	SyncInterval time.Duration `json:"sync_interval" flag:"redis-time-sync-interval"`
+}
+
+type redisJanitorConfig struct {
+	Interval         time.Duration `json:"interval" flag:"janitor-interval"`
+	OrphanExpiration time.Duration `json:"orphan_expiration" flag:"janitor-orphan-expiration"`
+}
+
+type redisMemoryConfig struct {
+	Budget   uint64        `json:"budget" flag:"redis-memory-budget"`
+	MaxTTL   time.Duration `json:"max_ttl" flag:"redis-memory-max-ttl"`
+	Interval time.Duration `json:"interval" flag:"redis-memory-interval"`
+}
+
+type confConfig struct {
+	Backend        string           `json:"backend" flag:"conf-backend"`
+	UpdateInterval time.Duration    `json:"update_interval" flag:"conf-update-interval"`
+	DefaultFile    string           `json:"default_file" flag:"default-conf-file"`
+	VerifyKeyFile  string           `json:"verify_key_file" flag:"conf-verify-key-file"`
+	Migrate        bool             `json:"migrate" flag:"conf-migrate"`
+	Redis          confRedisConfig  `json:"redis"`
+	Consul         confConsulConfig `json:"consul"`
+}
+
+type confRedisConfig struct {
+	Address  string `json:"address" flag:"redis-conf-address"`
+	PoolSize int    `json:"pool_size" flag:"redis-conf-pool-size"`
+}
+
+type confConsulConfig struct {
+	Address string `json:"address" flag:"consul-address"`
+	Token   string `json:"token" flag:"consul-token" secret:"true"`
+	Key     string `json:"key" flag:"consul-conf-key"`
+}
+
+type leaderElectionConfig struct {
+	Enabled  bool          `json:"enabled" flag:"leader-election"`
+	LeaseTTL time.Duration `json:"lease_ttl" flag:"leader-election-lease-ttl"`
+}
+
+type defaultsConfig struct {
+	ReportOnly     bool               `json:"report_only" flag:"report-only"`
+	WhitelistCIDRs []string           `json:"whitelist_cidrs" flag:"whitelist-cidr"`
+	BlacklistCIDRs []string           `json:"blacklist_cidrs" flag:"blacklist-cidr"`
+	Limit          defaultLimitConfig `json:"limit"`
+}
+
+type defaultLimitConfig struct {
+	Count          uint64        `json:"count" flag:"limit"`
+	Duration       time.Duration `json:"duration" flag:"limit-duration"`
+	Enabled        bool          `json:"enabled" flag:"limit-enabled"`
+	Algorithm      string        `json:"algorithm" flag:"limit-algorithm"`
+	EnforcePercent uint          `json:"enforce_percent" flag:"limit-enforce-percent"`
+	Calendar       string        `json:"calendar" flag:"limit-calendar"`
+	TimeZone       string        `json:"time_zone" flag:"limit-time-zone"`
+	Rollover       uint64        `json:"rollover" flag:"limit-rollover"`
+}
+
+type whitelistHostsConfig struct {
+	RefreshInterval time.Duration `json:"refresh_interval" flag:"whitelist-host-refresh-interval"`
+	LookupTimeout   time.Duration `json:"lookup_timeout" flag:"whitelist-host-lookup-timeout"`
+}
+
+type whitelistCacheConfig struct {
+	Size int           `json:"size" flag:"whitelist-negative-cache-size"`
+	TTL  time.Duration `json:"ttl" flag:"whitelist-negative-cache-ttl"`
+}
+
+type rulesConfig struct {
+	KeyCardinalityLimit  uint64        `json:"key_cardinality_limit" flag:"rule-key-cardinality-limit"`
+	KeyCardinalityWindow time.Duration `json:"key_cardinality_window" flag:"rule-key-cardinality-window"`
+}
+
+type reputationConfig struct {
+	URL            string        `json:"url" flag:"reputation-url"`
+	Timeout        time.Duration `json:"timeout" flag:"reputation-timeout"`
+	CacheTTL       time.Duration `json:"cache_ttl" flag:"reputation-cache-ttl"`
+	BlockScore     int           `json:"block_score" flag:"reputation-block-score"`
+	ThrottleScore  int           `json:"throttle_score" flag:"reputation-throttle-score"`
+	ThrottleFactor float64       `json:"throttle_factor" flag:"reputation-throttle-factor"`
+}
+
+type planConfig struct {
+	URL       string        `json:"url" flag:"plan-url"`
+	Key       string        `json:"key" flag:"plan-key"`
+	Timeout   time.Duration `json:"timeout" flag:"plan-timeout"`
+	CacheTTL  time.Duration `json:"cache_ttl" flag:"plan-cache-ttl"`
+	CacheSize int           `json:"cache_size" flag:"plan-cache-size"`
+}
+
+type openAPIConfig struct {
+	SpecFile string `json:"spec_file" flag:"openapi-spec-file"`
+}
+
+type internalNetworkConfig struct {
+	CIDRs []string `json:"cidrs" flag:"internal-network"`
+}
+
+type feedbackConfig struct {
+	Threshold       uint64        `json:"threshold" flag:"feedback-threshold"`
+	Statuses        []int         `json:"statuses" flag:"feedback-status"`
+	Window          time.Duration `json:"window" flag:"feedback-window"`
+	PenaltyDuration time.Duration `json:"penalty_duration" flag:"feedback-penalty-duration"`
+	Action          string        `json:"action" flag:"feedback-action"`
+	ThrottleFactor  float64       `json:"throttle_factor" flag:"feedback-throttle-factor"`
+}
+
+type challengeConfig struct {
+	PassDuration time.Duration `json:"pass_duration" flag:"challenge-pass-duration"`
+}
+
+type trustedCDNsConfig struct {
+	CDNs            []string      `json:"cdns" flag:"trusted-cdn"`
+	Ranges          []string      `json:"ranges" flag:"trusted-cdn-range"`
+	RefreshInterval time.Duration `json:"refresh_interval" flag:"trusted-cdn-refresh-interval"`
+	Timeout         time.Duration `json:"timeout" flag:"trusted-cdn-timeout"`
+}
+
+type decisionStreamConfig struct {
+	Enabled    bool    `json:"enabled" flag:"decision-stream-enabled"`
+	SampleRate float64 `json:"sample_rate" flag:"decision-stream-sample-rate"`
+	Buffer     int     `json:"buffer" flag:"decision-stream-buffer"`
+}
+
+type decisionCacheConfig struct {
+	Size   int           `json:"size" flag:"decision-cache-size"`
+	MaxTTL time.Duration `json:"max_ttl" flag:"decision-cache-max-ttl"`
+}
+
+type limitAnalysisConfig struct {
+	Window time.Duration `json:"window" flag:"limit-analysis-window"`
+	Margin float64       `json:"margin" flag:"limit-analysis-margin"`
+}
+
+type selfTestConfig struct {
+	Interval      time.Duration `json:"interval" flag:"self-test-interval"`
+	Timeout       time.Duration `json:"timeout" flag:"self-test-timeout"`
+	RemoteAddress string        `json:"remote_address" flag:"self-test-remote-address"`
+	Expect        string        `json:"expect" flag:"self-test-expect"`
+}
+
+type trafficProfileConfig struct {
+	Window time.Duration `json:"window" flag:"traffic-profile-window"`
+}
+
+type warmBlockedKeysConfig struct {
+	From    string        `json:"from" flag:"warm-blocked-keys-from"`
+	Timeout time.Duration `json:"timeout" flag:"warm-blocked-keys-timeout"`
+}
+
+type adminConfig struct {
+	Address    string   `json:"address" flag:"admin-address"`
+	AllowCIDRs []string `json:"allow_cidrs" flag:"admin-allow-cidr"`
+	DenyCIDRs  []string `json:"deny_cidrs" flag:"admin-deny-cidr"`
+}
+
+type metricsConfig struct {
+	DogstatsdAddress   string        `json:"dogstatsd_address" flag:"dogstatsd-address"`
+	DogstatsdTags      []string      `json:"dogstatsd_tags" flag:"dogstatsd-tag"`
+	BufferSize         int           `json:"buffer_size" flag:"dogstatsd-buffer-size"`
+	BlockStatsInterval time.Duration `json:"block_stats_interval" flag:"block-stats-interval"`
+	KeyInterval        time.Duration `json:"key_interval" flag:"key-metrics-interval"`
+	KeyTop             int           `json:"key_top" flag:"key-metrics-top"`
+}
+
+type sloConfig struct {
+	Interval          time.Duration `json:"interval" flag:"slo-interval"`
+	LatencyThreshold  time.Duration `json:"latency_threshold" flag:"slo-latency-threshold"`
+	LatencyObjective  float64       `json:"latency_objective" flag:"slo-latency-objective"`
+	FailOpenObjective float64       `json:"fail_open_objective" flag:"slo-fail-open-objective"`
+	ConfMaxAge        time.Duration `json:"conf_max_age" flag:"slo-conf-max-age"`
+	ConfObjective     float64       `json:"conf_objective" flag:"slo-conf-objective"`
+	AlertBurnRate     float64       `json:"alert_burn_rate" flag:"slo-alert-burn-rate"`
+	WebhookURL        string        `json:"webhook_url" flag:"slo-webhook-url" secret:"true"`
+	WebhookTimeout    time.Duration `json:"webhook_timeout" flag:"slo-webhook-timeout"`
+}
+
+type throttleWebhookConfig struct {
+	URL      string        `json:"url" flag:"throttle-webhook-url" secret:"true"`
+	Timeout  time.Duration `json:"timeout" flag:"throttle-webhook-timeout"`
+	Interval time.Duration `json:"interval" flag:"throttle-webhook-interval"`
+	Dedup    time.Duration `json:"dedup" flag:"throttle-webhook-dedup"`
+}
+
+type profilerConfig struct {
+	Enabled     bool   `json:"enabled" flag:"profiler-enabled"`
+	ProjectID   string `json:"project_id" flag:"profiler-project-id"`
+	ServiceName string `json:"service_name" flag:"profiler-service-name"`
+}
+
+// newConfig returns a config with its fields bound to the flags of app
+func newConfig(app *kingpin.Application) *config {
+	c := &config{}
+	app.Flag("log-level", "log level.").Short('l').Default("warn").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LOG_LEVEL").StringVar(&c.LogLevel)
+
+	app.Flag("address", "network address to listen on.").Short('a').Default("0.0.0.0:3000").OverrideDefaultFromEnvar("GUARDIAN_FLAG_ADDRESS").StringVar(&c.Server.Address)
+	app.Flag("network", "network to listen on. Must be \"tcp\", \"tcp4\", \"tcp6\", \"unix\" or \"unixpacket\".").Short('n').Default("tcp").OverrideDefaultFromEnvar("GUARDIAN_FLAG_NETWORK").StringVar(&c.Server.Network)
+	app.Flag("domain", "envoy rate limit domain served, may be repeated. all domains are served if unset").OverrideDefaultFromEnvar("GUARDIAN_FLAG_DOMAIN").StringsVar(&c.Server.Domains)
+	app.Flag("mode", "what the server enforces, one of limit (the whitelist and blacklist, rules, and rate limits) or blocklist (the whitelist and blacklist only, without counting requests)").Default(string(guardian.LimitServerMode)).OverrideDefaultFromEnvar("GUARDIAN_FLAG_MODE").EnumVar(&c.Server.Mode, string(guardian.LimitServerMode), string(guardian.BlocklistServerMode))
+	app.Flag("unknown-domain-action", "action taken on requests for domains that aren't served, one of ignore (allow without counting) or reject (fail the request)").Default(string(guardian.IgnoreUnknownDomainAction)).OverrideDefaultFromEnvar("GUARDIAN_FLAG_UNKNOWN_DOMAIN_ACTION").EnumVar(&c.Server.UnknownDomainAction, string(guardian.IgnoreUnknownDomainAction), string(guardian.RejectUnknownDomainAction))
+	app.Flag("debug-token", "secret token that, when sent in the x-guardian-debug header, logs the decision trace of that request at info level. disabled if empty.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_DEBUG_TOKEN").StringVar(&c.Server.DebugToken)
+	app.Flag("blocked-hint-max", "max duration blocked decisions are hinted to remain valid for in the x-guardian-blocked-for-ms response header. disabled if 0.").Default("1s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_BLOCKED_HINT_MAX").DurationVar(&c.Server.BlockedHintMax)
+	app.Flag("static-responses", "whether every caller of the rate limit service answers requests blocked by serve and challenge rules with their static responses and redirects, as the go client does. envoy's rate limit filter answers them with a 429, so synced conf with serve or challenge rules is rejected unless set.").Default("false").OverrideDefaultFromEnvar("GUARDIAN_FLAG_STATIC_RESPONSES").BoolVar(&c.Server.StaticResponses)
+	app.Flag("exemption", `requests exempt from rate limiting, such as health checks, as name=expression, e.g. healthz=req.path == "/healthz". may be repeated. exempted requests aren't counted, decided, or reported by request metrics, but are still blocked by the blacklist`).OverrideDefaultFromEnvar("GUARDIAN_FLAG_EXEMPTION").StringsVar(&c.Server.Exemptions)
+	app.Flag("warmup-report-only", "duration after starting that blocking is only reported, so counters and caches warm up before requests are blocked").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_WARMUP_REPORT_ONLY").DurationVar(&c.Server.Warmup.ReportOnly)
+	app.Flag("warmup-ramp", "duration after warmup-report-only that blocking is enforced for a growing share of remote addresses, until it is enforced for all of them").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_WARMUP_RAMP").DurationVar(&c.Server.Warmup.Ramp)
+	app.Flag("shutdown-drain-delay", "duration after a shutdown signal that health checks fail and rate limit requests are answered with --shutdown-drain-decision, so envoy rebalances to other replicas before the server stops. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SHUTDOWN_DRAIN_DELAY").DurationVar(&c.Server.Drain.Delay)
+	app.Flag("shutdown-drain-decision", "decision rate limit requests are answered with while draining, one of allow or unknown").Default(string(guardian.AllowDrainDecision)).OverrideDefaultFromEnvar("GUARDIAN_FLAG_SHUTDOWN_DRAIN_DECISION").EnumVar(&c.Server.Drain.Decision, string(guardian.AllowDrainDecision), string(guardian.UnknownDrainDecision))
+	app.Flag("invalid-request-action", "action taken on malformed rate limit requests, one of allow (allow without counting) or reject (fail the request)").Default(string(guardian.AllowInvalidRequestAction)).OverrideDefaultFromEnvar("GUARDIAN_FLAG_INVALID_REQUEST_ACTION").EnumVar(&c.Server.Validation.Action, string(guardian.AllowInvalidRequestAction), string(guardian.RejectInvalidRequestAction))
+	app.Flag("request-max-descriptors", "max descriptors of a valid rate limit request. unbounded if 0.").Default("64").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REQUEST_MAX_DESCRIPTORS").IntVar(&c.Server.Validation.MaxDescriptors)
+	app.Flag("request-max-descriptor-entries", "max entries of each descriptor of a valid rate limit request. unbounded if 0.").Default("16").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REQUEST_MAX_DESCRIPTOR_ENTRIES").IntVar(&c.Server.Validation.MaxEntries)
+	app.Flag("request-max-value-length", "max bytes of the domain and of each descriptor key and value of a valid rate limit request. unbounded if 0.").Default("8192").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REQUEST_MAX_VALUE_LENGTH").IntVar(&c.Server.Validation.MaxValueLength)
+	app.Flag("request-max-hits-addend", "max hits addend of a valid rate limit request. unbounded if 0.").Default("100000").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REQUEST_MAX_HITS_ADDEND").Uint32Var(&c.Server.Validation.MaxHitsAddend)
+
+	app.Flag("grpc-max-recv-msg-size", "max size in bytes of a grpc message the server receives. the grpc default of 4MiB if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_GRPC_MAX_RECV_MSG_SIZE").IntVar(&c.GRPC.MaxRecvMsgSize)
+	app.Flag("grpc-max-send-msg-size", "max size in bytes of a grpc message the server sends. the grpc default if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_GRPC_MAX_SEND_MSG_SIZE").IntVar(&c.GRPC.MaxSendMsgSize)
+	app.Flag("grpc-request-timeout", "max duration of a grpc request, enforced even when the caller sets no deadline or a longer one. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_GRPC_REQUEST_TIMEOUT").DurationVar(&c.GRPC.RequestTimeout)
+	app.Flag("grpc-max-connection-age", "max age of a grpc connection before the server asks the client to reconnect, rebalancing envoys across replicas. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_GRPC_MAX_CONNECTION_AGE").DurationVar(&c.GRPC.MaxConnectionAge)
+	app.Flag("grpc-max-connection-age-grace", "time in flight requests are given to complete once a connection reaches its max age before it is closed. unbounded if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_GRPC_MAX_CONNECTION_AGE_GRACE").DurationVar(&c.GRPC.MaxConnectionAgeGrace)
+	app.Flag("grpc-streaming-method", "route of a streaming grpc method, e.g. /chat.v1.Chat/Subscribe or /chat.v1.Chat/{method}, may be repeated. streams opened are exempt from the global limit and counted by route limits only").OverrideDefaultFromEnvar("GUARDIAN_FLAG_GRPC_STREAMING_METHOD").StringsVar(&c.GRPC.StreamingMethods)
+
+	app.Flag("redis-address", "host:port.").Short('r').OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_ADDRESS").StringVar(&c.Redis.Address)
+	app.Flag("redis-limit-address", "host:port of a redis dedicated to counters and the other state decisions read and write, so the hot counter workload can be kept off the redis conf is synced from. defaults to redis-address.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_LIMIT_ADDRESS").StringVar(&c.Redis.LimitAddress)
+	app.Flag("redis-username", "redis acl username, requires redis-password").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_USERNAME").StringVar(&c.Redis.Username)
+	app.Flag("redis-password", "redis auth password").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_PASSWORD").StringVar(&c.Redis.Password)
+	app.Flag("redis-pool-size", "redis connection pool size").Short('p').Default("20").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_POOL_SIZE").IntVar(&c.Redis.PoolSize)
+	app.Flag("redis-replica-address", "host:port of a redis replica hedged counter reads are sent to. hedged reads are sent to redis-limit-address, or redis-address, if empty.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_REPLICA_ADDRESS").StringVar(&c.Redis.ReplicaAddress)
+	app.Flag("redis-hedge-threshold", "time a redis counter increment or read is waited on before a second attempt is made, taking whichever returns first. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_HEDGE_THRESHOLD").DurationVar(&c.Redis.HedgeThreshold)
+	app.Flag("redis-bulkhead-limit", "max concurrent counter operations of the global limit and each route and rule, so one under attack can't exhaust the redis pool. requests over it are blocked. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_BULKHEAD_LIMIT").IntVar(&c.Redis.BulkheadLimit)
+	app.Flag("synchronous", "synchronously enforce ratelimit").Default("false").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SYNCHRONOUS").BoolVar(&c.Redis.Synchronous)
+	app.Flag("redis-tls", "connect to redis with tls").Default("false").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_TLS").BoolVar(&c.Redis.TLS.Enabled)
+	app.Flag("redis-tls-ca-file", "pem file of cas trusted to sign the redis server certificate. the system cas are trusted if empty.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_TLS_CA_FILE").StringVar(&c.Redis.TLS.CAFile)
+	app.Flag("redis-tls-server-name", "name the redis server certificate is verified against. defaults to the host of redis-address.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_TLS_SERVER_NAME").StringVar(&c.Redis.TLS.ServerName)
+	app.Flag("redis-tls-skip-verify", "skip verifying the redis server certificate").Default("false").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_TLS_SKIP_VERIFY").BoolVar(&c.Redis.TLS.SkipVerify)
+	app.Flag("redis-circuit-failure-threshold", "consecutive redis failures before counting locally until redis recovers, merging local counts back when it does. 0 disables local counting").Default("5").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_CIRCUIT_FAILURE_THRESHOLD").IntVar(&c.Redis.Circuit.FailureThreshold)
+	app.Flag("redis-circuit-open-duration", "time to count locally before retrying redis").Default("10s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_CIRCUIT_OPEN_DURATION").DurationVar(&c.Redis.Circuit.OpenDuration)
+	app.Flag("redis-time", "derive rate limit windows from redis TIME so all instances agree on window boundaries").Default("false").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_TIME").BoolVar(&c.Redis.Time.Enabled)
+	app.Flag("redis-time-sync-interval", "interval to resync the clock offset with redis TIME").Default("30s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_TIME_SYNC_INTERVAL").DurationVar(&c.Redis.Time.SyncInterval)
+	app.Flag("janitor-interval", "interval to scan redis for counter keys missing an expiration").Default("5m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_JANITOR_INTERVAL").DurationVar(&c.Redis.Janitor.Interval)
+	app.Flag("janitor-orphan-expiration", "expiration to set on counter keys found without one").Default("1m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_JANITOR_ORPHAN_EXPIRATION").DurationVar(&c.Redis.Janitor.OrphanExpiration)
+	app.Flag("redis-memory-budget", "redis used memory in bytes above which counter keys are expired early. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_MEMORY_BUDGET").Uint64Var(&c.Redis.Memory.Budget)
+	app.Flag("redis-memory-max-ttl", "max expiration of counter keys while redis exceeds its memory budget").Default("1m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_MEMORY_MAX_TTL").DurationVar(&c.Redis.Memory.MaxTTL)
+	app.Flag("redis-memory-interval", "interval to check redis used memory against its budget").Default("30s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_MEMORY_INTERVAL").DurationVar(&c.Redis.Memory.Interval)
+
+	app.Flag("conf-backend", "where conf is synced from, one of redis or consul").Default("redis").OverrideDefaultFromEnvar("GUARDIAN_FLAG_CONF_BACKEND").EnumVar(&c.Conf.Backend, "redis", "consul")
+	app.Flag("conf-update-interval", "interval to fetch new conf from redis, or to retry watching consul after an error").Short('i').Default("10s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_CONF_UPDATE_INTERVAL").DurationVar(&c.Conf.UpdateInterval)
+	app.Flag("default-conf-file", "json conf document used as the default conf until sync with redis occurs. fields it specifies take precedence over the equivalent flags. ignored if the file does not exist.").Default("/etc/guardian/conf.json").OverrideDefaultFromEnvar("GUARDIAN_FLAG_DEFAULT_CONF_FILE").StringVar(&c.Conf.DefaultFile)
+	app.Flag("conf-verify-key-file", "pem encoded ed25519 public key synced conf must be signed by, conf that isn't is not applied. disabled if empty.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_CONF_VERIFY_KEY_FILE").StringVar(&c.Conf.VerifyKeyFile)
+	app.Flag("conf-migrate", "migrate the conf stored in redis to the latest schema version on startup").Default("true").OverrideDefaultFromEnvar("GUARDIAN_FLAG_CONF_MIGRATE").BoolVar(&c.Conf.Migrate)
+	app.Flag("redis-conf-address", "host:port of the redis conf is synced from. defaults to redis-address.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_CONF_ADDRESS").StringVar(&c.Conf.Redis.Address)
+	app.Flag("redis-conf-pool-size", "size of the redis connection pool conf is synced through, separate from the pool counters use").Default("2").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_CONF_POOL_SIZE").IntVar(&c.Conf.Redis.PoolSize)
+	app.Flag("consul-address", "address of the consul agent conf is synced from with conf-backend consul").Default("http://localhost:8500").OverrideDefaultFromEnvar("GUARDIAN_FLAG_CONSUL_ADDRESS").StringVar(&c.Conf.Consul.Address)
+	app.Flag("consul-token", "acl token used to read the consul conf key").OverrideDefaultFromEnvar("GUARDIAN_FLAG_CONSUL_TOKEN").StringVar(&c.Conf.Consul.Token)
+	app.Flag("consul-conf-key", "consul key holding the json conf document with conf-backend consul").Default("guardian/conf").OverrideDefaultFromEnvar("GUARDIAN_FLAG_CONSUL_CONF_KEY").StringVar(&c.Conf.Consul.Key)
+
+	app.Flag("leader-election", "elect a leader among the instances sharing redis-limit-address, or redis-address, to alone run the janitor and shorten expirations over the redis memory budget. every instance runs them if disabled.").Default("false").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LEADER_ELECTION").BoolVar(&c.LeaderElection.Enabled)
+	app.Flag("leader-election-lease-ttl", "time the leader holds its lease without renewing it, and so the longest an instance that dies remains leader").Default("15s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LEADER_ELECTION_LEASE_TTL").DurationVar(&c.LeaderElection.LeaseTTL)
+
+	app.Flag("report-only", "report only, do not block.").Default("false").Short('o').OverrideDefaultFromEnvar("GUARDIAN_FLAG_REPORT_ONLY").BoolVar(&c.Defaults.ReportOnly)
+	app.Flag("whitelist-cidr", "default cidr to whitelist until sync with redis occurs").OverrideDefaultFromEnvar("GUARDIAN_FLAG_WHITELIST_CIDR").StringsVar(&c.Defaults.WhitelistCIDRs)
+	app.Flag("blacklist-cidr", "default cidr to blacklist until sync with redis occurs").OverrideDefaultFromEnvar("GUARDIAN_FLAG_BLACKLIST_CIDR").StringsVar(&c.Defaults.BlacklistCIDRs)
+	app.Flag("limit", "request limit per duration.").Short('q').Default("10").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT").Uint64Var(&c.Defaults.Limit.Count)
+	app.Flag("limit-duration", "duration to apply limit. supports time.ParseDuration format.").Short('y').Default("1s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT_DURATION").DurationVar(&c.Defaults.Limit.Duration)
+	app.Flag("limit-enabled", "rate limit enabled").Short('e').Default("true").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT_ENABLED").BoolVar(&c.Defaults.Limit.Enabled)
+	app.Flag("limit-algorithm", "rate limit algorithm, one of fixed_window, leaky_bucket, or day_buckets").Default(string(guardian.FixedWindowAlgorithm)).OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT_ALGORITHM").StringVar(&c.Defaults.Limit.Algorithm)
+	app.Flag("limit-enforce-percent", "percentage of clients the rate limit blocks, hashed by client. clients outside the percentage that exceed the limit are only reported. 0 enforces for all clients").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT_ENFORCE_PERCENT").UintVar(&c.Defaults.Limit.EnforcePercent)
+	app.Flag("limit-calendar", "align rate limit windows to a calendar minute, hour, or day instead of the limit duration").Default("").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT_CALENDAR").EnumVar(&c.Defaults.Limit.Calendar, "", "minute", "hour", "day")
+	app.Flag("limit-time-zone", "IANA time zone calendar windows are aligned in, defaults to UTC").Default("").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT_TIME_ZONE").StringVar(&c.Defaults.Limit.TimeZone)
+	app.Flag("limit-rollover", "max unused requests of a client's previous fixed window carried into its next window. 0 disables rollover").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT_ROLLOVER").Uint64Var(&c.Defaults.Limit.Rollover)
+
+	app.Flag("whitelist-host-refresh-interval", "interval whitelisted hostnames are resolved at. keep it below the ttl of their dns records. disabled if 0.").Default("1m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_WHITELIST_HOST_REFRESH_INTERVAL").DurationVar(&c.WhitelistHosts.RefreshInterval)
+	app.Flag("whitelist-host-lookup-timeout", "timeout of resolving a whitelisted hostname").Default("5s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_WHITELIST_HOST_LOOKUP_TIMEOUT").DurationVar(&c.WhitelistHosts.LookupTimeout)
+
+	app.Flag("whitelist-negative-cache-size", "remote addresses found not to be whitelisted that are cached so their next requests skip matching the whitelist. disabled if 0.").Default("10000").OverrideDefaultFromEnvar("GUARDIAN_FLAG_WHITELIST_NEGATIVE_CACHE_SIZE").IntVar(&c.WhitelistCache.Size)
+	app.Flag("whitelist-negative-cache-ttl", "duration a remote address is cached as not whitelisted. the cache is cleared whenever the whitelist changes").Default("1m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_WHITELIST_NEGATIVE_CACHE_TTL").DurationVar(&c.WhitelistCache.TTL)
+
+	app.Flag("rule-key-cardinality-limit", "estimated distinct limit key values a rule can count within rule-key-cardinality-window before counting requests by remote address instead. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_RULE_KEY_CARDINALITY_LIMIT").Uint64Var(&c.Rules.KeyCardinalityLimit)
+	app.Flag("rule-key-cardinality-window", "window distinct limit key values of rules are estimated over").Default("1m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_RULE_KEY_CARDINALITY_WINDOW").DurationVar(&c.Rules.KeyCardinalityWindow)
+
+	app.Flag("reputation-url", "url of an http ip reputation provider queried with ?ip=<ip>. disabled if empty.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REPUTATION_URL").StringVar(&c.Reputation.URL)
+	app.Flag("reputation-timeout", "timeout of ip reputation lookups").Default("1s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REPUTATION_TIMEOUT").DurationVar(&c.Reputation.Timeout)
+	app.Flag("reputation-cache-ttl", "duration to cache ip reputation scores").Default("5m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REPUTATION_CACHE_TTL").DurationVar(&c.Reputation.CacheTTL)
+	app.Flag("reputation-block-score", "reputation score at or above which requests are blocked. 0 disables.").Default("80").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REPUTATION_BLOCK_SCORE").IntVar(&c.Reputation.BlockScore)
+	app.Flag("reputation-throttle-score", "reputation score at or above which requests are rate limited with a reduced limit. 0 disables.").Default("50").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REPUTATION_THROTTLE_SCORE").IntVar(&c.Reputation.ThrottleScore)
+	app.Flag("reputation-throttle-factor", "factor applied to the limit count of throttled requests").Default("0.5").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REPUTATION_THROTTLE_FACTOR").Float64Var(&c.Reputation.ThrottleFactor)
+
+	app.Flag("plan-url", "url of an http plan service queried with ?key=<api key> for the tier rules match with req.tier. disabled if empty.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_PLAN_URL").StringVar(&c.Plan.URL)
+	app.Flag("plan-key", "request attribute holding the api key plan tiers are looked up by, e.g. header.x-api-key or metadata.api_key").Default("header.x-api-key").OverrideDefaultFromEnvar("GUARDIAN_FLAG_PLAN_KEY").StringVar(&c.Plan.Key)
+	app.Flag("plan-timeout", "timeout of plan tier lookups").Default("1s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_PLAN_TIMEOUT").DurationVar(&c.Plan.Timeout)
+	app.Flag("plan-cache-ttl", "duration to cache plan tiers").Default("5m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_PLAN_CACHE_TTL").DurationVar(&c.Plan.CacheTTL)
+	app.Flag("plan-cache-size", "api keys whose plan tiers are cached, the least recently used evicted first").Default("10000").OverrideDefaultFromEnvar("GUARDIAN_FLAG_PLAN_CACHE_SIZE").IntVar(&c.Plan.CacheSize)
+
+	app.Flag("openapi-spec-file", "json openapi 3 or swagger 2.0 spec whose operation ids rules can match with req.operation and count by with the {operation} key template placeholder. disabled if empty.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_OPENAPI_SPEC_FILE").StringVar(&c.OpenAPI.SpecFile)
+	app.Flag("internal-network", "cidr of a trusted internal network rules can match with req.internal, or private for the rfc 1918 and rfc 4193 ranges. may be repeated").OverrideDefaultFromEnvar("GUARDIAN_FLAG_INTERNAL_NETWORK").StringsVar(&c.InternalNetwork.CIDRs)
+
+	app.Flag("feedback-threshold", "abusive outcomes reported to the admin server at /v1/feedback within feedback-window that penalize a remote address. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_FEEDBACK_THRESHOLD").Uint64Var(&c.Feedback.Threshold)
+	app.Flag("feedback-status", "reported response status counted as an abusive outcome, may be repeated").Default("401", "403").OverrideDefaultFromEnvar("GUARDIAN_FLAG_FEEDBACK_STATUS").IntsVar(&c.Feedback.Statuses)
+	app.Flag("feedback-window", "window abusive outcomes are counted in").Default("1m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_FEEDBACK_WINDOW").DurationVar(&c.Feedback.Window)
+	app.Flag("feedback-penalty-duration", "duration a remote address is penalized for").Default("15m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_FEEDBACK_PENALTY_DURATION").DurationVar(&c.Feedback.PenaltyDuration)
+	app.Flag("feedback-action", "action taken on requests from penalized remote addresses, one of block or throttle").Default(string(guardian.FeedbackBlockAction)).OverrideDefaultFromEnvar("GUARDIAN_FLAG_FEEDBACK_ACTION").EnumVar(&c.Feedback.Action, string(guardian.FeedbackBlockAction), string(guardian.FeedbackThrottleAction))
+	app.Flag("feedback-throttle-factor", "factor applied to the limit count of requests from penalized remote addresses with the throttle action").Default("0.1").OverrideDefaultFromEnvar("GUARDIAN_FLAG_FEEDBACK_THROTTLE_FACTOR").Float64Var(&c.Feedback.ThrottleFactor)
+	app.Flag("challenge-pass-duration", "duration remote addresses reported to the admin server at /v1/challenge-passes as passing the challenge of a challenge rule are allowed for. disabled if 0.").Default("30m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_CHALLENGE_PASS_DURATION").DurationVar(&c.Challenge.PassDuration)
+
+	app.Flag("trusted-cdn", "cdn whose client address header replaces the remote address of requests from its ranges, one of akamai, cloudflare, cloudfront, or fastly. may be repeated").OverrideDefaultFromEnvar("GUARDIAN_FLAG_TRUSTED_CDN").EnumsVar(&c.TrustedCDNs.CDNs, guardian.CDNPresetNames()...)
+	app.Flag("trusted-cdn-range", "range of a trusted cdn as <cdn>=<cidr>, e.g. akamai=23.32.0.0/11, in addition to those it publishes. required for cdns that don't publish their ranges. may be repeated").OverrideDefaultFromEnvar("GUARDIAN_FLAG_TRUSTED_CDN_RANGE").StringsVar(&c.TrustedCDNs.Ranges)
+	app.Flag("trusted-cdn-refresh-interval", "interval to refresh the ranges trusted cdns publish").Default("24h").OverrideDefaultFromEnvar("GUARDIAN_FLAG_TRUSTED_CDN_REFRESH_INTERVAL").DurationVar(&c.TrustedCDNs.RefreshInterval)
+	app.Flag("trusted-cdn-timeout", "timeout of requests for the ranges trusted cdns publish").Default("10s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_TRUSTED_CDN_TIMEOUT").DurationVar(&c.TrustedCDNs.Timeout)
+
+	app.Flag("decision-stream-enabled", "serve the guardian.v1.DecisionStream grpc service streaming decisions to subscribers").Default("false").OverrideDefaultFromEnvar("GUARDIAN_FLAG_DECISION_STREAM_ENABLED").BoolVar(&c.DecisionStream.Enabled)
+	app.Flag("decision-stream-sample-rate", "fraction of allowed decisions streamed. blocked decisions and errors are always streamed").Default("1").OverrideDefaultFromEnvar("GUARDIAN_FLAG_DECISION_STREAM_SAMPLE_RATE").Float64Var(&c.DecisionStream.SampleRate)
+	app.Flag("decision-stream-buffer", "decisions buffered per subscriber before decisions are dropped for it").Default("1024").OverrideDefaultFromEnvar("GUARDIAN_FLAG_DECISION_STREAM_BUFFER").IntVar(&c.DecisionStream.Buffer)
+	app.Flag("decision-cache-size", "number of blocked decisions cached so identical requests are blocked without being decided again until their window ends. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_DECISION_CACHE_SIZE").IntVar(&c.DecisionCache.Size)
+	app.Flag("decision-cache-max-ttl", "longest a blocked decision is cached, bounding how long conf changes take to apply to cached decisions").Default("5s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_DECISION_CACHE_MAX_TTL").DurationVar(&c.DecisionCache.MaxTTL)
+
+	app.Flag("limit-analysis-window", "window client request rates are analyzed in to recommend limits, served by the admin server at /v1/limit-recommendations. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT_ANALYSIS_WINDOW").DurationVar(&c.LimitAnalysis.Window)
+	app.Flag("limit-analysis-margin", "fraction added to the observed p99.9 client request rate to recommend a limit").Default("0.2").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT_ANALYSIS_MARGIN").Float64Var(&c.LimitAnalysis.Margin)
+	app.Flag("traffic-profile-window", "window the requests and unique keys of each route are profiled in, reported as traffic.requests and traffic.unique_keys metrics and served by the admin server at /v1/traffic-profile. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_TRAFFIC_PROFILE_WINDOW").DurationVar(&c.TrafficProfile.Window)
+	app.Flag("self-test-interval", "interval to run a synthetic request through the rate limit pipeline and count a dedicated redis key, reporting the self_test.duration metric. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SELF_TEST_INTERVAL").DurationVar(&c.SelfTest.Interval)
+	app.Flag("self-test-timeout", "timeout of each self test probe").Default("1s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SELF_TEST_TIMEOUT").DurationVar(&c.SelfTest.Timeout)
+	app.Flag("self-test-remote-address", "remote address of self test requests, counted by limits like any client").Default(guardian.DefaultSelfTestRemoteAddress).OverrideDefaultFromEnvar("GUARDIAN_FLAG_SELF_TEST_REMOTE_ADDRESS").StringVar(&c.SelfTest.RemoteAddress)
+	app.Flag("self-test-expect", "decision expected for self test requests, one of allow or block. block if the probe address is blacklisted to test the blocking path.").Default("allow").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SELF_TEST_EXPECT").EnumVar(&c.SelfTest.Expect, "allow", "block")
+
+	app.Flag("warm-blocked-keys-from", "admin server url of a peer guardian, e.g. http://guardian-admin:3001, to copy the keys it has cached as blocked from on startup. disabled if empty.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_WARM_BLOCKED_KEYS_FROM").StringVar(&c.WarmBlockedKeys.From)
+	app.Flag("warm-blocked-keys-timeout", "timeout of copying blocked keys from the peer on startup").Default("5s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_WARM_BLOCKED_KEYS_TIMEOUT").DurationVar(&c.WarmBlockedKeys.Timeout)
+
+	app.Flag("admin-address", "network address for the admin http server to listen on. disabled if empty.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_ADMIN_ADDRESS").StringVar(&c.Admin.Address)
+	app.Flag("admin-allow-cidr", "cidr allowed to reach the admin server, may be repeated. all sources are allowed if unset").OverrideDefaultFromEnvar("GUARDIAN_FLAG_ADMIN_ALLOW_CIDR").StringsVar(&c.Admin.AllowCIDRs)
+	app.Flag("admin-deny-cidr", "cidr denied from reaching the admin server, may be repeated. takes precedence over admin-allow-cidr").OverrideDefaultFromEnvar("GUARDIAN_FLAG_ADMIN_DENY_CIDR").StringsVar(&c.Admin.DenyCIDRs)
+
+	app.Flag("dogstatsd-address", "host:port.").Short('d').OverrideDefaultFromEnvar("GUARDIAN_FLAG_DOGSTATSD_ADDRESS").StringVar(&c.Metrics.DogstatsdAddress)
+	app.Flag("dogstatsd-tag", "tag to add to dogstatsd metrics").OverrideDefaultFromEnvar("GUARDIAN_FLAG_DOGSTATSD_TAG").StringsVar(&c.Metrics.DogstatsdTags)
+	app.Flag("dogstatsd-buffer-size", "number of metrics queued for emission to dogstatsd, off the decision path, before metrics are dropped and counted as metrics.dropped").Default("1000000").OverrideDefaultFromEnvar("GUARDIAN_FLAG_DOGSTATSD_BUFFER_SIZE").IntVar(&c.Metrics.BufferSize)
+	app.Flag("block-stats-interval", "interval blocked decisions are flushed to the conf redis as hourly stats per rule and key, reported by guardian-cli block-report. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_BLOCK_STATS_INTERVAL").DurationVar(&c.Metrics.BlockStatsInterval)
+	app.Flag("key-metrics-interval", "interval decisions aggregated per rule and remote address are reported as the request.key metric, the top keys tagged individually and the rest as key:other. disabled if 0.").Default("0").OverrideDefaultFromEnvar("GUARDIAN_FLAG_KEY_METRICS_INTERVAL").DurationVar(&c.Metrics.KeyInterval)
+	app.Flag("key-metrics-top", "number of rule and remote address pairs with the most decisions reported individually each key-metrics-interval").Default("100").OverrideDefaultFromEnvar("GUARDIAN_FLAG_KEY_METRICS_TOP").IntVar(&c.Metrics.KeyTop)
+
+	app.Flag("slo-interval", "interval the burn rates of guardian's own slos are evaluated and reported at. disabled if 0.").Default("10s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SLO_INTERVAL").DurationVar(&c.SLO.Interval)
+	app.Flag("slo-latency-threshold", "duration decisions should be made within").Default("20ms").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SLO_LATENCY_THRESHOLD").DurationVar(&c.SLO.LatencyThreshold)
+	app.Flag("slo-latency-objective", "fraction of decisions that should be made within slo-latency-threshold. 0 disables the slo.").Default("0.99").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SLO_LATENCY_OBJECTIVE").Float64Var(&c.SLO.LatencyObjective)
+	app.Flag("slo-fail-open-objective", "fraction of decisions that should be made without an error failing them open. 0 disables the slo.").Default("0.999").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SLO_FAIL_OPEN_OBJECTIVE").Float64Var(&c.SLO.FailOpenObjective)
+	app.Flag("slo-conf-max-age", "longest the conf should go without being synced from redis").Default("1m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SLO_CONF_MAX_AGE").DurationVar(&c.SLO.ConfMaxAge)
+	app.Flag("slo-conf-objective", "fraction of time the conf should have been synced within slo-conf-max-age. 0 disables the slo, which isn't tracked with conf-backend consul.").Default("0.99").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SLO_CONF_OBJECTIVE").Float64Var(&c.SLO.ConfObjective)
+	app.Flag("slo-alert-burn-rate", "error budget burn rate over both the last 5m and 1h at which an slo alerts. 0 disables alerting.").Default("14.4").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SLO_ALERT_BURN_RATE").Float64Var(&c.SLO.AlertBurnRate)
+	app.Flag("slo-webhook-url", "url slo alerts are posted to as json, e.g. a slack incoming webhook. alerts are only logged and reported as metrics if empty.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SLO_WEBHOOK_URL").StringVar(&c.SLO.WebhookURL)
+	app.Flag("slo-webhook-timeout", "timeout of posting an slo alert").Default("5s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SLO_WEBHOOK_TIMEOUT").DurationVar(&c.SLO.WebhookTimeout)
+	app.Flag("throttle-webhook-url", "url the keys throttled by limit rules with notify set are posted to as json, in batches. disabled if empty.").OverrideDefaultFromEnvar("GUARDIAN_FLAG_THROTTLE_WEBHOOK_URL").StringVar(&c.ThrottleWebhook.URL)
+	app.Flag("throttle-webhook-timeout", "timeout of posting a batch of throttle notifications").Default("5s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_THROTTLE_WEBHOOK_TIMEOUT").DurationVar(&c.ThrottleWebhook.Timeout)
+	app.Flag("throttle-webhook-interval", "interval batches of throttle notifications are posted at").Default("1m").OverrideDefaultFromEnvar("GUARDIAN_FLAG_THROTTLE_WEBHOOK_INTERVAL").DurationVar(&c.ThrottleWebhook.Interval)
+	app.Flag("throttle-webhook-dedup", "period each rule and key is notified at most once in, across instances").Default("1h").OverrideDefaultFromEnvar("GUARDIAN_FLAG_THROTTLE_WEBHOOK_DEDUP").DurationVar(&c.ThrottleWebhook.Dedup)
+
+	app.Flag("profiler-enabled", "GCP Stackdriver Profiler enabled").Default("false").OverrideDefaultFromEnvar("GUARDIAN_FLAG_PROFILER_ENABLED").BoolVar(&c.Profiler.Enabled)
+	app.Flag("profiler-project-id", "GCP Stackdriver Profiler project ID").OverrideDefaultFromEnvar("GUARDIAN_FLAG_PROFILER_PROJECT_ID").StringVar(&c.Profiler.ProjectID)
+	app.Flag("profiler-service-name", "GCP Stackdriver Profiler service name").Default("guardian").OverrideDefaultFromEnvar("GUARDIAN_FLAG_PROFILER_SERVICE_NAME").StringVar(&c.Profiler.ServiceName)
+
+	return c
+}
+
+// applyConfigFile makes the values of the json config file given by configFile in args, or its environment
+// variable, the defaults of their flags, so that environment variables and flags override them
+func applyConfigFile(app *kingpin.Application, configFile *kingpin.FlagClause, args []string) error {
+	path := configFile.GetEnvarValue()
+	if ctx, err := app.ParseContext(args); err == nil { // errors are reported when args are parsed
+		for _, el := range ctx.Elements {
+			if el.Clause == configFile && el.Value != nil {
+				path = *el.Value
+			}
+		}
+	}
+
+	if len(path) == 0 {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	values := map[string]interface{}{}
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("error decoding config file %v: %v", path, err)
+	}
+
+	return applyConfigValues(app, reflect.TypeOf(config{}), values, "")
+}
+
+func applyConfigValues(app *kingpin.Application, t reflect.Type, values map[string]interface{}, prefix string) error {
+	for name, value := range values {
+		field, ok := configField(t, name)
+		if !ok {
+			return fmt.Errorf("unknown config field %v%v", prefix, name)
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			section, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("config field %v%v must be an object", prefix, name)
+			}
+
+			if err := applyConfigValues(app, field.Type, section, prefix+name+"."); err != nil {
+				return err
+			}
+			continue
+		}
+
+		defaults, err := configStrings(value, field.Type.Kind() == reflect.Slice)
+		if err != nil {
+			return fmt.Errorf("config field %v%v %v", prefix, name, err)
+		}
+
+		app.GetFlag(field.Tag.Get("flag")).Default(defaults...)
+	}
+
+	return nil
+}
+
+func configField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.Tag.Get("json") == name {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// configStrings returns a config file value as the strings its flag would be given
+func configStrings(value interface{}, list bool) ([]string, error) {
+	if values, ok := value.([]interface{}); ok {
+		if !list {
+			return nil, fmt.Errorf("must not be a list")
+		}
+
+		strs := []string{}
+		for _, v := range values {
+			s, err := configStrings(v, false)
+			if err != nil {
+				return nil, err
+			}
+			strs = append(strs, s...)
+		}
+		return strs, nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case json.Number:
+		return []string{v.String()}, nil
+	case bool:
+		return []string{fmt.Sprint(v)}, nil
+	default:
+		return nil, fmt.Errorf("must be a string, number, boolean, or list of them")
+	}
+}
+
+// printConfig writes c as json in the form of a config file, durations formatted as strings and secrets redacted
+func printConfig(w io.Writer, c *config) error {
+	b, err := json.MarshalIndent(configValues(reflect.ValueOf(*c)), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func configValues(v reflect.Value) map[string]interface{} {
+	values := map[string]interface{}{}
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		name := field.Tag.Get("json")
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			values[name] = configValues(value)
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			values[name] = time.Duration(value.Int()).String()
+		case field.Tag.Get("secret") == "true" && value.Len() > 0:
+			values[name] = "<redacted>"
+		case field.Type.Kind() == reflect.Slice && value.IsNil():
+			values[name] = reflect.MakeSlice(field.Type, 0, 0).Interface()
+		default:
+			values[name] = value.Interface()
+		}
+	}
+
+	return values
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
@@ -19,43 +23,37 @@ import (
 	"github.com/go-redis/redis"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 func main() {
 
-	logLevel := kingpin.Flag("log-level", "log level.").Short('l').Default("warn").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LOG_LEVEL").String()
-	address := kingpin.Flag("address", "network address to listen on.").Short('a').Default("0.0.0.0:3000").OverrideDefaultFromEnvar("GUARDIAN_FLAG_ADDRESS").String()
-	network := kingpin.Flag("network", "network to listen on. Must be \"tcp\", \"tcp4\", \"tcp6\", \"unix\" or \"unixpacket\".").Short('n').Default("tcp").OverrideDefaultFromEnvar("GUARDIAN_FLAG_NETWORK").String()
-	redisAddress := kingpin.Flag("redis-address", "host:port.").Short('r').OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_ADDRESS").String()
-	redisPoolSize := kingpin.Flag("redis-pool-size", "redis connection pool size").Short('p').Default("20").OverrideDefaultFromEnvar("GUARDIAN_FLAG_REDIS_POOL_SIZE").Int()
-	dogstatsdAddress := kingpin.Flag("dogstatsd-address", "host:port.").Short('d').OverrideDefaultFromEnvar("GUARDIAN_FLAG_DOGSTATSD_ADDRESS").String()
-	reportOnly := kingpin.Flag("report-only", "report only, do not block.").Default("false").Short('o').OverrideDefaultFromEnvar("GUARDIAN_FLAG_REPORT_ONLY").Bool()
-	reqLimit := kingpin.Flag("limit", "request limit per duration.").Short('q').Default("10").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT").Uint64()
-	limitDuration := kingpin.Flag("limit-duration", "duration to apply limit. supports time.ParseDuration format.").Short('y').Default("1s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT_DURATION").Duration()
-	limitEnabled := kingpin.Flag("limit-enabled", "rate limit enabled").Short('e').Default("true").OverrideDefaultFromEnvar("GUARDIAN_FLAG_LIMIT_ENABLED").Bool()
-	confUpdateInterval := kingpin.Flag("conf-update-interval", "interval to fetch new conf from redis").Short('i').Default("10s").OverrideDefaultFromEnvar("GUARDIAN_FLAG_CONF_UPDATE_INTERVAL").Duration()
-	dogstatsdTags := kingpin.Flag("dogstatsd-tag", "tag to add to dogstatsd metrics").Strings()
-	defaultWhitelist := kingpin.Flag("whitelist-cidr", "default cidr to whitelist until sync with redis occurs").Strings()
-	defaultBlacklist := kingpin.Flag("blacklist-cidr", "default cidr to blacklist until sync with redis occurs").Strings()
-	profilerEnabled := kingpin.Flag("profiler-enabled", "GCP Stackdriver Profiler enabled").Default("false").OverrideDefaultFromEnvar("GUARDIAN_FLAG_PROFILER_ENABLED").Bool()
-	profilerProjectID := kingpin.Flag("profiler-project-id", "GCP Stackdriver Profiler project ID").OverrideDefaultFromEnvar("GUARDIAN_FLAG_PROFILER_PROJECT_ID").String()
-	profilerServiceName := kingpin.Flag("profiler-service-name", "GCP Stackdriver Profiler service name").Default("guardian").OverrideDefaultFromEnvar("GUARDIAN_FLAG_PROFILER_SERVICE_NAME").String()
-	synchronous := kingpin.Flag("synchronous", "synchronously enforce ratelimit").Default("false").OverrideDefaultFromEnvar("GUARDIAN_FLAG_SYNCHRONOUS").Bool()
-	kingpin.Parse()
+	cfg := newConfig(kingpin.CommandLine)
+	configFile := kingpin.Flag("config-file", "json config file of flag values, grouped as printed by print-config. environment variables and flags override its values.").Short('c').OverrideDefaultFromEnvar("GUARDIAN_FLAG_CONFIG_FILE")
+	configFile.String() // read by applyConfigFile before the other flags are parsed, so they can override it
+	kingpin.Command("serve", "run the guardian server.").Default()
+	printConfigCmd := kingpin.Command("print-config", "print the effective config, merged from the config file, environment variables, and flags, as json.")
+
+	kingpin.FatalIfError(applyConfigFile(kingpin.CommandLine, configFile, os.Args[1:]), "invalid config file")
+	if kingpin.Parse() == printConfigCmd.FullCommand() {
+		kingpin.FatalIfError(printConfig(os.Stdout, cfg), "could not print config")
+		return
+	}
 
 	logger := logrus.StandardLogger()
-	level, err := logrus.ParseLevel(*logLevel)
+	level, err := logrus.ParseLevel(cfg.LogLevel)
 	if err != nil {
 		level = logrus.ErrorLevel
 	}
 
 	logger.Warnf("setting log level to %v", level)
 	logger.SetLevel(level)
+	logLevels := guardian.NewLogLevels(logger, "server", "limiter", "confstore", "whitelist")
 
-	l, err := net.Listen(*network, *address)
+	l, err := net.Listen(cfg.Server.Network, cfg.Server.Address)
 	if err != nil {
-		logger.WithError(err).Errorf("could not listen on network %s address %s", *network, *address)
+		logger.WithError(err).Errorf("could not listen on network %s address %s", cfg.Server.Network, cfg.Server.Address)
 		os.Exit(1)
 	}
 
@@ -63,18 +61,18 @@ func main() {
 
 	wg := sync.WaitGroup{}
 	var reporter guardian.MetricReporter
-	if len(*dogstatsdAddress) == 0 {
+	if len(cfg.Metrics.DogstatsdAddress) == 0 {
 		reporter = guardian.NullReporter{}
 	} else {
-		ddStatsd, err := statsd.NewBuffered(*dogstatsdAddress, 1000)
+		ddStatsd, err := statsd.NewBuffered(cfg.Metrics.DogstatsdAddress, 1000)
 
 		if err != nil {
-			logger.WithError(err).Errorf("could create dogstatsd client with address %s", *dogstatsdAddress)
+			logger.WithError(err).Errorf("could create dogstatsd client with address %s", cfg.Metrics.DogstatsdAddress)
 			os.Exit(1)
 		}
 
 		ddStatsd.Namespace = "guardian."
-		ddReporter := guardian.NewDataDogReporter(ddStatsd, *dogstatsdTags, logger.WithField("context", "datadog-metric-reporter"))
+		ddReporter := guardian.NewDataDogReporter(ddStatsd, cfg.Metrics.DogstatsdTags, cfg.Metrics.BufferSize, logger.WithField("context", "datadog-metric-reporter"))
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -83,53 +81,603 @@ func main() {
 		reporter = ddReporter
 	}
 
-	defaultLimit := guardian.Limit{Count: *reqLimit, Duration: *limitDuration, Enabled: *limitEnabled}
+	algorithm, err := guardian.ParseAlgorithm(cfg.Defaults.Limit.Algorithm)
+	if err != nil {
+		logger.WithError(err).Errorf("invalid limit algorithm %v", cfg.Defaults.Limit.Algorithm)
+		os.Exit(1)
+	}
+
+	if err := guardian.ValidateEnforcePercent(cfg.Defaults.Limit.EnforcePercent); err != nil {
+		logger.WithError(err).Errorf("invalid limit enforce percent %v", cfg.Defaults.Limit.EnforcePercent)
+		os.Exit(1)
+	}
+
+	defaultLimit := guardian.Limit{
+		Count:          cfg.Defaults.Limit.Count,
+		Duration:       cfg.Defaults.Limit.Duration,
+		Enabled:        cfg.Defaults.Limit.Enabled,
+		Algorithm:      algorithm,
+		EnforcePercent: cfg.Defaults.Limit.EnforcePercent,
+		Calendar:       guardian.CalendarWindow(cfg.Defaults.Limit.Calendar),
+		TimeZone:       cfg.Defaults.Limit.TimeZone,
+		Rollover:       cfg.Defaults.Limit.Rollover,
+	}
+
+	if err := guardian.ValidateCalendar(defaultLimit); err != nil {
+		logger.WithError(err).Errorf("invalid limit calendar %v", cfg.Defaults.Limit.Calendar)
+		os.Exit(1)
+	}
+
+	if err := guardian.ValidateDayBuckets(defaultLimit); err != nil {
+		logger.WithError(err).Errorf("invalid limit duration %v", cfg.Defaults.Limit.Duration)
+		os.Exit(1)
+	}
+
+	if err := guardian.ValidateRollover(defaultLimit); err != nil {
+		logger.WithError(err).Errorf("invalid limit rollover %v", cfg.Defaults.Limit.Rollover)
+		os.Exit(1)
+	}
+
+	defaultWhitelistCIDRs := guardian.IPNetsFromStrings(cfg.Defaults.WhitelistCIDRs, logger)
+	defaultBlacklistCIDRs := guardian.IPNetsFromStrings(cfg.Defaults.BlacklistCIDRs, logger)
+	defaultReportOnly := cfg.Defaults.ReportOnly
+
+	if len(cfg.Conf.DefaultFile) > 0 {
+		doc, err := guardian.LoadConfDocument(cfg.Conf.DefaultFile)
+		switch {
+		case os.IsNotExist(err):
+			logger.Infof("default conf file %v does not exist, using flags", cfg.Conf.DefaultFile)
+		case err != nil:
+			logger.WithError(err).Errorf("could not load default conf file %v", cfg.Conf.DefaultFile)
+			os.Exit(1)
+		default:
+			logger.Infof("loaded default conf file %v", cfg.Conf.DefaultFile)
+			if whitelist := doc.WhitelistCIDRs(); whitelist != nil {
+				defaultWhitelistCIDRs = whitelist
+			}
+			if blacklist := doc.BlacklistCIDRs(); blacklist != nil {
+				defaultBlacklistCIDRs = blacklist
+			}
+			if doc.Limit != nil {
+				defaultLimit, _ = doc.Limit.Limit() // validated when loaded
+			}
+			if doc.ReportOnly != nil {
+				defaultReportOnly = *doc.ReportOnly
+			}
+		}
+	}
+
 	logger.Infof("parsed default limit of %v", defaultLimit)
 
+	// counters may live on a dedicated redis, leaving redis-address to conf
+	limitRedisAddress := cfg.Redis.LimitAddress
+	if len(limitRedisAddress) == 0 {
+		limitRedisAddress = cfg.Redis.Address
+	}
+
 	redisOpts := &redis.Options{
-		Addr:     *redisAddress,
-		PoolSize: *redisPoolSize,
+		Addr:     limitRedisAddress,
+		PoolSize: cfg.Redis.PoolSize,
+	}
+
+	// conf is synced through its own pool, so a surge of counter traffic can't starve conf sync and vice versa
+	confRedisAddress := cfg.Conf.Redis.Address
+	if len(confRedisAddress) == 0 {
+		confRedisAddress = cfg.Redis.Address
+	}
+	if len(confRedisAddress) == 0 {
+		confRedisAddress = limitRedisAddress
+	}
+
+	confRedisOpts := &redis.Options{
+		Addr:     confRedisAddress,
+		PoolSize: cfg.Conf.Redis.PoolSize,
+	}
+
+	redisConnOpts := guardian.RedisConnOptions{
+		Username:      cfg.Redis.Username,
+		Password:      cfg.Redis.Password,
+		TLS:           cfg.Redis.TLS.Enabled,
+		TLSCAFile:     cfg.Redis.TLS.CAFile,
+		TLSServerName: cfg.Redis.TLS.ServerName,
+		TLSSkipVerify: cfg.Redis.TLS.SkipVerify,
+	}
+
+	var replicaRedisOpts *redis.Options
+	allOpts := []*redis.Options{redisOpts, confRedisOpts}
+	if len(cfg.Redis.ReplicaAddress) > 0 {
+		replicaRedisOpts = &redis.Options{
+			Addr:     cfg.Redis.ReplicaAddress,
+			PoolSize: cfg.Redis.PoolSize,
+		}
+		allOpts = append(allOpts, replicaRedisOpts)
 	}
 
-	logger.Infof("setting up redis client with address of %v and pool size of %v", redisOpts.Addr, redisOpts.PoolSize)
+	for _, opts := range allOpts {
+		if err := redisConnOpts.Apply(opts); err != nil {
+			logger.WithError(err).Error("invalid redis connection options")
+			os.Exit(1)
+		}
+	}
+
+	logger.Infof("setting up redis client with address of %v, pool size of %v, and tls %v", redisOpts.Addr, redisOpts.PoolSize, cfg.Redis.TLS.Enabled)
+	logger.Infof("setting up conf redis client with address of %v and pool size of %v", confRedisOpts.Addr, confRedisOpts.PoolSize)
+	confRedis := redis.NewClient(confRedisOpts)
+	hedging := guardian.RedisHedging{Threshold: cfg.Redis.HedgeThreshold}
+	if replicaRedisOpts != nil {
+		logger.Infof("setting up replica redis client with address of %v for hedged reads", replicaRedisOpts.Addr)
+		hedging.Replica = redis.NewClient(replicaRedisOpts)
+	}
 	redis := redis.NewClient(redisOpts)
 
-	redisConfStore := guardian.NewRedisConfStore(redis, guardian.IPNetsFromStrings(*defaultWhitelist, logger), guardian.IPNetsFromStrings(*defaultBlacklist, logger), defaultLimit, *reportOnly, logger.WithField("context", "redis-conf-provider"))
-	logger.Infof("starting cache update for conf store")
+	var confVerifyKey ed25519.PublicKey
+	if len(cfg.Conf.VerifyKeyFile) > 0 {
+		confVerifyKey, err = guardian.LoadConfVerifyKey(cfg.Conf.VerifyKeyFile)
+		if err != nil {
+			logger.WithError(err).Errorf("could not load conf verify key %v", cfg.Conf.VerifyKeyFile)
+			os.Exit(1)
+		}
+		logger.Infof("verifying synced conf with key %v", cfg.Conf.VerifyKeyFile)
+	}
+
+	var confStore guardian.ConfStore
+	switch cfg.Conf.Backend {
+	case "consul":
+		if confVerifyKey != nil {
+			logger.Error("signed conf is only supported with conf-backend redis")
+			os.Exit(1)
+		}
+
+		// blocking queries are held open by consul, so requests are only ended by stopping the store
+		consul := guardian.NewConsulClient(cfg.Conf.Consul.Address, cfg.Conf.Consul.Token, &http.Client{})
+		consulConfStore := guardian.NewConsulConfStore(consul, cfg.Conf.Consul.Key, defaultWhitelistCIDRs, defaultBlacklistCIDRs, defaultLimit, defaultReportOnly, cfg.Server.StaticResponses, logLevels.Logger("confstore").WithField("context", "consul-conf-provider"), reporter)
+		confStore = consulConfStore
+
+		logger.Infof("watching consul key %v at %v for conf", cfg.Conf.Consul.Key, cfg.Conf.Consul.Address)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consulConfStore.Run(cfg.Conf.UpdateInterval, stop)
+		}()
+	default:
+		redisConfStore := guardian.NewRedisConfStore(confRedis, defaultWhitelistCIDRs, defaultBlacklistCIDRs, defaultLimit, defaultReportOnly, confVerifyKey, cfg.Server.StaticResponses, logLevels.Logger("confstore").WithField("context", "redis-conf-provider"), reporter)
+		confStore = redisConfStore
+		if cfg.Conf.Migrate {
+			from, to, err := redisConfStore.Migrate()
+			if err != nil {
+				logger.WithError(err).Error("error migrating conf schema, continuing with existing conf")
+			} else if from != to {
+				logger.Infof("migrated conf schema from version %d to %d", from, to)
+			}
+		}
+
+		logger.Infof("starting cache update for conf store")
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			redisConfStore.RunSync(cfg.Conf.UpdateInterval, stop)
+		}()
+	}
+
+	var breaker *guardian.CircuitBreaker
+	if cfg.Redis.Circuit.FailureThreshold > 0 {
+		breaker = guardian.NewCircuitBreaker(cfg.Redis.Circuit.FailureThreshold, cfg.Redis.Circuit.OpenDuration)
+	}
+
+	redisCounter := guardian.NewRedisCounter(redis, cfg.Redis.Synchronous, breaker, hedging, logLevels.Logger("limiter").WithField("context", "redis-counter"), reporter)
+	if len(cfg.WarmBlockedKeys.From) > 0 {
+		warmBlockedKeys(redisCounter, cfg.WarmBlockedKeys.From, cfg.WarmBlockedKeys.Timeout, logger)
+	}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		redisConfStore.RunSync(*confUpdateInterval, stop)
+		redisCounter.Run(30*time.Second, stop)
 	}()
 
-	redisCounter := guardian.NewRedisCounter(redis, *synchronous, logger.WithField("context", "redis-counter"), reporter)
+	var leader guardian.LeaderProvider
+	if cfg.LeaderElection.Enabled {
+		hostname, _ := os.Hostname()
+		election := guardian.NewRedisLeaderElection(redis, fmt.Sprintf("%v:%d", hostname, os.Getpid()), cfg.LeaderElection.LeaseTTL, logger.WithField("context", "leader-election"), reporter)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			election.Run(stop)
+		}()
+		leader = election
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		redisCounter.Run(30*time.Second, stop)
+		redisCounter.RunJanitor(cfg.Redis.Janitor.Interval, cfg.Redis.Janitor.OrphanExpiration, leader, stop)
 	}()
 
-	whitelister := guardian.NewIPWhitelister(redisConfStore, logger.WithField("context", "ip-whitelister"), reporter)
-	blacklister := guardian.NewIPBlacklister(redisConfStore, logger.WithField("context", "ip-blacklister"), reporter)
-	rateLimiter := guardian.NewIPRateLimiter(redisConfStore, redisCounter, logger.WithField("context", "ip-rate-limiter"), reporter)
-	condFuncChain := guardian.DefaultCondChain(whitelister, blacklister, rateLimiter)
+	if cfg.Redis.Memory.Budget > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			redisCounter.RunMemoryBudget(cfg.Redis.Memory.Budget, cfg.Redis.Memory.MaxTTL, cfg.Redis.Memory.Interval, leader, stop)
+		}()
+	}
 
-	logger.Infof("starting server on %v", *address)
-	server := guardian.NewServer(condFuncChain, redisConfStore, logger.WithField("context", "server"), reporter)
-	grpcServer := rate_limit_grpc.NewRateLimitServer(server)
+	var clock guardian.Clock = guardian.LocalClock{}
+	if cfg.Redis.Time.Enabled {
+		redisClock := guardian.NewRedisClock(redis, logger.WithField("context", "redis-clock"))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			redisClock.Run(cfg.Redis.Time.SyncInterval, stop)
+		}()
+		clock = redisClock
+	}
 
+	var hostWhitelist guardian.WhitelistPrefixProvider
+	if cfg.WhitelistHosts.RefreshInterval > 0 {
+		hosts := guardian.NewHostWhitelist(confStore, net.DefaultResolver, cfg.WhitelistHosts.RefreshInterval, cfg.WhitelistHosts.LookupTimeout, logLevels.Logger("whitelist").WithField("context", "host-whitelist"), reporter)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hosts.Run(stop)
+		}()
+		hostWhitelist = hosts
+	}
+
+	whitelister := guardian.NewIPWhitelister(confStore, hostWhitelist, cfg.WhitelistCache.Size, cfg.WhitelistCache.TTL, logLevels.Logger("whitelist").WithField("context", "ip-whitelister"), reporter)
+	blacklister := guardian.NewIPBlacklister(confStore, logLevels.Logger("whitelist").WithField("context", "ip-blacklister"), reporter)
+	var bulkheads *guardian.Bulkheads
+	if cfg.Redis.BulkheadLimit > 0 {
+		bulkheads = guardian.NewBulkheads(cfg.Redis.BulkheadLimit, reporter)
+	}
+	rateLimiter := guardian.NewIPRateLimiter(confStore, redisCounter, clock, bulkheads, logLevels.Logger("limiter").WithField("context", "ip-rate-limiter"), reporter)
+	routeRateLimiter := guardian.NewRouteRateLimiter(confStore, redisCounter, clock, bulkheads, logLevels.Logger("limiter").WithField("context", "route-rate-limiter"), reporter)
+	var cardinalityGuard *guardian.KeyCardinalityGuard
+	if cfg.Rules.KeyCardinalityLimit > 0 {
+		cardinalityGuard = guardian.NewKeyCardinalityGuard(cfg.Rules.KeyCardinalityLimit, cfg.Rules.KeyCardinalityWindow, clock, logger.WithField("context", "key-cardinality-guard"), reporter)
+	}
+	ruleEvaluator := guardian.NewRuleEvaluator(confStore, redisCounter, clock, cardinalityGuard, bulkheads, logLevels.Logger("limiter").WithField("context", "rule-evaluator"), reporter)
+	conds := []guardian.CondRequestBlockerFunc{guardian.CondStopOnWhitelistFunc(whitelister), guardian.CondStopOnBlacklistFunc(blacklister)}
+
+	var feedbackPenalties *guardian.FeedbackPenalties
+	if cfg.Feedback.Threshold > 0 {
+		statuses := make(map[int]bool)
+		for _, status := range cfg.Feedback.Statuses {
+			statuses[status] = true
+		}
+
+		policy := guardian.FeedbackPolicy{Statuses: statuses, Threshold: cfg.Feedback.Threshold, Window: cfg.Feedback.Window, PenaltyDuration: cfg.Feedback.PenaltyDuration}
+		feedbackPenalties = guardian.NewFeedbackPenalties(redis, redisCounter, clock, policy, logger.WithField("context", "feedback-penalties"))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			feedbackPenalties.Run(cfg.Conf.UpdateInterval, stop)
+		}()
+
+		// penalized requests are counted against the reduced limit under keys of their own, and still by the rate limiter
+		throttledLimiter := guardian.NewIPRateLimiter(guardian.ScaledLimitProvider{Provider: confStore, Factor: cfg.Feedback.ThrottleFactor}, redisCounter, clock, bulkheads, logger.WithField("context", "feedback-rate-limiter"), reporter)
+		conds = append(conds, guardian.CondFeedbackFunc(feedbackPenalties, guardian.FeedbackAction(cfg.Feedback.Action), throttledLimiter.Limit, logger.WithField("context", "feedback")))
+	}
+
+	// passes are checked after the blacklist and feedback penalties, which a solved challenge doesn't lift
+	var challengePasses *guardian.ChallengePasses
+	if cfg.Challenge.PassDuration > 0 {
+		challengePasses = guardian.NewChallengePasses(redis, clock, cfg.Challenge.PassDuration, logger.WithField("context", "challenge-passes"))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			challengePasses.Run(cfg.Conf.UpdateInterval, stop)
+		}()
+
+		conds = append(conds, guardian.CondChallengePassFunc(challengePasses, logger.WithField("context", "challenge-passes")))
+	}
+
+	evaluateRules := ruleEvaluator.Evaluate
+	if len(cfg.Plan.URL) > 0 {
+		if err := guardian.ValidateRequestAttribute(cfg.Plan.Key); err != nil {
+			logger.WithError(err).Error("invalid plan key")
+			os.Exit(1)
+		}
+
+		if cfg.Plan.CacheSize <= 0 {
+			logger.Errorf("plan cache size %d must be positive", cfg.Plan.CacheSize)
+			os.Exit(1)
+		}
+
+		resolver := guardian.NewHTTPPlanResolver(cfg.Plan.URL, &http.Client{Timeout: cfg.Plan.Timeout})
+		planCache := guardian.NewPlanCache(resolver, cfg.Plan.CacheSize, cfg.Plan.CacheTTL, cfg.Plan.Timeout, logger.WithField("context", "plan-cache"), reporter)
+		evaluateRules = guardian.WithPlanTier(evaluateRules, planCache, cfg.Plan.Key)
+	}
+	if len(cfg.OpenAPI.SpecFile) > 0 {
+		operations, err := guardian.LoadOpenAPISpec(cfg.OpenAPI.SpecFile)
+		if err != nil {
+			logger.WithError(err).Errorf("could not load openapi spec %v", cfg.OpenAPI.SpecFile)
+			os.Exit(1)
+		}
+
+		logger.Infof("loaded %d operations from openapi spec %v", len(operations.Operations()), cfg.OpenAPI.SpecFile)
+		evaluateRules = guardian.WithOpenAPIOperation(evaluateRules, operations)
+	}
+	if len(cfg.InternalNetwork.CIDRs) > 0 {
+		networks, err := guardian.ParseInternalNetworks(cfg.InternalNetwork.CIDRs)
+		if err != nil {
+			logger.WithError(err).Error("invalid internal network")
+			os.Exit(1)
+		}
+
+		evaluateRules = guardian.WithInternalNetworks(evaluateRules, networks)
+	}
+	conds = append(conds, evaluateRules)
+
+	if len(cfg.Reputation.URL) > 0 {
+		provider := guardian.NewHTTPReputationProvider(cfg.Reputation.URL, &http.Client{Timeout: cfg.Reputation.Timeout})
+		reputationCache := guardian.NewReputationCache(provider, cfg.Reputation.CacheTTL, cfg.Reputation.Timeout, logger.WithField("context", "reputation-cache"), reporter)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reputationCache.Run(time.Minute, stop)
+		}()
+
+		// poorly scored requests are counted against the reduced limit under keys of their own, and still by the rate limiter
+		throttledLimiter := guardian.NewIPRateLimiter(guardian.ScaledLimitProvider{Provider: confStore, Factor: cfg.Reputation.ThrottleFactor}, redisCounter, clock, bulkheads, logger.WithField("context", "reputation-rate-limiter"), reporter)
+		thresholds := guardian.ReputationThresholds{BlockScore: cfg.Reputation.BlockScore, ThrottleScore: cfg.Reputation.ThrottleScore}
+		conds = append(conds, guardian.CondReputationFunc(reputationCache, thresholds, throttledLimiter.Limit, logger.WithField("context", "reputation")))
+	}
+
+	var reportOnlyProvider guardian.ReportOnlyProvider = confStore
+	if cfg.Server.Warmup.ReportOnly > 0 || cfg.Server.Warmup.Ramp > 0 {
+		reportOnlyProvider = guardian.NewWarmup(confStore, cfg.Server.Warmup.ReportOnly, cfg.Server.Warmup.Ramp, guardian.LocalClock{})
+	}
+
+	// SIGUSR1 puts this instance in report only mode regardless of the conf, SIGUSR2 returns it to the conf's mode
+	enforcementOverride := guardian.NewEnforcementOverride(reportOnlyProvider, logger.WithField("context", "enforcement-override"))
+	reportOnlyProvider = enforcementOverride
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		waitGracefulStop(grpcServer, stop)
+		overrideOnSignal(enforcementOverride, stop)
 	}()
 
-	if *profilerEnabled {
+	// the health server is created early so the admin server can report readiness from it
+	health := rate_limit_grpc.NewHealthServer()
+
+	streamingMethods, err := guardian.ParseGRPCStreamingMethods(cfg.GRPC.StreamingMethods)
+	if err != nil {
+		logger.WithError(err).Error("invalid grpc streaming method")
+		os.Exit(1)
+	}
+
+	// batches of the decisions api are rate limited together, with one round trip to redis
+	rateLimit := guardian.SkipGRPCStreams(guardian.LimitInBatches(rateLimiter.Limit), streamingMethods)
+	condFuncChain := guardian.CondChain(append(conds, guardian.CondStopOnBlockOrError(rateLimit), guardian.CondStopOnBlockOrError(routeRateLimiter.Limit))...)
+	if guardian.ServerMode(cfg.Server.Mode) == guardian.BlocklistServerMode {
+		logger.Info("serving in blocklist mode, enforcing the whitelist and blacklist only")
+		condFuncChain = guardian.ListsOnly(whitelister, blacklister, reporter)
+	}
+
+	if cfg.DecisionCache.Size > 0 {
+		decisionCache := guardian.NewDecisionCache(cfg.DecisionCache.Size, cfg.DecisionCache.MaxTTL, clock, reporter)
+		condFuncChain = guardian.CacheBlockedDecisions(condFuncChain, decisionCache)
+	}
+
+	decisionRate := guardian.NewDecisionRate(guardian.LocalClock{})
+	condFuncChain = guardian.CountDecisions(condFuncChain, decisionRate)
+
+	var sloTracker *guardian.SLOTracker
+	if cfg.SLO.Interval > 0 {
+		objectives := guardian.SLOObjectives{
+			LatencyThreshold: cfg.SLO.LatencyThreshold,
+			Latency:          cfg.SLO.LatencyObjective,
+			FailOpen:         cfg.SLO.FailOpenObjective,
+			ConfMaxAge:       cfg.SLO.ConfMaxAge,
+			ConfFreshness:    cfg.SLO.ConfObjective,
+			AlertBurnRate:    cfg.SLO.AlertBurnRate,
+		}
+		if cfg.Conf.Backend == "consul" {
+			objectives.ConfFreshness = 0 // consul conf is only applied when it changes, so its age isn't staleness
+		}
+
+		var alerter guardian.SLOAlerter
+		if len(cfg.SLO.WebhookURL) > 0 {
+			alerter = guardian.NewWebhookSLOAlerter(cfg.SLO.WebhookURL, &http.Client{Timeout: cfg.SLO.WebhookTimeout})
+		}
+
+		sloTracker = guardian.NewSLOTracker(objectives, confStore, alerter, guardian.LocalClock{}, logger.WithField("context", "slo-tracker"), reporter)
+		condFuncChain = guardian.TrackSLOs(condFuncChain, sloTracker)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sloTracker.Run(cfg.SLO.Interval, stop)
+		}()
+	}
+
+	guardian.NewVars(decisionRate, confStore, breaker, redis, confRedis, sloTracker).Publish() // served at /debug/vars of the admin server
+
+	var decisionPublisher *guardian.DecisionPublisher
+	if cfg.DecisionStream.Enabled {
+		decisionPublisher = guardian.NewDecisionPublisher(cfg.DecisionStream.SampleRate, cfg.DecisionStream.Buffer, clock, logger.WithField("context", "decision-publisher"), reporter)
+		condFuncChain = guardian.PublishDecisions(condFuncChain, decisionPublisher)
+	}
+
+	if cfg.Metrics.BlockStatsInterval > 0 {
+		blockStats := guardian.NewBlockStats(confRedis, clock, logger.WithField("context", "block-stats"))
+		condFuncChain = guardian.RecordBlocks(condFuncChain, blockStats)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			blockStats.Run(cfg.Metrics.BlockStatsInterval, stop)
+		}()
+	}
+
+	if cfg.Metrics.KeyInterval > 0 {
+		keyMetrics := guardian.NewKeyMetrics(cfg.Metrics.KeyTop, reporter)
+		condFuncChain = guardian.AggregateKeyMetrics(condFuncChain, keyMetrics)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keyMetrics.Run(cfg.Metrics.KeyInterval, stop)
+		}()
+	}
+
+	if len(cfg.ThrottleWebhook.URL) > 0 {
+		throttleNotifier := guardian.NewThrottleNotifier(cfg.ThrottleWebhook.URL, &http.Client{Timeout: cfg.ThrottleWebhook.Timeout}, confRedis, reportOnlyProvider, cfg.ThrottleWebhook.Dedup, clock, logger.WithField("context", "throttle-notifier"), reporter)
+		condFuncChain = guardian.NotifyThrottles(condFuncChain, throttleNotifier)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			throttleNotifier.Run(cfg.ThrottleWebhook.Interval, stop)
+		}()
+	}
+
+	var limitAnalyzer *guardian.LimitAnalyzer
+	if cfg.LimitAnalysis.Window > 0 {
+		limitAnalyzer = guardian.NewLimitAnalyzer(confStore, clock, cfg.LimitAnalysis.Window, cfg.LimitAnalysis.Margin)
+		condFuncChain = guardian.AnalyzeRequests(condFuncChain, limitAnalyzer)
+	}
+
+	var trafficProfiler *guardian.TrafficProfiler
+	if cfg.TrafficProfile.Window > 0 {
+		trafficProfiler = guardian.NewTrafficProfiler(confStore, clock, cfg.TrafficProfile.Window, reporter)
+		condFuncChain = guardian.ProfileTraffic(condFuncChain, trafficProfiler)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			trafficProfiler.Run(stop)
+		}()
+	}
+
+	exemptions := []guardian.Exemption{}
+	for _, s := range cfg.Server.Exemptions {
+		exemption, err := guardian.ParseExemption(s)
+		if err != nil {
+			logger.WithError(err).Error("invalid exemption")
+			os.Exit(1)
+		}
+		exemptions = append(exemptions, exemption)
+	}
+	condFuncChain = guardian.ExemptRequests(condFuncChain, exemptions, blacklister, reporter)
+
+	// resolved last so every limiter, and everything recording decisions, sees the client behind the cdn
+	if len(cfg.TrustedCDNs.CDNs) > 0 {
+		ranges, err := guardian.ParseCDNRanges(cfg.TrustedCDNs.Ranges)
+		if err != nil {
+			logger.WithError(err).Error("invalid trusted cdn range")
+			os.Exit(1)
+		}
+
+		cdnClientIP, err := guardian.NewCDNClientIP(cfg.TrustedCDNs.CDNs, ranges, &http.Client{Timeout: cfg.TrustedCDNs.Timeout}, logger.WithField("context", "cdn-client-ip"))
+		if err != nil {
+			logger.WithError(err).Error("invalid trusted cdn")
+			os.Exit(1)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cdnClientIP.Run(cfg.TrustedCDNs.RefreshInterval, cfg.TrustedCDNs.Timeout, stop)
+		}()
+		condFuncChain = guardian.WithCDNClientIP(condFuncChain, cdnClientIP)
+	}
+
+	if len(cfg.Admin.Address) > 0 {
+		admin := guardian.NewAdminServer(logger.WithField("context", "admin-server"))
+		admin.Handle("/debug/", http.DefaultServeMux) // net/http/pprof registers itself with the default mux
+		admin.Handle("/v1/blocked-keys", guardian.NewBlockedKeysHandler(redisCounter, logger.WithField("context", "blocked-keys-handler")))
+		admin.Handle("/v1/counters", guardian.NewCountersHandler(rateLimiter, logger.WithField("context", "counters-handler")))
+		admin.Handle("/readyz", guardian.NewReadyHandler(health, enforcementOverride, logger.WithField("context", "ready-handler")))
+		admin.Handle("/v1/log-levels", guardian.NewLogLevelsHandler(logLevels, logger.WithField("context", "log-levels-handler")))
+		admin.Handle("/v1/enforcement", guardian.NewEnforcementHandler(enforcementOverride, logger.WithField("context", "enforcement-handler")))
+		admin.Handle("/v1/simulate", guardian.NewSimulateHandler(confStore, logger.WithField("context", "simulate-handler")))
+
+		// batches are decided by the chain rate limit requests are, with the same exemptions and cdn client addresses
+		batchDecider := guardian.NewBatchDecider(condFuncChain, rateLimiter, reportOnlyProvider, logger.WithField("context", "batch-decider"))
+		admin.Handle("/v1/decisions", guardian.NewDecisionsHandler(batchDecider, logger.WithField("context", "decisions-handler"), reporter))
+
+		if feedbackPenalties != nil {
+			admin.Handle("/v1/feedback", guardian.NewFeedbackHandler(feedbackPenalties, logger.WithField("context", "feedback-handler")))
+		}
+
+		if challengePasses != nil {
+			admin.Handle("/v1/challenge-passes", guardian.NewChallengePassHandler(challengePasses, logger.WithField("context", "challenge-pass-handler")))
+		}
+
+		if limitAnalyzer != nil {
+			admin.Handle("/v1/limit-recommendations", guardian.NewRecommendationsHandler(limitAnalyzer, logger.WithField("context", "recommendations-handler")))
+		}
+
+		if trafficProfiler != nil {
+			admin.Handle("/v1/traffic-profile", guardian.NewTrafficProfileHandler(trafficProfiler, logger.WithField("context", "traffic-profile-handler")))
+		}
+
+		adminAllow, err := guardian.ParseCIDRs(cfg.Admin.AllowCIDRs)
+		if err != nil {
+			logger.WithError(err).Error("invalid admin allow cidr")
+			os.Exit(1)
+		}
+
+		adminDeny, err := guardian.ParseCIDRs(cfg.Admin.DenyCIDRs)
+		if err != nil {
+			logger.WithError(err).Error("invalid admin deny cidr")
+			os.Exit(1)
+		}
+
+		adminHandler := guardian.NewSourceFilter(admin, adminAllow, adminDeny, logger.WithField("context", "admin-source-filter"))
+		adminServer := &http.Server{Addr: cfg.Admin.Address, Handler: adminHandler}
+		go func() {
+			logger.Infof("starting admin server on %v", cfg.Admin.Address)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.WithError(err).Error("error running admin server")
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-stop
+			adminServer.Close()
+		}()
+	}
+
+	logger.Infof("starting server on %v", cfg.Server.Address)
+	server := guardian.NewServer(condFuncChain, reportOnlyProvider, cfg.Server.DebugToken, cfg.Server.BlockedHintMax, logLevels.Logger("server").WithField("context", "server"), reporter)
+	domainFilter := guardian.NewDomainFilter(server, cfg.Server.Domains, guardian.UnknownDomainAction(cfg.Server.UnknownDomainAction), logLevels.Logger("server").WithField("context", "domain-filter"), reporter)
+	limits := guardian.RequestLimits{MaxDescriptors: cfg.Server.Validation.MaxDescriptors, MaxEntries: cfg.Server.Validation.MaxEntries, MaxValueLength: cfg.Server.Validation.MaxValueLength, MaxHitsAddend: cfg.Server.Validation.MaxHitsAddend}
+	validator := guardian.NewRequestValidator(domainFilter, limits, guardian.InvalidRequestAction(cfg.Server.Validation.Action), logLevels.Logger("server").WithField("context", "request-validator"), reporter)
+	drainer := guardian.NewDrainer(validator, guardian.DrainDecision(cfg.Server.Drain.Decision), logLevels.Logger("server").WithField("context", "drainer"))
+	grpcServer := rate_limit_grpc.NewRateLimitServer(drainer, grpcServerOptions(cfg.GRPC.MaxRecvMsgSize, cfg.GRPC.MaxSendMsgSize, cfg.GRPC.RequestTimeout, cfg.GRPC.MaxConnectionAge, cfg.GRPC.MaxConnectionAgeGrace)...)
+	health.SetServingStatus(rate_limit_grpc.RateLimitServiceName, rate_limit_grpc.HealthCheckResponse_SERVING)
+	rate_limit_grpc.RegisterHealthServer(grpcServer, health)
+	if decisionPublisher != nil {
+		rate_limit_grpc.RegisterDecisionStreamServer(grpcServer, decisionPublisher)
+		health.SetServingStatus(rate_limit_grpc.DecisionStreamServiceName, rate_limit_grpc.HealthCheckResponse_SERVING)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		waitGracefulStop(grpcServer, health, drainer, cfg.Server.Drain.Delay, stop)
+	}()
+
+	if cfg.SelfTest.Interval > 0 {
+		domain := "guardian"
+		if len(cfg.Server.Domains) > 0 {
+			domain = cfg.Server.Domains[0]
+		}
+
+		hostname, _ := os.Hostname()
+		selfTest := guardian.NewSelfTest(drainer, redis, domain, cfg.SelfTest.RemoteAddress, cfg.SelfTest.Expect == "block", fmt.Sprintf("%v:%d", hostname, os.Getpid()), cfg.SelfTest.Timeout, logger.WithField("context", "self-test"), reporter)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			selfTest.Run(cfg.SelfTest.Interval, stop)
+		}()
+	}
+
+	if cfg.Profiler.Enabled {
 		config := profiler.Config{
-			Service:        *profilerServiceName,
+			Service:        cfg.Profiler.ServiceName,
 			ServiceVersion: version.Revision,
-			ProjectID:      *profilerProjectID,
+			ProjectID:      cfg.Profiler.ProjectID,
 			MutexProfiling: true,
 		}
 		if err := profiler.Start(config); err != nil {
@@ -144,10 +692,15 @@ func main() {
 
 	logger.Info("stopping server")
 
-	redis.Close()
 	close(stop)
 
-	wg.Wait()
+	wg.Wait() // before closing the clients, since block stats are flushed on stop
+
+	redis.Close()
+	confRedis.Close()
+	if hedging.Replica != nil {
+		hedging.Replica.Close()
+	}
 
 	logger.Info("goodbye")
 	if err != nil {
@@ -155,13 +708,80 @@ func main() {
 	}
 }
 
-func waitGracefulStop(server *grpc.Server, stop <-chan struct{}) {
+// grpcServerOptions returns the options tuning the grpc server, leaving the grpc defaults for those that are 0
+func grpcServerOptions(maxRecvMsgSize int, maxSendMsgSize int, requestTimeout time.Duration, maxConnectionAge time.Duration, maxConnectionAgeGrace time.Duration) []grpc.ServerOption {
+	opts := []grpc.ServerOption{}
+	if maxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(maxRecvMsgSize))
+	}
+
+	if maxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(maxSendMsgSize))
+	}
+
+	if requestTimeout > 0 {
+		opts = append(opts, grpc.UnaryInterceptor(rate_limit_grpc.RequestTimeoutInterceptor(requestTimeout)))
+	}
+
+	if maxConnectionAge > 0 {
+		params := keepalive.ServerParameters{MaxConnectionAge: maxConnectionAge, MaxConnectionAgeGrace: maxConnectionAgeGrace}
+		opts = append(opts, grpc.KeepaliveParams(params))
+	}
+
+	return opts
+}
+
+func overrideOnSignal(override *guardian.EnforcementOverride, stop <-chan struct{}) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case sig := <-sigCh:
+			override.SetReportOnly(sig == syscall.SIGUSR1)
+		}
+	}
+}
+
+// warmBlockedKeys caches the keys a peer has cached as blocked, so attackers a newly started instance hasn't counted
+// yet are blocked right away. Failing to reach the peer only delays blocking, so it isn't fatal.
+func warmBlockedKeys(counter *guardian.RedisCounter, peerURL string, timeout time.Duration, logger logrus.FieldLogger) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	keys, err := guardian.FetchPeerBlockedKeys(ctx, &http.Client{}, peerURL)
+	if err != nil {
+		logger.WithError(err).Warn("could not warm blocked keys from peer, continuing without them")
+		return
+	}
+
+	logger.Infof("warmed %d blocked keys from %v", counter.WarmBlockedKeys(keys), peerURL)
+}
+
+// waitGracefulStop stops server gracefully on a shutdown signal, first draining for drainDelay: failing health checks
+// and answering requests with the drainer's decision while envoy rebalances. Stopping sends GOAWAY on every
+// connection and closes the listener.
+func waitGracefulStop(server *grpc.Server, health *rate_limit_grpc.HealthServer, drainer *guardian.Drainer, drainDelay time.Duration, stop <-chan struct{}) {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
+	signaled := false
 	select {
 	case <-stop:
 	case <-sigCh:
+		signaled = true
+	}
+
+	health.Shutdown() // fail health checks while draining
+	if signaled && drainDelay > 0 {
+		drainer.Drain()
+		select {
+		case <-time.After(drainDelay):
+		case <-sigCh: // a second signal skips the rest of the delay
+		}
 	}
 
 	server.GracefulStop()
@@ -0,0 +1,47 @@
+package guardian
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewAdminServer creates a new AdminServer
+func NewAdminServer(logger logrus.FieldLogger) *AdminServer {
+	return &AdminServer{mux: http.NewServeMux(), logger: logger}
+}
+
+// AdminServer is an HTTP server exposing administrative and introspection endpoints, separate from the
+// rate limit service that Envoy talks to
+type AdminServer struct {
+	mux    *http.ServeMux
+	logger logrus.FieldLogger
+}
+
+// Handle registers handler for pattern
+func (a *AdminServer) Handle(pattern string, handler http.Handler) {
+	a.logger.Debugf("registering admin handler for %v", pattern)
+	a.mux.Handle(pattern, handler)
+}
+
+func (a *AdminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.logger.Debugf("received admin request %v %v", r.Method, r.URL)
+	a.mux.ServeHTTP(w, r)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}, logger logrus.FieldLogger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.WithError(err).Error("error writing json response")
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error, logger logrus.FieldLogger) {
+	writeJSON(w, status, errorResponse{Error: err.Error()}, logger)
+}
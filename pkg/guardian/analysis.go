@@ -0,0 +1,186 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// globalRoute is the route requests are analyzed under for recommending the global limit
+const globalRoute = "*"
+
+// recommendationPercentile is the percentile of client request rates a recommended limit allows
+const recommendationPercentile = 0.999
+
+// analyzerMaxClients is the number of distinct clients counted per route in a window, bounding memory under
+// attack. Requests of further clients aren't counted until the next window.
+const analyzerMaxClients = 100000
+
+// NewLimitAnalyzer creates a new LimitAnalyzer counting requests in windows of window and recommending limits margin
+// (e.g. 0.2 for 20%) above the observed rates
+func NewLimitAnalyzer(routes RouteLimitProvider, clock Clock, window time.Duration, margin float64) *LimitAnalyzer {
+	return &LimitAnalyzer{
+		routes:     routes,
+		clock:      clock,
+		window:     window,
+		margin:     margin,
+		current:    make(map[string]map[string]uint64),
+		histograms: make(map[string]map[uint64]uint64),
+	}
+}
+
+// LimitAnalyzer tracks the distribution of clients' request rates, overall and for each route with a limit, to
+// recommend limit values. A client's requests are counted in fixed windows, and a limit allowing the 99.9th
+// percentile of client windows plus a margin is recommended, so the busiest 0.1% of clients, which are likely
+// abusive, don't skew the recommendation.
+type LimitAnalyzer struct {
+	routes RouteLimitProvider
+	clock  Clock
+	window time.Duration
+	margin float64
+
+	mu          sync.Mutex
+	windowStart int64
+	current     map[string]map[string]uint64 // route to client to count in the current window
+	histograms  map[string]map[uint64]uint64 // route to a client's count in a window to the number of such windows
+}
+
+// LimitRecommendation is a recommended limit for a route, or the global limit if Route is "*"
+type LimitRecommendation struct {
+	Route string `json:"route"`
+	// Samples is the number of client windows observed
+	Samples  uint64 `json:"samples"`
+	P999     uint64 `json:"p99_9"`
+	Max      uint64 `json:"max"`
+	Count    uint64 `json:"count"`
+	Duration string `json:"duration"`
+}
+
+// Record counts request towards its client's current window
+func (a *LimitAnalyzer) Record(request Request) {
+	routes := []string{globalRoute}
+	if routeLimit, ok := a.routes.GetRouteMatcher().MatchRequest(request); ok {
+		routes = append(routes, routeLimit.Route.String())
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rollover()
+	for _, route := range routes {
+		clients, ok := a.current[route]
+		if !ok {
+			clients = make(map[string]uint64)
+			a.current[route] = clients
+		}
+
+		if _, ok := clients[request.RemoteAddress]; !ok && len(clients) >= analyzerMaxClients {
+			continue
+		}
+		clients[request.RemoteAddress] += uint64(request.Hits())
+	}
+}
+
+// rollover adds the counts of the current window to the histograms once it has ended. It must be called with mu
+// held.
+func (a *LimitAnalyzer) rollover() {
+	windowStart := a.clock.Now().UnixNano() / int64(a.window) * int64(a.window)
+	if windowStart == a.windowStart {
+		return
+	}
+
+	for route, clients := range a.current {
+		histogram, ok := a.histograms[route]
+		if !ok {
+			histogram = make(map[uint64]uint64)
+			a.histograms[route] = histogram
+		}
+
+		for _, count := range clients {
+			histogram[count]++
+		}
+	}
+
+	a.windowStart = windowStart
+	a.current = make(map[string]map[string]uint64)
+}
+
+// Recommendations returns a recommended limit for each route observed in a completed window, sorted by route
+func (a *LimitAnalyzer) Recommendations() []LimitRecommendation {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rollover()
+	recommendations := []LimitRecommendation{}
+	for route, histogram := range a.histograms {
+		counts := make([]uint64, 0, len(histogram))
+		samples := uint64(0)
+		for count, n := range histogram {
+			counts = append(counts, count)
+			samples += n
+		}
+		sort.Slice(counts, func(i, j int) bool { return counts[i] < counts[j] })
+
+		// nearest rank percentile
+		rank := uint64(math.Ceil(recommendationPercentile * float64(samples)))
+		p999, seen := uint64(0), uint64(0)
+		for _, count := range counts {
+			seen += histogram[count]
+			if seen >= rank {
+				p999 = count
+				break
+			}
+		}
+
+		recommendations = append(recommendations, LimitRecommendation{
+			Route:    route,
+			Samples:  samples,
+			P999:     p999,
+			Max:      counts[len(counts)-1],
+			Count:    uint64(math.Ceil(float64(p999) * (1 + a.margin))),
+			Duration: a.window.String(),
+		})
+	}
+
+	sort.Slice(recommendations, func(i, j int) bool { return recommendations[i].Route < recommendations[j].Route })
+	return recommendations
+}
+
+// AnalyzeRequests wraps f, recording each request it decides in analyzer. Blocked requests are recorded too, so
+// recommendations aren't capped by the limits in place.
+func AnalyzeRequests(f RequestBlockerFunc, analyzer *LimitAnalyzer) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		analyzer.Record(r)
+		return f(c, r)
+	}
+}
+
+// NewRecommendationsHandler creates a new RecommendationsHandler
+func NewRecommendationsHandler(analyzer *LimitAnalyzer, logger logrus.FieldLogger) *RecommendationsHandler {
+	return &RecommendationsHandler{analyzer: analyzer, logger: logger}
+}
+
+// RecommendationsHandler is an admin HTTP handler reporting the limits recommended by a LimitAnalyzer
+type RecommendationsHandler struct {
+	analyzer *LimitAnalyzer
+	logger   logrus.FieldLogger
+}
+
+type recommendationsResponse struct {
+	Recommendations []LimitRecommendation `json:"recommendations"`
+}
+
+func (h *RecommendationsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method), h.logger)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, recommendationsResponse{Recommendations: h.analyzer.Recommendations()}, h.logger)
+}
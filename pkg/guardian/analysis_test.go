@@ -0,0 +1,66 @@
+package guardian
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLimitAnalyzerRecommendations(t *testing.T) {
+	limit := Limit{Count: 1, Duration: time.Second, Enabled: true}
+	routes := &FakeRouteLimitProvider{routeLimits: []RouteLimit{{Route: mustParseRoutePattern(t, "/users/{id}"), Limit: LimitOverrideFromLimit(limit)}}}
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	analyzer := NewLimitAnalyzer(routes, clock, time.Minute, 0.5)
+
+	// 1000 clients making 1 to 10 requests each, and one abusive client making 1000
+	for i := 0; i < 1000; i++ {
+		for j := 0; j <= i%10; j++ {
+			analyzer.Record(Request{RemoteAddress: fmt.Sprintf("10.0.%d.%d", i/256, i%256), Path: "/"})
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		analyzer.Record(Request{RemoteAddress: "192.168.1.2", Path: "/users/1"})
+	}
+
+	if got := analyzer.Recommendations(); len(got) != 0 {
+		t.Fatalf("expected no recommendations before a window completes, received: %v", got)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	expected := []LimitRecommendation{
+		{Route: "*", Samples: 1001, P999: 10, Max: 1000, Count: 15, Duration: "1m0s"},
+		{Route: "/users/{id}", Samples: 1, P999: 1000, Max: 1000, Count: 1500, Duration: "1m0s"},
+	}
+
+	if diff := cmp.Diff(expected, analyzer.Recommendations()); diff != "" {
+		t.Errorf("unexpected recommendations (-want +got):\n%s", diff)
+	}
+}
+
+func TestRecommendationsHandler(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	analyzer := NewLimitAnalyzer(&FakeRouteLimitProvider{}, clock, time.Minute, 0)
+	analyzer.Record(Request{RemoteAddress: "192.168.1.2", Path: "/"})
+	clock.now = clock.now.Add(time.Minute)
+
+	rec := httptest.NewRecorder()
+	NewRecommendationsHandler(analyzer, TestingLogger).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/limit-recommendations", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %v received: %v", http.StatusOK, rec.Code)
+	}
+
+	res := recommendationsResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	expected := []LimitRecommendation{{Route: "*", Samples: 1, P999: 1, Max: 1, Count: 1, Duration: "1m0s"}}
+	if diff := cmp.Diff(expected, res.Recommendations); diff != "" {
+		t.Errorf("unexpected recommendations (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,166 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const baselineNamespace = "baseline"
+
+// maxBaselineWindows bounds the windows of a baseline's period, each a field of the hash counting a key's requests
+const maxBaselineWindows = 1440
+
+// Baseline raises a rule's limit for each key to Multiplier times the key's average count per window over the
+// Period before the current window, so keys are blocked for exceeding their own usual traffic rather than a globally
+// tuned count. The rule's limit count is the least count allowed, so keys without history aren't blocked at once.
+type Baseline struct {
+	Multiplier float64
+	Period     time.Duration
+}
+
+// BaselineDocument is the serializable form of a Baseline
+type BaselineDocument struct {
+	Multiplier float64 `json:"multiplier"`
+	Period     string  `json:"period"`
+}
+
+// BaselineDocumentFromBaseline converts a Baseline to a BaselineDocument
+func BaselineDocumentFromBaseline(b Baseline) BaselineDocument {
+	return BaselineDocument{Multiplier: b.Multiplier, Period: b.Period.String()}
+}
+
+// Baseline converts the document to a Baseline of limit
+func (bd BaselineDocument) Baseline(limit Limit) (Baseline, error) {
+	period, err := time.ParseDuration(bd.Period)
+	if err != nil {
+		return Baseline{}, errors.Wrap(err, "error parsing baseline period")
+	}
+
+	b := Baseline{Multiplier: bd.Multiplier, Period: period}
+	if err := ValidateBaseline(b, limit); err != nil {
+		return Baseline{}, err
+	}
+
+	return b, nil
+}
+
+// ValidateBaseline returns an error if the multiplier is less than 1, if the period isn't a whole number of limit's
+// windows up to maxBaselineWindows, or if limit doesn't count in plain fixed windows
+func ValidateBaseline(b Baseline, limit Limit) error {
+	if b.Multiplier < 1 || math.IsInf(b.Multiplier, 0) || math.IsNaN(b.Multiplier) {
+		return fmt.Errorf("baseline multiplier %v must be at least 1", b.Multiplier)
+	}
+
+	if err := validatePlainFixedWindows(limit, "baselines"); err != nil {
+		return err
+	}
+
+	if b.Period < limit.Duration || b.Period%limit.Duration != 0 {
+		return fmt.Errorf("baseline period %v must be a whole number of limit durations of %v", b.Period, limit.Duration)
+	}
+
+	if windows := b.windows(limit); windows > maxBaselineWindows {
+		return fmt.Errorf("baseline period %v spans %d windows, more than the max of %d", b.Period, windows, maxBaselineWindows)
+	}
+
+	return nil
+}
+
+func (b Baseline) String() string {
+	return fmt.Sprintf("%vx the %v average", b.Multiplier, b.Period)
+}
+
+// windows returns the number of limit's windows in the period
+func (b Baseline) windows(limit Limit) int {
+	return int(b.Period / limit.Duration)
+}
+
+// threshold returns the count a key whose last windows windows counted sum is allowed, at least count
+func (b Baseline) threshold(count uint64, sum uint64, windows int) uint64 {
+	scaled := b.Multiplier * float64(sum) / float64(windows)
+	if scaled >= math.MaxUint64 {
+		return math.MaxUint64
+	}
+
+	if threshold := uint64(scaled); threshold > count {
+		return threshold
+	}
+
+	return count
+}
+
+// BaselineCounter is a Counter that is also capable of counting keys against their baseline
+type BaselineCounter interface {
+	Counter
+
+	// IncrBaseline counts amount against the bucket of window in the buckets for key, returning its count and the
+	// sum of the buckets of the windows windows before it. The amount is only added to the bucket if the count is
+	// within baseline's threshold of minCount and the sum, so blocked requests don't raise the key's baseline.
+	IncrBaseline(context context.Context, key string, amount uint, window int64, windows int, baseline Baseline, minCount uint64, expireIn time.Duration) (uint64, uint64, error)
+}
+
+// baselineScript atomically counts against the bucket of the current window of a hash of window numbers to counts,
+// removing buckets that have left the period, and sums the buckets of the period before the current window. The
+// amount is only added to the bucket if the count is within the threshold, so the buckets only count allowed requests.
+// KEYS[1] buckets key
+// ARGV[1] current window number, ARGV[2] windows in the period, ARGV[3] amount to add, ARGV[4] expiration in seconds,
+// ARGV[5] baseline multiplier, ARGV[6] least threshold
+var baselineScript = redis.NewScript(`
+local window = tonumber(ARGV[1])
+local windows = tonumber(ARGV[2])
+
+local buckets = redis.call("HGETALL", KEYS[1])
+local sum = 0
+local current = 0
+for i = 1, #buckets, 2 do
+	local bucket = tonumber(buckets[i])
+	if bucket < window - windows then
+		redis.call("HDEL", KEYS[1], buckets[i])
+	elseif bucket < window then
+		sum = sum + tonumber(buckets[i + 1])
+	elseif bucket == window then
+		current = tonumber(buckets[i + 1])
+	end
+end
+
+local threshold = math.max(math.floor(tonumber(ARGV[5]) * sum / windows), tonumber(ARGV[6]))
+local count = current + tonumber(ARGV[3])
+if count <= threshold then
+	redis.call("HINCRBY", KEYS[1], ARGV[1], ARGV[3])
+end
+redis.call("EXPIRE", KEYS[1], ARGV[4])
+
+return {count, sum}
+`)
+
+func (rs *RedisCounter) IncrBaseline(context context.Context, key string, amount uint, window int64, windows int, baseline Baseline, minCount uint64, expireIn time.Duration) (uint64, uint64, error) {
+	return rs.runPairScript("baseline", baselineScript, []string{key}, window, windows, amount, expireSeconds(expireIn), baseline.Multiplier, minCount)
+}
+
+// incrBaseline counts incrBy against the window of key containing now, returning the count and limit with its count
+// raised to the key's baseline
+func incrBaseline(context context.Context, counter Counter, key string, limit Limit, baseline Baseline, incrBy uint, now time.Time) (uint64, Limit, error) {
+	buckets, ok := counter.(BaselineCounter)
+	if !ok {
+		return 0, limit, fmt.Errorf("counter does not support baselines")
+	}
+
+	windows := baseline.windows(limit)
+	window := windowNumber(limit, now)
+
+	// buckets are kept until they leave the period of the window after the current one
+	expireIn := time.Duration(windows+1) * limit.Duration
+	count, sum, err := buckets.IncrBaseline(context, NamespacedKey(baselineNamespace, key), incrBy, window, windows, baseline, limit.Count, expireIn)
+	if err != nil {
+		return 0, limit, err
+	}
+
+	limit.Count = baseline.threshold(limit.Count, sum, windows)
+	tracef(context, "baseline of %v counted %d over the last %d windows, allowing %d", key, sum, windows, limit.Count)
+	return count, limit, nil
+}
@@ -0,0 +1,106 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRedisCounterIncrBaselineSumsPeriod(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	tests := []struct {
+		name      string
+		window    int64
+		amount    uint
+		wantCount uint64
+		wantSum   uint64
+	}{
+		{name: "First", window: 100, amount: 2, wantCount: 2, wantSum: 0},
+		{name: "SameWindow", window: 100, amount: 1, wantCount: 3, wantSum: 0},
+		{name: "NextWindow", window: 101, amount: 1, wantCount: 1, wantSum: 3},
+		{name: "LastWindowOfPeriod", window: 103, amount: 0, wantCount: 0, wantSum: 4},
+		{name: "FirstWindowLeftPeriod", window: 104, amount: 5, wantCount: 5, wantSum: 1},
+		{name: "AllLeftPeriod", window: 110, amount: 1, wantCount: 1, wantSum: 0},
+		{name: "OverThreshold", window: 110, amount: 10, wantCount: 11, wantSum: 0},
+		{name: "OverThresholdNotCounted", window: 111, amount: 0, wantCount: 0, wantSum: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count, sum, err := c.IncrBaseline(context.Background(), "buckets", test.amount, test.window, 3, Baseline{Multiplier: 1, Period: 3 * time.Minute}, 5, time.Minute)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if count != test.wantCount || sum != test.wantSum {
+				t.Errorf("expected: (%v, %v) received: (%v, %v)", test.wantCount, test.wantSum, count, sum)
+			}
+		})
+	}
+}
+
+func TestRuleEvaluatorBaseline(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	doc := RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Count: 2, Duration: "1m", Enabled: true}, Baseline: &BaselineDocument{Multiplier: 10, Period: "5m0s"}}
+	rules := []Rule{mustParseRule(t, "outliers", doc)}
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, c, clock, nil, nil, TestingLogger, NullReporter{})
+	req := Request{RemoteAddress: "192.168.1.2"}
+
+	blocks := func(n int) int {
+		blocked := 0
+		for i := 0; i < n; i++ {
+			_, b, _, err := re.Evaluate(context.Background(), req)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			if b {
+				blocked++
+			}
+		}
+		return blocked
+	}
+
+	// without history the limit count applies
+	if got := blocks(4); got != 2 {
+		t.Fatalf("expected 2 requests over the limit count to be blocked, received: %d", got)
+	}
+
+	// the 2 requests allowed over 5 windows average 0.4, allowing 10x that, as blocked requests don't raise it
+	clock.now = clock.now.Add(time.Minute)
+	if got := blocks(9); got != 5 {
+		t.Fatalf("expected 5 requests over the baseline to be blocked, received: %d", got)
+	}
+
+	if got := RuleDocumentFromRule(rules[0]); got.Baseline == nil || *got.Baseline != *doc.Baseline {
+		t.Fatalf("expected rule document with baseline %v received: %v", doc.Baseline, got.Baseline)
+	}
+}
+
+func TestBaselineDocumentRejectsInvalid(t *testing.T) {
+	limit := Limit{Count: 10, Duration: time.Minute, Enabled: true}
+	tests := []struct {
+		name  string
+		doc   BaselineDocument
+		limit Limit
+	}{
+		{name: "MultiplierTooSmall", doc: BaselineDocument{Multiplier: 0.5, Period: "1h"}, limit: limit},
+		{name: "InvalidPeriod", doc: BaselineDocument{Multiplier: 20, Period: "hour"}, limit: limit},
+		{name: "PeriodNotWholeWindows", doc: BaselineDocument{Multiplier: 20, Period: "90s"}, limit: limit},
+		{name: "TooManyWindows", doc: BaselineDocument{Multiplier: 20, Period: "48h"}, limit: limit},
+		{name: "LeakyBucket", doc: BaselineDocument{Multiplier: 20, Period: "1h"}, limit: Limit{Count: 10, Duration: time.Minute, Algorithm: LeakyBucketAlgorithm}},
+		{name: "Rollover", doc: BaselineDocument{Multiplier: 20, Period: "1h"}, limit: Limit{Count: 10, Duration: time.Minute, Rollover: 5}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.doc.Baseline(test.limit); err == nil {
+				t.Fatal("expected error but received nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const maxBatchSize = 1000
+
+// BatchLimiter rate limits a batch of requests at once
+type BatchLimiter interface {
+	LimitBatch(context context.Context, requests []Request) ([]LimitResult, error)
+}
+
+// Decision is the outcome of evaluating a single request of a batch
+type Decision struct {
+	Blocked   bool
+	Remaining uint32
+	Err       error
+	// Response is the static response a blocked request should be answered with, nil for a 429
+	Response *StaticResponse
+	// Reason is why the request was blocked, in the format of the BlockReasonHeader
+	Reason string
+}
+
+// DefaultBatchDecider is the batch equivalent of DefaultCondChain, performing the following checks for every
+// request: whitelist, blacklist, rate limit.
+func DefaultBatchDecider(whitelister *IPWhitelister, blacklister *IPBlacklister, rateLimiter *IPRateLimiter, reportOnlyProvider ReportOnlyProvider, logger logrus.FieldLogger) *BatchDecider {
+	condWhitelistFunc := CondStopOnWhitelistFunc(whitelister)
+	condBlacklistFunc := CondStopOnBlacklistFunc(blacklister)
+	condRatelimitFunc := CondStopOnBlockOrError(LimitInBatches(rateLimiter.Limit))
+	return NewBatchDecider(CondChain(condWhitelistFunc, condBlacklistFunc, condRatelimitFunc), rateLimiter, reportOnlyProvider, logger)
+}
+
+// NewBatchDecider creates a new BatchDecider. Each request is decided by chain, the same chain that decides rate limit
+// requests, and the requests of a batch that reach the rate limiter wrapped by LimitInBatches in chain are rate limited
+// together by limiter.
+func NewBatchDecider(chain RequestBlockerFunc, limiter BatchLimiter, reportOnlyProvider ReportOnlyProvider, logger logrus.FieldLogger) *BatchDecider {
+	return &BatchDecider{chain: chain, limiter: limiter, roProvider: reportOnlyProvider, logger: logger}
+}
+
+// BatchDecider evaluates batches of requests for consumers, such as queue workers, that want to consume quota for
+// many requests in a single call
+type BatchDecider struct {
+	chain      RequestBlockerFunc
+	limiter    BatchLimiter
+	roProvider ReportOnlyProvider
+	logger     logrus.FieldLogger
+}
+
+// Decide returns a decision for each request in requests. The requests are decided concurrently, each waiting at the
+// rate limiter until every other request has either reached it or been decided, so the rate limiter is called once.
+func (b *BatchDecider) Decide(c context.Context, requests []Request) []Decision {
+	decisions := make([]Decision, len(requests))
+	batch := &pendingBatch{limiter: b.limiter, context: c, outstanding: len(requests)}
+
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			slot := &batchSlot{batch: batch, index: i}
+			hint := NewDecisionHint()
+			rc := WithDecisionHint(context.WithValue(c, batchSlotKey{}, slot), hint)
+
+			blocked, remaining, err := b.chain(rc, requests[i])
+			slot.arrive()
+			if err != nil {
+				decisions[i] = Decision{Blocked: blocked, Remaining: 0, Err: err}
+				return
+			}
+
+			decisions[i] = Decision{Blocked: blocked, Remaining: remaining}
+			if blocked {
+				decisions[i].Response = hint.StaticResponse()
+				if reason := hint.BlockReason(); reason != nil {
+					decisions[i].Reason = reason.String()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range decisions {
+		if !reportOnlyForRequest(b.roProvider, requests[i]) {
+			continue
+		}
+
+		if decisions[i].Blocked {
+			b.logger.Infof("would block on request %v", requests[i])
+		}
+		decisions[i].Blocked = false
+		decisions[i].Response = nil
+		decisions[i].Reason = ""
+	}
+
+	return decisions
+}
+
+type batchSlotKey struct{}
+
+// LimitInBatches wraps the rate limiter f of a chain decided by a BatchDecider, so the requests of a batch that reach
+// it are rate limited together by the BatchDecider's BatchLimiter. Requests decided one at a time are limited by f.
+func LimitInBatches(f RequestBlockerFunc) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		slot, ok := c.Value(batchSlotKey{}).(*batchSlot)
+		if !ok || slot.arrived {
+			return f(c, r)
+		}
+
+		result, err := slot.limit(r)
+		if err != nil {
+			return false, 0, err
+		}
+
+		if result.Blocked {
+			tracef(c, "rate limited in a batch")
+			hintBlockReason(c, RateLimitBlockReason, "")
+		}
+		return result.Blocked, result.Remaining, nil
+	}
+}
+
+// pendingBatch collects the requests of a batch waiting to be rate limited
+type pendingBatch struct {
+	limiter BatchLimiter
+	context context.Context
+
+	mu sync.Mutex
+	// outstanding is the number of requests that have neither reached the rate limiter nor been decided
+	outstanding int
+	waiting     []*batchSlot
+}
+
+// batchSlot is a single request of a batch
+type batchSlot struct {
+	batch *pendingBatch
+	index int
+	// arrived is set once the request has reached the rate limiter or been decided, only by its own goroutine
+	arrived bool
+
+	request Request
+	result  LimitResult
+	err     error
+	done    chan struct{}
+}
+
+// limit waits for the rest of the batch, then returns the result of rate limiting request with it
+func (s *batchSlot) limit(request Request) (LimitResult, error) {
+	s.request = request
+	s.done = make(chan struct{})
+
+	s.batch.mu.Lock()
+	s.batch.waiting = append(s.batch.waiting, s)
+	s.batch.mu.Unlock()
+
+	s.arrive()
+	<-s.done
+	return s.result, s.err
+}
+
+// arrive records that the request no longer holds up the batch, rate limiting the waiting requests if it was the last
+func (s *batchSlot) arrive() {
+	if s.arrived {
+		return
+	}
+	s.arrived = true
+
+	b := s.batch
+	b.mu.Lock()
+	b.outstanding--
+	if b.outstanding > 0 || len(b.waiting) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	waiting := b.waiting
+	b.waiting = nil
+	b.mu.Unlock()
+
+	// in the order of the batch, regardless of the order the requests reached the rate limiter in
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].index < waiting[j].index })
+	requests := make([]Request, len(waiting))
+	for i, w := range waiting {
+		requests[i] = w.request
+	}
+
+	results, err := b.limiter.LimitBatch(b.context, requests)
+	for i, w := range waiting {
+		if err != nil {
+			w.err = err
+		} else {
+			w.result = results[i]
+		}
+		close(w.done)
+	}
+}
+
+// NewDecisionsHandler creates a new DecisionsHandler
+func NewDecisionsHandler(decider *BatchDecider, logger logrus.FieldLogger, reporter MetricReporter) *DecisionsHandler {
+	return &DecisionsHandler{decider: decider, logger: logger, reporter: reporter}
+}
+
+// DecisionsHandler is an admin HTTP handler that evaluates a batch of requests posted as JSON. It trusts the remote
+// addresses in the body, so it's only served by the admin server and not alongside the rate limit service.
+type DecisionsHandler struct {
+	decider  *BatchDecider
+	logger   logrus.FieldLogger
+	reporter MetricReporter
+}
+
+type batchRequest struct {
+	RemoteAddress string            `json:"remote_address"`
+	Authority     string            `json:"authority"`
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	SourcePort    string            `json:"source_port"`
+	SNI           string            `json:"sni"`
+	ALPN          string            `json:"alpn"`
+	Headers       map[string]string `json:"headers"`
+	Metadata      map[string]string `json:"metadata"`
+	Hits          uint32            `json:"hits"`
+}
+
+type decisionsRequest struct {
+	Requests []batchRequest `json:"requests"`
+}
+
+type decisionResponse struct {
+	Blocked   bool            `json:"blocked"`
+	Remaining uint32          `json:"remaining"`
+	Error     string          `json:"error,omitempty"`
+	Response  *StaticResponse `json:"response,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+}
+
+type decisionsResponse struct {
+	Decisions []decisionResponse `json:"decisions"`
+}
+
+func (h *DecisionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method), h.logger)
+		return
+	}
+
+	body := decisionsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error decoding request body: %v", err), h.logger)
+		return
+	}
+
+	if len(body.Requests) > maxBatchSize {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("batch of %d requests exceeds max of %d", len(body.Requests), maxBatchSize), h.logger)
+		return
+	}
+
+	requests := make([]Request, len(body.Requests))
+	for i, br := range body.Requests {
+		headers := br.Headers
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		requests[i] = Request{RemoteAddress: CanonicalRemoteAddress(br.RemoteAddress), Authority: br.Authority, Method: br.Method, Path: br.Path, SourcePort: br.SourcePort, SNI: br.SNI, ALPN: br.ALPN, Headers: headers, Metadata: br.Metadata, HitsAddend: br.Hits, QueryParams: parseQuery(br.Path)}
+		requests[i].ID = requestID(requests[i])
+	}
+
+	start := time.Now()
+	decisions := h.decider.Decide(r.Context(), requests)
+	duration := time.Since(start)
+
+	res := decisionsResponse{Decisions: make([]decisionResponse, len(decisions))}
+	for i, d := range decisions {
+		res.Decisions[i] = decisionResponse{Blocked: d.Blocked, Remaining: d.Remaining, Response: d.Response, Reason: d.Reason}
+		if d.Err != nil {
+			h.logger.WithError(d.Err).Errorf("error deciding request %v", requests[i])
+			res.Decisions[i].Error = d.Err.Error()
+		}
+		h.reporter.Duration(requests[i], nil, d.Blocked, d.Err != nil, duration)
+	}
+
+	writeJSON(w, http.StatusOK, res, h.logger)
+}
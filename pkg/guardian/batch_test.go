@@ -0,0 +1,182 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestBatchDecider(t *testing.T, limit Limit, reportOnly bool) *BatchDecider {
+	t.Helper()
+	whitelister := NewIPWhitelister(&FakeWhitelistStore{whitelist: parseCIDRs([]string{"10.0.0.1/32"})}, nil, 0, 0, TestingLogger, NullReporter{})
+	blacklister := NewIPBlacklister(&FakeBlacklistStore{blacklist: parseCIDRs([]string{"11.0.0.1/32"})}, TestingLogger, NullReporter{})
+	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64)}
+	rateLimiter := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
+
+	return DefaultBatchDecider(whitelister, blacklister, rateLimiter, StaticReportOnlyProvider{reportOnly}, TestingLogger)
+}
+
+func TestBatchDecide(t *testing.T) {
+	decider := newTestBatchDecider(t, Limit{Count: 2, Duration: time.Minute, Enabled: true}, false)
+
+	requests := []Request{
+		{RemoteAddress: "10.0.0.1"}, // whitelisted
+		{RemoteAddress: "11.0.0.1"}, // blacklisted
+		{RemoteAddress: "12.0.0.1"},
+		{RemoteAddress: "12.0.0.1"},
+		{RemoteAddress: "12.0.0.1"}, // rate limited
+	}
+
+	want := []Decision{
+		{Blocked: false, Remaining: RequestsRemainingMax},
+		{Blocked: true, Remaining: RequestsRemainingMax, Reason: "blacklist:11.0.0.1/32"},
+		{Blocked: false, Remaining: 1},
+		{Blocked: false, Remaining: 0},
+		{Blocked: true, Remaining: 0, Reason: "rate_limit"},
+	}
+
+	got := decider.Decide(context.Background(), requests)
+	if len(got) != len(want) {
+		t.Fatalf("expected: %v received: %v", len(want), len(got))
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("request %d expected: %v received: %v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestBatchDecideReportOnly(t *testing.T) {
+	decider := newTestBatchDecider(t, Limit{Count: 2, Duration: time.Minute, Enabled: true}, true)
+
+	got := decider.Decide(context.Background(), []Request{{RemoteAddress: "11.0.0.1"}})
+	if got[0].Blocked {
+		t.Fatal("expected request to be allowed in report only mode")
+	}
+}
+
+type countingBatchLimiter struct {
+	limiter BatchLimiter
+	calls   int
+	batches [][]Request
+}
+
+func (l *countingBatchLimiter) LimitBatch(c context.Context, requests []Request) ([]LimitResult, error) {
+	l.calls++
+	l.batches = append(l.batches, requests)
+	return l.limiter.LimitBatch(c, requests)
+}
+
+func TestBatchDecideWrappedChain(t *testing.T) {
+	blacklister := NewIPBlacklister(&FakeBlacklistStore{blacklist: parseCIDRs([]string{"11.0.0.1/32"})}, TestingLogger, NullReporter{})
+	fstore := &FakeLimitStore{limit: Limit{Count: 1, Duration: time.Minute, Enabled: true}, count: make(map[string]uint64)}
+	rateLimiter := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
+	limiter := &countingBatchLimiter{limiter: rateLimiter}
+
+	exemption, err := ParseExemption(`healthz=req.path == "/healthz"`)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	chain := CondChain(CondStopOnBlacklistFunc(blacklister), CondStopOnBlockOrError(LimitInBatches(rateLimiter.Limit)))
+	chain = ExemptRequests(chain, []Exemption{exemption}, blacklister, NullReporter{})
+	rate := NewDecisionRate(LocalClock{})
+	chain = CountDecisions(chain, rate)
+	decider := NewBatchDecider(chain, limiter, StaticReportOnlyProvider{false}, TestingLogger)
+
+	requests := []Request{
+		{RemoteAddress: "12.0.0.1", Path: "/healthz"}, // exempted
+		{RemoteAddress: "12.0.0.1", Path: "/healthz"}, // exempted
+		{RemoteAddress: "11.0.0.1", Path: "/healthz"}, // exemptions don't lift the blacklist
+		{RemoteAddress: "12.0.0.1", Path: "/a"},
+		{RemoteAddress: "12.0.0.1", Path: "/b"}, // rate limited
+	}
+
+	want := []Decision{
+		{Blocked: false, Remaining: RequestsRemainingMax},
+		{Blocked: false, Remaining: RequestsRemainingMax},
+		{Blocked: true, Remaining: RequestsRemainingMax, Reason: "blacklist:11.0.0.1/32"},
+		{Blocked: false, Remaining: 0},
+		{Blocked: true, Remaining: 0, Reason: "rate_limit"},
+	}
+
+	got := decider.Decide(context.Background(), requests)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("request %d expected: %v received: %v", i, want[i], got[i])
+		}
+	}
+
+	if rate.Total() != uint64(len(requests)) {
+		t.Errorf("expected %d decisions counted, received: %v", len(requests), rate.Total())
+	}
+
+	if limiter.calls != 1 || len(limiter.batches[0]) != 2 || limiter.batches[0][0].Path != "/a" || limiter.batches[0][1].Path != "/b" {
+		t.Errorf("expected the unexempted requests to be limited in a single ordered batch, received: %v", limiter.batches)
+	}
+
+	// requests decided outside a batch are limited one at a time
+	if blocked, _, err := chain(context.Background(), Request{RemoteAddress: "12.0.0.1", Path: "/c"}); err != nil || !blocked {
+		t.Errorf("expected request to be rate limited, received: %v, %v", blocked, err)
+	}
+	if limiter.calls != 1 {
+		t.Errorf("expected the batch limiter not to be called, received: %d calls", limiter.calls)
+	}
+}
+
+func TestDecisionsHandler(t *testing.T) {
+	decider := newTestBatchDecider(t, Limit{Count: 2, Duration: time.Minute, Enabled: true}, false)
+	handler := NewDecisionsHandler(decider, TestingLogger, NullReporter{})
+
+	body := `{"requests": [{"remote_address": "11.0.0.1"}, {"remote_address": "12.0.0.1"}, {"remote_address": "invalid"}]}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/decisions", strings.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected: %v received: %v", http.StatusOK, rec.Code)
+	}
+
+	res := decisionsResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(res.Decisions) != 3 {
+		t.Fatalf("expected: %v received: %v", 3, len(res.Decisions))
+	}
+
+	if !res.Decisions[0].Blocked || res.Decisions[1].Blocked || res.Decisions[1].Remaining != 1 {
+		t.Fatalf("unexpected decisions: %v", res.Decisions)
+	}
+}
+
+func TestDecisionsHandlerRejectsBadRequests(t *testing.T) {
+	decider := newTestBatchDecider(t, Limit{Count: 2, Duration: time.Minute, Enabled: true}, false)
+	handler := NewDecisionsHandler(decider, TestingLogger, NullReporter{})
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{name: "WrongMethod", method: http.MethodGet, body: "", wantStatus: http.StatusMethodNotAllowed},
+		{name: "InvalidJSON", method: http.MethodPost, body: "{", wantStatus: http.StatusBadRequest},
+		{name: "TooLarge", method: http.MethodPost, body: `{"requests": [` + strings.Repeat(`{},`, maxBatchSize) + `{}]}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(test.method, "/v1/decisions", strings.NewReader(test.body)))
+			if rec.Code != test.wantStatus {
+				t.Fatalf("expected: %v received: %v", test.wantStatus, rec.Code)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/netip"
 	"time"
 
 	"github.com/pkg/errors"
@@ -31,16 +32,38 @@ type BlacklistProvider interface {
 	GetBlacklist() []net.IPNet
 }
 
+// BlacklistPrefixProvider is implemented by BlacklistProviders that can provide their blacklist as netip.Prefixes without
+// converting or copying it for every request. The returned slice must not be modified.
+type BlacklistPrefixProvider interface {
+	GetBlacklistPrefixes() []netip.Prefix
+}
+
+// NewIPBlacklister creates a new IPBlacklister. Providers that don't implement BlacklistPrefixProvider have their blacklist
+// converted for every request.
 func NewIPBlacklister(provider BlacklistProvider, logger logrus.FieldLogger, reporter MetricReporter) *IPBlacklister {
-	return &IPBlacklister{provider: provider, logger: logger, reporter: reporter}
+	prefixes, ok := provider.(BlacklistPrefixProvider)
+	if !ok {
+		prefixes = ipNetBlacklistProvider{provider}
+	}
+
+	return &IPBlacklister{provider: prefixes, logger: logger, reporter: reporter}
 }
 
 type IPBlacklister struct {
-	provider BlacklistProvider
+	provider BlacklistPrefixProvider
 	logger   logrus.FieldLogger
 	reporter MetricReporter
 }
 
+// ipNetBlacklistProvider adapts a BlacklistProvider to a BlacklistPrefixProvider
+type ipNetBlacklistProvider struct {
+	BlacklistProvider
+}
+
+func (p ipNetBlacklistProvider) GetBlacklistPrefixes() []netip.Prefix {
+	return PrefixesFromIPNets(p.GetBlacklist())
+}
+
 func (w *IPBlacklister) IsBlacklisted(context context.Context, req Request) (bool, error) {
 	start := time.Now()
 	blacklisted := false
@@ -50,27 +73,29 @@ func (w *IPBlacklister) IsBlacklisted(context context.Context, req Request) (boo
 	}()
 
 	w.logger.Debugf("checking blacklist for request %#v", req)
-	ip := net.ParseIP(req.RemoteAddress)
+	ip, ok := parseRemoteAddr(req.RemoteAddress)
 	w.logger.Debugf("parsed IP from request %#v", req)
-	if ip == nil {
+	if !ok {
 		errorOccurred = true
 		return false, fmt.Errorf("invalid remote address -- not IP")
 	}
 
 	w.logger.Debug("Getting blacklist")
-	blacklist := w.provider.GetBlacklist()
+	blacklist := w.provider.GetBlacklistPrefixes()
 	w.logger.Debugf("Got blacklist with length %d", len(blacklist))
 	w.reporter.CurrentBlacklist(blacklist)
 
 	for _, cidr := range blacklist {
 		if cidr.Contains(ip) {
 			w.logger.Debugf("Found %v in cidr %v of blacklist", ip, cidr.String())
+			tracef(context, "blacklisted by cidr %v", cidr.String())
+			hintBlockReason(context, BlacklistBlockReason, cidr.String())
 			blacklisted = true
 			return true, nil
 		}
-		w.logger.Debugf("CIDR %v does not contain %v of blacklist", cidr.String(), ip)
 	}
 
 	w.logger.Debugf("%v NOT FOUND in blacklist", ip)
+	tracef(context, "not blacklisted by %d cidrs", len(blacklist))
 	return false, nil
 }
@@ -0,0 +1,259 @@
+package guardian
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+)
+
+const blockStatsNamespace = "block_stats"
+
+// blockStatsRetention is how long hourly block stats are kept in Redis, long enough for weekly reports
+const blockStatsRetention = 8 * 24 * time.Hour
+
+// maxBlockStatsKeys bounds the keys counted each hour, by each instance between flushes and in Redis, so an attack
+// from many addresses can't grow the stats without bound. Keys beyond it are only counted in the totals.
+const maxBlockStatsKeys = 1000
+
+// NoRuleName names the blocks of requests that didn't match a rule, e.g. those blocked by the blacklist or a limit
+const NoRuleName = "(none)"
+
+// NewBlockStats creates a new BlockStats recording to redis
+func NewBlockStats(redis *redis.Client, clock Clock, logger logrus.FieldLogger) *BlockStats {
+	return &BlockStats{redis: redis, clock: clock, logger: logger, pending: make(map[int64]*hourBlockStats)}
+}
+
+// BlockStats aggregates blocked decisions per rule and per key into hourly buckets stored in Redis, from which
+// reports covering any window within blockStatsRetention are generated with FetchBlockReport. Decisions are counted
+// locally and flushed periodically, so recording never waits on Redis.
+type BlockStats struct {
+	redis  *redis.Client
+	clock  Clock
+	logger logrus.FieldLogger
+
+	mu      sync.Mutex
+	pending map[int64]*hourBlockStats // by the unix time of the start of the hour
+}
+
+type hourBlockStats struct {
+	total uint64
+	rules map[string]uint64
+	keys  map[string]uint64
+}
+
+// RecordBlocks wraps f, recording each request it blocks to s along with the rule that decided it
+func RecordBlocks(f RequestBlockerFunc, s *BlockStats) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		blocked, remaining, err := f(c, r)
+		if blocked {
+			s.Record(r, DecisionHintFromContext(c).MatchedRule())
+		}
+		return blocked, remaining, err
+	}
+}
+
+// Record counts a blocked request, attributed to rule unless it is nil
+func (s *BlockStats) Record(r Request, rule *Rule) {
+	ruleName := NoRuleName
+	if rule != nil {
+		ruleName = rule.Name
+	}
+
+	hour := s.clock.Now().Truncate(time.Hour).Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.pending[hour]
+	if !ok {
+		stats = &hourBlockStats{rules: make(map[string]uint64), keys: make(map[string]uint64)}
+		s.pending[hour] = stats
+	}
+
+	stats.total++
+	stats.rules[ruleName]++
+	if _, ok := stats.keys[r.RemoteAddress]; ok || len(stats.keys) < maxBlockStatsKeys {
+		stats.keys[r.RemoteAddress]++
+	}
+}
+
+// Run flushes the recorded stats to Redis every interval until stop is closed, flushing once more before returning
+func (s *BlockStats) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flushAndLog()
+		case <-stop:
+			s.flushAndLog()
+			return
+		}
+	}
+}
+
+func (s *BlockStats) flushAndLog() {
+	if err := s.Flush(); err != nil {
+		s.logger.WithError(err).Error("error flushing block stats")
+	}
+}
+
+// Flush adds the stats recorded since the last flush to Redis. Stats that fail to be flushed are dropped.
+func (s *BlockStats) Flush() error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[int64]*hourBlockStats)
+	s.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	pipe := s.redis.Pipeline()
+	defer pipe.Close()
+
+	for hour, stats := range pending {
+		totalKey, rulesKey, keysKey := blockStatsKeys(hour)
+		pipe.IncrBy(totalKey, int64(stats.total))
+		for rule, count := range stats.rules {
+			pipe.HIncrBy(rulesKey, rule, int64(count))
+		}
+		for key, count := range stats.keys {
+			pipe.ZIncrBy(keysKey, float64(count), key)
+		}
+		pipe.ZRemRangeByRank(keysKey, 0, -maxBlockStatsKeys-1) // keep the most blocked keys
+
+		for _, key := range []string{totalKey, rulesKey, keysKey} {
+			pipe.Expire(key, blockStatsRetention)
+		}
+	}
+
+	s.logger.Debugf("flushing block stats of %d hours", len(pending))
+	_, err := pipe.Exec()
+	return err
+}
+
+// blockStatsKeys returns the Redis keys of the total, per rule, and per key block stats of the hour starting at the
+// unix time hour
+func blockStatsKeys(hour int64) (string, string, string) {
+	prefix := NamespacedKey(blockStatsNamespace, strconv.FormatInt(hour, 10))
+	return prefix + ":total", prefix + ":rules", prefix + ":keys"
+}
+
+// BlockReport aggregates the requests blocked in a window, per rule and for the most blocked keys
+type BlockReport struct {
+	Start   time.Time    `json:"start"`
+	End     time.Time    `json:"end"`
+	Blocked uint64       `json:"blocked"`
+	Rules   []BlockCount `json:"rules"`
+	Keys    []BlockCount `json:"keys"`
+}
+
+// BlockCount is the number of requests blocked for a rule or key
+type BlockCount struct {
+	Name    string `json:"name"`
+	Blocked uint64 `json:"blocked"`
+}
+
+// FetchBlockReport aggregates the block stats stored in Redis for the hours overlapping the window of duration ending
+// at end, including the top most blocked keys. Key counts are approximate once more than maxBlockStatsKeys keys are
+// blocked in an hour.
+func FetchBlockReport(client *redis.Client, end time.Time, duration time.Duration, top int) (BlockReport, error) {
+	if duration <= 0 || duration > blockStatsRetention {
+		return BlockReport{}, fmt.Errorf("report duration %v must be between 0 and %v", duration, blockStatsRetention)
+	}
+
+	start := end.Add(-duration).Truncate(time.Hour)
+	report := BlockReport{Start: start, End: end}
+
+	pipe := client.Pipeline()
+	defer pipe.Close()
+
+	type hourCmds struct {
+		total *redis.StringCmd
+		rules *redis.StringStringMapCmd
+		keys  *redis.ZSliceCmd
+	}
+	hours := []hourCmds{}
+	for hour := start; hour.Before(end); hour = hour.Add(time.Hour) {
+		totalKey, rulesKey, keysKey := blockStatsKeys(hour.Unix())
+		hours = append(hours, hourCmds{total: pipe.Get(totalKey), rules: pipe.HGetAll(rulesKey), keys: pipe.ZRangeWithScores(keysKey, 0, -1)})
+	}
+
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return BlockReport{}, err
+	}
+
+	rules, keys := map[string]uint64{}, map[string]uint64{}
+	for _, h := range hours {
+		if total, err := h.total.Uint64(); err == nil {
+			report.Blocked += total
+		}
+
+		ruleCounts, _ := h.rules.Result()
+		for rule, countStr := range ruleCounts {
+			count, _ := strconv.ParseUint(countStr, 10, 64)
+			rules[rule] += count
+		}
+
+		keyCounts, _ := h.keys.Result()
+		for _, z := range keyCounts {
+			keys[z.Member.(string)] += uint64(z.Score)
+		}
+	}
+
+	report.Rules = sortedBlockCounts(rules, 0)
+	report.Keys = sortedBlockCounts(keys, top)
+	return report, nil
+}
+
+// sortedBlockCounts returns counts from most to least blocked, limited to top unless it is zero
+func sortedBlockCounts(counts map[string]uint64, top int) []BlockCount {
+	sorted := make([]BlockCount, 0, len(counts))
+	for name, count := range counts {
+		sorted = append(sorted, BlockCount{Name: name, Blocked: count})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Blocked != sorted[j].Blocked {
+			return sorted[i].Blocked > sorted[j].Blocked
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	if top > 0 && len(sorted) > top {
+		sorted = sorted[:top]
+	}
+
+	return sorted
+}
+
+// WriteCSV writes the report as CSV rows of type (total, rule, or key), name, and blocked count
+func (r BlockReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	rows := [][]string{
+		{"type", "name", "blocked"},
+		{"total", r.Start.UTC().Format(time.RFC3339) + "/" + r.End.UTC().Format(time.RFC3339), strconv.FormatUint(r.Blocked, 10)},
+	}
+	for _, c := range r.Rules {
+		rows = append(rows, []string{"rule", c.Name, strconv.FormatUint(c.Blocked, 10)})
+	}
+	for _, c := range r.Keys {
+		rows = append(rows, []string{"key", c.Name, strconv.FormatUint(c.Blocked, 10)})
+	}
+
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+
+	return cw.Error()
+}
@@ -0,0 +1,131 @@
+package guardian
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+)
+
+func newTestBlockStats(t *testing.T, clock Clock) (*BlockStats, *redis.Client, *miniredis.Miniredis) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis: %v", err)
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	return NewBlockStats(client, clock, TestingLogger), client, s
+}
+
+func TestBlockStatsReport(t *testing.T) {
+	now := time.Date(2018, 4, 5, 10, 30, 0, 0, time.UTC)
+	clock := &fixedClock{now.Add(-2 * time.Hour)}
+	stats, client, s := newTestBlockStats(t, clock)
+	defer s.Close()
+
+	rule := &Rule{Name: "block-export"}
+	stats.Record(Request{RemoteAddress: "10.0.0.1"}, rule)
+	stats.Record(Request{RemoteAddress: "10.0.0.2"}, nil)
+	if err := stats.Flush(); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	clock.now = now
+	stats.Record(Request{RemoteAddress: "10.0.0.1"}, rule)
+	stats.Record(Request{RemoteAddress: "10.0.0.1"}, rule)
+	if err := stats.Flush(); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	report, err := FetchBlockReport(client, now, 24*time.Hour, 1)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if report.Blocked != 4 {
+		t.Errorf("expected 4 blocked, received: %v", report.Blocked)
+	}
+
+	expectedRules := []BlockCount{{Name: "block-export", Blocked: 3}, {Name: NoRuleName, Blocked: 1}}
+	if fmt.Sprint(report.Rules) != fmt.Sprint(expectedRules) {
+		t.Errorf("expected rules: %v received: %v", expectedRules, report.Rules)
+	}
+
+	expectedKeys := []BlockCount{{Name: "10.0.0.1", Blocked: 3}}
+	if fmt.Sprint(report.Keys) != fmt.Sprint(expectedKeys) {
+		t.Errorf("expected the top key: %v received: %v", expectedKeys, report.Keys)
+	}
+
+	recent, err := FetchBlockReport(client, now, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if recent.Blocked != 2 {
+		t.Errorf("expected only the blocks of the last hour, received: %v", recent.Blocked)
+	}
+
+	if _, err := FetchBlockReport(client, now, 30*24*time.Hour, 0); err == nil {
+		t.Error("expected an error for a window longer than the retention")
+	}
+}
+
+func TestRecordBlocks(t *testing.T) {
+	stats, client, s := newTestBlockStats(t, &fixedClock{time.Now()})
+	defer s.Close()
+
+	blocker := RecordBlocks(func(c context.Context, r Request) (bool, uint32, error) {
+		return r.Path == "/blocked", 0, nil
+	}, stats)
+
+	for _, path := range []string{"/blocked", "/allowed"} {
+		if _, _, err := blocker(context.Background(), Request{RemoteAddress: "10.0.0.1", Path: path}); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+	}
+
+	if err := stats.Flush(); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	report, err := FetchBlockReport(client, time.Now(), time.Hour, 0)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if report.Blocked != 1 {
+		t.Errorf("expected only the blocked request to be recorded, received: %v", report.Blocked)
+	}
+}
+
+func TestBlockReportWriteCSV(t *testing.T) {
+	start := time.Date(2018, 4, 5, 0, 0, 0, 0, time.UTC)
+	report := BlockReport{
+		Start:   start,
+		End:     start.Add(24 * time.Hour),
+		Blocked: 3,
+		Rules:   []BlockCount{{Name: "block-export", Blocked: 3}},
+		Keys:    []BlockCount{{Name: "10.0.0.1", Blocked: 3}},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := report.WriteCSV(buf); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	expected := strings.Join([]string{
+		"type,name,blocked",
+		"total,2018-04-05T00:00:00Z/2018-04-06T00:00:00Z,3",
+		"rule,block-export,3",
+		"key,10.0.0.1,3",
+		"",
+	}, "\n")
+	if buf.String() != expected {
+		t.Errorf("expected:\n%v\nreceived:\n%v", expected, buf.String())
+	}
+}
@@ -0,0 +1,115 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// BlockedKey is a counter key the RedisCounter has cached as over its limit
+type BlockedKey struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	// TTL is how long the key stays blocked. It's relative so peers with skewed clocks agree on it.
+	TTL time.Duration `json:"ttl_ns"`
+}
+
+// BlockedKeys returns the keys cached as blocked that haven't expired
+func (rs *RedisCounter) BlockedKeys() []BlockedKey {
+	now := time.Now()
+
+	rs.cache.RLock()
+	defer rs.cache.RUnlock()
+
+	keys := []BlockedKey{}
+	for key, item := range rs.cache.m {
+		if item.blocked && item.expireAt.After(now) {
+			keys = append(keys, BlockedKey{Key: key, Count: item.val, TTL: item.expireAt.Sub(now)})
+		}
+	}
+
+	return keys
+}
+
+// WarmBlockedKeys caches keys as blocked, as if they had been counted by this RedisCounter, returning the number
+// cached. Keys already cached are left as they are.
+func (rs *RedisCounter) WarmBlockedKeys(keys []BlockedKey) int {
+	now := time.Now()
+
+	rs.cache.Lock()
+	defer rs.cache.Unlock()
+
+	warmed := 0
+	for _, key := range keys {
+		if _, ok := rs.cache.m[key.Key]; ok || key.TTL <= 0 {
+			continue
+		}
+
+		rs.cache.m[key.Key] = item{val: key.Count, blocked: true, expireAt: now.Add(key.TTL)}
+		warmed++
+	}
+
+	return warmed
+}
+
+// BlockedKeysProvider provides the keys a counter has cached as blocked
+type BlockedKeysProvider interface {
+	BlockedKeys() []BlockedKey
+}
+
+// NewBlockedKeysHandler creates a new BlockedKeysHandler
+func NewBlockedKeysHandler(provider BlockedKeysProvider, logger logrus.FieldLogger) *BlockedKeysHandler {
+	return &BlockedKeysHandler{provider: provider, logger: logger}
+}
+
+// BlockedKeysHandler is an admin HTTP handler serving the keys this instance has cached as blocked, so a newly
+// started peer can warm its cache with them
+type BlockedKeysHandler struct {
+	provider BlockedKeysProvider
+	logger   logrus.FieldLogger
+}
+
+type blockedKeysResponse struct {
+	Keys []BlockedKey `json:"keys"`
+}
+
+func (h *BlockedKeysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method), h.logger)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, blockedKeysResponse{Keys: h.provider.BlockedKeys()}, h.logger)
+}
+
+// FetchPeerBlockedKeys fetches the keys the peer Guardian whose admin server is at peerURL, e.g.
+// http://guardian-admin:3001, has cached as blocked
+func FetchPeerBlockedKeys(context context.Context, client *http.Client, peerURL string) ([]BlockedKey, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(peerURL, "/")+"/v1/blocked-keys", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating blocked keys request")
+	}
+
+	res, err := client.Do(req.WithContext(context))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error requesting blocked keys from %v", peerURL))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d requesting blocked keys from %v", res.StatusCode, peerURL)
+	}
+
+	body := blockedKeysResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error decoding blocked keys from %v", peerURL))
+	}
+
+	return body.Keys, nil
+}
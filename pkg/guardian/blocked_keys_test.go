@@ -0,0 +1,58 @@
+package guardian
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWarmBlockedKeysFromPeer(t *testing.T) {
+	peer, ps := newTestRedisCounter(t)
+	defer ps.Close()
+
+	// the peer has cached one key over its limit, one under it, and one that has expired
+	peer.cache.m["blocked"] = item{val: 20, blocked: true, expireAt: time.Now().Add(time.Minute)}
+	peer.cache.m["allowed"] = item{val: 1, blocked: false, expireAt: time.Now().Add(time.Minute)}
+	peer.cache.m["expired"] = item{val: 20, blocked: true, expireAt: time.Now().Add(-time.Second)}
+
+	srv := httptest.NewServer(NewBlockedKeysHandler(peer, TestingLogger))
+	defer srv.Close()
+
+	keys, err := FetchPeerBlockedKeys(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(keys) != 1 || keys[0].Key != "blocked" || keys[0].Count != 20 || keys[0].TTL <= 0 || keys[0].TTL > time.Minute {
+		t.Fatalf("expected only the unexpired blocked key, received: %+v", keys)
+	}
+
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	if warmed := c.WarmBlockedKeys(keys); warmed != 1 {
+		t.Fatalf("expected 1 key to be warmed, received: %v", warmed)
+	}
+
+	// the warmed key is blocked without waiting for its count to be fetched from redis
+	count, blocked, err := c.Incr(context.Background(), "blocked", 1, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if !blocked || count != 21 {
+		t.Fatalf("expected: (%v, %v) received: (%v, %v)", 21, true, count, blocked)
+	}
+}
+
+func TestWarmBlockedKeysKeepsCachedKeys(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	c.cache.m["known"] = item{val: 3, blocked: false, expireAt: time.Now().Add(time.Minute)}
+	warmed := c.WarmBlockedKeys([]BlockedKey{{Key: "known", Count: 20, TTL: time.Minute}, {Key: "stale", Count: 20}})
+	if warmed != 0 || c.cache.m["known"].blocked {
+		t.Fatalf("expected keys already cached, or without a ttl, not to be warmed, received: %v", warmed)
+	}
+}
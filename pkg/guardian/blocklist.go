@@ -0,0 +1,66 @@
+package guardian
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ServerMode is what a Guardian server enforces
+type ServerMode string
+
+const (
+	// LimitServerMode enforces the whitelist and blacklist, then the rules and rate limits
+	LimitServerMode ServerMode = "limit"
+	// BlocklistServerMode only enforces the whitelist and blacklist, so Guardian serves as a distributed IP allow and
+	// block list without counting requests
+	BlocklistServerMode ServerMode = "blocklist"
+)
+
+// ListDecision is how the whitelist and blacklist decided a request in BlocklistServerMode
+type ListDecision string
+
+const (
+	// WhitelistedListDecision allows a whitelisted request
+	WhitelistedListDecision ListDecision = "whitelisted"
+	// BlacklistedListDecision blocks a blacklisted request that isn't whitelisted
+	BlacklistedListDecision ListDecision = "blacklisted"
+	// UnlistedListDecision allows a request on neither list
+	UnlistedListDecision ListDecision = "unlisted"
+)
+
+// ListsOnly decides requests by the whitelist and blacklist alone, the decision of BlocklistServerMode. The
+// whitelist takes precedence, as it does in CondChain. Nothing is counted, so no request waits on the counter store.
+// Each decision is reported with ListDecision.
+func ListsOnly(whitelister *IPWhitelister, blacklister *IPBlacklister, reporter MetricReporter) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		decision, err := listDecision(c, r, whitelister, blacklister)
+		tracef(c, "list decision %v, err: %v", decision, err)
+		reporter.ListDecision(decision, err != nil)
+
+		return decision == BlacklistedListDecision, RequestsRemainingMax, err
+	}
+}
+
+func listDecision(c context.Context, r Request, whitelister *IPWhitelister, blacklister *IPBlacklister) (ListDecision, error) {
+	// an error checking the whitelist falls through to the blacklist, as CondStopOnWhitelistFunc does
+	whitelisted, whitelistErr := whitelister.IsWhitelisted(c, r)
+	if whitelistErr == nil && whitelisted {
+		return WhitelistedListDecision, nil
+	}
+
+	blacklisted, err := blacklister.IsBlacklisted(c, r)
+	if err != nil {
+		return UnlistedListDecision, errors.Wrap(err, "error checking if request is blacklisted")
+	}
+
+	if blacklisted {
+		return BlacklistedListDecision, nil
+	}
+
+	if whitelistErr != nil {
+		return UnlistedListDecision, errors.Wrap(whitelistErr, "error checking if request is whitelisted")
+	}
+
+	return UnlistedListDecision, nil
+}
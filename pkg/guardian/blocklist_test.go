@@ -0,0 +1,48 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+)
+
+type FakeListDecisionReporter struct {
+	NullReporter
+	decisions []ListDecision
+}
+
+func (f *FakeListDecisionReporter) ListDecision(decision ListDecision, errorOccurred bool) {
+	f.decisions = append(f.decisions, decision)
+}
+
+func TestListsOnly(t *testing.T) {
+	whitelister := NewIPWhitelister(&FakeWhitelistStore{whitelist: parseCIDRs([]string{"10.0.0.1/32"})}, nil, 0, 0, TestingLogger, NullReporter{})
+	blacklister := NewIPBlacklister(&FakeBlacklistStore{blacklist: parseCIDRs([]string{"10.0.0.0/8"})}, TestingLogger, NullReporter{})
+	reporter := &FakeListDecisionReporter{}
+	decide := ListsOnly(whitelister, blacklister, reporter)
+
+	tests := []struct {
+		remoteAddress string
+		blocked       bool
+		decision      ListDecision
+	}{
+		{remoteAddress: "10.0.0.1", blocked: false, decision: WhitelistedListDecision}, // the whitelist takes precedence
+		{remoteAddress: "10.0.0.2", blocked: true, decision: BlacklistedListDecision},
+		{remoteAddress: "192.168.1.2", blocked: false, decision: UnlistedListDecision},
+		{remoteAddress: "192.168.1.2", blocked: false, decision: UnlistedListDecision}, // never counted, so never limited
+	}
+
+	for i, test := range tests {
+		blocked, remaining, err := decide(context.Background(), Request{RemoteAddress: test.remoteAddress})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if blocked != test.blocked || remaining != RequestsRemainingMax {
+			t.Errorf("request %d from %v expected blocked: %v received: %v, remaining: %v", i, test.remoteAddress, test.blocked, blocked, remaining)
+		}
+
+		if reporter.decisions[i] != test.decision {
+			t.Errorf("request %d from %v expected decision: %v received: %v", i, test.remoteAddress, test.decision, reporter.decisions[i])
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package guardian
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// errBulkheadFull is returned by a full bulkhead. It's a decision rather than a failure: limiters block the requests
+// it's returned for rather than failing open.
+var errBulkheadFull = errors.New("bulkhead is full")
+
+// NewBulkheads creates new Bulkheads each allowing at most limit concurrent counter operations
+func NewBulkheads(limit int, reporter MetricReporter) *Bulkheads {
+	return &Bulkheads{limit: limit, reporter: reporter, bulkheads: make(map[string]chan struct{})}
+}
+
+// Bulkheads isolate the counter operations of routes and rules from each other. Each named bulkhead allows a limited
+// number of concurrent operations and fails the rest right away, so a route under attack can't exhaust the Redis
+// connection pool and starve the decisions of other routes.
+type Bulkheads struct {
+	limit    int
+	reporter MetricReporter
+
+	mu        sync.Mutex
+	bulkheads map[string]chan struct{}
+}
+
+// Acquire reserves a concurrent operation of the named bulkhead, returning the func releasing it, or errBulkheadFull
+// if the bulkhead is full. A nil Bulkheads never fills.
+func (b *Bulkheads) Acquire(name string) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+
+	slots := b.bulkhead(name)
+	select {
+	case slots <- struct{}{}:
+		b.reporter.Bulkhead(name, float64(len(slots))/float64(b.limit), false)
+		return func() { <-slots }, nil
+	default:
+		b.reporter.Bulkhead(name, 1, true)
+		return nil, errBulkheadFull
+	}
+}
+
+func (b *Bulkheads) bulkhead(name string) chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	slots, ok := b.bulkheads[name]
+	if !ok {
+		slots = make(chan struct{}, b.limit)
+		b.bulkheads[name] = slots
+	}
+
+	return slots
+}
+
+// rateLimitBulkhead is the bulkhead of the global rate limit
+const rateLimitBulkhead = "rate_limit"
+
+// routeBulkhead and ruleBulkhead name the bulkheads of routes and rules so they can't collide
+func routeBulkhead(route RoutePattern) string {
+	return NamespacedKey(routeLimitNamespace, route.String())
+}
+
+func ruleBulkhead(rule Rule) string {
+	return NamespacedKey(ruleNamespace, rule.Name)
+}
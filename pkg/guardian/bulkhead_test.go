@@ -0,0 +1,114 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBulkheadsIsolateNames(t *testing.T) {
+	b := NewBulkheads(2, NullReporter{})
+
+	releases := []func(){}
+	for i := 0; i < 2; i++ {
+		release, err := b.Acquire("attacked")
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		releases = append(releases, release)
+	}
+
+	if _, err := b.Acquire("attacked"); err == nil {
+		t.Fatal("expected a full bulkhead to reject the operation")
+	}
+
+	if _, err := b.Acquire("other"); err != nil {
+		t.Fatalf("expected other bulkheads to be unaffected, got error: %v", err)
+	}
+
+	releases[0]()
+	if _, err := b.Acquire("attacked"); err != nil {
+		t.Fatalf("expected a released operation to be reusable, got error: %v", err)
+	}
+
+	var nilBulkheads *Bulkheads
+	if _, err := nilBulkheads.Acquire("attacked"); err != nil {
+		t.Fatalf("expected nil bulkheads to never fill, got error: %v", err)
+	}
+}
+
+func TestRouteRateLimiterBlocksWhenBulkheadIsFull(t *testing.T) {
+	limit := Limit{Count: 2, Duration: time.Minute, Enabled: true}
+	route := mustParseRoutePattern(t, "/attacked")
+	provider := &FakeRouteLimitProvider{routeLimits: []RouteLimit{{Route: route, Limit: LimitOverrideFromLimit(limit)}}}
+	fstore := &FakeLimitStore{count: make(map[string]uint64)}
+	bulkheads := NewBulkheads(1, NullReporter{})
+	rl := NewRouteRateLimiter(provider, fstore, LocalClock{}, bulkheads, TestingLogger, NullReporter{})
+
+	release, err := bulkheads.Acquire(routeBulkhead(route))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	hint := NewDecisionHint()
+	blocked, _, err := rl.Limit(WithDecisionHint(context.Background(), hint), Request{RemoteAddress: "192.168.1.2", Path: "/attacked"})
+	if err != nil || !blocked {
+		t.Fatalf("expected request to be blocked without an error, received: (%v, %v)", blocked, err)
+	}
+
+	if reason := hint.BlockReason(); reason == nil || reason.Kind != RouteLimitBlockReason {
+		t.Fatalf("expected the route limit to be the block reason, received: %v", reason)
+	}
+
+	if len(fstore.count) != 0 {
+		t.Fatalf("expected request not to be counted, received: %v", fstore.count)
+	}
+
+	release()
+	if blocked, _, err := rl.Limit(context.Background(), Request{RemoteAddress: "192.168.1.2", Path: "/attacked"}); err != nil || blocked {
+		t.Fatalf("expected request to be allowed, received: (%v, %v)", blocked, err)
+	}
+}
+
+func TestIPRateLimiterBlocksWhenBulkheadIsFull(t *testing.T) {
+	fstore := &FakeLimitStore{limit: Limit{Count: 2, Duration: time.Minute, Enabled: true}, count: make(map[string]uint64)}
+	bulkheads := NewBulkheads(1, NullReporter{})
+	rl := NewIPRateLimiter(fstore, fstore, LocalClock{}, bulkheads, TestingLogger, NullReporter{})
+
+	release, err := bulkheads.Acquire(rateLimitBulkhead)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	blocked, _, err := rl.Limit(context.Background(), Request{RemoteAddress: "192.168.1.2"})
+	if err != nil || !blocked {
+		t.Fatalf("expected request to be blocked without an error, received: (%v, %v)", blocked, err)
+	}
+
+	release()
+	if blocked, _, err := rl.Limit(context.Background(), Request{RemoteAddress: "192.168.1.2"}); err != nil || blocked {
+		t.Fatalf("expected request to be allowed, received: (%v, %v)", blocked, err)
+	}
+}
+
+func TestRuleEvaluatorBlocksWhenBulkheadIsFull(t *testing.T) {
+	rule := mustParseRule(t, "login", RuleDocument{When: `req.path == "/login"`, Action: "limit", Limit: &LimitDocument{Count: 2, Duration: "1m", Enabled: true}})
+	fstore := &FakeLimitStore{count: make(map[string]uint64)}
+	bulkheads := NewBulkheads(1, NullReporter{})
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: []Rule{rule}}, fstore, LocalClock{}, nil, bulkheads, TestingLogger, NullReporter{})
+
+	release, err := bulkheads.Acquire(ruleBulkhead(rule))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer release()
+
+	stop, blocked, _, err := re.Evaluate(context.Background(), Request{RemoteAddress: "192.168.1.2", Path: "/login"})
+	if err != nil || !stop || !blocked {
+		t.Fatalf("expected request to be blocked without an error, received: (%v, %v, %v)", stop, blocked, err)
+	}
+
+	if len(fstore.count) != 0 {
+		t.Fatalf("expected request not to be counted, received: %v", fstore.count)
+	}
+}
@@ -0,0 +1,131 @@
+package guardian
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CalendarWindow is a calendar unit that fixed window limits can align their windows to, for billing style quotas
+// such as 1000 requests per calendar day
+type CalendarWindow string
+
+const (
+	// MinuteWindow windows start at the beginning of each minute
+	MinuteWindow CalendarWindow = "minute"
+
+	// HourWindow windows start at the beginning of each hour
+	HourWindow CalendarWindow = "hour"
+
+	// DayWindow windows start at midnight
+	DayWindow CalendarWindow = "day"
+)
+
+// ParseCalendarWindow parses a CalendarWindow from a string. An empty string means windows are not calendar aligned.
+func ParseCalendarWindow(s string) (CalendarWindow, error) {
+	switch CalendarWindow(s) {
+	case "", MinuteWindow, HourWindow, DayWindow:
+		return CalendarWindow(s), nil
+	}
+
+	return "", fmt.Errorf("unknown calendar window %q", s)
+}
+
+var locations = struct {
+	sync.RWMutex
+	m map[string]*time.Location
+}{m: make(map[string]*time.Location)}
+
+// loadLocation loads the named time zone, caching it since time.LoadLocation reads the zoneinfo database on every call.
+// An empty name is UTC.
+func loadLocation(name string) (*time.Location, error) {
+	locations.RLock()
+	loc, ok := locations.m[name]
+	locations.RUnlock()
+	if ok {
+		return loc, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error loading time zone %q", name))
+	}
+
+	locations.Lock()
+	locations.m[name] = loc
+	locations.Unlock()
+
+	return loc, nil
+}
+
+// ValidateTimeZone returns an error if name is not a known time zone
+func ValidateTimeZone(name string) error {
+	_, err := loadLocation(name)
+	return err
+}
+
+// ValidateCalendar returns an error if the limit's calendar window or time zone are invalid
+func ValidateCalendar(limit Limit) error {
+	if _, err := ParseCalendarWindow(string(limit.Calendar)); err != nil {
+		return err
+	}
+
+	if limit.Calendar == "" {
+		if limit.TimeZone != "" {
+			return fmt.Errorf("time zone %q requires a calendar window", limit.TimeZone)
+		}
+		return nil
+	}
+
+	if limit.Algorithm == LeakyBucketAlgorithm || limit.Algorithm == DayBucketsAlgorithm {
+		return fmt.Errorf("calendar windows are not supported by the %v algorithm", limit.Algorithm)
+	}
+
+	return ValidateTimeZone(limit.TimeZone)
+}
+
+// validatePlainFixedWindows returns an error if limit doesn't count in fixed windows aligned to the unix epoch, without
+// a calendar or rollover, which features keeping state by window number, such as baselines, require
+func validatePlainFixedWindows(limit Limit, feature string) error {
+	if (limit.Algorithm != "" && limit.Algorithm != FixedWindowAlgorithm) || limit.Calendar != "" || limit.Rollover > 0 {
+		return fmt.Errorf("%v can only be used with the %v algorithm without a calendar or rollover", feature, FixedWindowAlgorithm)
+	}
+
+	return nil
+}
+
+// windowNumber returns the number of the plain fixed window of limit containing now, counted from the unix epoch
+func windowNumber(limit Limit, now time.Time) int64 {
+	start, _ := limit.Window(now)
+	return start.UnixNano() / int64(limit.Duration)
+}
+
+// Window returns the start and end of the fixed window containing now. Windows are aligned to the limit's calendar
+// window in its time zone if it has one, otherwise they are the limit's duration aligned to the unix epoch.
+func (l Limit) Window(now time.Time) (time.Time, time.Time) {
+	if l.Calendar == "" {
+		start := time.Unix(slotStart(now, l.Duration), 0)
+		return start, start.Add(l.Duration)
+	}
+
+	loc, err := loadLocation(l.TimeZone)
+	if err != nil {
+		loc = time.UTC // validated when the limit was parsed
+	}
+
+	t := now.In(loc)
+	switch l.Calendar {
+	case MinuteWindow:
+		start := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+		return start, start.Add(time.Minute)
+	case HourWindow:
+		start := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		return start, start.Add(time.Hour)
+	}
+
+	// days aren't always 24 hours long in time zones with daylight saving time
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	return start, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+}
@@ -0,0 +1,149 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimitWindow(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	now := time.Date(2018, 3, 11, 15, 30, 45, 0, time.UTC)
+	tests := []struct {
+		name      string
+		limit     Limit
+		now       time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "Duration",
+			limit:     Limit{Duration: 10 * time.Second},
+			now:       now,
+			wantStart: time.Date(2018, 3, 11, 15, 30, 40, 0, time.UTC),
+			wantEnd:   time.Date(2018, 3, 11, 15, 30, 50, 0, time.UTC),
+		},
+		{
+			name:      "Minute",
+			limit:     Limit{Calendar: MinuteWindow},
+			now:       now,
+			wantStart: time.Date(2018, 3, 11, 15, 30, 0, 0, time.UTC),
+			wantEnd:   time.Date(2018, 3, 11, 15, 31, 0, 0, time.UTC),
+		},
+		{
+			name:      "Hour",
+			limit:     Limit{Calendar: HourWindow},
+			now:       now,
+			wantStart: time.Date(2018, 3, 11, 15, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2018, 3, 11, 16, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "DayUTC",
+			limit:     Limit{Calendar: DayWindow},
+			now:       now,
+			wantStart: time.Date(2018, 3, 11, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2018, 3, 12, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "DayAcrossDaylightSavingTime",
+			limit:     Limit{Calendar: DayWindow, TimeZone: "America/New_York"},
+			now:       now,
+			wantStart: time.Date(2018, 3, 11, 0, 0, 0, 0, ny),
+			wantEnd:   time.Date(2018, 3, 12, 0, 0, 0, 0, ny),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, end := test.limit.Window(test.now)
+			if !start.Equal(test.wantStart) || !end.Equal(test.wantEnd) {
+				t.Fatalf("expected: (%v, %v) received: (%v, %v)", test.wantStart, test.wantEnd, start, end)
+			}
+		})
+	}
+
+	start, end := Limit{Calendar: DayWindow, TimeZone: "America/New_York"}.Window(now)
+	if end.Sub(start) != 23*time.Hour {
+		t.Errorf("expected the day daylight saving time starts to be 23h, received %v", end.Sub(start))
+	}
+}
+
+func TestValidateCalendar(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   Limit
+		wantErr bool
+	}{
+		{name: "None", limit: Limit{}},
+		{name: "DayWithTimeZone", limit: Limit{Calendar: DayWindow, TimeZone: "Europe/London"}},
+		{name: "UnknownWindow", limit: Limit{Calendar: "week"}, wantErr: true},
+		{name: "UnknownTimeZone", limit: Limit{Calendar: DayWindow, TimeZone: "Mars/Olympus_Mons"}, wantErr: true},
+		{name: "TimeZoneWithoutWindow", limit: Limit{TimeZone: "Europe/London"}, wantErr: true},
+		{name: "LeakyBucket", limit: Limit{Calendar: HourWindow, Algorithm: LeakyBucketAlgorithm}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateCalendar(test.limit)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error: %v received: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.now
+}
+
+func TestLimitCalendarDay(t *testing.T) {
+	limit := Limit{Count: 2, Duration: time.Second, Enabled: true, Calendar: DayWindow}
+	clock := &fixedClock{now: time.Date(2018, 3, 11, 23, 59, 0, 0, time.UTC)}
+	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64)}
+	rl := NewIPRateLimiter(fstore, fstore, clock, nil, TestingLogger, NullReporter{})
+	req := Request{RemoteAddress: "192.168.1.2"}
+
+	for i := 0; i < 2; i++ {
+		if blocked, _, _ := rl.Limit(context.Background(), req); blocked {
+			t.Fatalf("expected request %d to be allowed", i)
+		}
+	}
+
+	clock.now = clock.now.Add(30 * time.Second) // well past the limit duration but the same day
+	if blocked, _, _ := rl.Limit(context.Background(), req); !blocked {
+		t.Fatal("expected request to be blocked for the rest of the day")
+	}
+
+	quota, err := rl.Quota(context.Background(), req)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if want := time.Date(2018, 3, 12, 0, 0, 0, 0, time.UTC); !quota.ResetAt.Equal(want) {
+		t.Errorf("expected quota to reset at: %v received: %v", want, quota.ResetAt)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if blocked, _, _ := rl.Limit(context.Background(), req); blocked {
+		t.Fatal("expected request to be allowed the next day")
+	}
+}
+
+func TestWindowExpiration(t *testing.T) {
+	now := time.Date(2018, 3, 11, 23, 59, 58, 500000000, time.UTC)
+	if got := windowExpiration(now, now.Add(1500*time.Millisecond)); got != 2*time.Second {
+		t.Errorf("expected expiration to round up to 2s, received %v", got)
+	}
+
+	if got := windowExpiration(now, now); got != time.Second {
+		t.Errorf("expected a minimum expiration of 1s, received %v", got)
+	}
+}
@@ -0,0 +1,194 @@
+package guardian
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// hllPrecision is the number of hash bits selecting a register of a hyperLogLog. 2^10 registers estimate distinct
+// counts within about 3%.
+const hllPrecision = 10
+const hllRegisters = 1 << hllPrecision
+
+// hyperLogLog estimates the number of distinct values added to it in constant memory. Values may be added
+// concurrently, its registers being updated atomically.
+type hyperLogLog struct {
+	registers [hllRegisters]uint32
+}
+
+// add adds value, returning whether it changed a register, and so the estimate
+func (h *hyperLogLog) add(value string) bool {
+	f := fnv.New64a()
+	f.Write([]byte(value))
+	hash := mix64(f.Sum64())
+
+	idx := hash >> (64 - hllPrecision)
+	rank := uint32(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	for {
+		r := atomic.LoadUint32(&h.registers[idx])
+		if rank <= r {
+			return false
+		}
+		if atomic.CompareAndSwapUint32(&h.registers[idx], r, rank) {
+			return true
+		}
+	}
+}
+
+func (h *hyperLogLog) estimate() uint64 {
+	sum, zeros := 0.0, 0
+	for i := range h.registers {
+		r := atomic.LoadUint32(&h.registers[i])
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(hllRegisters)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros)) // linear counting is more accurate for small counts
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// mix64 spreads the bits of an FNV hash, whose high bits vary little between similar values
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// NewKeyCardinalityGuard creates a new KeyCardinalityGuard degrading rules that count more than threshold distinct
+// keys within window
+func NewKeyCardinalityGuard(threshold uint64, window time.Duration, clock Clock, logger logrus.FieldLogger, reporter MetricReporter) *KeyCardinalityGuard {
+	return &KeyCardinalityGuard{
+		threshold: threshold,
+		window:    window,
+		clock:     clock,
+		logger:    logger,
+		reporter:  reporter,
+		start:     clock.Now(),
+		sketches:  make(map[string]*ruleSketch),
+		degraded:  make(map[string]bool),
+	}
+}
+
+// KeyCardinalityGuard protects Redis from rules keyed by a value with unbounded cardinality, such as a client
+// controlled header, that would create a counter for every value sent. It estimates the distinct keys each rule
+// counts per window, on this instance, and once a rule exceeds the threshold the rule counts requests by remote
+// address instead for the rest of the window and the next one.
+type KeyCardinalityGuard struct {
+	threshold uint64
+	window    time.Duration
+	clock     Clock
+	logger    logrus.FieldLogger
+	reporter  MetricReporter
+
+	// mu is only write locked to start a window or a rule's sketch, keys being added to sketches atomically
+	mu       sync.RWMutex
+	start    time.Time
+	sketches map[string]*ruleSketch // by rule name, of the current window
+	degraded map[string]bool        // rules that exceeded the threshold in the previous window
+}
+
+// ruleSketch estimates the distinct keys of a rule within a window
+type ruleSketch struct {
+	hyperLogLog
+	exceeded uint32 // set to 1 once the estimate exceeded the threshold
+}
+
+func (s *ruleSketch) isExceeded() bool {
+	return atomic.LoadUint32(&s.exceeded) == 1
+}
+
+// Degraded records key as counted by rule, returning whether rule has too many distinct keys to count by key
+func (g *KeyCardinalityGuard) Degraded(rule Rule, key string) bool {
+	sketch, degraded := g.sketch(rule.Name)
+	if sketch.add(key) && !sketch.isExceeded() {
+		if estimate := sketch.estimate(); estimate > g.threshold && atomic.CompareAndSwapUint32(&sketch.exceeded, 0, 1) {
+			keys := rule.LimitKey
+			if rule.KeyTemplate != nil {
+				keys = rule.KeyTemplate.String()
+			}
+			g.logger.Warnf("rule %v counted about %d distinct %v keys within %v, counting by remote address", rule.Name, estimate, keys, g.window)
+			g.reporter.RuleKeyCardinalityExceeded(rule, estimate)
+		}
+	}
+
+	return sketch.isExceeded() || degraded
+}
+
+// Exceeded returns whether rule has too many distinct keys to count by key, like Degraded, but without recording a
+// key. A nil guard never degrades rules.
+func (g *KeyCardinalityGuard) Exceeded(rule Rule) bool {
+	if g == nil {
+		return false
+	}
+
+	now := g.clock.Now()
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	sketch := g.sketches[rule.Name]
+	exceeded := sketch != nil && sketch.isExceeded()
+	switch elapsed := now.Sub(g.start); {
+	case elapsed >= 2*g.window:
+		return false
+	case elapsed >= g.window:
+		return exceeded // the window has passed, and will be the previous one once a key is recorded
+	default:
+		return exceeded || g.degraded[rule.Name]
+	}
+}
+
+// sketch returns the sketch of the rule named name in the current window, starting a window if the last one has
+// passed, and whether the rule exceeded the threshold in the previous window
+func (g *KeyCardinalityGuard) sketch(name string) (*ruleSketch, bool) {
+	now := g.clock.Now()
+
+	g.mu.RLock()
+	sketch, ok := g.sketches[name]
+	degraded := g.degraded[name]
+	current := now.Sub(g.start) < g.window
+	g.mu.RUnlock()
+	if ok && current {
+		return sketch, degraded
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if now.Sub(g.start) >= g.window {
+		degraded := make(map[string]bool)
+		if now.Sub(g.start) < 2*g.window { // otherwise no requests were counted in the previous window
+			for name, sketch := range g.sketches {
+				if sketch.isExceeded() {
+					degraded[name] = true
+				}
+			}
+		}
+		g.degraded = degraded
+		g.sketches = make(map[string]*ruleSketch)
+		g.start = now
+	}
+
+	sketch, ok = g.sketches[name]
+	if !ok {
+		sketch = &ruleSketch{}
+		g.sketches[name] = sketch
+	}
+
+	return sketch, g.degraded[name]
+}
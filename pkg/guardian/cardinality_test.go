@@ -0,0 +1,130 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		h := &hyperLogLog{}
+		for i := 0; i < n; i++ {
+			h.add(fmt.Sprintf("header.x-client-id=%d", i))
+			h.add(fmt.Sprintf("header.x-client-id=%d", i)) // repeated values aren't counted again
+		}
+
+		got := float64(h.estimate())
+		if got < float64(n)*0.9 || got > float64(n)*1.1 {
+			t.Errorf("expected an estimate within 10%% of %d, received: %v", n, got)
+		}
+	}
+}
+
+type FakeKeyCardinalityReporter struct {
+	NullReporter
+	exceeded []string
+}
+
+func (f *FakeKeyCardinalityReporter) RuleKeyCardinalityExceeded(rule Rule, estimate uint64) {
+	f.exceeded = append(f.exceeded, rule.Name)
+}
+
+func TestKeyCardinalityGuard(t *testing.T) {
+	clock := &fixedClock{time.Now()}
+	reporter := &FakeKeyCardinalityReporter{}
+	guard := NewKeyCardinalityGuard(100, time.Minute, clock, TestingLogger, reporter)
+	rule := Rule{Name: "per-client", LimitKey: "header.x-client-id"}
+
+	for i := 0; i < 50; i++ {
+		if guard.Degraded(rule, fmt.Sprintf("header.x-client-id=%d", i)) {
+			t.Fatalf("expected key %d to be under the threshold", i)
+		}
+	}
+
+	degraded := false
+	for i := 50; i < 200 && !degraded; i++ {
+		degraded = guard.Degraded(rule, fmt.Sprintf("header.x-client-id=%d", i))
+	}
+	if !degraded {
+		t.Fatal("expected the rule to be degraded past the threshold")
+	}
+
+	if guard.Degraded(Rule{Name: "other", LimitKey: "header.x-client-id"}, "header.x-client-id=1") {
+		t.Error("expected other rules not to be degraded")
+	}
+
+	// the rule stays degraded for the next window, even with few keys
+	clock.now = clock.now.Add(time.Minute)
+	if !guard.Degraded(rule, "header.x-client-id=1") {
+		t.Error("expected the rule to stay degraded for the window after exceeding the threshold")
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if guard.Degraded(rule, "header.x-client-id=1") {
+		t.Error("expected the rule to recover after a window under the threshold")
+	}
+
+	if len(reporter.exceeded) != 1 || reporter.exceeded[0] != "per-client" {
+		t.Errorf("expected the threshold crossing to be reported once, received: %v", reporter.exceeded)
+	}
+}
+
+func TestRuleEvaluatorCardinalityGuard(t *testing.T) {
+	rules := []Rule{mustParseRule(t, "per-client", RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Count: 5, Duration: "1m", Enabled: true}, LimitKey: "header.x-client-id"})}
+	clock := &fixedClock{time.Now()}
+	guard := NewKeyCardinalityGuard(10, time.Minute, clock, TestingLogger, NullReporter{})
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, &FakeLimitStore{count: make(map[string]uint64)}, clock, guard, nil, TestingLogger, NullReporter{})
+
+	// looking up keys doesn't count them
+	for i := 0; i < 30; i++ {
+		re.RuleKey(Request{RemoteAddress: "192.168.1.2", Headers: map[string]string{"x-client-id": fmt.Sprint(i)}}, rules[0])
+	}
+	if guard.Exceeded(rules[0]) {
+		t.Fatal("expected looking up keys not to degrade the rule")
+	}
+
+	// a client sending a new id with every request is only limited once the rule falls back to its address
+	blocked := false
+	for i := 0; i < 30 && !blocked; i++ {
+		req := Request{RemoteAddress: "192.168.1.2", Headers: map[string]string{"x-client-id": fmt.Sprint(i)}}
+		_, blocked, _, _ = re.Evaluate(context.Background(), req)
+	}
+	if !blocked {
+		t.Fatal("expected requests to be limited by remote address once the rule is degraded")
+	}
+
+	req := Request{RemoteAddress: "192.168.1.2", Headers: map[string]string{"x-client-id": "1"}}
+	if key := re.RuleKey(req, rules[0]); key != NamespacedKey(ruleNamespace, "per-client")+":192.168.1.2" {
+		t.Errorf("expected the key of the degraded rule to be the remote address, received: %v", key)
+	}
+}
+
+func TestRuleEvaluatorCardinalityGuardKeyTemplate(t *testing.T) {
+	template, err := ParseKeyTemplate("{ip}:{header.x-client-id}:{window}")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	rule := mustParseRule(t, "per-client", RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Count: 5, Duration: "1m", Enabled: true}})
+	rule.KeyTemplate = template
+
+	clock := &fixedClock{time.Now()}
+	fstore := &FakeLimitStore{count: make(map[string]uint64)}
+	guard := NewKeyCardinalityGuard(10, time.Minute, clock, TestingLogger, NullReporter{})
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: []Rule{rule}}, fstore, clock, guard, nil, TestingLogger, NullReporter{})
+
+	// placeholders are interpolated after the limit key is guarded, so the composed key must be guarded too
+	blocked := false
+	for i := 0; i < 30 && !blocked; i++ {
+		req := Request{RemoteAddress: "192.168.1.2", Headers: map[string]string{"x-client-id": fmt.Sprint(i)}}
+		_, blocked, _, _ = re.Evaluate(context.Background(), req)
+	}
+	if !blocked {
+		t.Fatal("expected requests to be limited by remote address once the rule is degraded")
+	}
+
+	if len(fstore.count) > 20 {
+		t.Errorf("expected the degraded rule to stop creating keys, received %d", len(fstore.count))
+	}
+}
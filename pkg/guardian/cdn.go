@@ -0,0 +1,315 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// CDNPreset describes how a CDN passes the address of the client it proxies, and where it publishes the ranges it
+// connects from
+type CDNPreset struct {
+	Name string
+	// Header is the request header carrying the client's address, forwarded by Envoy as a header.<name> descriptor
+	Header string
+	// RangesURL is where the CDN publishes its ranges, empty if it doesn't. The ranges of such CDNs must be supplied.
+	RangesURL   string
+	parseRanges func(r io.Reader) ([]string, error)
+	// parseAddress parses the header's value, parseRemoteAddr if nil
+	parseAddress func(header string) (netip.Addr, bool)
+}
+
+// CDNPresets are the CDNs whose client address headers are supported, by name
+var CDNPresets = map[string]CDNPreset{
+	"cloudflare": {Name: "cloudflare", Header: "cf-connecting-ip", RangesURL: "https://api.cloudflare.com/client/v4/ips", parseRanges: parseCloudflareRanges},
+	"fastly":     {Name: "fastly", Header: "fastly-client-ip", RangesURL: "https://api.fastly.com/public-ip-list", parseRanges: parseFastlyRanges},
+	"cloudfront": {Name: "cloudfront", Header: "cloudfront-viewer-address", RangesURL: "https://ip-ranges.amazonaws.com/ip-ranges.json", parseRanges: parseCloudFrontRanges, parseAddress: parseViewerAddress},
+	"akamai":     {Name: "akamai", Header: "true-client-ip"},
+}
+
+// CDNPresetNames returns the names of the CDNPresets, sorted
+func CDNPresetNames() []string {
+	names := []string{}
+	for name := range CDNPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseCloudflareRanges(r io.Reader) ([]string, error) {
+	body := struct {
+		Result struct {
+			IPv4CIDRs []string `json:"ipv4_cidrs"`
+			IPv6CIDRs []string `json:"ipv6_cidrs"`
+		} `json:"result"`
+	}{}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return append(body.Result.IPv4CIDRs, body.Result.IPv6CIDRs...), nil
+}
+
+func parseFastlyRanges(r io.Reader) ([]string, error) {
+	body := struct {
+		Addresses     []string `json:"addresses"`
+		IPv6Addresses []string `json:"ipv6_addresses"`
+	}{}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return append(body.Addresses, body.IPv6Addresses...), nil
+}
+
+// parseCloudFrontRanges parses the CLOUDFRONT ranges from the list of every AWS service's ranges
+func parseCloudFrontRanges(r io.Reader) ([]string, error) {
+	body := struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}{}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	ranges := []string{}
+	for _, p := range body.Prefixes {
+		if p.Service == "CLOUDFRONT" {
+			ranges = append(ranges, p.IPPrefix)
+		}
+	}
+	for _, p := range body.IPv6Prefixes {
+		if p.Service == "CLOUDFRONT" {
+			ranges = append(ranges, p.IPv6Prefix)
+		}
+	}
+
+	return ranges, nil
+}
+
+// ParseCDNRanges parses ranges supplied as <cdn>=<cidr>, e.g. akamai=23.32.0.0/11, by CDN name
+func ParseCDNRanges(values []string) (map[string][]netip.Prefix, error) {
+	ranges := map[string][]netip.Prefix{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("cdn range %q must be of the form <cdn>=<cidr>", value)
+		}
+
+		prefix, err := netip.ParsePrefix(parts[1])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error parsing cdn range %q", value))
+		}
+		ranges[parts[0]] = append(ranges[parts[0]], prefix.Masked())
+	}
+
+	return ranges, nil
+}
+
+// NewCDNClientIP creates a new CDNClientIP trusting the headers of the named CDNPresets. Supplied ranges, by preset
+// name, are trusted in addition to those the CDN publishes.
+func NewCDNClientIP(presets []string, supplied map[string][]netip.Prefix, client *http.Client, logger logrus.FieldLogger) (*CDNClientIP, error) {
+	c := &CDNClientIP{client: client, logger: logger}
+	for _, name := range presets {
+		preset, ok := CDNPresets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cdn %q", name)
+		}
+
+		if len(preset.RangesURL) == 0 && len(supplied[name]) == 0 {
+			return nil, fmt.Errorf("cdn %v doesn't publish its ranges, they must be supplied", name)
+		}
+
+		c.cdns = append(c.cdns, &cdnRanges{preset: preset, supplied: supplied[name]})
+	}
+
+	for name := range supplied {
+		if _, ok := CDNPresets[name]; !ok {
+			return nil, fmt.Errorf("unknown cdn %q", name)
+		}
+	}
+
+	return c, nil
+}
+
+// CDNClientIP resolves the address of clients behind a CDN from the CDN's client address header. The header is only
+// trusted on requests from the ranges of the CDN, so clients connecting directly can't spoof their address.
+type CDNClientIP struct {
+	client *http.Client
+	logger logrus.FieldLogger
+	cdns   []*cdnRanges
+}
+
+type cdnRanges struct {
+	preset   CDNPreset
+	supplied []netip.Prefix
+
+	mu        sync.RWMutex
+	published []netip.Prefix
+}
+
+func (cr *cdnRanges) contains(addr netip.Addr) bool {
+	for _, prefix := range cr.supplied {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	for _, prefix := range cr.published {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Run refreshes the published ranges of each CDN now and every interval until stop is closed. A CDN keeps its last
+// fetched ranges when refreshing them fails, its header isn't trusted on requests from published ranges until they
+// are fetched.
+func (c *CDNClientIP) Run(interval time.Duration, timeout time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, cr := range c.cdns {
+			if len(cr.preset.RangesURL) == 0 {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			err := c.refresh(ctx, cr)
+			cancel()
+			if err != nil {
+				c.logger.WithError(err).Errorf("error refreshing ranges of cdn %v", cr.preset.Name)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (c *CDNClientIP) refresh(context context.Context, cr *cdnRanges) error {
+	req, err := http.NewRequest(http.MethodGet, cr.preset.RangesURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "error creating ranges request")
+	}
+
+	res, err := c.client.Do(req.WithContext(context))
+	if err != nil {
+		return errors.Wrap(err, "error requesting ranges")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d requesting ranges", res.StatusCode)
+	}
+
+	ranges, err := cr.preset.parseRanges(res.Body)
+	if err != nil {
+		return errors.Wrap(err, "error decoding ranges")
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(ranges))
+	for _, r := range ranges {
+		prefix, err := netip.ParsePrefix(r)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error parsing range %q", r))
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	if len(prefixes) == 0 {
+		return fmt.Errorf("no ranges published")
+	}
+
+	cr.mu.Lock()
+	cr.published = prefixes
+	cr.mu.Unlock()
+
+	c.logger.Debugf("refreshed %d ranges of cdn %v", len(prefixes), cr.preset.Name)
+	return nil
+}
+
+// parseViewerAddress parses CloudFront's viewer address, which always ends in the viewer's port and doesn't bracket
+// IPv6 addresses, e.g. 2001:db8::1:443
+func parseViewerAddress(header string) (netip.Addr, bool) {
+	i := strings.LastIndex(header, ":")
+	if i < 0 || !validPort(header[i:]) {
+		return netip.Addr{}, false
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(header[:i]))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
+
+// ClientAddress returns the address of the client request was proxied for, and the CDN that proxied it, if it is
+// from the ranges of a CDN and has the CDN's header set to a valid address
+func (c *CDNClientIP) ClientAddress(request Request) (string, string, bool) {
+	remote, ok := parseRemoteAddr(request.RemoteAddress)
+	if !ok {
+		return "", "", false
+	}
+
+	for _, cr := range c.cdns {
+		header, ok := request.Headers[cr.preset.Header]
+		if !ok || !cr.contains(remote) {
+			continue
+		}
+
+		parse := cr.preset.parseAddress
+		if parse == nil {
+			parse = parseRemoteAddr
+		}
+
+		client, ok := parse(header)
+		if !ok {
+			return "", "", false
+		}
+
+		return client.String(), cr.preset.Name, true
+	}
+
+	return "", "", false
+}
+
+// WithCDNClientIP wraps f, replacing the RemoteAddress of requests proxied by a CDN with the address of the client
+// they were proxied for. Requests from elsewhere, or without a valid header, keep their remote address.
+func WithCDNClientIP(f RequestBlockerFunc, cdn *CDNClientIP) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		if client, name, ok := cdn.ClientAddress(r); ok {
+			tracef(c, "client address %v from %v header of cdn %v", client, CDNPresets[name].Header, name)
+			r.RemoteAddress = client
+		}
+
+		return f(c, r)
+	}
+}
@@ -0,0 +1,110 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestCDNClientIPRefreshesPublishedRanges(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"result": {"ipv4_cidrs": ["173.245.48.0/20"], "ipv6_cidrs": ["2400:cb00::/32"]}, "success": true}`)
+	}))
+	defer srv.Close()
+
+	c, err := NewCDNClientIP([]string{"cloudflare"}, nil, srv.Client(), TestingLogger)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	req := Request{RemoteAddress: "173.245.48.1", Headers: map[string]string{"cf-connecting-ip": "192.168.1.2"}}
+	if _, _, ok := c.ClientAddress(req); ok {
+		t.Fatal("expected the header not to be trusted before the ranges are fetched")
+	}
+
+	c.cdns[0].preset.RangesURL = srv.URL
+	if err := c.refresh(context.Background(), c.cdns[0]); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	client, name, ok := c.ClientAddress(req)
+	if !ok || client != "192.168.1.2" || name != "cloudflare" {
+		t.Fatalf("expected: (%v, %v, %v) received: (%v, %v, %v)", "192.168.1.2", "cloudflare", true, client, name, ok)
+	}
+}
+
+func TestCDNClientIPRejectsSpoofedHeaders(t *testing.T) {
+	ranges, err := ParseCDNRanges([]string{"akamai=23.32.0.0/11", "cloudflare=2400:cb00::/32", "cloudfront=130.176.0.0/16"})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c, err := NewCDNClientIP([]string{"akamai", "cloudflare", "cloudfront"}, ranges, http.DefaultClient, TestingLogger)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		req      Request
+		expected string
+	}{
+		{name: "FromCDN", req: Request{RemoteAddress: "23.32.0.1", Headers: map[string]string{"true-client-ip": "192.168.1.2"}}, expected: "192.168.1.2"},
+		{name: "FromCDNIPv6", req: Request{RemoteAddress: "[2400:cb00::1]:443", Headers: map[string]string{"cf-connecting-ip": "2001:DB8::1"}}, expected: "2001:db8::1"},
+		{name: "CloudFrontViewer", req: Request{RemoteAddress: "130.176.0.1", Headers: map[string]string{"cloudfront-viewer-address": "192.168.1.2:46532"}}, expected: "192.168.1.2"},
+		{name: "CloudFrontViewerIPv6", req: Request{RemoteAddress: "130.176.0.1", Headers: map[string]string{"cloudfront-viewer-address": "2001:db8::1:46532"}}, expected: "2001:db8::1"},
+		{name: "CloudFrontViewerWithoutPort", req: Request{RemoteAddress: "130.176.0.1", Headers: map[string]string{"cloudfront-viewer-address": "192.168.1.2"}}},
+		{name: "Direct", req: Request{RemoteAddress: "10.0.0.1", Headers: map[string]string{"true-client-ip": "192.168.1.2"}}},
+		{name: "OtherCDNsHeader", req: Request{RemoteAddress: "23.32.0.1", Headers: map[string]string{"cf-connecting-ip": "192.168.1.2"}}},
+		{name: "InvalidHeader", req: Request{RemoteAddress: "23.32.0.1", Headers: map[string]string{"true-client-ip": "not an ip"}}},
+		{name: "MissingHeader", req: Request{RemoteAddress: "23.32.0.1", Headers: map[string]string{}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var received string
+			f := WithCDNClientIP(func(c context.Context, r Request) (bool, uint32, error) {
+				received = r.RemoteAddress
+				return false, 0, nil
+			}, c)
+			f(context.Background(), test.req)
+
+			expected := test.expected
+			if len(expected) == 0 {
+				expected = test.req.RemoteAddress
+			}
+
+			if received != expected {
+				t.Fatalf("expected: %v received: %v", expected, received)
+			}
+		})
+	}
+}
+
+func TestNewCDNClientIPRequiresUnpublishedRanges(t *testing.T) {
+	if _, err := NewCDNClientIP([]string{"akamai"}, nil, http.DefaultClient, TestingLogger); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+
+	if _, err := NewCDNClientIP([]string{"fastly"}, map[string][]netip.Prefix{"unknown": nil}, http.DefaultClient, TestingLogger); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+}
+
+func TestParseCloudFrontRanges(t *testing.T) {
+	ranges, err := CDNPresets["cloudfront"].parseRanges(strings.NewReader(`{
+		"prefixes": [{"ip_prefix": "3.5.140.0/22", "service": "AMAZON"}, {"ip_prefix": "13.32.0.0/15", "service": "CLOUDFRONT"}],
+		"ipv6_prefixes": [{"ipv6_prefix": "2600:9000::/28", "service": "CLOUDFRONT"}]
+	}`))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(ranges) != 2 || ranges[0] != "13.32.0.0/15" || ranges[1] != "2600:9000::/28" {
+		t.Fatalf("expected only the cloudfront ranges, received: %v", ranges)
+	}
+}
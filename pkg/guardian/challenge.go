@@ -0,0 +1,230 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// redisChallengePassesKey is a sorted set of remote addresses that passed a challenge scored by the unix time their
+// pass expires at, shared by all instances
+const redisChallengePassesKey = "guardian_challenge_passes"
+
+// ChallengeResponseStatus is the status of the redirect requests challenged by a ChallengeAction rule are answered with
+const ChallengeResponseStatus = http.StatusFound
+
+// ChallengeReturnParam is the query parameter of the challenge URL giving the URL of the challenged request, which
+// the challenge can return the client to once it passes
+const ChallengeReturnParam = "return_to"
+
+// maxChallengePasses is the maximum number of passes accepted in a single challenge pass request
+const maxChallengePasses = 1000
+
+// validateRedirectURL returns an error if s isn't an absolute http or https URL
+func validateRedirectURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("url %q must be an absolute http or https url", s)
+	}
+
+	return nil
+}
+
+// challengeResponse returns the redirect to the rule's challenge URL that request is answered with, giving the URL
+// of the request in the ChallengeReturnParam. Requests are assumed to have been made over https.
+func (rule Rule) challengeResponse(request Request) StaticResponse {
+	u, err := url.Parse(rule.ChallengeURL)
+	if err != nil {
+		return StaticResponse{Status: ChallengeResponseStatus, Location: rule.ChallengeURL} // validated when parsed
+	}
+
+	returnTo := request.Path
+	if len(request.Authority) > 0 {
+		returnTo = "https://" + request.Authority + request.Path
+	}
+
+	query := u.Query()
+	query.Set(ChallengeReturnParam, returnTo)
+	u.RawQuery = query.Encode()
+
+	return StaticResponse{Status: ChallengeResponseStatus, Location: u.String()}
+}
+
+// NewChallengePasses creates a new ChallengePasses, passes lasting passDuration
+func NewChallengePasses(redis *redis.Client, clock Clock, passDuration time.Duration, logger logrus.FieldLogger) *ChallengePasses {
+	return &ChallengePasses{redis: redis, clock: clock, passDuration: passDuration, logger: logger, passes: make(map[string]time.Time)}
+}
+
+// ChallengePasses records the remote addresses that passed the challenge of a ChallengeAction rule, as reported by
+// the challenge service. Passes are stored in Redis, and synced to every instance so checking them doesn't wait on
+// Redis.
+type ChallengePasses struct {
+	redis        *redis.Client
+	clock        Clock
+	passDuration time.Duration
+	logger       logrus.FieldLogger
+
+	mu     sync.RWMutex
+	passes map[string]time.Time // remote address to pass expiration
+}
+
+// Pass records that remoteAddress passed a challenge, returning when its pass expires
+func (cp *ChallengePasses) Pass(remoteAddress string) (time.Time, error) {
+	if _, ok := parseRemoteAddr(remoteAddress); !ok {
+		return time.Time{}, fmt.Errorf("invalid remote address %q", remoteAddress)
+	}
+	remoteAddress = CanonicalRemoteAddress(remoteAddress)
+
+	expireAt := cp.clock.Now().Add(cp.passDuration)
+	member := redis.Z{Score: float64(expireAt.Unix()), Member: remoteAddress}
+	if err := cp.redis.ZAdd(redisChallengePassesKey, member).Err(); err != nil {
+		return time.Time{}, errors.Wrap(err, fmt.Sprintf("error recording challenge pass of %v", remoteAddress))
+	}
+
+	cp.logger.Infof("%v passed a challenge, allowing it until %v", remoteAddress, expireAt)
+
+	cp.mu.Lock()
+	cp.passes[remoteAddress] = expireAt
+	cp.mu.Unlock()
+
+	return expireAt, nil
+}
+
+// Passed returns whether remoteAddress has a pass that hasn't expired
+func (cp *ChallengePasses) Passed(remoteAddress string) bool {
+	cp.mu.RLock()
+	expireAt, ok := cp.passes[remoteAddress]
+	cp.mu.RUnlock()
+
+	return ok && cp.clock.Now().Before(expireAt)
+}
+
+// Run syncs passes from Redis every syncInterval
+func (cp *ChallengePasses) Run(syncInterval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(syncInterval)
+	for {
+		select {
+		case <-ticker.C:
+			if err := cp.Sync(); err != nil {
+				cp.logger.WithError(err).Warn("error syncing challenge passes")
+			}
+		case <-stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// Sync removes expired passes from Redis and replaces the local passes with those remaining
+func (cp *ChallengePasses) Sync() error {
+	now := strconv.FormatInt(cp.clock.Now().Unix(), 10)
+	pipe := cp.redis.Pipeline()
+	pipe.ZRemRangeByScore(redisChallengePassesKey, "-inf", now)
+	rangeCmd := pipe.ZRangeWithScores(redisChallengePassesKey, 0, -1)
+	if _, err := pipe.Exec(); err != nil {
+		return errors.Wrap(err, "error fetching challenge passes")
+	}
+
+	passes := make(map[string]time.Time)
+	for _, z := range rangeCmd.Val() {
+		if remoteAddress, ok := z.Member.(string); ok {
+			passes[remoteAddress] = time.Unix(int64(z.Score), 0)
+		}
+	}
+
+	cp.mu.Lock()
+	cp.passes = passes
+	cp.mu.Unlock()
+
+	return nil
+}
+
+// CondChallengePassFunc allows requests from remote addresses that passed a challenge, stopping the chain as the
+// whitelist does. Requests from other remote addresses continue down the chain.
+func CondChallengePassFunc(passes *ChallengePasses, logger logrus.FieldLogger) CondRequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, bool, uint32, error) {
+		if !passes.Passed(r.RemoteAddress) {
+			return false, false, RequestsRemainingMax, nil
+		}
+
+		logger.Debugf("allowing request %v that passed a challenge", r)
+		tracef(c, "passed a challenge")
+		return true, false, RequestsRemainingMax, nil
+	}
+}
+
+// NewChallengePassHandler creates a new ChallengePassHandler
+func NewChallengePassHandler(passes *ChallengePasses, logger logrus.FieldLogger) *ChallengePassHandler {
+	return &ChallengePassHandler{passes: passes, logger: logger}
+}
+
+// ChallengePassHandler is an admin HTTP handler accepting the remote addresses that passed a challenge, as verified
+// by the challenge service, as JSON such as {"passes": [{"remote_address": "192.168.1.234"}]}
+type ChallengePassHandler struct {
+	passes *ChallengePasses
+	logger logrus.FieldLogger
+}
+
+// ChallengePass is a remote address that passed a challenge
+type ChallengePass struct {
+	RemoteAddress string `json:"remote_address"`
+}
+
+type challengePassRequest struct {
+	Passes []ChallengePass `json:"passes"`
+}
+
+type challengePassResponse struct {
+	ExpireAt time.Time `json:"expire_at"`
+}
+
+func (h *ChallengePassHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method), h.logger)
+		return
+	}
+
+	body := challengePassRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error decoding request body: %v", err), h.logger)
+		return
+	}
+
+	if len(body.Passes) > maxChallengePasses {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("%d passes exceeds max of %d", len(body.Passes), maxChallengePasses), h.logger)
+		return
+	}
+
+	for _, pass := range body.Passes {
+		if _, ok := parseRemoteAddr(pass.RemoteAddress); !ok {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid remote address %q", pass.RemoteAddress), h.logger)
+			return
+		}
+	}
+
+	res := challengePassResponse{ExpireAt: h.passes.clock.Now().Add(h.passes.passDuration)}
+	for _, pass := range body.Passes {
+		expireAt, err := h.passes.Pass(pass.RemoteAddress)
+		if err != nil {
+			h.logger.WithError(err).Errorf("error recording challenge pass %v", pass)
+			writeJSONError(w, http.StatusInternalServerError, err, h.logger)
+			return
+		}
+		res.ExpireAt = expireAt
+	}
+
+	writeJSON(w, http.StatusOK, res, h.logger)
+}
@@ -0,0 +1,127 @@
+package guardian
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+)
+
+func newTestChallengePasses(t *testing.T, clock Clock) (*ChallengePasses, *miniredis.Miniredis) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	return NewChallengePasses(client, clock, 30*time.Minute, TestingLogger), s
+}
+
+func TestRuleEvaluatorChallenge(t *testing.T) {
+	rules := []Rule{mustParseRule(t, "no-sni", RuleDocument{When: `req.sni == ""`, Action: "challenge", ChallengeURL: "https://captcha.example.com/challenge?site=shop"})}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, &FakeLimitStore{count: make(map[string]uint64)}, LocalClock{}, nil, nil, TestingLogger, NullReporter{})
+
+	hint := NewDecisionHint()
+	req := Request{RemoteAddress: "192.168.1.2", Authority: "shop.example.com", Path: "/cart?item=1"}
+	stop, blocked, _, err := re.Evaluate(WithDecisionHint(context.Background(), hint), req)
+	if err != nil || !stop || !blocked {
+		t.Fatalf("expected request to be blocked, received: (%v, %v, %v)", stop, blocked, err)
+	}
+
+	expected := StaticResponse{Status: ChallengeResponseStatus, Location: "https://captcha.example.com/challenge?return_to=https%3A%2F%2Fshop.example.com%2Fcart%3Fitem%3D1&site=shop"}
+	if got := hint.StaticResponse(); got == nil || *got != expected {
+		t.Fatalf("expected: %v received: %v", expected, got)
+	}
+
+	if reason := hint.BlockReason(); reason == nil || reason.String() != "challenge:no-sni" {
+		t.Errorf("expected the challenge block reason, received: %v", reason)
+	}
+
+	if got := RuleDocumentFromRule(rules[0]); got.ChallengeURL != rules[0].ChallengeURL {
+		t.Errorf("expected rule document with challenge url %v received: %v", rules[0].ChallengeURL, got.ChallengeURL)
+	}
+}
+
+func TestChallengePasses(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	cp, s := newTestChallengePasses(t, clock)
+	defer s.Close()
+
+	if _, err := cp.Pass("not an ip"); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+
+	expireAt, err := cp.Pass("192.168.1.2")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if expected := clock.now.Add(30 * time.Minute); !expireAt.Equal(expected) {
+		t.Fatalf("expected: %v received: %v", expected, expireAt)
+	}
+
+	f := CondChallengePassFunc(cp, TestingLogger)
+	if stop, blocked, _, _ := f(context.Background(), Request{RemoteAddress: "192.168.1.2"}); !stop || blocked {
+		t.Fatalf("expected the passed request to be allowed, received: (%v, %v)", stop, blocked)
+	}
+
+	if stop, _, _, _ := f(context.Background(), Request{RemoteAddress: "192.168.1.3"}); stop {
+		t.Fatal("expected the request without a pass to continue down the chain")
+	}
+
+	// other instances learn of the pass when they sync
+	other, _ := newTestChallengePasses(t, clock)
+	other.redis = cp.redis
+	if err := other.Sync(); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if !other.Passed("192.168.1.2") {
+		t.Error("expected pass to be synced")
+	}
+
+	clock.now = clock.now.Add(31 * time.Minute)
+	if err := other.Sync(); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if other.Passed("192.168.1.2") || cp.Passed("192.168.1.2") {
+		t.Error("expected pass to expire")
+	}
+}
+
+func TestChallengePassHandler(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	cp, s := newTestChallengePasses(t, clock)
+	defer s.Close()
+
+	tests := []struct {
+		name   string
+		passes []ChallengePass
+		status int
+	}{
+		{name: "Valid", passes: []ChallengePass{{RemoteAddress: "192.168.1.2"}}, status: http.StatusOK},
+		{name: "InvalidRemoteAddress", passes: []ChallengePass{{RemoteAddress: "192.168.1.3"}, {RemoteAddress: "somehost"}}, status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, _ := json.Marshal(challengePassRequest{Passes: test.passes})
+			rec := httptest.NewRecorder()
+			NewChallengePassHandler(cp, TestingLogger).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/challenge-passes", bytes.NewReader(body)))
+			if rec.Code != test.status {
+				t.Fatalf("expected status %v received: %v", test.status, rec.Code)
+			}
+		})
+	}
+
+	if !cp.Passed("192.168.1.2") || cp.Passed("192.168.1.3") {
+		t.Fatal("expected only the valid request's pass to be recorded")
+	}
+}
@@ -0,0 +1,47 @@
+package guardian
+
+import (
+	"fmt"
+	"net"
+)
+
+// CIDRConflict is a whitelisted CIDR overlapping a blacklisted CIDR. Requests from the overlap are allowed, since the
+// whitelist is checked before the blacklist.
+type CIDRConflict struct {
+	Whitelisted net.IPNet
+	Blacklisted net.IPNet
+}
+
+func (c CIDRConflict) String() string {
+	return fmt.Sprintf("whitelisted %v overlaps blacklisted %v", c.Whitelisted.String(), c.Blacklisted.String())
+}
+
+// FindCIDRConflicts returns each pair of a whitelisted and a blacklisted CIDR that overlap, in the order of whitelist
+// and then blacklist
+func FindCIDRConflicts(whitelist []net.IPNet, blacklist []net.IPNet) []CIDRConflict {
+	conflicts := []CIDRConflict{}
+	for _, w := range whitelist {
+		wp, ok := PrefixFromIPNet(w)
+		if !ok {
+			continue
+		}
+
+		for _, b := range blacklist {
+			if bp, ok := PrefixFromIPNet(b); ok && wp.Overlaps(bp) {
+				conflicts = append(conflicts, CIDRConflict{Whitelisted: w, Blacklisted: b})
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// FetchCIDRConflicts fetches the whitelist and blacklist stored in Redis and returns their conflicts
+func (rs *RedisConfStore) FetchCIDRConflicts() ([]CIDRConflict, error) {
+	c := rs.pipelinedFetchConf()
+	if c.whitelist == nil || c.blacklist == nil {
+		return nil, fmt.Errorf("error fetching whitelist and blacklist")
+	}
+
+	return FindCIDRConflicts(c.whitelist, c.blacklist), nil
+}
@@ -0,0 +1,60 @@
+package guardian
+
+import (
+	"testing"
+)
+
+func TestFindCIDRConflicts(t *testing.T) {
+	tests := []struct {
+		name      string
+		whitelist []string
+		blacklist []string
+		want      []string
+	}{
+		{name: "Disjoint", whitelist: []string{"10.0.0.0/8"}, blacklist: []string{"192.168.0.0/16"}, want: []string{}},
+		{name: "BlacklistInsideWhitelist", whitelist: []string{"10.0.0.0/8"}, blacklist: []string{"10.1.2.3/32"}, want: []string{"whitelisted 10.0.0.0/8 overlaps blacklisted 10.1.2.3/32"}},
+		{name: "WhitelistInsideBlacklist", whitelist: []string{"10.1.0.0/16"}, blacklist: []string{"10.0.0.0/8"}, want: []string{"whitelisted 10.1.0.0/16 overlaps blacklisted 10.0.0.0/8"}},
+		{name: "IPv6", whitelist: []string{"2001:db8::/32"}, blacklist: []string{"2001:db8:1::/48", "10.0.0.0/8"}, want: []string{"whitelisted 2001:db8::/32 overlaps blacklisted 2001:db8:1::/48"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := []string{}
+			for _, c := range FindCIDRConflicts(parseCIDRs(test.whitelist), parseCIDRs(test.blacklist)) {
+				got = append(got, c.String())
+			}
+
+			if len(got) != len(test.want) {
+				t.Fatalf("expected: %v received: %v", test.want, got)
+			}
+
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("expected: %v received: %v", test.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestConfStoreFetchCIDRConflicts(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	if err := c.AddWhitelistCidrs(parseCIDRs([]string{"10.0.0.0/8"})); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := c.AddBlacklistCidrs(parseCIDRs([]string{"10.1.2.3/32", "192.168.0.0/16"})); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	conflicts, err := c.FetchCIDRConflicts()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(conflicts) != 1 || conflicts[0].Blacklisted.String() != "10.1.2.3/32" {
+		t.Errorf("expected a conflict with 10.1.2.3/32, received: %v", conflicts)
+	}
+}
@@ -0,0 +1,123 @@
+package guardian
+
+import (
+	"sync"
+	"time"
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half_open"
+	}
+
+	return "closed"
+}
+
+// NewCircuitBreaker creates a new CircuitBreaker that opens after failureThreshold consecutive failures and stays
+// open for openDuration before allowing a single probe through
+func NewCircuitBreaker(failureThreshold int, openDuration time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{failureThreshold: failureThreshold, openDuration: openDuration}
+}
+
+// CircuitBreaker tracks the health of a dependency so callers can stop waiting on it while it is failing.
+// A nil CircuitBreaker is always closed.
+type CircuitBreaker struct {
+	sync.Mutex
+	failureThreshold int
+	openDuration     time.Duration
+	failures         int
+	state            circuitState
+	openedAt         time.Time
+}
+
+// Allow returns whether a call to the dependency should be attempted
+func (cb *CircuitBreaker) Allow() bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.Lock()
+	defer cb.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		if time.Since(cb.openedAt) < cb.openDuration {
+			return false
+		}
+		cb.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		return false // a probe is already in flight
+	}
+
+	return true
+}
+
+// Success records a successful call, returning true if it closed the circuit
+func (cb *CircuitBreaker) Success() bool {
+	if cb == nil {
+		return false
+	}
+
+	cb.Lock()
+	defer cb.Unlock()
+
+	cb.failures = 0
+	closed := cb.state != circuitClosed
+	cb.state = circuitClosed
+	return closed
+}
+
+// Failure records a failed call, returning true if it opened the circuit
+func (cb *CircuitBreaker) Failure() bool {
+	if cb == nil {
+		return false
+	}
+
+	cb.Lock()
+	defer cb.Unlock()
+
+	cb.failures++
+	if cb.state == circuitOpen || (cb.state == circuitClosed && cb.failures < cb.failureThreshold) {
+		return false
+	}
+
+	cb.state = circuitOpen
+	cb.openedAt = time.Now()
+	return true
+}
+
+// Open returns whether the circuit is currently open or half open
+func (cb *CircuitBreaker) Open() bool {
+	if cb == nil {
+		return false
+	}
+
+	cb.Lock()
+	defer cb.Unlock()
+
+	return cb.state != circuitClosed
+}
+
+// State returns the name of the circuit's current state, one of closed, open, or half_open
+func (cb *CircuitBreaker) State() string {
+	if cb == nil {
+		return circuitClosed.String()
+	}
+
+	cb.Lock()
+	defer cb.Unlock()
+
+	return cb.state.String()
+}
@@ -0,0 +1,71 @@
+package guardian
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	cb := NewCircuitBreaker(2, time.Hour)
+
+	if cb.Failure() {
+		t.Fatal("expected circuit to remain closed after first failure")
+	}
+
+	if !cb.Failure() {
+		t.Fatal("expected circuit to open after reaching the failure threshold")
+	}
+
+	if cb.Allow() {
+		t.Fatal("expected open circuit to disallow calls")
+	}
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	cb := NewCircuitBreaker(2, time.Hour)
+
+	cb.Failure()
+	cb.Success()
+	if cb.Failure() {
+		t.Fatal("expected success to reset consecutive failures")
+	}
+
+	if !cb.Allow() {
+		t.Fatal("expected closed circuit to allow calls")
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	cb := NewCircuitBreaker(1, 10*time.Millisecond)
+	cb.Failure()
+
+	time.Sleep(20 * time.Millisecond)
+	if !cb.Allow() {
+		t.Fatal("expected a probe to be allowed after the open duration")
+	}
+
+	if cb.Allow() {
+		t.Fatal("expected only a single probe while half open")
+	}
+
+	if !cb.Failure() {
+		t.Fatal("expected failed probe to reopen the circuit")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	cb.Allow()
+	if !cb.Success() {
+		t.Fatal("expected successful probe to close the circuit")
+	}
+
+	if cb.Open() {
+		t.Fatal("expected circuit to be closed")
+	}
+}
+
+func TestNilCircuitBreakerIsClosed(t *testing.T) {
+	var cb *CircuitBreaker
+	if !cb.Allow() || cb.Failure() || cb.Success() || cb.Open() {
+		t.Fatal("expected nil circuit breaker to always be closed")
+	}
+}
@@ -0,0 +1,96 @@
+package guardian
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Clock provides the current time used to determine rate limit windows
+type Clock interface {
+	Now() time.Time
+}
+
+// LocalClock is a Clock that uses the local system time
+type LocalClock struct{}
+
+func (LocalClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewRedisClock creates a new RedisClock
+func NewRedisClock(redis *redis.Client, logger logrus.FieldLogger) *RedisClock {
+	return &RedisClock{redis: redis, logger: logger}
+}
+
+// RedisClock is a Clock that derives the current time from Redis TIME so that all Guardian instances sharing a Redis
+// agree on window boundaries regardless of local clock skew. The offset between the local clock and Redis is
+// cached and refreshed by Run, so Now never blocks on Redis.
+type RedisClock struct {
+	redis  *redis.Client
+	logger logrus.FieldLogger
+
+	mu     sync.RWMutex
+	offset time.Duration
+}
+
+func (rc *RedisClock) Now() time.Time {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return time.Now().Add(rc.offset)
+}
+
+// Offset returns the current estimated offset of the Redis clock from the local clock
+func (rc *RedisClock) Offset() time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.offset
+}
+
+// Run syncs the offset from Redis immediately and then every syncInterval
+func (rc *RedisClock) Run(syncInterval time.Duration, stop <-chan struct{}) {
+	rc.Sync()
+
+	ticker := time.NewTicker(syncInterval)
+	for {
+		select {
+		case <-ticker.C:
+			rc.Sync()
+		case <-stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// Sync fetches the Redis TIME and updates the cached offset. On error the previous offset is kept.
+func (rc *RedisClock) Sync() error {
+	sentAt := time.Now()
+	remote, err := rc.redis.Time().Result()
+	receivedAt := time.Now()
+	if err != nil {
+		err = errors.Wrap(err, "error fetching redis time")
+		rc.logger.WithError(err).Error("error syncing clock, keeping previous offset")
+		return err
+	}
+
+	rc.updateOffset(remote, sentAt, receivedAt)
+	return nil
+}
+
+// updateOffset corrects for the round trip by assuming the remote time was read halfway between sending and receiving
+func (rc *RedisClock) updateOffset(remote time.Time, sentAt time.Time, receivedAt time.Time) {
+	midpoint := sentAt.Add(receivedAt.Sub(sentAt) / 2)
+	offset := remote.Sub(midpoint)
+
+	rc.mu.Lock()
+	rc.offset = offset
+	rc.mu.Unlock()
+
+	rc.logger.Debugf("synced clock with redis, offset %v round trip %v", offset, receivedAt.Sub(sentAt))
+}
@@ -0,0 +1,51 @@
+package guardian
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+)
+
+func TestRedisClockOffsetUsesRoundTripMidpoint(t *testing.T) {
+	clock := NewRedisClock(nil, TestingLogger)
+
+	sentAt := time.Unix(1522969710, 0)
+	receivedAt := sentAt.Add(100 * time.Millisecond)
+	remote := sentAt.Add(5 * time.Second)
+
+	clock.updateOffset(remote, sentAt, receivedAt)
+
+	expected := 5*time.Second - 50*time.Millisecond
+	if got := clock.Offset(); got != expected {
+		t.Fatalf("expected: %v received: %v", expected, got)
+	}
+
+	now := clock.Now()
+	localNow := time.Now()
+	if now.Sub(localNow) < expected-time.Second || now.Sub(localNow) > expected+time.Second {
+		t.Fatalf("expected now to be offset by roughly %v, received %v", expected, now.Sub(localNow))
+	}
+}
+
+func TestRedisClockKeepsOffsetOnError(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis")
+	}
+	defer s.Close()
+
+	clock := NewRedisClock(redis.NewClient(&redis.Options{Addr: s.Addr()}), TestingLogger)
+	clock.updateOffset(time.Unix(100, 0), time.Unix(90, 0), time.Unix(90, 0))
+
+	s.Close() // force the sync to fail
+	if err := clock.Sync(); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+
+	expected := 10 * time.Second
+	if got := clock.Offset(); got != expected {
+		t.Fatalf("expected: %v received: %v", expected, got)
+	}
+}
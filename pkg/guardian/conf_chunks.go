@@ -0,0 +1,59 @@
+package guardian
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ConfChunks paces the changes of large conf imports, so they don't saturate the Redis instances read from for every
+// request. Chunks are applied in transactions of their own, so instances may sync a partially applied import between
+// them. As the changes are found by diffing against the stored conf, an interrupted import resumes where it stopped
+// when it's applied again.
+type ConfChunks struct {
+	// Size is the number of changes applied in each transaction, all of them in a single transaction if 0
+	Size int
+	// Interval is the pause between chunks
+	Interval time.Duration
+	// Progress is called after each chunk is applied with the number of changes applied so far, if it's not nil
+	Progress func(applied int, total int)
+}
+
+// applyConfChanges applies changes to doc in chunks, returning the changes it applied before any error
+func (rs *RedisConfStore) applyConfChanges(doc ConfDocument, changes []ConfChange, chunks ConfChunks) ([]ConfChange, error) {
+	size := chunks.Size
+	if size <= 0 || size > len(changes) {
+		size = len(changes)
+	}
+
+	for applied := 0; applied < len(changes); applied += size {
+		if applied > 0 && chunks.Interval > 0 {
+			time.Sleep(chunks.Interval)
+		}
+
+		end := applied + size
+		if end > len(changes) {
+			end = len(changes)
+		}
+
+		err := rs.UpdateConf(func(w ConfWriter) error {
+			for _, change := range changes[applied:end] {
+				if err := applyConfChange(w, doc, change); err != nil {
+					return errors.Wrap(err, fmt.Sprintf("error applying change %v", change))
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return changes[:applied], err
+		}
+
+		if chunks.Progress != nil {
+			chunks.Progress(end, len(changes))
+		}
+	}
+
+	return changes, nil
+}
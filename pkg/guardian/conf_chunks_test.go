@@ -0,0 +1,75 @@
+package guardian
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestApplyConfDocumentInChunks(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	doc := ConfDocument{Whitelist: []string{"10.0.0.0/8", "10.1.0.0/16", "10.2.0.0/16", "10.3.0.0/16", "10.4.0.0/16"}}
+	progress := []int{}
+	chunks := ConfChunks{Size: 2, Progress: func(applied int, total int) {
+		if total != 5 {
+			t.Errorf("expected total of 5 changes, received: %d", total)
+		}
+		progress = append(progress, applied)
+	}}
+
+	changes, err := c.ApplyConfDocumentInChunks(doc, chunks)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(changes) != 5 {
+		t.Errorf("expected 5 changes, received: %v", changes)
+	}
+
+	if diff := cmp.Diff([]int{2, 4, 5}, progress); diff != "" {
+		t.Errorf("unexpected progress: %v", diff)
+	}
+
+	c.UpdateCachedConf()
+	if diff := cmp.Diff(parseCIDRs(doc.Whitelist), c.GetWhitelist()); diff != "" {
+		t.Errorf("unexpected whitelist: %v", diff)
+	}
+}
+
+func TestApplyConfDocumentInChunksResumes(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	doc := ConfDocument{Blacklist: []string{"1.2.3.4/32", "1.2.3.5/32", "1.2.3.6/32", "1.2.3.7/32"}}
+
+	// Redis going away after the first chunk interrupts the import, returning the changes applied before it
+	interrupted := ConfChunks{Size: 2, Progress: func(applied int, total int) { s.Close() }}
+	changes, err := c.ApplyConfDocumentInChunks(doc, interrupted)
+	if err == nil {
+		t.Fatal("expected error but received nil")
+	}
+
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes applied before the error, received: %v", changes)
+	}
+
+	if err := s.Restart(); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	changes, err = c.ApplyConfDocumentInChunks(doc, ConfChunks{Size: 2})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(changes) != 2 {
+		t.Errorf("expected the 2 remaining changes, received: %v", changes)
+	}
+
+	c.UpdateCachedConf()
+	if diff := cmp.Diff(parseCIDRs(doc.Blacklist), c.GetBlacklist()); diff != "" {
+		t.Errorf("unexpected blacklist: %v", diff)
+	}
+}
@@ -0,0 +1,331 @@
+package guardian
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+)
+
+// ConfChangeKind is how a ConfChange changes the conf
+type ConfChangeKind string
+
+const (
+	ConfAdded   ConfChangeKind = "+"
+	ConfRemoved ConfChangeKind = "-"
+	ConfChanged ConfChangeKind = "~"
+)
+
+// ConfChange is a single change between two ConfDocuments
+type ConfChange struct {
+	Kind ConfChangeKind
+	// Field is the ConfDocument field changed, by its JSON name
+	Field string
+	// Key is the CIDR, host, route, rule name, authority, or key changed, empty for the limit, report only
+	// flag, and limit experiment
+	Key string
+	// From and To are the JSON encoded values before and after the change, empty when there is no value
+	From string
+	To   string
+}
+
+func (c ConfChange) String() string {
+	subject := c.Field
+	if len(c.Key) > 0 {
+		subject += " " + c.Key
+	}
+
+	switch c.Kind {
+	case ConfAdded:
+		if len(c.To) > 0 {
+			return fmt.Sprintf("%v %v %v", c.Kind, subject, c.To)
+		}
+	case ConfChanged:
+		return fmt.Sprintf("%v %v %v -> %v", c.Kind, subject, c.From, c.To)
+	}
+
+	return fmt.Sprintf("%v %v", c.Kind, subject)
+}
+
+// DiffConfDocuments returns the changes applying proposed to live would make, in a stable order. Fields omitted
+// from proposed are left unchanged, while fields it specifies replace those of live entirely. Both documents must
+// be valid.
+func DiffConfDocuments(live ConfDocument, proposed ConfDocument) []ConfChange {
+	changes := []ConfChange{}
+	if proposed.Whitelist != nil {
+		changes = append(changes, diffCIDRs("whitelist", live.Whitelist, proposed.Whitelist)...)
+	}
+
+	if proposed.WhitelistHosts != nil {
+		liveSet, proposedSet := map[string]string{}, map[string]string{}
+		for _, host := range canonicalHostnames(live.WhitelistHosts) {
+			liveSet[host] = ""
+		}
+		for _, host := range canonicalHostnames(proposed.WhitelistHosts) {
+			proposedSet[host] = ""
+		}
+		changes = append(changes, diffEntries("whitelist_host", liveSet, proposedSet)...)
+	}
+
+	if proposed.Blacklist != nil {
+		changes = append(changes, diffCIDRs("blacklist", live.Blacklist, proposed.Blacklist)...)
+	}
+
+	if proposed.Limit != nil {
+		to := normalizedJSON(normalizeLimitDocument(*proposed.Limit))
+		if live.Limit == nil {
+			changes = append(changes, ConfChange{Kind: ConfAdded, Field: "limit", To: to})
+		} else if from := normalizedJSON(normalizeLimitDocument(*live.Limit)); from != to {
+			changes = append(changes, ConfChange{Kind: ConfChanged, Field: "limit", From: from, To: to})
+		}
+	}
+
+	if proposed.ReportOnly != nil {
+		to := normalizedJSON(*proposed.ReportOnly)
+		if live.ReportOnly == nil {
+			changes = append(changes, ConfChange{Kind: ConfAdded, Field: "report_only", To: to})
+		} else if *live.ReportOnly != *proposed.ReportOnly {
+			changes = append(changes, ConfChange{Kind: ConfChanged, Field: "report_only", From: normalizedJSON(*live.ReportOnly), To: to})
+		}
+	}
+
+	if proposed.RouteLimits != nil {
+		changes = append(changes, diffLimitOverrides("route_limit", live.RouteLimits, proposed.RouteLimits)...)
+	}
+
+	if proposed.Rules != nil {
+		liveRules, proposedRules := map[string]string{}, map[string]string{}
+		for name, ruleDoc := range live.Rules {
+			liveRules[name] = normalizedJSON(normalizeRuleDocument(name, ruleDoc))
+		}
+		for name, ruleDoc := range proposed.Rules {
+			proposedRules[name] = normalizedJSON(normalizeRuleDocument(name, ruleDoc))
+		}
+		changes = append(changes, diffEntries("rule", liveRules, proposedRules)...)
+	}
+
+	if proposed.AuthorityLimits != nil {
+		changes = append(changes, diffLimitOverrides("authority_limit", live.AuthorityLimits, proposed.AuthorityLimits)...)
+	}
+
+	if proposed.KeyLimits != nil {
+		changes = append(changes, diffLimitOverrides("key_limit", live.KeyLimits, proposed.KeyLimits)...)
+	}
+
+	if proposed.LimitExperiment != nil {
+		changes = append(changes, diffLimitExperiments(live.LimitExperiment, *proposed.LimitExperiment)...)
+	}
+
+	return changes
+}
+
+func diffCIDRs(field string, live []string, proposed []string) []ConfChange {
+	liveSet, proposedSet := map[string]string{}, map[string]string{}
+	for _, cidr := range cidrStrings(mustParseCIDRs(live)) {
+		liveSet[cidr] = ""
+	}
+	for _, cidr := range cidrStrings(mustParseCIDRs(proposed)) {
+		proposedSet[cidr] = ""
+	}
+
+	return diffEntries(field, liveSet, proposedSet)
+}
+
+// diffLimitExperiments diffs the live experiment, nil if none is running, with proposed, which ends it if its percent
+// is zero
+func diffLimitExperiments(live *LimitExperimentDocument, proposed LimitExperimentDocument) []ConfChange {
+	to := normalizedJSON(normalizeLimitExperimentDocument(proposed))
+	if live == nil {
+		if proposed.Percent == 0 {
+			return nil
+		}
+
+		return []ConfChange{{Kind: ConfAdded, Field: "limit_experiment", To: to}}
+	}
+
+	from := normalizedJSON(normalizeLimitExperimentDocument(*live))
+	if proposed.Percent == 0 {
+		return []ConfChange{{Kind: ConfRemoved, Field: "limit_experiment", From: from}}
+	}
+
+	if from != to {
+		return []ConfChange{{Kind: ConfChanged, Field: "limit_experiment", From: from, To: to}}
+	}
+
+	return nil
+}
+
+func diffLimitOverrides(field string, live map[string]LimitOverrideDocument, proposed map[string]LimitOverrideDocument) []ConfChange {
+	liveEntries, proposedEntries := map[string]string{}, map[string]string{}
+	for name, doc := range live {
+		liveEntries[name] = normalizedJSON(normalizeLimitOverrideDocument(doc))
+	}
+	for name, doc := range proposed {
+		proposedEntries[name] = normalizedJSON(normalizeLimitOverrideDocument(doc))
+	}
+
+	return diffEntries(field, liveEntries, proposedEntries)
+}
+
+// diffEntries diffs maps of keys to JSON encoded values, returning the changes sorted by key
+func diffEntries(field string, live map[string]string, proposed map[string]string) []ConfChange {
+	changes := []ConfChange{}
+	for key, to := range proposed {
+		from, ok := live[key]
+		if !ok {
+			changes = append(changes, ConfChange{Kind: ConfAdded, Field: field, Key: key, To: to})
+		} else if from != to {
+			changes = append(changes, ConfChange{Kind: ConfChanged, Field: field, Key: key, From: from, To: to})
+		}
+	}
+
+	for key, from := range live {
+		if _, ok := proposed[key]; !ok {
+			changes = append(changes, ConfChange{Kind: ConfRemoved, Field: field, Key: key, From: from})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+func mustParseCIDRs(cidrStrings []string) []net.IPNet {
+	cidrs, err := ParseCIDRs(cidrStrings)
+	if err != nil {
+		panic(err) // documents are validated before being diffed
+	}
+
+	return cidrs
+}
+
+// normalizeLimitDocument returns the document as it would be stored, so that equivalent durations compare equal
+func normalizeLimitDocument(ld LimitDocument) LimitDocument {
+	limit, err := ld.Limit()
+	if err != nil {
+		return ld
+	}
+
+	return LimitDocumentFromLimit(limit)
+}
+
+func normalizeLimitOverrideDocument(d LimitOverrideDocument) LimitOverrideDocument {
+	o, err := d.Override()
+	if err != nil {
+		return d
+	}
+
+	return LimitOverrideDocumentFromOverride(o)
+}
+
+func normalizeLimitExperimentDocument(d LimitExperimentDocument) LimitExperimentDocument {
+	e, err := d.Experiment()
+	if err != nil {
+		return d
+	}
+
+	return LimitExperimentDocumentFromExperiment(e)
+}
+
+func normalizeRuleDocument(name string, rd RuleDocument) RuleDocument {
+	rule, err := rd.Rule(name)
+	if err != nil {
+		return rd
+	}
+
+	return RuleDocumentFromRule(rule)
+}
+
+func normalizedJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// ApplyConfDocument applies the changes DiffConfDocuments finds between the conf stored in Redis and doc, returning
+// them. The changes are made in a single transaction, so instances never sync a partially applied conf.
+func (rs *RedisConfStore) ApplyConfDocument(doc ConfDocument) ([]ConfChange, error) {
+	return rs.ApplyConfDocumentInChunks(doc, ConfChunks{})
+}
+
+// ApplyConfDocumentInChunks applies the changes DiffConfDocuments finds between the conf stored in Redis and doc in
+// chunks, returning those it applied, even if it returns an error
+func (rs *RedisConfStore) ApplyConfDocumentInChunks(doc ConfDocument, chunks ConfChunks) ([]ConfChange, error) {
+	if err := doc.Validate(); err != nil {
+		return nil, err
+	}
+
+	live, err := rs.ExportConfDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	return rs.applyConfChanges(doc, DiffConfDocuments(live, doc), chunks)
+}
+
+func applyConfChange(w ConfWriter, doc ConfDocument, change ConfChange) error {
+	removed := change.Kind == ConfRemoved
+	switch change.Field {
+	case "whitelist", "blacklist":
+		cidrs := mustParseCIDRs([]string{change.Key})
+		switch {
+		case change.Field == "whitelist" && removed:
+			return w.RemoveWhitelistCidrs(cidrs)
+		case change.Field == "whitelist":
+			return w.AddWhitelistCidrs(cidrs)
+		case removed:
+			return w.RemoveBlacklistCidrs(cidrs)
+		default:
+			return w.AddBlacklistCidrs(cidrs)
+		}
+	case "whitelist_host":
+		if removed {
+			return w.RemoveWhitelistHosts([]string{change.Key})
+		}
+
+		return w.AddWhitelistHosts([]string{change.Key})
+	case "limit":
+		limit, _ := doc.Limit.Limit() // validated
+		return w.SetLimit(limit)
+	case "report_only":
+		return w.SetReportOnly(*doc.ReportOnly)
+	case "route_limit":
+		route, err := ParseRoutePattern(change.Key)
+		if err != nil {
+			return err
+		}
+
+		if removed {
+			return w.RemoveRouteLimit(route)
+		}
+
+		limit, _ := doc.RouteLimits[change.Key].Override() // validated
+		return w.SetRouteLimit(route, limit)
+	case "authority_limit", "key_limit":
+		scope, docs := AuthorityLimitScope, doc.AuthorityLimits
+		if change.Field == "key_limit" {
+			scope, docs = KeyLimitScope, doc.KeyLimits
+		}
+
+		if removed {
+			return w.RemoveScopedLimit(scope, change.Key)
+		}
+
+		limit, _ := docs[change.Key].Override() // validated
+		return w.SetScopedLimit(scope, change.Key, limit)
+	case "limit_experiment":
+		if removed {
+			return w.RemoveLimitExperiment()
+		}
+
+		e, _ := doc.LimitExperiment.Experiment() // validated
+		return w.SetLimitExperiment(e)
+	case "rule":
+		if removed {
+			return w.RemoveRule(change.Key)
+		}
+
+		rule, _ := doc.Rules[change.Key].Rule(change.Key) // validated
+		return w.SetRule(rule)
+	}
+
+	return fmt.Errorf("unknown conf change %v", change)
+}
@@ -0,0 +1,94 @@
+package guardian
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffConfDocuments(t *testing.T) {
+	reportOnly, enforce := true, false
+	live := ConfDocument{
+		Whitelist:   []string{"10.0.0.0/8", "192.168.0.0/16"},
+		Blacklist:   []string{"1.2.3.4/32"},
+		Limit:       &LimitDocument{Count: 10, Duration: "1m0s", Enabled: true},
+		ReportOnly:  &reportOnly,
+		RouteLimits: map[string]LimitOverrideDocument{"/users/{id}": mustLimitOverrideDocument(t, `{"count": 5, "duration": "1s", "enabled": true}`)},
+	}
+	proposed := ConfDocument{
+		Whitelist:  []string{"10.1.2.3/8", "172.16.0.0/12"},
+		Limit:      &LimitDocument{Count: 20, Duration: "60s", Enabled: true},
+		ReportOnly: &enforce,
+		RouteLimits: map[string]LimitOverrideDocument{
+			"/users/{id}": mustLimitOverrideDocument(t, `{"count": 5, "duration": "1s", "enabled": true}`),
+			"/orders":     mustLimitOverrideDocument(t, `{"count": 1, "duration": "1s", "enabled": true}`),
+		},
+	}
+
+	expected := []string{
+		"+ whitelist 172.16.0.0/12",
+		"- whitelist 192.168.0.0/16",
+		`~ limit {"count":10,"duration":"1m0s","enabled":true} -> {"count":20,"duration":"1m0s","enabled":true}`,
+		"~ report_only true -> false",
+		`+ route_limit /orders {"count":1,"duration":"1s","enabled":true}`,
+	}
+
+	received := []string{}
+	for _, change := range DiffConfDocuments(live, proposed) {
+		received = append(received, change.String())
+	}
+
+	if diff := cmp.Diff(expected, received); diff != "" {
+		t.Errorf("expected: %v received: %v diff: %v", expected, received, diff)
+	}
+}
+
+func TestApplyConfDocument(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	if err := c.AddWhitelistCidrs(parseCIDRs([]string{"10.0.0.0/8"})); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	reportOnly := false
+	doc := ConfDocument{
+		Whitelist:  []string{"192.168.0.0/16"},
+		Limit:      &LimitDocument{Count: 20, Duration: "1s", Enabled: true},
+		ReportOnly: &reportOnly,
+		Rules:      map[string]RuleDocument{"no-admin": {When: `req.path.startsWith("/admin")`, Action: "block"}},
+	}
+
+	changes, err := c.ApplyConfDocument(doc)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(changes) != 5 {
+		t.Errorf("expected 5 changes, received: %v", changes)
+	}
+
+	c.UpdateCachedConf()
+	if diff := cmp.Diff(parseCIDRs([]string{"192.168.0.0/16"}), c.GetWhitelist()); diff != "" {
+		t.Errorf("unexpected whitelist: %v", diff)
+	}
+
+	if expected := (Limit{Count: 20, Duration: time.Second, Enabled: true}); c.GetLimit() != expected {
+		t.Errorf("expected: %v received: %v", expected, c.GetLimit())
+	}
+
+	if rules := c.GetRules(); len(rules) != 1 || rules[0].Name != "no-admin" {
+		t.Errorf("expected rule no-admin, received: %v", rules)
+	}
+
+	// applying the same document again changes nothing
+	changes, err = c.ApplyConfDocument(doc)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, received: %v", changes)
+	}
+}
@@ -0,0 +1,252 @@
+package guardian
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ConfDocument is a serializable document describing Guardian's conf. Fields that are omitted are left unspecified.
+type ConfDocument struct {
+	Whitelist []string `json:"whitelist,omitempty"`
+	// WhitelistHosts are hostnames whose addresses are whitelisted. They are applied to Redis but aren't applied as
+	// defaults, since they are resolved once synced.
+	WhitelistHosts []string       `json:"whitelist_hosts,omitempty"`
+	Blacklist      []string       `json:"blacklist,omitempty"`
+	Limit          *LimitDocument `json:"limit,omitempty"`
+	ReportOnly     *bool          `json:"report_only,omitempty"`
+	// RouteLimits, Rules, AuthorityLimits, KeyLimits, and LimitExperiment are included when exporting and signing the
+	// conf, but aren't applied as defaults
+	RouteLimits     map[string]LimitOverrideDocument `json:"route_limits,omitempty"`
+	Rules           map[string]RuleDocument          `json:"rules,omitempty"`
+	AuthorityLimits map[string]LimitOverrideDocument `json:"authority_limits,omitempty"`
+	KeyLimits       map[string]LimitOverrideDocument `json:"key_limits,omitempty"`
+	// LimitExperiment is omitted when no experiment is running, a percent of zero ends the running experiment
+	LimitExperiment *LimitExperimentDocument `json:"limit_experiment,omitempty"`
+}
+
+// LimitDocument is the serializable form of a Limit
+type LimitDocument struct {
+	Count     uint64 `json:"count"`
+	Duration  string `json:"duration"`
+	Enabled   bool   `json:"enabled"`
+	Algorithm string `json:"algorithm,omitempty"`
+	// EnforcePercent is the percentage of keys the limit blocks, omitted or zero to enforce for all keys
+	EnforcePercent uint `json:"enforce_percent,omitempty"`
+	// Calendar aligns windows to a "minute", "hour", or "day" in TimeZone, which defaults to UTC
+	Calendar string `json:"calendar,omitempty"`
+	TimeZone string `json:"time_zone,omitempty"`
+	// Rollover caps the unused count carried from one fixed window into the next, omitted or zero to disable it
+	Rollover uint64 `json:"rollover,omitempty"`
+}
+
+// LimitDocumentFromLimit converts a Limit to a LimitDocument
+func LimitDocumentFromLimit(limit Limit) LimitDocument {
+	return LimitDocument{Count: limit.Count, Duration: limit.Duration.String(), Enabled: limit.Enabled, Algorithm: string(limit.Algorithm), EnforcePercent: limit.EnforcePercent, Calendar: string(limit.Calendar), TimeZone: limit.TimeZone, Rollover: limit.Rollover}
+}
+
+// Limit converts the document to a Limit
+func (ld LimitDocument) Limit() (Limit, error) {
+	duration, err := time.ParseDuration(ld.Duration)
+	if err != nil {
+		return Limit{}, errors.Wrap(err, "error parsing limit duration")
+	}
+
+	if duration < time.Second {
+		return Limit{}, fmt.Errorf("limit duration %v must be at least 1s", duration)
+	}
+
+	if _, err := ParseAlgorithm(ld.Algorithm); err != nil {
+		return Limit{}, err
+	}
+
+	if err := ValidateEnforcePercent(ld.EnforcePercent); err != nil {
+		return Limit{}, err
+	}
+
+	limit := Limit{
+		Count:          ld.Count,
+		Duration:       duration,
+		Enabled:        ld.Enabled,
+		Algorithm:      Algorithm(ld.Algorithm),
+		EnforcePercent: ld.EnforcePercent,
+		Calendar:       CalendarWindow(ld.Calendar),
+		TimeZone:       ld.TimeZone,
+		Rollover:       ld.Rollover,
+	}
+
+	if err := ValidateCalendar(limit); err != nil {
+		return Limit{}, err
+	}
+
+	if err := ValidateDayBuckets(limit); err != nil {
+		return Limit{}, err
+	}
+
+	if err := ValidateRollover(limit); err != nil {
+		return Limit{}, err
+	}
+
+	return limit, nil
+}
+
+// LoadConfDocument reads and validates a ConfDocument from the file at path, expanding the environment variables it
+// references with ExpandConfTemplate. The document is decoded as YAML if path has a .yaml or .yml extension and as
+// JSON otherwise
+func LoadConfDocument(path string) (ConfDocument, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ConfDocument{}, err
+	}
+
+	expanded, err := ExpandConfTemplate(string(b), os.LookupEnv)
+	if err != nil {
+		return ConfDocument{}, errors.Wrap(err, fmt.Sprintf("error expanding conf document %v", path))
+	}
+
+	if isYAMLPath(path) {
+		b, err := yamlToJSON([]byte(expanded))
+		if err != nil {
+			return ConfDocument{}, errors.Wrap(err, fmt.Sprintf("error parsing conf document %v", path))
+		}
+		expanded = string(b)
+	}
+
+	doc, err := ParseConfDocument(strings.NewReader(expanded))
+	if err != nil {
+		return ConfDocument{}, errors.Wrap(err, fmt.Sprintf("error parsing conf document %v", path))
+	}
+
+	return doc, nil
+}
+
+// ParseConfDocument decodes and validates a JSON ConfDocument
+func ParseConfDocument(r io.Reader) (ConfDocument, error) {
+	doc := ConfDocument{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return ConfDocument{}, errors.Wrap(err, "error decoding conf document")
+	}
+
+	if err := doc.Validate(); err != nil {
+		return ConfDocument{}, err
+	}
+
+	return doc, nil
+}
+
+// Validate returns an error if any of the document's fields are invalid
+func (d ConfDocument) Validate() error {
+	if _, err := ParseCIDRs(d.Whitelist); err != nil {
+		return errors.Wrap(err, "invalid whitelist")
+	}
+
+	for _, host := range d.WhitelistHosts {
+		if err := ValidateHostname(host); err != nil {
+			return errors.Wrap(err, "invalid whitelist host")
+		}
+	}
+
+	if _, err := ParseCIDRs(d.Blacklist); err != nil {
+		return errors.Wrap(err, "invalid blacklist")
+	}
+
+	// narrower scopes are validated against the limit they'll inherit from if the document includes it, otherwise
+	// when they're synced
+	var parent *Limit
+	if d.Limit != nil {
+		limit, err := d.Limit.Limit()
+		if err != nil {
+			return errors.Wrap(err, "invalid limit")
+		}
+		parent = &limit
+	}
+
+	for template, limitDoc := range d.RouteLimits {
+		if _, err := ParseRoutePattern(template); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("invalid route %v", template))
+		}
+
+		if err := validateOverrideDocument(limitDoc, parent); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("invalid limit for route %v", template))
+		}
+	}
+
+	for authority, limitDoc := range d.AuthorityLimits {
+		if err := validateOverrideDocument(limitDoc, parent); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("invalid limit for authority %v", authority))
+		}
+	}
+
+	for key, limitDoc := range d.KeyLimits {
+		if err := validateOverrideDocument(limitDoc, parent); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("invalid limit for key %v", key))
+		}
+	}
+
+	for name, ruleDoc := range d.Rules {
+		if _, err := ruleDoc.Rule(name); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("invalid rule %v", name))
+		}
+	}
+
+	if d.LimitExperiment != nil {
+		e, err := d.LimitExperiment.Experiment()
+		if err == nil && parent != nil {
+			err = ValidateResolvedLimit(e.Limit.Apply(*parent))
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "invalid limit experiment")
+		}
+	}
+
+	return nil
+}
+
+func validateOverrideDocument(doc LimitOverrideDocument, parent *Limit) error {
+	o, err := doc.Override()
+	if err != nil || parent == nil {
+		return err
+	}
+
+	return ValidateResolvedLimit(o.Apply(*parent))
+}
+
+// WhitelistCIDRs returns the parsed whitelist, or nil if unspecified
+func (d ConfDocument) WhitelistCIDRs() []net.IPNet {
+	cidrs, _ := ParseCIDRs(d.Whitelist) // validated when parsed
+	return cidrs
+}
+
+// BlacklistCIDRs returns the parsed blacklist, or nil if unspecified
+func (d ConfDocument) BlacklistCIDRs() []net.IPNet {
+	cidrs, _ := ParseCIDRs(d.Blacklist) // validated when parsed
+	return cidrs
+}
+
+// ParseCIDRs parses cidrStrings, returning an error if any are invalid. nil is returned for nil cidrStrings.
+func ParseCIDRs(cidrStrings []string) ([]net.IPNet, error) {
+	if cidrStrings == nil {
+		return nil, nil
+	}
+
+	cidrs := []net.IPNet{}
+	for _, cidrString := range cidrStrings {
+		_, cidr, err := net.ParseCIDR(cidrString)
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, *cidr)
+	}
+
+	return cidrs, nil
+}
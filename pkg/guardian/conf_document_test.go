@@ -0,0 +1,85 @@
+package guardian
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseConfDocument(t *testing.T) {
+	doc, err := ParseConfDocument(strings.NewReader(`{
+		"whitelist": ["10.0.0.0/8"],
+		"limit": {"count": 10, "duration": "1m", "enabled": true, "algorithm": "leaky_bucket", "enforce_percent": 25},
+		"report_only": false
+	}`))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if want := parseCIDRs([]string{"10.0.0.0/8"}); !cmp.Equal(doc.WhitelistCIDRs(), want) {
+		t.Errorf("expected: %v received: %v", want, doc.WhitelistCIDRs())
+	}
+
+	if doc.BlacklistCIDRs() != nil {
+		t.Errorf("expected unspecified blacklist to be nil, received: %v", doc.BlacklistCIDRs())
+	}
+
+	limit, err := doc.Limit.Limit()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	want := Limit{Count: 10, Duration: time.Minute, Enabled: true, Algorithm: LeakyBucketAlgorithm, EnforcePercent: 25}
+	if limit != want {
+		t.Errorf("expected: %v received: %v", want, limit)
+	}
+
+	if doc.ReportOnly == nil || *doc.ReportOnly != false {
+		t.Errorf("expected report only to be false, received %v", doc.ReportOnly)
+	}
+}
+
+func TestParseConfDocumentRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{name: "InvalidJSON", doc: `{`},
+		{name: "UnknownField", doc: `{"whitelists": []}`},
+		{name: "InvalidCIDR", doc: `{"blacklist": ["10.0.0.0"]}`},
+		{name: "InvalidDuration", doc: `{"limit": {"count": 1, "duration": "forever"}}`},
+		{name: "SubSecondDuration", doc: `{"limit": {"count": 1, "duration": "10ms"}}`},
+		{name: "InvalidAlgorithm", doc: `{"limit": {"count": 1, "duration": "1s", "algorithm": "magic"}}`},
+		{name: "InvalidCalendar", doc: `{"limit": {"count": 1, "duration": "1s", "calendar": "fortnight"}}`},
+		{name: "InvalidTimeZone", doc: `{"limit": {"count": 1, "duration": "1s", "calendar": "day", "time_zone": "Nowhere/Special"}}`},
+		{name: "InvalidEnforcePercent", doc: `{"limit": {"count": 1, "duration": "1s", "enforce_percent": 101}}`},
+		{name: "InvalidRoute", doc: `{"route_limits": {"/users/{id": {"count": 1, "duration": "1s"}}}`},
+		{name: "InvalidRule", doc: `{"rules": {"block-api": {"when": "req.path ==", "action": "block"}}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseConfDocument(strings.NewReader(test.doc)); err == nil {
+				t.Fatal("expected error but received nil")
+			}
+		})
+	}
+}
+
+func TestLoadConfDocumentMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = LoadConfDocument(filepath.Join(dir, "missing.json"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, received: %v", err)
+	}
+}
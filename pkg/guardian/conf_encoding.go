@@ -0,0 +1,106 @@
+package guardian
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// redisLimitKey holds the limit as a versioned JSON record. It's written along with the legacy limit keys, one per
+// field, so instances that only know them can be upgraded alongside this one. The record is authoritative, the legacy
+// keys only being read if it's missing, so limits set by older instances aren't applied by this one once a record has
+// been written. A later version can stop writing the legacy keys, deleting them, once no older instances remain.
+const redisLimitKey = "guardian_conf:limit"
+
+// confRecordVersion is the version of the records the limit and the limit overrides of route, authority, and key
+// limits are encoded as. Records are decoded ignoring fields they don't know, so fields can be added without a new
+// version. The version is only incremented by changes older instances would misinterpret, and records of a newer
+// version are rejected rather than applied. Records written before they were versioned have no version.
+const confRecordVersion = 1
+
+// limitRecord is the encoding of the limit stored in Redis
+type limitRecord struct {
+	Version int `json:"version,omitempty"`
+	LimitDocument
+}
+
+// limitOverrideRecord is the encoding of the limit overrides stored in Redis
+type limitOverrideRecord struct {
+	Version int `json:"version,omitempty"`
+	LimitOverrideDocument
+}
+
+func encodeLimitRecord(limit Limit) (string, error) {
+	b, err := json.Marshal(limitRecord{Version: confRecordVersion, LimitDocument: LimitDocumentFromLimit(limit)})
+	if err != nil {
+		return "", errors.Wrap(err, "error encoding limit")
+	}
+
+	return string(b), nil
+}
+
+func decodeLimitRecord(recordStr string) (Limit, error) {
+	record := limitRecord{}
+	if err := json.Unmarshal([]byte(recordStr), &record); err != nil {
+		return Limit{}, errors.Wrap(err, "error decoding limit")
+	}
+
+	if err := checkConfRecordVersion(record.Version); err != nil {
+		return Limit{}, err
+	}
+
+	return record.Limit()
+}
+
+func encodeLimitOverrideRecord(o LimitOverride) (string, error) {
+	b, err := json.Marshal(limitOverrideRecord{Version: confRecordVersion, LimitOverrideDocument: LimitOverrideDocumentFromOverride(o)})
+	if err != nil {
+		return "", errors.Wrap(err, "error encoding limit override")
+	}
+
+	return string(b), nil
+}
+
+func decodeLimitOverrideRecord(recordStr string) (LimitOverride, error) {
+	record := limitOverrideRecord{}
+	if err := json.Unmarshal([]byte(recordStr), &record); err != nil {
+		return LimitOverride{}, errors.Wrap(err, "error decoding limit override")
+	}
+
+	if err := checkConfRecordVersion(record.Version); err != nil {
+		return LimitOverride{}, err
+	}
+
+	return record.Override()
+}
+
+func checkConfRecordVersion(version int) error {
+	if version > confRecordVersion {
+		return fmt.Errorf("record version %d is newer than the latest known version %d", version, confRecordVersion)
+	}
+
+	return nil
+}
+
+// migrateLimitRecord encodes the limit stored in the legacy limit keys as a record, unless one was already written
+func migrateLimitRecord(client *redis.Client) error {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	c := NewRedisConfStore(client, nil, nil, Limit{}, false, nil, false, logger, NullReporter{}).pipelinedFetchConf()
+	limit, ok := c.limit()
+	if !ok {
+		return nil // no limit was set, or it's invalid and left for the legacy keys to be fixed
+	}
+
+	record, err := encodeLimitRecord(limit)
+	if err != nil {
+		return err
+	}
+
+	return client.SetNX(redisLimitKey, record, 0).Err()
+}
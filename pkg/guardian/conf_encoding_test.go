@@ -0,0 +1,102 @@
+package guardian
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfStoreLimitRecord(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	expected := Limit{Count: 20, Duration: time.Minute, Enabled: true, Algorithm: LeakyBucketAlgorithm}
+	if err := c.SetLimit(expected); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	// an older instance changing the legacy keys without updating the record
+	s.Set(redisLimitCountKey, "30")
+	if got, err := c.FetchLimit(); err != nil || got != expected {
+		t.Fatalf("expected the record to be authoritative, received: (%v, %v)", got, err)
+	}
+
+	// a limit set before the record was written
+	s.Del(redisLimitKey)
+	if got, err := c.FetchLimit(); err != nil || got.Count != 30 {
+		t.Fatalf("expected the legacy keys without a record, received: (%v, %v)", got, err)
+	}
+
+	// a newer instance that stopped writing the legacy keys, adding a field this version doesn't know
+	for _, key := range []string{redisLimitCountKey, redisLimitDurationKey, redisLimitEnabledKey, redisLimitAlgorithmKey, redisLimitEnforcePercentKey, redisLimitCalendarKey, redisLimitTimeZoneKey, redisLimitRolloverKey} {
+		s.Del(key)
+	}
+	s.Set(redisLimitKey, `{"version": 1, "count": 20, "duration": "1m0s", "enabled": true, "algorithm": "leaky_bucket", "burst": 5}`)
+
+	c.UpdateCachedConf()
+	if got := c.GetLimit(); got != expected {
+		t.Fatalf("expected: %v received: %v", expected, got)
+	}
+
+	s.Set(redisLimitKey, `{"version": 2, "count": 40, "duration": "1m0s", "enabled": true}`)
+	c.UpdateCachedConf()
+	if got := c.GetLimit(); got != expected {
+		t.Fatalf("expected a record of a newer version to keep the last known good limit %v, received: %v", expected, got)
+	}
+}
+
+func TestConfStoreLimitOverrideRecords(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	s.HSet(redisRouteLimitsKey, "/unversioned", `{"count": 5}`)
+	s.HSet(redisRouteLimitsKey, "/unknown-fields", `{"version": 1, "count": 10, "burst": 5}`)
+	s.HSet(redisRouteLimitsKey, "/newer", `{"version": 2, "count": 15}`)
+	s.HSet(redisKeyLimitsKey, "10.0.0.1", `{"version": 1, "count": 20, "priority": "high"}`)
+
+	routeLimits, err := c.FetchRouteLimits()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	counts := map[string]uint64{}
+	for _, routeLimit := range routeLimits {
+		counts[routeLimit.Route.String()] = *routeLimit.Limit.Count
+	}
+
+	if len(counts) != 2 || counts["/unversioned"] != 5 || counts["/unknown-fields"] != 10 {
+		t.Fatalf("expected the unversioned and unknown fields records, received: %v", counts)
+	}
+
+	keyLimits, err := c.FetchScopedLimits(KeyLimitScope)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if o, ok := keyLimits["10.0.0.1"]; !ok || *o.Count != 20 {
+		t.Fatalf("unexpected key limits: %v", keyLimits)
+	}
+}
+
+func TestMigrateLimitRecord(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	expected := Limit{Count: 10, Duration: time.Second, Enabled: true}
+	s.Set(redisSchemaVersionKey, "1")
+	s.Set(redisLimitCountKey, "10")
+	s.Set(redisLimitDurationKey, "1s")
+	s.Set(redisLimitEnabledKey, "true")
+
+	if _, _, err := c.Migrate(); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	record, err := s.Get(redisLimitKey)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if got, err := decodeLimitRecord(record); err != nil || got != expected {
+		t.Fatalf("expected: %v received: (%v, %v)", expected, got, err)
+	}
+}
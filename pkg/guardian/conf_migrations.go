@@ -0,0 +1,104 @@
+package guardian
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const redisSchemaVersionKey = "guardian_conf:schema_version"
+const redisSchemaLockKey = "guardian_conf:schema_lock"
+const schemaLockExpiration = time.Minute
+
+// confMigration upgrades the conf stored in Redis from version-1 to version
+type confMigration struct {
+	version     int
+	description string
+	migrate     func(redis *redis.Client) error
+}
+
+// confMigrations are applied in order. New migrations must be appended with the next version and must be safe
+// to run against a store that older Guardian instances are still reading during a rolling upgrade.
+var confMigrations = []confMigration{
+	{
+		version:     1,
+		description: "baseline of the unversioned conf format",
+		migrate:     func(redis *redis.Client) error { return nil },
+	},
+	{
+		version:     2,
+		description: "encode the limit as a versioned record",
+		migrate:     migrateLimitRecord,
+	},
+}
+
+// LatestSchemaVersion is the conf schema version this build of Guardian reads and writes
+func LatestSchemaVersion() int {
+	return confMigrations[len(confMigrations)-1].version
+}
+
+// SchemaVersion returns the version of the conf schema stored in Redis. Zero indicates an unversioned store.
+func (rs *RedisConfStore) SchemaVersion() (int, error) {
+	version, err := rs.redis.Get(redisSchemaVersionKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, errors.Wrap(err, "error fetching schema version")
+	}
+
+	return int(version), nil
+}
+
+// Migrate upgrades the conf stored in Redis to the latest schema version, returning the versions migrated from
+// and to. A lock is held while migrating so concurrently starting instances don't migrate twice.
+func (rs *RedisConfStore) Migrate() (int, int, error) {
+	return rs.migrate(confMigrations)
+}
+
+func (rs *RedisConfStore) migrate(migrations []confMigration) (int, int, error) {
+	latest := migrations[len(migrations)-1].version
+
+	locked, err := rs.redis.SetNX(redisSchemaLockKey, "locked", schemaLockExpiration).Result()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "error acquiring schema lock")
+	}
+
+	if !locked {
+		return 0, 0, fmt.Errorf("schema lock %v is held by another migration", redisSchemaLockKey)
+	}
+	defer rs.redis.Del(redisSchemaLockKey)
+
+	from, err := rs.SchemaVersion()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if from > latest {
+		return from, from, fmt.Errorf("stored schema version %d is newer than the latest known version %d", from, latest)
+	}
+
+	current := from
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		rs.logger.Infof("migrating conf schema to version %d: %v", m.version, m.description)
+		if err := m.migrate(rs.redis); err != nil {
+			return from, current, errors.Wrap(err, fmt.Sprintf("error migrating conf schema to version %d", m.version))
+		}
+
+		if err := rs.redis.Set(redisSchemaVersionKey, strconv.Itoa(m.version), 0).Err(); err != nil {
+			return from, current, errors.Wrap(err, fmt.Sprintf("error setting schema version to %d", m.version))
+		}
+
+		current = m.version
+	}
+
+	return from, current, nil
+}
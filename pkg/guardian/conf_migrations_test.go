@@ -0,0 +1,111 @@
+package guardian
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+func TestMigrateStampsLatestVersion(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	from, to, err := c.Migrate()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if from != 0 || to != LatestSchemaVersion() {
+		t.Fatalf("expected: (%v, %v) received: (%v, %v)", 0, LatestSchemaVersion(), from, to)
+	}
+
+	if s.Exists(redisSchemaLockKey) {
+		t.Fatal("expected schema lock to be released")
+	}
+
+	// migrating again is a noop
+	from, to, err = c.Migrate()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if from != to {
+		t.Fatalf("expected noop migration, received: (%v, %v)", from, to)
+	}
+}
+
+func TestMigrateRunsPendingMigrationsInOrder(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+	s.Set(redisSchemaVersionKey, "1")
+
+	order := []int{}
+	record := func(version int) func(*redis.Client) error {
+		return func(*redis.Client) error {
+			order = append(order, version)
+			return nil
+		}
+	}
+
+	migrations := []confMigration{
+		{version: 1, migrate: record(1)},
+		{version: 2, migrate: record(2)},
+		{version: 3, migrate: record(3)},
+	}
+
+	if _, _, err := c.migrate(migrations); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(order) != 2 || order[0] != 2 || order[1] != 3 {
+		t.Fatalf("expected: %v received: %v", []int{2, 3}, order)
+	}
+
+	if got, _ := c.SchemaVersion(); got != 3 {
+		t.Fatalf("expected: %v received: %v", 3, got)
+	}
+}
+
+func TestMigrateStopsOnError(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	migrations := []confMigration{
+		{version: 1, migrate: func(*redis.Client) error { return nil }},
+		{version: 2, migrate: func(*redis.Client) error { return fmt.Errorf("some error") }},
+	}
+
+	_, to, err := c.migrate(migrations)
+	if err == nil {
+		t.Fatal("expected error but received nil")
+	}
+
+	if to != 1 {
+		t.Fatalf("expected: %v received: %v", 1, to)
+	}
+
+	if got, _ := c.SchemaVersion(); got != 1 {
+		t.Fatalf("expected: %v received: %v", 1, got)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+	s.Set(redisSchemaVersionKey, fmt.Sprintf("%d", LatestSchemaVersion()+1))
+
+	if _, _, err := c.Migrate(); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+}
+
+func TestMigrateRespectsLock(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+	s.Set(redisSchemaLockKey, "locked")
+
+	if _, _, err := c.Migrate(); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+}
@@ -0,0 +1,215 @@
+package guardian
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const redisConfSignatureKey = "guardian_conf:signature"
+
+// SignConfDocument returns an ed25519 signature of the document's canonical encoding
+func SignConfDocument(doc ConfDocument, key ed25519.PrivateKey) ([]byte, error) {
+	msg, err := doc.CanonicalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return ed25519.Sign(key, msg), nil
+}
+
+// VerifyConfDocument returns an error unless signature is a valid ed25519 signature of the document's canonical
+// encoding by key
+func VerifyConfDocument(doc ConfDocument, signature []byte, key ed25519.PublicKey) error {
+	if len(signature) == 0 {
+		return fmt.Errorf("conf is not signed")
+	}
+
+	msg, err := doc.CanonicalJSON()
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(key, msg, signature) {
+		return fmt.Errorf("conf signature is invalid")
+	}
+
+	return nil
+}
+
+// CanonicalJSON encodes the document so that equivalent documents have identical encodings regardless of the order
+// CIDRs and hosts were listed in
+func (d ConfDocument) CanonicalJSON() ([]byte, error) {
+	if d.Whitelist != nil {
+		d.Whitelist = append([]string{}, d.Whitelist...)
+		sort.Strings(d.Whitelist)
+	}
+
+	if d.WhitelistHosts != nil {
+		d.WhitelistHosts = canonicalHostnames(d.WhitelistHosts)
+	}
+
+	if d.Blacklist != nil {
+		d.Blacklist = append([]string{}, d.Blacklist...)
+		sort.Strings(d.Blacklist)
+	}
+
+	return json.Marshal(d) // maps are encoded with sorted keys
+}
+
+// ExportConfDocument fetches the conf stored in Redis as a ConfDocument
+func (rs *RedisConfStore) ExportConfDocument() (ConfDocument, error) {
+	c := rs.pipelinedFetchConf()
+	if len(c.problems) > 0 {
+		return ConfDocument{}, fmt.Errorf("error exporting invalid conf: %v", c.problems)
+	}
+
+	return c.document(), nil
+}
+
+// SignConf signs the conf stored in Redis with key, so that instances verifying the conf apply it. The conf must
+// be re-signed after every change, instances keep their last known good conf until it is.
+func (rs *RedisConfStore) SignConf(key ed25519.PrivateKey) error {
+	doc, err := rs.ExportConfDocument()
+	if err != nil {
+		return err
+	}
+
+	signature, err := SignConfDocument(doc, key)
+	if err != nil {
+		return errors.Wrap(err, "error signing conf")
+	}
+
+	rs.logger.Debugf("Sending SET for key %v", redisConfSignatureKey)
+	return rs.redis.Set(redisConfSignatureKey, base64.StdEncoding.EncodeToString(signature), 0).Err()
+}
+
+// document converts the fetched conf to a ConfDocument, omitting anything that wasn't fetched
+func (c fetchConf) document() ConfDocument {
+	doc := ConfDocument{}
+	if c.whitelist != nil {
+		doc.Whitelist = cidrStrings(c.whitelist)
+	}
+
+	doc.WhitelistHosts = c.whitelistHosts
+
+	if c.blacklist != nil {
+		doc.Blacklist = cidrStrings(c.blacklist)
+	}
+
+	if limit, ok := c.limit(); ok {
+		limitDoc := LimitDocumentFromLimit(limit)
+		doc.Limit = &limitDoc
+	}
+
+	doc.ReportOnly = c.reportOnly
+
+	if c.routeLimits != nil {
+		doc.RouteLimits = map[string]LimitOverrideDocument{}
+		for _, routeLimit := range c.routeLimits {
+			doc.RouteLimits[routeLimit.Route.String()] = LimitOverrideDocumentFromOverride(routeLimit.Limit)
+		}
+	}
+
+	if c.rules != nil {
+		doc.Rules = map[string]RuleDocument{}
+		for _, rule := range c.rules {
+			doc.Rules[rule.Name] = RuleDocumentFromRule(rule)
+		}
+	}
+
+	doc.AuthorityLimits = limitOverrideDocuments(c.authorityLimits)
+	doc.KeyLimits = limitOverrideDocuments(c.keyLimits)
+
+	if c.limitExperiment != nil && c.limitExperiment.Enabled() {
+		experimentDoc := LimitExperimentDocumentFromExperiment(*c.limitExperiment)
+		doc.LimitExperiment = &experimentDoc
+	}
+
+	return doc
+}
+
+// limitOverrideDocuments converts overrides by name to documents, returning nil if overrides is nil
+func limitOverrideDocuments(overrides map[string]LimitOverride) map[string]LimitOverrideDocument {
+	if overrides == nil {
+		return nil
+	}
+
+	docs := map[string]LimitOverrideDocument{}
+	for name, o := range overrides {
+		docs[name] = LimitOverrideDocumentFromOverride(o)
+	}
+
+	return docs
+}
+
+func cidrStrings(cidrs []net.IPNet) []string {
+	strs := []string{}
+	for _, cidr := range cidrs {
+		strs = append(strs, cidr.String())
+	}
+
+	return strs
+}
+
+// LoadConfSigningKey reads a PEM encoded PKCS #8 ed25519 private key from the file at path
+func LoadConfSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEMFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error parsing private key %v", path))
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %v is not an ed25519 key", path)
+	}
+
+	return edKey, nil
+}
+
+// LoadConfVerifyKey reads a PEM encoded PKIX ed25519 public key from the file at path
+func LoadConfVerifyKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEMFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error parsing public key %v", path))
+	}
+
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %v is not an ed25519 key", path)
+	}
+
+	return edKey, nil
+}
+
+func readPEMFile(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %v", path)
+	}
+
+	return block, nil
+}
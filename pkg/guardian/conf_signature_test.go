@@ -0,0 +1,172 @@
+package guardian
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestSigningKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	return public, private
+}
+
+func newTestVerifyingConfStore(t *testing.T, verifyKey ed25519.PublicKey) (*RedisConfStore, *miniredis.Miniredis) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis")
+	}
+
+	redis := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	return NewRedisConfStore(redis, []net.IPNet{}, []net.IPNet{}, Limit{}, false, verifyKey, false, TestingLogger, NullReporter{}), s
+}
+
+func TestConfStoreAppliesSignedConf(t *testing.T) {
+	public, private := newTestSigningKey(t)
+	c, s := newTestVerifyingConfStore(t, public)
+	defer s.Close()
+
+	expectedWhitelist := parseCIDRs([]string{"10.0.0.1/8"})
+	if err := c.AddWhitelistCidrs(expectedWhitelist); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	expectedLimit := Limit{Count: 20, Duration: time.Second, Enabled: true}
+	if err := c.SetLimit(expectedLimit); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c.UpdateCachedConf()
+	if got := c.GetWhitelist(); len(got) != 0 {
+		t.Errorf("expected unsigned whitelist not to be applied, received: %v", got)
+	}
+
+	if err := c.SignConf(private); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c.UpdateCachedConf()
+	if got := c.GetWhitelist(); !cmp.Equal(got, expectedWhitelist) {
+		t.Errorf("expected: %v received: %v", expectedWhitelist, got)
+	}
+
+	if got := c.GetLimit(); got != expectedLimit {
+		t.Errorf("expected: %v received: %v", expectedLimit, got)
+	}
+}
+
+func TestConfStoreRejectsTamperedConf(t *testing.T) {
+	public, private := newTestSigningKey(t)
+	c, s := newTestVerifyingConfStore(t, public)
+	defer s.Close()
+
+	if err := c.AddBlacklistCidrs(parseCIDRs([]string{"12.0.0.1/8"})); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := c.SignConf(private); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c.UpdateCachedConf()
+	s.HSet(redisIPWhitelistKey, "12.0.0.1/8", "true")
+	c.UpdateCachedConf()
+
+	if got := c.GetWhitelist(); len(got) != 0 {
+		t.Errorf("expected tampered whitelist not to be applied, received: %v", got)
+	}
+
+	_, otherPrivate := newTestSigningKey(t)
+	if err := c.SignConf(otherPrivate); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c.UpdateCachedConf()
+	if got := c.GetWhitelist(); len(got) != 0 {
+		t.Errorf("expected whitelist signed by another key not to be applied, received: %v", got)
+	}
+}
+
+func TestConfDocumentCanonicalJSONIgnoresCIDROrder(t *testing.T) {
+	a, err := ConfDocument{Whitelist: []string{"10.0.0.0/8", "12.0.0.0/8"}}.CanonicalJSON()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	b, err := ConfDocument{Whitelist: []string{"12.0.0.0/8", "10.0.0.0/8"}}.CanonicalJSON()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if string(a) != string(b) {
+		t.Errorf("expected %s to equal %s", a, b)
+	}
+}
+
+func TestLoadConfKeys(t *testing.T) {
+	public, private := newTestSigningKey(t)
+	dir, err := ioutil.TempDir("", "guardian")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	privatePath := filepath.Join(dir, "private.pem")
+	publicPath := filepath.Join(dir, "public.pem")
+	if err := ioutil.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := ioutil.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0600); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	loadedPrivate, err := LoadConfSigningKey(privatePath)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	loadedPublic, err := LoadConfVerifyKey(publicPath)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	doc := ConfDocument{Blacklist: []string{"12.0.0.0/8"}}
+	signature, err := SignConfDocument(doc, loadedPrivate)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := VerifyConfDocument(doc, signature, loadedPublic); err != nil {
+		t.Errorf("expected signature to verify, received: %v", err)
+	}
+
+	if _, err := LoadConfVerifyKey(privatePath); err == nil {
+		t.Errorf("expected error loading a private key as a public key")
+	}
+}
@@ -0,0 +1,85 @@
+package guardian
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExpandConfTemplate interpolates environment variables, looked up with lookup, into the conf document template s so
+// one document can be promoted across environments:
+//
+//	${NAME}          the value of NAME, which must be set
+//	${NAME:-default} the value of NAME, or default if NAME is unset or empty
+//	$${              a literal ${
+//
+// Values are inserted verbatim, so a variable used as a JSON string must be quoted in the template. Any other $, such
+// as one ending a route pattern, is left as is.
+func ExpandConfTemplate(s string, lookup func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1]) // the first $ of $${ escapes it
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+
+		end := strings.Index(s[i:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable %q", s[i:])
+		}
+		end += i
+
+		value, err := expandConfVariable(s[i+2:end], lookup)
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString(s[:i])
+		b.WriteString(value)
+		s = s[end+1:]
+	}
+}
+
+// expandConfVariable returns the value of the variable expression expr, the contents of ${...}
+func expandConfVariable(expr string, lookup func(string) (string, bool)) (string, error) {
+	name, def, hasDefault := expr, "", false
+	if i := strings.Index(expr, ":-"); i >= 0 {
+		name, def, hasDefault = expr[:i], expr[i+2:], true
+	}
+
+	if !validEnvName(name) {
+		return "", fmt.Errorf("invalid variable name %q", name)
+	}
+
+	value, ok := lookup(name)
+	if hasDefault && len(value) == 0 {
+		return def, nil
+	}
+
+	if !ok {
+		return "", fmt.Errorf("variable %v is not set and has no default", name)
+	}
+
+	return value, nil
+}
+
+func validEnvName(name string) bool {
+	if len(name) == 0 || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,78 @@
+package guardian
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandConfTemplate(t *testing.T) {
+	env := map[string]string{"LIMIT_COUNT": "100", "EMPTY": "", "CIDR": "10.0.0.0/8"}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{name: "NoVariables", template: `{"count": 10}`, want: `{"count": 10}`},
+		{name: "Set", template: `{"count": ${LIMIT_COUNT}}`, want: `{"count": 100}`},
+		{name: "Quoted", template: `["${CIDR}", "192.168.0.0/16"]`, want: `["10.0.0.0/8", "192.168.0.0/16"]`},
+		{name: "DefaultUnused", template: `${LIMIT_COUNT:-10}`, want: `100`},
+		{name: "DefaultUnset", template: `${UNSET:-10}`, want: `10`},
+		{name: "DefaultEmpty", template: `${EMPTY:-10}`, want: `10`},
+		{name: "EmptyWithoutDefault", template: `"${EMPTY}"`, want: `""`},
+		{name: "Escaped", template: `"$${LIMIT_COUNT}"`, want: `"${LIMIT_COUNT}"`},
+		{name: "OtherDollarsKept", template: `"^/api/.*$"`, want: `"^/api/.*$"`},
+		{name: "Unset", template: `${UNSET}`, wantErr: true},
+		{name: "Unterminated", template: `${LIMIT_COUNT`, wantErr: true},
+		{name: "InvalidName", template: `${1LIMIT}`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ExpandConfTemplate(test.template, lookup)
+			if test.wantErr != (err != nil) {
+				t.Fatalf("expected error: %v received: %v", test.wantErr, err)
+			}
+
+			if got != test.want {
+				t.Errorf("expected: %q received: %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadConfDocumentExpandsEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "conf.json")
+	template := `{"limit": {"count": ${GUARDIAN_TEST_LIMIT_COUNT:-10}, "duration": "1s", "enabled": true}, "report_only": ${GUARDIAN_TEST_REPORT_ONLY}}`
+	if err := ioutil.WriteFile(path, []byte(template), 0600); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if _, err := LoadConfDocument(path); err == nil {
+		t.Error("expected an error loading a document referencing an unset variable")
+	}
+
+	t.Setenv("GUARDIAN_TEST_REPORT_ONLY", "true")
+	t.Setenv("GUARDIAN_TEST_LIMIT_COUNT", "500")
+	doc, err := LoadConfDocument(path)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if doc.Limit.Count != 500 || doc.ReportOnly == nil || !*doc.ReportOnly {
+		t.Errorf("expected the expanded limit count and report only, received: %+v %v", doc.Limit, doc.ReportOnly)
+	}
+}
@@ -0,0 +1,65 @@
+package guardian
+
+import (
+	"net"
+
+	"github.com/go-redis/redis"
+)
+
+// ConfWriter changes the conf. Related changes, such as removing a CIDR from the whitelist and adding it to the
+// blacklist, can be made together with RedisConfStore.UpdateConf so instances never sync a half applied policy.
+type ConfWriter interface {
+	AddWhitelistCidrs(cidrs []net.IPNet) error
+	RemoveWhitelistCidrs(cidrs []net.IPNet) error
+	AddWhitelistHosts(hosts []string) error
+	RemoveWhitelistHosts(hosts []string) error
+	AddBlacklistCidrs(cidrs []net.IPNet) error
+	RemoveBlacklistCidrs(cidrs []net.IPNet) error
+	SetLimit(limit Limit) error
+	SetReportOnly(reportOnly bool) error
+	SetRouteLimit(route RoutePattern, limit LimitOverride) error
+	RemoveRouteLimit(route RoutePattern) error
+	SetScopedLimit(scope LimitScope, name string, o LimitOverride) error
+	RemoveScopedLimit(scope LimitScope, name string) error
+	SetRule(rule Rule) error
+	RemoveRule(name string) error
+	SetLimitExperiment(e LimitExperiment) error
+	RemoveLimitExperiment() error
+}
+
+// UpdateConf makes the changes update makes with w in a single MULTI/EXEC transaction, so instances syncing the conf
+// see all of them or none of them. Nothing is changed if update returns an error. The changes aren't visible to reads
+// until update returns.
+func (rs *RedisConfStore) UpdateConf(update func(w ConfWriter) error) error {
+	if rs.tx != nil {
+		return update(rs) // already queueing the changes of an enclosing update
+	}
+
+	pipe := rs.redis.TxPipeline()
+	defer pipe.Close()
+
+	tx := *rs
+	tx.tx = pipe
+	if err := update(&tx); err != nil {
+		return err
+	}
+
+	rs.logger.Debug("Sending EXEC for conf update")
+	_, err := pipe.Exec()
+	return err
+}
+
+// multi sends the commands queue queues in a transaction of their own, or queues them in the transaction of the
+// enclosing UpdateConf
+func (rs *RedisConfStore) multi(queue func(pipe redis.Pipeliner)) error {
+	if rs.tx != nil {
+		queue(rs.tx)
+		return nil
+	}
+
+	_, err := rs.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		queue(pipe)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,84 @@
+package guardian
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConfStoreUpdateConf(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	cidrs := parseCIDRs([]string{"10.0.0.0/8"})
+	if err := c.AddWhitelistCidrs(cidrs); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	route, err := ParseRoutePattern("/export")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	override := LimitOverrideFromLimit(Limit{Count: 2, Duration: time.Second, Enabled: true})
+	err = c.UpdateConf(func(w ConfWriter) error {
+		if err := w.RemoveWhitelistCidrs(cidrs); err != nil {
+			return err
+		}
+
+		if s.Exists(redisIPBlacklistKey) || !s.Exists(redisIPWhitelistKey) {
+			t.Error("expected changes to be queued until the update returns")
+		}
+
+		if err := w.AddBlacklistCidrs(cidrs); err != nil {
+			return err
+		}
+
+		return w.SetRouteLimit(route, override)
+	})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c.UpdateCachedConf()
+	if got := c.GetWhitelist(); len(got) != 0 {
+		t.Errorf("expected an empty whitelist, received: %v", got)
+	}
+
+	if got := c.GetBlacklist(); len(got) != 1 || got[0].String() != cidrs[0].String() {
+		t.Errorf("expected blacklist %v, received: %v", cidrs, got)
+	}
+
+	if got := c.GetRouteLimits(); len(got) != 1 {
+		t.Errorf("expected the route limit to be set, received: %v", got)
+	}
+}
+
+func TestConfStoreUpdateConfError(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	cidrs := parseCIDRs([]string{"10.0.0.0/8"})
+	err := c.UpdateConf(func(w ConfWriter) error {
+		if err := w.AddBlacklistCidrs(cidrs); err != nil {
+			return err
+		}
+
+		return w.AddWhitelistHosts([]string{"not a host"})
+	})
+	if err == nil {
+		t.Fatal("expected an error for an invalid host")
+	}
+
+	if blacklist, err := c.FetchBlacklist(); err != nil || len(blacklist) != 0 {
+		t.Errorf("expected nothing to change when the update fails, received: %v err: %v", blacklist, err)
+	}
+
+	err = c.UpdateConf(func(w ConfWriter) error {
+		w.AddBlacklistCidrs(cidrs)
+		return fmt.Errorf("aborted")
+	})
+	if err == nil || s.Exists(redisIPBlacklistKey) {
+		t.Errorf("expected an aborted update to change nothing, received: %v", err)
+	}
+}
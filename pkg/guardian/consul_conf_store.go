@@ -0,0 +1,360 @@
+package guardian
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// consulWait is how long a blocking query waits for the key to change before Consul responds with the current value
+const consulWait = 5 * time.Minute
+
+const consulIndexHeader = "X-Consul-Index"
+const consulTokenHeader = "X-Consul-Token"
+
+// NewConsulClient creates a new ConsulClient for the Consul agent at address, e.g. http://localhost:8500,
+// authenticating with token unless it is empty
+func NewConsulClient(address string, token string, client *http.Client) *ConsulClient {
+	return &ConsulClient{address: strings.TrimSuffix(address, "/"), token: token, client: client}
+}
+
+// ConsulClient reads keys from the Consul KV store over Consul's HTTP API
+type ConsulClient struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+// GetKey returns the value of key and the index it was last modified at, or a nil value if key doesn't exist. If
+// index is greater than zero, this is a blocking query that waits up to wait for key to be modified after index.
+func (cc *ConsulClient) GetKey(context context.Context, key string, index uint64, wait time.Duration) ([]byte, uint64, error) {
+	query := url.Values{"raw": {""}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(wait/time.Second)))
+	}
+
+	req, err := http.NewRequest(http.MethodGet, cc.address+"/v1/kv/"+strings.TrimPrefix(key, "/")+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error creating consul request")
+	}
+
+	if len(cc.token) > 0 {
+		req.Header.Set(consulTokenHeader, cc.token)
+	}
+
+	res, err := cc.client.Do(req.WithContext(context))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, fmt.Sprintf("error getting consul key %v", key))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return nil, 0, fmt.Errorf("unexpected status %d getting consul key %v", res.StatusCode, key)
+	}
+
+	newIndex, err := strconv.ParseUint(res.Header.Get(consulIndexHeader), 10, 64)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, fmt.Sprintf("error parsing index of consul key %v", key))
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, newIndex, nil
+	}
+
+	value, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, fmt.Sprintf("error reading consul key %v", key))
+	}
+
+	return value, newIndex, nil
+}
+
+// NewConsulConfStore creates a new ConsulConfStore syncing the conf document stored at key in Consul. The defaults are
+// served until a conf is synced, and for any fields the document omits. Documents with rules answering requests with
+// static responses are only applied if staticResponses, as checked by ValidateStaticResponses.
+func NewConsulConfStore(client *ConsulClient, key string, defaultWhitelist []net.IPNet, defaultBlacklist []net.IPNet, defaultLimit Limit, defaultReportOnly bool, staticResponses bool, logger logrus.FieldLogger, reporter MetricReporter) *ConsulConfStore {
+	defaultConf := newDefaultConf(defaultWhitelist, defaultBlacklist, defaultLimit, defaultReportOnly)
+	return &ConsulConfStore{client: client, key: key, defaults: defaultConf, staticResponses: staticResponses, logger: logger, reporter: reporter, conf: &lockingConf{conf: defaultConf}}
+}
+
+// ConsulConfStore is a ConfStore for shops that keep configuration in Consul. The whole conf is a single
+// ConfDocument, in JSON, stored at one key and watched with blocking queries, so changes are applied by every
+// instance as soon as Consul sees them. Conf is changed by writing the document, e.g. with consul kv put.
+type ConsulConfStore struct {
+	client          *ConsulClient
+	key             string
+	defaults        conf
+	staticResponses bool
+	conf            *lockingConf
+	logger          logrus.FieldLogger
+	reporter        MetricReporter
+}
+
+// Run watches the conf key, applying each version of the document, until stop is closed. Errors are retried after
+// retryInterval.
+func (cs *ConsulConfStore) Run(retryInterval time.Duration, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	index := uint64(0)
+	for {
+		value, newIndex, err := cs.client.GetKey(ctx, cs.key, index, consulWait)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			cs.logger.WithError(err).Errorf("error watching consul key %v, serving the last known good conf", cs.key)
+			select {
+			case <-time.After(retryInterval):
+				continue
+			case <-stop:
+				return
+			}
+		}
+
+		// a blocking query can return before the key changes, e.g. when the wait elapses
+		if newIndex != index || index == 0 {
+			cs.Apply(value)
+		}
+
+		// Consul's index can go backwards, e.g. when the cluster is restored from a snapshot, which restarts the watch
+		if newIndex < index {
+			newIndex = 0
+		}
+		index = newIndex
+	}
+}
+
+// Apply applies the conf document value, a nil value meaning the key doesn't exist. Like a flushed Redis, a missing
+// key or an invalid document is rejected, and the last known good conf is served instead.
+func (cs *ConsulConfStore) Apply(value []byte) {
+	if value == nil {
+		cs.reporter.ConfSync(true)
+		cs.logger.Errorf("consul key %v doesn't exist, serving the last known good conf", cs.key)
+		return
+	}
+
+	doc, err := ParseConfDocument(bytes.NewReader(value))
+	c := conf{}
+	if err == nil {
+		c, err = confFromDocument(doc, cs.defaults)
+	}
+	if err == nil {
+		err = ValidateStaticResponses(c.rules, cs.staticResponses)
+	}
+
+	cs.reporter.ConfSync(err != nil)
+	if err != nil {
+		cs.logger.WithError(err).Errorf("rejecting conf of consul key %v and serving the last known good conf", cs.key)
+		return
+	}
+
+	cs.conf.Lock()
+	defer cs.conf.Unlock()
+
+	// keep the matcher, and its cached matches, unless the route limits changed
+	if sameRouteLimits(c.routeMatcher.routeLimits, cs.conf.routeMatcher.routeLimits) {
+		c.routeMatcher = cs.conf.routeMatcher
+	}
+
+	cs.conf.conf = c
+	cs.conf.syncedAt = time.Now()
+	cs.logger.Debugf("applied conf of consul key %v", cs.key)
+}
+
+// confFromDocument converts a validated document to a conf, taking the fields it omits from defaults
+func confFromDocument(doc ConfDocument, defaults conf) (conf, error) {
+	c := defaults
+	if whitelist := doc.WhitelistCIDRs(); whitelist != nil {
+		c.whitelist = whitelist
+		c.whitelistPrefixes = PrefixesFromIPNets(whitelist)
+	}
+
+	if doc.WhitelistHosts != nil {
+		c.whitelistHosts = append([]string{}, doc.WhitelistHosts...)
+	}
+
+	if blacklist := doc.BlacklistCIDRs(); blacklist != nil {
+		c.blacklist = blacklist
+		c.blacklistPrefixes = PrefixesFromIPNets(blacklist)
+	}
+
+	if doc.Limit != nil {
+		c.limit, _ = doc.Limit.Limit() // validated
+	}
+
+	if doc.ReportOnly != nil {
+		c.reportOnly = *doc.ReportOnly
+	}
+
+	// narrower scopes are validated against the limit they'll inherit from, which may be the default
+	routeLimits := []RouteLimit{}
+	for template, limitDoc := range doc.RouteLimits {
+		route, _ := ParseRoutePattern(template) // validated
+		o, _ := limitDoc.Override()
+		if err := ValidateResolvedLimit(o.Apply(c.limit)); err != nil {
+			return conf{}, errors.Wrap(err, fmt.Sprintf("invalid limit for route %v", template))
+		}
+		routeLimits = append(routeLimits, RouteLimit{Route: route, Limit: o})
+	}
+	SortRouteLimits(routeLimits)
+	c.routeMatcher = NewRouteMatcher(routeLimits, routeMatchCacheSize)
+
+	c.rules = []Rule{}
+	for name, ruleDoc := range doc.Rules {
+		rule, _ := ruleDoc.Rule(name) // validated
+		c.rules = append(c.rules, rule)
+	}
+	SortRules(c.rules)
+
+	var err error
+	if c.authorityLimits, err = overridesFromDocuments(AuthorityLimitScope, doc.AuthorityLimits, c.limit); err != nil {
+		return conf{}, err
+	}
+
+	if c.keyLimits, err = overridesFromDocuments(KeyLimitScope, doc.KeyLimits, c.limit); err != nil {
+		return conf{}, err
+	}
+
+	c.limitExperiment = LimitExperiment{}
+	if doc.LimitExperiment != nil {
+		c.limitExperiment, _ = doc.LimitExperiment.Experiment() // validated
+		if err := ValidateResolvedLimit(c.limitExperiment.Limit.Apply(c.limit)); err != nil {
+			return conf{}, errors.Wrap(err, "invalid limit experiment")
+		}
+	}
+
+	return c, nil
+}
+
+func overridesFromDocuments(scope LimitScope, docs map[string]LimitOverrideDocument, parent Limit) (map[string]LimitOverride, error) {
+	overrides := make(map[string]LimitOverride)
+	for name, doc := range docs {
+		o, _ := doc.Override() // validated
+		if err := ValidateResolvedLimit(o.Apply(parent)); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid %v limit for %v", scope, name))
+		}
+
+		if scope == KeyLimitScope {
+			name = CanonicalRemoteAddress(name)
+		}
+		overrides[name] = o
+	}
+
+	return overrides, nil
+}
+
+func (cs *ConsulConfStore) GetWhitelist() []net.IPNet {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	return append([]net.IPNet{}, cs.conf.whitelist...)
+}
+
+// GetWhitelistPrefixes returns the whitelist as netip.Prefixes without copying it. It must not be modified.
+func (cs *ConsulConfStore) GetWhitelistPrefixes() []netip.Prefix {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	return cs.conf.whitelistPrefixes
+}
+
+func (cs *ConsulConfStore) GetWhitelistHosts() []string {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	return append([]string{}, cs.conf.whitelistHosts...)
+}
+
+func (cs *ConsulConfStore) GetBlacklist() []net.IPNet {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	return append([]net.IPNet{}, cs.conf.blacklist...)
+}
+
+// GetBlacklistPrefixes returns the blacklist as netip.Prefixes without copying it. It must not be modified.
+func (cs *ConsulConfStore) GetBlacklistPrefixes() []netip.Prefix {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	return cs.conf.blacklistPrefixes
+}
+
+func (cs *ConsulConfStore) GetLimit() Limit {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	return cs.conf.limit
+}
+
+func (cs *ConsulConfStore) GetReportOnly() bool {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	return cs.conf.reportOnly
+}
+
+func (cs *ConsulConfStore) GetRouteMatcher() *RouteMatcher {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	return cs.conf.routeMatcher
+}
+
+func (cs *ConsulConfStore) GetRules() []Rule {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	return append([]Rule{}, cs.conf.rules...)
+}
+
+func (cs *ConsulConfStore) GetLimitExperiment() LimitExperiment {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	return cs.conf.limitExperiment
+}
+
+// ResolveLimit returns the effective limit of request, through the route scope of routeLimit unless it is nil, and
+// the scope its requests are counted in
+func (cs *ConsulConfStore) ResolveLimit(request Request, routeLimit *RouteLimit) (Limit, string) {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	return resolveLimit(cs.conf.limit, cs.conf.authorityLimits, cs.conf.keyLimits, request, routeLimit, nil)
+}
+
+// ExplainLimit resolves the effective limit of request like ResolveLimit, along with each scope applied
+func (cs *ConsulConfStore) ExplainLimit(request Request, routeLimit *RouteLimit) LimitResolution {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	steps := []LimitResolutionStep{}
+	limit, _ := resolveLimit(cs.conf.limit, cs.conf.authorityLimits, cs.conf.keyLimits, request, routeLimit, &steps)
+	return LimitResolution{Limit: limit, Steps: steps}
+}
+
+// SyncedAt returns when a conf synced from Consul was last applied, or the zero time if one hasn't been
+func (cs *ConsulConfStore) SyncedAt() time.Time {
+	cs.conf.RLock()
+	defer cs.conf.RUnlock()
+
+	return cs.conf.syncedAt
+}
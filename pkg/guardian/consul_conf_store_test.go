@@ -0,0 +1,178 @@
+package guardian
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul serves a single key of the Consul KV API, answering blocking queries when the key is modified
+type fakeConsul struct {
+	mu      sync.Mutex
+	value   []byte
+	index   uint64
+	changed chan struct{}
+	tokens  []string
+}
+
+func newFakeConsul(value string) *fakeConsul {
+	return &fakeConsul{value: []byte(value), index: 1, changed: make(chan struct{})}
+}
+
+func (f *fakeConsul) put(value []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.value = value
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.tokens = append(f.tokens, r.Header.Get(consulTokenHeader))
+	index, changed := f.index, f.changed
+	f.mu.Unlock()
+
+	if after, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); after >= index {
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.Header().Set(consulIndexHeader, strconv.FormatUint(f.index, 10))
+	if f.value == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write(f.value)
+}
+
+func TestConsulClientGetKey(t *testing.T) {
+	consul := newFakeConsul(`{"report_only": true}`)
+	srv := httptest.NewServer(consul)
+	defer srv.Close()
+
+	client := NewConsulClient(srv.URL, "secret", srv.Client())
+	value, index, err := client.GetKey(context.Background(), "guardian/conf", 0, time.Second)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if string(value) != `{"report_only": true}` || index != 1 {
+		t.Fatalf("expected: (%v, %v) received: (%s, %v)", `{"report_only": true}`, 1, value, index)
+	}
+
+	go consul.put(nil)
+	value, index, err = client.GetKey(context.Background(), "guardian/conf", index, time.Second)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if value != nil || index != 2 {
+		t.Fatalf("expected the deleted key to have no value at index 2, received: (%s, %v)", value, index)
+	}
+
+	if consul.tokens[0] != "secret" {
+		t.Errorf("expected the token to be sent, received: %q", consul.tokens[0])
+	}
+}
+
+func newTestConsulConfStore(t *testing.T, consul *fakeConsul) (*ConsulConfStore, func()) {
+	t.Helper()
+
+	srv := httptest.NewServer(consul)
+	limit := Limit{Count: 10, Duration: time.Minute, Enabled: true}
+	store := NewConsulConfStore(NewConsulClient(srv.URL, "", srv.Client()), "guardian/conf", nil, nil, limit, false, false, TestingLogger, NullReporter{})
+	return store, srv.Close
+}
+
+func TestConsulConfStoreApply(t *testing.T) {
+	store, done := newTestConsulConfStore(t, newFakeConsul(""))
+	defer done()
+
+	store.Apply([]byte(`{
+		"blacklist": ["10.0.0.0/8"],
+		"report_only": true,
+		"route_limits": {"/search": {"count": 2}},
+		"rules": {"b-block": {"when": "req.path == \"/admin\"", "action": "block"}, "a-allow": {"when": "ip.inCIDR(\"192.168.0.0/16\")", "action": "allow"}}
+	}`))
+
+	if !store.GetReportOnly() || len(store.GetBlacklist()) != 1 || store.SyncedAt().IsZero() {
+		t.Fatalf("expected the document to be applied, received report only: %v blacklist: %v", store.GetReportOnly(), store.GetBlacklist())
+	}
+
+	// omitted fields keep their defaults, and narrower scopes inherit from them
+	if limit := store.GetLimit(); limit.Count != 10 {
+		t.Errorf("expected the default limit, received: %v", limit)
+	}
+
+	routeLimit, ok := store.GetRouteMatcher().Match("/search")
+	if limit, _ := store.ResolveLimit(Request{Path: "/search"}, &routeLimit); !ok || limit.Count != 2 {
+		t.Errorf("expected the route limit to apply, received: %v", routeLimit)
+	}
+
+	if rules := store.GetRules(); len(rules) != 2 || rules[0].Name != "a-allow" {
+		t.Errorf("expected the rules sorted by name, received: %v", rules)
+	}
+
+	// invalid documents, serve rules the store isn't configured to honor, and a deleted key are rejected, serving the
+	// last known good conf
+	serve := []byte(`{"rules": {"honeypot": {"when": "true", "action": "serve", "response": {"status": 200}}}}`)
+	challenge := []byte(`{"rules": {"captcha": {"when": "true", "action": "challenge", "challenge_url": "https://challenge.example.com"}}}`)
+	for _, value := range [][]byte{[]byte(`{"blacklist": ["not a cidr"]}`), []byte(`{"route_limits": {"/search": {"duration": "500ms"}}}`), serve, challenge, nil} {
+		store.Apply(value)
+		if !store.GetReportOnly() || len(store.GetBlacklist()) != 1 {
+			t.Errorf("expected %s to be rejected", value)
+		}
+	}
+}
+
+func TestConsulConfStoreRun(t *testing.T) {
+	consul := newFakeConsul(`{"report_only": true}`)
+	store, done := newTestConsulConfStore(t, consul)
+	defer done()
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		store.Run(10*time.Millisecond, stop)
+		close(stopped)
+	}()
+
+	waitFor := func(cond func() bool) bool {
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if cond() {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !waitFor(store.GetReportOnly) {
+		t.Fatal("expected the conf to be synced")
+	}
+
+	consul.put([]byte(`{"report_only": false}`))
+	if !waitFor(func() bool { return !store.GetReportOnly() }) {
+		t.Fatal("expected the change to be synced by the blocking query")
+	}
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Run to return once stopped")
+	}
+}
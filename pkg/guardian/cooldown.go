@@ -0,0 +1,120 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const cooldownNamespace = "cooldown"
+
+// maxCooldownWindows bounds the quiet windows a cooldown requires
+const maxCooldownWindows = 1000
+
+// Cooldown keeps a key blocked by a rule's limit blocked until it has been quiet, counting at most Percent of the
+// limit count, for Windows consecutive windows. Clients retrying at exactly the limit rate then stay blocked rather
+// than flapping between blocked and allowed.
+type Cooldown struct {
+	Percent uint `json:"percent"`
+	Windows uint `json:"windows"`
+}
+
+// Validate returns an error if the percent isn't between 0 and 100, if the windows aren't between 1 and
+// maxCooldownWindows, or if limit doesn't count in plain fixed windows
+func (c Cooldown) Validate(limit Limit) error {
+	if c.Percent > 100 {
+		return fmt.Errorf("cooldown percent %d must be between 0 and 100", c.Percent)
+	}
+
+	if c.Windows < 1 || c.Windows > maxCooldownWindows {
+		return fmt.Errorf("cooldown windows %d must be between 1 and %d", c.Windows, maxCooldownWindows)
+	}
+
+	return validatePlainFixedWindows(limit, "cooldowns")
+}
+
+func (c Cooldown) String() string {
+	return fmt.Sprintf("cooldown of %d windows under %d%%", c.Windows, c.Percent)
+}
+
+// CooldownCounter is a Counter that is also capable of keeping keys blocked until they cool down
+type CooldownCounter interface {
+	Counter
+
+	// IncrCooldown adds amount to the count of key in window, returning its count and the number of consecutive
+	// quiet windows, counting at most quietCount, it must still wait before it's allowed again. A count over count
+	// starts a cooldown of windows quiet windows. The state of key is kept for expireIn.
+	IncrCooldown(context context.Context, key string, amount uint, window int64, count uint64, quietCount uint64, windows uint, expireIn time.Duration) (uint64, uint64, error)
+}
+
+// cooldownScript atomically adds to the count of the current window of a hash of the window last counted, its count,
+// and the quiet windows remaining of the key's cooldown. When a new window is counted, a cooldown is shortened by the
+// last window counted if it was quiet, restarted if it wasn't, and shortened by the windows between, which counted
+// nothing.
+// KEYS[1] cooldown key
+// ARGV[1] current window number, ARGV[2] amount to add, ARGV[3] limit count, ARGV[4] quiet count,
+// ARGV[5] quiet windows required, ARGV[6] expiration in seconds
+var cooldownScript = redis.NewScript(`
+local window = tonumber(ARGV[1])
+local windows = tonumber(ARGV[5])
+local fields = redis.call("HGETALL", KEYS[1])
+local state = {}
+for i = 1, #fields, 2 do
+	state[fields[i]] = tonumber(fields[i + 1])
+end
+local last = state["window"]
+local count = state["count"] or 0
+local cooldown = state["cooldown"] or 0
+
+if last ~= window then
+	if last and cooldown > 0 then
+		if count <= tonumber(ARGV[4]) then
+			cooldown = cooldown - 1
+		else
+			cooldown = windows
+		end
+		cooldown = math.max(cooldown - (window - last - 1), 0)
+	end
+	count = 0
+end
+
+count = count + tonumber(ARGV[2])
+if count > tonumber(ARGV[3]) then
+	cooldown = windows
+end
+
+redis.call("HMSET", KEYS[1], "window", ARGV[1], "count", count, "cooldown", cooldown)
+redis.call("EXPIRE", KEYS[1], ARGV[6])
+return {count, cooldown}
+`)
+
+func (rs *RedisCounter) IncrCooldown(context context.Context, key string, amount uint, window int64, count uint64, quietCount uint64, windows uint, expireIn time.Duration) (uint64, uint64, error) {
+	return rs.runPairScript("cooldown", cooldownScript, []string{key}, window, amount, count, quietCount, windows, expireSeconds(expireIn))
+}
+
+// incrCooldown counts incrBy against the window of key containing now, returning the count and whether the key is
+// cooling down and must be blocked regardless of the count
+func incrCooldown(context context.Context, counter Counter, key string, limit Limit, cooldown Cooldown, incrBy uint, now time.Time) (uint64, bool, error) {
+	cooling, ok := counter.(CooldownCounter)
+	if !ok {
+		return 0, false, fmt.Errorf("counter does not support cooldowns")
+	}
+
+	window := windowNumber(limit, now)
+	quietCount := limit.Count * uint64(cooldown.Percent) / 100
+
+	// a key quiet for the whole cooldown has cooled down, so its state needn't be kept any longer
+	expireIn := time.Duration(cooldown.Windows+1) * limit.Duration
+	count, remaining, err := cooling.IncrCooldown(context, NamespacedKey(cooldownNamespace, key), incrBy, window, limit.Count, quietCount, cooldown.Windows, expireIn)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if remaining > 0 {
+		tracef(context, "%v is cooling down for %d more windows under %d requests", key, remaining, quietCount)
+	}
+
+	return count, remaining > 0, nil
+}
@@ -0,0 +1,117 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRedisCounterIncrCooldown(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	tests := []struct {
+		name         string
+		window       int64
+		amount       uint
+		wantCount    uint64
+		wantCooldown uint64
+	}{
+		{name: "OverLimit", window: 100, amount: 5, wantCount: 5, wantCooldown: 2},
+		{name: "LastWindowNotQuiet", window: 101, amount: 3, wantCount: 3, wantCooldown: 2},
+		{name: "StillNotQuiet", window: 102, amount: 1, wantCount: 1, wantCooldown: 2},
+		{name: "OneQuietWindow", window: 103, amount: 2, wantCount: 2, wantCooldown: 1},
+		{name: "CooledDown", window: 104, amount: 1, wantCount: 1, wantCooldown: 0},
+		{name: "OverLimitAgain", window: 105, amount: 5, wantCount: 5, wantCooldown: 2},
+		{name: "EmptyWindowsAreQuiet", window: 110, amount: 1, wantCount: 1, wantCooldown: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count, cooldown, err := c.IncrCooldown(context.Background(), "cooldown", test.amount, test.window, 4, 2, 2, time.Minute)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if count != test.wantCount || cooldown != test.wantCooldown {
+				t.Errorf("expected: (%v, %v) received: (%v, %v)", test.wantCount, test.wantCooldown, count, cooldown)
+			}
+		})
+	}
+}
+
+func TestRuleEvaluatorCooldown(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	doc := RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Count: 2, Duration: "1m", Enabled: true}, Cooldown: &Cooldown{Percent: 50, Windows: 1}}
+	rules := []Rule{mustParseRule(t, "flapping", doc)}
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, c, clock, nil, nil, TestingLogger, NullReporter{})
+	req := Request{RemoteAddress: "192.168.1.2"}
+
+	blocks := func(n int) int {
+		blocked := 0
+		for i := 0; i < n; i++ {
+			hint := NewDecisionHint()
+			_, b, _, err := re.Evaluate(WithDecisionHint(context.Background(), hint), req)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			if _, ok := hint.Get(); ok {
+				t.Fatal("expected blocks of a key cooling down not to be cached")
+			}
+			if b {
+				blocked++
+			}
+		}
+		return blocked
+	}
+
+	if got := blocks(3); got != 1 {
+		t.Fatalf("expected 1 request over the limit to be blocked, received: %d", got)
+	}
+
+	// retrying at the limit rate isn't quiet, so the key stays blocked
+	clock.now = clock.now.Add(time.Minute)
+	if got := blocks(2); got != 2 {
+		t.Fatalf("expected requests at the limit rate to be blocked, received: %d", got)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if got := blocks(1); got != 1 {
+		t.Fatalf("expected request in the quiet window to be blocked, received: %d", got)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if got := blocks(2); got != 0 {
+		t.Fatalf("expected requests after the cooldown to be allowed, received: %d", got)
+	}
+
+	if got := RuleDocumentFromRule(rules[0]); got.Cooldown == nil || *got.Cooldown != *doc.Cooldown {
+		t.Fatalf("expected rule document with cooldown %v received: %v", doc.Cooldown, got.Cooldown)
+	}
+}
+
+func TestRuleDocumentRejectsInvalidCooldown(t *testing.T) {
+	limit := &LimitDocument{Count: 10, Duration: "1m", Enabled: true}
+	tests := []struct {
+		name string
+		doc  RuleDocument
+	}{
+		{name: "PercentTooLarge", doc: RuleDocument{When: `true`, Action: "limit", Limit: limit, Cooldown: &Cooldown{Percent: 101, Windows: 1}}},
+		{name: "NoWindows", doc: RuleDocument{When: `true`, Action: "limit", Limit: limit, Cooldown: &Cooldown{Percent: 50}}},
+		{name: "TooManyWindows", doc: RuleDocument{When: `true`, Action: "limit", Limit: limit, Cooldown: &Cooldown{Percent: 50, Windows: 1001}}},
+		{name: "LeakyBucket", doc: RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Count: 10, Duration: "1m", Enabled: true, Algorithm: string(LeakyBucketAlgorithm)}, Cooldown: &Cooldown{Percent: 50, Windows: 1}}},
+		{name: "Baseline", doc: RuleDocument{When: `true`, Action: "limit", Limit: limit, Baseline: &BaselineDocument{Multiplier: 2, Period: "1h"}, Cooldown: &Cooldown{Percent: 50, Windows: 1}}},
+		{name: "WindowedKeyTemplate", doc: RuleDocument{When: `true`, Action: "limit", Limit: limit, KeyTemplate: "{ip}:{window}", Cooldown: &Cooldown{Percent: 50, Windows: 1}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.doc.Rule("flapping"); err == nil {
+				t.Fatal("expected error but received nil")
+			}
+		})
+	}
+}
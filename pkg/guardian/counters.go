@@ -0,0 +1,77 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// QuotaProvider provides the current usage of a rate limit for a request without counting the request
+type QuotaProvider interface {
+	Quota(context context.Context, request Request) (Quota, error)
+}
+
+// NewCountersHandler creates a new CountersHandler
+func NewCountersHandler(provider QuotaProvider, logger logrus.FieldLogger) *CountersHandler {
+	return &CountersHandler{provider: provider, logger: logger}
+}
+
+// CountersHandler is an admin HTTP handler reporting the current count and remaining quota for a request.
+// The request is described by the remote_address, authority, method, and path query parameters.
+type CountersHandler struct {
+	provider QuotaProvider
+	logger   logrus.FieldLogger
+}
+
+type countersResponse struct {
+	RemoteAddress string    `json:"remote_address"`
+	LimitCount    uint64    `json:"limit_count"`
+	LimitDuration string    `json:"limit_duration"`
+	LimitEnabled  bool      `json:"limit_enabled"`
+	Count         uint64    `json:"count"`
+	Remaining     uint64    `json:"remaining"`
+	ResetAt       time.Time `json:"reset_at"`
+}
+
+func (h *CountersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method), h.logger)
+		return
+	}
+
+	q := r.URL.Query()
+	req := Request{
+		RemoteAddress: CanonicalRemoteAddress(q.Get(remoteAddressDescriptor)),
+		Authority:     q.Get(authorityDescriptor),
+		Method:        q.Get(methodDescriptor),
+		Path:          q.Get(pathDescriptor),
+		Headers:       make(map[string]string),
+	}
+
+	if len(req.RemoteAddress) == 0 {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("missing required query parameter %v", remoteAddressDescriptor), h.logger)
+		return
+	}
+
+	quota, err := h.provider.Quota(r.Context(), req)
+	if err != nil {
+		h.logger.WithError(err).Errorf("error fetching quota for request %v", req)
+		writeJSONError(w, http.StatusInternalServerError, err, h.logger)
+		return
+	}
+
+	res := countersResponse{
+		RemoteAddress: req.RemoteAddress,
+		LimitCount:    quota.Limit.Count,
+		LimitDuration: quota.Limit.Duration.String(),
+		LimitEnabled:  quota.Limit.Enabled,
+		Count:         quota.Count,
+		Remaining:     quota.Remaining,
+		ResetAt:       quota.ResetAt,
+	}
+
+	writeJSON(w, http.StatusOK, res, h.logger)
+}
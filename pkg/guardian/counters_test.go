@@ -0,0 +1,70 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type FakeQuotaProvider struct {
+	quota       Quota
+	injectedErr error
+	received    Request
+}
+
+func (f *FakeQuotaProvider) Quota(context context.Context, request Request) (Quota, error) {
+	f.received = request
+	return f.quota, f.injectedErr
+}
+
+func TestCountersHandler(t *testing.T) {
+	resetAt := time.Unix(1522969770, 0).UTC()
+	quota := Quota{Limit: Limit{Count: 300, Duration: time.Minute, Enabled: true}, Count: 60, Remaining: 240, ResetAt: resetAt}
+
+	tests := []struct {
+		name        string
+		target      string
+		injectedErr error
+		wantStatus  int
+	}{
+		{name: "OK", target: "/v1/counters?remote_address=10.0.0.1&path=/foo", wantStatus: http.StatusOK},
+		{name: "MissingRemoteAddress", target: "/v1/counters?path=/foo", wantStatus: http.StatusBadRequest},
+		{name: "ProviderError", target: "/v1/counters?remote_address=10.0.0.1", injectedErr: fmt.Errorf("some error"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &FakeQuotaProvider{quota: quota, injectedErr: test.injectedErr}
+			admin := NewAdminServer(TestingLogger)
+			admin.Handle("/v1/counters", NewCountersHandler(provider, TestingLogger))
+
+			rec := httptest.NewRecorder()
+			admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.target, nil))
+
+			if rec.Code != test.wantStatus {
+				t.Fatalf("expected: %v received: %v", test.wantStatus, rec.Code)
+			}
+
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+
+			res := countersResponse{}
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if provider.received.RemoteAddress != "10.0.0.1" || provider.received.Path != "/foo" {
+				t.Fatalf("unexpected request passed to provider: %v", provider.received)
+			}
+
+			if res.Remaining != 240 || res.Count != 60 || res.LimitDuration != "1m0s" || !res.ResetAt.Equal(resetAt) {
+				t.Fatalf("unexpected response: %#v", res)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const dayBucketsNamespace = "day_buckets"
+
+const day = 24 * time.Hour
+
+// DayBucketCounter is a Counter that is also capable of enforcing the day buckets algorithm
+type DayBucketCounter interface {
+	Counter
+
+	// IncrDays adds amount to the bucket of the UTC day containing now in the buckets for key, returning the sum of
+	// the buckets of the last days days, including today
+	IncrDays(context context.Context, key string, amount uint, days int, now time.Time) (uint64, error)
+}
+
+// ValidateDayBuckets returns an error if the limit uses the day buckets algorithm with a duration that isn't a whole
+// number of days
+func ValidateDayBuckets(limit Limit) error {
+	if limit.Algorithm != DayBucketsAlgorithm {
+		return nil
+	}
+
+	if limit.Duration < day || limit.Duration%day != 0 {
+		return fmt.Errorf("limit duration %v must be a whole number of days for the %v algorithm", limit.Duration, limit.Algorithm)
+	}
+
+	return nil
+}
+
+// dayBucketsScript atomically adds to today's bucket of a hash of unix day numbers to counts, removing buckets that
+// have left the window, and sums the buckets remaining
+// KEYS[1] buckets key
+// ARGV[1] today's unix day number, ARGV[2] days in the window, ARGV[3] amount to add, ARGV[4] expiration in seconds
+var dayBucketsScript = redis.NewScript(`
+local today = tonumber(ARGV[1])
+local days = tonumber(ARGV[2])
+local amount = tonumber(ARGV[3])
+
+if amount > 0 then
+	redis.call("HINCRBY", KEYS[1], ARGV[1], amount)
+	redis.call("EXPIRE", KEYS[1], ARGV[4])
+end
+
+local buckets = redis.call("HGETALL", KEYS[1])
+local sum = 0
+for i = 1, #buckets, 2 do
+	if tonumber(buckets[i]) > today - days then
+		sum = sum + tonumber(buckets[i + 1])
+	else
+		redis.call("HDEL", KEYS[1], buckets[i])
+	end
+end
+
+return sum
+`)
+
+func (rs *RedisCounter) IncrDays(context context.Context, key string, amount uint, days int, now time.Time) (uint64, error) {
+	today := now.Unix() / int64(day/time.Second)
+	expireSecs := int64(days+1) * int64(day/time.Second)
+
+	return rs.runIntScript("day buckets", dayBucketsScript, []string{key}, today, days, amount, expireSecs)
+}
+
+// incrDayBuckets counts incrBy against the day buckets of key for limit, returning the count and whether it exceeds
+// the limit
+func incrDayBuckets(context context.Context, counter Counter, key string, limit Limit, incrBy uint, now time.Time) (uint64, bool, error) {
+	buckets, ok := counter.(DayBucketCounter)
+	if !ok {
+		return 0, false, fmt.Errorf("counter does not support the %v algorithm", limit.Algorithm)
+	}
+
+	count, err := buckets.IncrDays(context, NamespacedKey(dayBucketsNamespace, key), incrBy, int(limit.Duration/day), now)
+	return count, count > limit.Count, err
+}
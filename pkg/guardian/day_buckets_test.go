@@ -0,0 +1,95 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRedisCounterIncrDaysSumsWindow(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	key := "buckets"
+	now := time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		at     time.Time
+		amount uint
+		want   uint64
+	}{
+		{name: "First", at: now, amount: 2, want: 2},
+		{name: "SameDay", at: now.Add(6 * time.Hour), amount: 1, want: 3},
+		{name: "NextDay", at: now.Add(day), amount: 1, want: 4},
+		{name: "LastDayOfWindow", at: now.Add(6 * day), amount: 0, want: 4},
+		{name: "FirstDayLeftWindow", at: now.Add(7 * day), amount: 0, want: 1},
+		{name: "AllLeftWindow", at: now.Add(8 * day), amount: 0, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count, err := c.IncrDays(context.Background(), key, test.amount, 7, test.at)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if count != test.want {
+				t.Errorf("expected: %v received: %v", test.want, count)
+			}
+		})
+	}
+}
+
+func TestLimitDayBuckets(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	limit := Limit{Count: 2, Duration: 7 * day, Enabled: true, Algorithm: DayBucketsAlgorithm}
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	rl := NewIPRateLimiter(&FakeLimitStore{limit: limit}, c, clock, nil, TestingLogger, NullReporter{})
+	req := Request{RemoteAddress: "192.168.1.2"}
+
+	for i, want := range []bool{false, false, true} {
+		blocked, _, err := rl.Limit(context.Background(), req)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if blocked != want {
+			t.Fatalf("request %d expected blocked: %v received: %v", i, want, blocked)
+		}
+	}
+
+	// still blocked days later, since the requests are within the last week
+	clock.now = clock.now.Add(3 * day)
+	quota, err := rl.Quota(context.Background(), req)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if quota.Count != 3 || quota.Remaining != 0 {
+		t.Errorf("expected count 3 with none remaining, received: %+v", quota)
+	}
+
+	clock.now = clock.now.Add(4 * day)
+	if blocked, _, _ := rl.Limit(context.Background(), req); blocked {
+		t.Error("expected request to be allowed once the window passed")
+	}
+}
+
+func TestValidateDayBuckets(t *testing.T) {
+	valid := []time.Duration{day, 7 * day, 30 * day}
+	for _, d := range valid {
+		if err := ValidateDayBuckets(Limit{Duration: d, Algorithm: DayBucketsAlgorithm}); err != nil {
+			t.Errorf("expected %v to be valid, received: %v", d, err)
+		}
+	}
+
+	invalid := []time.Duration{time.Hour, 36 * time.Hour}
+	for _, d := range invalid {
+		if err := ValidateDayBuckets(Limit{Duration: d, Algorithm: DayBucketsAlgorithm}); err == nil {
+			t.Errorf("expected %v to be invalid", d)
+		}
+	}
+}
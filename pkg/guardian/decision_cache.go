@@ -0,0 +1,159 @@
+package guardian
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewDecisionCache creates a new DecisionCache of up to size decisions, each cached for at most maxTTL
+func NewDecisionCache(size int, maxTTL time.Duration, clock Clock, reporter MetricReporter) *DecisionCache {
+	return &DecisionCache{maxTTL: maxTTL, clock: clock, reporter: reporter, cache: newLRUCache(size)}
+}
+
+// DecisionCache caches blocked decisions that can't change until some time has passed, such as those of requests
+// counted over a limit whose window hasn't ended, so identical requests are answered without being decided again
+type DecisionCache struct {
+	maxTTL   time.Duration
+	clock    Clock
+	reporter MetricReporter
+
+	mu    sync.Mutex
+	cache *lruCache // decisionCacheKey to *cachedDecision
+}
+
+type cachedDecision struct {
+	expireAt time.Time
+	reason   *BlockReason
+	response *StaticResponse
+	rule     *Rule
+}
+
+// CacheBlockedDecisions wraps f, caching the blocked decisions it makes that record how long they remain valid to
+// the request's DecisionHint. Identical requests are blocked from the cache until the shorter of that and the cache's
+// max TTL has passed, without being counted. A request is identified by all of its attributes except its id.
+func CacheBlockedDecisions(f RequestBlockerFunc, cache *DecisionCache) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		key := decisionCacheKey(r)
+		if cached, ok := cache.get(key); ok {
+			cache.reporter.DecisionCacheLookup(true)
+			cached.replay(c, cache.clock.Now())
+			tracef(c, "blocked by cached decision until %v", cached.expireAt)
+			return true, 0, nil
+		}
+		cache.reporter.DecisionCacheLookup(false)
+
+		hint := DecisionHintFromContext(c)
+		if hint == nil {
+			hint = NewDecisionHint()
+			c = WithDecisionHint(c, hint)
+		}
+
+		blocked, remaining, err := f(c, r)
+		if blocked && err == nil {
+			cache.add(key, hint)
+		}
+
+		return blocked, remaining, err
+	}
+}
+
+func (d *DecisionCache) get(key string) (*cachedDecision, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	value, ok := d.cache.get(key)
+	if !ok {
+		return nil, false
+	}
+
+	cached := value.(*cachedDecision)
+	return cached, d.clock.Now().Before(cached.expireAt)
+}
+
+// add caches the decision recorded to hint, if it recorded how long the decision remains valid
+func (d *DecisionCache) add(key string, hint *DecisionHint) {
+	ttl, ok := hint.Get()
+	if !ok {
+		return
+	}
+
+	if ttl > d.maxTTL {
+		ttl = d.maxTTL
+	}
+
+	cached := &cachedDecision{expireAt: d.clock.Now().Add(ttl), reason: hint.BlockReason(), response: hint.StaticResponse(), rule: hint.MatchedRule()}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.cache.add(key, cached)
+}
+
+// replay records the cached decision to the hint of ctx, as deciding the request again would have
+func (cd *cachedDecision) replay(ctx context.Context, now time.Time) {
+	hint := DecisionHintFromContext(ctx)
+	if hint == nil {
+		return
+	}
+
+	hint.BlockedFor(cd.expireAt.Sub(now))
+	if cd.reason != nil {
+		hint.Block(*cd.reason)
+	}
+
+	if cd.response != nil {
+		hint.Serve(*cd.response)
+	}
+
+	if cd.rule != nil {
+		hint.MatchRule(*cd.rule, true)
+	}
+}
+
+// decisionCacheKey identifies the requests decided identically to r: those with the same attributes and hits,
+// ignoring the RequestIDHeader and DebugHeader, which differ between otherwise identical requests
+func decisionCacheKey(r Request) string {
+	headers := make([]string, 0, len(r.Headers))
+	for header := range r.Headers {
+		if header != RequestIDHeader && header != DebugHeader {
+			headers = append(headers, header)
+		}
+	}
+	sort.Strings(headers)
+
+	metadataKeys := make([]string, 0, len(r.Metadata))
+	for key := range r.Metadata {
+		metadataKeys = append(metadataKeys, key)
+	}
+	sort.Strings(metadataKeys)
+
+	b := strings.Builder{}
+	write := func(s string) {
+		b.WriteString(strconv.Quote(s))
+		b.WriteByte(' ')
+	}
+
+	write(r.RemoteAddress)
+	write(r.Authority)
+	write(r.Method)
+	write(r.Path)
+	write(r.SourcePort)
+	write(r.SNI)
+	write(r.ALPN)
+	write(strconv.FormatUint(uint64(r.Hits()), 10))
+	for _, header := range headers {
+		write(headerDescriptorPrefix + header)
+		write(r.Headers[header])
+	}
+
+	for _, key := range metadataKeys {
+		write(metadataDescriptorPrefix + key)
+		write(r.Metadata[key])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,84 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheBlockedDecisions(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 30, 0, time.UTC)}
+	store := &FakeLimitStore{limit: Limit{Count: 1, Duration: time.Minute, Enabled: true}, count: make(map[string]uint64)}
+	rl := NewIPRateLimiter(store, store, clock, nil, TestingLogger, NullReporter{})
+	cache := NewDecisionCache(16, 10*time.Second, clock, NullReporter{})
+	decide := CacheBlockedDecisions(rl.Limit, cache)
+
+	request := func(id string) (bool, *DecisionHint) {
+		hint := NewDecisionHint()
+		r := Request{RemoteAddress: "10.0.0.1", Path: "/", Headers: map[string]string{RequestIDHeader: id}}
+		blocked, _, err := decide(WithDecisionHint(context.Background(), hint), r)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		return blocked, hint
+	}
+
+	if blocked, _ := request("a"); blocked {
+		t.Fatal("expected the first request to be allowed")
+	}
+
+	if blocked, _ := request("b"); !blocked {
+		t.Fatal("expected the second request to be blocked")
+	}
+
+	// requests differing only by id are blocked from the cache without being counted
+	blocked, hint := request("c")
+	if !blocked || len(store.count) != 1 {
+		t.Fatalf("expected a cached block, received: %v with counts %v", blocked, store.count)
+	}
+
+	if blockedFor, ok := hint.Get(); !ok || blockedFor != 10*time.Second {
+		t.Errorf("expected the cached decision to be valid for the max ttl, received: (%v, %v)", blockedFor, ok)
+	}
+
+	if reason := hint.BlockReason(); reason == nil || reason.Kind != RateLimitBlockReason {
+		t.Errorf("expected the cached block reason, received: %v", reason)
+	}
+
+	for _, count := range store.count {
+		if count != 2 {
+			t.Fatalf("expected the cached request not to be counted, received count: %d", count)
+		}
+	}
+
+	// once the cached decision expires the request is decided again
+	clock.now = clock.now.Add(11 * time.Second)
+	if blocked, _ := request("d"); !blocked {
+		t.Fatal("expected the request to be blocked")
+	}
+
+	for _, count := range store.count {
+		if count != 3 {
+			t.Fatalf("expected the request to be counted once the cached decision expired, received count: %d", count)
+		}
+	}
+}
+
+func TestCacheBlockedDecisionsSkipsDecisionsWithoutExpiry(t *testing.T) {
+	blocks := 0
+	f := func(c context.Context, r Request) (bool, uint32, error) {
+		blocks++
+		return true, 0, nil // like the blacklist, blocks without knowing for how long
+	}
+
+	decide := CacheBlockedDecisions(f, NewDecisionCache(16, time.Minute, &fixedClock{now: time.Now()}, NullReporter{}))
+	for i := 0; i < 2; i++ {
+		if blocked, _, _ := decide(context.Background(), Request{RemoteAddress: "10.0.0.1"}); !blocked {
+			t.Fatal("expected the request to be blocked")
+		}
+	}
+
+	if blocks != 2 {
+		t.Fatalf("expected both requests to be decided, received: %d", blocks)
+	}
+}
@@ -0,0 +1,270 @@
+package guardian
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BlockedForHeader is the gRPC response header giving the milliseconds a blocked decision remains valid for. Callers
+// can block the same request locally until then instead of asking Guardian again, cutting requests during attacks.
+const BlockedForHeader = "x-guardian-blocked-for-ms"
+
+// StaticResponseStatusHeader, StaticResponseBodyHeader, and StaticResponseLocationHeader are the gRPC response
+// headers giving the status, body, and redirect location a blocked request should be answered with instead of a 429.
+// The body header is binary, base64 encoded on the wire. Envoy's v2 rate limit filter ignores them.
+const (
+	StaticResponseStatusHeader   = "x-guardian-response-status"
+	StaticResponseBodyHeader     = "x-guardian-response-body-bin"
+	StaticResponseLocationHeader = "x-guardian-response-location"
+)
+
+// BlockReasonHeader is the gRPC response header giving the machine readable reason a request was blocked, a
+// BlockReason such as rule:scrapers or blacklist:10.0.0.0/8
+const BlockReasonHeader = "x-guardian-block-reason"
+
+// BlockReasonKind is the kind of check that blocked a request
+type BlockReasonKind string
+
+const (
+	// BlacklistBlockReason is named by the blacklisted cidr
+	BlacklistBlockReason BlockReasonKind = "blacklist"
+	// RuleBlockReason is named by the rule that blocked the request or served it a static response
+	RuleBlockReason BlockReasonKind = "rule"
+	// ChallengeBlockReason is named by the rule that redirected the request to its challenge
+	ChallengeBlockReason BlockReasonKind = "challenge"
+	// RuleLimitBlockReason is named by the rule whose limit was exhausted
+	RuleLimitBlockReason BlockReasonKind = "rule_limit"
+	// RouteLimitBlockReason is named by the route whose limit was exhausted
+	RouteLimitBlockReason BlockReasonKind = "route_limit"
+	// RateLimitBlockReason is the global limit being exhausted
+	RateLimitBlockReason BlockReasonKind = "rate_limit"
+	// ReputationBlockReason and FeedbackBlockReason are named throttle when the request exhausted the reduced limit
+	// it was throttled to, rather than being blocked outright
+	ReputationBlockReason BlockReasonKind = "reputation"
+	FeedbackBlockReason   BlockReasonKind = "feedback"
+)
+
+// throttledBlockReason names the reasons of requests blocked by the reduced limit they were throttled to
+const throttledBlockReason = "throttle"
+
+// BlockReason is why a request was blocked: the kind of check that blocked it, and the name of the rule, route, or
+// cidr responsible, if any
+type BlockReason struct {
+	Kind BlockReasonKind
+	Name string
+}
+
+// String returns the reason as <kind>:<name>, or <kind> if it has no name
+func (r BlockReason) String() string {
+	if len(r.Name) == 0 {
+		return string(r.Kind)
+	}
+
+	return string(r.Kind) + ":" + r.Name
+}
+
+type decisionHintKey struct{}
+
+// DecisionHint records how long the decision of a single request is known to remain valid, the static response it
+// should be answered with if any, the rule that decided it, the exemption that skipped deciding it, and whether an
+// error kept it from being decided as usual
+type DecisionHint struct {
+	mu             sync.Mutex
+	blockedFor     time.Duration
+	set            bool
+	response       *StaticResponse
+	rule           *Rule
+	decisive       bool
+	reason         *BlockReason
+	exemption      string
+	errorSwallowed bool
+}
+
+// NewDecisionHint creates a new DecisionHint
+func NewDecisionHint() *DecisionHint {
+	return &DecisionHint{}
+}
+
+// BlockedFor records that the request will stay blocked for at least d, keeping the shortest duration recorded.
+// Recording to a nil hint does nothing, so callers needn't check whether hints are wanted.
+func (h *DecisionHint) BlockedFor(d time.Duration) {
+	if h == nil || d <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.set || d < h.blockedFor {
+		h.blockedFor = d
+		h.set = true
+	}
+}
+
+// Get returns how long the request will stay blocked, or false if no blocker knew
+func (h *DecisionHint) Get() (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.blockedFor, h.set
+}
+
+// Serve records that the request should be answered with response. Recording to a nil hint does nothing.
+func (h *DecisionHint) Serve(response StaticResponse) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.response = &response
+}
+
+// StaticResponse returns the response the request should be answered with, or nil if none was recorded
+func (h *DecisionHint) StaticResponse() *StaticResponse {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.response
+}
+
+// MatchRule records that the request matched rule, decisive if the rule blocked or allowed it. The first decisive rule
+// is kept, or the first rule matched if none were decisive. Recording to a nil hint does nothing.
+func (h *DecisionHint) MatchRule(rule Rule, decisive bool) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.rule == nil || (decisive && !h.decisive) {
+		h.rule = &rule
+		h.decisive = decisive
+	}
+}
+
+// MatchedRule returns the rule recorded by MatchRule, or nil if the request matched none
+func (h *DecisionHint) MatchedRule() *Rule {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.rule
+}
+
+// Block records why the request was blocked, replacing any reason recorded before so that checks throttling requests
+// through another limiter can record their own reason once it blocks. Recording to a nil hint does nothing.
+func (h *DecisionHint) Block(reason BlockReason) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reason = &reason
+}
+
+// BlockReason returns the reason recorded by Block, or nil if none was recorded
+func (h *DecisionHint) BlockReason() *BlockReason {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.reason
+}
+
+// Exempt records that the request was exempted from rate limiting by the exemption named name. Recording to a nil
+// hint does nothing.
+func (h *DecisionHint) Exempt(name string) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.exemption = name
+}
+
+// Exemption returns the name of the exemption recorded by Exempt, or empty if the request wasn't exempted
+func (h *DecisionHint) Exemption() string {
+	if h == nil {
+		return ""
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.exemption
+}
+
+// SwallowError records that an error kept the request from being decided as usual without failing the decision: a
+// limiter that couldn't count it let it through, or a full bulkhead blocked it. Recording to a nil hint does nothing.
+func (h *DecisionHint) SwallowError() {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.errorSwallowed = true
+}
+
+// ErrorSwallowed returns whether SwallowError was recorded
+func (h *DecisionHint) ErrorSwallowed() bool {
+	if h == nil {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.errorSwallowed
+}
+
+// WithDecisionHint returns a copy of ctx that how long a request's decision remains valid is recorded to hint
+func WithDecisionHint(ctx context.Context, hint *DecisionHint) context.Context {
+	return context.WithValue(ctx, decisionHintKey{}, hint)
+}
+
+// DecisionHintFromContext returns the hint of ctx, or nil if the request doesn't want one
+func DecisionHintFromContext(ctx context.Context) *DecisionHint {
+	hint, _ := ctx.Value(decisionHintKey{}).(*DecisionHint)
+	return hint
+}
+
+// hintBlockedFor records to the hint of ctx, if any, that the request will stay blocked for at least d
+func hintBlockedFor(ctx context.Context, d time.Duration) {
+	DecisionHintFromContext(ctx).BlockedFor(d)
+}
+
+// hintBlockReason records to the hint of ctx, if any, why the request was blocked
+func hintBlockReason(ctx context.Context, kind BlockReasonKind, name string) {
+	DecisionHintFromContext(ctx).Block(BlockReason{Kind: kind, Name: name})
+}
+
+// hintErrorSwallowed records to the hint of ctx, if any, that an error kept the request from being decided as usual
+func hintErrorSwallowed(ctx context.Context) {
+	DecisionHintFromContext(ctx).SwallowError()
+}
+
+// blockedFor returns how long a key whose count exceeded limit as of now is sure to stay blocked: until the end of
+// the window, until its leaky bucket drains enough for another request, or until the next UTC day starts
+func blockedFor(limit Limit, count uint64, now time.Time) time.Duration {
+	switch limit.Algorithm {
+	case LeakyBucketAlgorithm:
+		if count <= limit.Count {
+			return 0
+		}
+		return time.Duration(float64(limit.Duration) / float64(limit.Count) * float64(count-limit.Count))
+	case DayBucketsAlgorithm:
+		return now.UTC().Truncate(day).Add(day).Sub(now)
+	}
+
+	_, end := limit.Window(now)
+	return end.Sub(now)
+}
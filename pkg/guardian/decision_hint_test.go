@@ -0,0 +1,107 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBlockedFor(t *testing.T) {
+	now := time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		limit Limit
+		count uint64
+		want  time.Duration
+	}{
+		{name: "FixedWindow", limit: Limit{Count: 10, Duration: time.Hour, Enabled: true}, count: 11, want: time.Hour},
+		{name: "LeakyBucket", limit: Limit{Count: 10, Duration: time.Second, Enabled: true, Algorithm: LeakyBucketAlgorithm}, count: 13, want: 300 * time.Millisecond},
+		{name: "LeakyBucketNotFull", limit: Limit{Count: 10, Duration: time.Second, Enabled: true, Algorithm: LeakyBucketAlgorithm}, count: 10, want: 0},
+		{name: "DayBuckets", limit: Limit{Count: 10, Duration: 7 * day, Enabled: true, Algorithm: DayBucketsAlgorithm}, count: 11, want: 12 * time.Hour},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := blockedFor(test.limit, test.count, now); got != test.want {
+				t.Errorf("expected: %v received: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestDecisionHintKeepsShortest(t *testing.T) {
+	hint := NewDecisionHint()
+	if _, ok := hint.Get(); ok {
+		t.Fatal("expected empty hint")
+	}
+
+	ctx := WithDecisionHint(context.Background(), hint)
+	hintBlockedFor(ctx, time.Minute)
+	hintBlockedFor(ctx, time.Second)
+	hintBlockedFor(ctx, time.Hour)
+	hintBlockedFor(ctx, 0)
+
+	if got, ok := hint.Get(); !ok || got != time.Second {
+		t.Fatalf("expected: %v received: (%v, %v)", time.Second, got, ok)
+	}
+
+	// requests without a hint are left alone
+	hintBlockedFor(context.Background(), time.Second)
+}
+
+func TestIPRateLimiterHintsBlockedFor(t *testing.T) {
+	limit := Limit{Count: 1, Duration: time.Minute, Enabled: true}
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 15, 0, time.UTC)}
+	rl := NewIPRateLimiter(&FakeLimitStore{limit: limit, count: make(map[string]uint64)}, &FakeLimitStore{count: make(map[string]uint64)}, clock, nil, TestingLogger, NullReporter{})
+
+	for i := 0; i < 2; i++ {
+		hint := NewDecisionHint()
+		blocked, _, err := rl.Limit(WithDecisionHint(context.Background(), hint), Request{RemoteAddress: "192.168.1.2"})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		got, ok := hint.Get()
+		if blocked != (i > 0) || ok != blocked {
+			t.Fatalf("request %d unexpected decision: %v hint: (%v, %v)", i, blocked, got, ok)
+		}
+
+		if blocked && got != 45*time.Second {
+			t.Errorf("expected: %v received: %v", 45*time.Second, got)
+		}
+	}
+}
+
+func TestBlockReasonString(t *testing.T) {
+	tests := []struct {
+		reason BlockReason
+		want   string
+	}{
+		{reason: BlockReason{Kind: RuleBlockReason, Name: "scrapers"}, want: "rule:scrapers"},
+		{reason: BlockReason{Kind: RateLimitBlockReason}, want: "rate_limit"},
+		{reason: BlockReason{Kind: FeedbackBlockReason, Name: throttledBlockReason}, want: "feedback:throttle"},
+	}
+
+	for _, test := range tests {
+		if got := test.reason.String(); got != test.want {
+			t.Errorf("expected: %v received: %v", test.want, got)
+		}
+	}
+}
+
+func TestIPBlacklisterHintsBlockReason(t *testing.T) {
+	store := &FakeBlacklistStore{blacklist: parseCIDRs([]string{"10.0.0.0/24"})}
+	blacklister := NewIPBlacklister(store, TestingLogger, NullReporter{})
+
+	hint := NewDecisionHint()
+	blacklisted, err := blacklister.IsBlacklisted(WithDecisionHint(context.Background(), hint), Request{RemoteAddress: "10.0.0.28"})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	want := BlockReason{Kind: BlacklistBlockReason, Name: "10.0.0.0/24"}
+	if reason := hint.BlockReason(); !blacklisted || reason == nil || *reason != want {
+		t.Fatalf("expected blacklisted with reason %v received: %v %v", want, blacklisted, reason)
+	}
+}
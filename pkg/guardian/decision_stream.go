@@ -0,0 +1,152 @@
+package guardian
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dollarshaveclub/guardian/pkg/rate_limit_grpc"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxDecisionSubscribers bounds the subscribers of a DecisionPublisher, since each one is sent every published decision
+const maxDecisionSubscribers = 16
+
+// NewDecisionPublisher creates a new DecisionPublisher publishing sampleRate of allowed decisions and every blocked or
+// errored decision. Each subscriber is buffered up to buffer decisions.
+func NewDecisionPublisher(sampleRate float64, buffer int, clock Clock, logger logrus.FieldLogger, reporter MetricReporter) *DecisionPublisher {
+	return &DecisionPublisher{
+		sampleRate:  sampleRate,
+		buffer:      buffer,
+		clock:       clock,
+		logger:      logger,
+		reporter:    reporter,
+		subscribers: make(map[*decisionSubscriber]struct{}),
+	}
+}
+
+// DecisionPublisher publishes decisions to subscribers of the decision stream. Publishing never blocks a decision:
+// a subscriber that falls behind has decisions dropped once its buffer is full, and is told how many were dropped
+// with the next decision it is sent.
+type DecisionPublisher struct {
+	sampleRate float64
+	buffer     int
+	clock      Clock
+	logger     logrus.FieldLogger
+	reporter   MetricReporter
+
+	// subscribed lets Publish skip building decisions without taking the lock when there are no subscribers
+	subscribed  int32
+	mu          sync.RWMutex
+	subscribers map[*decisionSubscriber]struct{}
+}
+
+type decisionSubscriber struct {
+	blockedOnly bool
+	events      chan rate_limit_grpc.DecisionEvent
+	dropped     uint64
+}
+
+// PublishDecisions wraps f, publishing each decision it makes to p
+func PublishDecisions(f RequestBlockerFunc, p *DecisionPublisher) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		blocked, remaining, err := f(c, r)
+		p.Publish(r, blocked, remaining, err)
+		return blocked, remaining, err
+	}
+}
+
+// Publish publishes the decision made for r to each subscriber with room for it in its buffer
+func (p *DecisionPublisher) Publish(r Request, blocked bool, remaining uint32, err error) {
+	if atomic.LoadInt32(&p.subscribed) == 0 {
+		return
+	}
+
+	if !blocked && err == nil && p.sampleRate < 1 && rand.Float64() >= p.sampleRate {
+		return
+	}
+
+	event := rate_limit_grpc.DecisionEvent{
+		TimestampUnixNano: p.clock.Now().UnixNano(),
+		RemoteAddress:     r.RemoteAddress,
+		Authority:         r.Authority,
+		Method:            r.Method,
+		Path:              r.Path,
+		Blocked:           blocked,
+		Remaining:         remaining,
+		RequestId:         r.ID,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	dropped := 0
+	p.mu.RLock()
+	for s := range p.subscribers {
+		if s.blockedOnly && !blocked {
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+			dropped++
+		}
+	}
+	p.mu.RUnlock()
+
+	if dropped > 0 {
+		p.reporter.DecisionStreamDropped(dropped)
+	}
+}
+
+// Subscribe streams published decisions to stream until the subscriber disconnects. Subscribers beyond
+// maxDecisionSubscribers are rejected with ResourceExhausted.
+func (p *DecisionPublisher) Subscribe(req *rate_limit_grpc.SubscribeRequest, stream rate_limit_grpc.DecisionStream_SubscribeServer) error {
+	s, err := p.subscribe(req.BlockedOnly)
+	if err != nil {
+		return err
+	}
+	defer p.unsubscribe(s)
+
+	for {
+		select {
+		case event := <-s.events:
+			event.Dropped = atomic.SwapUint64(&s.dropped, 0)
+			if err := stream.Send(&event); err != nil {
+				p.logger.WithError(err).Debug("error sending decision to subscriber")
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (p *DecisionPublisher) subscribe(blockedOnly bool) (*decisionSubscriber, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.subscribers) >= maxDecisionSubscribers {
+		return nil, status.Errorf(codes.ResourceExhausted, "decision stream has the max of %d subscribers", maxDecisionSubscribers)
+	}
+
+	s := &decisionSubscriber{blockedOnly: blockedOnly, events: make(chan rate_limit_grpc.DecisionEvent, p.buffer)}
+	p.subscribers[s] = struct{}{}
+	atomic.StoreInt32(&p.subscribed, int32(len(p.subscribers)))
+	p.logger.Infof("decision stream subscribed, blocked only: %v, subscribers: %d", blockedOnly, len(p.subscribers))
+	return s, nil
+}
+
+func (p *DecisionPublisher) unsubscribe(s *decisionSubscriber) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.subscribers, s)
+	atomic.StoreInt32(&p.subscribed, int32(len(p.subscribers)))
+	p.logger.Infof("decision stream unsubscribed, subscribers: %d", len(p.subscribers))
+}
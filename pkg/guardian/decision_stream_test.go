@@ -0,0 +1,140 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dollarshaveclub/guardian/pkg/rate_limit_grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDecisionPublisherSubscribe(t *testing.T) {
+	now := time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)
+	p := NewDecisionPublisher(1, 16, &fixedClock{now}, TestingLogger, NullReporter{})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	srv := grpc.NewServer()
+	rate_limit_grpc.RegisterDecisionStreamServer(srv, p)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	subscribe := func(blockedOnly bool) grpc.ClientStream {
+		desc := &grpc.StreamDesc{StreamName: "Subscribe", ServerStreams: true}
+		stream, err := conn.NewStream(ctx, desc, rate_limit_grpc.DecisionStreamSubscribeFullMethod)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if err := stream.SendMsg(&rate_limit_grpc.SubscribeRequest{BlockedOnly: blockedOnly}); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if err := stream.CloseSend(); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		return stream
+	}
+
+	all := subscribe(false)
+	blocked := subscribe(true)
+
+	// subscribing completes asynchronously, so wait for both subscribers before publishing
+	for i := 0; i < 100 && atomic.LoadInt32(&p.subscribed) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	p.Publish(Request{RemoteAddress: "10.0.0.1", Path: "/allowed"}, false, 9, nil)
+	p.Publish(Request{RemoteAddress: "10.0.0.2", Path: "/blocked", ID: "abc-123"}, true, 0, nil)
+
+	recv := func(stream grpc.ClientStream) *rate_limit_grpc.DecisionEvent {
+		event := &rate_limit_grpc.DecisionEvent{}
+		if err := stream.RecvMsg(event); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		return event
+	}
+
+	if got := recv(all); got.Path != "/allowed" || got.Blocked || got.Remaining != 9 || got.TimestampUnixNano != now.UnixNano() {
+		t.Errorf("expected the allowed decision, received: %v", got)
+	}
+
+	if got := recv(all); got.Path != "/blocked" || !got.Blocked || got.RequestId != "abc-123" {
+		t.Errorf("expected the blocked decision, received: %v", got)
+	}
+
+	if got := recv(blocked); got.Path != "/blocked" || !got.Blocked {
+		t.Errorf("expected only the blocked decision, received: %v", got)
+	}
+}
+
+func TestDecisionPublisherDropsForSlowSubscribers(t *testing.T) {
+	p := NewDecisionPublisher(1, 1, &fixedClock{time.Now()}, TestingLogger, NullReporter{})
+	s, err := p.subscribe(false)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		p.Publish(Request{Path: fmt.Sprintf("/%d", i)}, true, 0, nil)
+	}
+
+	if got := len(s.events); got != 1 {
+		t.Errorf("expected the buffer to hold 1 decision, received: %v", got)
+	}
+
+	if got := s.dropped; got != 2 {
+		t.Errorf("expected 2 dropped decisions, received: %v", got)
+	}
+}
+
+func TestDecisionPublisherSampling(t *testing.T) {
+	p := NewDecisionPublisher(0, 16, &fixedClock{time.Now()}, TestingLogger, NullReporter{})
+	s, err := p.subscribe(false)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	p.Publish(Request{Path: "/allowed"}, false, 9, nil)
+	p.Publish(Request{Path: "/errored"}, false, 0, fmt.Errorf("redis unavailable"))
+	p.Publish(Request{Path: "/blocked"}, true, 0, nil)
+
+	if got := len(s.events); got != 2 {
+		t.Fatalf("expected only the errored and blocked decisions, received %v decisions", got)
+	}
+
+	if got := <-s.events; got.Path != "/errored" || got.Error != "redis unavailable" {
+		t.Errorf("expected the errored decision, received: %v", got)
+	}
+}
+
+func TestDecisionPublisherMaxSubscribers(t *testing.T) {
+	p := NewDecisionPublisher(1, 1, &fixedClock{time.Now()}, TestingLogger, NullReporter{})
+	for i := 0; i < maxDecisionSubscribers; i++ {
+		if _, err := p.subscribe(false); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+	}
+
+	if _, err := p.subscribe(false); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected resource exhausted beyond the max subscribers, received: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const distinctNamespace = "distinct"
+
+// DistinctCounter is a Counter that is also capable of approximately counting the distinct values added to a key
+type DistinctCounter interface {
+	Counter
+
+	// AddDistinct adds value to the set of key, returning the approximate number of distinct values it has. The set
+	// of key is kept for expireIn.
+	AddDistinct(context context.Context, key string, value string, expireIn time.Duration) (uint64, error)
+}
+
+// distinctScript atomically adds a value to a HyperLogLog and counts its distinct values
+// KEYS[1] HyperLogLog key
+// ARGV[1] value to add, ARGV[2] expiration in milliseconds
+var distinctScript = redis.NewScript(`
+redis.call("PFADD", KEYS[1], ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return redis.call("PFCOUNT", KEYS[1])
+`)
+
+// AddDistinct adds value to a HyperLogLog, so the memory of each key is bounded however many distinct values it has.
+// The count is approximate, with a standard error of 0.81%.
+func (rs *RedisCounter) AddDistinct(context context.Context, key string, value string, expireIn time.Duration) (uint64, error) {
+	expireMs := int64(rs.expiration(expireIn) / time.Millisecond)
+	return rs.runIntScript("distinct", distinctScript, []string{key}, value, expireMs)
+}
+
+// distinctValue returns the value of attribute counted for request. Paths are counted without their query, so a
+// client can't pass as a scanner by varying the query of one endpoint.
+func distinctValue(request Request, attribute string) string {
+	value := request.Attribute(attribute)
+	if attribute != pathDescriptor {
+		return value
+	}
+
+	if i := strings.Index(value, "?"); i >= 0 {
+		return value[:i]
+	}
+
+	return value
+}
+
+// incrDistinct adds the distinct attribute of request to the window of key containing now, returning the approximate
+// number of distinct values the key has counted in the window
+func incrDistinct(context context.Context, counter Counter, key string, limit Limit, attribute string, request Request, now time.Time) (uint64, error) {
+	distinct, ok := counter.(DistinctCounter)
+	if !ok {
+		return 0, fmt.Errorf("counter does not support distinct counts")
+	}
+
+	start, end := limit.Window(now)
+	key = fmt.Sprintf("%v:%d", NamespacedKey(distinctNamespace, key), start.Unix())
+	count, err := distinct.AddDistinct(context, key, distinctValue(request, attribute), end.Sub(now))
+	if err != nil {
+		return 0, err
+	}
+
+	tracef(context, "%v has counted %d distinct %v values", key, count, attribute)
+	return count, nil
+}
+
+// validateDistinct returns an error if rule counts distinct values of an unknown attribute, with anything but plain
+// fixed windows, or with schedules, a baseline, a cooldown or a KeyTemplate placing the window, which count requests
+func validateDistinct(rule Rule) error {
+	if err := ValidateRequestAttribute(rule.Distinct); err != nil {
+		return err
+	}
+
+	if len(rule.Schedules) > 0 || rule.Baseline != nil || rule.Cooldown != nil {
+		return fmt.Errorf("distinct counts can't be used with schedules, a baseline or a cooldown")
+	}
+
+	if rule.KeyTemplate != nil && rule.KeyTemplate.Windowed() {
+		return fmt.Errorf("distinct counts can't be used with the {%v} placeholder", windowPlaceholder)
+	}
+
+	return validatePlainFixedWindows(rule.Limit, "distinct counts")
+}
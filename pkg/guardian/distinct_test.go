@@ -0,0 +1,88 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// FakeDistinctCounter counts distinct values exactly in memory, as miniredis doesn't support HyperLogLogs
+type FakeDistinctCounter struct {
+	*RedisCounter
+	sets map[string]map[string]struct{}
+}
+
+func (f *FakeDistinctCounter) AddDistinct(context context.Context, key string, value string, expireIn time.Duration) (uint64, error) {
+	set, ok := f.sets[key]
+	if !ok {
+		set = make(map[string]struct{})
+		f.sets[key] = set
+	}
+	set[value] = struct{}{}
+
+	return uint64(len(set)), nil
+}
+
+func TestRuleEvaluatorDistinct(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	doc := RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Count: 2, Duration: "1m", Enabled: true}, Distinct: "path"}
+	rules := []Rule{mustParseRule(t, "scanner", doc)}
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	counter := &FakeDistinctCounter{RedisCounter: c, sets: make(map[string]map[string]struct{})}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, counter, clock, nil, nil, TestingLogger, NullReporter{})
+
+	evaluate := func(remoteAddress string, path string) bool {
+		_, blocked, _, err := re.Evaluate(context.Background(), Request{RemoteAddress: remoteAddress, Path: path})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		return blocked
+	}
+
+	for i := 0; i < 5; i++ {
+		if evaluate("192.168.1.2", "/api/users?page=1") || evaluate("192.168.1.2", "/api/orders?page=2") {
+			t.Fatal("expected repeated requests of 2 distinct paths to be allowed")
+		}
+	}
+
+	if !evaluate("192.168.1.2", "/.env") {
+		t.Fatal("expected request of a third distinct path to be blocked")
+	}
+
+	if evaluate("192.168.1.3", "/.env") {
+		t.Fatal("expected request of another address to be allowed")
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if evaluate("192.168.1.2", "/.git/config") {
+		t.Fatal("expected request in the next window to be allowed")
+	}
+
+	if got := RuleDocumentFromRule(rules[0]); got.Distinct != doc.Distinct {
+		t.Fatalf("expected rule document with distinct %v received: %v", doc.Distinct, got.Distinct)
+	}
+}
+
+func TestRuleDocumentRejectsInvalidDistinct(t *testing.T) {
+	limit := &LimitDocument{Count: 10, Duration: "1m", Enabled: true}
+	tests := []struct {
+		name string
+		doc  RuleDocument
+	}{
+		{name: "UnknownAttribute", doc: RuleDocument{When: `true`, Action: "limit", Limit: limit, Distinct: "paths"}},
+		{name: "LeakyBucket", doc: RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Count: 10, Duration: "1m", Enabled: true, Algorithm: string(LeakyBucketAlgorithm)}, Distinct: "path"}},
+		{name: "Baseline", doc: RuleDocument{When: `true`, Action: "limit", Limit: limit, Baseline: &BaselineDocument{Multiplier: 2, Period: "1h"}, Distinct: "path"}},
+		{name: "Cooldown", doc: RuleDocument{When: `true`, Action: "limit", Limit: limit, Cooldown: &Cooldown{Percent: 50, Windows: 1}, Distinct: "path"}},
+		{name: "WindowedKeyTemplate", doc: RuleDocument{When: `true`, Action: "limit", Limit: limit, KeyTemplate: "{ip}:{window}", Distinct: "path"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.doc.Rule("scanner"); err == nil {
+				t.Fatal("expected error but received nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package guardian
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+)
+
+// UnknownDomainAction is the action taken on rate limit requests for domains a DomainFilter doesn't serve
+type UnknownDomainAction string
+
+const (
+	// IgnoreUnknownDomainAction allows the request without evaluating or counting it
+	IgnoreUnknownDomainAction UnknownDomainAction = "ignore"
+	// RejectUnknownDomainAction fails the request with an InvalidArgument error, leaving Envoy to apply its failure
+	// mode
+	RejectUnknownDomainAction UnknownDomainAction = "reject"
+)
+
+// NewDomainFilter creates a new DomainFilter passing requests for domains to srv. Every domain is served if domains is
+// empty.
+func NewDomainFilter(srv ratelimit.RateLimitServiceServer, domains []string, action UnknownDomainAction, logger logrus.FieldLogger, reporter MetricReporter) *DomainFilter {
+	served := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		served[domain] = true
+	}
+
+	return &DomainFilter{srv: srv, domains: served, action: action, logger: logger, reporter: reporter}
+}
+
+// DomainFilter only serves rate limit requests for the Envoy rate limit domains it's configured with, so a shared
+// Envoy fleet can point several rate limit services at partially overlapping configs without Guardian counting
+// requests meant for another service
+type DomainFilter struct {
+	srv      ratelimit.RateLimitServiceServer
+	domains  map[string]bool
+	action   UnknownDomainAction
+	logger   logrus.FieldLogger
+	reporter MetricReporter
+}
+
+// Serves returns whether requests for domain are passed on
+func (f *DomainFilter) Serves(domain string) bool {
+	return len(f.domains) == 0 || f.domains[domain]
+}
+
+func (f *DomainFilter) ShouldRateLimit(ctx context.Context, relreq *ratelimit.RateLimitRequest) (*ratelimit.RateLimitResponse, error) {
+	domain := relreq.GetDomain()
+	if f.Serves(domain) {
+		return f.srv.ShouldRateLimit(ctx, relreq)
+	}
+
+	f.logger.Debugf("%v request for unknown domain %q", f.action, domain)
+	f.reporter.UnknownDomain(domain, f.action)
+
+	if f.action == RejectUnknownDomainAction {
+		return nil, status.Errorf(codes.InvalidArgument, "domain %q is not served", domain)
+	}
+
+	resp := &ratelimit.RateLimitResponse{OverallCode: ratelimit.RateLimitResponse_OK}
+	for i := 0; i < len(relreq.GetDescriptors()); i++ {
+		resp.Statuses = append(resp.Statuses, &ratelimit.RateLimitResponse_DescriptorStatus{Code: ratelimit.RateLimitResponse_OK})
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,70 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+)
+
+type FakeUnknownDomainReporter struct {
+	NullReporter
+	domains []string
+}
+
+func (f *FakeUnknownDomainReporter) UnknownDomain(domain string, action UnknownDomainAction) {
+	f.domains = append(f.domains, domain)
+}
+
+func TestDomainFilter(t *testing.T) {
+	blocked := 0
+	server := NewServer(func(c context.Context, r Request) (bool, uint32, error) {
+		blocked++
+		return true, 0, nil
+	}, StaticReportOnlyProvider{false}, "", 0, TestingLogger, NullReporter{})
+
+	reporter := &FakeUnknownDomainReporter{}
+	filter := NewDomainFilter(server, []string{"edge"}, IgnoreUnknownDomainAction, TestingLogger, reporter)
+
+	req := newRateLimitRequest()
+	req.Domain = "edge"
+	res, err := filter.ShouldRateLimit(context.Background(), req)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if res.OverallCode != ratelimit.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("expected a served domain to be decided, received: %v", res.OverallCode)
+	}
+
+	req.Domain = "internal"
+	res, err = filter.ShouldRateLimit(context.Background(), req)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if res.OverallCode != ratelimit.RateLimitResponse_OK || len(res.Statuses) != len(req.Descriptors) {
+		t.Errorf("expected an unknown domain to be allowed, received: %v", res)
+	}
+
+	if blocked != 1 {
+		t.Errorf("expected only the served domain to be decided, received: %v", blocked)
+	}
+
+	if len(reporter.domains) != 1 || reporter.domains[0] != "internal" {
+		t.Errorf("expected the unknown domain to be reported, received: %v", reporter.domains)
+	}
+
+	filter = NewDomainFilter(server, []string{"edge"}, RejectUnknownDomainAction, TestingLogger, NullReporter{})
+	if _, err := filter.ShouldRateLimit(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an unknown domain to be rejected, received: %v", err)
+	}
+
+	filter = NewDomainFilter(server, nil, RejectUnknownDomainAction, TestingLogger, NullReporter{})
+	if !filter.Serves("internal") {
+		t.Error("expected every domain to be served when none are configured")
+	}
+}
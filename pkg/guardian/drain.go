@@ -0,0 +1,65 @@
+package guardian
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+
+	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+)
+
+// DrainDecision is the decision rate limit requests are answered with by a draining Drainer
+type DrainDecision string
+
+const (
+	// AllowDrainDecision allows requests without evaluating or counting them
+	AllowDrainDecision DrainDecision = "allow"
+	// UnknownDrainDecision answers requests with the UNKNOWN code without evaluating or counting them, leaving Envoy
+	// to treat them as it treats an unknown decision
+	UnknownDrainDecision DrainDecision = "unknown"
+)
+
+// NewDrainer creates a new Drainer passing requests to srv until it drains
+func NewDrainer(srv ratelimit.RateLimitServiceServer, decision DrainDecision, logger logrus.FieldLogger) *Drainer {
+	return &Drainer{srv: srv, decision: decision, logger: logger}
+}
+
+// Drainer answers rate limit requests with its DrainDecision once it starts draining on shutdown, rather than
+// evaluating them, so calls Envoy sends while it rebalances to other replicas are answered at once
+type Drainer struct {
+	srv      ratelimit.RateLimitServiceServer
+	decision DrainDecision
+	logger   logrus.FieldLogger
+	draining int32
+}
+
+// Drain starts answering requests with the DrainDecision
+func (d *Drainer) Drain() {
+	if atomic.CompareAndSwapInt32(&d.draining, 0, 1) {
+		d.logger.Infof("draining, answering rate limit requests with decision %v", d.decision)
+	}
+}
+
+// Draining returns whether the Drainer has started draining
+func (d *Drainer) Draining() bool {
+	return atomic.LoadInt32(&d.draining) == 1
+}
+
+func (d *Drainer) ShouldRateLimit(ctx context.Context, relreq *ratelimit.RateLimitRequest) (*ratelimit.RateLimitResponse, error) {
+	if !d.Draining() {
+		return d.srv.ShouldRateLimit(ctx, relreq)
+	}
+
+	code := ratelimit.RateLimitResponse_OK
+	if d.decision == UnknownDrainDecision {
+		code = ratelimit.RateLimitResponse_UNKNOWN
+	}
+
+	resp := &ratelimit.RateLimitResponse{OverallCode: code}
+	for i := 0; i < len(relreq.GetDescriptors()); i++ {
+		resp.Statuses = append(resp.Statuses, &ratelimit.RateLimitResponse_DescriptorStatus{Code: code})
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,54 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+
+	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+)
+
+func TestDrainer(t *testing.T) {
+	tests := []struct {
+		name     string
+		decision DrainDecision
+		wantCode ratelimit.RateLimitResponse_Code
+	}{
+		{name: "Allow", decision: AllowDrainDecision, wantCode: ratelimit.RateLimitResponse_OK},
+		{name: "Unknown", decision: UnknownDrainDecision, wantCode: ratelimit.RateLimitResponse_UNKNOWN},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decided := 0
+			server := NewServer(func(c context.Context, r Request) (bool, uint32, error) {
+				decided++
+				return true, 0, nil
+			}, StaticReportOnlyProvider{false}, "", 0, TestingLogger, NullReporter{})
+			drainer := NewDrainer(server, test.decision, TestingLogger)
+
+			req := newRateLimitRequest()
+			res, err := drainer.ShouldRateLimit(context.Background(), req)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if res.OverallCode != ratelimit.RateLimitResponse_OVER_LIMIT {
+				t.Errorf("expected requests to be decided before draining, received: %v", res.OverallCode)
+			}
+
+			drainer.Drain()
+			res, err = drainer.ShouldRateLimit(context.Background(), req)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if res.OverallCode != test.wantCode || len(res.Statuses) != len(req.Descriptors) {
+				t.Errorf("expected draining requests to be answered with %v, received: %v", test.wantCode, res)
+			}
+
+			if decided != 1 {
+				t.Errorf("expected only requests before draining to be decided, received: %v", decided)
+			}
+		})
+	}
+}
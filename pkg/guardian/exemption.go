@@ -0,0 +1,66 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Exemption exempts requests matching an expression, such as health checks or synthetic monitoring, from rate
+// limiting
+type Exemption struct {
+	Name string
+	When *Expression
+}
+
+// ParseExemption parses an Exemption from name=expression, e.g. healthz=req.path == "/healthz". The name tags the
+// metrics of exempted requests.
+func ParseExemption(s string) (Exemption, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return Exemption{}, fmt.Errorf("exemption %q must be of the form name=expression", s)
+	}
+
+	name := strings.TrimSpace(parts[0])
+	if err := ValidateMetricTag(exemptionKey + ":" + name); err != nil {
+		return Exemption{}, errors.Wrap(err, fmt.Sprintf("invalid name for exemption %v", name))
+	}
+
+	when, err := ParseExpression(parts[1])
+	if err != nil {
+		return Exemption{}, errors.Wrap(err, fmt.Sprintf("invalid expression for exemption %v", name))
+	}
+
+	return Exemption{Name: name, When: when}, nil
+}
+
+// ExemptRequests wraps f, allowing requests matching any of exemptions without calling f unless blacklister blocks
+// them. Exempted requests aren't counted against any limit, analyzed, published, or reported by the metrics of
+// decided requests, so monitoring traffic neither consumes quota nor skews metrics. They are only counted by the
+// request.exempt metric.
+func ExemptRequests(f RequestBlockerFunc, exemptions []Exemption, blacklister *IPBlacklister, reporter MetricReporter) RequestBlockerFunc {
+	if len(exemptions) == 0 {
+		return f
+	}
+
+	blacklisted := CondStopOnBlacklistFunc(blacklister)
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		for _, exemption := range exemptions {
+			if exemption.When.Eval(r) {
+				// exemptions match attributes anyone can send, so they don't lift the blacklist
+				if stop, blocked, remaining, err := blacklisted(c, r); stop || err != nil {
+					return blocked, remaining, err
+				}
+
+				tracef(c, "exempted by %v", exemption.Name)
+				DecisionHintFromContext(c).Exempt(exemption.Name)
+				reporter.ExemptedRequest(exemption.Name)
+				return false, RequestsRemainingMax, nil
+			}
+		}
+
+		return f(c, r)
+	}
+}
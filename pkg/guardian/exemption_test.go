@@ -0,0 +1,83 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseExemption(t *testing.T) {
+	exemption, err := ParseExemption(`healthz=req.path == "/healthz"`)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if exemption.Name != "healthz" || !exemption.When.Eval(Request{Path: "/healthz"}) {
+		t.Fatalf("unexpected exemption: %v", exemption)
+	}
+
+	for _, s := range []string{`req.path == "/healthz"`, `=true`, `health check=true`, `healthz=req.path ==`} {
+		if _, err := ParseExemption(s); err == nil {
+			t.Errorf("expected error parsing %q but received nil", s)
+		}
+	}
+}
+
+func TestExemptRequests(t *testing.T) {
+	decided := 0
+	f := func(c context.Context, r Request) (bool, uint32, error) {
+		decided++
+		return true, 0, nil
+	}
+
+	exemptions := []Exemption{}
+	for _, s := range []string{`healthz=req.path == "/healthz"`, `synthetics=req.header("user-agent").startsWith("DatadogSynthetics")`} {
+		exemption, err := ParseExemption(s)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		exemptions = append(exemptions, exemption)
+	}
+
+	tests := []struct {
+		name      string
+		request   Request
+		exemption string
+	}{
+		{name: "HealthCheck", request: Request{RemoteAddress: "192.168.1.2", Path: "/healthz"}, exemption: "healthz"},
+		{name: "SyntheticMonitor", request: Request{RemoteAddress: "192.168.1.2", Path: "/", Headers: map[string]string{"user-agent": "DatadogSynthetics/1.0"}}, exemption: "synthetics"},
+		{name: "NotExempt", request: Request{RemoteAddress: "192.168.1.2", Path: "/"}, exemption: ""},
+	}
+
+	blacklister := NewIPBlacklister(&FakeBlacklistStore{blacklist: parseCIDRs([]string{"10.0.0.0/8"})}, TestingLogger, NullReporter{})
+	exempt := ExemptRequests(f, exemptions, blacklister, NullReporter{})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decided = 0
+			hint := NewDecisionHint()
+			blocked, _, err := exempt(WithDecisionHint(context.Background(), hint), test.request)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if got := hint.Exemption(); got != test.exemption {
+				t.Fatalf("expected exemption %q received: %q", test.exemption, got)
+			}
+
+			if exempted := test.exemption != ""; blocked == exempted || (decided == 0) != exempted {
+				t.Fatalf("expected exempted: %v received blocked: %v after %d decisions", exempted, blocked, decided)
+			}
+		})
+	}
+
+	// the blacklist is still enforced for requests matching an exemption
+	decided = 0
+	hint := NewDecisionHint()
+	blocked, _, err := exempt(WithDecisionHint(context.Background(), hint), Request{RemoteAddress: "10.0.0.1", Path: "/healthz"})
+	if err != nil || !blocked || decided != 0 {
+		t.Fatalf("expected blacklisted request to be blocked without being decided, received: (%v, %v) after %d decisions", blocked, err, decided)
+	}
+
+	if reason := hint.BlockReason(); reason == nil || reason.Kind != BlacklistBlockReason || hint.Exemption() != "" {
+		t.Fatalf("expected the blacklist to block the request, received reason %v and exemption %q", reason, hint.Exemption())
+	}
+}
@@ -0,0 +1,188 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const redisLimitExperimentKey = "guardian_conf:limit_experiment"
+const experimentNamespace = "experiment"
+
+// MaxExperimentPercent is the largest valid LimitExperiment.Percent
+const MaxExperimentPercent = 100
+
+// LimitExperiment evaluates a percentage of keys, chosen deterministically by hashing the key, with an alternate limit
+// so its behavior can be compared with the limit on real traffic before switching. Keys in the cohort are counted by
+// both limits and are limited by the alternate, keys outside of it are unaffected.
+type LimitExperiment struct {
+	// Percent is the percentage of keys in the cohort. Zero disables the experiment.
+	Percent uint
+	// Limit is applied to the limit resolved for a request to produce the alternate limit. It can't override Enabled,
+	// limits that aren't enabled aren't experimented on.
+	Limit LimitOverride
+}
+
+func (e LimitExperiment) String() string {
+	return fmt.Sprintf("LimitExperiment(%d%% of keys, limit: %v)", e.Percent, e.Limit)
+}
+
+// Enabled returns whether any keys are in the experiment's cohort
+func (e LimitExperiment) Enabled() bool {
+	return e.Percent > 0
+}
+
+// InCohort returns whether key is evaluated by the experiment's alternate limit. The split is independent of the one
+// made by Limit.EnforcePercent, so experimenting doesn't only sample keys a ramping limit does or doesn't enforce.
+func (e LimitExperiment) InCohort(key string) bool {
+	if e.Percent == 0 {
+		return false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(experimentNamespace + ":" + key))
+	return uint(h.Sum32()%MaxExperimentPercent) < e.Percent
+}
+
+// LimitExperimentDocument is the JSON form of a LimitExperiment
+type LimitExperimentDocument struct {
+	Percent uint                  `json:"percent"`
+	Limit   LimitOverrideDocument `json:"limit"`
+}
+
+// LimitExperimentDocumentFromExperiment converts a LimitExperiment to a LimitExperimentDocument
+func LimitExperimentDocumentFromExperiment(e LimitExperiment) LimitExperimentDocument {
+	return LimitExperimentDocument{Percent: e.Percent, Limit: LimitOverrideDocumentFromOverride(e.Limit)}
+}
+
+// Experiment converts the document to a LimitExperiment, returning an error if it is invalid
+func (d LimitExperimentDocument) Experiment() (LimitExperiment, error) {
+	if d.Percent > MaxExperimentPercent {
+		return LimitExperiment{}, fmt.Errorf("experiment percent %d must be between 0 and %d", d.Percent, MaxExperimentPercent)
+	}
+
+	o, err := d.Limit.Override()
+	if err != nil {
+		return LimitExperiment{}, errors.Wrap(err, "invalid experiment limit")
+	}
+
+	if o.Enabled != nil {
+		return LimitExperiment{}, fmt.Errorf("experiment limit can't override enabled, limits that aren't enabled aren't experimented on")
+	}
+
+	return LimitExperiment{Percent: d.Percent, Limit: o}, nil
+}
+
+// LimitExperimentProvider provides the current limit experiment
+type LimitExperimentProvider interface {
+	// GetLimitExperiment returns the current limit experiment, which is disabled if none is set
+	GetLimitExperiment() LimitExperiment
+}
+
+// experiment returns the alternate limit and the request it's counted for if request is in the cohort of the current
+// limit experiment. The alternate is counted under its own keys so the limit's counts carry on unaffected when the
+// experiment ends.
+func (rl *IPRateLimiter) experiment(request Request, limit Limit) (Limit, Request, bool) {
+	provider, ok := rl.conf.(LimitExperimentProvider)
+	if !ok {
+		return Limit{}, Request{}, false
+	}
+
+	e := provider.GetLimitExperiment()
+	if !e.InCohort(request.RemoteAddress) {
+		return Limit{}, Request{}, false
+	}
+
+	alternate := e.Limit.Apply(limit)
+	if err := ValidateResolvedLimit(alternate); err != nil {
+		rl.logger.WithError(err).Warnf("skipping experiment for request %v, alternate of %v is invalid", request, limit)
+		return Limit{}, Request{}, false
+	}
+
+	shadow := request
+	shadow.RemoteAddress = NamespacedKey(experimentNamespace, request.RemoteAddress)
+	return alternate, shadow, true
+}
+
+// experimenting returns whether a limit experiment is enabled
+func (rl *IPRateLimiter) experimenting() bool {
+	provider, ok := rl.conf.(LimitExperimentProvider)
+	return ok && provider.GetLimitExperiment().Enabled()
+}
+
+// incrExperiment counts request against both limit and the experiment's alternate, reporting both decisions. It
+// returns the alternate's result, or limit's if the alternate couldn't be counted.
+func (rl *IPRateLimiter) incrExperiment(context context.Context, request Request, scope string, limit Limit, shadow Request, alternate Limit) (Limit, uint64, bool, error) {
+	count, blocked, err := rl.incr(context, request, scope, limit, request.Hits())
+	if err != nil {
+		return limit, count, blocked, err
+	}
+
+	altCount, altBlocked, altErr := rl.incr(context, shadow, scope, alternate, request.Hits())
+	if altErr != nil {
+		rl.logger.WithError(altErr).Errorf("error incrementing experiment limit for request %v, using %v", request, limit)
+		return limit, count, blocked, nil
+	}
+
+	primaryBlocked := (blocked || count > limit.Count) && limit.Enforced(request.RemoteAddress)
+	alternateBlocked := (altBlocked || altCount > alternate.Count) && alternate.Enforced(request.RemoteAddress)
+	tracef(context, "experiment: %v blocked: %v, alternate %v blocked: %v", limit, primaryBlocked, alternate, alternateBlocked)
+	rl.reporter.LimitExperiment(request, limit, primaryBlocked, alternate, alternateBlocked)
+	return alternate, altCount, altBlocked, nil
+}
+
+func (rs *RedisConfStore) GetLimitExperiment() LimitExperiment {
+	rs.conf.RLock()
+	defer rs.conf.RUnlock()
+
+	return rs.conf.limitExperiment
+}
+
+func (rs *RedisConfStore) FetchLimitExperiment() (LimitExperiment, error) {
+	c := rs.pipelinedFetchConf()
+	if c.limitExperiment == nil {
+		return LimitExperiment{}, fmt.Errorf("error fetching limit experiment")
+	}
+
+	return *c.limitExperiment, nil
+}
+
+// SetLimitExperiment sets the limit experiment, replacing any current experiment
+func (rs *RedisConfStore) SetLimitExperiment(e LimitExperiment) error {
+	experimentJSON, err := json.Marshal(LimitExperimentDocumentFromExperiment(e))
+	if err != nil {
+		return err
+	}
+
+	rs.logger.Debugf("Sending SET for key %v", redisLimitExperimentKey)
+	return rs.multi(func(pipe redis.Pipeliner) {
+		pipe.Set(redisLimitExperimentKey, string(experimentJSON), 0)
+	})
+}
+
+// RemoveLimitExperiment ends the limit experiment
+func (rs *RedisConfStore) RemoveLimitExperiment() error {
+	rs.logger.Debugf("Sending DEL for key %v", redisLimitExperimentKey)
+	return rs.multi(func(pipe redis.Pipeliner) {
+		pipe.Del(redisLimitExperimentKey)
+	})
+}
+
+// parseLimitExperiment parses a JSON encoded LimitExperimentDocument, validating its alternate against parent
+func parseLimitExperiment(experimentStr string, parent Limit) (LimitExperiment, error) {
+	doc := LimitExperimentDocument{}
+	if err := json.Unmarshal([]byte(experimentStr), &doc); err != nil {
+		return LimitExperiment{}, errors.Wrap(err, "error decoding limit experiment")
+	}
+
+	e, err := doc.Experiment()
+	if err != nil {
+		return LimitExperiment{}, err
+	}
+
+	return e, ValidateResolvedLimit(e.Limit.Apply(parent))
+}
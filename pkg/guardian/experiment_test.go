@@ -0,0 +1,216 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type FakeLimitExperimentReporter struct {
+	NullReporter
+	primaryBlocked   []bool
+	alternateBlocked []bool
+}
+
+func (f *FakeLimitExperimentReporter) LimitExperiment(request Request, primary Limit, primaryBlocked bool, alternate Limit, alternateBlocked bool) {
+	f.primaryBlocked = append(f.primaryBlocked, primaryBlocked)
+	f.alternateBlocked = append(f.alternateBlocked, alternateBlocked)
+}
+
+func TestLimitExperimentInCohort(t *testing.T) {
+	inCohort := func(e LimitExperiment) int {
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if e.InCohort(fmt.Sprintf("10.0.%d.%d", i/256, i%256)) {
+				n++
+			}
+		}
+		return n
+	}
+
+	if got := inCohort(LimitExperiment{}); got != 0 {
+		t.Errorf("expected no keys in the cohort of a disabled experiment, received: %v", got)
+	}
+
+	if got := inCohort(LimitExperiment{Percent: MaxExperimentPercent}); got != 1000 {
+		t.Errorf("expected every key in the cohort, received: %v", got)
+	}
+
+	if got := inCohort(LimitExperiment{Percent: 30}); got < 250 || got > 350 {
+		t.Errorf("expected about 300 keys in the cohort, received: %v", got)
+	}
+
+	e := LimitExperiment{Percent: 50}
+	if e.InCohort("192.168.1.2") != e.InCohort("192.168.1.2") {
+		t.Error("expected the cohort to be deterministic")
+	}
+}
+
+func TestLimitExperimentDocumentValidation(t *testing.T) {
+	count := uint64(10)
+	if _, err := (LimitExperimentDocument{Percent: 10, Limit: LimitOverrideDocument{Count: &count, Algorithm: string(LeakyBucketAlgorithm)}}).Experiment(); err != nil {
+		t.Errorf("got error: %v", err)
+	}
+
+	if _, err := (LimitExperimentDocument{Percent: MaxExperimentPercent + 1}).Experiment(); err == nil {
+		t.Error("expected an error for a percent over the max")
+	}
+
+	enabled := false
+	if _, err := (LimitExperimentDocument{Percent: 10, Limit: LimitOverrideDocument{Enabled: &enabled}}).Experiment(); err == nil {
+		t.Error("expected an error for an experiment overriding enabled")
+	}
+
+	if _, err := (LimitExperimentDocument{Percent: 10, Limit: LimitOverrideDocument{Algorithm: "sliding_log"}}).Experiment(); err == nil {
+		t.Error("expected an error for an unknown algorithm")
+	}
+}
+
+func TestIPRateLimiterLimitExperiment(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	limit := Limit{Count: 3, Duration: time.Minute, Enabled: true}
+	if err := c.SetLimit(limit); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	alternateCount := uint64(1)
+	experiment := LimitExperiment{Percent: 50, Limit: LimitOverride{Count: &alternateCount}}
+	if err := c.SetLimitExperiment(experiment); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	c.UpdateCachedConf()
+
+	var cohort, control string
+	for i := 0; cohort == "" || control == ""; i++ {
+		key := fmt.Sprintf("192.168.1.%d", i)
+		if experiment.InCohort(key) {
+			cohort = key
+		} else {
+			control = key
+		}
+	}
+
+	now := time.Date(2018, 4, 5, 10, 0, 0, 0, time.UTC)
+	counter := &FakeLimitStore{count: make(map[string]uint64)}
+	reporter := &FakeLimitExperimentReporter{}
+	rl := NewIPRateLimiter(c, counter, &fixedClock{now}, nil, TestingLogger, reporter)
+
+	for i, expected := range []bool{false, true} {
+		blocked, _, err := rl.Limit(context.Background(), Request{RemoteAddress: cohort})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if blocked != expected {
+			t.Errorf("expected cohort request %d blocked: %v received: %v", i, expected, blocked)
+		}
+	}
+
+	if len(reporter.primaryBlocked) != 2 || reporter.primaryBlocked[1] || !reporter.alternateBlocked[1] {
+		t.Errorf("expected the second cohort request reported as allowed by the limit and blocked by the alternate, received: %v %v", reporter.primaryBlocked, reporter.alternateBlocked)
+	}
+
+	for i := 0; i < 2; i++ {
+		if blocked, _, err := rl.Limit(context.Background(), Request{RemoteAddress: control}); err != nil || blocked {
+			t.Errorf("expected control request %d to be allowed, received: %v err: %v", i, blocked, err)
+		}
+	}
+
+	if len(reporter.primaryBlocked) != 2 {
+		t.Errorf("expected only cohort requests to be reported, received: %d", len(reporter.primaryBlocked))
+	}
+
+	slot := rl.SlotKey(Request{RemoteAddress: cohort}, "", limit, now)
+	if counter.count[slot] != 2 || counter.count[NamespacedKey(experimentNamespace, slot)] != 2 {
+		t.Errorf("expected the cohort counted against both limits, received: %v", counter.count)
+	}
+
+	quota, err := rl.Quota(context.Background(), Request{RemoteAddress: cohort})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if quota.Limit.Count != alternateCount || quota.Count != 2 {
+		t.Errorf("expected the cohort's quota of the alternate limit, received: %+v", quota)
+	}
+}
+
+func TestConfStoreLimitExperiment(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	if err := c.SetLimit(Limit{Count: 10, Duration: time.Minute, Enabled: true}); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	algorithm := LeakyBucketAlgorithm
+	experiment := LimitExperiment{Percent: 20, Limit: LimitOverride{Algorithm: &algorithm, Metadata: Metadata{Owner: "team-edge"}}}
+	if err := c.SetLimitExperiment(experiment); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	c.UpdateCachedConf()
+
+	if got := c.GetLimitExperiment(); got.String() != experiment.String() || got.Limit.Metadata != experiment.Limit.Metadata {
+		t.Errorf("expected: %v received: %v", experiment, got)
+	}
+
+	doc, err := c.ExportConfDocument()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if doc.LimitExperiment == nil || doc.LimitExperiment.Percent != 20 {
+		t.Errorf("expected the experiment to be exported, received: %v", doc.LimitExperiment)
+	}
+
+	s.Set(redisLimitExperimentKey, `{"percent": 500}`)
+	c.UpdateCachedConf()
+	if got := c.GetLimitExperiment(); got.Percent != 20 {
+		t.Errorf("expected an invalid experiment to keep the last known good experiment, received: %v", got)
+	}
+
+	if err := c.RemoveLimitExperiment(); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	c.UpdateCachedConf()
+
+	if got := c.GetLimitExperiment(); got.Enabled() {
+		t.Errorf("expected the experiment to end, received: %v", got)
+	}
+}
+
+func TestDiffConfDocumentsLimitExperiment(t *testing.T) {
+	count := uint64(5)
+	running := &LimitExperimentDocument{Percent: 10, Limit: LimitOverrideDocument{Count: &count}}
+
+	tests := []struct {
+		name     string
+		live     *LimitExperimentDocument
+		proposed *LimitExperimentDocument
+		want     []ConfChangeKind
+	}{
+		{name: "Unspecified", live: running, proposed: nil, want: []ConfChangeKind{}},
+		{name: "Started", live: nil, proposed: running, want: []ConfChangeKind{ConfAdded}},
+		{name: "Unchanged", live: running, proposed: running, want: []ConfChangeKind{}},
+		{name: "Changed", live: running, proposed: &LimitExperimentDocument{Percent: 20, Limit: running.Limit}, want: []ConfChangeKind{ConfChanged}},
+		{name: "Ended", live: running, proposed: &LimitExperimentDocument{}, want: []ConfChangeKind{ConfRemoved}},
+		{name: "NotRunning", live: nil, proposed: &LimitExperimentDocument{}, want: []ConfChangeKind{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := DiffConfDocuments(ConfDocument{LimitExperiment: test.live}, ConfDocument{LimitExperiment: test.proposed})
+			kinds := []ConfChangeKind{}
+			for _, change := range changes {
+				kinds = append(kinds, change.Kind)
+			}
+
+			if fmt.Sprint(kinds) != fmt.Sprint(test.want) {
+				t.Errorf("expected: %v received: %v", test.want, changes)
+			}
+		})
+	}
+}
@@ -0,0 +1,538 @@
+package guardian
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Expression is a compiled boolean expression evaluated against a request, used to match rules. Expressions
+// support string literals, true and false, &&, ||, !, == and !=, parentheses, and the following:
+//
+//	req.path, req.method, req.authority, req.remote_address  request fields
+//	req.host                                                the authority without its port, see Request.Host
+//	req.source_port, req.sni, req.alpn                      the client's port, TLS server name, and negotiated
+//	                                                        protocol, empty if unknown
+//	req.authenticated                                       whether the Authorization header holds well formed
+//	                                                        credentials, see Request.Authenticated
+//	req.tier                                                the plan tier of the request's API key, empty if
+//	                                                        unknown, see WithPlanTier
+//	req.operation                                           the id of the OpenAPI operation the request matches,
+//	                                                        empty if unknown, see WithOpenAPIOperation
+//	req.internal                                            whether the remote address is in the internal
+//	                                                        networks, see WithInternalNetworks
+//	req.grpc_service, req.grpc_method                       the service and method called by a gRPC request, empty
+//	                                                        for other requests, see Request.GRPCMethod
+//	req.header("name")                                      a request header, empty if missing
+//	req.metadata("name")                                    an Envoy dynamic metadata value, empty if missing
+//	req.query("name")                                       the values of a query parameter of the path, a single
+//	                                                        empty value if missing, see Request.Query
+//	req.hasQuery("name")                                    whether the path has the query parameter, even without
+//	                                                        a value
+//	ip                                                      the remote address
+//	s.startsWith("x"), s.endsWith("x"), s.contains("x")     string tests
+//	s.matches("regexp")                                     regular expression match
+//	s.inCIDR("10.0.0.0/8")                                  whether s is an IP within the CIDR
+//	s.inRange("1024-65535")                                 whether s is a number within the inclusive range, or
+//	                                                        equal to a single number such as "443"
+//
+// Comparisons and string tests of the values of a query parameter match if any value matches, so
+// req.query("tag") == "beta" matches ?tag=alpha&tag=beta, while != matches if no value is equal.
+//
+// For example: req.path.startsWith("/api") && req.method == "POST" && !ip.inCIDR("10.0.0.0/8")
+type Expression struct {
+	source string
+	eval   func(*Request) bool
+}
+
+// ParseExpression compiles an Expression, returning an error if it is malformed or doesn't evaluate to a boolean
+func ParseExpression(source string) (*Expression, error) {
+	tokens, err := lexExpression(source)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error parsing expression %q", source))
+	}
+
+	p := &exprParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = fmt.Errorf("unexpected %v", p.peek())
+	}
+
+	if err == nil && node.kind != boolValue {
+		err = fmt.Errorf("expression is a %v, not a bool", node.kind)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error parsing expression %q", source))
+	}
+
+	return &Expression{source: source, eval: node.boolFn}, nil
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against request
+func (e *Expression) Eval(request Request) bool {
+	return e.eval(&request)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.val)
+	}
+
+	return fmt.Sprintf("%q", t.val)
+}
+
+func lexExpression(source string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(source) && source[end] != '"'; end++ {
+				if source[end] == '\\' {
+					end++
+				}
+			}
+
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+
+			val, err := strconv.Unquote(source[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d", i)
+			}
+
+			tokens = append(tokens, token{kind: tokenString, val: val})
+			i = end + 1
+		case c == '_' || unicode.IsLetter(c):
+			end := i
+			for ; end < len(source) && (source[end] == '_' || unicode.IsLetter(rune(source[end])) || unicode.IsDigit(rune(source[end]))); end++ {
+			}
+			tokens = append(tokens, token{kind: tokenIdent, val: source[i:end]})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "!", "(", ")", ".", ","} {
+				if strings.HasPrefix(source[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+
+			tokens = append(tokens, token{kind: tokenOp, val: op})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+type valueKind string
+
+const (
+	boolValue    valueKind = "bool"
+	stringValue  valueKind = "string"
+	stringsValue valueKind = "strings"
+	requestValue valueKind = "request"
+)
+
+// exprNode is a compiled sub expression, exactly one of its functions is set according to its kind
+type exprNode struct {
+	kind      valueKind
+	boolFn    func(*Request) bool
+	stringFn  func(*Request) string
+	stringsFn func(*Request) []string
+	literal   *string // set for string literals so arguments can be validated and precompiled
+}
+
+// anyString returns a function evaluating test against the value of a string node, or against each value of a
+// strings node, true if any value passes
+func anyString(node exprNode, test func(*Request, string) bool) func(*Request) bool {
+	if node.kind == stringValue {
+		s := node.stringFn
+		return func(r *Request) bool { return test(r, s(r)) }
+	}
+
+	values := node.stringsFn
+	return func(r *Request) bool {
+		for _, v := range values(r) {
+			if test(r, v) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) acceptOp(op string) bool {
+	if t := p.peek(); t.kind == tokenOp && t.val == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return fmt.Errorf("expected %q but found %v", op, p.peek())
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.acceptOp("||") {
+		var right exprNode
+		if right, err = p.parseAnd(); err == nil {
+			left, err = combineBools("||", left, right, func(a, b func(*Request) bool) func(*Request) bool {
+				return func(r *Request) bool { return a(r) || b(r) }
+			})
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.acceptOp("&&") {
+		var right exprNode
+		if right, err = p.parseUnary(); err == nil {
+			left, err = combineBools("&&", left, right, func(a, b func(*Request) bool) func(*Request) bool {
+				return func(r *Request) bool { return a(r) && b(r) }
+			})
+		}
+	}
+	return left, err
+}
+
+func combineBools(op string, left, right exprNode, combine func(a, b func(*Request) bool) func(*Request) bool) (exprNode, error) {
+	if left.kind != boolValue || right.kind != boolValue {
+		return exprNode{}, fmt.Errorf("%q requires bool operands, found %v and %v", op, left.kind, right.kind)
+	}
+	return exprNode{kind: boolValue, boolFn: combine(left.boolFn, right.boolFn)}, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if !p.acceptOp("!") {
+		return p.parseComparison()
+	}
+
+	operand, err := p.parseUnary()
+	if err != nil {
+		return exprNode{}, err
+	}
+
+	if operand.kind != boolValue {
+		return exprNode{}, fmt.Errorf("\"!\" requires a bool operand, found %v", operand.kind)
+	}
+
+	f := operand.boolFn
+	return exprNode{kind: boolValue, boolFn: func(r *Request) bool { return !f(r) }}, nil
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return exprNode{}, err
+	}
+
+	negate := false
+	switch {
+	case p.acceptOp("=="):
+	case p.acceptOp("!="):
+		negate = true
+	default:
+		return left, nil
+	}
+
+	right, err := p.parsePostfix()
+	if err != nil {
+		return exprNode{}, err
+	}
+
+	if left.kind == stringValue && right.kind == stringsValue {
+		left, right = right, left
+	}
+
+	var eq func(*Request) bool
+	switch {
+	case left.kind == boolValue && right.kind == boolValue:
+		a, b := left.boolFn, right.boolFn
+		eq = func(r *Request) bool { return a(r) == b(r) }
+	case left.kind == stringValue && right.kind == stringValue:
+		a, b := left.stringFn, right.stringFn
+		eq = func(r *Request) bool { return a(r) == b(r) }
+	case left.kind == stringsValue && right.kind == stringValue:
+		b := right.stringFn
+		eq = anyString(left, func(r *Request, v string) bool { return v == b(r) })
+	default:
+		return exprNode{}, fmt.Errorf("cannot compare %v with %v", left.kind, right.kind)
+	}
+
+	if negate {
+		return exprNode{kind: boolValue, boolFn: func(r *Request) bool { return !eq(r) }}, nil
+	}
+	return exprNode{kind: boolValue, boolFn: eq}, nil
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	for err == nil && p.acceptOp(".") {
+		name := p.next()
+		if name.kind != tokenIdent {
+			return exprNode{}, fmt.Errorf("expected a field or method name but found %v", name)
+		}
+
+		if !p.acceptOp("(") {
+			node, err = field(node, name.val)
+			continue
+		}
+
+		var args []exprNode
+		if args, err = p.parseArgs(); err == nil {
+			node, err = method(node, name.val, args)
+		}
+	}
+	return node, err
+}
+
+func (p *exprParser) parseArgs() ([]exprNode, error) {
+	args := []exprNode{}
+	if p.acceptOp(")") {
+		return args, nil
+	}
+
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if p.acceptOp(")") {
+			return args, nil
+		}
+
+		if err := p.expectOp(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenString:
+		val := t.val
+		return exprNode{kind: stringValue, stringFn: func(*Request) string { return val }, literal: &val}, nil
+	case t.kind == tokenIdent && (t.val == "true" || t.val == "false"):
+		val := t.val == "true"
+		return exprNode{kind: boolValue, boolFn: func(*Request) bool { return val }}, nil
+	case t.kind == tokenIdent && t.val == "req":
+		return exprNode{kind: requestValue}, nil
+	case t.kind == tokenIdent && t.val == "ip":
+		return exprNode{kind: stringValue, stringFn: func(r *Request) string { return r.RemoteAddress }}, nil
+	case t.kind == tokenOp && t.val == "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return exprNode{}, err
+		}
+		return node, p.expectOp(")")
+	case t.kind == tokenIdent:
+		return exprNode{}, fmt.Errorf("unknown identifier %q", t.val)
+	}
+
+	return exprNode{}, fmt.Errorf("unexpected %v", t)
+}
+
+func field(node exprNode, name string) (exprNode, error) {
+	if node.kind != requestValue {
+		return exprNode{}, fmt.Errorf("%v has no field %q", node.kind, name)
+	}
+
+	var f func(*Request) string
+	switch name {
+	case "path":
+		f = func(r *Request) string { return r.Path }
+	case "method":
+		f = func(r *Request) string { return r.Method }
+	case "authority":
+		f = func(r *Request) string { return r.Authority }
+	case "remote_address":
+		f = func(r *Request) string { return r.RemoteAddress }
+	case "host":
+		f = func(r *Request) string { return r.Host() }
+	case "source_port":
+		f = func(r *Request) string { return r.SourcePort }
+	case "sni":
+		f = func(r *Request) string { return r.SNI }
+	case "alpn":
+		f = func(r *Request) string { return r.ALPN }
+	case "tier":
+		f = func(r *Request) string { return r.Tier }
+	case "operation":
+		f = func(r *Request) string { return r.Operation }
+	case "grpc_service":
+		f = func(r *Request) string { m, _ := r.GRPCMethod(); return m.Service }
+	case "grpc_method":
+		f = func(r *Request) string { m, _ := r.GRPCMethod(); return m.Method }
+	case "authenticated":
+		return exprNode{kind: boolValue, boolFn: func(r *Request) bool { return r.Authenticated(time.Now()) }}, nil
+	case "internal":
+		return exprNode{kind: boolValue, boolFn: func(r *Request) bool { return r.Internal }}, nil
+	default:
+		return exprNode{}, fmt.Errorf("request has no field %q", name)
+	}
+
+	return exprNode{kind: stringValue, stringFn: f}, nil
+}
+
+func method(node exprNode, name string, args []exprNode) (exprNode, error) {
+	if len(args) != 1 || args[0].kind != stringValue {
+		return exprNode{}, fmt.Errorf("%v takes a single string argument", name)
+	}
+	arg := args[0]
+
+	if node.kind == requestValue {
+		argFn := arg.stringFn
+		switch name {
+		case "header":
+			return exprNode{kind: stringValue, stringFn: func(r *Request) string { return r.Headers[argFn(r)] }}, nil
+		case "metadata":
+			return exprNode{kind: stringValue, stringFn: func(r *Request) string { return r.Metadata[argFn(r)] }}, nil
+		case "query":
+			return exprNode{kind: stringsValue, stringsFn: func(r *Request) []string {
+				if values := r.Query()[argFn(r)]; len(values) > 0 {
+					return values
+				}
+				return []string{""}
+			}}, nil
+		case "hasQuery":
+			return exprNode{kind: boolValue, boolFn: func(r *Request) bool { _, ok := r.Query()[argFn(r)]; return ok }}, nil
+		}
+		return exprNode{}, fmt.Errorf("request has no method %q", name)
+	}
+
+	if node.kind != stringValue && node.kind != stringsValue {
+		return exprNode{}, fmt.Errorf("%v has no method %q", node.kind, name)
+	}
+
+	argFn := arg.stringFn
+	var f func(*Request, string) bool
+	switch name {
+	case "startsWith":
+		f = func(r *Request, s string) bool { return strings.HasPrefix(s, argFn(r)) }
+	case "endsWith":
+		f = func(r *Request, s string) bool { return strings.HasSuffix(s, argFn(r)) }
+	case "contains":
+		f = func(r *Request, s string) bool { return strings.Contains(s, argFn(r)) }
+	case "matches":
+		if arg.literal == nil {
+			return exprNode{}, fmt.Errorf("matches requires a string literal")
+		}
+		re, err := regexp.Compile(*arg.literal)
+		if err != nil {
+			return exprNode{}, errors.Wrap(err, "invalid regexp")
+		}
+		f = func(r *Request, s string) bool { return re.MatchString(s) }
+	case "inCIDR":
+		if arg.literal == nil {
+			return exprNode{}, fmt.Errorf("inCIDR requires a string literal")
+		}
+		prefix, err := netip.ParsePrefix(*arg.literal)
+		if err != nil {
+			return exprNode{}, errors.Wrap(err, "invalid cidr")
+		}
+		prefix = prefix.Masked()
+		f = func(r *Request, s string) bool {
+			ip, ok := parseRemoteAddr(s)
+			return ok && prefix.Contains(ip)
+		}
+	case "inRange":
+		if arg.literal == nil {
+			return exprNode{}, fmt.Errorf("inRange requires a string literal")
+		}
+		min, max, err := parseRange(*arg.literal)
+		if err != nil {
+			return exprNode{}, err
+		}
+		f = func(r *Request, s string) bool {
+			n, err := strconv.ParseUint(s, 10, 64)
+			return err == nil && n >= min && n <= max
+		}
+	default:
+		return exprNode{}, fmt.Errorf("%v has no method %q", node.kind, name)
+	}
+
+	return exprNode{kind: boolValue, boolFn: anyString(node, f)}, nil
+}
+
+// parseRange parses an inclusive range of numbers such as "1024-65535", or a single number such as "443"
+func parseRange(s string) (uint64, uint64, error) {
+	parts := strings.SplitN(s, "-", 2)
+	min, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+
+	max := min
+	if len(parts) == 2 {
+		if max, err = strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 64); err != nil || max < min {
+			return 0, 0, fmt.Errorf("invalid range %q", s)
+		}
+	}
+
+	return min, max, nil
+}
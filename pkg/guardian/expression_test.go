@@ -0,0 +1,118 @@
+package guardian
+
+import "testing"
+
+func TestExpressionEval(t *testing.T) {
+	req := Request{
+		RemoteAddress: "192.168.1.2",
+		Authority:     "example.com",
+		Method:        "POST",
+		Path:          "/api/users?page=2&export=true&debug&tag=alpha&tag=beta",
+		SourcePort:    "51234",
+		SNI:           "example.com",
+		ALPN:          "h2",
+		Headers:       map[string]string{"user-agent": "curl/7.54.0"},
+		Metadata:      map[string]string{"user_id": "1234"},
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: `true`, want: true},
+		{expr: `req.path.startsWith("/api") && req.method == "POST" && !ip.inCIDR("10.0.0.0/8")`, want: true},
+		{expr: `req.path.startsWith("/api") && req.method == "GET"`, want: false},
+		{expr: `req.method != "GET" || false`, want: true},
+		{expr: `ip.inCIDR("192.168.0.0/16")`, want: true},
+		{expr: `req.remote_address == "192.168.1.2"`, want: true},
+		{expr: `req.authority.endsWith(".com")`, want: true},
+		{expr: `req.sni == "" || req.sni != req.host`, want: false},
+		{expr: `req.source_port.inRange("1024-65535") && !req.source_port.inRange("443")`, want: true},
+		{expr: `req.alpn == "h2"`, want: true},
+		{expr: `req.path.inRange("0-10")`, want: false},
+		{expr: `req.header("user-agent").matches("^curl/")`, want: true},
+		{expr: `req.header("x-missing") == ""`, want: true},
+		{expr: `req.metadata("user_id") == "1234"`, want: true},
+		{expr: `req.metadata("x-missing") == ""`, want: true},
+		{expr: `!req.authenticated`, want: true},
+		{expr: `req.query("page") == "2" && req.query("export").matches("^(true|1)$")`, want: true},
+		{expr: `req.hasQuery("debug") && req.query("debug") == ""`, want: true},
+		{expr: `req.hasQuery("x-missing") || req.query("x-missing") != ""`, want: false},
+		{expr: `req.query("tag") == "beta" && "alpha" == req.query("tag")`, want: true},
+		{expr: `req.query("tag") != "beta" || req.query("tag") == "gamma"`, want: false},
+		{expr: `req.query("tag").startsWith("be")`, want: true},
+		{expr: `req.path.contains("users") && !(req.method == "POST" && req.authority == "example.com")`, want: false},
+		{expr: `!!true == true`, want: true},
+		{expr: `"a\"b".contains("\"")`, want: true},
+	}
+
+	for _, test := range tests {
+		expr, err := ParseExpression(test.expr)
+		if err != nil {
+			t.Fatalf("error parsing %v: %v", test.expr, err)
+		}
+
+		if got := expr.Eval(req); got != test.want {
+			t.Errorf("%v expected: %v received: %v", test.expr, test.want, got)
+		}
+	}
+}
+
+func TestExpressionEvalHost(t *testing.T) {
+	tests := []struct {
+		authority string
+		expr      string
+		want      bool
+	}{
+		{authority: "example.com", expr: `req.host == "example.com" && req.host == req.authority`, want: true},
+		{authority: "Example.com:443", expr: `req.host == "example.com" && req.authority.endsWith(".com:443")`, want: true},
+		{authority: "[2001:db8::1]:8443", expr: `req.host == "2001:db8::1"`, want: true},
+		{authority: "example.com:8080", expr: `req.host.endsWith(":8080")`, want: false},
+		{authority: "", expr: `req.host == ""`, want: true},
+	}
+
+	for _, test := range tests {
+		expr, err := ParseExpression(test.expr)
+		if err != nil {
+			t.Fatalf("error parsing %v: %v", test.expr, err)
+		}
+
+		if got := expr.Eval(Request{Authority: test.authority}); got != test.want {
+			t.Errorf("%v with authority %q expected: %v received: %v", test.expr, test.authority, test.want, got)
+		}
+	}
+}
+
+func TestParseExpressionRejectsInvalid(t *testing.T) {
+	tests := []string{
+		``,
+		`req.path`,
+		`req.path == true`,
+		`req.body == ""`,
+		`req.path.startsWith(1)`,
+		`req.path.startsWith("a", "b")`,
+		`req.path.reverse("a")`,
+		`ip.inCIDR("10.0.0.0")`,
+		`ip.inCIDR(req.path)`,
+		`req.path.matches("[")`,
+		`req.source_port.inRange("65535-1024")`,
+		`req.source_port.inRange("http")`,
+		`req.source_port.inRange(req.path)`,
+		`req.method == "GET" &&`,
+		`(true`,
+		`"unterminated`,
+		`true & false`,
+		`user.admin`,
+		`!req.path`,
+		`req.query("a") == req.query("b")`,
+		`req.path.startsWith(req.query("a"))`,
+		`!req.query("a")`,
+		`true true`,
+	}
+
+	for _, test := range tests {
+		if _, err := ParseExpression(test); err == nil {
+			t.Errorf("expected error parsing %v but received nil", test)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	feedbackNamespace = "feedback"
+	// redisFeedbackPenaltiesKey is a sorted set of penalized remote addresses scored by the unix time their penalty
+	// expires at, shared by all instances
+	redisFeedbackPenaltiesKey = "guardian_feedback_penalties"
+)
+
+// maxFeedbackReports is the maximum number of reports accepted in a single feedback request
+const maxFeedbackReports = 1000
+
+// FeedbackAction is the action taken on requests from remote addresses penalized for abusive outcomes
+type FeedbackAction string
+
+const (
+	// FeedbackBlockAction blocks requests from penalized remote addresses
+	FeedbackBlockAction FeedbackAction = "block"
+
+	// FeedbackThrottleAction rate limits requests from penalized remote addresses with a reduced limit
+	FeedbackThrottleAction FeedbackAction = "throttle"
+)
+
+// FeedbackReport is an outcome of a request reported by an upstream service
+type FeedbackReport struct {
+	RemoteAddress string `json:"remote_address"`
+	Path          string `json:"path"`
+	Status        int    `json:"status"`
+}
+
+// FeedbackPolicy configures when remote addresses are penalized for abusive outcomes
+type FeedbackPolicy struct {
+	// Statuses are the response statuses counted as abusive, e.g. 401 for failed logins
+	Statuses map[int]bool
+	// Threshold is the number of abusive outcomes within Window that penalizes a remote address
+	Threshold uint64
+	Window    time.Duration
+	// PenaltyDuration is how long a remote address stays penalized
+	PenaltyDuration time.Duration
+}
+
+// NewFeedbackPenalties creates a new FeedbackPenalties
+func NewFeedbackPenalties(redis *redis.Client, counter Counter, clock Clock, policy FeedbackPolicy, logger logrus.FieldLogger) *FeedbackPenalties {
+	return &FeedbackPenalties{redis: redis, counter: counter, clock: clock, policy: policy, logger: logger, penalties: make(map[string]time.Time)}
+}
+
+// FeedbackPenalties counts abusive outcomes reported by upstream services, such as repeated 401s on /login,
+// penalizing remote addresses with too many of them. Penalties are stored in Redis, and synced to every instance so
+// checking them doesn't wait on Redis.
+type FeedbackPenalties struct {
+	redis   *redis.Client
+	counter Counter
+	clock   Clock
+	policy  FeedbackPolicy
+	logger  logrus.FieldLogger
+
+	mu        sync.RWMutex
+	penalties map[string]time.Time // remote address to penalty expiration
+}
+
+// Report counts report if its status is abusive, returning whether its remote address is now penalized
+func (fp *FeedbackPenalties) Report(context context.Context, report FeedbackReport) (bool, error) {
+	if !fp.policy.Statuses[report.Status] || len(report.RemoteAddress) == 0 {
+		return false, nil
+	}
+	report.RemoteAddress = CanonicalRemoteAddress(report.RemoteAddress)
+
+	limit := Limit{Count: fp.policy.Threshold, Duration: fp.policy.Window, Enabled: true}
+	key := NamespacedKey(feedbackNamespace, report.RemoteAddress)
+	count, _, err := incrLimitKey(context, fp.counter, fp.clock, key, limit, 1)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("error counting feedback for %v", report.RemoteAddress))
+	}
+
+	if count < fp.policy.Threshold {
+		return false, nil
+	}
+
+	expireAt := fp.clock.Now().Add(fp.policy.PenaltyDuration)
+	member := redis.Z{Score: float64(expireAt.Unix()), Member: report.RemoteAddress}
+	if err := fp.redis.ZAdd(redisFeedbackPenaltiesKey, member).Err(); err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("error penalizing %v", report.RemoteAddress))
+	}
+
+	fp.logger.Infof("penalizing %v until %v after %d abusive outcomes, last %d on %v", report.RemoteAddress, expireAt, count, report.Status, report.Path)
+
+	fp.mu.Lock()
+	fp.penalties[report.RemoteAddress] = expireAt
+	fp.mu.Unlock()
+
+	return true, nil
+}
+
+// Penalized returns whether remoteAddress is currently penalized
+func (fp *FeedbackPenalties) Penalized(remoteAddress string) bool {
+	return fp.penalizedFor(remoteAddress) > 0
+}
+
+// penalizedFor returns how much longer remoteAddress is penalized for, zero if it isn't
+func (fp *FeedbackPenalties) penalizedFor(remoteAddress string) time.Duration {
+	fp.mu.RLock()
+	expireAt, ok := fp.penalties[remoteAddress]
+	fp.mu.RUnlock()
+
+	if now := fp.clock.Now(); ok && now.Before(expireAt) {
+		return expireAt.Sub(now)
+	}
+
+	return 0
+}
+
+// Run syncs penalties from Redis every syncInterval
+func (fp *FeedbackPenalties) Run(syncInterval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(syncInterval)
+	for {
+		select {
+		case <-ticker.C:
+			if err := fp.Sync(); err != nil {
+				fp.logger.WithError(err).Warn("error syncing feedback penalties")
+			}
+		case <-stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// Sync removes expired penalties from Redis and replaces the local penalties with those remaining
+func (fp *FeedbackPenalties) Sync() error {
+	now := strconv.FormatInt(fp.clock.Now().Unix(), 10)
+	pipe := fp.redis.Pipeline()
+	pipe.ZRemRangeByScore(redisFeedbackPenaltiesKey, "-inf", now)
+	rangeCmd := pipe.ZRangeWithScores(redisFeedbackPenaltiesKey, 0, -1)
+	if _, err := pipe.Exec(); err != nil {
+		return errors.Wrap(err, "error fetching feedback penalties")
+	}
+
+	penalties := make(map[string]time.Time)
+	for _, z := range rangeCmd.Val() {
+		if remoteAddress, ok := z.Member.(string); ok {
+			penalties[remoteAddress] = time.Unix(int64(z.Score), 0)
+		}
+	}
+
+	fp.mu.Lock()
+	fp.penalties = penalties
+	fp.mu.Unlock()
+
+	return nil
+}
+
+// CondFeedbackFunc blocks requests from remote addresses penalized for abusive outcomes, stopping the chain, or rate
+// limits them with throttled if action is FeedbackThrottleAction, stopping the chain if they are blocked. Requests
+// from other remote addresses continue down the chain.
+func CondFeedbackFunc(penalties *FeedbackPenalties, action FeedbackAction, throttled RequestBlockerFunc, logger logrus.FieldLogger) CondRequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, bool, uint32, error) {
+		penalizedFor := penalties.penalizedFor(r.RemoteAddress)
+		if penalizedFor == 0 {
+			return false, false, RequestsRemainingMax, nil
+		}
+
+		tracef(c, "penalized for abusive feedback, action %v", action)
+		if action == FeedbackThrottleAction {
+			logger.Debugf("throttling request %v penalized for abusive feedback", r)
+			return throttle(c, r, throttled, FeedbackBlockReason)
+		}
+
+		logger.Debugf("blocking request %v penalized for abusive feedback", r)
+		hintBlockedFor(c, penalizedFor)
+		hintBlockReason(c, FeedbackBlockReason, "")
+		return true, true, 0, nil
+	}
+}
+
+// NewFeedbackHandler creates a new FeedbackHandler
+func NewFeedbackHandler(penalties *FeedbackPenalties, logger logrus.FieldLogger) *FeedbackHandler {
+	return &FeedbackHandler{penalties: penalties, logger: logger}
+}
+
+// FeedbackHandler is an admin HTTP handler accepting request outcomes reported by upstream services as JSON, such as
+// {"reports": [{"remote_address": "192.168.1.234", "path": "/login", "status": 401}]}
+type FeedbackHandler struct {
+	penalties *FeedbackPenalties
+	logger    logrus.FieldLogger
+}
+
+type feedbackRequest struct {
+	Reports []FeedbackReport `json:"reports"`
+}
+
+type feedbackResponse struct {
+	Penalized []string `json:"penalized"`
+}
+
+func (h *FeedbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method), h.logger)
+		return
+	}
+
+	body := feedbackRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error decoding request body: %v", err), h.logger)
+		return
+	}
+
+	if len(body.Reports) > maxFeedbackReports {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("%d reports exceeds max of %d", len(body.Reports), maxFeedbackReports), h.logger)
+		return
+	}
+
+	res := feedbackResponse{Penalized: []string{}}
+	for _, report := range body.Reports {
+		penalized, err := h.penalties.Report(r.Context(), report)
+		if err != nil {
+			h.logger.WithError(err).Errorf("error reporting feedback %v", report)
+			writeJSONError(w, http.StatusInternalServerError, err, h.logger)
+			return
+		}
+
+		if penalized {
+			res.Penalized = append(res.Penalized, report.RemoteAddress)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, res, h.logger)
+}
@@ -0,0 +1,165 @@
+package guardian
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestFeedbackPenalties(t *testing.T, clock Clock) (*FeedbackPenalties, *miniredis.Miniredis) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis")
+	}
+
+	policy := FeedbackPolicy{Statuses: map[int]bool{401: true}, Threshold: 3, Window: time.Minute, PenaltyDuration: 15 * time.Minute}
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	return NewFeedbackPenalties(client, &FakeLimitStore{count: make(map[string]uint64)}, clock, policy, TestingLogger), s
+}
+
+func TestFeedbackPenaltiesPenalizeRepeatedAbuse(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	fp, s := newTestFeedbackPenalties(t, clock)
+	defer s.Close()
+
+	reports := []struct {
+		report    FeedbackReport
+		penalized bool
+	}{
+		{report: FeedbackReport{RemoteAddress: "192.168.1.2", Path: "/login", Status: 401}, penalized: false},
+		{report: FeedbackReport{RemoteAddress: "192.168.1.2", Path: "/login", Status: 200}, penalized: false}, // not abusive
+		{report: FeedbackReport{RemoteAddress: "192.168.1.2", Path: "/login", Status: 401}, penalized: false},
+		{report: FeedbackReport{RemoteAddress: "192.168.1.2", Path: "/login", Status: 401}, penalized: true},
+	}
+
+	for i, test := range reports {
+		penalized, err := fp.Report(context.Background(), test.report)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if penalized != test.penalized {
+			t.Fatalf("report %d expected penalized: %v received: %v", i, test.penalized, penalized)
+		}
+	}
+
+	if !fp.Penalized("192.168.1.2") || fp.Penalized("192.168.1.3") {
+		t.Fatal("expected only 192.168.1.2 to be penalized")
+	}
+
+	// other instances learn of the penalty when they sync
+	other, _ := newTestFeedbackPenalties(t, clock)
+	other.redis = fp.redis
+	if err := other.Sync(); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if !other.Penalized("192.168.1.2") {
+		t.Error("expected penalty to be synced")
+	}
+
+	clock.now = clock.now.Add(16 * time.Minute)
+	if err := other.Sync(); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if other.Penalized("192.168.1.2") {
+		t.Error("expected penalty to expire")
+	}
+}
+
+func TestCondFeedbackFunc(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	fp, s := newTestFeedbackPenalties(t, clock)
+	defer s.Close()
+
+	fp.penalties["192.168.1.2"] = clock.now.Add(time.Minute)
+	throttled := func(context.Context, Request) (bool, uint32, error) { return false, 3, nil }
+
+	tests := []struct {
+		name      string
+		action    FeedbackAction
+		request   Request
+		stop      bool
+		blocked   bool
+		remaining uint32
+	}{
+		{name: "Block", action: FeedbackBlockAction, request: Request{RemoteAddress: "192.168.1.2"}, stop: true, blocked: true, remaining: 0},
+		{name: "Throttle", action: FeedbackThrottleAction, request: Request{RemoteAddress: "192.168.1.2"}, stop: false, blocked: false, remaining: 3},
+		{name: "NotPenalized", action: FeedbackBlockAction, request: Request{RemoteAddress: "192.168.1.3"}, stop: false, blocked: false, remaining: RequestsRemainingMax},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := CondFeedbackFunc(fp, test.action, throttled, TestingLogger)
+			stop, blocked, remaining, err := f(context.Background(), test.request)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if stop != test.stop || blocked != test.blocked || remaining != test.remaining {
+				t.Fatalf("expected: (%v, %v, %v) received: (%v, %v, %v)", test.stop, test.blocked, test.remaining, stop, blocked, remaining)
+			}
+		})
+	}
+}
+
+func TestFeedbackThrottledRequestsCountedOnce(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	fp, s := newTestFeedbackPenalties(t, clock)
+	defer s.Close()
+	fp.penalties["192.168.1.2"] = clock.now.Add(time.Minute)
+
+	fstore := &FakeLimitStore{limit: Limit{Count: 10, Duration: time.Minute, Enabled: true}, count: make(map[string]uint64)}
+	throttled := NewIPRateLimiter(ScaledLimitProvider{Provider: fstore, Factor: 0.5}, fstore, clock, nil, TestingLogger, NullReporter{})
+	rateLimiter := NewIPRateLimiter(fstore, fstore, clock, nil, TestingLogger, NullReporter{})
+
+	// the order of main: feedback penalties, then the global rate limiter, sharing a counter
+	chain := CondChain(
+		CondFeedbackFunc(fp, FeedbackThrottleAction, throttled.Limit, TestingLogger),
+		CondStopOnBlockOrError(rateLimiter.Limit),
+	)
+
+	for i := 1; i <= 6; i++ {
+		blocked, _, err := chain(context.Background(), Request{RemoteAddress: "192.168.1.2"})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if want := i > 5; blocked != want {
+			t.Fatalf("request %d: expected blocked %v, received %v", i, want, blocked)
+		}
+	}
+}
+
+func TestFeedbackHandler(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	fp, s := newTestFeedbackPenalties(t, clock)
+	defer s.Close()
+
+	report := FeedbackReport{RemoteAddress: "192.168.1.2", Path: "/login", Status: 401}
+	body, _ := json.Marshal(feedbackRequest{Reports: []FeedbackReport{report, report, report}})
+
+	rec := httptest.NewRecorder()
+	NewFeedbackHandler(fp, TestingLogger).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/feedback", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %v received: %v", http.StatusOK, rec.Code)
+	}
+
+	res := feedbackResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"192.168.1.2"}, res.Penalized); diff != "" {
+		t.Errorf("unexpected penalized (-want +got):\n%s", diff)
+	}
+}
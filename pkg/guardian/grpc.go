@@ -0,0 +1,87 @@
+package guardian
+
+import (
+	"context"
+	"strings"
+)
+
+// contentTypeHeader is the header, forwarded by Envoy as the header.content-type descriptor, that identifies gRPC
+// requests
+const contentTypeHeader = "content-type"
+
+const grpcContentType = "application/grpc"
+
+// GRPCMethod is the method a gRPC request calls
+type GRPCMethod struct {
+	// Service is the fully qualified service name, e.g. chat.v1.Chat
+	Service string
+	Method  string
+}
+
+// String returns the method's path, e.g. /chat.v1.Chat/Subscribe
+func (m GRPCMethod) String() string {
+	return "/" + m.Service + "/" + m.Method
+}
+
+// GRPCMethod returns the method called if the request is a gRPC request: one with a content type of
+// application/grpc (or application/grpc+proto etc.) to a path of the form /<service>/<method>
+func (r Request) GRPCMethod() (GRPCMethod, bool) {
+	if !strings.HasPrefix(r.Headers[contentTypeHeader], grpcContentType) {
+		return GRPCMethod{}, false
+	}
+
+	service, method, ok := strings.Cut(strings.TrimPrefix(r.Path, "/"), "/")
+	if !ok || len(service) == 0 || len(method) == 0 || strings.ContainsAny(method, "/?") {
+		return GRPCMethod{}, false
+	}
+
+	return GRPCMethod{Service: service, Method: method}, true
+}
+
+// GRPCStreamingMethods are the routes of gRPC methods that are streaming RPCs, e.g. /chat.v1.Chat/Subscribe or
+// /chat.v1.Chat/{method} for every method of a service
+type GRPCStreamingMethods []RoutePattern
+
+// ParseGRPCStreamingMethods parses the routes of streaming gRPC methods
+func ParseGRPCStreamingMethods(templates []string) (GRPCStreamingMethods, error) {
+	methods := GRPCStreamingMethods{}
+	for _, template := range templates {
+		route, err := ParseRoutePattern(template)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, route)
+	}
+
+	return methods, nil
+}
+
+// Match returns whether request is a gRPC request calling a streaming method
+func (m GRPCStreamingMethods) Match(request Request) bool {
+	if _, ok := request.GRPCMethod(); !ok {
+		return false
+	}
+
+	for _, route := range m {
+		if route.MatchRequest(request) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SkipGRPCStreams wraps f, allowing requests that open streams of streaming methods without calling f. Envoy asks
+// for a decision once per stream rather than per message, so a stream open isn't comparable to a unary request and
+// shouldn't consume the quota of limits sized for unary traffic, e.g. when every stream reconnects after a deploy.
+// Streams are still counted by route limits of their methods.
+func SkipGRPCStreams(f RequestBlockerFunc, streaming GRPCStreamingMethods) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		if streaming.Match(r) {
+			tracef(c, "skipping limit for stream of %v", r.Path)
+			return false, RequestsRemainingMax, nil
+		}
+
+		return f(c, r)
+	}
+}
@@ -0,0 +1,86 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func grpcRequest(path string) Request {
+	return Request{RemoteAddress: "192.168.1.2", Path: path, Headers: map[string]string{contentTypeHeader: "application/grpc+proto"}}
+}
+
+func TestRequestGRPCMethod(t *testing.T) {
+	tests := []struct {
+		name    string
+		request Request
+		want    GRPCMethod
+		ok      bool
+	}{
+		{name: "Unary", request: grpcRequest("/chat.v1.Chat/Send"), want: GRPCMethod{Service: "chat.v1.Chat", Method: "Send"}, ok: true},
+		{name: "NotGRPC", request: Request{Path: "/chat.v1.Chat/Send"}},
+		{name: "NoMethod", request: grpcRequest("/chat.v1.Chat")},
+		{name: "TooManySegments", request: grpcRequest("/chat.v1.Chat/Send/1")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := test.request.GRPCMethod()
+			if got != test.want || ok != test.ok {
+				t.Errorf("expected: %v %v received: %v %v", test.want, test.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestGRPCExpression(t *testing.T) {
+	e, err := ParseExpression(`req.grpc_service == "chat.v1.Chat" && req.grpc_method.startsWith("Send")`)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if !e.Eval(grpcRequest("/chat.v1.Chat/SendMessage")) {
+		t.Error("expected the gRPC method to match")
+	}
+
+	if e.Eval(Request{Path: "/chat.v1.Chat/SendMessage"}) {
+		t.Error("expected a request that isn't gRPC not to match")
+	}
+}
+
+func TestSkipGRPCStreams(t *testing.T) {
+	streaming, err := ParseGRPCStreamingMethods([]string{"/chat.v1.Chat/Subscribe", "/events.v1.Events/{method}"})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	fstore := &FakeLimitStore{limit: Limit{Count: 1, Duration: time.Minute, Enabled: true}, count: make(map[string]uint64)}
+	rl := NewIPRateLimiter(fstore, fstore, &fixedClock{time.Now()}, nil, TestingLogger, NullReporter{})
+	limit := SkipGRPCStreams(rl.Limit, streaming)
+
+	tests := []struct {
+		request Request
+		blocked bool
+	}{
+		{request: grpcRequest("/chat.v1.Chat/Subscribe"), blocked: false},
+		{request: grpcRequest("/events.v1.Events/Watch"), blocked: false},
+		{request: grpcRequest("/chat.v1.Chat/Send"), blocked: false},
+		{request: grpcRequest("/chat.v1.Chat/Subscribe"), blocked: false},
+		{request: grpcRequest("/chat.v1.Chat/Send"), blocked: true},
+	}
+
+	for i, test := range tests {
+		blocked, _, err := limit(context.Background(), test.request)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if blocked != test.blocked {
+			t.Errorf("request %d to %v expected blocked: %v received: %v", i, test.request.Path, test.blocked, blocked)
+		}
+	}
+
+	if _, err := ParseGRPCStreamingMethods([]string{"chat.v1.Chat/Subscribe"}); err == nil {
+		t.Error("expected an error for a method without a leading slash")
+	}
+}
@@ -23,16 +23,16 @@ func newAcceptanceGuardianServer(t *testing.T, logger logrus.FieldLogger) (*Serv
 
 	stop := make(chan struct{})
 	redis := redis.NewClient(&redis.Options{Addr: mr.Addr()})
-	redisConfStore := NewRedisConfStore(redis, []net.IPNet{}, []net.IPNet{}, Limit{Count: 15, Duration: time.Second}, false, logger.WithField("context", "redis-conf-provider"))
-	redisCounter := NewRedisCounter(redis, false, logger.WithField("context", "redis-counter"), NullReporter{})
+	redisConfStore := NewRedisConfStore(redis, []net.IPNet{}, []net.IPNet{}, Limit{Count: 15, Duration: time.Second}, false, nil, false, logger.WithField("context", "redis-conf-provider"), NullReporter{})
+	redisCounter := NewRedisCounter(redis, false, nil, RedisHedging{}, logger.WithField("context", "redis-counter"), NullReporter{})
 	go redisConfStore.RunSync(1*time.Second, stop)
 
-	whitelister := NewIPWhitelister(redisConfStore, logger.WithField("context", "ip-whitelister"), NullReporter{})
+	whitelister := NewIPWhitelister(redisConfStore, nil, 0, 0, logger.WithField("context", "ip-whitelister"), NullReporter{})
 	blacklister := NewIPBlacklister(redisConfStore, logger.WithField("context", "ip-blacklister"), NullReporter{})
-	rateLimiter := NewIPRateLimiter(redisConfStore, redisCounter, logger.WithField("context", "ip-rate-limiter"), NullReporter{})
+	rateLimiter := NewIPRateLimiter(redisConfStore, redisCounter, LocalClock{}, nil, logger.WithField("context", "ip-rate-limiter"), NullReporter{})
 
 	condFuncChain := DefaultCondChain(whitelister, blacklister, rateLimiter)
-	server := NewServer(condFuncChain, redisConfStore, logger.WithField("context", "server"), NullReporter{})
+	server := NewServer(condFuncChain, redisConfStore, "", 0, logger.WithField("context", "server"), NullReporter{})
 
 	return server, mr, redisConfStore, stop
 }
@@ -0,0 +1,73 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/pkg/errors"
+)
+
+// PrivateInternalNetworks names the RFC 1918 and RFC 4193 private ranges, which may be given to
+// ParseInternalNetworks in place of listing them
+const PrivateInternalNetworks = "private"
+
+var privateNetworks = []netip.Prefix{
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("fc00::/7"),
+}
+
+// InternalNetworks are the networks of trusted internal clients, such as other services of the cluster. Unlike the
+// whitelist, they don't skip limits on their own; rules match them with req.internal, so internal clients can be
+// exempted from a rule, or a rule limited to external clients, with the intent kept in the rule.
+type InternalNetworks struct {
+	prefixes []netip.Prefix
+}
+
+// ParseInternalNetworks parses the CIDRs of internal networks, or PrivateInternalNetworks for the private ranges
+func ParseInternalNetworks(values []string) (*InternalNetworks, error) {
+	n := &InternalNetworks{}
+	for _, value := range values {
+		if value == PrivateInternalNetworks {
+			n.prefixes = append(n.prefixes, privateNetworks...)
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error parsing internal network %q", value))
+		}
+		n.prefixes = append(n.prefixes, prefix.Masked())
+	}
+
+	return n, nil
+}
+
+// Contains returns whether remoteAddress, with or without a port, is in one of the internal networks
+func (n *InternalNetworks) Contains(remoteAddress string) bool {
+	addr, ok := parseRemoteAddr(remoteAddress)
+	if !ok {
+		return false
+	}
+
+	for _, prefix := range n.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithInternalNetworks wraps f, setting the Internal field of the request from whether its remote address is in
+// networks, so rules can match it with req.internal
+func WithInternalNetworks(f CondRequestBlockerFunc, networks *InternalNetworks) CondRequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, bool, uint32, error) {
+		r.Internal = networks.Contains(r.RemoteAddress)
+		tracef(c, "internal: %v", r.Internal)
+
+		return f(c, r)
+	}
+}
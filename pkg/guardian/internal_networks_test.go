@@ -0,0 +1,82 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInternalNetworksContains(t *testing.T) {
+	networks, err := ParseInternalNetworks([]string{PrivateInternalNetworks, "100.64.0.0/10", "2001:db8::1/32"})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	tests := []struct {
+		remoteAddress string
+		want          bool
+	}{
+		{remoteAddress: "10.1.2.3", want: true},
+		{remoteAddress: "172.20.0.1:5000", want: true},
+		{remoteAddress: "192.168.1.2", want: true},
+		{remoteAddress: "::ffff:192.168.1.2", want: true},
+		{remoteAddress: "[fd00::1]:443", want: true},
+		{remoteAddress: "100.64.1.1", want: true},
+		{remoteAddress: "2001:db8:ffff::1", want: true},
+		{remoteAddress: "172.32.0.1", want: false},
+		{remoteAddress: "8.8.8.8", want: false},
+		{remoteAddress: "2001:db9::1", want: false},
+		{remoteAddress: "not-an-address", want: false},
+		{remoteAddress: "", want: false},
+	}
+
+	for _, test := range tests {
+		if got := networks.Contains(test.remoteAddress); got != test.want {
+			t.Errorf("%q expected internal: %v received: %v", test.remoteAddress, test.want, got)
+		}
+	}
+}
+
+func TestParseInternalNetworksInvalid(t *testing.T) {
+	for _, value := range []string{"10.0.0.1", "10.0.0.0/33", "internal"} {
+		if _, err := ParseInternalNetworks([]string{value}); err == nil {
+			t.Errorf("%q expected error", value)
+		}
+	}
+}
+
+func TestWithInternalNetworks(t *testing.T) {
+	networks, err := ParseInternalNetworks([]string{PrivateInternalNetworks})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	rules := []Rule{
+		mustParseRule(t, "external", RuleDocument{When: `!req.internal`, Action: "limit", Limit: &LimitDocument{Count: 1, Duration: "1m", Enabled: true}}),
+		mustParseRule(t, "internal", RuleDocument{When: `req.internal`, Action: "limit", Limit: &LimitDocument{Count: 3, Duration: "1m", Enabled: true}}),
+	}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, &FakeLimitStore{count: make(map[string]uint64)}, LocalClock{}, nil, nil, TestingLogger, NullReporter{})
+	evaluate := WithInternalNetworks(re.Evaluate, networks)
+
+	tests := []struct {
+		remoteAddress string
+		blocked       bool
+	}{
+		{remoteAddress: "8.8.8.8", blocked: false},
+		{remoteAddress: "8.8.8.8", blocked: true},
+		{remoteAddress: "10.0.0.1", blocked: false},
+		{remoteAddress: "10.0.0.1", blocked: false},
+		{remoteAddress: "10.0.0.1", blocked: false},
+		{remoteAddress: "10.0.0.1", blocked: true},
+	}
+
+	for i, test := range tests {
+		_, blocked, _, err := evaluate(context.Background(), Request{RemoteAddress: test.remoteAddress, Path: "/"})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if blocked != test.blocked {
+			t.Errorf("request %d from %v expected blocked: %v received: %v", i, test.remoteAddress, test.blocked, blocked)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package guardian
+
+import (
+	"fmt"
+	"net"
+)
+
+// IPList names the list an IPListMatch is an entry of
+type IPList string
+
+// The lists an IP can be checked against
+const (
+	WhitelistIPList IPList = "whitelist"
+	BlacklistIPList IPList = "blacklist"
+)
+
+// IPListMatch is an entry of the whitelist or blacklist containing an IP
+type IPListMatch struct {
+	List IPList
+	CIDR net.IPNet
+}
+
+// FindIPListMatches returns the entries of whitelist and blacklist containing remoteAddress, in the order of
+// whitelist and then blacklist. The request is allowed if any whitelist entry contains it, since the whitelist is
+// checked before the blacklist.
+func FindIPListMatches(remoteAddress string, whitelist []net.IPNet, blacklist []net.IPNet) ([]IPListMatch, error) {
+	ip, ok := parseRemoteAddr(remoteAddress)
+	if !ok {
+		return nil, fmt.Errorf("invalid ip %q", remoteAddress)
+	}
+
+	matches := []IPListMatch{}
+	for _, list := range []struct {
+		name  IPList
+		cidrs []net.IPNet
+	}{{WhitelistIPList, whitelist}, {BlacklistIPList, blacklist}} {
+		for _, cidr := range list.cidrs {
+			if prefix, ok := PrefixFromIPNet(cidr); ok && prefix.Contains(ip) {
+				matches = append(matches, IPListMatch{List: list.name, CIDR: cidr})
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// FetchIPListMatches fetches the whitelist and blacklist stored in Redis, which instances apply once they sync, and
+// returns the entries containing remoteAddress
+func (rs *RedisConfStore) FetchIPListMatches(remoteAddress string) ([]IPListMatch, error) {
+	c := rs.pipelinedFetchConf()
+	if c.whitelist == nil || c.blacklist == nil {
+		return nil, fmt.Errorf("error fetching whitelist and blacklist")
+	}
+
+	return FindIPListMatches(remoteAddress, c.whitelist, c.blacklist)
+}
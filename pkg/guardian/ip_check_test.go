@@ -0,0 +1,64 @@
+package guardian
+
+import (
+	"testing"
+)
+
+func TestFindIPListMatches(t *testing.T) {
+	whitelist := parseCIDRs([]string{"10.0.0.0/8", "192.168.1.0/24"})
+	blacklist := parseCIDRs([]string{"10.1.0.0/16", "172.16.0.0/12"})
+
+	tests := []struct {
+		ip   string
+		want []string
+	}{
+		{ip: "10.1.2.3", want: []string{"whitelist 10.0.0.0/8", "blacklist 10.1.0.0/16"}},
+		{ip: "::ffff:192.168.1.7", want: []string{"whitelist 192.168.1.0/24"}},
+		{ip: "172.16.5.4:8080", want: []string{"blacklist 172.16.0.0/12"}},
+		{ip: "8.8.8.8", want: []string{}},
+	}
+
+	for _, test := range tests {
+		matches, err := FindIPListMatches(test.ip, whitelist, blacklist)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		got := []string{}
+		for _, m := range matches {
+			got = append(got, string(m.List)+" "+m.CIDR.String())
+		}
+
+		if len(got) != len(test.want) {
+			t.Fatalf("%v expected: %v received: %v", test.ip, test.want, got)
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%v expected: %v received: %v", test.ip, test.want, got)
+			}
+		}
+	}
+
+	if _, err := FindIPListMatches("not-an-ip", whitelist, blacklist); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+}
+
+func TestConfStoreFetchIPListMatches(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	if err := c.AddBlacklistCidrs(parseCIDRs([]string{"10.1.2.3/32"})); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	// entries not yet synced to the conf store's cache are matched
+	matches, err := c.FetchIPListMatches("10.1.2.3")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(matches) != 1 || matches[0].List != BlacklistIPList || matches[0].CIDR.String() != "10.1.2.3/32" {
+		t.Fatalf("expected a blacklist match of 10.1.2.3/32, received: %v", matches)
+	}
+}
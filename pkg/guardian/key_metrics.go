@@ -0,0 +1,167 @@
+package guardian
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OtherKeyName names the decisions of the keys outside the top keys, reported together for each rule
+const OtherKeyName = "other"
+
+// maxKeyMetricsKeys bounds the rule and key pairs counted between flushes, so an attack from many addresses can't grow
+// the aggregate without bound. It is split evenly among the shards pairs are counted in, and decisions of further pairs
+// are counted as OtherKeyName.
+const maxKeyMetricsKeys = 100000
+
+// NewKeyMetrics creates a new KeyMetrics reporting the top keys of each flush to reporter
+func NewKeyMetrics(top int, reporter MetricReporter) *KeyMetrics {
+	m := &KeyMetrics{top: top, reporter: reporter}
+	for i := range m.shards {
+		m.shards[i].pending = make(map[keyMetricsPair]*keyDecisions)
+	}
+
+	return m
+}
+
+// KeyMetrics aggregates decisions per rule and key in process, so per key metrics can be reported without a series
+// for every remote address. Each flush reports the top rule and key pairs by decisions, and rolls the decisions of
+// the rest into OtherKeyName for each rule, capping the cardinality of the key tag. Decisions are counted in the shard
+// of their key.
+type KeyMetrics struct {
+	top      int
+	reporter MetricReporter
+	shards   [recordShards]keyMetricsShard
+}
+
+type keyMetricsShard struct {
+	mu      sync.Mutex
+	pending map[keyMetricsPair]*keyDecisions
+}
+
+type keyMetricsPair struct {
+	rule string
+	key  string
+}
+
+type keyDecisions struct {
+	allowed uint64
+	blocked uint64
+}
+
+func (kd keyDecisions) total() uint64 {
+	return kd.allowed + kd.blocked
+}
+
+// AggregateKeyMetrics wraps f, recording each request it decides to m along with the rule that decided it
+func AggregateKeyMetrics(f RequestBlockerFunc, m *KeyMetrics) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		blocked, remaining, err := f(c, r)
+		m.Record(r, DecisionHintFromContext(c).MatchedRule(), blocked)
+		return blocked, remaining, err
+	}
+}
+
+// Record counts a decision of the request's remote address, attributed to rule unless it is nil
+func (m *KeyMetrics) Record(r Request, rule *Rule, blocked bool) {
+	pair := keyMetricsPair{rule: NoRuleName, key: r.RemoteAddress}
+	if rule != nil {
+		pair.rule = rule.Name
+	}
+
+	shard := &m.shards[recordShard(pair.key)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	decisions, ok := shard.pending[pair]
+	if !ok && len(shard.pending) >= maxKeyMetricsKeys/recordShards {
+		pair.key = OtherKeyName
+		decisions, ok = shard.pending[pair]
+	}
+	if !ok {
+		decisions = &keyDecisions{}
+		shard.pending[pair] = decisions
+	}
+
+	if blocked {
+		decisions.blocked++
+	} else {
+		decisions.allowed++
+	}
+}
+
+// Run flushes the aggregated decisions every interval until stop is closed, flushing once more before returning
+func (m *KeyMetrics) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.Flush()
+		case <-stop:
+			m.Flush()
+			return
+		}
+	}
+}
+
+// Flush reports the decisions aggregated since the last flush: those of the top pairs by decisions individually, and
+// those of the rest as OtherKeyName of their rule
+func (m *KeyMetrics) Flush() {
+	pending := map[keyMetricsPair]*keyDecisions{}
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.mu.Lock()
+		shardPending := shard.pending
+		shard.pending = make(map[keyMetricsPair]*keyDecisions)
+		shard.mu.Unlock()
+
+		// Only the OtherKeyName pairs of a rule can be in more than one shard
+		for pair, decisions := range shardPending {
+			if merged, ok := pending[pair]; ok {
+				merged.allowed += decisions.allowed
+				merged.blocked += decisions.blocked
+				continue
+			}
+			pending[pair] = decisions
+		}
+	}
+
+	pairs := make([]keyMetricsPair, 0, len(pending))
+	for pair := range pending {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		ti, tj := pending[pairs[i]].total(), pending[pairs[j]].total()
+		if ti != tj {
+			return ti > tj
+		}
+		if pairs[i].rule != pairs[j].rule {
+			return pairs[i].rule < pairs[j].rule
+		}
+		return pairs[i].key < pairs[j].key
+	})
+
+	others := map[string]*keyDecisions{}
+	for i, pair := range pairs {
+		decisions := pending[pair]
+		if i < m.top && pair.key != OtherKeyName {
+			m.reporter.KeyDecisions(pair.rule, pair.key, decisions.allowed, decisions.blocked)
+			continue
+		}
+
+		other, ok := others[pair.rule]
+		if !ok {
+			other = &keyDecisions{}
+			others[pair.rule] = other
+		}
+		other.allowed += decisions.allowed
+		other.blocked += decisions.blocked
+	}
+
+	for rule, decisions := range others {
+		m.reporter.KeyDecisions(rule, OtherKeyName, decisions.allowed, decisions.blocked)
+	}
+}
@@ -0,0 +1,72 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type FakeKeyDecisionsReporter struct {
+	NullReporter
+	decisions map[string]keyDecisions
+}
+
+func (f *FakeKeyDecisionsReporter) KeyDecisions(rule string, key string, allowed uint64, blocked uint64) {
+	f.decisions[rule+" "+key] = keyDecisions{allowed: allowed, blocked: blocked}
+}
+
+func TestKeyMetricsFlushesTopKeys(t *testing.T) {
+	reporter := &FakeKeyDecisionsReporter{decisions: map[string]keyDecisions{}}
+	m := NewKeyMetrics(2, reporter)
+	rule := &Rule{Name: "api"}
+
+	for i := 0; i < 5; i++ {
+		m.Record(Request{RemoteAddress: "192.168.1.2"}, rule, i%2 == 0)
+	}
+	for i := 0; i < 3; i++ {
+		m.Record(Request{RemoteAddress: "192.168.1.3"}, nil, false)
+	}
+	for i := 0; i < 10; i++ {
+		m.Record(Request{RemoteAddress: fmt.Sprintf("10.0.0.%d", i)}, rule, true)
+	}
+	m.Record(Request{RemoteAddress: "10.0.0.1"}, nil, false)
+
+	m.Flush()
+	expected := map[string]keyDecisions{
+		"api 192.168.1.2":           {allowed: 2, blocked: 3},
+		NoRuleName + " 192.168.1.3": {allowed: 3},
+		"api other":                 {blocked: 10},
+		NoRuleName + " other":       {allowed: 1},
+	}
+
+	if diff := cmp.Diff(expected, reporter.decisions, cmp.AllowUnexported(keyDecisions{})); diff != "" {
+		t.Errorf("unexpected key decisions (-want +got):\n%s", diff)
+	}
+
+	reporter.decisions = map[string]keyDecisions{}
+	m.Flush()
+	if len(reporter.decisions) != 0 {
+		t.Errorf("expected no key decisions after flushing, received: %v", reporter.decisions)
+	}
+}
+
+func TestAggregateKeyMetrics(t *testing.T) {
+	reporter := &FakeKeyDecisionsReporter{decisions: map[string]keyDecisions{}}
+	m := NewKeyMetrics(10, reporter)
+	rule := Rule{Name: "api"}
+	f := AggregateKeyMetrics(func(c context.Context, r Request) (bool, uint32, error) {
+		DecisionHintFromContext(c).MatchRule(rule, true)
+		return true, 0, nil
+	}, m)
+
+	if _, _, err := f(WithDecisionHint(context.Background(), NewDecisionHint()), Request{RemoteAddress: "192.168.1.2"}); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	m.Flush()
+	if got := reporter.decisions["api 192.168.1.2"]; got.blocked != 1 {
+		t.Errorf("expected the blocked decision to be attributed to the rule, received: %v", reporter.decisions)
+	}
+}
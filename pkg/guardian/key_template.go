@@ -0,0 +1,138 @@
+package guardian
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxKeyTemplateLength bounds the length of a key template
+const maxKeyTemplateLength = 200
+
+const (
+	ipPlaceholder        = "ip"
+	keyPlaceholder       = "key"
+	routePlaceholder     = "route"
+	operationPlaceholder = "operation"
+	windowPlaceholder    = "window"
+)
+
+// keyTemplateEscaper escapes the characters placeholder values can't contain, so a value can't forge the separators
+// or placeholders of the template it's substituted into
+var keyTemplateEscaper = strings.NewReplacer("%", "%25", ":", "%3A", "{", "%7B", "}", "%7D")
+
+// KeyTemplate composes the key a rule counts requests by, e.g. "{ip}:{route}:{window}". Placeholders are {ip}, the
+// remote address, {key}, the value of the rule's LimitKey, {route}, the route limit matching the request or its path
+// if none does, {operation}, the OpenAPI operation the request matches or empty if none does, {window}, the start of
+// the fixed window, and any request attribute named as in ValidateRequestAttribute. Keys are suffixed with the window
+// unless the template places it.
+type KeyTemplate struct {
+	template string
+	// parts are literal text, and placeholder names at odd indexes
+	parts    []string
+	windowed bool
+	routed   bool
+}
+
+// ParseKeyTemplate parses a KeyTemplate, returning an error if it has an unknown placeholder or unbalanced braces
+func ParseKeyTemplate(template string) (*KeyTemplate, error) {
+	if len(template) == 0 {
+		return nil, fmt.Errorf("key template must not be empty")
+	}
+
+	if len(template) > maxKeyTemplateLength {
+		return nil, fmt.Errorf("key template of %d characters exceeds max of %d", len(template), maxKeyTemplateLength)
+	}
+
+	kt := &KeyTemplate{template: template}
+	rest := template
+	for len(rest) > 0 {
+		open := strings.IndexByte(rest, '{')
+		if close := strings.IndexByte(rest, '}'); close >= 0 && (open < 0 || close < open) {
+			return nil, fmt.Errorf("key template %q has an unopened }", template)
+		}
+
+		if open < 0 {
+			kt.parts = append(kt.parts, rest)
+			break
+		}
+
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("key template %q has an unclosed {", template)
+		}
+
+		name := rest[open+1 : open+end]
+		if err := validatePlaceholder(name); err != nil {
+			return nil, fmt.Errorf("invalid placeholder in key template %q: %v", template, err)
+		}
+
+		kt.windowed = kt.windowed || name == windowPlaceholder
+		kt.routed = kt.routed || name == routePlaceholder
+		kt.parts = append(kt.parts, rest[:open], name)
+		rest = rest[open+end+1:]
+	}
+
+	return kt, nil
+}
+
+func validatePlaceholder(name string) error {
+	switch name {
+	case ipPlaceholder, keyPlaceholder, routePlaceholder, operationPlaceholder, windowPlaceholder:
+		return nil
+	}
+
+	return ValidateRequestAttribute(name)
+}
+
+func (kt *KeyTemplate) String() string {
+	return kt.template
+}
+
+// Windowed returns whether the template places the window in the key
+func (kt *KeyTemplate) Windowed() bool {
+	return kt.windowed
+}
+
+// Routed returns whether the template places the route in the key
+func (kt *KeyTemplate) Routed() bool {
+	return kt.routed
+}
+
+// keyTemplateValues are the values substituted for the placeholders of a KeyTemplate that aren't request attributes
+type keyTemplateValues struct {
+	key    string
+	route  string
+	window int64
+}
+
+// key returns the key of request, escaping the values substituted for placeholders. The window is left out unless
+// the template is Windowed.
+func (kt *KeyTemplate) key(request Request, values keyTemplateValues) string {
+	b := strings.Builder{}
+	for i, part := range kt.parts {
+		if i%2 == 0 {
+			b.WriteString(part)
+			continue
+		}
+
+		value := ""
+		switch part {
+		case ipPlaceholder:
+			value = request.RemoteAddress
+		case keyPlaceholder:
+			value = values.key
+		case routePlaceholder:
+			value = values.route
+		case operationPlaceholder:
+			value = request.Operation // empty if unmatched, rather than the path, so unmatched requests share a key
+		case windowPlaceholder:
+			value = strconv.FormatInt(values.window, 10)
+		default:
+			value = request.Attribute(part)
+		}
+		b.WriteString(keyTemplateEscaper.Replace(value))
+	}
+
+	return b.String()
+}
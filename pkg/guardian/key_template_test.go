@@ -0,0 +1,131 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseKeyTemplateRejectsInvalid(t *testing.T) {
+	for _, template := range []string{"", "{ip", "ip}", "{ip}:{", "{}", "{user}", "{header.}", "{ip}}"} {
+		if _, err := ParseKeyTemplate(template); err == nil {
+			t.Errorf("expected an error for template %q", template)
+		}
+	}
+}
+
+func TestKeyTemplateEscapesValues(t *testing.T) {
+	kt, err := ParseKeyTemplate("{ip}:{header.x-tenant}:{window}")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if !kt.Windowed() {
+		t.Error("expected the template to place the window")
+	}
+
+	// a tenant containing separators and placeholders can't forge another tenant's key
+	req := Request{RemoteAddress: "::1", Headers: map[string]string{"x-tenant": "acme:{window}%"}}
+	expected := "%3A%3A1:acme%3A%7Bwindow%7D%25:60"
+	if key := kt.key(req, keyTemplateValues{window: 60}); key != expected {
+		t.Fatalf("expected: %v received: %v", expected, key)
+	}
+}
+
+type fakeRuleAndRouteProvider struct {
+	FakeRuleProvider
+	FakeRouteLimitProvider
+}
+
+func TestRuleEvaluatorKeyTemplate(t *testing.T) {
+	rule := mustParseRule(t, "per-route", RuleDocument{
+		When:        `true`,
+		Action:      "limit",
+		Limit:       &LimitDocument{Count: 1, Duration: "1m", Enabled: true},
+		KeyTemplate: "{window}/{route}/{ip}",
+	})
+	conf := &fakeRuleAndRouteProvider{
+		FakeRuleProvider:       FakeRuleProvider{rules: []Rule{rule}},
+		FakeRouteLimitProvider: FakeRouteLimitProvider{routeLimits: []RouteLimit{{Route: mustParseRoutePattern(t, "/users/{id}")}}},
+	}
+	now := time.Unix(1522895021, 0)
+	store := &FakeLimitStore{count: make(map[string]uint64)}
+	re := NewRuleEvaluator(conf, store, &fixedClock{now}, nil, nil, TestingLogger, NullReporter{})
+
+	// requests for different users share the route's count
+	for i, path := range []string{"/users/1", "/users/2"} {
+		_, blocked, _, err := re.Evaluate(context.Background(), Request{RemoteAddress: "192.168.1.2", Path: path})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if blocked != (i == 1) {
+			t.Fatalf("request for %v expected blocked: %v received: %v", path, i == 1, blocked)
+		}
+	}
+
+	expected := NamespacedKey(ruleNamespace, "per-route") + ":1522894980//users/%7Bid%7D/192.168.1.2"
+	if store.count[expected] != 2 {
+		t.Fatalf("expected requests to be counted under %v, received: %v", expected, store.count)
+	}
+}
+
+type countingRuleAndRouteProvider struct {
+	fakeRuleAndRouteProvider
+	matchers int
+}
+
+func (c *countingRuleAndRouteProvider) GetRouteMatcher() *RouteMatcher {
+	c.matchers++
+	return c.fakeRuleAndRouteProvider.GetRouteMatcher()
+}
+
+func TestRuleEvaluatorKeyTemplateMatchesRouteOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		matchers int
+	}{
+		{name: "Route", template: "{route}/{ip}", matchers: 1},
+		{name: "WindowedRoute", template: "{window}/{route}/{ip}", matchers: 1},
+		{name: "NoRoute", template: "{ip}:{header.x-client-id}", matchers: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule := mustParseRule(t, "templated", RuleDocument{
+				When:        `true`,
+				Action:      "limit",
+				Limit:       &LimitDocument{Count: 10, Duration: "1m", Enabled: true},
+				KeyTemplate: test.template,
+			})
+			conf := &countingRuleAndRouteProvider{fakeRuleAndRouteProvider: fakeRuleAndRouteProvider{
+				FakeRuleProvider:       FakeRuleProvider{rules: []Rule{rule}},
+				FakeRouteLimitProvider: FakeRouteLimitProvider{routeLimits: []RouteLimit{{Route: mustParseRoutePattern(t, "/users/{id}")}}},
+			}}
+			clock := &fixedClock{time.Unix(1522895021, 0)}
+			guard := NewKeyCardinalityGuard(100, time.Minute, clock, TestingLogger, NullReporter{})
+			re := NewRuleEvaluator(conf, &FakeLimitStore{count: make(map[string]uint64)}, clock, guard, nil, TestingLogger, NullReporter{})
+
+			if _, _, _, err := re.Evaluate(context.Background(), Request{RemoteAddress: "192.168.1.2", Path: "/users/1"}); err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if conf.matchers != test.matchers {
+				t.Fatalf("expected the route to be matched %d times, received: %d", test.matchers, conf.matchers)
+			}
+		})
+	}
+}
+
+func TestRuleDocumentRejectsWindowedKeyTemplateWithoutFixedWindows(t *testing.T) {
+	doc := RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Duration: "1m", Algorithm: string(LeakyBucketAlgorithm)}, KeyTemplate: "{ip}:{window}"}
+	if _, err := doc.Rule("leaky"); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+
+	doc.KeyTemplate = "{ip}"
+	if _, err := doc.Rule("leaky"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package guardian
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const leaderNamespace = "leader"
+
+// LeaderProvider reports whether this instance is the leader, the one instance of those sharing a Redis that performs
+// tasks which only need to be performed once, like scanning Redis for counter keys
+type LeaderProvider interface {
+	IsLeader() bool
+}
+
+// isLeader returns whether lp is the leader. Every instance is the leader when there is no election.
+func isLeader(lp LeaderProvider) bool {
+	return lp == nil || lp.IsLeader()
+}
+
+// renewLeaseScript extends the lease only if it's still held by the instance renewing it
+var renewLeaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLeaseScript deletes the lease only if it's still held by the instance releasing it
+var releaseLeaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// NewRedisLeaderElection creates a new RedisLeaderElection. id must be unique to this instance, and leaseTTL is how
+// long the leader holds the lease without renewing it.
+func NewRedisLeaderElection(redis *redis.Client, id string, leaseTTL time.Duration, logger logrus.FieldLogger, reporter MetricReporter) *RedisLeaderElection {
+	return &RedisLeaderElection{
+		redis:    redis,
+		key:      NamespacedKey(leaderNamespace, "tasks"),
+		id:       id,
+		leaseTTL: leaseTTL,
+		logger:   logger,
+		reporter: reporter,
+	}
+}
+
+// RedisLeaderElection elects a leader among the instances sharing a Redis by holding a lease on a key. Every instance
+// keeps serving decisions; only tasks that need to be performed once are left to the leader.
+type RedisLeaderElection struct {
+	redis    *redis.Client
+	key      string
+	id       string
+	leaseTTL time.Duration
+	logger   logrus.FieldLogger
+	reporter MetricReporter
+
+	leader int32
+}
+
+func (le *RedisLeaderElection) IsLeader() bool {
+	return atomic.LoadInt32(&le.leader) == 1
+}
+
+// Run campaigns for the lease now and every third of the lease TTL until stop is closed, renewing it while it's held.
+// The lease is released on stop so another instance takes over without waiting for it to expire.
+func (le *RedisLeaderElection) Run(stop <-chan struct{}) {
+	le.Campaign()
+
+	ticker := time.NewTicker(le.leaseTTL / 3)
+	for {
+		select {
+		case <-ticker.C:
+			le.Campaign()
+		case <-stop:
+			ticker.Stop()
+			le.Release()
+			return
+		}
+	}
+}
+
+// Campaign renews the lease if this instance holds it, or acquires it if it's free. The instance steps down if Redis
+// can't be reached, since its lease may expire and be acquired by another instance.
+func (le *RedisLeaderElection) Campaign() error {
+	leader, err := le.campaign()
+	if err != nil {
+		err = errors.Wrap(err, "error campaigning for leader")
+		le.logger.WithError(err).Error("error campaigning for leader, stepping down")
+	}
+
+	le.setLeader(leader)
+	return err
+}
+
+func (le *RedisLeaderElection) campaign() (bool, error) {
+	if le.IsLeader() {
+		res, err := renewLeaseScript.Run(le.redis, []string{le.key}, le.id, int64(le.leaseTTL/time.Millisecond)).Result()
+		if err != nil {
+			return false, err
+		}
+
+		if renewed, _ := res.(int64); renewed == 1 {
+			return true, nil
+		}
+	}
+
+	return le.redis.SetNX(le.key, le.id, le.leaseTTL).Result()
+}
+
+// Release steps down, releasing the lease if this instance holds it
+func (le *RedisLeaderElection) Release() error {
+	if !le.IsLeader() {
+		return nil
+	}
+
+	le.setLeader(false)
+	if err := releaseLeaseScript.Run(le.redis, []string{le.key}, le.id).Err(); err != nil {
+		err = errors.Wrap(err, "error releasing leader lease")
+		le.logger.WithError(err).Error("error releasing leader lease, it will expire instead")
+		return err
+	}
+
+	return nil
+}
+
+func (le *RedisLeaderElection) setLeader(leader bool) {
+	value := int32(0)
+	if leader {
+		value = 1
+	}
+
+	if previous := atomic.SwapInt32(&le.leader, value); previous != value {
+		if leader {
+			le.logger.Infof("elected leader as %v", le.id)
+		} else {
+			le.logger.Infof("stepped down as leader as %v", le.id)
+		}
+	}
+
+	le.reporter.CurrentLeader(leader)
+}
@@ -0,0 +1,79 @@
+package guardian
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+)
+
+func TestRedisLeaderElection(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis")
+	}
+	defer s.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	a := NewRedisLeaderElection(client, "a", 15*time.Second, TestingLogger, NullReporter{})
+	b := NewRedisLeaderElection(client, "b", 15*time.Second, TestingLogger, NullReporter{})
+
+	for _, le := range []*RedisLeaderElection{a, b, a, b} {
+		if err := le.Campaign(); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+	}
+
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("expected only a to be leader, a: %v b: %v", a.IsLeader(), b.IsLeader())
+	}
+
+	// a renews its lease, so it outlasts the original ttl
+	s.FastForward(10 * time.Second)
+	a.Campaign()
+	s.FastForward(10 * time.Second)
+	b.Campaign()
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("expected a to remain leader after renewing, a: %v b: %v", a.IsLeader(), b.IsLeader())
+	}
+
+	// b takes over once a releases the lease
+	if err := a.Release(); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	b.Campaign()
+	a.Campaign()
+	if a.IsLeader() || !b.IsLeader() {
+		t.Fatalf("expected b to take over, a: %v b: %v", a.IsLeader(), b.IsLeader())
+	}
+
+	// a leader whose lease expired and was taken steps down rather than renewing it
+	s.FastForward(15 * time.Second)
+	a.Campaign()
+	b.Campaign()
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("expected a to take over the expired lease, a: %v b: %v", a.IsLeader(), b.IsLeader())
+	}
+}
+
+func TestRedisLeaderElectionStepsDownOnError(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis")
+	}
+
+	le := NewRedisLeaderElection(redis.NewClient(&redis.Options{Addr: s.Addr()}), "a", 15*time.Second, TestingLogger, NullReporter{})
+	if err := le.Campaign(); err != nil || !le.IsLeader() {
+		t.Fatalf("expected to be elected, got error: %v", err)
+	}
+
+	s.Close()
+	if err := le.Campaign(); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+
+	if le.IsLeader() {
+		t.Fatal("expected to step down when redis can't be reached")
+	}
+}
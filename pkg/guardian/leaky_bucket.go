@@ -0,0 +1,65 @@
+package guardian
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const leakyBucketNamespace = "leaky_bucket"
+
+// LeakyBucketCounter is a Counter that is also capable of enforcing the leaky bucket algorithm
+type LeakyBucketCounter interface {
+	Counter
+
+	// Fill drains the bucket for key at a rate of capacity per drainDuration as of now and then adds amount to it if
+	// doing so wouldn't overflow capacity. The level of the bucket and whether amount was added are returned.
+	Fill(context context.Context, key string, amount uint, capacity uint64, drainDuration time.Duration, now time.Time) (uint64, bool, error)
+}
+
+// leakyBucketScript atomically drains and fills a bucket stored as a hash of its level and the time it was last
+// drained in milliseconds
+// KEYS[1] bucket key
+// ARGV[1] capacity, ARGV[2] milliseconds to drain one unit, ARGV[3] now in milliseconds, ARGV[4] amount to add,
+// ARGV[5] expiration in milliseconds
+var leakyBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local drain_ms = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local amount = tonumber(ARGV[4])
+
+local state = redis.call("HMGET", KEYS[1], "level", "ts")
+local level = tonumber(state[1]) or 0
+local ts = tonumber(state[2]) or now
+
+if now > ts then
+	level = math.max(0, level - (now - ts) / drain_ms)
+else
+	now = ts
+end
+
+local allowed = 0
+if level + amount <= capacity then
+	level = level + amount
+	allowed = 1
+end
+
+redis.call("HMSET", KEYS[1], "level", tostring(level), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+
+return {math.ceil(level), allowed}
+`)
+
+func (rs *RedisCounter) Fill(context context.Context, key string, amount uint, capacity uint64, drainDuration time.Duration, now time.Time) (uint64, bool, error) {
+	if capacity == 0 {
+		return 0, false, nil
+	}
+
+	drainMs := float64(drainDuration/time.Millisecond) / float64(capacity)
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	expireMs := int64(drainDuration / time.Millisecond)
+
+	level, allowed, err := rs.runPairScript("leaky bucket", leakyBucketScript, []string{key}, capacity, drainMs, nowMs, amount, expireMs)
+	return level, allowed == 1, err
+}
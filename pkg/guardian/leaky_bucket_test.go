@@ -0,0 +1,117 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRedisCounterFillDrainsAtFixedRate(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	key := "bucket"
+	capacity := uint64(2)
+	drain := time.Second // drains 2 per second
+	now := time.Unix(1522969710, 0)
+
+	tests := []struct {
+		name        string
+		at          time.Time
+		amount      uint
+		wantLevel   uint64
+		wantAllowed bool
+	}{
+		{name: "First", at: now, amount: 1, wantLevel: 1, wantAllowed: true},
+		{name: "Second", at: now, amount: 1, wantLevel: 2, wantAllowed: true},
+		{name: "Overflow", at: now, amount: 1, wantLevel: 2, wantAllowed: false},
+		{name: "PartiallyDrained", at: now.Add(500 * time.Millisecond), amount: 1, wantLevel: 2, wantAllowed: true},
+		{name: "Drained", at: now.Add(2 * time.Second), amount: 0, wantLevel: 0, wantAllowed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			level, allowed, err := c.Fill(context.Background(), key, test.amount, capacity, drain, test.at)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if level != test.wantLevel {
+				t.Errorf("expected level: %v received: %v", test.wantLevel, level)
+			}
+
+			if allowed != test.wantAllowed {
+				t.Errorf("expected allowed: %v received: %v", test.wantAllowed, allowed)
+			}
+		})
+	}
+
+	if ttl := s.TTL(NamespacedKey(limitStoreNamespace, key)); ttl <= 0 {
+		t.Fatalf("expected bucket to have an expiration, received %v", ttl)
+	}
+}
+
+func TestLimitLeakyBucket(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	limit := Limit{Count: 3, Duration: time.Minute, Enabled: true, Algorithm: LeakyBucketAlgorithm}
+	fstore := &FakeLimitStore{limit: limit}
+	rl := NewIPRateLimiter(fstore, c, LocalClock{}, nil, TestingLogger, NullReporter{})
+
+	req := Request{RemoteAddress: "192.168.1.2"}
+	for i := 0; i < 5; i++ {
+		blocked, remaining, err := rl.Limit(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expectedBlocked := i >= 3
+		if blocked != expectedBlocked {
+			t.Fatalf("iteration %d expected blocked: %v, received blocked: %v", i, expectedBlocked, blocked)
+		}
+
+		if !blocked && remaining != uint32(2-i) {
+			t.Fatalf("iteration %d expected remaining: %v, received: %v", i, 2-i, remaining)
+		}
+	}
+}
+
+func TestLimitLeakyBucketUnsupportedCounterFailsOpen(t *testing.T) {
+	limit := Limit{Count: 3, Duration: time.Minute, Enabled: true, Algorithm: LeakyBucketAlgorithm}
+	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64)}
+	rl := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
+
+	blocked, _, err := rl.Limit(context.Background(), Request{RemoteAddress: "192.168.1.2"})
+	if err == nil {
+		t.Fatal("expected error but received nil")
+	}
+
+	if blocked {
+		t.Fatal("failed closed when it should have failed open")
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Algorithm
+		wantErr bool
+	}{
+		{in: "", want: FixedWindowAlgorithm},
+		{in: "fixed_window", want: FixedWindowAlgorithm},
+		{in: "leaky_bucket", want: LeakyBucketAlgorithm},
+		{in: "token_bucket", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseAlgorithm(test.in)
+		if (err != nil) != test.wantErr {
+			t.Fatalf("%q expected error: %v received: %v", test.in, test.wantErr, err)
+		}
+
+		if got != test.want {
+			t.Fatalf("%q expected: %v received: %v", test.in, test.want, got)
+		}
+	}
+}
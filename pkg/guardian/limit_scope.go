@@ -0,0 +1,446 @@
+package guardian
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// LimitScope is a level of the limit inheritance hierarchy. From broadest to most specific the scopes are global,
+// authority, route, and key. Each scope overrides the fields it sets of the limit resolved for the broader scopes,
+// and inherits the rest.
+type LimitScope string
+
+const (
+	// GlobalLimitScope is the limit applied to every request
+	GlobalLimitScope LimitScope = "global"
+
+	// AuthorityLimitScope overrides the limit for requests to an authority, e.g. api.example.com
+	AuthorityLimitScope LimitScope = "authority"
+
+	// RouteLimitScope overrides the limit for requests matching a route
+	RouteLimitScope LimitScope = "route"
+
+	// KeyLimitScope overrides the limit for requests from a key, the remote address
+	KeyLimitScope LimitScope = "key"
+)
+
+// LimitOverride is a partial Limit set for a scope. Fields that are nil are inherited from the broader scopes.
+type LimitOverride struct {
+	Count          *uint64
+	Duration       *time.Duration
+	Enabled        *bool
+	Algorithm      *Algorithm
+	EnforcePercent *uint
+	// Calendar and TimeZone are overridden together. Overriding Duration without Calendar clears the inherited
+	// calendar, since windows are then of the duration.
+	Calendar *CalendarWindow
+	TimeZone string
+	Rollover *uint64
+	// Metadata documents the override, it isn't inherited
+	Metadata Metadata
+}
+
+// LimitOverrideFromLimit creates a LimitOverride overriding every field with those of limit
+func LimitOverrideFromLimit(limit Limit) LimitOverride {
+	return LimitOverride{
+		Count:          &limit.Count,
+		Duration:       &limit.Duration,
+		Enabled:        &limit.Enabled,
+		Algorithm:      &limit.Algorithm,
+		EnforcePercent: &limit.EnforcePercent,
+		Calendar:       &limit.Calendar,
+		TimeZone:       limit.TimeZone,
+		Rollover:       &limit.Rollover,
+	}
+}
+
+// Apply returns parent with the fields set by the override replaced
+func (o LimitOverride) Apply(parent Limit) Limit {
+	limit := parent
+	if o.Count != nil {
+		limit.Count = *o.Count
+	}
+
+	if o.Duration != nil {
+		limit.Duration = *o.Duration
+		limit.Calendar = ""
+		limit.TimeZone = ""
+	}
+
+	if o.Enabled != nil {
+		limit.Enabled = *o.Enabled
+	}
+
+	if o.Algorithm != nil {
+		limit.Algorithm = *o.Algorithm
+	}
+
+	if o.EnforcePercent != nil {
+		limit.EnforcePercent = *o.EnforcePercent
+	}
+
+	if o.Calendar != nil {
+		limit.Calendar = *o.Calendar
+		limit.TimeZone = o.TimeZone
+	}
+
+	if o.Rollover != nil {
+		limit.Rollover = *o.Rollover
+	}
+
+	return limit
+}
+
+// Equal returns whether o and other override the same fields with the same values
+func (o LimitOverride) Equal(other LimitOverride) bool {
+	return o.String() == other.String()
+}
+
+func (o LimitOverride) String() string {
+	fields := []string{}
+	if o.Count != nil {
+		fields = append(fields, fmt.Sprintf("count: %d", *o.Count))
+	}
+
+	if o.Duration != nil {
+		fields = append(fields, fmt.Sprintf("duration: %v", *o.Duration))
+	}
+
+	if o.Enabled != nil {
+		fields = append(fields, fmt.Sprintf("enabled: %v", *o.Enabled))
+	}
+
+	if o.Algorithm != nil {
+		fields = append(fields, fmt.Sprintf("algorithm: %v", *o.Algorithm))
+	}
+
+	if o.EnforcePercent != nil {
+		fields = append(fields, fmt.Sprintf("enforced: %d%%", *o.EnforcePercent))
+	}
+
+	if o.Calendar != nil {
+		fields = append(fields, fmt.Sprintf("calendar: %q (%q)", *o.Calendar, o.TimeZone))
+	}
+
+	if o.Rollover != nil {
+		fields = append(fields, fmt.Sprintf("rollover: %d", *o.Rollover))
+	}
+
+	if len(fields) == 0 {
+		return "LimitOverride(inherits all)"
+	}
+
+	return "LimitOverride(" + strings.Join(fields, ", ") + ")"
+}
+
+// LimitOverrideDocument is the serializable form of a LimitOverride. A LimitDocument is a valid LimitOverrideDocument
+// overriding count, duration, enabled, and any other fields it includes.
+type LimitOverrideDocument struct {
+	Count          *uint64 `json:"count,omitempty"`
+	Duration       string  `json:"duration,omitempty"`
+	Enabled        *bool   `json:"enabled,omitempty"`
+	Algorithm      string  `json:"algorithm,omitempty"`
+	EnforcePercent *uint   `json:"enforce_percent,omitempty"`
+	// Calendar is "" to clear an inherited calendar
+	Calendar *string `json:"calendar,omitempty"`
+	TimeZone string  `json:"time_zone,omitempty"`
+	Rollover *uint64 `json:"rollover,omitempty"`
+	Metadata
+}
+
+// LimitOverrideDocumentFromOverride converts a LimitOverride to a LimitOverrideDocument
+func LimitOverrideDocumentFromOverride(o LimitOverride) LimitOverrideDocument {
+	doc := LimitOverrideDocument{Count: o.Count, Enabled: o.Enabled, EnforcePercent: o.EnforcePercent, TimeZone: o.TimeZone, Rollover: o.Rollover, Metadata: o.Metadata}
+	if o.Duration != nil {
+		doc.Duration = o.Duration.String()
+	}
+
+	if o.Algorithm != nil {
+		doc.Algorithm = string(*o.Algorithm)
+	}
+
+	if o.Calendar != nil {
+		calendar := string(*o.Calendar)
+		doc.Calendar = &calendar
+	}
+
+	return doc
+}
+
+// Override converts the document to a LimitOverride, validating the fields it sets
+func (d LimitOverrideDocument) Override() (LimitOverride, error) {
+	if err := d.Metadata.Validate(); err != nil {
+		return LimitOverride{}, err
+	}
+
+	o := LimitOverride{Count: d.Count, Enabled: d.Enabled, EnforcePercent: d.EnforcePercent, TimeZone: d.TimeZone, Rollover: d.Rollover, Metadata: d.Metadata}
+	if len(d.Duration) > 0 {
+		duration, err := time.ParseDuration(d.Duration)
+		if err != nil {
+			return LimitOverride{}, errors.Wrap(err, "error parsing limit duration")
+		}
+
+		if duration < time.Second {
+			return LimitOverride{}, fmt.Errorf("limit duration %v must be at least 1s", duration)
+		}
+		o.Duration = &duration
+	}
+
+	if len(d.Algorithm) > 0 {
+		if _, err := ParseAlgorithm(d.Algorithm); err != nil {
+			return LimitOverride{}, err
+		}
+		algorithm := Algorithm(d.Algorithm)
+		o.Algorithm = &algorithm
+	}
+
+	if d.EnforcePercent != nil {
+		if err := ValidateEnforcePercent(*d.EnforcePercent); err != nil {
+			return LimitOverride{}, err
+		}
+	}
+
+	calendar := CalendarWindow("")
+	if d.Calendar != nil {
+		calendar = CalendarWindow(*d.Calendar)
+		o.Calendar = &calendar
+	}
+
+	// the algorithm is validated along with the calendar once the override is applied
+	if err := ValidateCalendar(Limit{Calendar: calendar, TimeZone: d.TimeZone}); err != nil {
+		return LimitOverride{}, err
+	}
+
+	return o, nil
+}
+
+// ValidateResolvedLimit returns an error if a limit resolved by applying overrides is inconsistent, such as a
+// calendar window inherited by a limit overriding the algorithm with leaky bucket
+func ValidateResolvedLimit(limit Limit) error {
+	if err := ValidateCalendar(limit); err != nil {
+		return err
+	}
+
+	if err := ValidateDayBuckets(limit); err != nil {
+		return err
+	}
+
+	return ValidateRollover(limit)
+}
+
+// LimitOverridesFromStrings parses limit overrides from a map of names to JSON encoded limit override records,
+// skipping any that are invalid or inconsistent once applied to parent. Names of the key scope are canonicalized as
+// remote addresses.
+func LimitOverridesFromStrings(scope LimitScope, overrideStrs map[string]string, parent Limit, logger logrus.FieldLogger) map[string]LimitOverride {
+	overrides := make(map[string]LimitOverride)
+	for name, overrideStr := range overrideStrs {
+		o, err := decodeLimitOverrideRecord(overrideStr)
+		if err == nil {
+			err = ValidateResolvedLimit(o.Apply(parent))
+		}
+
+		if err != nil {
+			logger.WithError(err).Errorf("error parsing %v limit for %v", scope, name)
+			continue
+		}
+
+		if scope == KeyLimitScope {
+			name = CanonicalRemoteAddress(name)
+		}
+		overrides[name] = o
+	}
+
+	return overrides
+}
+
+// LimitResolver resolves the effective limit of a request through the limit inheritance hierarchy
+type LimitResolver interface {
+	// ResolveLimit returns the effective limit of request, through the route scope of routeLimit unless it is nil,
+	// and the scope its requests are counted in
+	ResolveLimit(request Request, routeLimit *RouteLimit) (Limit, string)
+}
+
+// LimitResolution is the effective limit of a request along with how it was resolved
+type LimitResolution struct {
+	Limit Limit
+	Steps []LimitResolutionStep
+}
+
+// LimitResolutionStep is the limit resolved after applying a scope that had a limit for the request
+type LimitResolutionStep struct {
+	Scope LimitScope
+	// Name is the authority, route, or key the scope's limit is set for, empty for the global scope
+	Name     string
+	Override LimitOverride
+	Limit    Limit
+}
+
+// resolveLimit resolves the limit of request from global through the authority, route, and key scopes, recording
+// each scope applied to steps unless it is nil. It also returns the scope requests are counted in: the authority
+// if it has a limit of its own, so an address's requests to an authority aren't counted against the limit of
+// another, and empty otherwise. Routes and keys are already counted separately.
+func resolveLimit(global Limit, authorityLimits map[string]LimitOverride, keyLimits map[string]LimitOverride, request Request, routeLimit *RouteLimit, steps *[]LimitResolutionStep) (Limit, string) {
+	limit := global
+	scope := ""
+	apply := func(scope LimitScope, name string, o LimitOverride) {
+		limit = o.Apply(limit)
+		if steps != nil {
+			*steps = append(*steps, LimitResolutionStep{Scope: scope, Name: name, Override: o, Limit: limit})
+		}
+	}
+
+	if steps != nil {
+		*steps = append(*steps, LimitResolutionStep{Scope: GlobalLimitScope, Override: LimitOverrideFromLimit(global), Limit: global})
+	}
+
+	if o, ok := authorityLimits[request.Authority]; ok {
+		apply(AuthorityLimitScope, request.Authority, o)
+		scope = NamespacedKey(string(AuthorityLimitScope), request.Authority)
+	}
+
+	if routeLimit != nil {
+		apply(RouteLimitScope, routeLimit.Route.String(), routeLimit.Limit)
+	}
+
+	if o, ok := keyLimits[request.RemoteAddress]; ok {
+		apply(KeyLimitScope, request.RemoteAddress, o)
+	}
+
+	return limit, scope
+}
+
+// resolveRequestLimit returns the effective limit of request from conf, through the route scope of routeLimit unless
+// it is nil, and the scope its requests are counted in. Providers that don't resolve limits have only the global and
+// route scopes.
+func resolveRequestLimit(conf interface{}, request Request, routeLimit *RouteLimit) (Limit, string) {
+	if resolver, ok := conf.(LimitResolver); ok {
+		return resolver.ResolveLimit(request, routeLimit)
+	}
+
+	global := Limit{}
+	if provider, ok := conf.(LimitProvider); ok {
+		global = provider.GetLimit()
+	}
+
+	return resolveLimit(global, nil, nil, request, routeLimit, nil)
+}
+
+// ResolveLimit returns the effective limit of request, through the route scope of routeLimit unless it is nil, and
+// the scope its requests are counted in
+func (rs *RedisConfStore) ResolveLimit(request Request, routeLimit *RouteLimit) (Limit, string) {
+	rs.conf.RLock()
+	defer rs.conf.RUnlock()
+
+	return resolveLimit(rs.conf.limit, rs.conf.authorityLimits, rs.conf.keyLimits, request, routeLimit, nil)
+}
+
+// ExplainLimit resolves the effective limit of request like ResolveLimit, along with each scope applied
+func (rs *RedisConfStore) ExplainLimit(request Request, routeLimit *RouteLimit) LimitResolution {
+	rs.conf.RLock()
+	defer rs.conf.RUnlock()
+
+	steps := []LimitResolutionStep{}
+	limit, _ := resolveLimit(rs.conf.limit, rs.conf.authorityLimits, rs.conf.keyLimits, request, routeLimit, &steps)
+	return LimitResolution{Limit: limit, Steps: steps}
+}
+
+// scopedLimitsKey returns the Redis key of the hash holding the limits set for scope
+func scopedLimitsKey(scope LimitScope) (string, error) {
+	switch scope {
+	case AuthorityLimitScope:
+		return redisAuthorityLimitsKey, nil
+	case KeyLimitScope:
+		return redisKeyLimitsKey, nil
+	}
+
+	return "", fmt.Errorf("limits can't be set by name for the %v scope", scope)
+}
+
+// FetchScopedLimits fetches the limits set for the authority or key scope by name from Redis
+func (rs *RedisConfStore) FetchScopedLimits(scope LimitScope) (map[string]LimitOverride, error) {
+	c := rs.pipelinedFetchConf()
+	overrides := c.authorityLimits
+	if scope == KeyLimitScope {
+		overrides = c.keyLimits
+	}
+
+	if overrides == nil {
+		return nil, fmt.Errorf("error fetching %v limits", scope)
+	}
+
+	return overrides, nil
+}
+
+// SetScopedLimit sets the limit of the authority or key scope for name
+func (rs *RedisConfStore) SetScopedLimit(scope LimitScope, name string, o LimitOverride) error {
+	key, err := scopedLimitsKey(scope)
+	if err != nil {
+		return err
+	}
+
+	record, err := encodeLimitOverrideRecord(o)
+	if err != nil {
+		return err
+	}
+
+	if scope == KeyLimitScope {
+		name = CanonicalRemoteAddress(name)
+	}
+
+	rs.logger.Debugf("Sending HSet for key %v field %v", key, name)
+	return rs.multi(func(pipe redis.Pipeliner) {
+		pipe.HSet(key, name, record)
+	})
+}
+
+// RemoveScopedLimit removes the limit of the authority or key scope for name
+func (rs *RedisConfStore) RemoveScopedLimit(scope LimitScope, name string) error {
+	key, err := scopedLimitsKey(scope)
+	if err != nil {
+		return err
+	}
+
+	fields := []string{name}
+	if canonical := CanonicalRemoteAddress(name); scope == KeyLimitScope && canonical != name {
+		fields = append(fields, canonical)
+	}
+
+	rs.logger.Debugf("Sending HDel for key %v fields %v", key, fields)
+	return rs.multi(func(pipe redis.Pipeliner) {
+		pipe.HDel(key, fields...)
+	})
+}
+
+// limitResolutionJSON is the JSON form of a LimitResolution
+type limitResolutionJSON struct {
+	Limit LimitDocument             `json:"limit"`
+	Steps []limitResolutionStepJSON `json:"steps"`
+}
+
+type limitResolutionStepJSON struct {
+	Scope    LimitScope            `json:"scope"`
+	Name     string                `json:"name,omitempty"`
+	Override LimitOverrideDocument `json:"override"`
+	Limit    LimitDocument         `json:"limit"`
+}
+
+// MarshalJSON encodes the resolution with its limits as documents
+func (r LimitResolution) MarshalJSON() ([]byte, error) {
+	res := limitResolutionJSON{Limit: LimitDocumentFromLimit(r.Limit), Steps: []limitResolutionStepJSON{}}
+	for _, step := range r.Steps {
+		res.Steps = append(res.Steps, limitResolutionStepJSON{
+			Scope:    step.Scope,
+			Name:     step.Name,
+			Override: LimitOverrideDocumentFromOverride(step.Override),
+			Limit:    LimitDocumentFromLimit(step.Limit),
+		})
+	}
+
+	return json.Marshal(res)
+}
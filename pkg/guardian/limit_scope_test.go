@@ -0,0 +1,239 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func mustLimitOverrideDocument(t testing.TB, s string) LimitOverrideDocument {
+	doc := LimitOverrideDocument{}
+	if err := json.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatalf("error decoding limit override %v: %v", s, err)
+	}
+
+	return doc
+}
+
+func mustLimitOverride(t testing.TB, s string) LimitOverride {
+	o, err := mustLimitOverrideDocument(t, s).Override()
+	if err != nil {
+		t.Fatalf("error parsing limit override %v: %v", s, err)
+	}
+
+	return o
+}
+
+func TestLimitOverrideApply(t *testing.T) {
+	parent := Limit{Count: 100, Duration: time.Hour, Enabled: true, Algorithm: LeakyBucketAlgorithm, EnforcePercent: 50}
+	calendarParent := Limit{Count: 100, Duration: 24 * time.Hour, Enabled: true, Calendar: DayWindow, TimeZone: "America/Los_Angeles"}
+
+	tests := []struct {
+		name     string
+		override string
+		parent   Limit
+		want     Limit
+	}{
+		{name: "InheritsAll", override: `{}`, parent: parent, want: parent},
+		{name: "CountOnly", override: `{"count": 10}`, parent: parent, want: Limit{Count: 10, Duration: time.Hour, Enabled: true, Algorithm: LeakyBucketAlgorithm, EnforcePercent: 50}},
+		{name: "Disabled", override: `{"enabled": false}`, parent: parent, want: Limit{Count: 100, Duration: time.Hour, Enabled: false, Algorithm: LeakyBucketAlgorithm, EnforcePercent: 50}},
+		{name: "EnforceAll", override: `{"enforce_percent": 0}`, parent: parent, want: Limit{Count: 100, Duration: time.Hour, Enabled: true, Algorithm: LeakyBucketAlgorithm}},
+		{name: "InheritsCalendar", override: `{"count": 10}`, parent: calendarParent, want: Limit{Count: 10, Duration: 24 * time.Hour, Enabled: true, Calendar: DayWindow, TimeZone: "America/Los_Angeles"}},
+		{name: "DurationClearsCalendar", override: `{"duration": "1m"}`, parent: calendarParent, want: Limit{Count: 100, Duration: time.Minute, Enabled: true}},
+		{name: "Calendar", override: `{"calendar": "hour"}`, parent: calendarParent, want: Limit{Count: 100, Duration: 24 * time.Hour, Enabled: true, Calendar: HourWindow}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := mustLimitOverride(t, test.override).Apply(test.parent); got != test.want {
+				t.Errorf("expected: %v received: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestLimitOverrideDocumentRejectsInvalid(t *testing.T) {
+	tests := []string{
+		`{"duration": "10ms"}`,
+		`{"algorithm": "magic"}`,
+		`{"enforce_percent": 101}`,
+		`{"calendar": "fortnight"}`,
+		`{"time_zone": "America/Los_Angeles"}`,
+	}
+
+	for _, test := range tests {
+		if _, err := mustLimitOverrideDocument(t, test).Override(); err == nil {
+			t.Errorf("expected error parsing %v", test)
+		}
+	}
+}
+
+func TestLimitOverrideDocumentEncodesLimitDocuments(t *testing.T) {
+	// route limits stored before they could be partial must encode the same way, so their signatures still verify
+	stored := `{"count":5,"duration":"1m0s","enabled":false,"calendar":"day","time_zone":"UTC"}`
+	doc := LimitOverrideDocumentFromOverride(mustLimitOverride(t, stored))
+	if got := normalizedJSON(doc); got != stored {
+		t.Errorf("expected: %v received: %v", stored, got)
+	}
+}
+
+func TestConfStoreResolvesLimitsThroughScopes(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	if err := c.SetLimit(Limit{Count: 100, Duration: time.Minute, Enabled: true}); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	users, _ := ParseRoutePattern("/users/{id}")
+	if err := c.SetRouteLimit(users, mustLimitOverride(t, `{"count": 10}`)); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := c.SetScopedLimit(AuthorityLimitScope, "api.example.com", mustLimitOverride(t, `{"duration": "1h"}`)); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := c.SetScopedLimit(KeyLimitScope, "192.168.1.2", mustLimitOverride(t, `{"count": 1000}`)); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c.UpdateCachedConf()
+	routeLimit, ok := c.GetRouteMatcher().Match("/users/1")
+	if !ok {
+		t.Fatal("expected route limit to match")
+	}
+
+	tests := []struct {
+		name       string
+		request    Request
+		routeLimit *RouteLimit
+		want       Limit
+		scopes     []LimitScope
+	}{
+		{name: "Global", request: Request{RemoteAddress: "10.0.0.1"}, want: Limit{Count: 100, Duration: time.Minute, Enabled: true}, scopes: []LimitScope{GlobalLimitScope}},
+		{name: "Route", request: Request{RemoteAddress: "10.0.0.1"}, routeLimit: &routeLimit, want: Limit{Count: 10, Duration: time.Minute, Enabled: true}, scopes: []LimitScope{GlobalLimitScope, RouteLimitScope}},
+		{name: "AuthorityRoute", request: Request{RemoteAddress: "10.0.0.1", Authority: "api.example.com"}, routeLimit: &routeLimit, want: Limit{Count: 10, Duration: time.Hour, Enabled: true}, scopes: []LimitScope{GlobalLimitScope, AuthorityLimitScope, RouteLimitScope}},
+		{name: "AuthorityRouteKey", request: Request{RemoteAddress: "192.168.1.2", Authority: "api.example.com"}, routeLimit: &routeLimit, want: Limit{Count: 1000, Duration: time.Hour, Enabled: true}, scopes: []LimitScope{GlobalLimitScope, AuthorityLimitScope, RouteLimitScope, KeyLimitScope}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, _ := c.ResolveLimit(test.request, test.routeLimit); got != test.want {
+				t.Errorf("expected: %v received: %v", test.want, got)
+			}
+
+			resolution := c.ExplainLimit(test.request, test.routeLimit)
+			scopes := []LimitScope{}
+			for _, step := range resolution.Steps {
+				scopes = append(scopes, step.Scope)
+			}
+
+			if resolution.Limit != test.want || !cmp.Equal(scopes, test.scopes) {
+				t.Errorf("expected %v through %v, received: %v through %v", test.want, test.scopes, resolution.Limit, scopes)
+			}
+		})
+	}
+}
+
+func TestConfStoreRejectsInconsistentScopedLimits(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	if err := c.SetLimit(Limit{Count: 100, Duration: time.Minute, Enabled: true}); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	// inherits a duration that isn't a whole number of days
+	if err := c.SetScopedLimit(KeyLimitScope, "192.168.1.2", mustLimitOverride(t, `{"algorithm": "day_buckets"}`)); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c.UpdateCachedConf()
+	if got, _ := c.ResolveLimit(Request{RemoteAddress: "192.168.1.2"}, nil); got.Algorithm == DayBucketsAlgorithm {
+		t.Errorf("expected inconsistent key limit to be rejected, received: %v", got)
+	}
+}
+
+func TestIPRateLimiterUsesScopedLimit(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	if err := c.SetLimit(Limit{Count: 100, Duration: time.Minute, Enabled: true}); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := c.SetScopedLimit(AuthorityLimitScope, "login.example.com", mustLimitOverride(t, `{"count": 1}`)); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	c.UpdateCachedConf()
+
+	counter := &FakeLimitStore{count: make(map[string]uint64)}
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	rl := NewIPRateLimiter(c, counter, clock, nil, TestingLogger, NullReporter{})
+
+	requests := []Request{
+		{RemoteAddress: "192.168.1.2", Authority: "login.example.com"},
+		{RemoteAddress: "192.168.1.3", Authority: "www.example.com"},
+	}
+
+	// batches fall back to limiting each request when limits differ by request
+	for i := 0; i < 2; i++ {
+		results, err := rl.LimitBatch(context.Background(), requests)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if results[0].Blocked != (i > 0) || results[1].Blocked {
+			t.Fatalf("batch %d unexpected results: %v", i, results)
+		}
+	}
+}
+
+func TestSimulateHandler(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	if err := c.SetLimit(Limit{Count: 100, Duration: time.Minute, Enabled: true}); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	users, _ := ParseRoutePattern("/users/{id}")
+	if err := c.SetRouteLimit(users, mustLimitOverride(t, `{"count": 10}`)); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	c.UpdateCachedConf()
+
+	rec := httptest.NewRecorder()
+	NewSimulateHandler(c, TestingLogger).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/simulate?remote_address=10.0.0.1&path=/users/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %v received: %v", http.StatusOK, rec.Code)
+	}
+
+	res := struct {
+		Limit      limitResolutionJSON  `json:"limit"`
+		Route      string               `json:"route"`
+		RouteLimit *limitResolutionJSON `json:"route_limit"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if res.Limit.Limit.Count != 100 || len(res.Limit.Steps) != 1 {
+		t.Errorf("unexpected limit: %+v", res.Limit)
+	}
+
+	if res.Route != "/users/{id}" || res.RouteLimit == nil || res.RouteLimit.Limit.Count != 10 || res.RouteLimit.Limit.Duration != "1m0s" {
+		t.Fatalf("unexpected route limit: %v %+v", res.Route, res.RouteLimit)
+	}
+
+	if steps := res.RouteLimit.Steps; len(steps) != 2 || steps[1].Scope != RouteLimitScope || !strings.Contains(normalizedJSON(steps[1].Override), `"count":10`) {
+		t.Errorf("unexpected route limit steps: %+v", steps)
+	}
+}
@@ -0,0 +1,129 @@
+package guardian
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultLogComponent names the base logger of LogLevels, used by everything outside its components
+const DefaultLogComponent = "default"
+
+// NewLogLevels creates a new LogLevels with a logger for each of components, starting at the level of base and
+// writing through its output, formatter, and hooks
+func NewLogLevels(base *logrus.Logger, components ...string) *LogLevels {
+	loggers := map[string]*logrus.Logger{DefaultLogComponent: base}
+	for _, component := range components {
+		loggers[component] = &logrus.Logger{Out: base.Out, Hooks: base.Hooks, Formatter: base.Formatter, Level: loggerLevel(base)}
+	}
+
+	return &LogLevels{loggers: loggers}
+}
+
+// LogLevels holds a logger for each component of the server, such as the limiters, so the verbosity of one can be
+// raised at runtime without the log volume of raising the level of every component
+type LogLevels struct {
+	loggers map[string]*logrus.Logger // never modified after creation, levels are set atomically
+}
+
+// Logger returns the logger of component, or the base logger if it isn't one of the components
+func (l *LogLevels) Logger(component string) *logrus.Logger {
+	if logger, ok := l.loggers[component]; ok {
+		return logger
+	}
+
+	return l.loggers[DefaultLogComponent]
+}
+
+// SetLevel sets the level of the logger of component, or of the base logger if component is DefaultLogComponent
+func (l *LogLevels) SetLevel(component string, level logrus.Level) error {
+	logger, ok := l.loggers[component]
+	if !ok {
+		return fmt.Errorf("unknown log component %q, must be one of %v", component, l.components())
+	}
+
+	if loggerLevel(logger) != level {
+		logger.SetLevel(level)
+		logger.Warnf("log level of %v set to %v", component, level)
+	}
+
+	return nil
+}
+
+// Levels returns the level of each component's logger by component
+func (l *LogLevels) Levels() map[string]string {
+	levels := make(map[string]string, len(l.loggers))
+	for component, logger := range l.loggers {
+		levels[component] = loggerLevel(logger).String()
+	}
+
+	return levels
+}
+
+func (l *LogLevels) components() []string {
+	components := make([]string, 0, len(l.loggers))
+	for component := range l.loggers {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	return components
+}
+
+// loggerLevel returns the level of logger, which may be set concurrently with SetLevel
+func loggerLevel(logger *logrus.Logger) logrus.Level {
+	return logrus.Level(atomic.LoadUint32((*uint32)(&logger.Level)))
+}
+
+// NewLogLevelsHandler creates a new LogLevelsHandler
+func NewLogLevelsHandler(levels *LogLevels, logger logrus.FieldLogger) *LogLevelsHandler {
+	return &LogLevelsHandler{levels: levels, logger: logger}
+}
+
+// LogLevelsHandler is an admin HTTP handler serving the log level of each component on GET and setting that of a
+// component on POST
+type LogLevelsHandler struct {
+	levels *LogLevels
+	logger logrus.FieldLogger
+}
+
+type logLevelsResponse struct {
+	Levels map[string]string `json:"levels"`
+}
+
+type logLevelRequest struct {
+	Component string `json:"component"`
+	Level     string `json:"level"`
+}
+
+func (h *LogLevelsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		body := logLevelRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error decoding request body: %v", err), h.logger)
+			return
+		}
+
+		level, err := logrus.ParseLevel(body.Level)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err, h.logger)
+			return
+		}
+
+		if err := h.levels.SetLevel(body.Component, level); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err, h.logger)
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method), h.logger)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, logLevelsResponse{Levels: h.levels.Levels()}, h.logger)
+}
@@ -0,0 +1,79 @@
+package guardian
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogLevels(t *testing.T) {
+	out := &bytes.Buffer{}
+	base := logrus.New()
+	base.Out = out
+	base.SetLevel(logrus.InfoLevel)
+	levels := NewLogLevels(base, "limiter", "server")
+
+	if err := levels.SetLevel("limiter", logrus.DebugLevel); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	levels.Logger("limiter").Debug("limiter debug")
+	levels.Logger("server").Debug("server debug")
+	levels.Logger("unknown").Debug("unknown debug")
+	if !strings.Contains(out.String(), "limiter debug") {
+		t.Errorf("expected the limiter's debug logs, received: %v", out.String())
+	}
+
+	if strings.Contains(out.String(), "server debug") || strings.Contains(out.String(), "unknown debug") {
+		t.Errorf("expected no other debug logs, received: %v", out.String())
+	}
+
+	if err := levels.SetLevel("confstore", logrus.DebugLevel); err == nil {
+		t.Error("expected error setting the level of an unknown component")
+	}
+
+	if err := levels.SetLevel(DefaultLogComponent, logrus.ErrorLevel); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	want := map[string]string{DefaultLogComponent: "error", "limiter": "debug", "server": "info"}
+	if diff := cmp.Diff(want, levels.Levels()); diff != "" {
+		t.Errorf("unexpected levels (-want +got):\n%v", diff)
+	}
+}
+
+func TestLogLevelsHandler(t *testing.T) {
+	base := logrus.New()
+	base.SetLevel(logrus.InfoLevel)
+	handler := NewLogLevelsHandler(NewLogLevels(base, "server"), TestingLogger)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/log-levels", strings.NewReader(`{"component": "server", "level": "debug"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, received: %v %v", rec.Code, rec.Body.String())
+	}
+
+	res := logLevelsResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	want := map[string]string{DefaultLogComponent: "info", "server": "debug"}
+	if diff := cmp.Diff(want, res.Levels); diff != "" {
+		t.Errorf("unexpected levels (-want +got):\n%v", diff)
+	}
+
+	for _, body := range []string{`{"component": "server", "level": "loud"}`, `{"component": "whitelist", "level": "debug"}`, `{`} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/log-levels", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%v expected status 400, received: %v", body, rec.Code)
+		}
+	}
+}
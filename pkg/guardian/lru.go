@@ -0,0 +1,47 @@
+package guardian
+
+import "container/list"
+
+// lruCache is a fixed size cache evicting the least recently used entry. It is not safe for concurrent use.
+type lruCache struct {
+	size    int
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, order: list.New(), entries: make(map[string]*list.Element, size)}
+}
+
+// get returns the value cached for key, marking it as recently used
+func (c *lruCache) get(key string) (interface{}, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+// add caches value for key, evicting the least recently used entry if the cache is full
+func (c *lruCache) add(key string, value interface{}) {
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*lruEntry).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+}
@@ -0,0 +1,160 @@
+package guardian
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const usedMemoryField = "used_memory"
+
+// RunMemoryBudget checks Redis's used memory every interval until stop is closed. While it exceeds budget, in bytes,
+// counter keys are expired after at most maxTTL: the expirations of existing keys are shortened, and increments
+// set at most maxTTL. Counts of longer windows are lost early, letting clients exceed their limits, rather than
+// Redis running out of memory. Only the leader, if there is one, shortens the expirations of existing keys.
+func (rs *RedisCounter) RunMemoryBudget(budget uint64, maxTTL time.Duration, interval time.Duration, leader LeaderProvider, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			used, err := rs.usedMemory()
+			if err != nil {
+				rs.logger.WithError(err).Error("error checking redis memory budget")
+				rs.reporter.RedisMemoryBudget(0, float64(budget), 0, true)
+				continue
+			}
+			rs.enforceMemoryBudget(used, budget, maxTTL, isLeader(leader))
+		case <-stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// usedMemory returns the used_memory reported by INFO memory
+func (rs *RedisCounter) usedMemory() (uint64, error) {
+	info, err := rs.redis.Info("memory").Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "error fetching memory info")
+	}
+
+	return parseUsedMemory(info)
+}
+
+func parseUsedMemory(info string) (uint64, error) {
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if len(parts) == 2 && parts[0] == usedMemoryField {
+			return strconv.ParseUint(parts[1], 10, 64)
+		}
+	}
+
+	return 0, fmt.Errorf("memory info is missing %v", usedMemoryField)
+}
+
+func (rs *RedisCounter) enforceMemoryBudget(used uint64, budget uint64, maxTTL time.Duration, scan bool) {
+	shortened := 0
+	err := error(nil)
+	defer func() {
+		rs.reporter.RedisMemoryBudget(float64(used), float64(budget), float64(shortened), err != nil)
+	}()
+
+	if used <= budget {
+		if atomic.SwapInt64(&rs.maxTTL, 0) != 0 {
+			rs.logger.Warnf("redis memory of %d bytes is back within the budget of %d bytes, restoring counter expirations", used, budget)
+		}
+		return
+	}
+
+	if atomic.SwapInt64(&rs.maxTTL, int64(maxTTL)) == 0 {
+		rs.logger.Errorf("redis memory of %d bytes exceeds the budget of %d bytes, expiring counters after at most %v", used, budget, maxTTL)
+	}
+
+	if !scan {
+		return
+	}
+
+	match := NamespacedKey(limitStoreNamespace, "*")
+	cursor := uint64(0)
+	for {
+		var keys []string
+		keys, cursor, err = rs.redis.Scan(cursor, match, janitorScanCount).Result()
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("error scanning for keys matching %v", match))
+			rs.logger.WithError(err).Error("error enforcing redis memory budget")
+			return
+		}
+
+		n, serr := rs.shortenExpirations(keys, maxTTL)
+		shortened += n
+		if serr != nil {
+			err = serr
+			rs.logger.WithError(err).Error("error enforcing redis memory budget")
+			return
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if shortened > 0 {
+		rs.logger.Warnf("shortened the expirations of %d counter keys to %v", shortened, maxTTL)
+	}
+}
+
+// shortenExpirations expires the keys expiring after maxTTL, or never, after maxTTL, returning how many were
+func (rs *RedisCounter) shortenExpirations(keys []string, maxTTL time.Duration) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	pipe := rs.redis.Pipeline()
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		ttls[i] = pipe.PTTL(key)
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		return 0, errors.Wrap(err, "error fetching ttls")
+	}
+
+	long := []string{}
+	for i, ttl := range ttls {
+		// a TTL of -1 indicates the key exists but has no associated expire, -2 that it no longer exists
+		if ttl.Val() > maxTTL || ttl.Val() == -1*time.Millisecond {
+			long = append(long, keys[i])
+		}
+	}
+
+	if len(long) == 0 {
+		return 0, nil
+	}
+
+	pipe = rs.redis.Pipeline()
+	for _, key := range long {
+		pipe.Expire(key, maxTTL)
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		return 0, errors.Wrap(err, "error shortening expirations")
+	}
+
+	return len(long), nil
+}
+
+// expiration returns expireIn, capped while Redis exceeds its memory budget
+func (rs *RedisCounter) expiration(expireIn time.Duration) time.Duration {
+	if maxTTL := time.Duration(atomic.LoadInt64(&rs.maxTTL)); maxTTL > 0 && expireIn > maxTTL {
+		return maxTTL
+	}
+
+	return expireIn
+}
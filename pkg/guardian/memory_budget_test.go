@@ -0,0 +1,56 @@
+package guardian
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUsedMemory(t *testing.T) {
+	info := "# Memory\r\nused_memory:1048576\r\nused_memory_human:1.00M\r\nmaxmemory:0\r\n"
+	used, err := parseUsedMemory(info)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if used != 1048576 {
+		t.Fatalf("expected: %v received: %v", 1048576, used)
+	}
+
+	if _, err := parseUsedMemory("# Memory\r\nmaxmemory:0\r\n"); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+}
+
+func TestRedisCounterEnforceMemoryBudget(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	long := NamespacedKey(limitStoreNamespace, "long")
+	short := NamespacedKey(limitStoreNamespace, "short")
+	persistent := NamespacedKey(limitStoreNamespace, "persistent")
+	other := "other"
+	for _, key := range []string{long, short, persistent, other} {
+		s.Set(key, "1")
+	}
+	s.SetTTL(long, time.Hour)
+	s.SetTTL(short, 30*time.Second)
+
+	c.enforceMemoryBudget(2048, 1024, time.Minute, true)
+
+	expected := map[string]time.Duration{long: time.Minute, short: 30 * time.Second, persistent: time.Minute, other: 0}
+	for key, ttl := range expected {
+		if s.TTL(key) != ttl {
+			t.Errorf("expected ttl of %v: %v received: %v", key, ttl, s.TTL(key))
+		}
+	}
+
+	if expireIn := c.expiration(time.Hour); expireIn != time.Minute {
+		t.Fatalf("expected expirations capped at %v while over budget, received: %v", time.Minute, expireIn)
+	}
+
+	c.enforceMemoryBudget(512, 1024, time.Minute, true)
+
+	if expireIn := c.expiration(time.Hour); expireIn != time.Hour {
+		t.Fatalf("expected expirations restored within budget, received: %v", expireIn)
+	}
+}
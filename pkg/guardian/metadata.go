@@ -0,0 +1,56 @@
+package guardian
+
+import (
+	"fmt"
+	"strings"
+)
+
+const maxMetadataLength = 1024
+
+// Metadata records why a limit or rule exists and who to ask about it. It's stored with the limit or rule but doesn't
+// affect decisions.
+type Metadata struct {
+	Description string `json:"description,omitempty"`
+	// Owner is the person or team responsible, e.g. team-payments
+	Owner string `json:"owner,omitempty"`
+	// Ticket links to the ticket or discussion the limit or rule was added in
+	Ticket string `json:"ticket,omitempty"`
+}
+
+// Validate returns an error if any of the fields are longer than maxMetadataLength
+func (m Metadata) Validate() error {
+	fields := []struct {
+		name  string
+		value string
+	}{{"description", m.Description}, {"owner", m.Owner}, {"ticket", m.Ticket}}
+
+	for _, f := range fields {
+		if len(f.value) > maxMetadataLength {
+			return fmt.Errorf("%v must be at most %d characters", f.name, maxMetadataLength)
+		}
+	}
+
+	return nil
+}
+
+// Empty returns whether none of the fields are set
+func (m Metadata) Empty() bool {
+	return m == Metadata{}
+}
+
+func (m Metadata) String() string {
+	fields := []string{}
+	if len(m.Description) > 0 {
+		fields = append(fields, fmt.Sprintf("description: %q", m.Description))
+	}
+
+	if len(m.Owner) > 0 {
+		fields = append(fields, fmt.Sprintf("owner: %q", m.Owner))
+	}
+
+	if len(m.Ticket) > 0 {
+		fields = append(fields, fmt.Sprintf("ticket: %q", m.Ticket))
+	}
+
+	return "Metadata(" + strings.Join(fields, ", ") + ")"
+}
@@ -0,0 +1,84 @@
+package guardian
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetadataValidate(t *testing.T) {
+	if err := (Metadata{Description: "export is expensive", Owner: "team-data", Ticket: "https://example.com/TICKET-1"}).Validate(); err != nil {
+		t.Errorf("got error: %v", err)
+	}
+
+	if err := (Metadata{Owner: strings.Repeat("a", maxMetadataLength+1)}).Validate(); err == nil {
+		t.Error("expected an error for an owner longer than the max")
+	}
+}
+
+func TestConfStoreMetadata(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	metadata := Metadata{Description: "export is expensive", Owner: "team-data", Ticket: "https://example.com/TICKET-1"}
+
+	route, err := ParseRoutePattern("/export")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	override := LimitOverrideFromLimit(Limit{Count: 2, Duration: time.Second, Enabled: true})
+	override.Metadata = metadata
+	if err := c.SetRouteLimit(route, override); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := c.SetScopedLimit(AuthorityLimitScope, "api.example.com", override); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	rule, err := RuleDocument{When: `req.path == "/export"`, Action: string(BlockAction), Metadata: metadata}.Rule("block-export")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := c.SetRule(rule); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	routeLimits, err := c.FetchRouteLimits()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(routeLimits) != 1 || routeLimits[0].Limit.Metadata != metadata {
+		t.Errorf("expected route limit metadata %v, received: %v", metadata, routeLimits)
+	}
+
+	scoped, err := c.FetchScopedLimits(AuthorityLimitScope)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if got := scoped["api.example.com"].Metadata; got != metadata {
+		t.Errorf("expected scoped limit metadata %v, received: %v", metadata, got)
+	}
+
+	rules, err := c.FetchRules()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(rules) != 1 || rules[0].Metadata != metadata {
+		t.Errorf("expected rule metadata %v, received: %v", metadata, rules)
+	}
+
+	doc, err := c.ExportConfDocument()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if got := doc.Rules["block-export"].Metadata; got != metadata {
+		t.Errorf("expected exported rule metadata %v, received: %v", metadata, got)
+	}
+}
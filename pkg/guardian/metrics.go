@@ -1,8 +1,9 @@
 package guardian
 
 import (
-	"net"
+	"net/netip"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
@@ -13,72 +14,207 @@ const durationMetricName = "request.duration"
 const reqWhitelistMetricName = "request.whitelist"
 const reqBlacklisttMetricName = "request.blacklist"
 const reqRateLimitMetricName = "request.rate_limit"
+const reqRouteRateLimitMetricName = "request.route_rate_limit"
+const reqRuleMetricName = "request.rule"
+const reqRuleObservedMetricName = "request.rule.observed"
+const reqRuleKeyCardinalityMetricName = "request.rule.key_cardinality_exceeded"
+const reqRateLimitCanaryMetricName = "request.rate_limit.canary"
+const reqRateLimitExperimentMetricName = "request.rate_limit.experiment"
+const reqExemptMetricName = "request.exempt"
+const trafficRequestsMetricName = "traffic.requests"
+const trafficUniqueKeysMetricName = "traffic.unique_keys"
+const reqKeyMetricName = "request.key"
 const redisCounterIncrMetricName = "redis_counter.incr"
+const redisCounterHedgedMetricName = "redis_counter.hedged"
 const redisCounterPrunedMetricName = "redis_counter.cache.pruned"
 const redisCounterCacheSizeMetricName = "redis_counter.cache.size"
 const redisCounterPrunePassMetricName = "redis_counter.cache.prune_pass"
+const redisCounterSpillMergedMetricName = "redis_counter.spill.merged"
+const redisCounterSpillMergePassMetricName = "redis_counter.spill.merge_pass"
+const redisCounterJanitorScannedMetricName = "redis_counter.janitor.scanned"
+const redisCounterJanitorOrphansMetricName = "redis_counter.janitor.orphans"
+const redisCounterJanitorPassMetricName = "redis_counter.janitor.pass"
+const redisMemoryUsedMetricName = "redis_counter.memory.used"
+const redisMemoryBudgetMetricName = "redis_counter.memory.budget"
+const redisMemoryShortenedMetricName = "redis_counter.memory.shortened"
+const reputationLookupMetricName = "reputation.lookup"
+const planLookupMetricName = "plan.lookup"
+const whitelistHostLookupMetricName = "whitelist.host_lookup"
+const decisionStreamDroppedMetricName = "decision_stream.dropped"
+const decisionCacheLookupMetricName = "decision_cache.lookup"
 const rateLimitCountMetricName = "rate_limit.count"
 const rateLimitDurationMetricName = "rate_limit.duration"
 const rateLimitEnabledMetricName = "rate_limit.enabled"
+const rateLimitEnforcePercentMetricName = "rate_limit.enforce_percent"
+const rateLimitRolloverMetricName = "rate_limit.rollover"
 const whitelistCountMetricName = "whitelist.count"
 const blacklistCountMetricName = "blacklist.count"
 const reportOnlyEnabledMetricName = "report_only.enabled"
+const reportOnlyOverrideMetricName = "report_only.override"
+const confSyncRejectedMetricName = "conf.sync.rejected"
+const leaderMetricName = "leader_election.leader"
+const bulkheadSaturationMetricName = "bulkhead.saturation"
+const bulkheadRejectedMetricName = "bulkhead.rejected"
+const reqUnknownDomainMetricName = "request.unknown_domain"
+const reqInvalidMetricName = "request.invalid"
+const reqListDecisionMetricName = "request.list_decision"
+const selfTestMetricName = "self_test.duration"
+const throttleWebhookMetricName = "throttle_webhook.notifications"
+const sloBurnRateMetricName = "slo.burn_rate"
+const sloAlertingMetricName = "slo.alerting"
+const metricsDroppedMetricName = "metrics.dropped"
 const blockedKey = "blocked"
+const commandKey = "command"
+const hedgeWonKey = "hedge_won"
 const whitelistedKey = "whitelisted"
 const blacklistedKey = "blacklisted"
 const ratelimitedKey = "ratelimited"
+const enforcedKey = "enforced"
 const errorKey = "error"
-
-const metricChannelBuffSize = 1000000
+const routeKey = "route"
+const ruleKey = "rule"
+const actionKey = "action"
+const overLimitKey = "over_limit"
+const primaryBlockedKey = "primary_blocked"
+const alternateBlockedKey = "alternate_blocked"
+const primaryAlgorithmKey = "primary_algorithm"
+const alternateAlgorithmKey = "alternate_algorithm"
+const domainKey = "domain"
+const bulkheadKey = "bulkhead"
+const sloKey = "slo"
+const windowKey = "window"
+const hitKey = "hit"
+const exemptionKey = "exemption"
+const keyKey = "key"
+const reasonKey = "reason"
+const decisionKey = "decision"
+const passedKey = "passed"
+
+// DefaultMetricBufferSize is the number of metrics a DataDogReporter queues for emission before dropping them
+const DefaultMetricBufferSize = 1000000
+
+// metricDropReportInterval is how often a DataDogReporter reports the metrics it dropped
+const metricDropReportInterval = 10 * time.Second
 
 type MetricReporter interface {
-	Duration(request Request, blocked bool, errorOccurred bool, duration time.Duration)
+	Duration(request Request, rule *Rule, blocked bool, errorOccurred bool, duration time.Duration)
 	HandledWhitelist(request Request, whitelisted bool, errorOccurred bool, duration time.Duration)
 	HandledBlacklist(request Request, whitelisted bool, errorOccurred bool, duration time.Duration)
 	HandledRatelimit(request Request, ratelimited bool, errorOccurred bool, duration time.Duration)
+	HandledRatelimitCanary(request Request, enforced bool)
+	LimitExperiment(request Request, primary Limit, primaryBlocked bool, alternate Limit, alternateBlocked bool)
+	HandledRule(request Request, rule Rule, blocked bool, errorOccurred bool, duration time.Duration)
+	ObservedRule(request Request, rule Rule, count uint64, overLimit bool)
+	RuleKeyCardinalityExceeded(rule Rule, estimate uint64)
+	HandledRouteRatelimit(request Request, route string, ratelimited bool, errorOccurred bool, duration time.Duration)
+	Bulkhead(name string, saturation float64, rejected bool)
 	RedisCounterIncr(duration time.Duration, errorOccurred bool)
+	RedisCounterHedged(command string, hedgeWon bool)
 	RedisCounterPruned(duration time.Duration, cacheSize float64, prunedCounted float64)
+	RedisCounterJanitor(duration time.Duration, scanned float64, orphans float64, errorOccurred bool)
+	RedisMemoryBudget(used float64, budget float64, shortened float64, errorOccurred bool)
+	RedisCounterSpillMerged(duration time.Duration, merged float64, errorOccurred bool)
+	ReputationLookup(duration time.Duration, errorOccurred bool)
+	PlanLookup(duration time.Duration, errorOccurred bool)
+	WhitelistHostLookup(duration time.Duration, errorOccurred bool)
+	DecisionStreamDropped(dropped int)
+	DecisionCacheLookup(hit bool)
+	ExemptedRequest(exemption string)
+	TrafficWindow(route string, requests uint64, uniqueKeys uint64)
+	KeyDecisions(rule string, key string, allowed uint64, blocked uint64)
+	UnknownDomain(domain string, action UnknownDomainAction)
+	InvalidRequest(reason InvalidRequestReason, action InvalidRequestAction)
+	ListDecision(decision ListDecision, errorOccurred bool)
+	SelfTest(duration time.Duration, passed bool)
+	ThrottleWebhook(notifications int, errorOccurred bool)
 	CurrentLimit(limit Limit)
-	CurrentWhitelist(whitelist []net.IPNet)
-	CurrentBlacklist(blacklist []net.IPNet)
+	CurrentWhitelist(whitelist []netip.Prefix)
+	CurrentBlacklist(blacklist []netip.Prefix)
 	CurrentReportOnlyMode(reportOnly bool)
+	CurrentReportOnlyOverride(overridden bool)
+	ConfSync(rejected bool)
+	CurrentLeader(leader bool)
+	SLOBurnRate(slo string, window time.Duration, burnRate float64)
+	SLOAlerting(slo string, alerting bool)
 }
 
+// DataDogReporter reports metrics to DogStatsD. Metrics are queued and emitted by Run, so a slow statsd socket never
+// delays the decisions reporting them. Once the queue is full, metrics are dropped and counted instead.
 type DataDogReporter struct {
+	// dropped counts the metrics dropped since they were last reported. It's first for 64 bit alignment of atomic
+	// operations.
+	dropped     uint64
 	client      *statsd.Client
 	logger      logrus.FieldLogger
 	defaultTags []string
 	c           chan func()
 }
 
-func NewDataDogReporter(client *statsd.Client, defaultTags []string, logger logrus.FieldLogger) *DataDogReporter {
+// NewDataDogReporter creates a new DataDogReporter queueing up to bufferSize metrics, such as DefaultMetricBufferSize
+func NewDataDogReporter(client *statsd.Client, defaultTags []string, bufferSize int, logger logrus.FieldLogger) *DataDogReporter {
 	return &DataDogReporter{
 		client:      client,
 		logger:      logger,
 		defaultTags: defaultTags,
-		c:           make(chan func(), metricChannelBuffSize),
+		c:           make(chan func(), bufferSize),
 	}
 }
 
+// Run emits queued metrics until stop is closed, then emits those still queued and flushes the client. The metrics
+// dropped since the last report are reported every 10s as metrics.dropped.
 func (d *DataDogReporter) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(metricDropReportInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case f := <-d.c:
 			f()
+		case <-ticker.C:
+			d.reportDropped()
 		case <-stop:
-			for f := range d.c { // drain the channel
-				f()
+			d.flush()
+			return
+		}
+	}
+}
+
+// flush emits the queued metrics and the count of those dropped, and flushes the client's buffer
+func (d *DataDogReporter) flush() {
+	for {
+		select {
+		case f := <-d.c:
+			f()
+		default:
+			d.reportDropped()
+			if err := d.client.Flush(); err != nil {
+				d.logger.WithError(err).Error("error flushing metrics")
 			}
 			return
 		}
 	}
 }
 
-func (d *DataDogReporter) Duration(request Request, blocked bool, errorOccurred bool, duration time.Duration) {
+func (d *DataDogReporter) reportDropped() {
+	dropped := atomic.SwapUint64(&d.dropped, 0)
+	if dropped == 0 {
+		return
+	}
+
+	d.logger.Warnf("metric buffer full, dropped %d metrics", dropped)
+	d.client.Count(metricsDroppedMetricName, int64(dropped), d.defaultTags, 1)
+}
+
+// Duration reports the duration of a decision, tagged with the rule that decided it if any
+func (d *DataDogReporter) Duration(request Request, rule *Rule, blocked bool, errorOccurred bool, duration time.Duration) {
 	f := func() {
 		blockedTag := blockedKey + ":" + strconv.FormatBool(blocked)
 		errorTag := errorKey + ":" + strconv.FormatBool(errorOccurred)
-		tags := append([]string{blockedTag, errorTag}, d.defaultTags...)
+		tags := []string{blockedTag, errorTag}
+		if rule != nil {
+			tags = append(tags, ruleTags(*rule)...)
+		}
+		tags = append(tags, d.defaultTags...)
 		d.client.TimeInMilliseconds(durationMetricName, float64(duration/time.Millisecond), tags, 1)
 	}
 
@@ -115,6 +251,93 @@ func (d *DataDogReporter) HandledRatelimit(request Request, ratelimited bool, er
 	d.enqueue(f)
 }
 
+func (d *DataDogReporter) HandledRatelimitCanary(request Request, enforced bool) {
+	f := func() {
+		enforcedTag := enforcedKey + ":" + strconv.FormatBool(enforced)
+		tags := append([]string{enforcedTag}, d.defaultTags...)
+		d.client.Incr(reqRateLimitCanaryMetricName, tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
+// LimitExperiment reports the decisions of a limit and the alternate limit of an experiment for a request in the
+// experiment's cohort
+func (d *DataDogReporter) LimitExperiment(request Request, primary Limit, primaryBlocked bool, alternate Limit, alternateBlocked bool) {
+	f := func() {
+		tags := append([]string{
+			primaryBlockedKey + ":" + strconv.FormatBool(primaryBlocked),
+			alternateBlockedKey + ":" + strconv.FormatBool(alternateBlocked),
+			primaryAlgorithmKey + ":" + algorithmTag(primary.Algorithm),
+			alternateAlgorithmKey + ":" + algorithmTag(alternate.Algorithm),
+		}, d.defaultTags...)
+		d.client.Incr(reqRateLimitExperimentMetricName, tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
+// algorithmTag returns the tag value of algorithm, naming the default
+func algorithmTag(algorithm Algorithm) string {
+	if algorithm == "" {
+		return string(FixedWindowAlgorithm)
+	}
+
+	return string(algorithm)
+}
+
+func (d *DataDogReporter) HandledRule(request Request, rule Rule, blocked bool, errorOccurred bool, duration time.Duration) {
+	f := func() {
+		blockedTag := blockedKey + ":" + strconv.FormatBool(blocked)
+		errorTag := errorKey + ":" + strconv.FormatBool(errorOccurred)
+		tags := append(append(ruleTags(rule), blockedTag, errorTag), d.defaultTags...)
+		d.client.TimeInMilliseconds(reqRuleMetricName, float64(duration/time.Millisecond), tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
+// ObservedRule reports the count of the key a request matching an observe rule was counted by, as a histogram of
+// the counts keys reach
+func (d *DataDogReporter) ObservedRule(request Request, rule Rule, count uint64, overLimit bool) {
+	f := func() {
+		overLimitTag := overLimitKey + ":" + strconv.FormatBool(overLimit)
+		tags := append(append(ruleTags(rule), overLimitTag), d.defaultTags...)
+		d.client.Histogram(reqRuleObservedMetricName, float64(count), tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
+// RuleKeyCardinalityExceeded reports a rule counting too many distinct keys and falling back to the remote address,
+// as a histogram of the estimated distinct keys
+func (d *DataDogReporter) RuleKeyCardinalityExceeded(rule Rule, estimate uint64) {
+	f := func() {
+		tags := append(ruleTags(rule), d.defaultTags...)
+		d.client.Histogram(reqRuleKeyCardinalityMetricName, float64(estimate), tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
+func (d *DataDogReporter) HandledRouteRatelimit(request Request, route string, ratelimited bool, errorOccurred bool, duration time.Duration) {
+	f := func() {
+		routeTag := routeKey + ":" + route
+		ratelimitedTag := ratelimitedKey + ":" + strconv.FormatBool(ratelimited)
+		errorTag := errorKey + ":" + strconv.FormatBool(errorOccurred)
+		tags := append([]string{routeTag, ratelimitedTag, errorTag}, d.defaultTags...)
+		d.client.TimeInMilliseconds(reqRouteRateLimitMetricName, float64(duration/time.Millisecond), tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
+// Bulkhead reports the fraction of a bulkhead's concurrent operations in use, and counts operations it rejected
+func (d *DataDogReporter) Bulkhead(name string, saturation float64, rejected bool) {
+	f := func() {
+		tags := append([]string{bulkheadKey + ":" + name}, d.defaultTags...)
+		d.client.Histogram(bulkheadSaturationMetricName, saturation, tags, 1.0)
+		if rejected {
+			d.client.Count(bulkheadRejectedMetricName, 1, tags, 1.0)
+		}
+	}
+	d.enqueue(f)
+}
+
 func (d *DataDogReporter) RedisCounterIncr(duration time.Duration, errorOccurred bool) {
 	f := func() {
 		errorTag := errorKey + ":" + strconv.FormatBool(errorOccurred)
@@ -124,6 +347,14 @@ func (d *DataDogReporter) RedisCounterIncr(duration time.Duration, errorOccurred
 	d.enqueue(f)
 }
 
+func (d *DataDogReporter) RedisCounterHedged(command string, hedgeWon bool) {
+	f := func() {
+		tags := append([]string{commandKey + ":" + command, hedgeWonKey + ":" + strconv.FormatBool(hedgeWon)}, d.defaultTags...)
+		d.client.Incr(redisCounterHedgedMetricName, tags, 1)
+	}
+	d.enqueue(f)
+}
+
 func (d *DataDogReporter) RedisCounterPruned(duration time.Duration, cacheSize float64, prunedCounted float64) {
 	f := func() {
 		d.client.Gauge(redisCounterCacheSizeMetricName, cacheSize, d.defaultTags, 1)
@@ -133,6 +364,163 @@ func (d *DataDogReporter) RedisCounterPruned(duration time.Duration, cacheSize f
 	d.enqueue(f)
 }
 
+func (d *DataDogReporter) RedisCounterJanitor(duration time.Duration, scanned float64, orphans float64, errorOccurred bool) {
+	f := func() {
+		errorTag := errorKey + ":" + strconv.FormatBool(errorOccurred)
+		tags := append([]string{errorTag}, d.defaultTags...)
+		d.client.Gauge(redisCounterJanitorScannedMetricName, scanned, d.defaultTags, 1)
+		d.client.Gauge(redisCounterJanitorOrphansMetricName, orphans, d.defaultTags, 1)
+		d.client.TimeInMilliseconds(redisCounterJanitorPassMetricName, float64(duration/time.Millisecond), tags, 1)
+	}
+	d.enqueue(f)
+}
+
+func (d *DataDogReporter) RedisMemoryBudget(used float64, budget float64, shortened float64, errorOccurred bool) {
+	f := func() {
+		errorTag := errorKey + ":" + strconv.FormatBool(errorOccurred)
+		tags := append([]string{errorTag}, d.defaultTags...)
+		d.client.Gauge(redisMemoryUsedMetricName, used, tags, 1)
+		d.client.Gauge(redisMemoryBudgetMetricName, budget, d.defaultTags, 1)
+		d.client.Gauge(redisMemoryShortenedMetricName, shortened, tags, 1)
+	}
+	d.enqueue(f)
+}
+
+func (d *DataDogReporter) RedisCounterSpillMerged(duration time.Duration, merged float64, errorOccurred bool) {
+	f := func() {
+		errorTag := errorKey + ":" + strconv.FormatBool(errorOccurred)
+		tags := append([]string{errorTag}, d.defaultTags...)
+		d.client.Gauge(redisCounterSpillMergedMetricName, merged, tags, 1)
+		d.client.TimeInMilliseconds(redisCounterSpillMergePassMetricName, float64(duration/time.Millisecond), tags, 1)
+	}
+	d.enqueue(f)
+}
+
+func (d *DataDogReporter) ReputationLookup(duration time.Duration, errorOccurred bool) {
+	f := func() {
+		errorTag := errorKey + ":" + strconv.FormatBool(errorOccurred)
+		tags := append([]string{errorTag}, d.defaultTags...)
+		d.client.TimeInMilliseconds(reputationLookupMetricName, float64(duration/time.Millisecond), tags, 1)
+	}
+	d.enqueue(f)
+}
+
+func (d *DataDogReporter) PlanLookup(duration time.Duration, errorOccurred bool) {
+	f := func() {
+		errorTag := errorKey + ":" + strconv.FormatBool(errorOccurred)
+		tags := append([]string{errorTag}, d.defaultTags...)
+		d.client.TimeInMilliseconds(planLookupMetricName, float64(duration/time.Millisecond), tags, 1)
+	}
+	d.enqueue(f)
+}
+
+func (d *DataDogReporter) WhitelistHostLookup(duration time.Duration, errorOccurred bool) {
+	f := func() {
+		errorTag := errorKey + ":" + strconv.FormatBool(errorOccurred)
+		tags := append([]string{errorTag}, d.defaultTags...)
+		d.client.TimeInMilliseconds(whitelistHostLookupMetricName, float64(duration/time.Millisecond), tags, 1)
+	}
+	d.enqueue(f)
+}
+
+func (d *DataDogReporter) DecisionStreamDropped(dropped int) {
+	f := func() {
+		d.client.Count(decisionStreamDroppedMetricName, int64(dropped), d.defaultTags, 1)
+	}
+	d.enqueue(f)
+}
+
+// DecisionCacheLookup counts lookups of the decision cache, tagged with whether a cached decision was found
+func (d *DataDogReporter) DecisionCacheLookup(hit bool) {
+	f := func() {
+		tags := append([]string{hitKey + ":" + strconv.FormatBool(hit)}, d.defaultTags...)
+		d.client.Count(decisionCacheLookupMetricName, 1, tags, 1)
+	}
+	d.enqueue(f)
+}
+
+// ExemptedRequest counts a request exempted from rate limiting, tagged with the exemption it matched
+func (d *DataDogReporter) ExemptedRequest(exemption string) {
+	f := func() {
+		tags := append([]string{exemptionKey + ":" + exemption}, d.defaultTags...)
+		d.client.Incr(reqExemptMetricName, tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
+// TrafficWindow reports the requests and unique keys of a route in a completed traffic profile window, as histograms
+// giving the shape of its traffic across windows and instances
+func (d *DataDogReporter) TrafficWindow(route string, requests uint64, uniqueKeys uint64) {
+	f := func() {
+		tags := append([]string{routeKey + ":" + route}, d.defaultTags...)
+		d.client.Histogram(trafficRequestsMetricName, float64(requests), tags, 1.0)
+		d.client.Histogram(trafficUniqueKeysMetricName, float64(uniqueKeys), tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
+// KeyDecisions counts the decisions of a key attributed to a rule since the last KeyMetrics flush, tagged with the
+// key, the rule, and whether they were blocked
+func (d *DataDogReporter) KeyDecisions(rule string, key string, allowed uint64, blocked uint64) {
+	f := func() {
+		tags := func(blocked bool) []string {
+			return append([]string{ruleKey + ":" + rule, keyKey + ":" + key, blockedKey + ":" + strconv.FormatBool(blocked)}, d.defaultTags...)
+		}
+		if allowed > 0 {
+			d.client.Count(reqKeyMetricName, int64(allowed), tags(false), 1.0)
+		}
+		if blocked > 0 {
+			d.client.Count(reqKeyMetricName, int64(blocked), tags(true), 1.0)
+		}
+	}
+	d.enqueue(f)
+}
+
+// UnknownDomain reports a request for a domain that isn't served and the action taken on it
+func (d *DataDogReporter) UnknownDomain(domain string, action UnknownDomainAction) {
+	f := func() {
+		tags := append([]string{domainKey + ":" + domain, actionKey + ":" + string(action)}, d.defaultTags...)
+		d.client.Incr(reqUnknownDomainMetricName, tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
+// InvalidRequest reports a malformed request, why it's invalid, and the action taken on it
+func (d *DataDogReporter) InvalidRequest(reason InvalidRequestReason, action InvalidRequestAction) {
+	f := func() {
+		tags := append([]string{reasonKey + ":" + string(reason), actionKey + ":" + string(action)}, d.defaultTags...)
+		d.client.Incr(reqInvalidMetricName, tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
+// ListDecision counts a request decided by the whitelist and blacklist alone, tagged with the decision
+func (d *DataDogReporter) ListDecision(decision ListDecision, errorOccurred bool) {
+	f := func() {
+		tags := append([]string{decisionKey + ":" + string(decision), errorKey + ":" + strconv.FormatBool(errorOccurred)}, d.defaultTags...)
+		d.client.Incr(reqListDecisionMetricName, tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
+// SelfTest reports the duration of a self test probe, tagged with whether it passed
+func (d *DataDogReporter) SelfTest(duration time.Duration, passed bool) {
+	f := func() {
+		tags := append([]string{passedKey + ":" + strconv.FormatBool(passed)}, d.defaultTags...)
+		d.client.TimeInMilliseconds(selfTestMetricName, float64(duration/time.Millisecond), tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
+// ThrottleWebhook counts the notifications of a throttle webhook post, tagged with whether it failed
+func (d *DataDogReporter) ThrottleWebhook(notifications int, errorOccurred bool) {
+	f := func() {
+		tags := append([]string{errorKey + ":" + strconv.FormatBool(errorOccurred)}, d.defaultTags...)
+		d.client.Count(throttleWebhookMetricName, int64(notifications), tags, 1.0)
+	}
+	d.enqueue(f)
+}
+
 func (d *DataDogReporter) CurrentLimit(limit Limit) {
 	f := func() {
 		enabled := 0
@@ -143,18 +531,25 @@ func (d *DataDogReporter) CurrentLimit(limit Limit) {
 		d.client.Gauge(rateLimitCountMetricName, float64(limit.Count), d.defaultTags, 1)
 		d.client.Gauge(rateLimitDurationMetricName, float64(limit.Duration), d.defaultTags, 1)
 		d.client.Gauge(rateLimitEnabledMetricName, float64(enabled), d.defaultTags, 1)
+
+		enforcePercent := limit.EnforcePercent
+		if enforcePercent == 0 {
+			enforcePercent = MaxEnforcePercent
+		}
+		d.client.Gauge(rateLimitEnforcePercentMetricName, float64(enforcePercent), d.defaultTags, 1)
+		d.client.Gauge(rateLimitRolloverMetricName, float64(limit.Rollover), d.defaultTags, 1)
 	}
 	d.enqueue(f)
 }
 
-func (d *DataDogReporter) CurrentWhitelist(whitelist []net.IPNet) {
+func (d *DataDogReporter) CurrentWhitelist(whitelist []netip.Prefix) {
 	f := func() {
 		d.client.Gauge(whitelistCountMetricName, float64(len(whitelist)), d.defaultTags, 1)
 	}
 	d.enqueue(f)
 }
 
-func (d *DataDogReporter) CurrentBlacklist(blacklist []net.IPNet) {
+func (d *DataDogReporter) CurrentBlacklist(blacklist []netip.Prefix) {
 	f := func() {
 		d.client.Gauge(blacklistCountMetricName, float64(len(blacklist)), d.defaultTags, 1)
 	}
@@ -172,17 +567,80 @@ func (d *DataDogReporter) CurrentReportOnlyMode(reportOnly bool) {
 	d.enqueue(f)
 }
 
+// CurrentReportOnlyOverride reports whether report only mode is overridden by an EnforcementOverride
+func (d *DataDogReporter) CurrentReportOnlyOverride(overridden bool) {
+	f := func() {
+		value := 0
+		if overridden {
+			value = 1
+		}
+		d.client.Gauge(reportOnlyOverrideMetricName, float64(value), d.defaultTags, 1)
+	}
+	d.enqueue(f)
+}
+
+func (d *DataDogReporter) ConfSync(rejected bool) {
+	f := func() {
+		value := 0
+		if rejected {
+			value = 1
+		}
+		d.client.Gauge(confSyncRejectedMetricName, float64(value), d.defaultTags, 1)
+	}
+	d.enqueue(f)
+}
+
+// CurrentLeader reports whether this instance is the elected leader
+func (d *DataDogReporter) CurrentLeader(leader bool) {
+	f := func() {
+		value := 0
+		if leader {
+			value = 1
+		}
+		d.client.Gauge(leaderMetricName, float64(value), d.defaultTags, 1)
+	}
+	d.enqueue(f)
+}
+
+// SLOBurnRate reports how many times faster than its objective allows the error budget of an SLO burned over window
+func (d *DataDogReporter) SLOBurnRate(slo string, window time.Duration, burnRate float64) {
+	f := func() {
+		tags := append([]string{sloKey + ":" + slo, windowKey + ":" + sloWindowTag(window)}, d.defaultTags...)
+		d.client.Gauge(sloBurnRateMetricName, burnRate, tags, 1)
+	}
+	d.enqueue(f)
+}
+
+// SLOAlerting reports whether an SLO is alerting
+func (d *DataDogReporter) SLOAlerting(slo string, alerting bool) {
+	f := func() {
+		value := 0
+		if alerting {
+			value = 1
+		}
+		tags := append([]string{sloKey + ":" + slo}, d.defaultTags...)
+		d.client.Gauge(sloAlertingMetricName, float64(value), tags, 1)
+	}
+	d.enqueue(f)
+}
+
+// ruleTags returns the tags of metrics about requests matching rule: its name, its action, and its extra tags
+func ruleTags(rule Rule) []string {
+	return append([]string{ruleKey + ":" + rule.Name, actionKey + ":" + string(rule.Action)}, rule.Tags...)
+}
+
+// enqueue queues f to be run by Run, or counts it as dropped if the queue is full
 func (d *DataDogReporter) enqueue(f func()) {
 	select {
 	case d.c <- f:
 	default:
-		d.logger.Error("buffered channel full -- discarding metric")
+		atomic.AddUint64(&d.dropped, 1)
 	}
 }
 
 type NullReporter struct{}
 
-func (n NullReporter) Duration(request Request, blocked bool, errorOccured bool, duration time.Duration) {
+func (n NullReporter) Duration(request Request, rule *Rule, blocked bool, errorOccured bool, duration time.Duration) {
 }
 
 func (n NullReporter) HandledWhitelist(request Request, whitelisted bool, errorOccured bool, duration time.Duration) {
@@ -195,19 +653,106 @@ func (n NullReporter) HandledBlacklist(request Request, blacklisted bool, errorO
 func (n NullReporter) HandledRatelimit(request Request, ratelimited bool, errorOccured bool, duration time.Duration) {
 }
 
+func (n NullReporter) HandledRatelimitCanary(request Request, enforced bool) {
+}
+
+func (n NullReporter) LimitExperiment(request Request, primary Limit, primaryBlocked bool, alternate Limit, alternateBlocked bool) {
+}
+
+func (n NullReporter) HandledRule(request Request, rule Rule, blocked bool, errorOccurred bool, duration time.Duration) {
+}
+
+func (n NullReporter) ObservedRule(request Request, rule Rule, count uint64, overLimit bool) {
+}
+
+func (n NullReporter) RuleKeyCardinalityExceeded(rule Rule, estimate uint64) {
+}
+
+func (n NullReporter) HandledRouteRatelimit(request Request, route string, ratelimited bool, errorOccurred bool, duration time.Duration) {
+}
+
+func (n NullReporter) Bulkhead(name string, saturation float64, rejected bool) {
+}
+
 func (n NullReporter) RedisCounterIncr(duration time.Duration, errorOccurred bool) {
 }
+
+func (n NullReporter) RedisCounterHedged(command string, hedgeWon bool) {
+}
 func (n NullReporter) RedisCounterPruned(duration time.Duration, cacheSize float64, prunedCounted float64) {
 }
 
+func (n NullReporter) RedisMemoryBudget(used float64, budget float64, shortened float64, errorOccurred bool) {
+}
+
+func (n NullReporter) RedisCounterJanitor(duration time.Duration, scanned float64, orphans float64, errorOccurred bool) {
+}
+
+func (n NullReporter) RedisCounterSpillMerged(duration time.Duration, merged float64, errorOccurred bool) {
+}
+
+func (n NullReporter) ReputationLookup(duration time.Duration, errorOccurred bool) {
+}
+
+func (n NullReporter) PlanLookup(duration time.Duration, errorOccurred bool) {
+}
+
+func (n NullReporter) WhitelistHostLookup(duration time.Duration, errorOccurred bool) {
+}
+
+func (n NullReporter) DecisionStreamDropped(dropped int) {
+}
+
+func (n NullReporter) DecisionCacheLookup(hit bool) {
+}
+
+func (n NullReporter) ExemptedRequest(exemption string) {
+}
+
+func (n NullReporter) TrafficWindow(route string, requests uint64, uniqueKeys uint64) {
+}
+
+func (n NullReporter) KeyDecisions(rule string, key string, allowed uint64, blocked uint64) {
+}
+
+func (n NullReporter) UnknownDomain(domain string, action UnknownDomainAction) {
+}
+
+func (n NullReporter) InvalidRequest(reason InvalidRequestReason, action InvalidRequestAction) {
+}
+
+func (n NullReporter) ListDecision(decision ListDecision, errorOccurred bool) {
+}
+
+func (n NullReporter) SelfTest(duration time.Duration, passed bool) {
+}
+
+func (n NullReporter) ThrottleWebhook(notifications int, errorOccurred bool) {
+}
+
 func (n NullReporter) CurrentLimit(limit Limit) {
 }
 
-func (n NullReporter) CurrentWhitelist(whitelist []net.IPNet) {
+func (n NullReporter) CurrentWhitelist(whitelist []netip.Prefix) {
 }
 
-func (n NullReporter) CurrentBlacklist(blacklist []net.IPNet) {
+func (n NullReporter) CurrentBlacklist(blacklist []netip.Prefix) {
 }
 
 func (n NullReporter) CurrentReportOnlyMode(reportOnly bool) {
 }
+
+func (n NullReporter) CurrentReportOnlyOverride(overridden bool) {
+}
+
+func (n NullReporter) ConfSync(rejected bool) {
+}
+
+func (n NullReporter) CurrentLeader(leader bool) {
+}
+
+func (n NullReporter) SLOBurnRate(slo string, window time.Duration, burnRate float64) {
+}
+
+func (n NullReporter) SLOAlerting(slo string, alerting bool) {
+}
@@ -2,9 +2,10 @@ package guardian
 
 import (
 	"fmt"
-	"net"
+	"net/netip"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -19,7 +20,7 @@ func TestDatadogReportSetsDefaultTags(t *testing.T) {
 	}
 
 	defaultTags := []string{"default1:tag1", "default2:tag2"}
-	reporter := NewDataDogReporter(client, defaultTags, TestingLogger)
+	reporter := NewDataDogReporter(client, defaultTags, DefaultMetricBufferSize, TestingLogger)
 
 	stop := make(chan struct{})
 	defer close(stop)
@@ -30,22 +31,23 @@ func TestDatadogReportSetsDefaultTags(t *testing.T) {
 
 	req := Request{}
 
-	reporter.Duration(req, false, false, time.Second)
+	reporter.Duration(req, nil, false, false, time.Second)
 	reporter.HandledWhitelist(req, true, false, time.Second)
 	reporter.HandledRatelimit(req, true, false, time.Second)
 	reporter.RedisCounterIncr(time.Second, false)
 	reporter.RedisCounterPruned(time.Second, 100, 20)
 	reporter.CurrentLimit(Limit{})
-	reporter.CurrentWhitelist([]net.IPNet{})
+	reporter.CurrentWhitelist([]netip.Prefix{})
 	reporter.CurrentReportOnlyMode(false)
 
 	time.Sleep(time.Second) // wait for all the go funcs to run
 
-	if len(writer.received) == 0 {
-		t.Fatalf("expected: %v, received: %v", "> 0", len(writer.received))
+	received := writer.stats()
+	if len(received) == 0 {
+		t.Fatalf("expected: %v, received: %v", "> 0", len(received))
 	}
 
-	for _, stat := range writer.received {
+	for _, stat := range received {
 		if !contains(stat.tags, defaultTags) {
 			t.Fatalf("expected contains: %v, received: %v", defaultTags, stat.tags)
 		}
@@ -76,7 +78,7 @@ func TestDatadogReportsAllDurations(t *testing.T) {
 	}
 
 	defaultTags := []string{"default1:tag1", "default2:tag2"}
-	reporter := NewDataDogReporter(client, defaultTags, TestingLogger)
+	reporter := NewDataDogReporter(client, defaultTags, DefaultMetricBufferSize, TestingLogger)
 
 	stop := make(chan struct{})
 	defer close(stop)
@@ -85,14 +87,78 @@ func TestDatadogReportsAllDurations(t *testing.T) {
 		reporter.Run(stop)
 	}()
 
-	reporter.Duration(Request{Authority: "one"}, false, false, time.Second)
-	reporter.Duration(Request{Authority: "two"}, false, false, time.Second)
-	reporter.Duration(Request{Authority: "three"}, false, false, time.Second)
-	reporter.Duration(Request{Authority: "four"}, false, false, time.Second)
+	reporter.Duration(Request{Authority: "one"}, nil, false, false, time.Second)
+	reporter.Duration(Request{Authority: "two"}, nil, false, false, time.Second)
+	reporter.Duration(Request{Authority: "three"}, nil, false, false, time.Second)
+	reporter.Duration(Request{Authority: "four"}, nil, false, false, time.Second)
 	time.Sleep(time.Second) // wait for stats to send
 
-	if len(writer.received) != 4 {
-		t.Fatalf("expected: %v, received: %v", 4, len(writer.received))
+	received := writer.stats()
+	if len(received) != 4 {
+		t.Fatalf("expected: %v, received: %v", 4, len(received))
+	}
+}
+
+func TestDatadogReportsRuleTags(t *testing.T) {
+	writer := &testStatsdWriter{}
+	client, err := statsd.NewWithWriter(writer)
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	reporter := NewDataDogReporter(client, []string{"default1:tag1"}, DefaultMetricBufferSize, TestingLogger)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		reporter.Run(stop)
+	}()
+
+	rule := Rule{Name: "export", Action: LimitAction, Tags: []string{"team:data"}}
+	reporter.Duration(Request{}, &rule, true, false, time.Second)
+	reporter.HandledRule(Request{}, rule, true, false, time.Second)
+	time.Sleep(time.Second) // wait for stats to send
+
+	received := writer.stats()
+	if len(received) != 2 {
+		t.Fatalf("expected: %v, received: %v", 2, len(received))
+	}
+
+	want := []string{"rule:export", "action:limit", "team:data", "default1:tag1"}
+	for _, stat := range received {
+		if !contains(stat.tags, want) {
+			t.Errorf("expected %v to contain: %v, received: %v", stat.name, want, stat.tags)
+		}
+	}
+}
+
+func TestDatadogReporterDropsAndFlushes(t *testing.T) {
+	writer := &testStatsdWriter{}
+	client, err := statsd.NewWithWriter(writer)
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	reporter := NewDataDogReporter(client, []string{"default1:tag1"}, 2, TestingLogger)
+	for i := 0; i < 5; i++ {
+		reporter.Duration(Request{}, nil, false, false, time.Second) // never blocks, even though nothing is emitting
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	reporter.Run(stop) // returns once the queue is flushed
+
+	counts := map[string]int{}
+	received := writer.stats()
+	for _, stat := range received {
+		counts[stat.name]++
+		if stat.name == metricsDroppedMetricName && stat.value != "3" {
+			t.Errorf("expected 3 dropped metrics, received: %v", stat.value)
+		}
+	}
+
+	if counts[durationMetricName] != 2 || counts[metricsDroppedMetricName] != 1 {
+		t.Fatalf("expected 2 durations and the dropped count, received: %v", counts)
 	}
 }
 
@@ -114,11 +180,20 @@ type stat struct {
 	tags       []tag
 }
 
+// testStatsdWriter records the stats written by the statsd client, which writes from its own goroutine
 type testStatsdWriter struct {
+	mu       sync.Mutex
 	received []stat
 }
 
-func (ts testStatsdWriter) parseStat(str string) (stat, error) {
+// stats returns a copy of the stats written so far
+func (ts *testStatsdWriter) stats() []stat {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]stat(nil), ts.received...)
+}
+
+func (ts *testStatsdWriter) parseStat(str string) (stat, error) {
 	stat := stat{}
 	comps := strings.Split(str, "|")
 	if len(comps) < 2 {
@@ -166,7 +241,9 @@ func (ts *testStatsdWriter) Write(data []byte) (n int, err error) {
 			continue
 		}
 
+		ts.mu.Lock()
 		ts.received = append(ts.received, stat)
+		ts.mu.Unlock()
 	}
 
 	return len(data), nil
@@ -0,0 +1,198 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// operationMatchCacheSize is the number of recently requested methods and paths whose matched operation is cached
+const operationMatchCacheSize = 4096
+
+// openAPIMethods are the methods an OpenAPI path item can define operations for
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// OpenAPIOperation is an operation of an OpenAPI spec, matched by its method and path template
+type OpenAPIOperation struct {
+	ID     string
+	Method string
+	Route  RoutePattern
+}
+
+type openAPISpec struct {
+	BasePath string                                `json:"basePath"` // Swagger 2.0
+	Servers  []openAPIServer                       `json:"servers"`  // OpenAPI 3
+	Paths    map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIOperationObject struct {
+	OperationID string `json:"operationId"`
+}
+
+// ParseOpenAPISpec parses the operations of a JSON OpenAPI 3 or Swagger 2.0 spec. Paths are prefixed with the
+// basePath of a Swagger spec, or the paths of the servers of an OpenAPI spec. Operations without an operationId
+// are skipped.
+func ParseOpenAPISpec(data []byte) (*OpenAPIOperations, error) {
+	spec := openAPISpec{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, errors.Wrap(err, "error decoding openapi spec")
+	}
+
+	prefixes, err := spec.prefixes()
+	if err != nil {
+		return nil, err
+	}
+
+	operations := []OpenAPIOperation{}
+	ids := map[string]string{}
+	for path, item := range spec.Paths {
+		for _, method := range openAPIMethods {
+			raw, ok := item[method]
+			if !ok {
+				continue
+			}
+
+			op := openAPIOperationObject{}
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("error decoding openapi operation %v %v", strings.ToUpper(method), path))
+			}
+
+			if len(op.OperationID) == 0 {
+				continue
+			}
+
+			if other, ok := ids[op.OperationID]; ok {
+				return nil, fmt.Errorf("openapi operation id %v of %v %v is also used by %v", op.OperationID, strings.ToUpper(method), path, other)
+			}
+			ids[op.OperationID] = strings.ToUpper(method) + " " + path
+
+			for _, prefix := range prefixes {
+				route, err := ParseRoutePattern(prefix + path)
+				if err != nil {
+					return nil, errors.Wrap(err, fmt.Sprintf("invalid path of openapi operation %v", op.OperationID))
+				}
+				operations = append(operations, OpenAPIOperation{ID: op.OperationID, Method: strings.ToUpper(method), Route: route})
+			}
+		}
+	}
+
+	return NewOpenAPIOperations(operations), nil
+}
+
+// LoadOpenAPISpec parses the operations of the JSON OpenAPI spec at path
+func LoadOpenAPISpec(path string) (*OpenAPIOperations, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := ParseOpenAPISpec(b)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error parsing openapi spec %v", path))
+	}
+
+	return ops, nil
+}
+
+// prefixes returns the prefixes of the spec's paths, an empty prefix if it has none
+func (s openAPISpec) prefixes() ([]string, error) {
+	paths := []string{strings.TrimSuffix(s.BasePath, "/")}
+	if len(s.Servers) > 0 {
+		paths = []string{}
+	}
+
+	seen := map[string]bool{}
+	for _, server := range s.Servers {
+		u, err := url.Parse(server.URL)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid openapi server url %v", server.URL))
+		}
+
+		path := strings.TrimSuffix(u.Path, "/")
+		if strings.ContainsAny(path, "{}") {
+			return nil, fmt.Errorf("openapi server url %v has variables in its path, which aren't supported", server.URL)
+		}
+
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	return paths, nil
+}
+
+// NewOpenAPIOperations creates new OpenAPIOperations
+func NewOpenAPIOperations(operations []OpenAPIOperation) *OpenAPIOperations {
+	sorted := append([]OpenAPIOperation{}, operations...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Route.moreSpecific(sorted[j].Route)
+	})
+
+	return &OpenAPIOperations{operations: sorted, cache: newLRUCache(operationMatchCacheSize)}
+}
+
+// OpenAPIOperations maps requests to the operations of an OpenAPI spec. Like route limits, concrete paths such as
+// /users/me are matched before templated ones such as /users/{id}.
+type OpenAPIOperations struct {
+	operations []OpenAPIOperation
+
+	mu    sync.Mutex
+	cache *lruCache // method and normalized path to operation id
+}
+
+// Operations returns the operations, in the order they are matched
+func (o *OpenAPIOperations) Operations() []OpenAPIOperation {
+	return append([]OpenAPIOperation{}, o.operations...)
+}
+
+// Match returns the id of the operation matching method and path, or false if none do
+func (o *OpenAPIOperations) Match(method string, path string) (string, bool) {
+	method = strings.ToUpper(method)
+	normalized := normalizeRoutePath(path)
+	key := method + " " + normalized
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if cached, ok := o.cache.get(key); ok {
+		id := cached.(string)
+		return id, len(id) > 0
+	}
+
+	id := ""
+	segments := splitPath(normalized)
+	for _, op := range o.operations {
+		if op.Method == method && op.Route.matchSegments(segments) {
+			id = op.ID
+			break
+		}
+	}
+
+	o.cache.add(key, id)
+	return id, len(id) > 0
+}
+
+// WithOpenAPIOperation wraps f, setting the Operation of requests to the id of the operation of ops they match so
+// rules can match it with req.operation and count by it with the {operation} key template placeholder. Requests that
+// don't match an operation have an empty operation.
+func WithOpenAPIOperation(f CondRequestBlockerFunc, ops *OpenAPIOperations) CondRequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, bool, uint32, error) {
+		operation, found := ops.Match(r.Method, r.Path)
+		tracef(c, "openapi operation %q, found: %v", operation, found)
+		r.Operation = operation
+
+		return f(c, r)
+	}
+}
@@ -0,0 +1,139 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const testOpenAPISpec = `{
+	"openapi": "3.0.0",
+	"servers": [{"url": "https://api.example.com/v1"}],
+	"paths": {
+		"/users/{id}": {
+			"get": {"operationId": "getUser"},
+			"delete": {"operationId": "deleteUser"},
+			"parameters": [{"name": "id", "in": "path"}]
+		},
+		"/users/me": {"get": {"operationId": "getCurrentUser"}},
+		"/orders": {"post": {"operationId": "createOrder"}, "get": {"summary": "no operation id"}}
+	}
+}`
+
+func TestParseOpenAPISpec(t *testing.T) {
+	ops, err := ParseOpenAPISpec([]byte(testOpenAPISpec))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: "GET", path: "/v1/users/42", want: "getUser"},
+		{method: "delete", path: "/v1/users/42/", want: "deleteUser"},
+		{method: "GET", path: "/v1/users/me?fields=name", want: "getCurrentUser"},
+		{method: "POST", path: "/v1/orders", want: "createOrder"},
+		{method: "GET", path: "/v1/orders", want: ""},
+		{method: "GET", path: "/users/42", want: ""},
+		{method: "GET", path: "/v1/users/42/orders", want: ""},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 2; i++ { // the second match is cached
+			got, ok := ops.Match(test.method, test.path)
+			if got != test.want || ok != (len(test.want) > 0) {
+				t.Errorf("%v %v expected: %q received: (%q, %v)", test.method, test.path, test.want, got, ok)
+			}
+		}
+	}
+}
+
+func TestParseOpenAPISpecSwaggerBasePath(t *testing.T) {
+	ops, err := ParseOpenAPISpec([]byte(`{"swagger": "2.0", "basePath": "/api/", "paths": {"/pets": {"get": {"operationId": "listPets"}}}}`))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if got, ok := ops.Match("GET", "/api/pets"); !ok || got != "listPets" {
+		t.Fatalf("expected: listPets received: (%q, %v)", got, ok)
+	}
+}
+
+func TestParseOpenAPISpecRejectsInvalid(t *testing.T) {
+	specs := []string{
+		`not json`,
+		`{"paths": {"users": {"get": {"operationId": "getUsers"}}}}`,
+		`{"paths": {"/a": {"get": {"operationId": "dup"}}, "/b": {"get": {"operationId": "dup"}}}}`,
+		`{"servers": [{"url": "https://{region}.example.com/{version}"}], "paths": {}}`,
+	}
+
+	for _, spec := range specs {
+		if _, err := ParseOpenAPISpec([]byte(spec)); err == nil {
+			t.Errorf("expected an error for spec %v", spec)
+		}
+	}
+}
+
+func TestWithOpenAPIOperation(t *testing.T) {
+	ops, err := ParseOpenAPISpec([]byte(testOpenAPISpec))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	rule := mustParseRule(t, "orders", RuleDocument{
+		When:        `req.operation == "createOrder" || req.operation == "getUser"`,
+		Action:      "limit",
+		Limit:       &LimitDocument{Count: 1, Duration: "1m", Enabled: true},
+		KeyTemplate: "{operation}:{ip}",
+	})
+	now := time.Unix(1522895021, 0)
+	store := &FakeLimitStore{count: make(map[string]uint64)}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: []Rule{rule}}, store, &fixedClock{now}, nil, nil, TestingLogger, NullReporter{})
+	evaluate := WithOpenAPIOperation(re.Evaluate, ops)
+
+	tests := []struct {
+		method  string
+		path    string
+		blocked bool
+	}{
+		{method: "POST", path: "/v1/orders", blocked: false},
+		{method: "GET", path: "/v1/users/1", blocked: false},
+		{method: "GET", path: "/v1/users/2", blocked: true}, // counted by operation, not path
+		{method: "POST", path: "/v1/orders", blocked: true},
+		{method: "GET", path: "/v1/users/me", blocked: false},
+		{method: "GET", path: "/v1/users/me", blocked: false},
+	}
+
+	for i, test := range tests {
+		_, blocked, _, err := evaluate(context.Background(), Request{RemoteAddress: "192.168.1.2", Method: test.method, Path: test.path})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if blocked != test.blocked {
+			t.Errorf("request %d %v %v expected blocked: %v received: %v", i, test.method, test.path, test.blocked, blocked)
+		}
+	}
+
+	expected := NamespacedKey(ruleNamespace, "orders") + ":createOrder:192.168.1.2:60:1522894980"
+	if store.count[expected] != 2 {
+		t.Fatalf("expected requests to be counted under %v, received: %v", expected, store.count)
+	}
+
+	// requests matching no operation share a key rather than being counted by path
+	unmatched := mustParseRule(t, "unmatched", RuleDocument{When: `req.operation == ""`, Action: "limit", Limit: &LimitDocument{Count: 1, Duration: "1m", Enabled: true}, KeyTemplate: "{operation}:{ip}"})
+	re = NewRuleEvaluator(&FakeRuleProvider{rules: []Rule{unmatched}}, store, &fixedClock{now}, nil, nil, TestingLogger, NullReporter{})
+	evaluate = WithOpenAPIOperation(re.Evaluate, ops)
+	for i, path := range []string{"/v1/unknown/1", "/v1/unknown/2"} {
+		_, blocked, _, err := evaluate(context.Background(), Request{RemoteAddress: "192.168.1.2", Method: "GET", Path: path})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if want := i > 0; blocked != want {
+			t.Errorf("request %d %v expected blocked: %v received: %v", i, path, want, blocked)
+		}
+	}
+}
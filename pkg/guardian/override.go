@@ -0,0 +1,134 @@
+package guardian
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dollarshaveclub/guardian/pkg/rate_limit_grpc"
+)
+
+// NewEnforcementOverride creates a new EnforcementOverride wrapping provider, initially not overriding it
+func NewEnforcementOverride(provider ReportOnlyProvider, logger logrus.FieldLogger) *EnforcementOverride {
+	return &EnforcementOverride{provider: provider, logger: logger}
+}
+
+// EnforcementOverride is a panic button putting this instance in report only mode instantly, without touching Redis,
+// e.g. when a bad limit is blocking legitimate traffic and Redis is unavailable or conf sync is too slow. While the
+// override is off report only mode is decided by provider.
+type EnforcementOverride struct {
+	provider   ReportOnlyProvider
+	logger     logrus.FieldLogger
+	reportOnly int32 // accessed atomically, 1 when overridden
+}
+
+// SetReportOnly overrides the provider's report only mode if reportOnly is true, or stops overriding it
+func (o *EnforcementOverride) SetReportOnly(reportOnly bool) {
+	var v int32
+	if reportOnly {
+		v = 1
+	}
+
+	if atomic.SwapInt32(&o.reportOnly, v) != v {
+		o.logger.Warnf("report only override set to %v", reportOnly)
+	}
+}
+
+// GetReportOnly returns whether blocking every request should only be reported
+func (o *EnforcementOverride) GetReportOnly() bool {
+	return o.ReportOnlyOverridden() || o.provider.GetReportOnly()
+}
+
+// GetReportOnlyForRequest returns whether blocking request should only be reported
+func (o *EnforcementOverride) GetReportOnlyForRequest(request Request) bool {
+	return o.ReportOnlyOverridden() || reportOnlyForRequest(o.provider, request)
+}
+
+// ReportOnlyOverridden returns whether report only mode is overridden
+func (o *EnforcementOverride) ReportOnlyOverridden() bool {
+	return atomic.LoadInt32(&o.reportOnly) == 1
+}
+
+type enforcementResponse struct {
+	ReportOnly         bool `json:"report_only"`
+	ReportOnlyOverride bool `json:"report_only_override"`
+}
+
+type enforcementRequest struct {
+	ReportOnlyOverride *bool `json:"report_only_override"`
+}
+
+// NewEnforcementHandler creates a new EnforcementHandler
+func NewEnforcementHandler(override *EnforcementOverride, logger logrus.FieldLogger) *EnforcementHandler {
+	return &EnforcementHandler{override: override, logger: logger}
+}
+
+// EnforcementHandler is an admin HTTP handler serving the report only override on GET and setting it on POST
+type EnforcementHandler struct {
+	override *EnforcementOverride
+	logger   logrus.FieldLogger
+}
+
+func (h *EnforcementHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		body := enforcementRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error decoding request body: %v", err), h.logger)
+			return
+		}
+
+		if body.ReportOnlyOverride == nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("report_only_override is required"), h.logger)
+			return
+		}
+
+		h.override.SetReportOnly(*body.ReportOnlyOverride)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method), h.logger)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, enforcementResponse{ReportOnly: h.override.GetReportOnly(), ReportOnlyOverride: h.override.ReportOnlyOverridden()}, h.logger)
+}
+
+type readyResponse struct {
+	Ready bool `json:"ready"`
+	enforcementResponse
+}
+
+// NewReadyHandler creates a new ReadyHandler
+func NewReadyHandler(health rate_limit_grpc.HealthCheckServer, override *EnforcementOverride, logger logrus.FieldLogger) *ReadyHandler {
+	return &ReadyHandler{health: health, override: override, logger: logger}
+}
+
+// ReadyHandler is an admin HTTP handler responding 200 while the rate limit service is serving and 503 once it's
+// shutting down, along with whether blocking is only reported and whether that is overridden
+type ReadyHandler struct {
+	health   rate_limit_grpc.HealthCheckServer
+	override *EnforcementOverride
+	logger   logrus.FieldLogger
+}
+
+func (h *ReadyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method), h.logger)
+		return
+	}
+
+	res := readyResponse{enforcementResponse: enforcementResponse{ReportOnly: h.override.GetReportOnly(), ReportOnlyOverride: h.override.ReportOnlyOverridden()}}
+	if check, err := h.health.Check(r.Context(), &rate_limit_grpc.HealthCheckRequest{}); err == nil {
+		res.Ready = check.Status == rate_limit_grpc.HealthCheckResponse_SERVING
+	}
+
+	status := http.StatusOK
+	if !res.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, res, h.logger)
+}
@@ -0,0 +1,103 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+
+	"github.com/dollarshaveclub/guardian/pkg/rate_limit_grpc"
+)
+
+type FakeReportOnlyOverrideReporter struct {
+	NullReporter
+	overridden []bool
+}
+
+func (f *FakeReportOnlyOverrideReporter) CurrentReportOnlyOverride(overridden bool) {
+	f.overridden = append(f.overridden, overridden)
+}
+
+func TestEnforcementOverride(t *testing.T) {
+	override := NewEnforcementOverride(StaticReportOnlyProvider{false}, TestingLogger)
+	reporter := &FakeReportOnlyOverrideReporter{}
+	server := NewServer(func(c context.Context, r Request) (bool, uint32, error) {
+		return true, 0, nil
+	}, override, "", 0, TestingLogger, reporter)
+
+	override.SetReportOnly(true)
+	if !override.GetReportOnly() || !override.GetReportOnlyForRequest(Request{RemoteAddress: "192.168.1.2"}) {
+		t.Error("expected the override to put every request in report only mode")
+	}
+
+	if res, _ := server.ShouldRateLimit(context.Background(), newRateLimitRequest()); res.OverallCode != ratelimit.RateLimitResponse_OK {
+		t.Errorf("expected blocking to only be reported, received: %v", res.OverallCode)
+	}
+
+	override.SetReportOnly(false)
+	if override.GetReportOnly() {
+		t.Error("expected the provider's report only mode once the override is off")
+	}
+
+	if res, _ := server.ShouldRateLimit(context.Background(), newRateLimitRequest()); res.OverallCode != ratelimit.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("expected blocking to be enforced, received: %v", res.OverallCode)
+	}
+
+	if len(reporter.overridden) != 2 || !reporter.overridden[0] || reporter.overridden[1] {
+		t.Errorf("expected the override to be reported, received: %v", reporter.overridden)
+	}
+}
+
+func TestEnforcementHandler(t *testing.T) {
+	override := NewEnforcementOverride(StaticReportOnlyProvider{false}, TestingLogger)
+	handler := NewEnforcementHandler(override, TestingLogger)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/enforcement", strings.NewReader(`{"report_only_override": true}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, received: %v %v", rec.Code, rec.Body.String())
+	}
+
+	if !override.ReportOnlyOverridden() {
+		t.Error("expected the override to be set")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/enforcement", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without report_only_override, received: %v", rec.Code)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	override := NewEnforcementOverride(StaticReportOnlyProvider{false}, TestingLogger)
+	override.SetReportOnly(true)
+	health := rate_limit_grpc.NewHealthServer()
+	handler := NewReadyHandler(health, override, TestingLogger)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, received: %v", rec.Code)
+	}
+
+	res := readyResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if !res.Ready || !res.ReportOnly || !res.ReportOnlyOverride {
+		t.Errorf("expected ready with report only overridden, received: %+v", res)
+	}
+
+	health.Shutdown()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 once shutting down, received: %v", rec.Code)
+	}
+}
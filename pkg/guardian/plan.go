@@ -0,0 +1,177 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const maxConcurrentPlanLookups = 100
+
+// PlanResolver resolves the plan tier of an API key, e.g. free or pro. Keys without a plan have an empty tier.
+type PlanResolver interface {
+	Tier(context context.Context, apiKey string) (string, error)
+}
+
+// NewHTTPPlanResolver creates a new HTTPPlanResolver
+func NewHTTPPlanResolver(endpoint string, client *http.Client) *HTTPPlanResolver {
+	return &HTTPPlanResolver{endpoint: endpoint, client: client}
+}
+
+// HTTPPlanResolver is a PlanResolver that looks up tiers with GET <endpoint>?key=<api key>, expecting a JSON response
+// such as {"tier": "pro"}. A 404 response means the key has no plan.
+type HTTPPlanResolver struct {
+	endpoint string
+	client   *http.Client
+}
+
+type planResponse struct {
+	Tier string `json:"tier"`
+}
+
+func (h *HTTPPlanResolver) Tier(context context.Context, apiKey string) (string, error) {
+	target := h.endpoint + "?key=" + url.QueryEscape(apiKey)
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating plan request")
+	}
+
+	// errors don't include the key, which is a credential
+	res, err := h.client.Do(req.WithContext(context))
+	if err != nil {
+		return "", errors.Wrap(err, "error requesting plan")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d requesting plan", res.StatusCode)
+	}
+
+	body := planResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", errors.Wrap(err, "error decoding plan")
+	}
+
+	return body.Tier, nil
+}
+
+// NewPlanCache creates a new PlanCache caching the tiers of at most size API keys
+func NewPlanCache(resolver PlanResolver, size int, ttl time.Duration, timeout time.Duration, logger logrus.FieldLogger, reporter MetricReporter) *PlanCache {
+	return &PlanCache{
+		resolver: resolver,
+		ttl:      ttl,
+		timeout:  timeout,
+		logger:   logger,
+		reporter: reporter,
+		entries:  newLRUCache(size),
+		inflight: make(map[string]bool),
+		sem:      make(chan struct{}, maxConcurrentPlanLookups),
+	}
+}
+
+type planEntry struct {
+	tier     string
+	expireAt time.Time
+}
+
+// PlanCache caches tiers from a PlanResolver. Like ReputationCache, lookups happen asynchronously, off of the request
+// path, so an API key has no tier until its first lookup completes. API keys are client controlled, so the tiers of
+// the least recently used keys are evicted once the cache is full.
+type PlanCache struct {
+	resolver PlanResolver
+	ttl      time.Duration
+	timeout  time.Duration
+	logger   logrus.FieldLogger
+	reporter MetricReporter
+
+	mu       sync.Mutex
+	entries  *lruCache // of planEntry by API key
+	inflight map[string]bool
+	sem      chan struct{}
+}
+
+// Tier returns the cached tier of apiKey and whether one was found, starting a lookup if the tier is missing or
+// expired
+func (pc *PlanCache) Tier(apiKey string) (string, bool) {
+	now := time.Now()
+
+	pc.mu.Lock()
+	entry := planEntry{}
+	cached, found := pc.entries.get(apiKey)
+	if found {
+		entry = cached.(planEntry)
+	}
+	fresh := found && entry.expireAt.After(now)
+	lookup := !fresh && !pc.inflight[apiKey]
+	if lookup {
+		pc.inflight[apiKey] = true
+	}
+	pc.mu.Unlock()
+
+	if lookup {
+		select {
+		case pc.sem <- struct{}{}:
+			go pc.lookup(apiKey)
+		default:
+			pc.logger.Warn("too many plan lookups in flight, skipping lookup")
+			pc.mu.Lock()
+			delete(pc.inflight, apiKey)
+			pc.mu.Unlock()
+		}
+	}
+
+	// a stale tier is still more useful than none while it is refreshed
+	return entry.tier, found
+}
+
+func (pc *PlanCache) lookup(apiKey string) {
+	start := time.Now()
+	defer func() { <-pc.sem }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pc.timeout)
+	tier, err := pc.resolver.Tier(ctx, apiKey)
+	cancel()
+	pc.reporter.PlanLookup(time.Now().Sub(start), err != nil)
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	delete(pc.inflight, apiKey)
+
+	if err != nil {
+		pc.logger.WithError(err).Warn("error looking up plan tier")
+		return
+	}
+
+	pc.entries.add(apiKey, planEntry{tier: tier, expireAt: time.Now().Add(pc.ttl)})
+}
+
+// TierSource provides cached plan tiers
+type TierSource interface {
+	Tier(apiKey string) (string, bool)
+}
+
+// WithPlanTier wraps f, setting the Tier of requests to the tier of the API key in their keyAttribute (named as in
+// ValidateRequestAttribute, e.g. header.x-api-key) so rules can match it with req.tier. Requests without a key, or
+// whose key's tier hasn't been looked up yet, have an empty tier.
+func WithPlanTier(f CondRequestBlockerFunc, tiers TierSource, keyAttribute string) CondRequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, bool, uint32, error) {
+		if apiKey := r.Attribute(keyAttribute); len(apiKey) > 0 {
+			tier, found := tiers.Tier(apiKey)
+			tracef(c, "plan tier %q, found: %v", tier, found)
+			r.Tier = tier
+		}
+
+		return f(c, r)
+	}
+}
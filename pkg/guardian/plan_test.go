@@ -0,0 +1,119 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type FakePlanResolver struct {
+	tiers map[string]string
+}
+
+func (f *FakePlanResolver) Tier(context context.Context, apiKey string) (string, error) {
+	tier, ok := f.tiers[apiKey]
+	if !ok {
+		return "", fmt.Errorf("unknown key")
+	}
+	return tier, nil
+}
+
+type StaticTierSource map[string]string
+
+func (s StaticTierSource) Tier(apiKey string) (string, bool) {
+	tier, ok := s[apiKey]
+	return tier, ok
+}
+
+func TestHTTPPlanResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("key") {
+		case "key-pro":
+			fmt.Fprint(w, `{"tier": "pro"}`)
+		case "key-unknown":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	resolver := NewHTTPPlanResolver(srv.URL, srv.Client())
+	tier, err := resolver.Tier(context.Background(), "key-pro")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if tier != "pro" {
+		t.Fatalf("expected: %v received: %v", "pro", tier)
+	}
+
+	if tier, err := resolver.Tier(context.Background(), "key-unknown"); err != nil || tier != "" {
+		t.Fatalf("expected an empty tier for a key without a plan, received: (%q, %v)", tier, err)
+	}
+
+	if _, err := resolver.Tier(context.Background(), "key-error"); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+}
+
+func TestPlanCacheLooksUpAsynchronously(t *testing.T) {
+	resolver := &FakePlanResolver{tiers: map[string]string{"key-pro": "pro", "key-free": "free"}}
+	cache := NewPlanCache(resolver, 1, time.Minute, time.Second, TestingLogger, NullReporter{})
+
+	if _, found := cache.Tier("key-pro"); found {
+		t.Fatal("expected no tier before the first lookup completes")
+	}
+
+	time.Sleep(100 * time.Millisecond) // wait for async lookup
+
+	tier, found := cache.Tier("key-pro")
+	if !found || tier != "pro" {
+		t.Fatalf("expected: (%v, %v) received: (%v, %v)", "pro", true, tier, found)
+	}
+
+	cache.Tier("key-free")
+	time.Sleep(100 * time.Millisecond) // wait for async lookup
+
+	if _, found := cache.Tier("key-pro"); found {
+		t.Fatal("expected the least recently used tier to be evicted")
+	}
+}
+
+func TestWithPlanTier(t *testing.T) {
+	rules := []Rule{
+		mustParseRule(t, "tier-free", RuleDocument{When: `req.tier == "free"`, Action: "limit", Limit: &LimitDocument{Count: 1, Duration: "1m", Enabled: true}, LimitKey: "header.x-api-key"}),
+		mustParseRule(t, "tier-pro", RuleDocument{When: `req.tier == "pro"`, Action: "limit", Limit: &LimitDocument{Count: 3, Duration: "1m", Enabled: true}, LimitKey: "header.x-api-key"}),
+	}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, &FakeLimitStore{count: make(map[string]uint64)}, LocalClock{}, nil, nil, TestingLogger, NullReporter{})
+	evaluate := WithPlanTier(re.Evaluate, StaticTierSource{"key-free": "free", "key-pro": "pro"}, "header.x-api-key")
+
+	tests := []struct {
+		apiKey  string
+		blocked bool
+	}{
+		{apiKey: "key-free", blocked: false},
+		{apiKey: "key-free", blocked: true},
+		{apiKey: "key-pro", blocked: false},
+		{apiKey: "key-pro", blocked: false},
+		{apiKey: "key-pro", blocked: false},
+		{apiKey: "key-pro", blocked: true},
+		{apiKey: "key-unknown", blocked: false},
+		{apiKey: "", blocked: false},
+	}
+
+	for i, test := range tests {
+		req := Request{RemoteAddress: "192.168.1.2", Headers: map[string]string{"x-api-key": test.apiKey}}
+		_, blocked, _, err := evaluate(context.Background(), req)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if blocked != test.blocked {
+			t.Errorf("request %d with key %q expected blocked: %v received: %v", i, test.apiKey, test.blocked, blocked)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package guardian
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// maxPolicyCaseRepeat bounds the times a policy case sends its request
+const maxPolicyCaseRepeat = 10000
+
+// PolicyDecision is the decision a policy case expects for its request
+type PolicyDecision string
+
+const (
+	// AllowPolicyDecision expects the request to be allowed
+	AllowPolicyDecision PolicyDecision = "allow"
+	// BlockPolicyDecision expects the request to be blocked, regardless of report only mode
+	BlockPolicyDecision PolicyDecision = "block"
+)
+
+// PolicySuite is a suite of example requests and the decisions a conf document is expected to make for them
+type PolicySuite struct {
+	Cases []PolicyCase `json:"cases"`
+}
+
+// PolicyCase is an example request and the decision expected for it. Each case is evaluated with counters of its
+// own, so cases don't count each other's requests.
+type PolicyCase struct {
+	Name    string        `json:"name"`
+	Request PolicyRequest `json:"request"`
+	// Repeat sends the request this many times, the decision for the last being the one expected, once if 0
+	Repeat int               `json:"repeat,omitempty"`
+	Expect PolicyExpectation `json:"expect"`
+}
+
+// PolicyRequest is the serializable form of a Request
+type PolicyRequest struct {
+	RemoteAddress string            `json:"remote_address"`
+	Authority     string            `json:"authority,omitempty"`
+	Method        string            `json:"method,omitempty"`
+	Path          string            `json:"path,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+// PolicyExpectation is the decision expected for a request
+type PolicyExpectation struct {
+	Decision PolicyDecision `json:"decision"`
+	// Reason is the BlockReason expected for a blocked request, such as rule:scrapers or rate_limit, unchecked if empty
+	Reason string `json:"reason,omitempty"`
+	// Rule is the name of the rule expected to decide the request, unchecked if empty
+	Rule string `json:"rule,omitempty"`
+}
+
+// PolicyResult is the outcome of a policy case
+type PolicyResult struct {
+	Case     string         `json:"case"`
+	Passed   bool           `json:"passed"`
+	Decision PolicyDecision `json:"decision"`
+	Reason   string         `json:"reason,omitempty"`
+	Rule     string         `json:"rule,omitempty"`
+	// Failure describes how the decision differed from the expected one, or the error evaluating the request
+	Failure string `json:"failure,omitempty"`
+}
+
+// LoadPolicySuite reads and validates a PolicySuite from the file at path, which is YAML if it has a .yaml or .yml
+// extension and JSON otherwise
+func LoadPolicySuite(path string) (PolicySuite, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return PolicySuite{}, err
+	}
+
+	if isYAMLPath(path) {
+		if b, err = yamlToJSON(b); err != nil {
+			return PolicySuite{}, errors.Wrap(err, fmt.Sprintf("error parsing policy suite %v", path))
+		}
+	}
+
+	suite, err := ParsePolicySuite(bytes.NewReader(b))
+	if err != nil {
+		return PolicySuite{}, errors.Wrap(err, fmt.Sprintf("error parsing policy suite %v", path))
+	}
+
+	return suite, nil
+}
+
+// ParsePolicySuite decodes and validates a JSON PolicySuite
+func ParsePolicySuite(r io.Reader) (PolicySuite, error) {
+	suite := PolicySuite{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&suite); err != nil {
+		return PolicySuite{}, errors.Wrap(err, "error decoding policy suite")
+	}
+
+	if len(suite.Cases) == 0 {
+		return PolicySuite{}, fmt.Errorf("policy suite has no cases")
+	}
+
+	names := map[string]bool{}
+	for i, c := range suite.Cases {
+		if len(c.Name) == 0 {
+			return PolicySuite{}, fmt.Errorf("policy case %d has no name", i+1)
+		}
+
+		if names[c.Name] {
+			return PolicySuite{}, fmt.Errorf("policy case %v appears more than once", c.Name)
+		}
+		names[c.Name] = true
+
+		if len(c.Request.RemoteAddress) == 0 {
+			return PolicySuite{}, fmt.Errorf("policy case %v has no remote address", c.Name)
+		}
+
+		if c.Repeat < 0 || c.Repeat > maxPolicyCaseRepeat {
+			return PolicySuite{}, fmt.Errorf("policy case %v repeat %d must be between 0 and %d", c.Name, c.Repeat, maxPolicyCaseRepeat)
+		}
+
+		if c.Expect.Decision != AllowPolicyDecision && c.Expect.Decision != BlockPolicyDecision {
+			return PolicySuite{}, fmt.Errorf("policy case %v expects unknown decision %q, must be %v or %v", c.Name, c.Expect.Decision, AllowPolicyDecision, BlockPolicyDecision)
+		}
+	}
+
+	return suite, nil
+}
+
+// RunPolicySuite evaluates the cases of suite against the conf of doc through the whitelist, blacklist, rules, global
+// limit, and route limits, returning the result of each. Requests are counted in memory rather than in Redis, so a
+// case whose request is counted by an algorithm or feature needing Redis, such as a leaky bucket or a cooldown, fails
+// with the error of counting it.
+func RunPolicySuite(doc ConfDocument, suite PolicySuite) ([]PolicyResult, error) {
+	conf, err := confFromDocument(doc, newDefaultConf(nil, nil, Limit{}, false))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]PolicyResult, 0, len(suite.Cases))
+	for _, c := range suite.Cases {
+		results = append(results, runPolicyCase(conf, c))
+	}
+
+	return results, nil
+}
+
+func runPolicyCase(c conf, pc PolicyCase) PolicyResult {
+	errs := &policyErrorHook{}
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.AddHook(errs)
+
+	store := staticConfProvider{conf: c}
+	counter := &memCounter{counts: make(map[string]uint64)}
+	clock := frozenClock{now: time.Now()}
+	ruleEvaluator := NewRuleEvaluator(store, counter, clock, nil, nil, logger, NullReporter{})
+	rateLimiter := NewIPRateLimiter(store, counter, clock, nil, logger, NullReporter{})
+	routeRateLimiter := NewRouteRateLimiter(store, counter, clock, nil, logger, NullReporter{})
+	chain := CondChain(
+		CondStopOnWhitelistFunc(NewIPWhitelister(store, nil, 0, 0, logger, NullReporter{})),
+		CondStopOnBlacklistFunc(NewIPBlacklister(store, logger, NullReporter{})),
+		ruleEvaluator.Evaluate,
+		CondStopOnBlockOrError(rateLimiter.Limit),
+		CondStopOnBlockOrError(routeRateLimiter.Limit),
+	)
+
+	request := Request{
+		RemoteAddress: CanonicalRemoteAddress(pc.Request.RemoteAddress),
+		Authority:     pc.Request.Authority,
+		Method:        pc.Request.Method,
+		Path:          pc.Request.Path,
+		Headers:       pc.Request.Headers,
+		Metadata:      pc.Request.Metadata,
+	}
+	if request.Headers == nil {
+		request.Headers = make(map[string]string)
+	}
+
+	repeat := pc.Repeat
+	if repeat == 0 {
+		repeat = 1
+	}
+
+	result := PolicyResult{Case: pc.Name}
+	for i := 0; i < repeat; i++ {
+		hint := NewDecisionHint()
+		blocked, _, err := chain(WithDecisionHint(context.Background(), hint), request)
+		if err == nil {
+			err = errs.err()
+		}
+		if err != nil {
+			result.Failure = fmt.Sprintf("error evaluating request %d: %v", i+1, err)
+			return result
+		}
+
+		result.Decision, result.Reason, result.Rule = AllowPolicyDecision, "", ""
+		if blocked {
+			result.Decision = BlockPolicyDecision
+		}
+		if reason := hint.BlockReason(); reason != nil && blocked {
+			result.Reason = reason.String()
+		}
+		if rule := hint.MatchedRule(); rule != nil {
+			result.Rule = rule.Name
+		}
+	}
+
+	switch {
+	case result.Decision != pc.Expect.Decision:
+		result.Failure = fmt.Sprintf("expected %v, decided %v", pc.Expect.Decision, result.Decision)
+	case len(pc.Expect.Reason) > 0 && result.Reason != pc.Expect.Reason:
+		result.Failure = fmt.Sprintf("expected reason %q, received %q", pc.Expect.Reason, result.Reason)
+	case len(pc.Expect.Rule) > 0 && result.Rule != pc.Expect.Rule:
+		result.Failure = fmt.Sprintf("expected rule %q, received %q", pc.Expect.Rule, result.Rule)
+	default:
+		result.Passed = true
+	}
+
+	return result
+}
+
+// policyErrorHook records the errors logged while evaluating a request, as the limiters fail open on errors rather
+// than returning them
+type policyErrorHook struct {
+	mu      sync.Mutex
+	entries []*logrus.Entry
+}
+
+func (h *policyErrorHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (h *policyErrorHook) Fire(entry *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, entry)
+	return nil
+}
+
+// err returns the first error logged since it was last called, if any
+func (h *policyErrorHook) err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) == 0 {
+		return nil
+	}
+
+	entry := h.entries[0]
+	h.entries = nil
+	if err, ok := entry.Data[logrus.ErrorKey].(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", entry.Message)
+}
+
+// staticConfProvider provides an unchanging conf to limiters, for evaluating requests against a conf document without
+// a conf store
+type staticConfProvider struct {
+	conf conf
+}
+
+func (s staticConfProvider) GetWhitelist() []net.IPNet {
+	return s.conf.whitelist
+}
+
+func (s staticConfProvider) GetWhitelistPrefixes() []netip.Prefix {
+	return s.conf.whitelistPrefixes
+}
+
+func (s staticConfProvider) GetWhitelistHosts() []string {
+	return s.conf.whitelistHosts
+}
+
+func (s staticConfProvider) GetBlacklist() []net.IPNet {
+	return s.conf.blacklist
+}
+
+func (s staticConfProvider) GetBlacklistPrefixes() []netip.Prefix {
+	return s.conf.blacklistPrefixes
+}
+
+func (s staticConfProvider) GetLimit() Limit {
+	return s.conf.limit
+}
+
+func (s staticConfProvider) GetReportOnly() bool {
+	return s.conf.reportOnly
+}
+
+func (s staticConfProvider) GetRouteMatcher() *RouteMatcher {
+	return s.conf.routeMatcher
+}
+
+func (s staticConfProvider) GetRules() []Rule {
+	return s.conf.rules
+}
+
+func (s staticConfProvider) GetLimitExperiment() LimitExperiment {
+	return s.conf.limitExperiment
+}
+
+func (s staticConfProvider) ResolveLimit(request Request, routeLimit *RouteLimit) (Limit, string) {
+	return resolveLimit(s.conf.limit, s.conf.authorityLimits, s.conf.keyLimits, request, routeLimit, nil)
+}
+
+// memCounter is a Counter counting in memory without expiring keys, for evaluating a handful of requests at a
+// frozenClock, whose keys never leave their window
+type memCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func (m *memCounter) Incr(context context.Context, key string, incrBy uint, maxBeforeBlock uint64, expireIn time.Duration) (uint64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.counts[key] += uint64(incrBy)
+	return m.counts[key], false, nil
+}
+
+func (m *memCounter) Count(context context.Context, key string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.counts[key], nil
+}
+
+// frozenClock is a Clock stopped at now
+type frozenClock struct {
+	now time.Time
+}
+
+func (c frozenClock) Now() time.Time {
+	return c.now
+}
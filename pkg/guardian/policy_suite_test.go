@@ -0,0 +1,161 @@
+package guardian
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPolicyConf = `{
+	"blacklist": ["192.168.9.0/24"],
+	"limit": {"count": 100, "duration": "1m", "enabled": true},
+	"report_only": true,
+	"route_limits": {"/login": {"count": 2, "duration": "1m", "enabled": true}},
+	"rules": {
+		"allow-internal": {"when": "ip.inCIDR(\"10.0.0.0/8\")", "action": "allow"},
+		"block-admin": {"when": "req.path.startsWith(\"/admin\")", "action": "block"},
+		"posts": {"when": "req.method == \"POST\"", "action": "limit", "limit": {"count": 3, "duration": "1m", "enabled": true}}
+	}
+}`
+
+func TestRunPolicySuite(t *testing.T) {
+	doc, err := ParseConfDocument(strings.NewReader(testPolicyConf))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	suite, err := ParsePolicySuite(strings.NewReader(`{"cases": [
+		{"name": "internal admin", "request": {"remote_address": "10.0.0.1", "path": "/admin"}, "expect": {"decision": "allow", "rule": "allow-internal"}},
+		{"name": "external admin", "request": {"remote_address": "192.168.1.2", "path": "/admin"}, "expect": {"decision": "block", "reason": "rule:block-admin"}},
+		{"name": "blacklisted", "request": {"remote_address": "192.168.9.1", "path": "/"}, "expect": {"decision": "block", "reason": "blacklist:192.168.9.0/24"}},
+		{"name": "posts under limit", "request": {"remote_address": "192.168.1.2", "method": "POST"}, "repeat": 3, "expect": {"decision": "allow"}},
+		{"name": "posts over limit", "request": {"remote_address": "192.168.1.2", "method": "POST"}, "repeat": 4, "expect": {"decision": "block", "rule": "posts"}},
+		{"name": "login over limit", "request": {"remote_address": "192.168.1.2", "path": "/login"}, "repeat": 3, "expect": {"decision": "block", "reason": "route_limit:/login"}},
+		{"name": "wrong expectation", "request": {"remote_address": "192.168.1.2", "path": "/"}, "expect": {"decision": "block"}}
+	]}`))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	results, err := RunPolicySuite(doc, suite)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(results) != len(suite.Cases) {
+		t.Fatalf("expected %d results, received: %v", len(suite.Cases), results)
+	}
+
+	for _, result := range results[:len(results)-1] {
+		if !result.Passed {
+			t.Errorf("expected case %v to pass, received: %+v", result.Case, result)
+		}
+	}
+
+	if last := results[len(results)-1]; last.Passed || last.Decision != AllowPolicyDecision || last.Failure == "" {
+		t.Errorf("expected case %v to fail with an allow decision, received: %+v", last.Case, last)
+	}
+}
+
+func TestLoadPolicySuiteYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	suite := `# admin is only reachable from the internal network
+cases:
+  - name: internal admin
+    request: {remote_address: 10.0.0.1, path: /admin}
+    expect: {decision: allow, rule: allow-internal}
+  - name: posts over limit
+    request:
+      remote_address: 192.168.1.2
+      method: POST
+    repeat: 4
+    expect:
+      decision: block
+      rule: posts
+`
+	for _, name := range []string{"suite.yaml", "suite.yml"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(suite), 0600); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		loaded, err := LoadPolicySuite(path)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		doc, err := ParseConfDocument(strings.NewReader(testPolicyConf))
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		results, err := RunPolicySuite(doc, loaded)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		for _, result := range results {
+			if !result.Passed {
+				t.Errorf("expected case %v of %v to pass, received: %+v", result.Case, name, result)
+			}
+		}
+	}
+
+	// unknown fields are rejected in YAML as in JSON
+	path := filepath.Join(dir, "unknown.yaml")
+	if err := ioutil.WriteFile(path, []byte("cases:\n- name: a\n  request: {remote_address: 10.0.0.1}\n  expect: {decision: allow}\n  extra: 1\n"), 0600); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if _, err := LoadPolicySuite(path); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+}
+
+func TestRunPolicySuiteFailsUnsupportedAlgorithms(t *testing.T) {
+	doc, err := ParseConfDocument(strings.NewReader(`{"limit": {"count": 10, "duration": "1m", "enabled": true, "algorithm": "leaky_bucket"}}`))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	suite := PolicySuite{Cases: []PolicyCase{{Name: "leaky", Request: PolicyRequest{RemoteAddress: "192.168.1.2"}, Expect: PolicyExpectation{Decision: AllowPolicyDecision}}}}
+	results, err := RunPolicySuite(doc, suite)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if results[0].Passed || !strings.Contains(results[0].Failure, "leaky_bucket") {
+		t.Errorf("expected case to fail with the unsupported algorithm, received: %+v", results[0])
+	}
+}
+
+func TestParsePolicySuiteRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		suite string
+	}{
+		{name: "NoCases", suite: `{"cases": []}`},
+		{name: "UnknownField", suite: `{"cases": [{"name": "a", "request": {"remote_address": "192.168.1.2"}, "expect": {"decision": "allow"}, "extra": 1}]}`},
+		{name: "NoName", suite: `{"cases": [{"request": {"remote_address": "192.168.1.2"}, "expect": {"decision": "allow"}}]}`},
+		{name: "DuplicateName", suite: `{"cases": [
+			{"name": "a", "request": {"remote_address": "192.168.1.2"}, "expect": {"decision": "allow"}},
+			{"name": "a", "request": {"remote_address": "192.168.1.3"}, "expect": {"decision": "allow"}}
+		]}`},
+		{name: "NoRemoteAddress", suite: `{"cases": [{"name": "a", "request": {"path": "/"}, "expect": {"decision": "allow"}}]}`},
+		{name: "UnknownDecision", suite: `{"cases": [{"name": "a", "request": {"remote_address": "192.168.1.2"}, "expect": {"decision": "deny"}}]}`},
+		{name: "TooManyRepeats", suite: `{"cases": [{"name": "a", "request": {"remote_address": "192.168.1.2"}, "repeat": 10001, "expect": {"decision": "allow"}}]}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParsePolicySuite(strings.NewReader(test.suite)); err == nil {
+				t.Fatal("expected error but received nil")
+			}
+		})
+	}
+}
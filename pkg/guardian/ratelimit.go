@@ -3,22 +3,112 @@ package guardian
 import (
 	"context"
 	"fmt"
-	"strconv"
+	"hash/fnv"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// Algorithm is the algorithm used to enforce a Limit
+type Algorithm string
+
+const (
+	// FixedWindowAlgorithm counts requests in fixed windows of the limit duration, allowing bursts of up to the
+	// limit count at any time within a window. This is the default.
+	FixedWindowAlgorithm Algorithm = "fixed_window"
+
+	// LeakyBucketAlgorithm drains requests at a fixed rate of count per duration and rejects those that would
+	// overflow a bucket with a capacity of count, smoothing traffic for write heavy routes
+	LeakyBucketAlgorithm Algorithm = "leaky_bucket"
+
+	// DayBucketsAlgorithm counts requests in buckets of UTC days, allowing up to the limit count over the last
+	// duration days. It is meant for week or month long limits, which it counts without keys that are evicted or reset
+	// wholesale at window boundaries.
+	DayBucketsAlgorithm Algorithm = "day_buckets"
+)
+
+// ParseAlgorithm parses an Algorithm from a string. An empty string is the default fixed window algorithm.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch Algorithm(s) {
+	case "", FixedWindowAlgorithm:
+		return FixedWindowAlgorithm, nil
+	case LeakyBucketAlgorithm:
+		return LeakyBucketAlgorithm, nil
+	case DayBucketsAlgorithm:
+		return DayBucketsAlgorithm, nil
+	}
+
+	return "", fmt.Errorf("unknown algorithm %q", s)
+}
+
+// MaxEnforcePercent is the largest valid Limit.EnforcePercent
+const MaxEnforcePercent = 100
+
 // Limit describes a rate limit
 type Limit struct {
-	Count    uint64
-	Duration time.Duration
-	Enabled  bool
+	Count     uint64
+	Duration  time.Duration
+	Enabled   bool
+	Algorithm Algorithm
+
+	// EnforcePercent is the percentage of keys the limit blocks, chosen deterministically by hashing the key.
+	// Requests from the remaining keys that exceed the limit are only reported. Zero enforces for all keys.
+	EnforcePercent uint
+
+	// Calendar aligns fixed windows to a calendar unit in TimeZone, an IANA time zone name or empty for UTC,
+	// instead of windows of Duration. Duration still determines the leaky bucket drain rate.
+	Calendar CalendarWindow
+	TimeZone string
+
+	// Rollover caps the count left unused by a key's previous fixed window that is carried into its next window as
+	// credit, letting bursty keys that stay under the limit on average exceed it. Zero disables rollover.
+	Rollover uint64
 }
 
 func (l Limit) String() string {
-	return fmt.Sprintf("Limit(%d per %v, enabled: %v)", l.Count, l.Duration, l.Enabled)
+	s := fmt.Sprintf("Limit(%d per %v, enabled: %v", l.Count, l.Duration, l.Enabled)
+	if l.Calendar != "" {
+		tz := l.TimeZone
+		if tz == "" {
+			tz = "UTC"
+		}
+		s = fmt.Sprintf("Limit(%d per calendar %v (%v), enabled: %v", l.Count, l.Calendar, tz, l.Enabled)
+	}
+
+	if l.Algorithm != "" && l.Algorithm != FixedWindowAlgorithm {
+		s += fmt.Sprintf(", algorithm: %v", l.Algorithm)
+	}
+
+	if l.EnforcePercent != 0 && l.EnforcePercent != MaxEnforcePercent {
+		s += fmt.Sprintf(", enforced: %d%%", l.EnforcePercent)
+	}
+
+	if l.Rollover != 0 {
+		s += fmt.Sprintf(", rollover: %d", l.Rollover)
+	}
+
+	return s + ")"
+}
+
+// Enforced returns whether the limit blocks requests for key
+func (l Limit) Enforced(key string) bool {
+	if l.EnforcePercent == 0 || l.EnforcePercent >= MaxEnforcePercent {
+		return true
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return uint(h.Sum32()%MaxEnforcePercent) < l.EnforcePercent
+}
+
+// ValidateEnforcePercent returns an error if percent is not a valid Limit.EnforcePercent
+func ValidateEnforcePercent(percent uint) error {
+	if percent > MaxEnforcePercent {
+		return fmt.Errorf("enforce percent %d must be between 0 and %d", percent, MaxEnforcePercent)
+	}
+
+	return nil
 }
 
 // LimitProvider provides the current limit settings
@@ -33,19 +123,55 @@ type Counter interface {
 	// Incr increments key by count and sets the expiration to expireIn from now. The result of the incr, whether to force block,
 	// and an error is returned
 	Incr(context context.Context, key string, incryBy uint, maxBeforeBlock uint64, expireIn time.Duration) (uint64, bool, error)
+
+	// Count returns the current count of key without incrementing it
+	Count(context context.Context, key string) (uint64, error)
+}
+
+// CounterIncr describes a single increment of a batch
+type CounterIncr struct {
+	Key            string
+	IncrBy         uint
+	MaxBeforeBlock uint64
+	ExpireIn       time.Duration
+}
+
+// CounterResult is the result of a single increment of a batch
+type CounterResult struct {
+	Count   uint64
+	Blocked bool
 }
 
-// NewIPRateLimiter creates a new IP rate limiter
-func NewIPRateLimiter(conf LimitProvider, counter Counter, logger logrus.FieldLogger, reporter MetricReporter) *IPRateLimiter {
-	return &IPRateLimiter{conf: conf, counter: counter, logger: logger, reporter: reporter}
+// BatchCounter is a Counter capable of applying a batch of increments in a single round trip
+type BatchCounter interface {
+	Counter
+
+	// IncrBatch applies each increment in order, returning a result for each
+	IncrBatch(context context.Context, incrs []CounterIncr) ([]CounterResult, error)
+}
+
+// Quota describes the current usage of a rate limit for a request
+type Quota struct {
+	Limit     Limit
+	Count     uint64
+	Remaining uint64
+	ResetAt   time.Time
+}
+
+// NewIPRateLimiter creates a new IP rate limiter, counting requests in the rate limit's bulkhead unless bulkheads is
+// nil. Requests arriving while the bulkhead is full are blocked.
+func NewIPRateLimiter(conf LimitProvider, counter Counter, clock Clock, bulkheads *Bulkheads, logger logrus.FieldLogger, reporter MetricReporter) *IPRateLimiter {
+	return &IPRateLimiter{conf: conf, counter: counter, clock: clock, bulkheads: bulkheads, logger: logger, reporter: reporter}
 }
 
 // IPRateLimiter is an IP based rate limiter
 type IPRateLimiter struct {
-	conf     LimitProvider
-	counter  Counter
-	logger   logrus.FieldLogger
-	reporter MetricReporter
+	conf      LimitProvider
+	counter   Counter
+	clock     Clock
+	bulkheads *Bulkheads
+	logger    logrus.FieldLogger
+	reporter  MetricReporter
 }
 
 // Limit limits a request if request exceeds rate limit
@@ -57,19 +183,35 @@ func (rl *IPRateLimiter) Limit(context context.Context, request Request) (bool,
 		rl.reporter.HandledRatelimit(request, ratelimited, err != nil, time.Now().Sub(start))
 	}()
 
-	limit := rl.conf.GetLimit()
+	limit, scope := resolveRequestLimit(rl.conf, request, nil)
 	rl.logger.Debugf("fetched limit %v", limit)
 	rl.reporter.CurrentLimit(limit)
 
 	if !limit.Enabled {
 		rl.logger.Debugf("limit not enabled for request %v, allowing", request)
+		tracef(context, "rate limit %v not enabled", limit)
 		return false, ^uint32(0), nil
 	}
 
-	key := rl.SlotKey(request, time.Now(), limit.Duration)
-	rl.logger.Debugf("generated key %v for request %v", key, request)
-
-	currCount, blocked, err := rl.counter.Incr(context, key, 1, limit.Count, limit.Duration)
+	var currCount uint64
+	var blocked bool
+	if alternate, shadow, ok := rl.experiment(request, limit); ok {
+		limit, currCount, blocked, err = rl.incrExperiment(context, request, scope, limit, shadow, alternate)
+	} else {
+		currCount, blocked, err = rl.incr(context, request, scope, limit, request.Hits())
+	}
+	tracef(context, "rate limit counter: count %d of %v, force block: %v, err: %v", currCount, limit, blocked, err)
+	if err == errBulkheadFull {
+		// the request can't be counted, so it's blocked without a hint of how long for, as the bulkhead may free up
+		err = nil
+		hintErrorSwallowed(context)
+		ratelimited = rl.enforced(limit, request)
+		if ratelimited {
+			rl.logger.Debugf("request %v blocked with the rate limit bulkhead full", request)
+			hintBlockReason(context, RateLimitBlockReason, "")
+		}
+		return ratelimited, 0, nil
+	}
 	if err != nil {
 		err = errors.Wrap(err, fmt.Sprintf("error incrementing limit for request %v", request))
 		rl.logger.WithError(err).Error("counter returned error when call incr")
@@ -77,35 +219,242 @@ func (rl *IPRateLimiter) Limit(context context.Context, request Request) (bool,
 	}
 
 	ratelimited = blocked || currCount > limit.Count
+	if ratelimited && !rl.enforced(limit, request) {
+		tracef(context, "rate limit exceeded but not enforced for %d%% of keys", limit.EnforcePercent)
+		ratelimited = false
+		return ratelimited, 0, err
+	}
+
 	if ratelimited {
 		rl.logger.Debugf("request %v blocked", request)
+		hintBlockedFor(context, blockedFor(limit, currCount, rl.clock.Now()))
+		hintBlockReason(context, RateLimitBlockReason, "")
 		return ratelimited, 0, err // block request, rate limited
 	}
 
+	remaining32 := rl.remaining(limit, currCount)
+	rl.logger.Debugf("request %v allowed with %v remaining requests", request, remaining32)
+	return ratelimited, remaining32, err
+}
+
+// incr counts incrBy against request's limit, resolved in scope, using the limit's algorithm
+func (rl *IPRateLimiter) incr(context context.Context, request Request, scope string, limit Limit, incrBy uint) (uint64, bool, error) {
+	release, err := rl.bulkheads.Acquire(rateLimitBulkhead)
+	if err != nil {
+		return 0, false, err
+	}
+	defer release()
+
+	key := rl.counterKey(request, scope)
+	rl.logger.Debugf("generated key %v for request %v", key, request)
+	tracef(context, "incrementing %v of %v", limit.Algorithm, key)
+	return incrLimitKey(context, rl.counter, rl.clock, key, limit, incrBy)
+}
+
+// counterKey generates the key request is counted under in scope, before it's windowed by the limit's algorithm
+func (rl *IPRateLimiter) counterKey(request Request, scope string) string {
+	if len(scope) == 0 {
+		return request.RemoteAddress
+	}
+
+	return request.RemoteAddress + ":" + scope
+}
+
+// windowKey generates the key of the fixed window containing now and how long the key must be kept
+func (rl *IPRateLimiter) windowKey(request Request, scope string, limit Limit, now time.Time) (string, time.Duration) {
+	return fixedWindowKey(limitKey(rl.counterKey(request, scope), limit), limit, now)
+}
+
+// windowExpiration returns the time from now until the end of a window, rounded up to a whole second since that's
+// the resolution of Redis expirations
+func windowExpiration(now time.Time, end time.Time) time.Duration {
+	expireIn := end.Sub(now)
+	if rem := expireIn % time.Second; rem != 0 {
+		expireIn += time.Second - rem
+	}
+
+	if expireIn < time.Second {
+		return time.Second
+	}
+
+	return expireIn
+}
+
+// LimitResult is the result of rate limiting a single request of a batch
+type LimitResult struct {
+	Blocked   bool
+	Remaining uint32
+}
+
+// LimitBatch limits each request of a batch, sharing a single round trip to the counter when it is a BatchCounter
+func (rl *IPRateLimiter) LimitBatch(context context.Context, requests []Request) ([]LimitResult, error) {
+	batchCounter, ok := rl.counter.(BatchCounter)
+	if global := rl.conf.GetLimit(); !ok || global.Algorithm == LeakyBucketAlgorithm || global.Algorithm == DayBucketsAlgorithm || global.Rollover > 0 || rl.overridden(requests) || rl.experimenting() {
+		return rl.limitEach(context, requests)
+	}
+
+	start := time.Now()
+	results := make([]LimitResult, len(requests))
+	var err error
+	defer func() {
+		duration := time.Now().Sub(start)
+		for i, request := range requests {
+			rl.reporter.HandledRatelimit(request, results[i].Blocked, err != nil, duration)
+		}
+	}()
+
+	limit := rl.conf.GetLimit()
+	rl.logger.Debugf("fetched limit %v", limit)
+	rl.reporter.CurrentLimit(limit)
+
+	if !limit.Enabled {
+		rl.logger.Debugf("limit not enabled for batch of %d requests, allowing", len(requests))
+		for i := range results {
+			results[i].Remaining = RequestsRemainingMax
+		}
+		return results, nil
+	}
+
+	now := rl.clock.Now()
+	incrs := make([]CounterIncr, len(requests))
+	for i, request := range requests {
+		// requests have the global limit, and so no scope, or they would have been limited one by one
+		key, expireIn := rl.windowKey(request, "", limit, now)
+		incrs[i] = CounterIncr{Key: key, IncrBy: request.Hits(), MaxBeforeBlock: limit.Count, ExpireIn: expireIn}
+	}
+
+	counts, err := batchCounter.IncrBatch(context, incrs)
+	if err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("error incrementing limit for batch of %d requests", len(requests)))
+		rl.logger.WithError(err).Error("counter returned error when call incr batch")
+		return nil, err
+	}
+
+	for i, count := range counts {
+		if count.Blocked || count.Count > limit.Count {
+			results[i] = LimitResult{Blocked: rl.enforced(limit, requests[i]), Remaining: 0}
+			continue
+		}
+		results[i] = LimitResult{Blocked: false, Remaining: rl.remaining(limit, count.Count)}
+	}
+
+	return results, nil
+}
+
+// overridden returns whether the limit of any of requests is overridden by a narrower scope than the global limit
+func (rl *IPRateLimiter) overridden(requests []Request) bool {
+	if _, ok := rl.conf.(LimitResolver); !ok {
+		return false
+	}
+
+	global := rl.conf.GetLimit()
+	for _, request := range requests {
+		if limit, _ := resolveRequestLimit(rl.conf, request, nil); limit != global {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rl *IPRateLimiter) limitEach(context context.Context, requests []Request) ([]LimitResult, error) {
+	results := make([]LimitResult, len(requests))
+	for i, request := range requests {
+		blocked, remaining, err := rl.Limit(context, request)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = LimitResult{Blocked: blocked, Remaining: remaining}
+	}
+
+	return results, nil
+}
+
+// enforced returns whether a request that exceeded limit should be blocked, reporting it if it is ramping
+func (rl *IPRateLimiter) enforced(limit Limit, request Request) bool {
+	if limit.EnforcePercent == 0 || limit.EnforcePercent >= MaxEnforcePercent {
+		return true
+	}
+
+	enforced := limit.Enforced(request.RemoteAddress)
+	rl.reporter.HandledRatelimitCanary(request, enforced)
+	if !enforced {
+		rl.logger.Infof("would block request %v, limit enforced for %d%% of keys", request, limit.EnforcePercent)
+	}
+
+	return enforced
+}
+
+func (rl *IPRateLimiter) remaining(limit Limit, currCount uint64) uint32 {
 	remaining64 := limit.Count - currCount
 	remaining32 := uint32(remaining64)
 	if uint64(remaining32) != remaining64 { // if we lose some signifcant bits, convert it to max of uint32
-		rl.logger.Errorf("overflow detected, setting to max uint32: remaining64 %v remaining32", remaining64, remaining32)
+		rl.logger.Errorf("overflow detected, setting to max uint32: remaining64 %v remaining32 %v", remaining64, remaining32)
 		remaining32 = ^uint32(0)
 	}
 
-	rl.logger.Debugf("request %v allowed with %v remaining requests", request, remaining32)
-	return ratelimited, remaining32, err
+	return remaining32
 }
 
-// SlotKey generates the key for a slot determined by the request, slot time, and limit duration
-func (rl *IPRateLimiter) SlotKey(request Request, slotTime time.Time, duration time.Duration) string {
-	// a) convert to seconds
-	// b) get slot time unix epoch seconds
-	// c) use integer division to bucket based on limit.Duration
-	// if secs = 10
-	// 1522895020 -> 1522895020
-	// 1522895021 -> 1522895020
-	// 1522895028 -> 1522895020
-	// 1522895030 -> 1522895030
+// Quota returns the current usage of the rate limit for request without counting it against the limit
+func (rl *IPRateLimiter) Quota(context context.Context, request Request) (Quota, error) {
+	limit, scope := resolveRequestLimit(rl.conf, request, nil)
+	if !limit.Enabled {
+		return Quota{Limit: limit}, nil
+	}
+
+	if alternate, shadow, ok := rl.experiment(request, limit); ok {
+		limit, request = alternate, shadow
+	}
+
+	now := rl.clock.Now()
+	var count uint64
+	var resetAt time.Time
+	var err error
+	if limit.Algorithm == LeakyBucketAlgorithm {
+		// filling by zero drains the bucket without counting a request
+		count, _, err = rl.incr(context, request, scope, limit, 0)
+		resetAt = now.Add(time.Duration(float64(limit.Duration) / float64(limit.Count) * float64(count)))
+	} else if limit.Algorithm == DayBucketsAlgorithm {
+		// adding zero sums the buckets without counting a request. the oldest bucket leaves the window at midnight UTC.
+		count, _, err = rl.incr(context, request, scope, limit, 0)
+		resetAt = now.UTC().Truncate(day).Add(day)
+	} else if limit.Rollover > 0 {
+		// adding zero reads the window and its credit without counting a request
+		count, _, err = rl.incr(context, request, scope, limit, 0)
+		_, resetAt = limit.Window(now)
+	} else {
+		key, _ := rl.windowKey(request, scope, limit, now)
+		count, err = rl.counter.Count(context, key)
+		_, resetAt = limit.Window(now)
+	}
+
+	if err != nil {
+		return Quota{}, errors.Wrap(err, fmt.Sprintf("error fetching count for request %v", request))
+	}
+
+	remaining := uint64(0)
+	if count < limit.Count {
+		remaining = limit.Count - count
+	}
+
+	return Quota{Limit: limit, Count: count, Remaining: remaining, ResetAt: resetAt}, nil
+}
+
+// SlotKey generates the key of the fixed window of limit containing slotTime for request, counted in scope
+func (rl *IPRateLimiter) SlotKey(request Request, scope string, limit Limit, slotTime time.Time) string {
+	key, _ := rl.windowKey(request, scope, limit, slotTime)
+	return key
+}
+
+// BucketKey generates the key of the leaky bucket of limit for request, counted in scope
+func (rl *IPRateLimiter) BucketKey(request Request, scope string, limit Limit) string {
+	return NamespacedKey(leakyBucketNamespace, limitKey(rl.counterKey(request, scope), limit))
+}
+
+// slotStart returns the unix epoch seconds of the start of the slot containing slotTime
+func slotStart(slotTime time.Time, duration time.Duration) int64 {
 	secs := int64(duration / time.Second) // a
 	t := slotTime.Unix()                  // b
-	slot := (t / secs) * secs             // c
-	key := request.RemoteAddress + ":" + strconv.FormatInt(slot, 10)
-	return key
+	return (t / secs) * secs              // c
 }
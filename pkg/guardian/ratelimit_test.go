@@ -28,6 +28,14 @@ func (fl *FakeLimitStore) Incr(context context.Context, key string, incryBy uint
 	return fl.count[key], fl.forceBlock, nil
 }
 
+func (fl *FakeLimitStore) Count(context context.Context, key string) (uint64, error) {
+	if fl.injectedErr != nil {
+		return 0, fl.injectedErr
+	}
+
+	return fl.count[key], nil
+}
+
 func TestLimitString(t *testing.T) {
 	limit := Limit{Count: 3, Duration: time.Second, Enabled: true}
 	got := limit.String()
@@ -38,13 +46,33 @@ func TestLimitString(t *testing.T) {
 	}
 }
 
+func TestLimitStringEnforcePercent(t *testing.T) {
+	limit := Limit{Count: 3, Duration: time.Second, Enabled: true, EnforcePercent: 10}
+	got := limit.String()
+	expected := "Limit(3 per 1s, enabled: true, enforced: 10%)"
+
+	if got != expected {
+		t.Errorf("expected: %v received: %v", expected, got)
+	}
+}
+
+func TestLimitStringCalendar(t *testing.T) {
+	limit := Limit{Count: 1000, Duration: time.Second, Enabled: true, Calendar: DayWindow}
+	got := limit.String()
+	expected := "Limit(1000 per calendar day (UTC), enabled: true)"
+
+	if got != expected {
+		t.Errorf("expected: %v received: %v", expected, got)
+	}
+}
+
 func TestLimitRateLimits(t *testing.T) {
 
 	// 3 rps
 	limit := Limit{Count: 3, Duration: 1 * time.Second, Enabled: true}
 
 	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64)}
-	rl := NewIPRateLimiter(fstore, fstore, TestingLogger, NullReporter{})
+	rl := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
 
 	req := Request{RemoteAddress: "192.168.1.2"}
 	sentCount := 10
@@ -75,7 +103,7 @@ func TestDisableLimitDoesNotRateLimit(t *testing.T) {
 	limit := Limit{Count: 1, Duration: 1 * time.Second, Enabled: false}
 
 	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64)}
-	rl := NewIPRateLimiter(fstore, fstore, TestingLogger, NullReporter{})
+	rl := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
 
 	req := Request{RemoteAddress: "192.168.1.2"}
 	sentCount := 10
@@ -104,7 +132,7 @@ func TestLimitRateLimitsButThenAllowsAgain(t *testing.T) {
 	limit := Limit{Count: 3, Duration: 1 * time.Second, Enabled: true}
 
 	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64)}
-	rl := NewIPRateLimiter(fstore, fstore, TestingLogger, NullReporter{})
+	rl := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
 
 	req := Request{RemoteAddress: "192.168.1.2"}
 	sentCount := 10
@@ -149,10 +177,10 @@ func TestLimitRemainingOfflowUsesMaxUInt32(t *testing.T) {
 	limit := Limit{Count: ^uint64(0), Duration: 1 * time.Second, Enabled: true}
 
 	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64)}
-	rl := NewIPRateLimiter(fstore, fstore, TestingLogger, NullReporter{})
+	rl := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
 
 	req := Request{RemoteAddress: "192.168.1.2"}
-	slot := rl.SlotKey(req, time.Now(), limit.Duration)
+	slot := rl.SlotKey(req, "", limit, time.Now())
 	fstore.count[slot] = uint64(^uint32(0)) << 5 // set slot count to some value > max uint32
 
 	blocked, remaining, err := rl.Limit(context.Background(), req)
@@ -174,7 +202,7 @@ func TestLimitFailsOpen(t *testing.T) {
 	limit := Limit{Count: 3, Duration: 1 * time.Second, Enabled: true}
 
 	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64), injectedErr: fmt.Errorf("some error")}
-	rl := NewIPRateLimiter(fstore, fstore, TestingLogger, NullReporter{})
+	rl := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
 
 	req := Request{RemoteAddress: "192.168.1.2"}
 
@@ -194,7 +222,7 @@ func TestLimitRateLimitsOnBlock(t *testing.T) {
 	limit := Limit{Count: 3, Duration: 1 * time.Second, Enabled: true}
 
 	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64), forceBlock: true}
-	rl := NewIPRateLimiter(fstore, fstore, TestingLogger, NullReporter{})
+	rl := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
 
 	req := Request{RemoteAddress: "192.168.1.2"}
 
@@ -212,7 +240,7 @@ func TestLimitRateLimitsOnBlock(t *testing.T) {
 func TestSlotKeyGeneration(t *testing.T) {
 	limit := Limit{Count: 3, Duration: 1 * time.Second, Enabled: true}
 	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64), injectedErr: fmt.Errorf("some error")}
-	rl := NewIPRateLimiter(fstore, fstore, TestingLogger, NullReporter{})
+	rl := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
 
 	referenceRequest := Request{RemoteAddress: "192.168.1.2"}
 	referenceTime := time.Unix(1522969710, 0)
@@ -220,6 +248,7 @@ func TestSlotKeyGeneration(t *testing.T) {
 	tests := []struct {
 		name          string
 		request       Request
+		scope         string
 		requestTime   time.Time
 		limitDuration time.Duration
 		want          string
@@ -229,27 +258,42 @@ func TestSlotKeyGeneration(t *testing.T) {
 			request:       referenceRequest,
 			requestTime:   referenceTime,
 			limitDuration: 10 * time.Second,
-			want:          "192.168.1.2:1522969710",
+			want:          "192.168.1.2:10:1522969710",
 		},
 		{
 			name:          "BucketRoundsDown",
 			request:       referenceRequest,
 			requestTime:   referenceTime.Add(5 * time.Second),
 			limitDuration: 10 * time.Second,
-			want:          "192.168.1.2:1522969710",
+			want:          "192.168.1.2:10:1522969710",
 		},
 		{
 			name:          "BucketNext",
 			request:       referenceRequest,
 			requestTime:   referenceTime.Add(10 * time.Second),
 			limitDuration: 10 * time.Second,
-			want:          "192.168.1.2:1522969720",
+			want:          "192.168.1.2:10:1522969720",
+		},
+		{
+			name:          "DurationSameStart",
+			request:       referenceRequest,
+			requestTime:   referenceTime,
+			limitDuration: 30 * time.Second,
+			want:          "192.168.1.2:30:1522969710",
+		},
+		{
+			name:          "Scope",
+			request:       referenceRequest,
+			scope:         "authority:api.example.com",
+			requestTime:   referenceTime,
+			limitDuration: 10 * time.Second,
+			want:          "192.168.1.2:authority:api.example.com:10:1522969710",
 		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			got := rl.SlotKey(test.request, test.requestTime, test.limitDuration)
+			got := rl.SlotKey(test.request, test.scope, Limit{Count: 3, Duration: test.limitDuration, Enabled: true}, test.requestTime)
 			if got != test.want {
 				t.Errorf("got %v, wanted %v", got, test.want)
 			}
@@ -257,3 +301,109 @@ func TestSlotKeyGeneration(t *testing.T) {
 	}
 
 }
+
+func TestQuotaDoesNotIncrement(t *testing.T) {
+	limit := Limit{Count: 3, Duration: time.Minute, Enabled: true}
+
+	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64)}
+	rl := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
+
+	req := Request{RemoteAddress: "192.168.1.2"}
+	if _, _, err := rl.Limit(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		quota, err := rl.Quota(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if quota.Count != 1 {
+			t.Fatalf("expected: %v received: %v", 1, quota.Count)
+		}
+
+		if quota.Remaining != 2 {
+			t.Fatalf("expected: %v received: %v", 2, quota.Remaining)
+		}
+
+		if !quota.ResetAt.After(time.Now()) {
+			t.Fatalf("expected reset %v to be in the future", quota.ResetAt)
+		}
+	}
+}
+
+func TestLimitHonorsHitsAddend(t *testing.T) {
+	limit := Limit{Count: 10, Duration: time.Minute, Enabled: true}
+
+	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64)}
+	rl := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
+
+	req := Request{RemoteAddress: "192.168.1.2", HitsAddend: 4}
+	tests := []struct {
+		blocked   bool
+		remaining uint32
+	}{
+		{blocked: false, remaining: 6},
+		{blocked: false, remaining: 2},
+		{blocked: true, remaining: 0},
+	}
+
+	for i, test := range tests {
+		blocked, remaining, err := rl.Limit(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if blocked != test.blocked || remaining != test.remaining {
+			t.Fatalf("iteration %d expected: (%v, %v) received: (%v, %v)", i, test.blocked, test.remaining, blocked, remaining)
+		}
+	}
+}
+
+func TestLimitEnforcePercent(t *testing.T) {
+	limit := Limit{Count: 1, Duration: time.Minute, Enabled: true, EnforcePercent: 50}
+
+	fstore := &FakeLimitStore{limit: limit, count: make(map[string]uint64)}
+	rl := NewIPRateLimiter(fstore, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
+
+	enforced := 0
+	for i := 0; i < 1000; i++ {
+		req := Request{RemoteAddress: fmt.Sprintf("10.0.%d.%d", i/256, i%256)}
+		for j := 0; j < 2; j++ {
+			blocked, _, err := rl.Limit(context.Background(), req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if j == 1 && blocked != limit.Enforced(req.RemoteAddress) {
+				t.Fatalf("expected blocked to be %v for %v", limit.Enforced(req.RemoteAddress), req.RemoteAddress)
+			}
+
+			if j == 1 && blocked {
+				enforced++
+			}
+		}
+	}
+
+	if enforced < 400 || enforced > 600 {
+		t.Errorf("expected about half of clients to be enforced, received %d of 1000", enforced)
+	}
+}
+
+func TestLimitEnforced(t *testing.T) {
+	key := "192.168.1.2"
+	if !(Limit{}).Enforced(key) || !(Limit{EnforcePercent: 100}).Enforced(key) {
+		t.Fatal("expected limit to be enforced for all keys")
+	}
+
+	// enforcement is sticky as the percentage ramps up
+	enforced := false
+	for percent := uint(1); percent <= 100; percent++ {
+		got := Limit{EnforcePercent: percent}.Enforced(key)
+		if enforced && !got {
+			t.Fatalf("expected %v to remain enforced at %d%%", key, percent)
+		}
+		enforced = got
+	}
+}
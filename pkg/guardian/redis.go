@@ -1,6 +1,80 @@
 package guardian
 
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
 // NamespacedKey returns a key with the namespace prepended
 func NamespacedKey(namespace, key string) string {
 	return namespace + ":" + key
 }
+
+// RedisConnOptions configures authentication and encryption of connections to Redis, as required by managed
+// Redis offerings
+type RedisConnOptions struct {
+	// Username authenticates with Redis 6 ACLs along with Password. Only Password is sent if empty.
+	Username string
+	Password string
+
+	TLS bool
+	// TLSCAFile is a PEM file of the CAs trusted to sign the server certificate, the system's CAs if empty
+	TLSCAFile string
+	// TLSServerName is the name the server certificate is verified against, the host of the address if empty
+	TLSServerName string
+	TLSSkipVerify bool
+}
+
+// Apply configures opts, whose Addr must already be set, to connect with the options
+func (o RedisConnOptions) Apply(opts *redis.Options) error {
+	if len(o.Username) > 0 {
+		if len(o.Password) == 0 {
+			return fmt.Errorf("redis username %v requires a password", o.Username)
+		}
+
+		// the client only sends AUTH with a password, so authenticate as the user once connected
+		username, password := o.Username, o.Password
+		opts.OnConnect = func(conn *redis.Conn) error {
+			cmd := redis.NewStatusCmd("auth", username, password)
+			conn.Process(cmd)
+			return errors.Wrap(cmd.Err(), fmt.Sprintf("error authenticating with redis as %v", username))
+		}
+	} else {
+		opts.Password = o.Password
+	}
+
+	if !o.TLS {
+		return nil
+	}
+
+	serverName := o.TLSServerName
+	if len(serverName) == 0 {
+		host, _, err := net.SplitHostPort(opts.Addr)
+		if err != nil {
+			return errors.Wrap(err, "error parsing redis address for tls server name")
+		}
+		serverName = host
+	}
+
+	tlsConfig := &tls.Config{ServerName: serverName, InsecureSkipVerify: o.TLSSkipVerify}
+	if len(o.TLSCAFile) > 0 {
+		pem, err := ioutil.ReadFile(o.TLSCAFile)
+		if err != nil {
+			return errors.Wrap(err, "error reading redis tls ca file")
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in redis tls ca file %v", o.TLSCAFile)
+		}
+	}
+
+	opts.TLSConfig = tlsConfig
+	return nil
+}
@@ -1,9 +1,15 @@
 package guardian
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/netip"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,13 +19,50 @@ import (
 
 const redisIPWhitelistKey = "guardian_conf:whitelist"
 const redisIPBlacklistKey = "guardian_conf:blacklist"
+const redisWhitelistHostsKey = "guardian_conf:whitelist_hosts"
 const redisLimitCountKey = "guardian_conf:limit_count"
 const redisLimitDurationKey = "guardian_conf:limit_duration"
 const redisLimitEnabledKey = "guardian_conf:limit_enabled"
+const redisLimitAlgorithmKey = "guardian_conf:limit_algorithm"
+const redisLimitEnforcePercentKey = "guardian_conf:limit_enforce_percent"
+const redisLimitCalendarKey = "guardian_conf:limit_calendar"
+const redisLimitTimeZoneKey = "guardian_conf:limit_time_zone"
+const redisLimitRolloverKey = "guardian_conf:limit_rollover"
 const redisReportOnlyKey = "guardian_conf:reportOnly"
+const redisRouteLimitsKey = "guardian_conf:route_limits"
+const redisRulesKey = "guardian_conf:rules"
+const redisAuthorityLimitsKey = "guardian_conf:authority_limits"
+const redisKeyLimitsKey = "guardian_conf:key_limits"
+
+// NewRedisConfStore creates a new RedisConfStore. If verifyKey is not nil, synced conf is only applied if it was
+// signed by the corresponding private key. Synced conf with rules answering requests with static responses is only
+// applied if staticResponses, as checked by ValidateStaticResponses.
+func NewRedisConfStore(redis *redis.Client, defaultWhitelist []net.IPNet, defaultBlacklist []net.IPNet, defaultLimit Limit, defaultReportOnly bool, verifyKey ed25519.PublicKey, staticResponses bool, logger logrus.FieldLogger, reporter MetricReporter) *RedisConfStore {
+	defaultConf := newDefaultConf(defaultWhitelist, defaultBlacklist, defaultLimit, defaultReportOnly)
+	return &RedisConfStore{redis: redis, verifyKey: verifyKey, staticResponses: staticResponses, logger: logger, reporter: reporter, conf: &lockingConf{conf: defaultConf}}
+}
+
+// ConfStore provides the conf requests are decided with, cached in memory and kept in sync with where it's stored.
+// RedisConfStore and ConsulConfStore are ConfStores.
+type ConfStore interface {
+	WhitelistProvider
+	WhitelistPrefixProvider
+	WhitelistHostProvider
+	BlacklistProvider
+	BlacklistPrefixProvider
+	LimitProvider
+	LimitResolver
+	LimitExplainer
+	LimitExperimentProvider
+	ReportOnlyProvider
+	RuleProvider
+
+	// SyncedAt returns when a synced conf was last applied, or the zero time if one hasn't been
+	SyncedAt() time.Time
+}
 
-// NewRedisConfStore creates a new RedisConfStore
-func NewRedisConfStore(redis *redis.Client, defaultWhitelist []net.IPNet, defaultBlacklist []net.IPNet, defaultLimit Limit, defaultReportOnly bool, logger logrus.FieldLogger) *RedisConfStore {
+// newDefaultConf creates the conf served until one is synced
+func newDefaultConf(defaultWhitelist []net.IPNet, defaultBlacklist []net.IPNet, defaultLimit Limit, defaultReportOnly bool) conf {
 	if defaultWhitelist == nil {
 		defaultWhitelist = []net.IPNet{}
 	}
@@ -28,26 +71,46 @@ func NewRedisConfStore(redis *redis.Client, defaultWhitelist []net.IPNet, defaul
 		defaultBlacklist = []net.IPNet{}
 	}
 
-	defaultConf := conf{whitelist: defaultWhitelist, blacklist: defaultBlacklist, limit: defaultLimit, reportOnly: defaultReportOnly}
-	return &RedisConfStore{redis: redis, logger: logger, conf: &lockingConf{conf: defaultConf}}
+	return conf{whitelist: defaultWhitelist, blacklist: defaultBlacklist, whitelistPrefixes: PrefixesFromIPNets(defaultWhitelist), blacklistPrefixes: PrefixesFromIPNets(defaultBlacklist), limit: defaultLimit, reportOnly: defaultReportOnly, routeMatcher: NewRouteMatcher([]RouteLimit{}, routeMatchCacheSize), rules: []Rule{}, authorityLimits: map[string]LimitOverride{}, keyLimits: map[string]LimitOverride{}}
 }
 
 // RedisConfStore is a configuration provider that uses Redis for persistence
 type RedisConfStore struct {
-	redis  *redis.Client
-	conf   *lockingConf
-	logger logrus.FieldLogger
+	redis           *redis.Client
+	conf            *lockingConf
+	verifyKey       ed25519.PublicKey
+	staticResponses bool
+	logger          logrus.FieldLogger
+	reporter        MetricReporter
+	// tx queues changes instead of sending them when the store is the ConfWriter of UpdateConf
+	tx redis.Pipeliner
 }
 
 type conf struct {
-	whitelist  []net.IPNet
-	blacklist  []net.IPNet
-	limit      Limit
-	reportOnly bool
+	whitelist []net.IPNet
+	blacklist []net.IPNet
+	// whitelistPrefixes and blacklistPrefixes are the whitelist and blacklist converted for matching requests. They
+	// are replaced, never modified, so they can be shared without copying.
+	whitelistPrefixes []netip.Prefix
+	blacklistPrefixes []netip.Prefix
+	// whitelistHosts are the hostnames whose addresses are whitelisted, resolved by a HostWhitelist
+	whitelistHosts []string
+	limit          Limit
+	reportOnly     bool
+	routeMatcher   *RouteMatcher
+	rules          []Rule
+	// authorityLimits and keyLimits override the limit for requests to an authority or from a key
+	authorityLimits map[string]LimitOverride
+	keyLimits       map[string]LimitOverride
+	// limitExperiment evaluates a cohort of keys with an alternate limit, disabled unless set
+	limitExperiment LimitExperiment
 }
 type lockingConf struct {
 	sync.RWMutex
 	conf
+	limitSynced bool      // whether the limit has been synced from redis, so its keys disappearing is suspect
+	syncedAt    time.Time // when a synced conf was last applied, zero until the first sync
+	conflicts   string    // the whitelist and blacklist conflicts last warned about
 }
 
 func (rs *RedisConfStore) GetWhitelist() []net.IPNet {
@@ -57,6 +120,14 @@ func (rs *RedisConfStore) GetWhitelist() []net.IPNet {
 	return append([]net.IPNet{}, rs.conf.whitelist...)
 }
 
+// GetWhitelistPrefixes returns the whitelist as netip.Prefixes without copying it. It must not be modified.
+func (rs *RedisConfStore) GetWhitelistPrefixes() []netip.Prefix {
+	rs.conf.RLock()
+	defer rs.conf.RUnlock()
+
+	return rs.conf.whitelistPrefixes
+}
+
 func (rs *RedisConfStore) FetchWhitelist() ([]net.IPNet, error) {
 	c := rs.pipelinedFetchConf()
 	if c.whitelist == nil {
@@ -66,6 +137,22 @@ func (rs *RedisConfStore) FetchWhitelist() ([]net.IPNet, error) {
 	return c.whitelist, nil
 }
 
+func (rs *RedisConfStore) GetWhitelistHosts() []string {
+	rs.conf.RLock()
+	defer rs.conf.RUnlock()
+
+	return append([]string{}, rs.conf.whitelistHosts...)
+}
+
+func (rs *RedisConfStore) FetchWhitelistHosts() ([]string, error) {
+	c := rs.pipelinedFetchConf()
+	if c.whitelistHosts == nil {
+		return nil, fmt.Errorf("error fetching whitelist hosts")
+	}
+
+	return c.whitelistHosts, nil
+}
+
 func (rs *RedisConfStore) GetBlacklist() []net.IPNet {
 	rs.conf.RLock()
 	defer rs.conf.RUnlock()
@@ -73,6 +160,14 @@ func (rs *RedisConfStore) GetBlacklist() []net.IPNet {
 	return append([]net.IPNet{}, rs.conf.blacklist...)
 }
 
+// GetBlacklistPrefixes returns the blacklist as netip.Prefixes without copying it. It must not be modified.
+func (rs *RedisConfStore) GetBlacklistPrefixes() []netip.Prefix {
+	rs.conf.RLock()
+	defer rs.conf.RUnlock()
+
+	return rs.conf.blacklistPrefixes
+}
+
 func (rs *RedisConfStore) FetchBlacklist() ([]net.IPNet, error) {
 	c := rs.pipelinedFetchConf()
 	if c.blacklist == nil {
@@ -84,62 +179,75 @@ func (rs *RedisConfStore) FetchBlacklist() ([]net.IPNet, error) {
 
 func (rs *RedisConfStore) AddWhitelistCidrs(cidrs []net.IPNet) error {
 	key := redisIPWhitelistKey
-	for _, cidr := range cidrs {
-		field := cidr.String()
-		rs.logger.Debugf("Sending HSet for key %v field %v", key, field)
-		res := rs.redis.HSet(key, field, "true") // value doesn't matter
-
-		if res.Err() != nil {
-			return res.Err()
+	return rs.multi(func(pipe redis.Pipeliner) {
+		for _, cidr := range cidrs {
+			field := cidr.String()
+			rs.logger.Debugf("Sending HSet for key %v field %v", key, field)
+			pipe.HSet(key, field, "true") // value doesn't matter
 		}
-	}
-
-	return nil
+	})
 }
 
 func (rs *RedisConfStore) RemoveWhitelistCidrs(cidrs []net.IPNet) error {
 	key := redisIPWhitelistKey
-	for _, cidr := range cidrs {
-		field := cidr.String()
-		rs.logger.Debugf("Sending HDel for key %v field %v", key, field)
-		res := rs.redis.HDel(key, field, "true") // value doesn't matter
+	return rs.multi(func(pipe redis.Pipeliner) {
+		for _, cidr := range cidrs {
+			field := cidr.String()
+			rs.logger.Debugf("Sending HDel for key %v field %v", key, field)
+			pipe.HDel(key, field, "true") // value doesn't matter
+		}
+	})
+}
 
-		if res.Err() != nil {
-			return res.Err()
+// AddWhitelistHosts whitelists the addresses hosts resolve to, canonicalizing each host
+func (rs *RedisConfStore) AddWhitelistHosts(hosts []string) error {
+	for _, host := range hosts {
+		if err := ValidateHostname(host); err != nil {
+			return err
 		}
 	}
 
-	return nil
+	key := redisWhitelistHostsKey
+	return rs.multi(func(pipe redis.Pipeliner) {
+		for _, host := range hosts {
+			field := CanonicalHostname(host)
+			rs.logger.Debugf("Sending HSet for key %v field %v", key, field)
+			pipe.HSet(key, field, "true") // value doesn't matter
+		}
+	})
+}
+
+func (rs *RedisConfStore) RemoveWhitelistHosts(hosts []string) error {
+	key := redisWhitelistHostsKey
+	return rs.multi(func(pipe redis.Pipeliner) {
+		for _, host := range hosts {
+			field := CanonicalHostname(host)
+			rs.logger.Debugf("Sending HDel for key %v field %v", key, field)
+			pipe.HDel(key, field)
+		}
+	})
 }
 
 func (rs *RedisConfStore) AddBlacklistCidrs(cidrs []net.IPNet) error {
 	key := redisIPBlacklistKey
-	for _, cidr := range cidrs {
-		field := cidr.String()
-		rs.logger.Debugf("Sending HSet for key %v field %v", key, field)
-		res := rs.redis.HSet(key, field, "true") // value doesn't matter
-
-		if res.Err() != nil {
-			return res.Err()
+	return rs.multi(func(pipe redis.Pipeliner) {
+		for _, cidr := range cidrs {
+			field := cidr.String()
+			rs.logger.Debugf("Sending HSet for key %v field %v", key, field)
+			pipe.HSet(key, field, "true") // value doesn't matter
 		}
-	}
-
-	return nil
+	})
 }
 
 func (rs *RedisConfStore) RemoveBlacklistCidrs(cidrs []net.IPNet) error {
 	key := redisIPBlacklistKey
-	for _, cidr := range cidrs {
-		field := cidr.String()
-		rs.logger.Debugf("Sending HDel for key %v field %v", key, field)
-		res := rs.redis.HDel(key, field, "true") // value doesn't matter
-
-		if res.Err() != nil {
-			return res.Err()
+	return rs.multi(func(pipe redis.Pipeliner) {
+		for _, cidr := range cidrs {
+			field := cidr.String()
+			rs.logger.Debugf("Sending HDel for key %v field %v", key, field)
+			pipe.HDel(key, field, "true") // value doesn't matter
 		}
-	}
-
-	return nil
+	})
 }
 
 func (rs *RedisConfStore) GetLimit() Limit {
@@ -151,26 +259,42 @@ func (rs *RedisConfStore) GetLimit() Limit {
 
 func (rs *RedisConfStore) FetchLimit() (Limit, error) {
 	c := rs.pipelinedFetchConf()
-	if c.limitCount == nil || c.limitDuration == nil || c.limitEnabled == nil {
+	limit, ok := c.limit()
+	if !ok {
 		return Limit{}, fmt.Errorf("error fetching limit")
 	}
 
-	return Limit{Count: *c.limitCount, Duration: *c.limitDuration, Enabled: *c.limitEnabled}, nil
+	return limit, nil
 }
 
 func (rs *RedisConfStore) SetLimit(limit Limit) error {
 	limitCountStr := strconv.FormatUint(limit.Count, 10)
 	limitDurationStr := limit.Duration.String()
 	limitEnabledStr := strconv.FormatBool(limit.Enabled)
+	record, err := encodeLimitRecord(limit)
+	if err != nil {
+		return err
+	}
 
-	pipe := rs.redis.TxPipeline()
-	pipe.Set(redisLimitCountKey, limitCountStr, 0)
-	pipe.Set(redisLimitDurationKey, limitDurationStr, 0)
-	pipe.Set(redisLimitEnabledKey, limitEnabledStr, 0)
+	return rs.multi(func(pipe redis.Pipeliner) {
+		pipe.Set(redisLimitKey, record, 0)
+		pipe.Set(redisLimitCountKey, limitCountStr, 0)
+		pipe.Set(redisLimitDurationKey, limitDurationStr, 0)
+		pipe.Set(redisLimitEnabledKey, limitEnabledStr, 0)
+		pipe.Set(redisLimitAlgorithmKey, string(limit.Algorithm), 0)
+		pipe.Set(redisLimitEnforcePercentKey, strconv.FormatUint(uint64(limit.EnforcePercent), 10), 0)
+		pipe.Set(redisLimitCalendarKey, string(limit.Calendar), 0)
+		pipe.Set(redisLimitTimeZoneKey, limit.TimeZone, 0)
+		pipe.Set(redisLimitRolloverKey, strconv.FormatUint(limit.Rollover, 10), 0)
+	})
+}
 
-	_, err := pipe.Exec()
+// SyncedAt returns when a conf synced from Redis was last applied, or the zero time if one hasn't been
+func (rs *RedisConfStore) SyncedAt() time.Time {
+	rs.conf.RLock()
+	defer rs.conf.RUnlock()
 
-	return err
+	return rs.conf.syncedAt
 }
 
 func (rs *RedisConfStore) GetReportOnly() bool {
@@ -191,7 +315,88 @@ func (rs *RedisConfStore) FetchReportOnly() (bool, error) {
 
 func (rs *RedisConfStore) SetReportOnly(reportOnly bool) error {
 	reportOnlyStr := strconv.FormatBool(reportOnly)
-	return rs.redis.Set(redisReportOnlyKey, reportOnlyStr, 0).Err()
+	return rs.multi(func(pipe redis.Pipeliner) {
+		pipe.Set(redisReportOnlyKey, reportOnlyStr, 0)
+	})
+}
+
+func (rs *RedisConfStore) GetRouteLimits() []RouteLimit {
+	rs.conf.RLock()
+	defer rs.conf.RUnlock()
+
+	return rs.conf.routeMatcher.RouteLimits()
+}
+
+func (rs *RedisConfStore) GetRouteMatcher() *RouteMatcher {
+	rs.conf.RLock()
+	defer rs.conf.RUnlock()
+
+	return rs.conf.routeMatcher
+}
+
+func (rs *RedisConfStore) FetchRouteLimits() ([]RouteLimit, error) {
+	c := rs.pipelinedFetchConf()
+	if c.routeLimits == nil {
+		return nil, fmt.Errorf("error fetching route limits")
+	}
+
+	return c.routeLimits, nil
+}
+
+func (rs *RedisConfStore) SetRouteLimit(route RoutePattern, limit LimitOverride) error {
+	record, err := encodeLimitOverrideRecord(limit)
+	if err != nil {
+		return err
+	}
+
+	field := route.String()
+	rs.logger.Debugf("Sending HSet for key %v field %v", redisRouteLimitsKey, field)
+	return rs.multi(func(pipe redis.Pipeliner) {
+		pipe.HSet(redisRouteLimitsKey, field, record)
+	})
+}
+
+func (rs *RedisConfStore) RemoveRouteLimit(route RoutePattern) error {
+	field := route.String()
+	rs.logger.Debugf("Sending HDel for key %v field %v", redisRouteLimitsKey, field)
+	return rs.multi(func(pipe redis.Pipeliner) {
+		pipe.HDel(redisRouteLimitsKey, field)
+	})
+}
+
+func (rs *RedisConfStore) GetRules() []Rule {
+	rs.conf.RLock()
+	defer rs.conf.RUnlock()
+
+	return append([]Rule{}, rs.conf.rules...)
+}
+
+func (rs *RedisConfStore) FetchRules() ([]Rule, error) {
+	c := rs.pipelinedFetchConf()
+	if c.rules == nil {
+		return nil, fmt.Errorf("error fetching rules")
+	}
+
+	return c.rules, nil
+}
+
+func (rs *RedisConfStore) SetRule(rule Rule) error {
+	ruleJSON, err := json.Marshal(RuleDocumentFromRule(rule))
+	if err != nil {
+		return err
+	}
+
+	rs.logger.Debugf("Sending HSet for key %v field %v", redisRulesKey, rule.Name)
+	return rs.multi(func(pipe redis.Pipeliner) {
+		pipe.HSet(redisRulesKey, rule.Name, string(ruleJSON))
+	})
+}
+
+func (rs *RedisConfStore) RemoveRule(name string) error {
+	rs.logger.Debugf("Sending HDel for key %v field %v", redisRulesKey, name)
+	return rs.multi(func(pipe redis.Pipeliner) {
+		pipe.HDel(redisRulesKey, name)
+	})
 }
 
 func (rs *RedisConfStore) RunSync(updateInterval time.Duration, stop <-chan struct{}) {
@@ -217,100 +422,367 @@ func (rs *RedisConfStore) UpdateCachedConf() {
 	rs.conf.Lock()
 	defer rs.conf.Unlock()
 
+	// applying a partially written or flushed conf could, for example, empty the blacklist, so keep serving the last
+	// known good conf until redis holds a complete conf again
+	problems := fetched.problems
+	if rs.conf.limitSynced && fetched.limitMissing {
+		problems = append(problems, "limit keys are missing")
+	}
+
+	if err := ValidateStaticResponses(fetched.rules, rs.staticResponses); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if rs.verifyKey != nil && len(fetched.problems) == 0 {
+		if err := VerifyConfDocument(fetched.document(), fetched.signature, rs.verifyKey); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	rs.reporter.ConfSync(len(problems) > 0)
+	if len(problems) > 0 {
+		rs.logger.Errorf("rejecting synced conf and serving the last known good conf: %v", strings.Join(problems, "; "))
+		return
+	}
+
 	if fetched.whitelist != nil {
 		rs.conf.whitelist = fetched.whitelist
+		rs.conf.whitelistPrefixes = PrefixesFromIPNets(fetched.whitelist)
+	}
+
+	if fetched.whitelistHosts != nil {
+		rs.conf.whitelistHosts = fetched.whitelistHosts
 	}
 
 	if fetched.blacklist != nil {
 		rs.conf.blacklist = fetched.blacklist
+		rs.conf.blacklistPrefixes = PrefixesFromIPNets(fetched.blacklist)
 	}
 
-	if fetched.limitCount != nil &&
-		fetched.limitDuration != nil &&
-		fetched.limitEnabled != nil {
-		rs.conf.limit.Count = *fetched.limitCount
-		rs.conf.limit.Duration = *fetched.limitDuration
-		rs.conf.limit.Enabled = *fetched.limitEnabled
+	rs.warnCIDRConflicts()
+
+	if limit, ok := fetched.limit(); ok {
+		rs.conf.limitSynced = true
+		rs.conf.limit = limit
 	}
 
 	if fetched.reportOnly != nil {
 		rs.conf.reportOnly = *fetched.reportOnly
 	}
 
+	// keep the matcher, and its cached matches, unless the route limits changed
+	if fetched.routeLimits != nil && !sameRouteLimits(fetched.routeLimits, rs.conf.routeMatcher.routeLimits) {
+		rs.conf.routeMatcher = NewRouteMatcher(fetched.routeLimits, routeMatchCacheSize)
+	}
+
+	if fetched.rules != nil {
+		rs.conf.rules = fetched.rules
+	}
+
+	if fetched.authorityLimits != nil {
+		rs.conf.authorityLimits = fetched.authorityLimits
+	}
+
+	if fetched.keyLimits != nil {
+		rs.conf.keyLimits = fetched.keyLimits
+	}
+
+	if fetched.limitExperiment != nil {
+		rs.conf.limitExperiment = *fetched.limitExperiment
+	}
+
+	rs.conf.syncedAt = time.Now()
+
 	rs.logger.Debug("Updated conf")
 }
 
+// warnCIDRConflicts logs the conflicts between the whitelist and blacklist when they change. The conf must be locked.
+func (rs *RedisConfStore) warnCIDRConflicts() {
+	conflicts := []string{}
+	for _, c := range FindCIDRConflicts(rs.conf.whitelist, rs.conf.blacklist) {
+		conflicts = append(conflicts, c.String())
+	}
+
+	joined := strings.Join(conflicts, "; ")
+	if joined == rs.conf.conflicts {
+		return
+	}
+
+	rs.conf.conflicts = joined
+	if len(conflicts) > 0 {
+		rs.logger.Warnf("whitelist and blacklist conflict, requests from the overlaps are whitelisted: %v", joined)
+	}
+}
+
 type fetchConf struct {
-	whitelist     []net.IPNet
-	blacklist     []net.IPNet
-	limitCount    *uint64
-	limitDuration *time.Duration
-	limitEnabled  *bool
-	reportOnly    *bool
+	whitelist           []net.IPNet
+	whitelistHosts      []string
+	blacklist           []net.IPNet
+	limitCount          *uint64
+	limitDuration       *time.Duration
+	limitEnabled        *bool
+	limitAlgorithm      *Algorithm
+	limitEnforcePercent *uint
+	limitCalendar       *CalendarWindow
+	limitTimeZone       *string // set along with limitCalendar
+	limitRollover       *uint64
+	reportOnly          *bool
+	routeLimits         []RouteLimit
+	rules               []Rule
+	authorityLimits     map[string]LimitOverride
+	keyLimits           map[string]LimitOverride
+	limitExperiment     *LimitExperiment // disabled if the key doesn't exist
+	signature           []byte           // nil if the conf is unsigned
+
+	problems     []string // corrupt values found while fetching
+	limitMissing bool     // whether all of the required limit keys are missing
+}
+
+// limit returns the fetched limit, or false if any of its required keys weren't fetched
+func (c fetchConf) limit() (Limit, bool) {
+	if c.limitCount == nil || c.limitDuration == nil || c.limitEnabled == nil {
+		return Limit{}, false
+	}
+
+	limit := Limit{Count: *c.limitCount, Duration: *c.limitDuration, Enabled: *c.limitEnabled}
+	if c.limitAlgorithm != nil {
+		limit.Algorithm = *c.limitAlgorithm
+	}
+
+	if c.limitEnforcePercent != nil {
+		limit.EnforcePercent = *c.limitEnforcePercent
+	}
+
+	if c.limitCalendar != nil {
+		limit.Calendar = *c.limitCalendar
+		limit.TimeZone = *c.limitTimeZone
+	}
+
+	if c.limitRollover != nil {
+		limit.Rollover = *c.limitRollover
+	}
+
+	return limit, true
+}
+
+// setLimit sets the fetched limit to limit
+func (c *fetchConf) setLimit(limit Limit) {
+	c.limitCount = &limit.Count
+	c.limitDuration = &limit.Duration
+	c.limitEnabled = &limit.Enabled
+	c.limitAlgorithm = &limit.Algorithm
+	c.limitEnforcePercent = &limit.EnforcePercent
+	c.limitCalendar = &limit.Calendar
+	c.limitTimeZone = &limit.TimeZone
+	c.limitRollover = &limit.Rollover
+	c.limitMissing = false
 }
 
 func (rs *RedisConfStore) pipelinedFetchConf() fetchConf {
 	newConf := fetchConf{}
 	rs.logger.Debugf("Sending HKEYS for key %v", redisIPWhitelistKey)
+	rs.logger.Debugf("Sending HKEYS for key %v", redisWhitelistHostsKey)
 	rs.logger.Debugf("Sending HKEYS for key %v", redisIPBlacklistKey)
 	rs.logger.Debugf("Sending GET for key %v", redisLimitCountKey)
 	rs.logger.Debugf("Sending GET for key %v", redisLimitDurationKey)
 	rs.logger.Debugf("Sending GET for key %v", redisLimitEnabledKey)
+	rs.logger.Debugf("Sending GET for key %v", redisLimitAlgorithmKey)
+	rs.logger.Debugf("Sending GET for key %v", redisLimitEnforcePercentKey)
+	rs.logger.Debugf("Sending GET for key %v", redisLimitCalendarKey)
+	rs.logger.Debugf("Sending GET for key %v", redisLimitTimeZoneKey)
+	rs.logger.Debugf("Sending GET for key %v", redisLimitRolloverKey)
+	rs.logger.Debugf("Sending GET for key %v", redisLimitKey)
 	rs.logger.Debugf("Sending GET for key %v", redisReportOnlyKey)
-
-	pipe := rs.redis.Pipeline()
+	rs.logger.Debugf("Sending HGETALL for key %v", redisRouteLimitsKey)
+	rs.logger.Debugf("Sending HGETALL for key %v", redisRulesKey)
+	rs.logger.Debugf("Sending HGETALL for key %v", redisAuthorityLimitsKey)
+	rs.logger.Debugf("Sending HGETALL for key %v", redisKeyLimitsKey)
+	rs.logger.Debugf("Sending GET for key %v", redisLimitExperimentKey)
+	rs.logger.Debugf("Sending GET for key %v", redisConfSignatureKey)
+
+	// read in a transaction so that changes made together by UpdateConf are fetched together
+	pipe := rs.redis.TxPipeline()
 	whitelistKeysCmd := pipe.HKeys(redisIPWhitelistKey)
+	whitelistHostsCmd := pipe.HKeys(redisWhitelistHostsKey)
 	blacklistKeysCmd := pipe.HKeys(redisIPBlacklistKey)
 	limitCountCmd := pipe.Get(redisLimitCountKey)
 	limitDurationCmd := pipe.Get(redisLimitDurationKey)
 	limitEnabledCmd := pipe.Get(redisLimitEnabledKey)
+	limitAlgorithmCmd := pipe.Get(redisLimitAlgorithmKey)
+	limitEnforcePercentCmd := pipe.Get(redisLimitEnforcePercentKey)
+	limitCalendarCmd := pipe.Get(redisLimitCalendarKey)
+	limitTimeZoneCmd := pipe.Get(redisLimitTimeZoneKey)
+	limitRolloverCmd := pipe.Get(redisLimitRolloverKey)
+	limitRecordCmd := pipe.Get(redisLimitKey)
 	reportOnlyCmd := pipe.Get(redisReportOnlyKey)
+	routeLimitsCmd := pipe.HGetAll(redisRouteLimitsKey)
+	rulesCmd := pipe.HGetAll(redisRulesKey)
+	authorityLimitsCmd := pipe.HGetAll(redisAuthorityLimitsKey)
+	keyLimitsCmd := pipe.HGetAll(redisKeyLimitsKey)
+	limitExperimentCmd := pipe.Get(redisLimitExperimentKey)
+	signatureCmd := pipe.Get(redisConfSignatureKey)
 	pipe.Exec()
 
 	if whitelistStrs, err := whitelistKeysCmd.Result(); err == nil {
 		newConf.whitelist = IPNetsFromStrings(whitelistStrs, rs.logger)
+		if len(newConf.whitelist) != len(whitelistStrs) {
+			newConf.problems = append(newConf.problems, "whitelist contains invalid cidrs")
+		}
 	} else {
 		rs.logger.WithError(err).Warnf("error send HKEYS for key %v", redisIPWhitelistKey)
 	}
 
+	if hosts, err := whitelistHostsCmd.Result(); err == nil {
+		newConf.whitelistHosts = []string{}
+		for _, host := range hosts {
+			if err := ValidateHostname(host); err != nil {
+				rs.logger.WithError(err).Errorf("error parsing whitelist host %v", host)
+				continue
+			}
+			newConf.whitelistHosts = append(newConf.whitelistHosts, host)
+		}
+
+		if len(newConf.whitelistHosts) != len(hosts) {
+			newConf.problems = append(newConf.problems, "whitelist hosts contain invalid hostnames")
+		}
+		sort.Strings(newConf.whitelistHosts)
+	} else {
+		rs.logger.WithError(err).Warnf("error send HKEYS for key %v", redisWhitelistHostsKey)
+	}
+
 	if blacklistStrs, err := blacklistKeysCmd.Result(); err == nil {
 		newConf.blacklist = IPNetsFromStrings(blacklistStrs, rs.logger)
+		if len(newConf.blacklist) != len(blacklistStrs) {
+			newConf.problems = append(newConf.problems, "blacklist contains invalid cidrs")
+		}
 	} else {
 		rs.logger.WithError(err).Warnf("error send HKEYS for key %v", redisIPWhitelistKey)
 	}
 
-	if limitCount, err := limitCountCmd.Uint64(); err == nil {
-		newConf.limitCount = &limitCount
-	} else {
-		rs.logger.WithError(err).Warnf("error sending GET for key %v", redisLimitCountKey)
+	// the limit record is authoritative, the legacy limit keys only being read for limits set before it was written,
+	// or if it can't be applied
+	limitFromRecord := false
+	if recordStr, err := limitRecordCmd.Result(); err == nil {
+		if limit, err := decodeLimitRecord(recordStr); err != nil {
+			rs.logger.WithError(err).Warnf("error parsing limit record")
+			newConf.problems = append(newConf.problems, "invalid limit record")
+		} else {
+			newConf.setLimit(limit)
+			limitFromRecord = true
+		}
+	} else if err != redis.Nil {
+		rs.logger.WithError(err).Warnf("error sending GET for key %v", redisLimitKey)
 	}
 
-	if limitDurationStr, err := limitDurationCmd.Result(); err == nil {
-		limitDuration, err := time.ParseDuration(limitDurationStr)
-		if err != nil {
-			rs.logger.WithError(err).Warnf("error parsing limit duration")
+	if !limitFromRecord {
+		newConf.limitMissing = limitCountCmd.Err() == redis.Nil && limitDurationCmd.Err() == redis.Nil && limitEnabledCmd.Err() == redis.Nil
+		if limitCount, err := limitCountCmd.Uint64(); err == nil {
+			newConf.limitCount = &limitCount
+		} else if limitCountCmd.Err() == nil {
+			rs.logger.WithError(err).Warnf("error parsing limit count")
+			newConf.problems = append(newConf.problems, "invalid limit count")
 		} else {
-			newConf.limitDuration = &limitDuration
+			rs.logger.WithError(err).Warnf("error sending GET for key %v", redisLimitCountKey)
 		}
-	} else {
-		rs.logger.WithError(err).Errorf("error sending GET for key %v", redisLimitDurationKey)
-	}
 
-	if limitEnabledStr, err := limitEnabledCmd.Result(); err == nil {
-		limitEnabled, err := strconv.ParseBool(limitEnabledStr)
-		if err != nil {
-			rs.logger.WithError(err).Warnf("error parsing limit enabled")
+		if limitDurationStr, err := limitDurationCmd.Result(); err == nil {
+			limitDuration, err := time.ParseDuration(limitDurationStr)
+			if err != nil {
+				rs.logger.WithError(err).Warnf("error parsing limit duration")
+				newConf.problems = append(newConf.problems, "invalid limit duration")
+			} else {
+				newConf.limitDuration = &limitDuration
+			}
 		} else {
-			newConf.limitEnabled = &limitEnabled
+			rs.logger.WithError(err).Errorf("error sending GET for key %v", redisLimitDurationKey)
+		}
+
+		if limitEnabledStr, err := limitEnabledCmd.Result(); err == nil {
+			limitEnabled, err := strconv.ParseBool(limitEnabledStr)
+			if err != nil {
+				rs.logger.WithError(err).Warnf("error parsing limit enabled")
+				newConf.problems = append(newConf.problems, "invalid limit enabled")
+			} else {
+				newConf.limitEnabled = &limitEnabled
+			}
+		} else {
+			rs.logger.WithError(err).Errorf("error sending GET for key %v", redisLimitEnabledKey)
+		}
+
+		if limitAlgorithmStr, err := limitAlgorithmCmd.Result(); err == nil {
+			if _, err := ParseAlgorithm(limitAlgorithmStr); err != nil {
+				rs.logger.WithError(err).Warnf("error parsing limit algorithm")
+				newConf.problems = append(newConf.problems, "invalid limit algorithm")
+			} else {
+				limitAlgorithm := Algorithm(limitAlgorithmStr)
+				newConf.limitAlgorithm = &limitAlgorithm
+			}
+		} else if err != redis.Nil {
+			rs.logger.WithError(err).Warnf("error sending GET for key %v", redisLimitAlgorithmKey)
+		}
+
+		if limitEnforcePercent64, err := limitEnforcePercentCmd.Uint64(); err == nil {
+			limitEnforcePercent := uint(limitEnforcePercent64)
+			if err := ValidateEnforcePercent(limitEnforcePercent); err != nil {
+				rs.logger.WithError(err).Warnf("error parsing limit enforce percent")
+				newConf.problems = append(newConf.problems, "invalid limit enforce percent")
+			} else {
+				newConf.limitEnforcePercent = &limitEnforcePercent
+			}
+		} else if err != redis.Nil {
+			rs.logger.WithError(err).Warnf("error sending GET for key %v", redisLimitEnforcePercentKey)
+		}
+
+		limitCalendarStr, err := limitCalendarCmd.Result()
+		if err != nil && err != redis.Nil {
+			rs.logger.WithError(err).Warnf("error sending GET for key %v", redisLimitCalendarKey)
+		}
+
+		limitTimeZone, tzErr := limitTimeZoneCmd.Result()
+		if tzErr != nil && tzErr != redis.Nil {
+			rs.logger.WithError(tzErr).Warnf("error sending GET for key %v", redisLimitTimeZoneKey)
+		}
+
+		if err == nil && (tzErr == nil || tzErr == redis.Nil) {
+			limitCalendar := CalendarWindow(limitCalendarStr)
+			calendarLimit := Limit{Calendar: limitCalendar, TimeZone: limitTimeZone}
+			if newConf.limitAlgorithm != nil {
+				calendarLimit.Algorithm = *newConf.limitAlgorithm
+			}
+
+			if err := ValidateCalendar(calendarLimit); err != nil {
+				rs.logger.WithError(err).Warnf("error parsing limit calendar")
+				newConf.problems = append(newConf.problems, "invalid limit calendar")
+			} else {
+				newConf.limitCalendar = &limitCalendar
+				newConf.limitTimeZone = &limitTimeZone
+			}
+		}
+
+		if limitRollover, err := limitRolloverCmd.Uint64(); err == nil {
+			newConf.limitRollover = &limitRollover
+		} else if err != redis.Nil {
+			rs.logger.WithError(err).Warnf("error sending GET for key %v", redisLimitRolloverKey)
+		}
+	}
+
+	if limit, ok := newConf.limit(); ok {
+		if err := ValidateDayBuckets(limit); err != nil {
+			rs.logger.WithError(err).Warnf("error validating limit")
+			newConf.problems = append(newConf.problems, "invalid limit duration for algorithm")
+		}
+
+		if err := ValidateRollover(limit); err != nil {
+			rs.logger.WithError(err).Warnf("error validating limit")
+			newConf.problems = append(newConf.problems, "invalid limit rollover for algorithm")
 		}
-	} else {
-		rs.logger.WithError(err).Errorf("error sending GET for key %v", redisLimitEnabledKey)
 	}
 
 	if reportOnlyStr, err := reportOnlyCmd.Result(); err == nil {
 		reportOnly, err := strconv.ParseBool(reportOnlyStr)
 		if err != nil {
 			rs.logger.WithError(err).Warnf("error parsing report only")
+			newConf.problems = append(newConf.problems, "invalid report only")
 		} else {
 			newConf.reportOnly = &reportOnly
 		}
@@ -319,5 +791,71 @@ func (rs *RedisConfStore) pipelinedFetchConf() fetchConf {
 
 	}
 
+	// narrower scopes are validated against the limit they'll inherit from
+	parent, ok := newConf.limit()
+	if !ok {
+		parent = rs.GetLimit()
+	}
+
+	if routeLimitStrs, err := routeLimitsCmd.Result(); err == nil {
+		newConf.routeLimits = RouteLimitsFromStrings(routeLimitStrs, parent, rs.logger)
+		if len(newConf.routeLimits) != len(routeLimitStrs) {
+			newConf.problems = append(newConf.problems, "route limits contain invalid entries")
+		}
+	} else {
+		rs.logger.WithError(err).Warnf("error sending HGETALL for key %v", redisRouteLimitsKey)
+	}
+
+	if ruleStrs, err := rulesCmd.Result(); err == nil {
+		newConf.rules = RulesFromStrings(ruleStrs, rs.logger)
+		if len(newConf.rules) != len(ruleStrs) {
+			newConf.problems = append(newConf.problems, "rules contain invalid entries")
+		}
+	} else {
+		rs.logger.WithError(err).Warnf("error sending HGETALL for key %v", redisRulesKey)
+	}
+
+	if authorityLimitStrs, err := authorityLimitsCmd.Result(); err == nil {
+		newConf.authorityLimits = LimitOverridesFromStrings(AuthorityLimitScope, authorityLimitStrs, parent, rs.logger)
+		if len(newConf.authorityLimits) != len(authorityLimitStrs) {
+			newConf.problems = append(newConf.problems, "authority limits contain invalid entries")
+		}
+	} else {
+		rs.logger.WithError(err).Warnf("error sending HGETALL for key %v", redisAuthorityLimitsKey)
+	}
+
+	if keyLimitStrs, err := keyLimitsCmd.Result(); err == nil {
+		newConf.keyLimits = LimitOverridesFromStrings(KeyLimitScope, keyLimitStrs, parent, rs.logger)
+		if len(newConf.keyLimits) != len(keyLimitStrs) {
+			newConf.problems = append(newConf.problems, "key limits contain invalid entries")
+		}
+	} else {
+		rs.logger.WithError(err).Warnf("error sending HGETALL for key %v", redisKeyLimitsKey)
+	}
+
+	if experimentStr, err := limitExperimentCmd.Result(); err == nil {
+		if experiment, err := parseLimitExperiment(experimentStr, parent); err != nil {
+			rs.logger.WithError(err).Warnf("error parsing limit experiment")
+			newConf.problems = append(newConf.problems, "invalid limit experiment")
+		} else {
+			newConf.limitExperiment = &experiment
+		}
+	} else if err == redis.Nil {
+		newConf.limitExperiment = &LimitExperiment{}
+	} else {
+		rs.logger.WithError(err).Warnf("error sending GET for key %v", redisLimitExperimentKey)
+	}
+
+	if signatureStr, err := signatureCmd.Result(); err == nil {
+		signature, err := base64.StdEncoding.DecodeString(signatureStr)
+		if err != nil {
+			rs.logger.WithError(err).Warnf("error decoding conf signature")
+		} else {
+			newConf.signature = signature
+		}
+	} else if err != redis.Nil {
+		rs.logger.WithError(err).Warnf("error sending GET for key %v", redisConfSignatureKey)
+	}
+
 	return newConf
 }
@@ -21,7 +21,7 @@ func newTestConfStoreWithDefaults(t *testing.T, defaultWhitelist []net.IPNet, de
 	}
 
 	redis := redis.NewClient(&redis.Options{Addr: s.Addr()})
-	return NewRedisConfStore(redis, defaultWhitelist, defaultBlacklist, defaultLimit, defaultReportOnly, TestingLogger), s
+	return NewRedisConfStore(redis, defaultWhitelist, defaultBlacklist, defaultLimit, defaultReportOnly, nil, false, TestingLogger, NullReporter{}), s
 }
 
 func TestConfStoreReturnsDefaults(t *testing.T) {
@@ -285,3 +285,228 @@ func TestConfStoreRemoveBlacklistCidr(t *testing.T) {
 		t.Errorf("expected: %v received: %v", expected, got)
 	}
 }
+
+func TestConfStoreLimitAlgorithm(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	expectedLimit := Limit{Count: 20, Duration: time.Second, Enabled: true, Algorithm: LeakyBucketAlgorithm, EnforcePercent: 10}
+	if err := c.SetLimit(expectedLimit); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	gotLimit, err := c.FetchLimit()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if gotLimit != expectedLimit {
+		t.Errorf("expected: %v received: %v", expectedLimit, gotLimit)
+	}
+
+	c.UpdateCachedConf()
+	s.Set(redisLimitAlgorithmKey, "unknown")
+	s.Set(redisLimitEnforcePercentKey, "150")
+	c.UpdateCachedConf()
+
+	if got := c.GetLimit(); got != expectedLimit {
+		t.Errorf("expected an invalid algorithm and enforce percent to keep the last known good limit %v, received: %v", expectedLimit, got)
+	}
+}
+
+func TestConfStoreRouteLimits(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	if got := c.GetRouteLimits(); len(got) != 0 {
+		t.Fatalf("expected no route limits by default, received: %v", got)
+	}
+
+	orders, _ := ParseRoutePattern("/users/{id}/orders")
+	users, _ := ParseRoutePattern("/users/{id}")
+	ordersLimit := LimitOverrideFromLimit(Limit{Count: 5, Duration: time.Minute, Enabled: true, Algorithm: FixedWindowAlgorithm})
+	usersLimit := LimitOverrideFromLimit(Limit{Count: 20, Duration: time.Second, Enabled: true, Algorithm: LeakyBucketAlgorithm})
+
+	if err := c.SetRouteLimit(users, usersLimit); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := c.SetRouteLimit(orders, ordersLimit); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c.UpdateCachedConf()
+	got := c.GetRouteLimits()
+	if len(got) != 2 || got[0].Route.String() != orders.String() || !got[0].Limit.Equal(ordersLimit) || got[1].Route.String() != users.String() || !got[1].Limit.Equal(usersLimit) {
+		t.Fatalf("unexpected route limits: %v", got)
+	}
+
+	if err := c.RemoveRouteLimit(users); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	fetched, err := c.FetchRouteLimits()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(fetched) != 1 || fetched[0].Route.String() != orders.String() {
+		t.Fatalf("unexpected route limits: %v", fetched)
+	}
+}
+
+func TestConfStoreLimitCalendar(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	expectedLimit := Limit{Count: 1000, Duration: time.Second, Enabled: true, Calendar: DayWindow, TimeZone: "America/Los_Angeles"}
+	if err := c.SetLimit(expectedLimit); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c.UpdateCachedConf()
+	if got := c.GetLimit(); got != expectedLimit {
+		t.Errorf("expected: %v received: %v", expectedLimit, got)
+	}
+
+	s.Set(redisLimitTimeZoneKey, "Nowhere/Special")
+	c.UpdateCachedConf()
+
+	if got := c.GetLimit(); got != expectedLimit {
+		t.Errorf("expected an invalid time zone to keep the last known good limit %v, received: %v", expectedLimit, got)
+	}
+}
+
+func TestConfStoreRules(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	rule, err := RuleDocument{When: `req.path.startsWith("/admin")`, Action: "block"}.Rule("block-admin")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := c.SetRule(rule); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c.UpdateCachedConf()
+	got := c.GetRules()
+	if len(got) != 1 || got[0].Name != rule.Name || got[0].When.String() != rule.When.String() || got[0].Action != BlockAction {
+		t.Fatalf("unexpected rules: %v", got)
+	}
+
+	if err := c.RemoveRule(rule.Name); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	fetched, err := c.FetchRules()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(fetched) != 0 {
+		t.Fatalf("expected no rules, received: %v", fetched)
+	}
+}
+
+func TestConfStoreRejectsStaticResponsesUnlessHonored(t *testing.T) {
+	rule, err := RuleDocument{When: `req.path == "/wp-login.php"`, Action: "serve", Response: &StaticResponse{Status: 200}}.Rule("honeypot")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	for _, honored := range []bool{false, true} {
+		s, err := miniredis.Run()
+		if err != nil {
+			t.Fatalf("error creating miniredis")
+		}
+		defer s.Close()
+
+		reporter := &FakeConfSyncReporter{}
+		c := NewRedisConfStore(redis.NewClient(&redis.Options{Addr: s.Addr()}), []net.IPNet{}, []net.IPNet{}, Limit{}, false, nil, honored, TestingLogger, reporter)
+		if err := c.SetRule(rule); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		c.UpdateCachedConf()
+		if got := len(c.GetRules()) == 1; got != honored {
+			t.Errorf("static responses honored: %v expected the serve rule applied: %v received: %v", honored, honored, got)
+		}
+
+		if want := []bool{!honored}; !cmp.Equal(reporter.rejected, want) {
+			t.Errorf("expected conf sync metrics: %v received: %v", want, reporter.rejected)
+		}
+	}
+}
+
+type FakeConfSyncReporter struct {
+	NullReporter
+	rejected []bool
+}
+
+func (f *FakeConfSyncReporter) ConfSync(rejected bool) {
+	f.rejected = append(f.rejected, rejected)
+}
+
+func TestConfStoreKeepsLastKnownGoodConfAfterFlush(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis")
+	}
+	defer s.Close()
+
+	reporter := &FakeConfSyncReporter{}
+	c := NewRedisConfStore(redis.NewClient(&redis.Options{Addr: s.Addr()}), []net.IPNet{}, []net.IPNet{}, Limit{}, false, nil, false, TestingLogger, reporter)
+
+	expectedBlacklist := parseCIDRs([]string{"12.0.0.1/8"})
+	expectedLimit := Limit{Count: 20, Duration: time.Second, Enabled: true}
+	if err := c.AddBlacklistCidrs(expectedBlacklist); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := c.SetLimit(expectedLimit); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c.UpdateCachedConf()
+	s.FlushAll()
+	c.UpdateCachedConf()
+
+	if got := c.GetBlacklist(); !cmp.Equal(got, expectedBlacklist) {
+		t.Errorf("expected: %v received: %v", expectedBlacklist, got)
+	}
+
+	if got := c.GetLimit(); got != expectedLimit {
+		t.Errorf("expected: %v received: %v", expectedLimit, got)
+	}
+
+	if want := []bool{false, true}; !cmp.Equal(reporter.rejected, want) {
+		t.Errorf("expected conf sync metrics: %v received: %v", want, reporter.rejected)
+	}
+}
+
+func TestConfStoreRejectsCorruptConf(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	if err := c.AddWhitelistCidrs(parseCIDRs([]string{"10.0.0.1/8"})); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	c.UpdateCachedConf()
+	s.HSet(redisIPWhitelistKey, "not-a-cidr", "true")
+	s.HSet(redisIPBlacklistKey, "12.0.0.1/8", "true")
+	c.UpdateCachedConf()
+
+	if got := c.GetBlacklist(); len(got) != 0 {
+		t.Errorf("expected blacklist from a corrupt sync not to be applied, received: %v", got)
+	}
+
+	s.HDel(redisIPWhitelistKey, "not-a-cidr")
+	c.UpdateCachedConf()
+
+	if got := c.GetBlacklist(); len(got) != 1 {
+		t.Errorf("expected blacklist to be applied once the conf is valid, received: %v", got)
+	}
+}
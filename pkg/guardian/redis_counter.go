@@ -12,9 +12,23 @@ import (
 )
 
 const limitStoreNamespace = "limit_store"
+const janitorScanCount = 1000
+const maxSpilledKeys = 100000
 
-func NewRedisCounter(redis *redis.Client, synchronous bool, logger logrus.FieldLogger, reporter MetricReporter) *RedisCounter {
-	return &RedisCounter{redis: redis, synchronous: synchronous, logger: logger, cache: &lockingExpiringMap{m: make(map[string]item)}, reporter: reporter}
+// NewRedisCounter creates a new RedisCounter. While breaker is open, increments are counted locally and merged back into
+// Redis once it closes. A nil breaker disables local counting. Increments and reads of counts that are slow to return
+// are hedged as configured by hedging, the zero RedisHedging disabling hedging.
+func NewRedisCounter(redis *redis.Client, synchronous bool, breaker *CircuitBreaker, hedging RedisHedging, logger logrus.FieldLogger, reporter MetricReporter) *RedisCounter {
+	return &RedisCounter{
+		redis:       redis,
+		synchronous: synchronous,
+		breaker:     breaker,
+		hedging:     hedging,
+		logger:      logger,
+		cache:       &lockingExpiringMap{m: make(map[string]item)},
+		spill:       &lockingExpiringMap{m: make(map[string]item)},
+		reporter:    reporter,
+	}
 }
 
 type item struct {
@@ -32,11 +46,17 @@ type lockingExpiringMap struct {
 // TODO: fetch the current limit configuration from redis instead of using
 // a static one
 type RedisCounter struct {
+	// maxTTL caps the expiration of counter keys while Redis exceeds its memory budget, in nanoseconds. It's first
+	// for 64 bit alignment of atomic operations.
+	maxTTL      int64
 	redis       *redis.Client
 	synchronous bool
+	breaker     *CircuitBreaker
+	hedging     RedisHedging
 	logger      logrus.FieldLogger
 	reporter    MetricReporter
 	cache       *lockingExpiringMap
+	spill       *lockingExpiringMap // increments counted while the breaker is open, pending merge into Redis
 }
 
 func (rs *RedisCounter) Run(pruneInterval time.Duration, stop <-chan struct{}) {
@@ -52,13 +72,45 @@ func (rs *RedisCounter) Run(pruneInterval time.Duration, stop <-chan struct{}) {
 	}
 }
 
+// RunJanitor periodically scans the limit store for counter keys that are missing an expiration (which can happen when
+// a pipeline partially fails) and expires them after orphanExpiration so they don't accumulate in Redis forever. Only
+// the leader scans, if there is one.
+func (rs *RedisCounter) RunJanitor(interval time.Duration, orphanExpiration time.Duration, leader LeaderProvider, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			if isLeader(leader) {
+				rs.expireOrphans(orphanExpiration)
+			}
+		case <-stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
 func (rs *RedisCounter) Incr(context context.Context, key string, incrBy uint, maxBeforeBlock uint64, expireIn time.Duration) (uint64, bool, error) {
 	runIncrFunc := func() (item, error) {
+		if !rs.breaker.Allow() {
+			return rs.spillIncr(key, incrBy, maxBeforeBlock, expireIn), nil
+		}
+
 		count, err := rs.doIncr(context, key, incrBy, expireIn)
 		if err != nil {
 			rs.logger.WithError(err).Error("error incrementing")
+			if count > 0 { // the increment reached redis, so it's reachable though the expiration wasn't set
+				rs.recordSuccess()
+				return item{}, err
+			}
+
+			rs.recordFailure()
+			if rs.breaker != nil {
+				return rs.spillIncr(key, incrBy, maxBeforeBlock, expireIn), nil
+			}
 			return item{}, err
 		}
+		rs.recordSuccess()
 
 		item := item{val: count, blocked: count > maxBeforeBlock, expireAt: time.Now().Add(expireIn)}
 		rs.cache.Lock()
@@ -87,6 +139,191 @@ func (rs *RedisCounter) Incr(context context.Context, key string, incrBy uint, m
 	return curr.val, curr.blocked, err
 }
 
+func (rs *RedisCounter) IncrBatch(context context.Context, incrs []CounterIncr) ([]CounterResult, error) {
+	results := make([]CounterResult, len(incrs))
+	pending := []CounterIncr{}
+	pendingIdx := []int{}
+	local := make(map[string]uint64) // accounts for a key appearing more than once in the batch
+
+	rs.cache.RLock()
+	for i, incr := range incrs {
+		existing := rs.cache.m[incr.Key]
+		local[incr.Key] += uint64(incr.IncrBy)
+		count := existing.val + local[incr.Key]
+		if existing.blocked {
+			results[i] = CounterResult{Count: count, Blocked: true}
+			continue
+		}
+
+		results[i] = CounterResult{Count: count, Blocked: count > incr.MaxBeforeBlock}
+		pending = append(pending, incr)
+		pendingIdx = append(pendingIdx, i)
+	}
+	rs.cache.RUnlock()
+
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	spillBatch := func() ([]CounterResult, error) {
+		fetched := make([]CounterResult, len(pending))
+		for i, incr := range pending {
+			item := rs.spillIncr(incr.Key, incr.IncrBy, incr.MaxBeforeBlock, incr.ExpireIn)
+			fetched[i] = CounterResult{Count: item.val, Blocked: item.blocked}
+		}
+
+		return fetched, nil
+	}
+
+	runIncrBatchFunc := func() ([]CounterResult, error) {
+		if !rs.breaker.Allow() {
+			return spillBatch()
+		}
+
+		counts, err := rs.doIncrBatch(context, pending)
+		if err != nil {
+			rs.logger.WithError(err).Error("error incrementing batch")
+			if rs.breaker != nil {
+				rs.recordFailure()
+				return spillBatch()
+			}
+			return nil, err
+		}
+		rs.recordSuccess()
+
+		now := time.Now()
+		fetched := make([]CounterResult, len(pending))
+		rs.cache.Lock()
+		for i, incr := range pending {
+			item := item{val: counts[i], blocked: counts[i] > incr.MaxBeforeBlock, expireAt: now.Add(incr.ExpireIn)}
+			rs.cache.m[incr.Key] = item
+			fetched[i] = CounterResult{Count: item.val, Blocked: item.blocked}
+		}
+		rs.cache.Unlock()
+
+		return fetched, nil
+	}
+
+	if !rs.synchronous {
+		go runIncrBatchFunc()
+		return results, nil
+	}
+
+	fetched, err := runIncrBatchFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, idx := range pendingIdx {
+		results[idx] = fetched[i]
+	}
+
+	return results, nil
+}
+
+// spillIncr counts an increment locally while Redis is unavailable
+func (rs *RedisCounter) spillIncr(key string, incrBy uint, maxBeforeBlock uint64, expireIn time.Duration) item {
+	now := time.Now()
+
+	rs.spill.Lock()
+	spilled, ok := rs.spill.m[key]
+	if !ok || spilled.expireAt.Before(now) {
+		spilled = item{expireAt: now.Add(expireIn)}
+	}
+	spilled.val += uint64(incrBy)
+	if ok || len(rs.spill.m) < maxSpilledKeys {
+		rs.spill.m[key] = spilled
+	}
+	rs.spill.Unlock()
+
+	rs.cache.Lock()
+	defer rs.cache.Unlock()
+
+	existing, ok := rs.cache.m[key]
+	if !ok || existing.expireAt.Before(now) {
+		existing = item{expireAt: now.Add(expireIn)}
+	}
+	existing.val += uint64(incrBy)
+	existing.blocked = existing.val > maxBeforeBlock
+	rs.cache.m[key] = existing
+
+	return existing
+}
+
+func (rs *RedisCounter) recordFailure() {
+	if rs.breaker.Failure() {
+		rs.logger.Warn("redis circuit breaker opened, counting locally until redis recovers")
+	}
+}
+
+func (rs *RedisCounter) recordSuccess() {
+	if rs.breaker.Success() {
+		rs.logger.Warn("redis circuit breaker closed, merging local counts into redis")
+		go rs.mergeSpill()
+	}
+}
+
+// mergeSpill adds the increments counted locally while the breaker was open back into Redis. This is best effort,
+// counts that fail to merge or whose window has ended are dropped.
+func (rs *RedisCounter) mergeSpill() {
+	start := time.Now()
+	merged := 0
+	err := error(nil)
+	defer func() {
+		rs.reporter.RedisCounterSpillMerged(time.Now().Sub(start), float64(merged), err != nil)
+	}()
+
+	rs.spill.Lock()
+	spilled := rs.spill.m
+	rs.spill.m = make(map[string]item)
+	rs.spill.Unlock()
+
+	now := time.Now()
+	pipe := rs.redis.Pipeline()
+	for key, spilledItem := range spilled {
+		expireIn := spilledItem.expireAt.Sub(now)
+		if expireIn < time.Second {
+			continue
+		}
+
+		key = NamespacedKey(limitStoreNamespace, key)
+		pipe.IncrBy(key, int64(spilledItem.val))
+		pipe.Expire(key, rs.expiration(expireIn))
+		merged++
+	}
+
+	if merged == 0 {
+		return
+	}
+
+	rs.logger.Debugf("Sending pipeline of INCRBY EXPIRE to merge %d spilled keys", merged)
+	if _, err = pipe.Exec(); err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("error merging %d spilled keys", merged))
+		rs.logger.WithError(err).Error("error executing pipeline")
+	}
+}
+
+func (rs *RedisCounter) Count(context context.Context, key string) (uint64, error) {
+	key = NamespacedKey(limitStoreNamespace, key)
+
+	if rs.hedging.Threshold > 0 {
+		count, err := rs.hedgedGet(key)
+		return count, errors.Wrap(err, fmt.Sprintf("error fetching count for key %v", key))
+	}
+
+	rs.logger.Debugf("Sending GET for key %v", key)
+	count, err := rs.redis.Get(key).Uint64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("error fetching count for key %v", key))
+	}
+
+	return count, nil
+}
+
 func (rs *RedisCounter) pruneCache(olderThan time.Time) {
 	start := time.Now()
 	cacheSize := 0
@@ -115,6 +352,16 @@ func (rs *RedisCounter) doIncr(context context.Context, key string, incrBy uint,
 	}()
 
 	key = NamespacedKey(limitStoreNamespace, key)
+	expireIn = rs.expiration(expireIn)
+
+	if rs.hedging.Threshold > 0 {
+		var count uint64
+		count, err = rs.hedgedIncr(key, incrBy, expireIn)
+		if err != nil {
+			rs.logger.WithError(err).Error("error running hedged increment")
+		}
+		return count, err
+	}
 
 	rs.logger.Debugf("Sending pipeline for key %v INCRBY %v EXPIRE %v", key, incrBy, expireIn.Seconds())
 
@@ -141,3 +388,115 @@ func (rs *RedisCounter) doIncr(context context.Context, key string, incrBy uint,
 	rs.logger.Debugf("Successfully executed pipeline and got response: %v %v", count, expireSet)
 	return count, nil
 }
+
+func (rs *RedisCounter) expireOrphans(orphanExpiration time.Duration) {
+	start := time.Now()
+	scanned := 0
+	orphans := 0
+	err := error(nil)
+	defer func() {
+		rs.reporter.RedisCounterJanitor(time.Now().Sub(start), float64(scanned), float64(orphans), err != nil)
+	}()
+
+	match := NamespacedKey(limitStoreNamespace, "*")
+	cursor := uint64(0)
+	for {
+		var keys []string
+		keys, cursor, err = rs.redis.Scan(cursor, match, janitorScanCount).Result()
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("error scanning for keys matching %v", match))
+			rs.logger.WithError(err).Error("error running janitor")
+			return
+		}
+
+		scanned += len(keys)
+		found, ferr := rs.expireOrphanKeys(keys, orphanExpiration)
+		orphans += found
+		if ferr != nil {
+			err = ferr
+			rs.logger.WithError(err).Error("error running janitor")
+			return
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if orphans > 0 {
+		rs.logger.Warnf("janitor expired %d orphaned counter keys out of %d scanned", orphans, scanned)
+	}
+}
+
+func (rs *RedisCounter) expireOrphanKeys(keys []string, orphanExpiration time.Duration) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	pipe := rs.redis.Pipeline()
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		ttls[i] = pipe.TTL(key)
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		return 0, errors.Wrap(err, "error fetching ttls")
+	}
+
+	orphans := []string{}
+	for i, ttl := range ttls {
+		// a TTL of -1 indicates the key exists but has no associated expire
+		if ttl.Val() == -1*time.Second {
+			orphans = append(orphans, keys[i])
+		}
+	}
+
+	if len(orphans) == 0 {
+		return 0, nil
+	}
+
+	rs.logger.Debugf("Sending pipeline EXPIRE %v for orphaned keys %v", orphanExpiration.Seconds(), orphans)
+	pipe = rs.redis.Pipeline()
+	for _, key := range orphans {
+		pipe.Expire(key, orphanExpiration)
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		return 0, errors.Wrap(err, "error expiring orphaned keys")
+	}
+
+	return len(orphans), nil
+}
+
+func (rs *RedisCounter) doIncrBatch(context context.Context, incrs []CounterIncr) ([]uint64, error) {
+	start := time.Now()
+	err := error(nil)
+	defer func() {
+		rs.reporter.RedisCounterIncr(time.Now().Sub(start), err != nil)
+	}()
+
+	rs.logger.Debugf("Sending pipeline of INCRBY EXPIRE for %d keys", len(incrs))
+
+	pipe := rs.redis.Pipeline()
+	cmds := make([]*redis.IntCmd, len(incrs))
+	for i, incr := range incrs {
+		key := NamespacedKey(limitStoreNamespace, incr.Key)
+		cmds[i] = pipe.IncrBy(key, int64(incr.IncrBy))
+		pipe.Expire(key, rs.expiration(incr.ExpireIn))
+	}
+
+	_, err = pipe.Exec()
+	if err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("error incrementing batch of %d keys", len(incrs)))
+		rs.logger.WithError(err).Error("error executing pipeline")
+		return nil, err
+	}
+
+	counts := make([]uint64, len(cmds))
+	for i, cmd := range cmds {
+		counts[i] = uint64(cmd.Val())
+	}
+
+	rs.logger.Debugf("Successfully executed pipeline and got response: %v", counts)
+	return counts, nil
+}
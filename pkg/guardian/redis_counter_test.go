@@ -17,7 +17,7 @@ func newTestRedisCounter(t *testing.T) (*RedisCounter, *miniredis.Miniredis) {
 	}
 
 	redis := redis.NewClient(&redis.Options{Addr: s.Addr()})
-	return NewRedisCounter(redis, false, TestingLogger, NullReporter{}), s
+	return NewRedisCounter(redis, false, nil, RedisHedging{}, TestingLogger, NullReporter{}), s
 }
 
 func TestRedisCounterIncr(t *testing.T) {
@@ -90,3 +90,123 @@ func TestPrune(t *testing.T) {
 	}
 
 }
+
+func TestJanitorExpiresOrphans(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	orphanKey := NamespacedKey(limitStoreNamespace, "orphan")
+	healthyKey := NamespacedKey(limitStoreNamespace, "healthy")
+	unrelatedKey := "unrelated"
+
+	s.Set(orphanKey, "5")
+	s.Set(healthyKey, "5")
+	s.SetTTL(healthyKey, time.Hour)
+	s.Set(unrelatedKey, "5")
+
+	orphanExpiration := time.Minute
+	c.expireOrphans(orphanExpiration)
+
+	if got := s.TTL(orphanKey); got != orphanExpiration {
+		t.Fatalf("expected: %v received: %v", orphanExpiration, got)
+	}
+
+	if got := s.TTL(healthyKey); got != time.Hour {
+		t.Fatalf("expected: %v received: %v", time.Hour, got)
+	}
+
+	if got := s.TTL(unrelatedKey); got != 0 {
+		t.Fatalf("expected: %v received: %v", 0, got)
+	}
+}
+
+func TestRedisCounterIncrBatch(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+	c.synchronous = true
+
+	expire := time.Minute
+	incrs := []CounterIncr{
+		{Key: "a", IncrBy: 1, MaxBeforeBlock: 1, ExpireIn: expire},
+		{Key: "b", IncrBy: 1, MaxBeforeBlock: 1, ExpireIn: expire},
+		{Key: "a", IncrBy: 1, MaxBeforeBlock: 1, ExpireIn: expire},
+	}
+
+	results, err := c.IncrBatch(context.Background(), incrs)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	want := []CounterResult{{Count: 1, Blocked: false}, {Count: 1, Blocked: false}, {Count: 2, Blocked: true}}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("incr %d expected: %v received: %v", i, want[i], results[i])
+		}
+	}
+
+	if got := s.TTL(NamespacedKey(limitStoreNamespace, "a")); got != expire {
+		t.Fatalf("expected: %v received: %v", expire, got)
+	}
+}
+
+func TestRedisCounterSpillsWhileRedisDown(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis")
+	}
+	defer s.Close()
+
+	addr := s.Addr()
+	redis := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: 0, DialTimeout: 50 * time.Millisecond})
+	breaker := NewCircuitBreaker(1, 50*time.Millisecond)
+	c := NewRedisCounter(redis, true, breaker, RedisHedging{}, TestingLogger, NullReporter{})
+
+	key := "test_key"
+	namespacedKey := NamespacedKey(limitStoreNamespace, key)
+	if _, _, err := c.Incr(context.Background(), key, 2, 10, time.Minute); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	s.Close()
+
+	for i := 0; i < 3; i++ {
+		count, _, err := c.Incr(context.Background(), key, 1, 10, time.Minute)
+		if err != nil {
+			t.Fatalf("expected local count while redis is down, got error: %v", err)
+		}
+
+		if want := uint64(3 + i); count != want {
+			t.Fatalf("expected: %v received: %v", want, count)
+		}
+	}
+
+	if !breaker.Open() {
+		t.Fatal("expected circuit breaker to be open")
+	}
+
+	if err := s.Restart(); err != nil {
+		t.Fatalf("error restarting miniredis: %v", err)
+	}
+	s.Set(namespacedKey, "2") // restarting clears miniredis, restore the count made before the outage
+
+	time.Sleep(100 * time.Millisecond) // wait for the breaker to allow a probe
+	count, _, err := c.Incr(context.Background(), key, 1, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if count != 3 {
+		t.Fatalf("expected probe to return the redis count of 3, received: %v", count)
+	}
+
+	time.Sleep(100 * time.Millisecond) // wait for spilled counts to merge
+
+	gotCountStr, err := s.Get(namespacedKey)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if gotCount, _ := strconv.Atoi(gotCountStr); gotCount != 6 {
+		t.Fatalf("expected merged count of 6, received: %v", gotCount)
+	}
+}
@@ -0,0 +1,112 @@
+package guardian
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const hedgeTokenNamespace = "hedge_token"
+
+// hedgeTokenTTL is how long the token shared by the attempts of a hedged increment is kept. It must outlast the slowest
+// attempt for the increment to be counted once.
+const hedgeTokenTTL = 10 * time.Second
+
+// RedisHedging configures hedged Redis commands. A command that hasn't returned within Threshold is attempted a second
+// time and whichever attempt returns first is used, cutting the tail latency of transiently slow shards.
+type RedisHedging struct {
+	// Threshold is how long a command is waited on before it's hedged. Hedging is disabled if 0.
+	Threshold time.Duration
+	// Replica is the client hedged reads are sent to, the primary if nil. Hedged writes are always sent to the primary.
+	Replica *redis.Client
+}
+
+// hedgedIncrScript increments a counter only if the token shared by the attempts of an increment is new, so the
+// increment is counted once however many attempts reach Redis. Attempts after the first return the current count.
+var hedgedIncrScript = redis.NewScript(`
+if redis.call("set", KEYS[2], "1", "NX", "PX", ARGV[3]) then
+	local count = redis.call("incrby", KEYS[1], ARGV[1])
+	redis.call("pexpire", KEYS[1], ARGV[2])
+	return count
+end
+return tonumber(redis.call("get", KEYS[1]) or "0")
+`)
+
+type hedgeResult struct {
+	val    uint64
+	hedged bool
+	err    error
+}
+
+// hedge runs attempt, running it again as a hedge if it hasn't returned within the hedging threshold, and returns the
+// first successful result or the error of the attempt to fail last. An attempt that loses is left to finish in the
+// background.
+func (rs *RedisCounter) hedge(command string, attempt func(hedged bool) (uint64, error)) (uint64, error) {
+	results := make(chan hedgeResult, 2)
+	run := func(hedged bool) {
+		val, err := attempt(hedged)
+		results <- hedgeResult{val: val, hedged: hedged, err: err}
+	}
+
+	go run(false)
+	timer := time.NewTimer(rs.hedging.Threshold)
+	defer timer.Stop()
+
+	select {
+	case r := <-results:
+		return r.val, r.err
+	case <-timer.C:
+	}
+
+	rs.logger.Debugf("hedging %v after %v", command, rs.hedging.Threshold)
+	go run(true)
+	r := <-results
+	if r.err != nil {
+		r = <-results
+	}
+
+	rs.reporter.RedisCounterHedged(command, r.hedged)
+	return r.val, r.err
+}
+
+// hedgedIncr increments the namespaced key, hedging the increment with an attempt sharing its token
+func (rs *RedisCounter) hedgedIncr(key string, incrBy uint, expireIn time.Duration) (uint64, error) {
+	tokenKey := NamespacedKey(hedgeTokenNamespace, key+":"+strconv.FormatUint(rand.Uint64(), 36))
+	expireMs := int64(expireIn / time.Millisecond)
+	tokenTTLMs := int64(hedgeTokenTTL / time.Millisecond)
+
+	rs.logger.Debugf("Running hedged increment script for key %v INCRBY %v EXPIRE %v", key, incrBy, expireIn.Seconds())
+	return rs.hedge("incr", func(hedged bool) (uint64, error) {
+		res, err := hedgedIncrScript.Run(rs.redis, []string{key, tokenKey}, incrBy, expireMs, tokenTTLMs).Result()
+		if err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("error incrementing key %v with increase %d and expiration %v", key, incrBy, expireIn))
+		}
+
+		count, ok := res.(int64)
+		if !ok {
+			return 0, fmt.Errorf("unexpected hedged increment script response %v", res)
+		}
+
+		return uint64(count), nil
+	})
+}
+
+// hedgedGet gets the count of the namespaced key, hedging the read to the replica if there is one
+func (rs *RedisCounter) hedgedGet(key string) (uint64, error) {
+	return rs.hedge("get", func(hedged bool) (uint64, error) {
+		client := rs.redis
+		if hedged && rs.hedging.Replica != nil {
+			client = rs.hedging.Replica
+		}
+
+		count, err := client.Get(key).Uint64()
+		if err == redis.Nil {
+			return 0, nil
+		}
+		return count, err
+	})
+}
@@ -0,0 +1,117 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+)
+
+func newTestHedgedRedisCounter(t *testing.T, threshold time.Duration) (*RedisCounter, *miniredis.Miniredis) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis")
+	}
+
+	redis := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	return NewRedisCounter(redis, true, nil, RedisHedging{Threshold: threshold}, TestingLogger, NullReporter{}), s
+}
+
+func TestRedisCounterHedge(t *testing.T) {
+	c, s := newTestHedgedRedisCounter(t, 10*time.Millisecond)
+	defer s.Close()
+
+	tests := []struct {
+		name    string
+		attempt func(hedged bool) (uint64, error)
+		want    uint64
+		wantErr bool
+	}{
+		{
+			name:    "FastAttempt",
+			attempt: func(hedged bool) (uint64, error) { return 1, nil },
+			want:    1,
+		},
+		{
+			name: "SlowAttemptHedged",
+			attempt: func(hedged bool) (uint64, error) {
+				if !hedged {
+					time.Sleep(time.Second)
+					return 1, nil
+				}
+				return 2, nil
+			},
+			want: 2,
+		},
+		{
+			name: "HedgeFails",
+			attempt: func(hedged bool) (uint64, error) {
+				if !hedged {
+					time.Sleep(50 * time.Millisecond)
+					return 1, nil
+				}
+				return 0, fmt.Errorf("hedge failed")
+			},
+			want: 1,
+		},
+		{
+			name: "BothFail",
+			attempt: func(hedged bool) (uint64, error) {
+				time.Sleep(20 * time.Millisecond)
+				return 0, fmt.Errorf("failed")
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := c.hedge("test", test.attempt)
+			if test.wantErr != (err != nil) {
+				t.Fatalf("expected error: %v received: %v", test.wantErr, err)
+			}
+
+			if got != test.want {
+				t.Errorf("expected: %v received: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestRedisCounterHedgedIncrCountsOnce(t *testing.T) {
+	c, s := newTestHedgedRedisCounter(t, time.Second)
+	defer s.Close()
+
+	key := NamespacedKey(limitStoreNamespace, "test_key")
+	tokenKey := NamespacedKey(hedgeTokenNamespace, key+":token")
+	for i := 0; i < 2; i++ {
+		res, err := hedgedIncrScript.Run(c.redis, []string{key, tokenKey}, 3, 60000, 10000).Result()
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if res.(int64) != 3 {
+			t.Errorf("expected attempt %d to return 3, received: %v", i, res)
+		}
+	}
+
+	count, blocked, err := c.Incr(context.Background(), "test_key", 2, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if count != 5 || blocked {
+		t.Errorf("expected count 5 unblocked, received: %v %v", count, blocked)
+	}
+
+	if got, err := c.Count(context.Background(), "test_key"); err != nil || got != 5 {
+		t.Errorf("expected count 5, received: %v err: %v", got, err)
+	}
+
+	if ttl := s.TTL(key); ttl != time.Minute {
+		t.Errorf("expected ttl of %v, received: %v", time.Minute, ttl)
+	}
+}
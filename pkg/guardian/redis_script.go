@@ -0,0 +1,84 @@
+package guardian
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// runScript runs script, named name in logs and errors, against keys namespaced by limitStoreNamespace, reporting
+// its duration and outcome as a counter increment. Scripts keep state, such as a key's history, that a local count
+// can't stand in for, so they fail fast while the breaker is open rather than wait on a failing Redis.
+func (rs *RedisCounter) runScript(name string, script *redis.Script, keys []string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	err := error(nil)
+	defer func() {
+		rs.reporter.RedisCounterIncr(time.Now().Sub(start), err != nil)
+	}()
+
+	if !rs.breaker.Allow() {
+		err = fmt.Errorf("redis circuit breaker open")
+		return nil, err
+	}
+
+	namespaced := make([]string, len(keys))
+	for i, key := range keys {
+		namespaced[i] = NamespacedKey(limitStoreNamespace, key)
+	}
+
+	rs.logger.Debugf("Running %v script for keys %v with args %v", name, namespaced, args)
+	res, err := script.Run(rs.redis, namespaced, args...).Result()
+	if err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("error running %v script for %v", name, namespaced))
+		rs.logger.WithError(err).Errorf("error running %v script", name)
+		rs.recordFailure()
+		return nil, err
+	}
+	rs.recordSuccess()
+
+	rs.logger.Debugf("Successfully ran %v script and got response: %v", name, res)
+	return res, nil
+}
+
+// runIntScript runs a script replying with an integer
+func (rs *RedisCounter) runIntScript(name string, script *redis.Script, keys []string, args ...interface{}) (uint64, error) {
+	res, err := rs.runScript(name, script, keys, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, ok := res.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected %v script response %v", name, res)
+	}
+
+	return uint64(n), nil
+}
+
+// runPairScript runs a script replying with a pair of integers
+func (rs *RedisCounter) runPairScript(name string, script *redis.Script, keys []string, args ...interface{}) (uint64, uint64, error) {
+	res, err := rs.runScript(name, script, keys, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return 0, 0, fmt.Errorf("unexpected %v script response %v", name, res)
+	}
+
+	first, firstOk := vals[0].(int64)
+	second, secondOk := vals[1].(int64)
+	if !firstOk || !secondOk {
+		return 0, 0, fmt.Errorf("unexpected %v script response %v", name, res)
+	}
+
+	return uint64(first), uint64(second), nil
+}
+
+// expireSeconds rounds d up to whole seconds, for scripts setting expirations with EXPIRE
+func expireSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
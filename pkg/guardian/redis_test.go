@@ -1,6 +1,14 @@
 package guardian
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+)
 
 func TestNamespacedKey(t *testing.T) {
 	received := NamespacedKey("someNamespace", "someKey")
@@ -10,3 +18,62 @@ func TestNamespacedKey(t *testing.T) {
 		t.Errorf("expected: %q received: %q", expected, received)
 	}
 }
+
+func TestRedisConnOptionsPassword(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis")
+	}
+	defer s.Close()
+	s.RequireAuth("secret")
+
+	opts := &redis.Options{Addr: s.Addr()}
+	if err := (RedisConnOptions{Password: "secret"}).Apply(opts); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := redis.NewClient(opts).Ping().Err(); err != nil {
+		t.Errorf("expected authenticated ping to succeed, received: %v", err)
+	}
+}
+
+func TestRedisConnOptionsUsername(t *testing.T) {
+	opts := &redis.Options{Addr: "localhost:6379"}
+	if err := (RedisConnOptions{Username: "guardian", Password: "secret"}).Apply(opts); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if opts.Password != "" || opts.OnConnect == nil {
+		t.Errorf("expected username to authenticate on connect rather than with the password alone")
+	}
+
+	if err := (RedisConnOptions{Username: "guardian"}).Apply(&redis.Options{Addr: "localhost:6379"}); err == nil {
+		t.Errorf("expected error for username without a password")
+	}
+}
+
+func TestRedisConnOptionsTLS(t *testing.T) {
+	opts := &redis.Options{Addr: "redis.example.com:6378"}
+	if err := (RedisConnOptions{TLS: true}).Apply(opts); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if opts.TLSConfig == nil || opts.TLSConfig.ServerName != "redis.example.com" {
+		t.Errorf("expected tls config verifying the host of the address, received: %v", opts.TLSConfig)
+	}
+
+	dir, err := ioutil.TempDir("", "guardian")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := (RedisConnOptions{TLS: true, TLSCAFile: caFile}).Apply(&redis.Options{Addr: "redis.example.com:6378"}); err == nil {
+		t.Errorf("expected error for a ca file without certificates")
+	}
+}
@@ -0,0 +1,121 @@
+package guardian
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ParseRemoteAddress parses the IP of a remote address, returning nil if it isn't one. IPv6 literals may be bracketed
+// and carry a zone identifier, which is dropped, and addresses may carry a port, e.g. [fe80::1%eth0]:443.
+func ParseRemoteAddress(remoteAddress string) net.IP {
+	addr, ok := parseRemoteAddr(remoteAddress)
+	if !ok {
+		return nil
+	}
+
+	return net.IP(addr.AsSlice())
+}
+
+// CanonicalRemoteAddress returns the canonical form of the IP of remoteAddress, so that every spelling of an address
+// is counted and matched as the same client. Remote addresses that aren't IPs are returned unchanged.
+func CanonicalRemoteAddress(remoteAddress string) string {
+	addr, ok := parseRemoteAddr(remoteAddress)
+	if !ok {
+		return remoteAddress
+	}
+
+	return addr.String()
+}
+
+// parseRemoteAddr parses remoteAddress as ParseRemoteAddress does without allocating. IPv4-mapped IPv6 addresses
+// are unmapped so they match IPv4 prefixes.
+func parseRemoteAddr(remoteAddress string) (netip.Addr, bool) {
+	host := strings.TrimSpace(remoteAddress)
+	if strings.HasPrefix(host, "[") {
+		end := strings.Index(host, "]")
+		if end < 0 {
+			return netip.Addr{}, false
+		}
+
+		if rest := host[end+1:]; len(rest) > 0 && !validPort(rest) {
+			return netip.Addr{}, false
+		}
+		host = host[1:end]
+	} else if strings.Count(host, ":") == 1 {
+		// a bare IPv6 literal has more than one colon, so this can only be an IPv4 address with a port
+		i := strings.Index(host, ":")
+		if !validPort(host[i:]) {
+			return netip.Addr{}, false
+		}
+		host = host[:i]
+	}
+
+	if i := strings.Index(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
+
+func validPort(s string) bool {
+	if len(s) < 2 || s[0] != ':' {
+		return false
+	}
+
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PrefixFromIPNet converts n to a netip.Prefix, returning false if it is invalid
+func PrefixFromIPNet(n net.IPNet) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(n.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+
+	ones, bits := n.Mask.Size()
+	if bits == 0 {
+		return netip.Prefix{}, false
+	}
+
+	// IPv4 networks may be stored in 16 bytes with a 16 byte mask
+	if addr.Is4In6() && bits == 128 {
+		if ones < 96 {
+			return netip.Prefix{}, false
+		}
+		addr, ones = addr.Unmap(), ones-96
+	} else if addr.Is4In6() {
+		addr = addr.Unmap()
+	}
+
+	return netip.PrefixFrom(addr, ones).Masked(), true
+}
+
+// PrefixesFromIPNets converts ipNets to netip.Prefixes, skipping any that are invalid
+func PrefixesFromIPNets(ipNets []net.IPNet) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(ipNets))
+	for _, ipNet := range ipNets {
+		if prefix, ok := PrefixFromIPNet(ipNet); ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	return prefixes
+}
+
+// IPNetFromPrefix converts prefix to a net.IPNet
+func IPNetFromPrefix(prefix netip.Prefix) net.IPNet {
+	addr := prefix.Masked().Addr()
+	return net.IPNet{IP: net.IP(addr.AsSlice()), Mask: net.CIDRMask(prefix.Bits(), addr.BitLen())}
+}
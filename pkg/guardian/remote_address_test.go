@@ -0,0 +1,50 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCanonicalRemoteAddress(t *testing.T) {
+	tests := []struct {
+		remoteAddress string
+		want          string
+	}{
+		{remoteAddress: "192.168.1.2", want: "192.168.1.2"},
+		{remoteAddress: "192.168.1.2:8080", want: "192.168.1.2"},
+		{remoteAddress: "::ffff:192.168.1.2", want: "192.168.1.2"},
+		{remoteAddress: "2001:DB8:0:0::1", want: "2001:db8::1"},
+		{remoteAddress: "[2001:db8::1]", want: "2001:db8::1"},
+		{remoteAddress: "[2001:db8::1]:443", want: "2001:db8::1"},
+		{remoteAddress: "fe80::1%eth0", want: "fe80::1"},
+		{remoteAddress: "[fe80::1%25eth0]:443", want: "fe80::1"},
+		{remoteAddress: "[2001:db8::1]:http", want: "[2001:db8::1]:http"},
+		{remoteAddress: "[2001:db8::1", want: "[2001:db8::1"},
+		{remoteAddress: "not-an-ip", want: "not-an-ip"},
+		{remoteAddress: "", want: ""},
+	}
+
+	for _, test := range tests {
+		if got := CanonicalRemoteAddress(test.remoteAddress); got != test.want {
+			t.Errorf("%v expected: %v received: %v", test.remoteAddress, test.want, got)
+		}
+	}
+}
+
+func TestIPRateLimiterCountsIPv6SpellingsTogether(t *testing.T) {
+	limit := Limit{Count: 2, Duration: time.Minute, Enabled: true}
+	rl := NewIPRateLimiter(&FakeLimitStore{limit: limit, count: make(map[string]uint64)}, &FakeLimitStore{count: make(map[string]uint64)}, LocalClock{}, nil, TestingLogger, NullReporter{})
+
+	for i, remoteAddress := range []string{"2001:db8::1", "[2001:DB8::1]:443", "2001:db8:0:0:0:0:0:1%eth0"} {
+		req := RequestFromRateLimitRequest(RateLimitRequestFromRequest("test", Request{RemoteAddress: remoteAddress}))
+		blocked, _, err := rl.Limit(context.Background(), req)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if blocked != (i == 2) {
+			t.Fatalf("%v expected blocked: %v received: %v", remoteAddress, i == 2, blocked)
+		}
+	}
+}
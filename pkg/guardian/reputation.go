@@ -0,0 +1,246 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const maxConcurrentReputationLookups = 100
+
+const throttleNamespace = "throttle"
+
+// ReputationProvider scores the reputation of an IP address from 0 (trusted) to 100 (abusive)
+type ReputationProvider interface {
+	Score(context context.Context, ip string) (int, error)
+}
+
+// NewHTTPReputationProvider creates a new HTTPReputationProvider
+func NewHTTPReputationProvider(endpoint string, client *http.Client) *HTTPReputationProvider {
+	return &HTTPReputationProvider{endpoint: endpoint, client: client}
+}
+
+// HTTPReputationProvider is a ReputationProvider that looks up scores with GET <endpoint>?ip=<ip>, expecting a JSON
+// response such as {"score": 42}
+type HTTPReputationProvider struct {
+	endpoint string
+	client   *http.Client
+}
+
+type reputationResponse struct {
+	Score int `json:"score"`
+}
+
+func (h *HTTPReputationProvider) Score(context context.Context, ip string) (int, error) {
+	target := h.endpoint + "?ip=" + url.QueryEscape(ip)
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "error creating reputation request")
+	}
+
+	res, err := h.client.Do(req.WithContext(context))
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("error requesting reputation for %v", ip))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d requesting reputation for %v", res.StatusCode, ip)
+	}
+
+	body := reputationResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("error decoding reputation for %v", ip))
+	}
+
+	return body.Score, nil
+}
+
+// NewReputationCache creates a new ReputationCache
+func NewReputationCache(provider ReputationProvider, ttl time.Duration, timeout time.Duration, logger logrus.FieldLogger, reporter MetricReporter) *ReputationCache {
+	return &ReputationCache{
+		provider: provider,
+		ttl:      ttl,
+		timeout:  timeout,
+		logger:   logger,
+		reporter: reporter,
+		entries:  make(map[string]reputationEntry),
+		inflight: make(map[string]bool),
+		sem:      make(chan struct{}, maxConcurrentReputationLookups),
+	}
+}
+
+type reputationEntry struct {
+	score    int
+	expireAt time.Time
+}
+
+// ReputationCache caches scores from a ReputationProvider. Lookups happen asynchronously, off of the request path,
+// so an IP has no score until its first lookup completes.
+type ReputationCache struct {
+	provider ReputationProvider
+	ttl      time.Duration
+	timeout  time.Duration
+	logger   logrus.FieldLogger
+	reporter MetricReporter
+
+	mu       sync.Mutex
+	entries  map[string]reputationEntry
+	inflight map[string]bool
+	sem      chan struct{}
+}
+
+// Score returns the cached score for ip and whether one was found, starting a lookup if the score is missing or expired
+func (rc *ReputationCache) Score(ip string) (int, bool) {
+	now := time.Now()
+
+	rc.mu.Lock()
+	entry, found := rc.entries[ip]
+	fresh := found && entry.expireAt.After(now)
+	lookup := !fresh && !rc.inflight[ip]
+	if lookup {
+		rc.inflight[ip] = true
+	}
+	rc.mu.Unlock()
+
+	if lookup {
+		select {
+		case rc.sem <- struct{}{}:
+			go rc.lookup(ip)
+		default:
+			rc.logger.Warnf("too many reputation lookups in flight, skipping lookup of %v", ip)
+			rc.mu.Lock()
+			delete(rc.inflight, ip)
+			rc.mu.Unlock()
+		}
+	}
+
+	// a stale score is still more useful than none while it is refreshed
+	return entry.score, found
+}
+
+func (rc *ReputationCache) lookup(ip string) {
+	start := time.Now()
+	defer func() { <-rc.sem }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), rc.timeout)
+	score, err := rc.provider.Score(ctx, ip)
+	cancel()
+	rc.reporter.ReputationLookup(time.Now().Sub(start), err != nil)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.inflight, ip)
+
+	if err != nil {
+		rc.logger.WithError(err).Warnf("error looking up reputation of %v", ip)
+		return
+	}
+
+	rc.logger.Debugf("looked up reputation of %v: %d", ip, score)
+	rc.entries[ip] = reputationEntry{score: score, expireAt: time.Now().Add(rc.ttl)}
+}
+
+// Run prunes expired scores every pruneInterval
+func (rc *ReputationCache) Run(pruneInterval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(pruneInterval)
+	for {
+		select {
+		case <-ticker.C:
+			rc.prune(time.Now())
+		case <-stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+func (rc *ReputationCache) prune(olderThan time.Time) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for ip, entry := range rc.entries {
+		if entry.expireAt.Before(olderThan) {
+			delete(rc.entries, ip)
+		}
+	}
+}
+
+// ReputationThresholds configures how reputation scores affect requests
+type ReputationThresholds struct {
+	// BlockScore is the score at or above which requests are blocked. Zero disables blocking.
+	BlockScore int
+	// ThrottleScore is the score at or above which requests are rate limited by the throttled limiter instead.
+	// Zero disables throttling.
+	ThrottleScore int
+}
+
+// ScoreSource provides cached reputation scores
+type ScoreSource interface {
+	Score(ip string) (int, bool)
+}
+
+// CondReputationFunc blocks requests from IPs with a score at or above the block threshold and rate limits requests
+// from IPs at or above the throttle threshold with throttled, stopping the chain in both cases. Requests from IPs
+// without a score, or below both thresholds, continue down the chain.
+func CondReputationFunc(scores ScoreSource, thresholds ReputationThresholds, throttled RequestBlockerFunc, logger logrus.FieldLogger) CondRequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, bool, uint32, error) {
+		score, found := scores.Score(r.RemoteAddress)
+		if !found {
+			tracef(c, "no reputation score")
+			return false, false, RequestsRemainingMax, nil
+		}
+
+		tracef(c, "reputation score %d", score)
+
+		if thresholds.BlockScore > 0 && score >= thresholds.BlockScore {
+			logger.Debugf("blocking request %v with reputation score %d", r, score)
+			hintBlockReason(c, ReputationBlockReason, "")
+			return true, true, 0, nil
+		}
+
+		if thresholds.ThrottleScore > 0 && score >= thresholds.ThrottleScore {
+			logger.Debugf("throttling request %v with reputation score %d", r, score)
+			return throttle(c, r, throttled, ReputationBlockReason)
+		}
+
+		return false, false, RequestsRemainingMax, nil
+	}
+}
+
+// throttle limits r with throttled, stopping the chain if it's blocked or errors. Requests are counted under a key
+// namespaced by kind, so a request allowed through to the rate limiters later in the chain isn't counted twice
+// against their keys. Blocked requests are given kind as their reason, rather than that of the limiter they were
+// throttled through.
+func throttle(c context.Context, r Request, throttled RequestBlockerFunc, kind BlockReasonKind) (bool, bool, uint32, error) {
+	tr := r
+	tr.RemoteAddress = NamespacedKey(NamespacedKey(throttleNamespace, string(kind)), r.RemoteAddress)
+	stop, blocked, remaining, err := CondStopOnBlockOrError(throttled)(c, tr)
+	if err != nil {
+		hintErrorSwallowed(c)
+	}
+	if blocked {
+		hintBlockReason(c, kind, throttledBlockReason)
+	}
+
+	return stop, blocked, remaining, err
+}
+
+// ScaledLimitProvider is a LimitProvider that scales the count of another provider's limit by Factor
+type ScaledLimitProvider struct {
+	Provider LimitProvider
+	Factor   float64
+}
+
+func (s ScaledLimitProvider) GetLimit() Limit {
+	limit := s.Provider.GetLimit()
+	limit.Count = uint64(float64(limit.Count) * s.Factor)
+	return limit
+}
@@ -0,0 +1,149 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type FakeReputationProvider struct {
+	scores map[string]int
+}
+
+func (f *FakeReputationProvider) Score(context context.Context, ip string) (int, error) {
+	score, ok := f.scores[ip]
+	if !ok {
+		return 0, fmt.Errorf("unknown ip %v", ip)
+	}
+	return score, nil
+}
+
+type StaticScoreSource map[string]int
+
+func (s StaticScoreSource) Score(ip string) (int, bool) {
+	score, ok := s[ip]
+	return score, ok
+}
+
+func TestHTTPReputationProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("ip") != "10.0.0.1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"score": 87}`)
+	}))
+	defer srv.Close()
+
+	provider := NewHTTPReputationProvider(srv.URL, srv.Client())
+	score, err := provider.Score(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if score != 87 {
+		t.Fatalf("expected: %v received: %v", 87, score)
+	}
+
+	if _, err := provider.Score(context.Background(), "10.0.0.2"); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+}
+
+func TestReputationCacheLooksUpAsynchronously(t *testing.T) {
+	provider := &FakeReputationProvider{scores: map[string]int{"10.0.0.1": 90}}
+	cache := NewReputationCache(provider, time.Minute, time.Second, TestingLogger, NullReporter{})
+
+	if _, found := cache.Score("10.0.0.1"); found {
+		t.Fatal("expected no score before the first lookup completes")
+	}
+
+	time.Sleep(100 * time.Millisecond) // wait for async lookup
+
+	score, found := cache.Score("10.0.0.1")
+	if !found || score != 90 {
+		t.Fatalf("expected: (%v, %v) received: (%v, %v)", 90, true, score, found)
+	}
+
+	cache.prune(time.Now().Add(2 * time.Minute))
+	if _, found := cache.Score("10.0.0.1"); found {
+		t.Fatal("expected score to be pruned")
+	}
+}
+
+func TestCondReputationFunc(t *testing.T) {
+	scores := StaticScoreSource{"10.0.0.1": 90, "10.0.0.2": 60, "10.0.0.3": 10}
+	thresholds := ReputationThresholds{BlockScore: 80, ThrottleScore: 50}
+	throttled := func(context.Context, Request) (bool, uint32, error) {
+		return true, 0, nil
+	}
+
+	tests := []struct {
+		name        string
+		ip          string
+		wantStop    bool
+		wantBlocked bool
+	}{
+		{name: "Block", ip: "10.0.0.1", wantStop: true, wantBlocked: true},
+		{name: "Throttle", ip: "10.0.0.2", wantStop: true, wantBlocked: true},
+		{name: "Trusted", ip: "10.0.0.3", wantStop: false, wantBlocked: false},
+		{name: "Unknown", ip: "10.0.0.4", wantStop: false, wantBlocked: false},
+	}
+
+	f := CondReputationFunc(scores, thresholds, throttled, TestingLogger)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stop, blocked, _, err := f(context.Background(), Request{RemoteAddress: test.ip})
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if stop != test.wantStop || blocked != test.wantBlocked {
+				t.Fatalf("expected: (%v, %v) received: (%v, %v)", test.wantStop, test.wantBlocked, stop, blocked)
+			}
+		})
+	}
+}
+
+func TestScaledLimitProvider(t *testing.T) {
+	fstore := &FakeLimitStore{limit: Limit{Count: 10, Duration: time.Second, Enabled: true}}
+	got := ScaledLimitProvider{Provider: fstore, Factor: 0.5}.GetLimit()
+
+	want := Limit{Count: 5, Duration: time.Second, Enabled: true}
+	if got != want {
+		t.Fatalf("expected: %v received: %v", want, got)
+	}
+}
+
+func TestThrottledRequestsCountedOnce(t *testing.T) {
+	fstore := &FakeLimitStore{limit: Limit{Count: 10, Duration: time.Minute, Enabled: true}, count: make(map[string]uint64)}
+	clock := &fixedClock{now: time.Unix(1522969710, 0)}
+	throttled := NewIPRateLimiter(ScaledLimitProvider{Provider: fstore, Factor: 0.5}, fstore, clock, nil, TestingLogger, NullReporter{})
+	rateLimiter := NewIPRateLimiter(fstore, fstore, clock, nil, TestingLogger, NullReporter{})
+	thresholds := ReputationThresholds{ThrottleScore: 50}
+
+	// the order of main: reputation, then the global rate limiter, sharing a counter
+	chain := CondChain(
+		CondReputationFunc(StaticScoreSource{"10.0.0.2": 60}, thresholds, throttled.Limit, TestingLogger),
+		CondStopOnBlockOrError(rateLimiter.Limit),
+	)
+
+	for i := 1; i <= 6; i++ {
+		blocked, _, err := chain(context.Background(), Request{RemoteAddress: "10.0.0.2"})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if want := i > 5; blocked != want {
+			t.Fatalf("request %d: expected blocked %v, received %v", i, want, blocked)
+		}
+	}
+
+	key, _ := rateLimiter.windowKey(Request{RemoteAddress: "10.0.0.2"}, "", fstore.limit, clock.now)
+	if got := fstore.count[key]; got != 5 {
+		t.Fatalf("expected the rate limiter to count 5 requests, received: %d", got)
+	}
+}
@@ -1,8 +1,15 @@
 package guardian
 
 import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 
+	envoy_api_v2_ratelimit "github.com/envoyproxy/go-control-plane/envoy/api/v2/ratelimit"
 	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
 )
 
@@ -11,10 +18,21 @@ const (
 	authorityDescriptor     = "authority"
 	methodDescriptor        = "method"
 	pathDescriptor          = "path"
+	sourcePortDescriptor    = "source_port"
+	sniDescriptor           = "sni"
+	alpnDescriptor          = "alpn"
 )
 
 const headerDescriptorPrefix = "header."
 
+// metadataDescriptorPrefix prefixes the keys of descriptors carrying Envoy dynamic metadata or filter state, such as
+// a user ID set by an earlier authentication filter
+const metadataDescriptorPrefix = "metadata."
+
+// RequestIDHeader is the header carrying the id that correlates a request across Envoy, Guardian, and the upstream.
+// Envoy sets it on every request, and must send it as the "header.x-request-id" descriptor.
+const RequestIDHeader = "x-request-id"
+
 // Request is an http request
 type Request struct {
 	RemoteAddress string
@@ -22,30 +40,221 @@ type Request struct {
 	Method        string
 	Path          string
 	Headers       map[string]string
+	// SourcePort, SNI, and ALPN describe the downstream connection: the client's port, the server name it requested
+	// in its TLS handshake, and the protocol negotiated with ALPN. They are empty if Envoy doesn't send them, or the
+	// connection isn't TLS.
+	SourcePort string
+	SNI        string
+	ALPN       string
+	// Metadata holds Envoy dynamic metadata and filter state values, by descriptor key without the metadata prefix.
+	// It is nil if the request has none.
+	Metadata map[string]string
+	// Tier is the plan tier of the request's API key, set by WithPlanTier. It is empty if unknown.
+	Tier string
+	// Operation is the id of the OpenAPI operation the request matches, set by WithOpenAPIOperation. It is empty if
+	// unknown.
+	Operation string
+	// Internal is whether the remote address is in the internal networks, set by WithInternalNetworks.
+	Internal bool
+	// QueryParams are the query parameters of Path, parsed once by RequestFromRateLimitRequest and the batch
+	// decisions API so the rules matching on them don't each parse the query. It is nil if Path has no query or it
+	// wasn't parsed, see Query.
+	QueryParams url.Values
+
+	// ID correlates the request's log lines and decision stream events with Envoy's and the upstream's. It is taken
+	// from the RequestIDHeader, or generated if the request has none.
+	ID string
+
+	// HitsAddend is the number of hits the request counts for. Zero is treated as one.
+	HitsAddend uint32
+}
+
+// requestID returns the id in the RequestIDHeader of r, or a new random id if it has none
+func requestID(r Request) string {
+	if id := r.Headers[RequestIDHeader]; len(id) > 0 {
+		return id
+	}
+
+	return strconv.FormatUint(rand.Uint64(), 16)
+}
+
+// Hits returns the number of hits the request should be counted as
+func (r Request) Hits() uint {
+	if r.HitsAddend == 0 {
+		return 1
+	}
+
+	return uint(r.HitsAddend)
+}
+
+// Attribute returns the value of a request attribute named as in ValidateRequestAttribute, empty if it is missing
+func (r Request) Attribute(name string) string {
+	switch {
+	case name == remoteAddressDescriptor:
+		return r.RemoteAddress
+	case name == authorityDescriptor:
+		return r.Authority
+	case name == methodDescriptor:
+		return r.Method
+	case name == pathDescriptor:
+		return r.Path
+	case name == sourcePortDescriptor:
+		return r.SourcePort
+	case name == sniDescriptor:
+		return r.SNI
+	case name == alpnDescriptor:
+		return r.ALPN
+	case strings.HasPrefix(name, headerDescriptorPrefix):
+		return r.Headers[strings.TrimPrefix(name, headerDescriptorPrefix)]
+	case strings.HasPrefix(name, metadataDescriptorPrefix):
+		return r.Metadata[strings.TrimPrefix(name, metadataDescriptorPrefix)]
+	}
+
+	return ""
+}
+
+// Host returns the request's authority without its port, lowercased
+func (r Request) Host() string {
+	host := r.Authority
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.ToLower(host)
+}
+
+// Query returns the query parameters of the request's path, those in QueryParams if it is set. Malformed parameters,
+// such as those with invalid percent encoding, are skipped rather than failing the whole query, so a malformed query
+// can't hide its other parameters.
+func (r Request) Query() url.Values {
+	if r.QueryParams != nil {
+		return r.QueryParams
+	}
+
+	if query := parseQuery(r.Path); query != nil {
+		return query
+	}
+	return url.Values{}
+}
+
+// parseQuery returns the query parameters of path, nil if it has no query
+func parseQuery(path string) url.Values {
+	i := strings.Index(path, "?")
+	if i < 0 {
+		return nil
+	}
+
+	query, _ := url.ParseQuery(path[i+1:]) // well formed parameters are returned along with the first error
+	return query
+}
+
+// ValidateRequestAttribute returns an error unless name is remote_address, authority, method, path, source_port, sni,
+// alpn, header.<name>, or metadata.<name>
+func ValidateRequestAttribute(name string) error {
+	switch name {
+	case remoteAddressDescriptor, authorityDescriptor, methodDescriptor, pathDescriptor, sourcePortDescriptor, sniDescriptor, alpnDescriptor:
+		return nil
+	}
+
+	for _, prefix := range []string{headerDescriptorPrefix, metadataDescriptorPrefix} {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown request attribute %q", name)
 }
 
 // RequestFromRateLimitRequest returns a Request from a RateLimitRequest
 func RequestFromRateLimitRequest(rlreq *ratelimit.RateLimitRequest) Request {
-	req := Request{Headers: make(map[string]string)}
+	req := Request{Headers: make(map[string]string), HitsAddend: rlreq.GetHitsAddend()}
 	for _, descriptor := range rlreq.GetDescriptors() {
 		for _, e := range descriptor.GetEntries() {
 			switch e.GetKey() {
 			case remoteAddressDescriptor:
-				req.RemoteAddress = e.GetValue()
+				req.RemoteAddress = CanonicalRemoteAddress(e.GetValue())
 			case authorityDescriptor:
 				req.Authority = e.GetValue()
 			case methodDescriptor:
 				req.Method = e.GetValue()
 			case pathDescriptor:
 				req.Path = e.GetValue()
+			case sourcePortDescriptor:
+				req.SourcePort = e.GetValue()
+			case sniDescriptor:
+				req.SNI = e.GetValue()
+			case alpnDescriptor:
+				req.ALPN = e.GetValue()
 			default:
 				if strings.HasPrefix(e.GetKey(), headerDescriptorPrefix) {
 					header := strings.TrimPrefix(e.GetKey(), headerDescriptorPrefix)
 					req.Headers[header] = e.GetValue()
+				} else if strings.HasPrefix(e.GetKey(), metadataDescriptorPrefix) {
+					if req.Metadata == nil {
+						req.Metadata = make(map[string]string)
+					}
+					key := strings.TrimPrefix(e.GetKey(), metadataDescriptorPrefix)
+					req.Metadata[key] = e.GetValue()
 				}
 			}
 		}
 	}
+	req.QueryParams = parseQuery(req.Path)
 
 	return req
 }
+
+// RateLimitRequestFromRequest returns a RateLimitRequest for domain describing req the same way Guardian's Envoy
+// configuration does, with one descriptor per entry
+func RateLimitRequestFromRequest(domain string, req Request) *ratelimit.RateLimitRequest {
+	rlreq := &ratelimit.RateLimitRequest{Domain: domain, HitsAddend: uint32(req.Hits())}
+
+	add := func(key, value string) {
+		if len(value) == 0 {
+			return
+		}
+
+		entry := &envoy_api_v2_ratelimit.RateLimitDescriptor_Entry{Key: key, Value: value}
+		descriptor := &envoy_api_v2_ratelimit.RateLimitDescriptor{Entries: []*envoy_api_v2_ratelimit.RateLimitDescriptor_Entry{entry}}
+		rlreq.Descriptors = append(rlreq.Descriptors, descriptor)
+	}
+
+	add(remoteAddressDescriptor, req.RemoteAddress)
+	add(authorityDescriptor, req.Authority)
+	add(methodDescriptor, req.Method)
+	add(pathDescriptor, req.Path)
+	add(sourcePortDescriptor, req.SourcePort)
+	add(sniDescriptor, req.SNI)
+	add(alpnDescriptor, req.ALPN)
+
+	// the id is propagated as the RequestIDHeader, unless the request already carries one
+	headerValues := req.Headers
+	if len(req.ID) > 0 && len(req.Headers[RequestIDHeader]) == 0 {
+		headerValues = map[string]string{RequestIDHeader: req.ID}
+		for header, value := range req.Headers {
+			headerValues[header] = value
+		}
+	}
+
+	headers := make([]string, 0, len(headerValues))
+	for header := range headerValues {
+		headers = append(headers, header)
+	}
+	sort.Strings(headers)
+
+	for _, header := range headers {
+		add(headerDescriptorPrefix+header, headerValues[header])
+	}
+
+	metadataKeys := make([]string, 0, len(req.Metadata))
+	for key := range req.Metadata {
+		metadataKeys = append(metadataKeys, key)
+	}
+	sort.Strings(metadataKeys)
+
+	for _, key := range metadataKeys {
+		add(metadataDescriptorPrefix+key, req.Metadata[key])
+	}
+
+	return rlreq
+}
@@ -27,6 +27,7 @@ func TestRequestConversion(t *testing.T) {
 			Method:        "GET",
 			Path:          "/somePath",
 			Headers:       make(map[string]string),
+			HitsAddend:    1,
 		},
 	}, {
 		name: "WithHeaders",
@@ -43,6 +44,7 @@ func TestRequestConversion(t *testing.T) {
 			Method:        "GET",
 			Path:          "/somePath",
 			Headers:       map[string]string{"x-forwarded-for": "192.168.1.223, 10.10.0.23"},
+			HitsAddend:    1,
 		},
 	},
 	}
@@ -57,6 +59,98 @@ func TestRequestConversion(t *testing.T) {
 	}
 }
 
+func TestRequestHits(t *testing.T) {
+	rlreq := rateLimitRequestWithKeyValues([]kv{kv{k: remoteAddressDescriptor, v: "10.0.0.123"}})
+	rlreq.HitsAddend = 5
+	if got := RequestFromRateLimitRequest(rlreq).Hits(); got != 5 {
+		t.Errorf("expected: %v received: %v", 5, got)
+	}
+
+	rlreq.HitsAddend = 0
+	if got := RequestFromRateLimitRequest(rlreq).Hits(); got != 1 {
+		t.Errorf("expected: %v received: %v", 1, got)
+	}
+}
+
+func TestRequestQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want map[string][]string
+	}{
+		{name: "NoQuery", path: "/search", want: map[string][]string{}},
+		{name: "Query", path: "/search?q=a%20b&export=true&export=1&debug", want: map[string][]string{"q": {"a b"}, "export": {"true", "1"}, "debug": {""}}},
+		{name: "MalformedSkipped", path: "/search?q=%zz&export=true&;", want: map[string][]string{"export": {"true"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := map[string][]string(Request{Path: test.path}.Query())
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected query (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRequestFromRateLimitRequestParsesQuery(t *testing.T) {
+	rlreq := RateLimitRequestFromRequest("edge_proxy_per_ip", Request{RemoteAddress: "192.168.1.2", Path: "/search?tag=a&tag=b"})
+
+	req := RequestFromRateLimitRequest(rlreq)
+	if diff := cmp.Diff(map[string][]string{"tag": {"a", "b"}}, map[string][]string(req.QueryParams)); diff != "" {
+		t.Errorf("unexpected query params (-want +got):\n%s", diff)
+	}
+
+	// the parsed parameters are used rather than parsing the path again
+	req.Path = "/search"
+	if got := req.Query().Get("tag"); got != "a" {
+		t.Errorf("expected: %v received: %v", "a", got)
+	}
+}
+
+func TestRateLimitRequestRoundTrip(t *testing.T) {
+	want := Request{
+		RemoteAddress: "10.0.0.123",
+		Authority:     "www.shave.io",
+		Method:        "GET",
+		Path:          "/somePath",
+		SourcePort:    "51234",
+		SNI:           "www.shave.io",
+		ALPN:          "h2",
+		Headers:       map[string]string{"x-forwarded-for": "192.168.1.223", "user-agent": "curl"},
+		Metadata:      map[string]string{"user_id": "1234"},
+		HitsAddend:    3,
+	}
+
+	rlreq := RateLimitRequestFromRequest("some.domain", want)
+	if rlreq.GetDomain() != "some.domain" {
+		t.Errorf("expected: %v received: %v", "some.domain", rlreq.GetDomain())
+	}
+
+	got := RequestFromRateLimitRequest(rlreq)
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("got want differs: (-got +want)\n%s", diff)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	r := Request{Headers: map[string]string{RequestIDHeader: "abc-123"}}
+	if got := requestID(r); got != "abc-123" {
+		t.Fatalf("expected: %v received: %v", "abc-123", got)
+	}
+
+	generated := requestID(Request{})
+	if len(generated) == 0 || generated == requestID(Request{}) {
+		t.Fatalf("expected a new id for each request without one, received: %v", generated)
+	}
+
+	// the id of a request without the header is propagated as the header
+	rlreq := RateLimitRequestFromRequest("some.domain", Request{Path: "/", ID: generated})
+	if got := RequestFromRateLimitRequest(rlreq).Headers[RequestIDHeader]; got != generated {
+		t.Fatalf("expected: %v received: %v", generated, got)
+	}
+}
+
 type kv struct {
 	k string
 	v string
@@ -0,0 +1,88 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const rolloverNamespace = "rollover"
+
+// RolloverCounter is a Counter that is also capable of carrying the unused count of a fixed window into the next
+type RolloverCounter interface {
+	Counter
+
+	// IncrRollover adds amount to the count of the window key, returning its count and its credit. The credit is set
+	// when the window is first counted to the count left unused by the previous window prevKey, up to maxCredit. The
+	// window is kept for expireIn so it can be read as the previous window of the next.
+	IncrRollover(context context.Context, key string, prevKey string, amount uint, count uint64, maxCredit uint64, expireIn time.Duration) (uint64, uint64, error)
+}
+
+// ValidateRollover returns an error if the limit carries over unused count with an algorithm that doesn't count in
+// fixed windows
+func ValidateRollover(limit Limit) error {
+	if limit.Rollover == 0 {
+		return nil
+	}
+
+	if limit.Algorithm == LeakyBucketAlgorithm || limit.Algorithm == DayBucketsAlgorithm {
+		return fmt.Errorf("rollover is not supported by the %v algorithm", limit.Algorithm)
+	}
+
+	return nil
+}
+
+// rolloverScript atomically adds to the count of a window hash, setting its credit from the count of the previous
+// window hash when the window is created
+// KEYS[1] window key, KEYS[2] previous window key
+// ARGV[1] amount to add, ARGV[2] limit count, ARGV[3] max credit, ARGV[4] expiration in seconds
+var rolloverScript = redis.NewScript(`
+local credit = redis.call("HGET", KEYS[1], "credit")
+if not credit then
+	local prev = tonumber(redis.call("HGET", KEYS[2], "count") or "0")
+	credit = math.min(math.max(tonumber(ARGV[2]) - prev, 0), tonumber(ARGV[3]))
+	redis.call("HSET", KEYS[1], "credit", credit)
+	redis.call("EXPIRE", KEYS[1], ARGV[4])
+end
+
+local count = redis.call("HINCRBY", KEYS[1], "count", ARGV[1])
+return {count, tonumber(credit)}
+`)
+
+func (rs *RedisCounter) IncrRollover(context context.Context, key string, prevKey string, amount uint, count uint64, maxCredit uint64, expireIn time.Duration) (uint64, uint64, error) {
+	return rs.runPairScript("rollover", rolloverScript, []string{key, prevKey}, amount, count, maxCredit, expireSeconds(expireIn))
+}
+
+// incrRollover counts incrBy against the window of key containing now, returning the count less the credit carried
+// over from the previous window and whether it exceeds the limit
+func incrRollover(context context.Context, counter Counter, key string, limit Limit, incrBy uint, now time.Time) (uint64, bool, error) {
+	rollover, ok := counter.(RolloverCounter)
+	if !ok {
+		return 0, false, fmt.Errorf("counter does not support rollover")
+	}
+
+	start, end := limit.Window(now)
+	prevStart, _ := limit.Window(start.Add(-time.Nanosecond))
+
+	// the window is read as the previous window until the end of the next
+	expireIn := windowExpiration(now, end) + end.Sub(start)
+	key = NamespacedKey(rolloverNamespace, key)
+	windowKey := key + ":" + strconv.FormatInt(start.Unix(), 10)
+	prevKey := key + ":" + strconv.FormatInt(prevStart.Unix(), 10)
+
+	count, credit, err := rollover.IncrRollover(context, windowKey, prevKey, incrBy, limit.Count, limit.Rollover, expireIn)
+	if err != nil {
+		return 0, false, err
+	}
+
+	tracef(context, "window %v counted %d with %d credit carried over", windowKey, count, credit)
+	if count <= credit {
+		return 0, false, nil
+	}
+
+	count -= credit
+	return count, count > limit.Count, nil
+}
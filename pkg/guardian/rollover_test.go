@@ -0,0 +1,106 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRedisCounterIncrRolloverCarriesUnusedCount(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	tests := []struct {
+		name       string
+		key        string
+		prevKey    string
+		amount     uint
+		wantCount  uint64
+		wantCredit uint64
+	}{
+		{name: "NoPreviousWindow", key: "w1", prevKey: "w0", amount: 3, wantCount: 3, wantCredit: 5},
+		{name: "SameWindowKeepsCredit", key: "w1", prevKey: "w0", amount: 2, wantCount: 5, wantCredit: 5},
+		{name: "PartlyUsedPreviousWindow", key: "w2", prevKey: "w1", amount: 1, wantCount: 1, wantCredit: 5},
+		{name: "CreditCapped", key: "w3", prevKey: "w2", amount: 0, wantCount: 0, wantCredit: 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count, credit, err := c.IncrRollover(context.Background(), test.key, test.prevKey, test.amount, 10, 5, time.Minute)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if count != test.wantCount || credit != test.wantCredit {
+				t.Errorf("expected count %v credit %v received count %v credit %v", test.wantCount, test.wantCredit, count, credit)
+			}
+		})
+	}
+
+	// w1 counted 5 of 10, leaving 5 unused, which is within the cap of 8
+	if _, credit, _ := c.IncrRollover(context.Background(), "x2", "w1", 1, 10, 8, time.Minute); credit != 5 {
+		t.Errorf("expected credit 5, received: %v", credit)
+	}
+}
+
+func TestLimitRollover(t *testing.T) {
+	c, s := newTestRedisCounter(t)
+	defer s.Close()
+
+	limit := Limit{Count: 2, Duration: time.Minute, Enabled: true, Rollover: 1}
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	rl := NewIPRateLimiter(&FakeLimitStore{limit: limit}, c, clock, nil, TestingLogger, NullReporter{})
+	req := Request{RemoteAddress: "192.168.1.2"}
+
+	// the first window's quiet previous window leaves it a credit of 1
+	for i, want := range []bool{false, false, false, true} {
+		blocked, _, err := rl.Limit(context.Background(), req)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if blocked != want {
+			t.Fatalf("request %d expected blocked: %v received: %v", i, want, blocked)
+		}
+	}
+
+	// the first window used all of its count, so the next has no credit
+	clock.now = clock.now.Add(time.Minute)
+	for i, want := range []bool{false, false, true} {
+		if blocked, _, _ := rl.Limit(context.Background(), req); blocked != want {
+			t.Fatalf("request %d of second window expected blocked: %v received: %v", i, want, blocked)
+		}
+	}
+
+	quota, err := rl.Quota(context.Background(), req)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if quota.Count != 3 || quota.Remaining != 0 {
+		t.Errorf("expected count 3 with none remaining, received: %+v", quota)
+	}
+}
+
+func TestValidateRollover(t *testing.T) {
+	valid := []Limit{
+		{Duration: time.Minute, Rollover: 10},
+		{Duration: time.Minute, Algorithm: FixedWindowAlgorithm, Calendar: DayWindow, Rollover: 10},
+		{Duration: day, Algorithm: DayBucketsAlgorithm},
+	}
+	for _, l := range valid {
+		if err := ValidateRollover(l); err != nil {
+			t.Errorf("expected %v to be valid, received: %v", l, err)
+		}
+	}
+
+	invalid := []Limit{
+		{Duration: time.Minute, Algorithm: LeakyBucketAlgorithm, Rollover: 10},
+		{Duration: day, Algorithm: DayBucketsAlgorithm, Rollover: 10},
+	}
+	for _, l := range invalid {
+		if err := ValidateRollover(l); err == nil {
+			t.Errorf("expected %v to be invalid", l)
+		}
+	}
+}
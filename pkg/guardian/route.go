@@ -0,0 +1,487 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const routeLimitNamespace = "route"
+
+// routeMatchCacheSize is the number of recently requested paths whose matched route limit is cached
+const routeMatchCacheSize = 4096
+
+// RoutePattern matches request paths against a template such as /users/{id}/orders. A segment of the form {name}
+// matches any single path segment and {name:regexp} matches a segment fully matching regexp, so requests for
+// different resource IDs collapse into the same route. A template prefixed with a host, such as
+// api.example.com/search, is scoped to that authority and only matches its requests.
+type RoutePattern struct {
+	raw       string
+	authority string
+	segments  []routeSegment
+}
+
+type routeSegment struct {
+	literal string
+	param   bool
+	pattern *regexp.Regexp
+}
+
+// ParseRoutePattern parses a RoutePattern from a template
+func ParseRoutePattern(template string) (RoutePattern, error) {
+	authority, path := "", template
+	if i := strings.Index(template, "/"); i > 0 {
+		authority, path = template[:i], template[i:]
+		if err := validateRouteAuthority(authority); err != nil {
+			return RoutePattern{}, errors.Wrap(err, fmt.Sprintf("route %q has invalid authority", template))
+		}
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		return RoutePattern{}, fmt.Errorf("route %q must begin with / or an authority", template)
+	}
+
+	segments := []routeSegment{}
+	for _, s := range splitPath(path) {
+		if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+			if strings.ContainsAny(s, "{}") {
+				return RoutePattern{}, fmt.Errorf("route %q has malformed segment %q", template, s)
+			}
+			segments = append(segments, routeSegment{literal: s})
+			continue
+		}
+
+		param := strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+		segment := routeSegment{param: true}
+		if i := strings.Index(param, ":"); i >= 0 {
+			pattern, err := regexp.Compile("^(?:" + param[i+1:] + ")$")
+			if err != nil {
+				return RoutePattern{}, errors.Wrap(err, fmt.Sprintf("route %q has invalid pattern for segment %q", template, s))
+			}
+			segment.pattern = pattern
+			param = param[:i]
+		}
+
+		if len(param) == 0 {
+			return RoutePattern{}, fmt.Errorf("route %q has unnamed segment %q", template, s)
+		}
+		segments = append(segments, segment)
+	}
+
+	return RoutePattern{raw: template, authority: authority, segments: segments}, nil
+}
+
+// validateRouteAuthority returns an error unless authority is a lowercase host without a port, as returned by
+// Request.Host
+func validateRouteAuthority(authority string) error {
+	if strings.ContainsAny(authority, "{}:?#@ ") {
+		return fmt.Errorf("authority %q must be a host without a port", authority)
+	}
+
+	if authority != strings.ToLower(authority) {
+		return fmt.Errorf("authority %q must be lowercase", authority)
+	}
+
+	return nil
+}
+
+func (r RoutePattern) String() string {
+	return r.raw
+}
+
+// Authority returns the authority the route is scoped to, or empty if it matches requests to any authority
+func (r RoutePattern) Authority() string {
+	return r.authority
+}
+
+// Match returns whether path, ignoring any query string, matches the route. The route's authority isn't checked,
+// see MatchRequest.
+func (r RoutePattern) Match(path string) bool {
+	return r.matchSegments(splitPath(normalizeRoutePath(path)))
+}
+
+// MatchRequest returns whether the request's path matches the route, and its host the route's authority if it has
+// one
+func (r RoutePattern) MatchRequest(request Request) bool {
+	if len(r.authority) > 0 && r.authority != request.Host() {
+		return false
+	}
+
+	return r.Match(request.Path)
+}
+
+// matchSegments returns whether the segments of a path match the route
+func (r RoutePattern) matchSegments(segments []string) bool {
+	if len(segments) != len(r.segments) {
+		return false
+	}
+
+	for i, s := range segments {
+		rs := r.segments[i]
+		switch {
+		case !rs.param && rs.literal != s:
+			return false
+		case rs.pattern != nil && !rs.pattern.MatchString(s):
+			return false
+		}
+	}
+
+	return true
+}
+
+// moreSpecific returns whether r should be matched before other: routes scoped to an authority first, so requests
+// to the authority fall back to the routes of every authority, then routes with more segments, then literal segments
+// before patterned parameters before plain parameters
+func (r RoutePattern) moreSpecific(other RoutePattern) bool {
+	if (len(r.authority) > 0) != (len(other.authority) > 0) {
+		return len(r.authority) > 0
+	}
+
+	if len(r.segments) != len(other.segments) {
+		return len(r.segments) > len(other.segments)
+	}
+
+	for i := range r.segments {
+		a, b := r.segments[i].specificity(), other.segments[i].specificity()
+		if a != b {
+			return a > b
+		}
+	}
+
+	return r.raw < other.raw
+}
+
+func (s routeSegment) specificity() int {
+	switch {
+	case !s.param:
+		return 2
+	case s.pattern != nil:
+		return 1
+	}
+
+	return 0
+}
+
+// normalizeRoutePath strips the query string and surrounding slashes from path, which don't affect the routes it
+// matches
+func normalizeRoutePath(path string) string {
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+
+	return strings.Trim(path, "/")
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return []string{}
+	}
+
+	return strings.Split(path, "/")
+}
+
+// RouteLimit is a limit applied to requests matching a route. Fields the route doesn't set are inherited from the
+// broader scopes.
+type RouteLimit struct {
+	Route RoutePattern
+	Limit LimitOverride
+}
+
+// SortRouteLimits sorts route limits so the most specific route comes first
+func SortRouteLimits(routeLimits []RouteLimit) {
+	sort.Slice(routeLimits, func(i, j int) bool {
+		return routeLimits[i].Route.moreSpecific(routeLimits[j].Route)
+	})
+}
+
+// RouteLimitsFromStrings parses route limits from a map of route templates to JSON encoded limit override records,
+// skipping any that are invalid or inconsistent once applied to parent. The result is sorted most specific route
+// first.
+func RouteLimitsFromStrings(routeLimitStrs map[string]string, parent Limit, logger logrus.FieldLogger) []RouteLimit {
+	routeLimits := []RouteLimit{}
+	for template, limitStr := range routeLimitStrs {
+		route, err := ParseRoutePattern(template)
+		if err != nil {
+			logger.WithError(err).Errorf("error parsing route %v", template)
+			continue
+		}
+
+		limit, err := decodeLimitOverrideRecord(limitStr)
+		if err == nil {
+			err = ValidateResolvedLimit(limit.Apply(parent))
+		}
+
+		if err != nil {
+			logger.WithError(err).Errorf("error parsing limit for route %v", template)
+			continue
+		}
+
+		routeLimits = append(routeLimits, RouteLimit{Route: route, Limit: limit})
+	}
+
+	SortRouteLimits(routeLimits)
+	return routeLimits
+}
+
+// NewRouteMatcher creates a new RouteMatcher for route limits sorted most specific route first, caching the
+// matches of up to cacheSize recently requested paths
+func NewRouteMatcher(routeLimits []RouteLimit, cacheSize int) *RouteMatcher {
+	authorities := map[string]bool{}
+	for _, routeLimit := range routeLimits {
+		if len(routeLimit.Route.authority) > 0 {
+			authorities[routeLimit.Route.authority] = true
+		}
+	}
+
+	return &RouteMatcher{routeLimits: append([]RouteLimit{}, routeLimits...), authorities: authorities, cache: newLRUCache(cacheSize)}
+}
+
+// RouteMatcher finds the route limit matching a request path. Its route limits can't be changed, a new
+// RouteMatcher is created when they are, so cached matches never go stale.
+type RouteMatcher struct {
+	routeLimits []RouteLimit
+	authorities map[string]bool // authorities with routes scoped to them
+
+	mu    sync.Mutex
+	cache *lruCache // authority with scoped routes, if any, and normalized path to routeMatch
+}
+
+type routeMatch struct {
+	routeLimit RouteLimit
+	ok         bool
+}
+
+// RouteLimits returns the route limits, most specific route first
+func (m *RouteMatcher) RouteLimits() []RouteLimit {
+	return append([]RouteLimit{}, m.routeLimits...)
+}
+
+// Match returns the most specific route limit matching path, or false if none do. Routes scoped to an authority
+// aren't matched, see MatchRequest.
+func (m *RouteMatcher) Match(path string) (RouteLimit, bool) {
+	return m.match("", path)
+}
+
+// MatchRequest returns the most specific route limit matching the request, or false if none do. Routes scoped to
+// the request's host are matched before the routes of every authority.
+func (m *RouteMatcher) MatchRequest(request Request) (RouteLimit, bool) {
+	return m.match(request.Host(), request.Path)
+}
+
+func (m *RouteMatcher) match(authority string, path string) (RouteLimit, bool) {
+	if len(m.routeLimits) == 0 {
+		return RouteLimit{}, false
+	}
+
+	// other authorities match the same routes, so they share cached matches
+	if !m.authorities[authority] {
+		authority = ""
+	}
+
+	key := authority + "/" + normalizeRoutePath(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cached, ok := m.cache.get(key); ok {
+		match := cached.(routeMatch)
+		return match.routeLimit, match.ok
+	}
+
+	match := routeMatch{}
+	segments := splitPath(normalizeRoutePath(path))
+	for _, routeLimit := range m.routeLimits {
+		if routeLimit.Route.authority != "" && routeLimit.Route.authority != authority {
+			continue
+		}
+
+		if routeLimit.Route.matchSegments(segments) {
+			match = routeMatch{routeLimit: routeLimit, ok: true}
+			break
+		}
+	}
+
+	m.cache.add(key, match)
+	return match.routeLimit, match.ok
+}
+
+// sameRouteLimits returns whether a and b hold the same routes with the same limits in the same order
+func sameRouteLimits(a []RouteLimit, b []RouteLimit) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Route.raw != b[i].Route.raw || !a[i].Limit.Equal(b[i].Limit) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RouteLimitProvider provides the current route limits
+type RouteLimitProvider interface {
+	// GetRouteMatcher returns a RouteMatcher for the current route limits
+	GetRouteMatcher() *RouteMatcher
+}
+
+// NewRouteRateLimiter creates a new route rate limiter counting the requests of each route in its own bulkhead of
+// bulkheads, if it isn't nil. Requests are blocked when their route's bulkhead is full.
+func NewRouteRateLimiter(conf RouteLimitProvider, counter Counter, clock Clock, bulkheads *Bulkheads, logger logrus.FieldLogger, reporter MetricReporter) *RouteRateLimiter {
+	return &RouteRateLimiter{conf: conf, counter: counter, clock: clock, bulkheads: bulkheads, logger: logger, reporter: reporter}
+}
+
+// RouteRateLimiter rate limits each IP per route, counting all requests matching the most specific route together
+type RouteRateLimiter struct {
+	conf      RouteLimitProvider
+	counter   Counter
+	clock     Clock
+	bulkheads *Bulkheads
+	logger    logrus.FieldLogger
+	reporter  MetricReporter
+}
+
+// Limit limits a request if it matches a route and exceeds the route's rate limit
+func (rl *RouteRateLimiter) Limit(context context.Context, request Request) (bool, uint32, error) {
+	routeLimit, ok := rl.match(request)
+	if !ok {
+		rl.logger.Debugf("no route limit for request %v, allowing", request)
+		tracef(context, "matched no route limit")
+		return false, RequestsRemainingMax, nil
+	}
+
+	start := time.Now()
+	ratelimited := false
+	var err error
+	defer func() {
+		rl.reporter.HandledRouteRatelimit(request, routeLimit.Route.String(), ratelimited, err != nil, time.Now().Sub(start))
+	}()
+
+	limit, scope := resolveRequestLimit(rl.conf, request, &routeLimit)
+	rl.logger.Debugf("matched route limit %v %v for request %v", routeLimit.Route, limit, request)
+	if !limit.Enabled {
+		return false, RequestsRemainingMax, nil
+	}
+
+	currCount, blocked, err := rl.incr(context, request, routeLimit.Route, scope, limit, request.Hits())
+	tracef(context, "route %v counter: count %d of %v, force block: %v, err: %v", routeLimit.Route, currCount, limit, blocked, err)
+	if err == errBulkheadFull {
+		// the request can't be counted, so it's blocked without a hint of how long for, as the bulkhead may free up
+		err = nil
+		hintErrorSwallowed(context)
+		ratelimited = limit.Enforced(request.RemoteAddress)
+		if ratelimited {
+			rl.logger.Debugf("request %v blocked with the bulkhead of route limit %v full", request, routeLimit.Route)
+			hintBlockReason(context, RouteLimitBlockReason, routeLimit.Route.String())
+		}
+		return ratelimited, 0, nil
+	}
+	if err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("error incrementing route limit %v for request %v", routeLimit.Route, request))
+		rl.logger.WithError(err).Error("counter returned error when call incr")
+		return false, 0, err
+	}
+
+	ratelimited = (blocked || currCount > limit.Count) && limit.Enforced(request.RemoteAddress)
+	if ratelimited {
+		rl.logger.Debugf("request %v blocked by route limit %v", request, routeLimit.Route)
+		hintBlockedFor(context, blockedFor(limit, currCount, rl.clock.Now()))
+		hintBlockReason(context, RouteLimitBlockReason, routeLimit.Route.String())
+		return ratelimited, 0, nil
+	}
+
+	return ratelimited, limitRemaining(limit, currCount), nil
+}
+
+func (rl *RouteRateLimiter) match(request Request) (RouteLimit, bool) {
+	return rl.conf.GetRouteMatcher().MatchRequest(request)
+}
+
+func (rl *RouteRateLimiter) incr(context context.Context, request Request, route RoutePattern, scope string, limit Limit, incrBy uint) (uint64, bool, error) {
+	release, err := rl.bulkheads.Acquire(routeBulkhead(route))
+	if err != nil {
+		return 0, false, err
+	}
+	defer release()
+
+	key := rl.RouteKey(request, route, scope)
+	rl.logger.Debugf("generated key %v for request %v", key, request)
+	return incrLimitKey(context, rl.counter, rl.clock, key, limit, incrBy)
+}
+
+// incrLimitKey counts incrBy against limit for key using the limit's algorithm, windowing key for fixed windows
+func incrLimitKey(context context.Context, counter Counter, clock Clock, key string, limit Limit, incrBy uint) (uint64, bool, error) {
+	now := clock.Now()
+	key = limitKey(key, limit)
+	if limit.Algorithm == LeakyBucketAlgorithm {
+		bucket, ok := counter.(LeakyBucketCounter)
+		if !ok {
+			return 0, false, fmt.Errorf("counter does not support the %v algorithm", limit.Algorithm)
+		}
+
+		level, allowed, err := bucket.Fill(context, NamespacedKey(leakyBucketNamespace, key), incrBy, limit.Count, limit.Duration, now)
+		return level, !allowed, err
+	}
+
+	if limit.Algorithm == DayBucketsAlgorithm {
+		return incrDayBuckets(context, counter, key, limit, incrBy, now)
+	}
+
+	if limit.Rollover > 0 {
+		return incrRollover(context, counter, key, limit, incrBy, now)
+	}
+
+	slotKey, expireIn := fixedWindowKey(key, limit, now)
+	return counter.Incr(context, slotKey, incrBy, limit.Count, expireIn)
+}
+
+// limitKey suffixes key with the duration of limit, so limits of different durations resolved for the same key,
+// whose windows may start together, aren't counted together
+func limitKey(key string, limit Limit) string {
+	return key + ":" + strconv.FormatInt(int64(limit.Duration/time.Second), 10)
+}
+
+// fixedWindowKey returns the key of the fixed window of limit containing now for a key already suffixed by limitKey,
+// and how long it must be kept
+func fixedWindowKey(key string, limit Limit, now time.Time) (string, time.Duration) {
+	start, end := limit.Window(now)
+	expireIn := limit.Duration
+	if limit.Calendar != "" {
+		expireIn = windowExpiration(now, end)
+	}
+
+	return key + ":" + strconv.FormatInt(start.Unix(), 10), expireIn
+}
+
+// limitRemaining returns the requests remaining of limit after count, capped to the max uint32
+func limitRemaining(limit Limit, count uint64) uint32 {
+	if count >= limit.Count {
+		return 0
+	}
+
+	remaining := limit.Count - count
+	if remaining > uint64(RequestsRemainingMax) {
+		return RequestsRemainingMax
+	}
+
+	return uint32(remaining)
+}
+
+// RouteKey generates the key shared by all of an IP's requests matching route, counted in scope
+func (rl *RouteRateLimiter) RouteKey(request Request, route RoutePattern, scope string) string {
+	key := NamespacedKey(routeLimitNamespace, route.String()) + ":" + request.RemoteAddress
+	if len(scope) > 0 {
+		key += ":" + scope
+	}
+
+	return key
+}
@@ -0,0 +1,193 @@
+package guardian
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxRouteLimitManifestRows bounds the route limits of a manifest, which are applied in a single transaction
+const maxRouteLimitManifestRows = 10000
+
+// routeLimitManifestColumns are the columns of a route limit manifest and how each sets its route's limit. Cells
+// left empty leave the field to be inherited from the global limit.
+var routeLimitManifestColumns = map[string]func(d *LimitOverrideDocument, value string) error{
+	"route": func(d *LimitOverrideDocument, value string) error { return nil },
+	"count": func(d *LimitOverrideDocument, value string) error {
+		count, err := strconv.ParseUint(value, 10, 64)
+		d.Count = &count
+		return err
+	},
+	"duration": func(d *LimitOverrideDocument, value string) error {
+		d.Duration = value
+		return nil
+	},
+	"enabled": func(d *LimitOverrideDocument, value string) error {
+		enabled, err := strconv.ParseBool(value)
+		d.Enabled = &enabled
+		return err
+	},
+	"algorithm": func(d *LimitOverrideDocument, value string) error {
+		d.Algorithm = value
+		return nil
+	},
+	"enforce_percent": func(d *LimitOverrideDocument, value string) error {
+		percent, err := strconv.ParseUint(value, 10, 32)
+		p := uint(percent)
+		d.EnforcePercent = &p
+		return err
+	},
+	"rollover": func(d *LimitOverrideDocument, value string) error {
+		rollover, err := strconv.ParseUint(value, 10, 64)
+		d.Rollover = &rollover
+		return err
+	},
+	"description": func(d *LimitOverrideDocument, value string) error {
+		d.Description = value
+		return nil
+	},
+	"owner": func(d *LimitOverrideDocument, value string) error {
+		d.Owner = value
+		return nil
+	},
+	"ticket": func(d *LimitOverrideDocument, value string) error {
+		d.Ticket = value
+		return nil
+	},
+}
+
+// ParseRouteLimitManifest parses route limits from a CSV manifest whose header row names its columns, such as
+// route,count,duration,enabled. The route column is required, and the others are those of routeLimitManifestColumns.
+// Each route limit is validated, and a route may only appear once.
+func ParseRouteLimitManifest(r io.Reader) (map[string]LimitOverrideDocument, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("manifest has no header row")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading manifest")
+	}
+
+	hasRoute := false
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		if _, ok := routeLimitManifestColumns[header[i]]; !ok {
+			return nil, fmt.Errorf("manifest has unknown column %q", column)
+		}
+		hasRoute = hasRoute || header[i] == "route"
+	}
+
+	if !hasRoute {
+		return nil, fmt.Errorf("manifest has no route column")
+	}
+
+	limits := map[string]LimitOverrideDocument{}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading manifest")
+		}
+
+		line, _ := reader.FieldPos(0)
+		route, doc, err := parseRouteLimitManifestRow(header, row)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid route limit on line %d", line))
+		}
+
+		if _, ok := limits[route]; ok {
+			return nil, fmt.Errorf("route %v on line %d appears more than once", route, line)
+		}
+
+		if len(limits) == maxRouteLimitManifestRows {
+			return nil, fmt.Errorf("manifest has more than the max of %d route limits", maxRouteLimitManifestRows)
+		}
+		limits[route] = doc
+	}
+
+	return limits, nil
+}
+
+func parseRouteLimitManifestRow(header []string, row []string) (string, LimitOverrideDocument, error) {
+	route := ""
+	doc := LimitOverrideDocument{}
+	for i, column := range header {
+		value := strings.TrimSpace(row[i])
+		if column == "route" {
+			route = value
+		}
+
+		if len(value) == 0 {
+			continue
+		}
+
+		if err := routeLimitManifestColumns[column](&doc, value); err != nil {
+			return "", LimitOverrideDocument{}, errors.Wrap(err, fmt.Sprintf("invalid %v %q", column, value))
+		}
+	}
+
+	pattern, err := ParseRoutePattern(route)
+	if err != nil {
+		return "", LimitOverrideDocument{}, err
+	}
+
+	if _, err := doc.Override(); err != nil {
+		return "", LimitOverrideDocument{}, errors.Wrap(err, fmt.Sprintf("invalid limit for route %v", route))
+	}
+
+	return pattern.String(), doc, nil
+}
+
+// DiffRouteLimits returns the changes applying limits to the route limits of live makes. Route limits of live
+// missing from limits are left unchanged, unless replace is set and they are removed.
+func DiffRouteLimits(live ConfDocument, limits map[string]LimitOverrideDocument, replace bool) []ConfChange {
+	return DiffConfDocuments(live, routeLimitsDocument(live, limits, replace))
+}
+
+// routeLimitsDocument returns the document of the route limits of live with limits applied
+func routeLimitsDocument(live ConfDocument, limits map[string]LimitOverrideDocument, replace bool) ConfDocument {
+	routeLimits := map[string]LimitOverrideDocument{}
+	if !replace {
+		for route, doc := range live.RouteLimits {
+			routeLimits[route] = doc
+		}
+	}
+
+	for route, doc := range limits {
+		routeLimits[route] = doc
+	}
+
+	return ConfDocument{RouteLimits: routeLimits}
+}
+
+// ApplyRouteLimits applies limits to the route limits stored in Redis as DiffRouteLimits describes, returning the
+// changes made. The changes are made in a single transaction, so instances never sync a partially applied manifest.
+func (rs *RedisConfStore) ApplyRouteLimits(limits map[string]LimitOverrideDocument, replace bool) ([]ConfChange, error) {
+	return rs.ApplyRouteLimitsInChunks(limits, replace, ConfChunks{})
+}
+
+// ApplyRouteLimitsInChunks applies limits to the route limits stored in Redis as DiffRouteLimits describes in chunks,
+// returning the changes it applied, even if it returns an error
+func (rs *RedisConfStore) ApplyRouteLimitsInChunks(limits map[string]LimitOverrideDocument, replace bool, chunks ConfChunks) ([]ConfChange, error) {
+	if err := (ConfDocument{RouteLimits: limits}).Validate(); err != nil {
+		return nil, err
+	}
+
+	live, err := rs.ExportConfDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := routeLimitsDocument(live, limits, replace)
+	return rs.applyConfChanges(doc, DiffConfDocuments(live, doc), chunks)
+}
@@ -0,0 +1,93 @@
+package guardian
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRouteLimitManifest(t *testing.T) {
+	manifest := `# route limits of the storefront
+route,count,duration,enabled,owner
+/users/{id}/orders, 10, 1m, true, team-orders
+/search,100,,,
+`
+
+	limits, err := ParseRouteLimitManifest(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	orders, ok := limits["/users/{id}/orders"]
+	if !ok || *orders.Count != 10 || orders.Duration != "1m" || !*orders.Enabled || orders.Owner != "team-orders" {
+		t.Fatalf("unexpected orders limit: %v", orders)
+	}
+
+	// empty cells are inherited
+	search, ok := limits["/search"]
+	if !ok || *search.Count != 100 || search.Duration != "" || search.Enabled != nil {
+		t.Fatalf("unexpected search limit: %v", search)
+	}
+}
+
+func TestParseRouteLimitManifestRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{name: "Empty", manifest: ``},
+		{name: "NoRouteColumn", manifest: "count,duration\n10,1m\n"},
+		{name: "UnknownColumn", manifest: "route,method,count\n/search,GET,10\n"},
+		{name: "InvalidRoute", manifest: "route,count\nsearch,10\n"},
+		{name: "InvalidCount", manifest: "route,count\n/search,ten\n"},
+		{name: "InvalidDuration", manifest: "route,duration\n/search,1ms\n"},
+		{name: "DuplicateRoute", manifest: "route,count\n/search,10\n/search,20\n"},
+		{name: "MissingCells", manifest: "route,count\n/search\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseRouteLimitManifest(strings.NewReader(test.manifest)); err == nil {
+				t.Fatal("expected error but received nil")
+			}
+		})
+	}
+}
+
+func TestApplyRouteLimits(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	if err := c.SetRouteLimit(mustParseRoutePattern(t, "/legacy"), LimitOverrideFromLimit(Limit{Count: 5, Duration: time.Minute, Enabled: true})); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	limits, err := ParseRouteLimitManifest(strings.NewReader("route,count,duration,enabled\n/search,100,1m,true\n/users/{id},10,1s,true\n"))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	changes, err := c.ApplyRouteLimits(limits, false)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, received: %v", changes)
+	}
+
+	routeLimits, err := c.FetchRouteLimits()
+	if err != nil || len(routeLimits) != 3 {
+		t.Fatalf("expected the manifest's route limits to be added to the legacy route limit, received: (%v, %v)", routeLimits, err)
+	}
+
+	// replacing removes the route limits missing from the manifest, and leaves those unchanged alone
+	changes, err = c.ApplyRouteLimits(limits, true)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(changes) != 1 || changes[0].Kind != ConfRemoved || changes[0].Key != "/legacy" {
+		t.Fatalf("expected the legacy route limit to be removed, received: %v", changes)
+	}
+}
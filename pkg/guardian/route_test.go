@@ -0,0 +1,254 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type FakeRouteLimitProvider struct {
+	routeLimits []RouteLimit
+}
+
+func (f *FakeRouteLimitProvider) GetRouteMatcher() *RouteMatcher {
+	return NewRouteMatcher(f.routeLimits, routeMatchCacheSize)
+}
+
+func mustParseRoutePattern(t testing.TB, template string) RoutePattern {
+	t.Helper()
+	route, err := ParseRoutePattern(template)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	return route
+}
+
+func TestRoutePatternMatch(t *testing.T) {
+	tests := []struct {
+		route string
+		path  string
+		want  bool
+	}{
+		{route: "/", path: "/", want: true},
+		{route: "/users", path: "/users/", want: true},
+		{route: "/users/{id}/orders", path: "/users/123/orders", want: true},
+		{route: "/users/{id}/orders", path: "/users/abc/orders?page=2", want: true},
+		{route: "/users/{id}/orders", path: "/users/123/orders/1", want: false},
+		{route: "/users/{id}/orders", path: "/users/orders", want: false},
+		{route: "/users/{id:[0-9]+}", path: "/users/123", want: true},
+		{route: "/users/{id:[0-9]+}", path: "/users/me", want: false},
+		{route: "/users/{id:[0-9]+}", path: "/users/123abc", want: false},
+	}
+
+	for _, test := range tests {
+		route := mustParseRoutePattern(t, test.route)
+		if got := route.Match(test.path); got != test.want {
+			t.Errorf("%v match %v expected: %v received: %v", test.route, test.path, test.want, got)
+		}
+	}
+}
+
+func TestParseRoutePatternRejectsInvalid(t *testing.T) {
+	for _, template := range []string{"users", "/users/{}", "/users/{id", "/users/{id:[}", "/users/a{id}", "api.example.com", "API.example.com/users", "api.example.com:443/users", "api.{x}.com/users"} {
+		if _, err := ParseRoutePattern(template); err == nil {
+			t.Errorf("expected error parsing %v but received nil", template)
+		}
+	}
+}
+
+func TestRouteLimitsFromStringsSortsBySpecificity(t *testing.T) {
+	limit := `{"count": 1, "duration": "1s", "enabled": true}`
+	routeLimits := RouteLimitsFromStrings(map[string]string{
+		"/users/{id}":           limit,
+		"/users/me":             limit,
+		"/users/{id:[0-9]+}":    limit,
+		"/users/{id}/orders":    limit,
+		"api.example.com/users": limit,
+		"/users/{id}/invalid{":  limit,
+		"/users/bad":            `{"count": 1, "duration": "10ms"}`,
+	}, Limit{Count: 1, Duration: time.Second, Enabled: true}, TestingLogger)
+
+	want := []string{"api.example.com/users", "/users/{id}/orders", "/users/me", "/users/{id:[0-9]+}", "/users/{id}"}
+	if len(routeLimits) != len(want) {
+		t.Fatalf("expected: %v received: %v", want, routeLimits)
+	}
+
+	for i, routeLimit := range routeLimits {
+		if routeLimit.Route.String() != want[i] {
+			t.Errorf("expected: %v received: %v", want, routeLimits)
+		}
+	}
+}
+
+func TestRouteMatcherMatchesMostSpecificRoute(t *testing.T) {
+	limit := Limit{Count: 1, Duration: time.Second, Enabled: true}
+	routeLimits := []RouteLimit{
+		{Route: mustParseRoutePattern(t, "/users/me"), Limit: LimitOverrideFromLimit(limit)},
+		{Route: mustParseRoutePattern(t, "/users/{id}"), Limit: LimitOverrideFromLimit(limit)},
+	}
+	matcher := NewRouteMatcher(routeLimits, 2)
+
+	tests := []struct {
+		path  string
+		route string
+		ok    bool
+	}{
+		{path: "/users/me", route: "/users/me", ok: true},
+		{path: "/users/1", route: "/users/{id}", ok: true},
+		{path: "/users/1?foo=bar", route: "/users/{id}", ok: true},
+		{path: "/users/me/", route: "/users/me", ok: true},
+		{path: "/users", ok: false},
+		{path: "/users/me", route: "/users/me", ok: true}, // evicted from the cache by the paths before it
+		{path: "/users", ok: false},                       // cached miss
+	}
+
+	for _, test := range tests {
+		routeLimit, ok := matcher.Match(test.path)
+		if ok != test.ok || routeLimit.Route.String() != test.route {
+			t.Errorf("%v expected: (%v, %v) received: (%v, %v)", test.path, test.route, test.ok, routeLimit.Route.String(), ok)
+		}
+	}
+}
+
+func TestRouteMatcherMatchesAuthorityRoutesFirst(t *testing.T) {
+	limit := Limit{Count: 1, Duration: time.Second, Enabled: true}
+	routeLimits := RouteLimitsFromStrings(map[string]string{
+		"/search":               `{"count": 1, "duration": "1s", "enabled": true}`,
+		"/users/{id}/orders":    `{"count": 1, "duration": "1s", "enabled": true}`,
+		"api.foo.com/search":    `{"count": 1, "duration": "1s", "enabled": true}`,
+		"api.bar.com/search":    `{"count": 1, "duration": "1s", "enabled": true}`,
+		"api.bar.com/{section}": `{"count": 1, "duration": "1s", "enabled": true}`,
+	}, limit, TestingLogger)
+	matcher := NewRouteMatcher(routeLimits, routeMatchCacheSize)
+
+	tests := []struct {
+		authority string
+		path      string
+		route     string
+	}{
+		{authority: "api.foo.com", path: "/search?q=1", route: "api.foo.com/search"},
+		{authority: "API.bar.com:443", path: "/search", route: "api.bar.com/search"},
+		{authority: "api.bar.com", path: "/users", route: "api.bar.com/{section}"},
+		{authority: "api.baz.com", path: "/search", route: "/search"},
+		{authority: "api.foo.com", path: "/users/1/orders", route: "/users/{id}/orders"}, // falls back
+		{authority: "api.foo.com", path: "/users", route: ""},
+	}
+
+	for _, test := range tests {
+		routeLimit, _ := matcher.MatchRequest(Request{Authority: test.authority, Path: test.path})
+		if routeLimit.Route.String() != test.route {
+			t.Errorf("%v%v expected: %v received: %v", test.authority, test.path, test.route, routeLimit.Route.String())
+		}
+	}
+
+	if routeLimit, _ := matcher.Match("/search"); routeLimit.Route.String() != "/search" {
+		t.Errorf("expected /search received: %v", routeLimit.Route.String())
+	}
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache(2)
+	c.add("a", 1)
+	c.add("b", 2)
+	c.get("a")
+	c.add("c", 3)
+
+	if _, ok := c.get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if got, ok := c.get(key); !ok || got.(int) != want {
+			t.Errorf("%v expected: %v received: (%v, %v)", key, want, got, ok)
+		}
+	}
+}
+
+func TestRouteRateLimiterCollapsesResourceIDs(t *testing.T) {
+	limit := Limit{Count: 2, Duration: time.Minute, Enabled: true}
+	provider := &FakeRouteLimitProvider{routeLimits: []RouteLimit{{Route: mustParseRoutePattern(t, "/users/{id}/orders"), Limit: LimitOverrideFromLimit(limit)}}}
+	fstore := &FakeLimitStore{count: make(map[string]uint64)}
+	rl := NewRouteRateLimiter(provider, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
+
+	tests := []struct {
+		path      string
+		blocked   bool
+		remaining uint32
+	}{
+		{path: "/users/1/orders", blocked: false, remaining: 1},
+		{path: "/users/2/orders", blocked: false, remaining: 0},
+		{path: "/users/3/orders", blocked: true, remaining: 0},
+		{path: "/users/3", blocked: false, remaining: RequestsRemainingMax},
+	}
+
+	for _, test := range tests {
+		req := Request{RemoteAddress: "192.168.1.2", Path: test.path}
+		blocked, remaining, err := rl.Limit(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if blocked != test.blocked || remaining != test.remaining {
+			t.Fatalf("%v expected: (%v, %v) received: (%v, %v)", test.path, test.blocked, test.remaining, blocked, remaining)
+		}
+	}
+
+	// other IPs have their own count
+	blocked, _, err := rl.Limit(context.Background(), Request{RemoteAddress: "192.168.1.3", Path: "/users/1/orders"})
+	if err != nil || blocked {
+		t.Fatalf("expected request from another IP to be allowed, received: (%v, %v)", blocked, err)
+	}
+}
+
+func TestRouteRateLimiterFailsOpen(t *testing.T) {
+	limit := Limit{Count: 2, Duration: time.Minute, Enabled: true}
+	provider := &FakeRouteLimitProvider{routeLimits: []RouteLimit{{Route: mustParseRoutePattern(t, "/"), Limit: LimitOverrideFromLimit(limit)}}}
+	fstore := &FakeLimitStore{count: make(map[string]uint64), injectedErr: fmt.Errorf("some error")}
+	rl := NewRouteRateLimiter(provider, fstore, LocalClock{}, nil, TestingLogger, NullReporter{})
+
+	blocked, _, err := rl.Limit(context.Background(), Request{RemoteAddress: "192.168.1.2", Path: "/"})
+	if err == nil {
+		t.Fatal("expected error but received nil")
+	}
+
+	if blocked {
+		t.Fatal("expected request to be allowed")
+	}
+}
+
+func benchmarkRouteLimits(b *testing.B) []RouteLimit {
+	limit := `{"count": 1, "duration": "1s", "enabled": true}`
+	routeLimitStrs := map[string]string{}
+	for i := 0; i < 50; i++ {
+		routeLimitStrs[fmt.Sprintf("/service%v/{id:[0-9]+}/items/{item}", i)] = limit
+	}
+
+	return RouteLimitsFromStrings(routeLimitStrs, Limit{Count: 1, Duration: time.Second, Enabled: true}, TestingLogger)
+}
+
+// BenchmarkRouteMatchLinear matches by scanning every route, splitting the path for each of them
+func BenchmarkRouteMatchLinear(b *testing.B) {
+	routeLimits := benchmarkRouteLimits(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, routeLimit := range routeLimits {
+			if routeLimit.Route.Match("/service49/1234/items/5678?foo=bar") {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkRouteMatcherMatch(b *testing.B) {
+	matcher := NewRouteMatcher(benchmarkRouteLimits(b), routeMatchCacheSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		matcher.Match("/service49/1234/items/5678?foo=bar")
+	}
+}
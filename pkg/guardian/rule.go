@@ -0,0 +1,772 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const ruleNamespace = "rule"
+
+// RuleAction is the action taken on requests matching a Rule
+type RuleAction string
+
+const (
+	// LimitAction rate limits each IP's, or each LimitKey value's, requests matching the rule with the rule's limit
+	LimitAction RuleAction = "limit"
+
+	// BlockAction blocks requests matching the rule
+	BlockAction RuleAction = "block"
+
+	// AllowAction allows requests matching the rule without evaluating further rules or limits
+	AllowAction RuleAction = "allow"
+
+	// ServeAction blocks requests matching the rule, answering them with the rule's static response rather than a
+	// 429 that invites bots to retry. Only callers of the Go client or the batch decisions API can answer with the
+	// response, as Envoy's rate limit filter ignores the response headers and answers with a 429, so confs with serve
+	// rules are rejected by ValidateStaticResponses unless the callers are known to honor static responses.
+	ServeAction RuleAction = "serve"
+
+	// ObserveAction counts requests matching the rule like LimitAction and reports their counts, and whether they
+	// exceeded the rule's limit, without ever blocking them. It measures a candidate limit before enforcing it.
+	ObserveAction RuleAction = "observe"
+
+	// ChallengeAction blocks requests matching the rule, redirecting them to the rule's challenge URL, such as a
+	// CAPTCHA. Remote addresses passing the challenge are allowed for a while, as recorded by ChallengePasses. Like
+	// ServeAction's static response, the redirect is ignored by Envoy's rate limit filter, which answers with a 429, so
+	// confs with challenge rules are also rejected by ValidateStaticResponses unless callers honor static responses.
+	ChallengeAction RuleAction = "challenge"
+)
+
+// counts returns whether requests matching rules with the action are counted against the rule's limit
+func (a RuleAction) counts() bool {
+	return a == LimitAction || a == ObserveAction
+}
+
+// StaticResponse returns whether requests blocked by rules with the action are answered with a static response
+func (a RuleAction) StaticResponse() bool {
+	return a == ServeAction || a == ChallengeAction
+}
+
+// ParseRuleAction parses a RuleAction from a string
+func ParseRuleAction(s string) (RuleAction, error) {
+	switch RuleAction(s) {
+	case LimitAction, BlockAction, AllowAction, ServeAction, ObserveAction, ChallengeAction:
+		return RuleAction(s), nil
+	}
+
+	return "", fmt.Errorf("unknown rule action %q", s)
+}
+
+// maxStaticResponseBody bounds static response bodies, which are sent in gRPC response headers
+const maxStaticResponseBody = 4096
+
+// StaticResponse is the response requests blocked by a ServeAction or ChallengeAction rule are answered with
+type StaticResponse struct {
+	Status int    `json:"status"`
+	Body   string `json:"body,omitempty"`
+	// Location is the URL redirected to, for redirect statuses
+	Location string `json:"location,omitempty"`
+}
+
+// Validate returns an error if the status isn't a valid HTTP status, the body is too large, or the location isn't
+// an absolute http or https URL
+func (sr StaticResponse) Validate() error {
+	if sr.Status < 100 || sr.Status > 599 {
+		return fmt.Errorf("invalid response status %d", sr.Status)
+	}
+
+	if len(sr.Body) > maxStaticResponseBody {
+		return fmt.Errorf("response body of %d bytes exceeds max of %d", len(sr.Body), maxStaticResponseBody)
+	}
+
+	if len(sr.Location) > 0 {
+		if err := validateRedirectURL(sr.Location); err != nil {
+			return errors.Wrap(err, "invalid response location")
+		}
+	}
+
+	return nil
+}
+
+// maxRuleTags bounds the extra metric tags of a rule
+const maxRuleTags = 10
+
+// maxRulePriority bounds the magnitude of a rule's priority
+const maxRulePriority = 1000
+
+const maxMetricTagLength = 200
+
+// ValidateMetricTag returns an error if tag isn't a DataDog tag of the form key:value, or if its key is one Guardian
+// tags rule metrics with itself
+func ValidateMetricTag(tag string) error {
+	if len(tag) == 0 || len(tag) > maxMetricTagLength {
+		return fmt.Errorf("tag %q must be between 1 and %d characters", tag, maxMetricTagLength)
+	}
+
+	if (tag[0] < 'a' || tag[0] > 'z') && (tag[0] < 'A' || tag[0] > 'Z') {
+		return fmt.Errorf("tag %q must start with a letter", tag)
+	}
+
+	for _, c := range tag {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && !strings.ContainsRune("_-:./", c) {
+			return fmt.Errorf("tag %q has an invalid character %q", tag, c)
+		}
+	}
+
+	key := strings.SplitN(tag, ":", 2)[0]
+	switch key {
+	case ruleKey, actionKey, blockedKey, errorKey, overLimitKey:
+		return fmt.Errorf("tag %q uses the reserved key %v", tag, key)
+	}
+
+	return nil
+}
+
+// Rule applies an action to requests matching an expression
+type Rule struct {
+	Name   string
+	When   *Expression
+	Action RuleAction
+	Limit  Limit // used by LimitAction and ObserveAction
+	// LimitKey is the request attribute, such as metadata.user_id, requests are counted by for LimitAction and
+	// ObserveAction.
+	// Requests are counted by remote address if it is empty or the request is missing the attribute.
+	LimitKey string
+	// KeyTemplate composes the key requests are counted by for LimitAction and ObserveAction, the LimitKey value if
+	// nil
+	KeyTemplate *KeyTemplate
+	// Schedules replace Limit while they are active, the first active schedule taking precedence
+	Schedules []LimitSchedule
+	// Baseline raises the limit of each key to a multiple of its average count, nil to count against Limit alone
+	Baseline *Baseline
+	// Cooldown keeps keys blocked by Limit blocked until they've been quiet for its windows, nil to allow keys again
+	// as soon as they're under Limit
+	Cooldown *Cooldown
+	// Distinct is the request attribute, such as path, whose distinct values are counted against Limit rather than
+	// requests, empty to count requests
+	Distinct string
+	// Notify posts the keys LimitAction throttles to the throttle webhook
+	Notify bool
+	// Response is the static response of ServeAction
+	Response StaticResponse
+	// ChallengeURL is the URL requests are redirected to by ChallengeAction
+	ChallengeURL string
+	Metadata     Metadata
+	// Tags are added to the metrics of requests matching the rule
+	Tags []string
+	// Priority orders rule evaluation, higher first. Rules of equal priority are evaluated in order of name.
+	Priority int
+
+	scheduled *scheduledLimit
+}
+
+// LimitAt returns the rule's limit in effect at now
+func (rule Rule) LimitAt(now time.Time) Limit {
+	if len(rule.Schedules) == 0 || rule.scheduled == nil {
+		return rule.Limit
+	}
+
+	return rule.scheduled.limitAt(now, rule.Schedules, rule.Limit)
+}
+
+// RuleDocument is the serializable form of a Rule
+type RuleDocument struct {
+	When   string         `json:"when"`
+	Action string         `json:"action"`
+	Limit  *LimitDocument `json:"limit,omitempty"`
+	// LimitKey is a request attribute named as in ValidateRequestAttribute
+	LimitKey string `json:"limit_key,omitempty"`
+	// KeyTemplate is parsed by ParseKeyTemplate
+	KeyTemplate string `json:"key_template,omitempty"`
+	// Schedules replace Limit while they are active, the first active schedule taking precedence
+	Schedules []ScheduleDocument `json:"schedules,omitempty"`
+	// Baseline raises the limit of each key to a multiple of its average count over the baseline's period
+	Baseline *BaselineDocument `json:"baseline,omitempty"`
+	// Cooldown keeps keys blocked by the limit blocked until they've been under its percent of the limit for its
+	// windows
+	Cooldown *Cooldown `json:"cooldown,omitempty"`
+	// Distinct is a request attribute named as in ValidateRequestAttribute whose distinct values are counted instead
+	// of requests
+	Distinct string `json:"distinct,omitempty"`
+	// Notify is only allowed with the limit action
+	Notify bool `json:"notify,omitempty"`
+	// Response is required by the serve action
+	Response *StaticResponse `json:"response,omitempty"`
+	// ChallengeURL is required by the challenge action, an absolute http or https URL
+	ChallengeURL string `json:"challenge_url,omitempty"`
+	// Tags are DataDog tags, such as team:payments, added to the metrics of requests matching the rule
+	Tags []string `json:"tags,omitempty"`
+	// Priority is between -1000 and 1000, rules with higher priorities being evaluated first
+	Priority int `json:"priority,omitempty"`
+	Metadata
+}
+
+// RuleDocumentFromRule converts a Rule to a RuleDocument
+func RuleDocumentFromRule(rule Rule) RuleDocument {
+	doc := RuleDocument{When: rule.When.String(), Action: string(rule.Action), Priority: rule.Priority, Tags: rule.Tags, Metadata: rule.Metadata}
+	if rule.Action == ServeAction {
+		response := rule.Response
+		doc.Response = &response
+	}
+
+	if rule.Action == ChallengeAction {
+		doc.ChallengeURL = rule.ChallengeURL
+	}
+
+	if rule.Action.counts() {
+		limitDoc := LimitDocumentFromLimit(rule.Limit)
+		doc.Limit = &limitDoc
+		doc.LimitKey = rule.LimitKey
+		doc.Distinct = rule.Distinct
+		doc.Notify = rule.Notify
+		if rule.KeyTemplate != nil {
+			doc.KeyTemplate = rule.KeyTemplate.String()
+		}
+		for _, ls := range rule.Schedules {
+			doc.Schedules = append(doc.Schedules, ScheduleDocumentFromLimitSchedule(ls))
+		}
+		if rule.Baseline != nil {
+			baselineDoc := BaselineDocumentFromBaseline(*rule.Baseline)
+			doc.Baseline = &baselineDoc
+		}
+		if rule.Cooldown != nil {
+			cooldown := *rule.Cooldown
+			doc.Cooldown = &cooldown
+		}
+	}
+
+	return doc
+}
+
+// Rule converts the document to a Rule named name
+func (rd RuleDocument) Rule(name string) (Rule, error) {
+	if len(name) == 0 {
+		return Rule{}, fmt.Errorf("rule name must not be empty")
+	}
+
+	when, err := ParseExpression(rd.When)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	action, err := ParseRuleAction(rd.Action)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	if rd.Priority < -maxRulePriority || rd.Priority > maxRulePriority {
+		return Rule{}, fmt.Errorf("rule %v priority %d must be between %d and %d", name, rd.Priority, -maxRulePriority, maxRulePriority)
+	}
+
+	if err := rd.Metadata.Validate(); err != nil {
+		return Rule{}, errors.Wrap(err, fmt.Sprintf("invalid metadata for rule %v", name))
+	}
+
+	if len(rd.Tags) > maxRuleTags {
+		return Rule{}, fmt.Errorf("rule %v has %d tags, more than the max of %d", name, len(rd.Tags), maxRuleTags)
+	}
+
+	for _, tag := range rd.Tags {
+		if err := ValidateMetricTag(tag); err != nil {
+			return Rule{}, errors.Wrap(err, fmt.Sprintf("invalid tag for rule %v", name))
+		}
+	}
+
+	rule := Rule{Name: name, When: when, Action: action, Priority: rd.Priority, Metadata: rd.Metadata, Tags: rd.Tags}
+	if action == ServeAction {
+		if rd.Response == nil {
+			return Rule{}, fmt.Errorf("rule %v with action %v requires a response", name, action)
+		}
+
+		if err := rd.Response.Validate(); err != nil {
+			return Rule{}, errors.Wrap(err, fmt.Sprintf("invalid response for rule %v", name))
+		}
+		rule.Response = *rd.Response
+	}
+
+	if action == ChallengeAction {
+		if len(rd.ChallengeURL) == 0 {
+			return Rule{}, fmt.Errorf("rule %v with action %v requires a challenge url", name, action)
+		}
+
+		if err := validateRedirectURL(rd.ChallengeURL); err != nil {
+			return Rule{}, errors.Wrap(err, fmt.Sprintf("invalid challenge url for rule %v", name))
+		}
+		rule.ChallengeURL = rd.ChallengeURL
+	}
+
+	if rd.Notify && action != LimitAction {
+		return Rule{}, fmt.Errorf("rule %v with action %v can't notify, only the %v action throttles keys", name, action, LimitAction)
+	}
+
+	if !action.counts() {
+		return rule, nil
+	}
+	rule.Notify = rd.Notify
+
+	if rd.Limit == nil {
+		return Rule{}, fmt.Errorf("rule %v with action %v requires a limit", name, action)
+	}
+
+	if rule.Limit, err = rd.Limit.Limit(); err != nil {
+		return Rule{}, errors.Wrap(err, fmt.Sprintf("invalid limit for rule %v", name))
+	}
+
+	if len(rd.LimitKey) > 0 {
+		if err := ValidateRequestAttribute(rd.LimitKey); err != nil {
+			return Rule{}, errors.Wrap(err, fmt.Sprintf("invalid limit key for rule %v", name))
+		}
+		rule.LimitKey = rd.LimitKey
+	}
+
+	for _, sd := range rd.Schedules {
+		ls, err := sd.LimitSchedule()
+		if err != nil {
+			return Rule{}, errors.Wrap(err, fmt.Sprintf("invalid schedule for rule %v", name))
+		}
+		rule.Schedules = append(rule.Schedules, ls)
+	}
+
+	if rd.Baseline != nil {
+		if len(rule.Schedules) > 0 {
+			return Rule{}, fmt.Errorf("rule %v can't have both schedules and a baseline", name)
+		}
+
+		baseline, err := rd.Baseline.Baseline(rule.Limit)
+		if err != nil {
+			return Rule{}, errors.Wrap(err, fmt.Sprintf("invalid baseline for rule %v", name))
+		}
+		rule.Baseline = &baseline
+	}
+
+	if rd.Cooldown != nil {
+		if len(rule.Schedules) > 0 || rule.Baseline != nil {
+			return Rule{}, fmt.Errorf("rule %v can't have a cooldown with schedules or a baseline", name)
+		}
+
+		if err := rd.Cooldown.Validate(rule.Limit); err != nil {
+			return Rule{}, errors.Wrap(err, fmt.Sprintf("invalid cooldown for rule %v", name))
+		}
+		cooldown := *rd.Cooldown
+		rule.Cooldown = &cooldown
+	}
+
+	if len(rd.KeyTemplate) > 0 {
+		if rule.KeyTemplate, err = ParseKeyTemplate(rd.KeyTemplate); err != nil {
+			return Rule{}, errors.Wrap(err, fmt.Sprintf("invalid key template for rule %v", name))
+		}
+
+		if err := validateWindowedKeyTemplate(rule); err != nil {
+			return Rule{}, errors.Wrap(err, fmt.Sprintf("invalid key template for rule %v", name))
+		}
+	}
+
+	if len(rd.Distinct) > 0 {
+		rule.Distinct = rd.Distinct
+		if err := validateDistinct(rule); err != nil {
+			return Rule{}, errors.Wrap(err, fmt.Sprintf("invalid distinct for rule %v", name))
+		}
+	}
+
+	if len(rule.Schedules) > 0 {
+		rule.scheduled = newScheduledLimit()
+	}
+
+	return rule, nil
+}
+
+// validateWindowedKeyTemplate returns an error if rule's KeyTemplate places the window but any of its limits isn't
+// counted in plain fixed windows, or if it has a baseline or cooldown. The other algorithms, baselines and cooldowns
+// key their counts by something other than the window.
+func validateWindowedKeyTemplate(rule Rule) error {
+	if !rule.KeyTemplate.Windowed() {
+		return nil
+	}
+
+	if rule.Baseline != nil {
+		return fmt.Errorf("the {%v} placeholder can't be used with a baseline", windowPlaceholder)
+	}
+
+	if rule.Cooldown != nil {
+		return fmt.Errorf("the {%v} placeholder can't be used with a cooldown", windowPlaceholder)
+	}
+
+	limits := []Limit{rule.Limit}
+	for _, ls := range rule.Schedules {
+		limits = append(limits, ls.Limit)
+	}
+
+	for _, limit := range limits {
+		if (limit.Algorithm != "" && limit.Algorithm != FixedWindowAlgorithm) || limit.Rollover > 0 {
+			return fmt.Errorf("the {%v} placeholder requires the %v algorithm without rollover", windowPlaceholder, FixedWindowAlgorithm)
+		}
+	}
+
+	return nil
+}
+
+// SortRules sorts rules in the order they are evaluated, by descending priority and then by name. Names are unique,
+// so the order doesn't depend on the order the rules were fetched in.
+func SortRules(rules []Rule) {
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		return rules[i].Name < rules[j].Name
+	})
+}
+
+// ValidateStaticResponses returns an error naming the rules whose action answers requests with a static response,
+// unless honored, which is whether every caller of the rate limit service answers blocked requests with their static
+// response. Envoy's rate limit filter ignores static responses and answers with a 429, so behind Envoy those rules
+// would silently act as block rules.
+func ValidateStaticResponses(rules []Rule, honored bool) error {
+	if honored {
+		return nil
+	}
+
+	names := []string{}
+	for _, rule := range rules {
+		if rule.Action.StaticResponse() {
+			names = append(names, rule.Name)
+		}
+	}
+
+	if len(names) > 0 {
+		return fmt.Errorf("rules %v answer requests with static responses, which the callers of the rate limit service aren't known to honor", strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+// RulesFromStrings parses rules from a map of rule names to JSON encoded RuleDocuments, skipping any that are
+// invalid. The result is sorted by SortRules, the order rules are evaluated in.
+func RulesFromStrings(ruleStrs map[string]string, logger logrus.FieldLogger) []Rule {
+	rules := []Rule{}
+	for name, ruleStr := range ruleStrs {
+		doc := RuleDocument{}
+		if err := json.Unmarshal([]byte(ruleStr), &doc); err != nil {
+			logger.WithError(err).Errorf("error decoding rule %v", name)
+			continue
+		}
+
+		rule, err := doc.Rule(name)
+		if err != nil {
+			logger.WithError(err).Errorf("error parsing rule %v", name)
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	SortRules(rules)
+	return rules
+}
+
+// RuleProvider provides the current rules
+type RuleProvider interface {
+	// GetRules returns the current rules in the order they are evaluated
+	GetRules() []Rule
+}
+
+// NewRuleEvaluator creates a new RuleEvaluator counting requests of rules with too many distinct LimitKey values by
+// remote address, as decided by guard, and the requests of each rule in its own bulkhead of bulkheads. A nil guard
+// never degrades rules, and nil bulkheads never block. Requests are blocked by limit rules whose bulkhead is full.
+func NewRuleEvaluator(conf RuleProvider, counter Counter, clock Clock, guard *KeyCardinalityGuard, bulkheads *Bulkheads, logger logrus.FieldLogger, reporter MetricReporter) *RuleEvaluator {
+	return &RuleEvaluator{conf: conf, counter: counter, clock: clock, guard: guard, bulkheads: bulkheads, logger: logger, reporter: reporter}
+}
+
+// RuleEvaluator applies rules to requests
+type RuleEvaluator struct {
+	conf      RuleProvider
+	counter   Counter
+	clock     Clock
+	guard     *KeyCardinalityGuard
+	bulkheads *Bulkheads
+	logger    logrus.FieldLogger
+	reporter  MetricReporter
+}
+
+// Evaluate applies each rule matching request in order. It is a CondRequestBlockerFunc that stops the chain when a
+// request is blocked or allowed by a rule. Rules whose limit can't be counted are skipped, failing open.
+func (re *RuleEvaluator) Evaluate(context context.Context, request Request) (bool, bool, uint32, error) {
+	minRemaining := RequestsRemainingMax
+	for _, rule := range re.conf.GetRules() {
+		if !rule.When.Eval(request) {
+			continue
+		}
+
+		re.logger.Debugf("request %v matched rule %v", request, rule.Name)
+		tracef(context, "matched rule %v with action %v", rule.Name, rule.Action)
+		hint := DecisionHintFromContext(context)
+		switch rule.Action {
+		case AllowAction:
+			hint.MatchRule(rule, true)
+			re.reporter.HandledRule(request, rule, false, false, 0)
+			return true, false, RequestsRemainingMax, nil
+		case BlockAction:
+			re.logger.Debugf("request %v blocked by rule %v", request, rule.Name)
+			hint.MatchRule(rule, true)
+			hint.Block(BlockReason{Kind: RuleBlockReason, Name: rule.Name})
+			re.reporter.HandledRule(request, rule, true, false, 0)
+			return true, true, 0, nil
+		case ServeAction:
+			re.logger.Debugf("request %v served a static response by rule %v", request, rule.Name)
+			hint.Serve(rule.Response)
+			hint.MatchRule(rule, true)
+			hint.Block(BlockReason{Kind: RuleBlockReason, Name: rule.Name})
+			re.reporter.HandledRule(request, rule, true, false, 0)
+			return true, true, 0, nil
+		case ChallengeAction:
+			re.logger.Debugf("request %v challenged by rule %v", request, rule.Name)
+			hint.Serve(rule.challengeResponse(request))
+			hint.MatchRule(rule, true)
+			hint.Block(BlockReason{Kind: ChallengeBlockReason, Name: rule.Name})
+			re.reporter.HandledRule(request, rule, true, false, 0)
+			return true, true, 0, nil
+		case ObserveAction:
+			hint.MatchRule(rule, false)
+			re.observe(context, request, rule)
+			continue
+		}
+
+		blocked, remaining := re.limit(context, request, rule)
+		hint.MatchRule(rule, blocked)
+		if blocked {
+			return true, true, 0, nil
+		}
+
+		if remaining < minRemaining {
+			minRemaining = remaining
+		}
+	}
+
+	return false, false, minRemaining, nil
+}
+
+func (re *RuleEvaluator) limit(context context.Context, request Request, rule Rule) (bool, uint32) {
+	start := time.Now()
+	blocked := false
+	var err error
+	defer func() {
+		re.reporter.HandledRule(request, rule, blocked, err != nil, time.Now().Sub(start))
+	}()
+
+	limit := rule.LimitAt(re.clock.Now())
+	if !limit.Enabled {
+		return false, RequestsRemainingMax
+	}
+
+	value := re.limitKeyValue(context, request, rule)
+	key, limit, count, forceBlock, err := re.incr(context, request, rule, limit, value)
+	tracef(context, "rule %v counter %v: count %d of %v, force block: %v, err: %v", rule.Name, key, count, limit, forceBlock, err)
+	if err == errBulkheadFull {
+		// the request can't be counted, so it's blocked without a hint of how long for, as the bulkhead may free up
+		err = nil
+		hintErrorSwallowed(context)
+		blocked = limit.Enforced(value)
+		if blocked {
+			re.logger.Debugf("request %v blocked with the bulkhead of rule %v full", request, rule.Name)
+			hintBlockReason(context, RuleLimitBlockReason, rule.Name)
+		}
+		return blocked, 0
+	}
+	if err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("error incrementing limit of rule %v for request %v", rule.Name, request))
+		re.logger.WithError(err).Error("counter returned error when call incr, skipping rule")
+		hintErrorSwallowed(context)
+		return false, RequestsRemainingMax
+	}
+
+	blocked = (forceBlock || count > limit.Count) && limit.Enforced(value)
+	if blocked {
+		re.logger.Debugf("request %v blocked by limit of rule %v", request, rule.Name)
+		// the requests of a key cooling down must be counted to tell when it's quiet, so they can't be cached
+		if rule.Cooldown == nil {
+			hintBlockedFor(context, blockedFor(limit, count, re.clock.Now()))
+		}
+		hintBlockReason(context, RuleLimitBlockReason, rule.Name)
+		return true, 0
+	}
+
+	return false, limitRemaining(limit, count)
+}
+
+// observe counts request against the limit of an observe rule and reports the count, never blocking it
+func (re *RuleEvaluator) observe(context context.Context, request Request, rule Rule) {
+	limit := rule.LimitAt(re.clock.Now())
+	if !limit.Enabled {
+		return
+	}
+
+	key, limit, count, forceBlock, err := re.incr(context, request, rule, limit, re.limitKeyValue(context, request, rule))
+	tracef(context, "observed rule %v counter %v: count %d of %v, err: %v", rule.Name, key, count, limit, err)
+	if err == errBulkheadFull {
+		return // the rejection is reported by the bulkhead, and observing never decides requests
+	}
+	if err != nil {
+		re.logger.WithError(err).Errorf("error incrementing counter of observed rule %v", rule.Name)
+		return
+	}
+
+	re.reporter.ObservedRule(request, rule, count, forceBlock || count > limit.Count)
+}
+
+// RuleKey generates the key counting an IP's, or the rule's LimitKey value's, requests matching rule. Looking up a
+// key isn't counted by the cardinality guard, so it doesn't bring the rule closer to being degraded.
+func (re *RuleEvaluator) RuleKey(request Request, rule Rule) string {
+	if re.guard.Exceeded(rule) {
+		return degradedRuleKey(request, rule)
+	}
+
+	now := re.clock.Now()
+	values, templateKey := re.templateKey(request, rule, rule.limitKeyValue(request))
+	return re.counterKey(request, rule, rule.LimitAt(now), values, templateKey, now)
+}
+
+// incr counts request against limit, returning the key it was counted under and the limit it was counted against,
+// which differs from limit when the rule has a baseline
+func (re *RuleEvaluator) incr(context context.Context, request Request, rule Rule, limit Limit, value string) (string, Limit, uint64, bool, error) {
+	now := re.clock.Now()
+	values, templateKey := re.templateKey(request, rule, value)
+	key := re.counterKey(request, rule, limit, values, templateKey, now)
+	degraded := re.templateDegraded(context, rule, templateKey)
+	if degraded {
+		key = degradedRuleKey(request, rule)
+	}
+
+	release, err := re.bulkheads.Acquire(ruleBulkhead(rule))
+	if err != nil {
+		return key, limit, 0, false, err
+	}
+	defer release()
+
+	if rule.Baseline != nil {
+		count, limit, err := incrBaseline(context, re.counter, key, limit, *rule.Baseline, request.Hits(), now)
+		return key, limit, count, false, err
+	}
+
+	if rule.Cooldown != nil {
+		count, forceBlock, err := incrCooldown(context, re.counter, key, limit, *rule.Cooldown, request.Hits(), now)
+		return key, limit, count, forceBlock, err
+	}
+
+	if len(rule.Distinct) > 0 {
+		count, err := incrDistinct(context, re.counter, key, limit, rule.Distinct, request, now)
+		return key, limit, count, false, err
+	}
+
+	if rule.KeyTemplate == nil || degraded || !rule.KeyTemplate.Windowed() {
+		count, forceBlock, err := incrLimitKey(context, re.counter, re.clock, key, limit, request.Hits())
+		return key, limit, count, forceBlock, err
+	}
+
+	// the key already has the window, which is validated to be a plain fixed window
+	_, end := limit.Window(now)
+	expireIn := limit.Duration
+	if limit.Calendar != "" {
+		expireIn = windowExpiration(now, end)
+	}
+
+	count, forceBlock, err := re.counter.Incr(context, key, request.Hits(), limit.Count, expireIn)
+	return key, limit, count, forceBlock, err
+}
+
+// counterKey returns the key counting request for rule, the value of values if the rule has no KeyTemplate and
+// templateKey, with the window placed if the template is Windowed, if it has one. Keys are namespaced by the rule, so
+// templates can't collide with the keys of other rules.
+func (re *RuleEvaluator) counterKey(request Request, rule Rule, limit Limit, values keyTemplateValues, templateKey string, now time.Time) string {
+	key := NamespacedKey(ruleNamespace, rule.Name) + ":"
+	if rule.KeyTemplate == nil {
+		return key + values.key
+	}
+
+	if rule.KeyTemplate.Windowed() {
+		start, _ := limit.Window(now)
+		values.window = start.Unix()
+		return key + rule.KeyTemplate.key(request, values)
+	}
+
+	return key + templateKey
+}
+
+// degradedRuleKey returns the key counting request for rule once the cardinality guard has degraded the rule
+func degradedRuleKey(request Request, rule Rule) string {
+	return NamespacedKey(ruleNamespace, rule.Name) + ":" + request.RemoteAddress
+}
+
+// templateKey resolves the values of rule's KeyTemplate for request, value being the value of its LimitKey, and
+// returns them with the key the template composes from them without the window. The key is empty if the rule has no
+// KeyTemplate, and the route is only matched if the template places it.
+func (re *RuleEvaluator) templateKey(request Request, rule Rule, value string) (keyTemplateValues, string) {
+	values := keyTemplateValues{key: value}
+	if rule.KeyTemplate == nil {
+		return values, ""
+	}
+
+	if rule.KeyTemplate.Routed() {
+		values.route = request.Path
+		if routes, ok := re.conf.(RouteLimitProvider); ok {
+			if routeLimit, ok := routes.GetRouteMatcher().MatchRequest(request); ok {
+				values.route = routeLimit.Route.String()
+			}
+		}
+	}
+
+	return values, rule.KeyTemplate.key(request, values)
+}
+
+// templateDegraded returns whether the cardinality guard has degraded rule, whose KeyTemplate composes its key from
+// values that may be client controlled, so that the rule counts requests by remote address instead. templateKey is
+// recorded without its window, so a rule isn't degraded by the windows passing.
+func (re *RuleEvaluator) templateDegraded(context context.Context, rule Rule, templateKey string) bool {
+	if re.guard == nil || rule.KeyTemplate == nil {
+		return false
+	}
+
+	if re.guard.Degraded(rule, templateKey) {
+		tracef(context, "rule %v has too many distinct %v keys, counting by remote address", rule.Name, rule.KeyTemplate)
+		return true
+	}
+
+	return false
+}
+
+// limitKeyValue returns the value request is counted by for rule, falling back to the remote address when the
+// cardinality guard has degraded the rule. The values of rules with a KeyTemplate are guarded as part of the key
+// composed from them, by templateDegraded.
+func (re *RuleEvaluator) limitKeyValue(context context.Context, request Request, rule Rule) string {
+	value := rule.limitKeyValue(request)
+	if re.guard == nil || rule.KeyTemplate != nil || value == request.RemoteAddress {
+		return value
+	}
+
+	if re.guard.Degraded(rule, value) {
+		tracef(context, "rule %v has too many distinct %v keys, counting by remote address", rule.Name, rule.LimitKey)
+		return request.RemoteAddress
+	}
+
+	return value
+}
+
+// limitKeyValue returns the value requests are counted by, the remote address unless the request has the rule's
+// LimitKey attribute. Values are prefixed with the attribute so they can't collide with remote addresses.
+func (rule Rule) limitKeyValue(request Request) string {
+	if len(rule.LimitKey) == 0 || rule.LimitKey == remoteAddressDescriptor {
+		return request.RemoteAddress
+	}
+
+	value := request.Attribute(rule.LimitKey)
+	if len(value) == 0 {
+		return request.RemoteAddress
+	}
+
+	return rule.LimitKey + "=" + value
+}
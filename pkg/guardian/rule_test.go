@@ -0,0 +1,300 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type FakeRuleProvider struct {
+	rules []Rule
+}
+
+func (f *FakeRuleProvider) GetRules() []Rule {
+	return f.rules
+}
+
+func mustParseRule(t *testing.T, name string, doc RuleDocument) Rule {
+	t.Helper()
+	rule, err := doc.Rule(name)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	return rule
+}
+
+func TestRuleEvaluatorActions(t *testing.T) {
+	rules := []Rule{
+		mustParseRule(t, "a-allow-internal", RuleDocument{When: `ip.inCIDR("10.0.0.0/8")`, Action: "allow"}),
+		mustParseRule(t, "b-block-admin", RuleDocument{When: `req.path.startsWith("/admin")`, Action: "block"}),
+	}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, &FakeLimitStore{count: make(map[string]uint64)}, LocalClock{}, nil, nil, TestingLogger, NullReporter{})
+
+	tests := []struct {
+		name    string
+		request Request
+		stop    bool
+		blocked bool
+	}{
+		{name: "Allowed", request: Request{RemoteAddress: "10.0.0.1", Path: "/admin"}, stop: true, blocked: false},
+		{name: "Blocked", request: Request{RemoteAddress: "192.168.1.2", Path: "/admin/users"}, stop: true, blocked: true},
+		{name: "NoMatch", request: Request{RemoteAddress: "192.168.1.2", Path: "/"}, stop: false, blocked: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stop, blocked, _, err := re.Evaluate(context.Background(), test.request)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if stop != test.stop || blocked != test.blocked {
+				t.Fatalf("expected: (%v, %v) received: (%v, %v)", test.stop, test.blocked, stop, blocked)
+			}
+		})
+	}
+}
+
+func TestRuleEvaluatorLimit(t *testing.T) {
+	limit := &LimitDocument{Count: 2, Duration: "1m", Enabled: true}
+	rules := []Rule{mustParseRule(t, "posts", RuleDocument{When: `req.method == "POST"`, Action: "limit", Limit: limit})}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, &FakeLimitStore{count: make(map[string]uint64)}, LocalClock{}, nil, nil, TestingLogger, NullReporter{})
+
+	post := Request{RemoteAddress: "192.168.1.2", Method: "POST"}
+	tests := []struct {
+		request   Request
+		blocked   bool
+		remaining uint32
+	}{
+		{request: post, blocked: false, remaining: 1},
+		{request: Request{RemoteAddress: "192.168.1.2", Method: "GET"}, blocked: false, remaining: RequestsRemainingMax},
+		{request: post, blocked: false, remaining: 0},
+		{request: post, blocked: true, remaining: 0},
+	}
+
+	for i, test := range tests {
+		stop, blocked, remaining, err := re.Evaluate(context.Background(), test.request)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if stop != test.blocked || blocked != test.blocked || remaining != test.remaining {
+			t.Fatalf("iteration %d expected: (%v, %v) received: (%v, %v, %v)", i, test.blocked, test.remaining, stop, blocked, remaining)
+		}
+	}
+}
+
+func TestRuleEvaluatorLimitKey(t *testing.T) {
+	limit := &LimitDocument{Count: 1, Duration: "1m", Enabled: true}
+	rules := []Rule{mustParseRule(t, "per-user", RuleDocument{When: `true`, Action: "limit", Limit: limit, LimitKey: "metadata.user_id"})}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, &FakeLimitStore{count: make(map[string]uint64)}, LocalClock{}, nil, nil, TestingLogger, NullReporter{})
+
+	tests := []struct {
+		request Request
+		blocked bool
+	}{
+		{request: Request{RemoteAddress: "192.168.1.2", Metadata: map[string]string{"user_id": "1"}}, blocked: false},
+		{request: Request{RemoteAddress: "192.168.1.3", Metadata: map[string]string{"user_id": "1"}}, blocked: true}, // same user, another IP
+		{request: Request{RemoteAddress: "192.168.1.3", Metadata: map[string]string{"user_id": "2"}}, blocked: false},
+		{request: Request{RemoteAddress: "192.168.1.3"}, blocked: false}, // counted by remote address without a user
+		{request: Request{RemoteAddress: "192.168.1.3"}, blocked: true},
+	}
+
+	for i, test := range tests {
+		_, blocked, _, err := re.Evaluate(context.Background(), test.request)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if blocked != test.blocked {
+			t.Fatalf("request %d expected blocked: %v received: %v", i, test.blocked, blocked)
+		}
+	}
+}
+
+type FakeObservedRuleReporter struct {
+	NullReporter
+	counts    []uint64
+	overLimit []bool
+}
+
+func (f *FakeObservedRuleReporter) ObservedRule(request Request, rule Rule, count uint64, overLimit bool) {
+	f.counts = append(f.counts, count)
+	f.overLimit = append(f.overLimit, overLimit)
+}
+
+func TestRuleEvaluatorObserve(t *testing.T) {
+	limit := &LimitDocument{Count: 1, Duration: "1m", Enabled: true}
+	rules := []Rule{
+		mustParseRule(t, "a-observe-ua", RuleDocument{When: `true`, Action: "observe", Limit: limit, LimitKey: "header.user-agent"}),
+		mustParseRule(t, "b-limit", RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Count: 10, Duration: "1m", Enabled: true}}),
+	}
+	reporter := &FakeObservedRuleReporter{}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, &FakeLimitStore{count: make(map[string]uint64)}, LocalClock{}, nil, nil, TestingLogger, reporter)
+
+	for i := 0; i < 3; i++ {
+		req := Request{RemoteAddress: fmt.Sprintf("192.168.1.%d", i), Headers: map[string]string{"user-agent": "curl"}}
+		stop, blocked, remaining, err := re.Evaluate(context.Background(), req)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		// observed rules neither block nor affect the requests remaining, and later rules are still evaluated
+		if stop || blocked || remaining != 9 {
+			t.Fatalf("request %d expected to be allowed by the limit rule, received: (%v, %v, %v)", i, stop, blocked, remaining)
+		}
+	}
+
+	wantCounts, wantOverLimit := []uint64{1, 2, 3}, []bool{false, true, true}
+	if fmt.Sprint(reporter.counts) != fmt.Sprint(wantCounts) || fmt.Sprint(reporter.overLimit) != fmt.Sprint(wantOverLimit) {
+		t.Fatalf("expected: (%v, %v) received: (%v, %v)", wantCounts, wantOverLimit, reporter.counts, reporter.overLimit)
+	}
+}
+
+func TestRuleEvaluatorServe(t *testing.T) {
+	response := &StaticResponse{Status: 200, Body: "<html></html>"}
+	rules := []Rule{mustParseRule(t, "bots", RuleDocument{When: `req.header("user-agent").contains("bot")`, Action: "serve", Response: response})}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, &FakeLimitStore{count: make(map[string]uint64)}, LocalClock{}, nil, nil, TestingLogger, NullReporter{})
+
+	hint := NewDecisionHint()
+	req := Request{RemoteAddress: "192.168.1.2", Headers: map[string]string{"user-agent": "badbot"}}
+	stop, blocked, _, err := re.Evaluate(WithDecisionHint(context.Background(), hint), req)
+	if err != nil || !stop || !blocked {
+		t.Fatalf("expected request to be blocked, received: (%v, %v, %v)", stop, blocked, err)
+	}
+
+	if got := hint.StaticResponse(); got == nil || *got != *response {
+		t.Fatalf("expected: %v received: %v", response, got)
+	}
+
+	if got := RuleDocumentFromRule(rules[0]); got.Response == nil || *got.Response != *response {
+		t.Fatalf("expected rule document with response %v received: %v", response, got.Response)
+	}
+}
+
+func TestRuleEvaluatorLimitFailsOpen(t *testing.T) {
+	limit := &LimitDocument{Count: 2, Duration: "1m", Enabled: true}
+	rules := []Rule{mustParseRule(t, "all", RuleDocument{When: `true`, Action: "limit", Limit: limit})}
+	fstore := &FakeLimitStore{count: make(map[string]uint64), injectedErr: fmt.Errorf("some error")}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, fstore, LocalClock{}, nil, nil, TestingLogger, NullReporter{})
+
+	hint := NewDecisionHint()
+	stop, blocked, _, err := re.Evaluate(WithDecisionHint(context.Background(), hint), Request{RemoteAddress: "192.168.1.2"})
+	if stop || blocked || err != nil {
+		t.Fatalf("expected rule to be skipped, received: (%v, %v, %v)", stop, blocked, err)
+	}
+
+	if !hint.ErrorSwallowed() {
+		t.Fatal("expected the error to be recorded to the hint")
+	}
+}
+
+func TestRuleDocumentRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  RuleDocument
+	}{
+		{name: "InvalidExpression", doc: RuleDocument{When: `req.path ==`, Action: "block"}},
+		{name: "InvalidAction", doc: RuleDocument{When: `true`, Action: "tarpit"}},
+		{name: "MissingLimit", doc: RuleDocument{When: `true`, Action: "limit"}},
+		{name: "InvalidLimit", doc: RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Duration: "1ms"}}},
+		{name: "InvalidLimitKey", doc: RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Duration: "1s"}, LimitKey: "metadata."}},
+		{name: "MissingResponse", doc: RuleDocument{When: `true`, Action: "serve"}},
+		{name: "InvalidResponseStatus", doc: RuleDocument{When: `true`, Action: "serve", Response: &StaticResponse{Status: 999}}},
+		{name: "PriorityTooHigh", doc: RuleDocument{When: `true`, Action: "block", Priority: maxRulePriority + 1}},
+		{name: "PriorityTooLow", doc: RuleDocument{When: `true`, Action: "block", Priority: -maxRulePriority - 1}},
+		{name: "MissingChallengeURL", doc: RuleDocument{When: `true`, Action: "challenge"}},
+		{name: "RelativeChallengeURL", doc: RuleDocument{When: `true`, Action: "challenge", ChallengeURL: "/captcha"}},
+		{name: "InvalidResponseLocation", doc: RuleDocument{When: `true`, Action: "serve", Response: &StaticResponse{Status: 302, Location: "ftp://example.com"}}},
+		{name: "ResponseBodyTooLarge", doc: RuleDocument{When: `true`, Action: "serve", Response: &StaticResponse{Status: 200, Body: strings.Repeat("a", maxStaticResponseBody+1)}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.doc.Rule("rule"); err == nil {
+				t.Fatal("expected error but received nil")
+			}
+		})
+	}
+}
+
+func TestRulesFromStringsSortsByName(t *testing.T) {
+	rules := RulesFromStrings(map[string]string{
+		"b":       `{"when": "true", "action": "block"}`,
+		"a":       `{"when": "true", "action": "allow"}`,
+		"invalid": `{"when": "true", "action": "limit"}`,
+	}, TestingLogger)
+
+	if len(rules) != 2 || rules[0].Name != "a" || rules[1].Name != "b" {
+		t.Fatalf("unexpected rules: %v", rules)
+	}
+}
+
+func TestRulesFromStringsSortsByPriority(t *testing.T) {
+	ruleStrs := map[string]string{
+		"a-default": `{"when": "true", "action": "block"}`,
+		"b-first":   `{"when": "true", "action": "allow", "priority": 10}`,
+		"c-last":    `{"when": "true", "action": "allow", "priority": -10}`,
+		"d-first":   `{"when": "true", "action": "allow", "priority": 10}`,
+	}
+
+	// map iteration order varies, the evaluation order mustn't
+	for i := 0; i < 10; i++ {
+		rules := RulesFromStrings(ruleStrs, TestingLogger)
+		names := []string{}
+		for _, rule := range rules {
+			names = append(names, rule.Name)
+		}
+
+		if expected := "b-first,d-first,a-default,c-last"; strings.Join(names, ",") != expected {
+			t.Fatalf("expected: %v received: %v", expected, strings.Join(names, ","))
+		}
+	}
+}
+
+func TestRuleDocumentRoundTrip(t *testing.T) {
+	doc := RuleDocument{When: `req.method == "POST"`, Action: "limit", Limit: &LimitDocument{Count: 5, Duration: time.Minute.String(), Enabled: true}, Priority: 5}
+	rule := mustParseRule(t, "posts", doc)
+
+	got := RuleDocumentFromRule(rule)
+	if got.When != doc.When || got.Action != doc.Action || got.Priority != doc.Priority || got.Limit == nil || *got.Limit != *doc.Limit {
+		t.Fatalf("expected: %v received: %v", doc, got)
+	}
+}
+
+func TestRuleTags(t *testing.T) {
+	valid := []string{"team:payments", "endpoint:/v1/export", "canary"}
+	if _, err := (RuleDocument{When: `true`, Action: "block", Tags: valid}).Rule("tagged"); err != nil {
+		t.Errorf("got error: %v", err)
+	}
+
+	invalid := []string{"", "1team:payments", "team:pay ments", "rule:other", "action:allow"}
+	for _, tag := range invalid {
+		if _, err := (RuleDocument{When: `true`, Action: "block", Tags: []string{tag}}).Rule("tagged"); err == nil {
+			t.Errorf("expected an error for tag %q", tag)
+		}
+	}
+}
+
+func TestRuleEvaluatorMatchesRule(t *testing.T) {
+	rules := []Rule{
+		mustParseRule(t, "a-observe", RuleDocument{When: `true`, Action: "observe", Limit: &LimitDocument{Count: 1, Duration: "1m", Enabled: true}}),
+		mustParseRule(t, "b-block-admin", RuleDocument{When: `req.path == "/admin"`, Action: "block"}),
+	}
+	re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, &FakeLimitStore{count: make(map[string]uint64)}, LocalClock{}, nil, nil, TestingLogger, NullReporter{})
+
+	tests := map[string]string{"/": "a-observe", "/admin": "b-block-admin"}
+	for path, want := range tests {
+		hint := NewDecisionHint()
+		if _, _, _, err := re.Evaluate(WithDecisionHint(context.Background(), hint), Request{RemoteAddress: "192.168.1.2", Path: path}); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if got := hint.MatchedRule(); got == nil || got.Name != want {
+			t.Errorf("%v expected matched rule %v, received: %v", path, want, got)
+		}
+	}
+}
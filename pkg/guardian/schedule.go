@@ -0,0 +1,204 @@
+package guardian
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Schedule is a cron-like spec of the minutes it is active. Specs have the five fields of crontab(5): minute (0-59),
+// hour (0-23), day of month (1-31), month (1-12), and day of week (0-6, Sunday being 0 or 7). Fields are * or
+// comma separated values and ranges (a-b), each optionally followed by a step (/n). As in cron, when both the day
+// of month and day of week are restricted a minute matching either is active.
+//
+// For example "* 0-6 * * *" is active overnight, and "* 9-17 * * 1-5" during business hours on weekdays.
+type Schedule struct {
+	spec     string
+	timeZone string
+	location *time.Location
+
+	minutes, hours, doms, months, dows uint64
+	domStar, dowStar                   bool
+}
+
+type scheduleField struct {
+	name     string
+	min, max int
+}
+
+var scheduleFields = [5]scheduleField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+// ParseSchedule parses a Schedule evaluated in the named time zone, UTC if empty
+func ParseSchedule(spec string, timeZone string) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(scheduleFields) {
+		return nil, fmt.Errorf("schedule %q must have %d fields", spec, len(scheduleFields))
+	}
+
+	loc, err := loadLocation(timeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	masks := [5]uint64{}
+	for i, field := range fields {
+		if masks[i], err = parseScheduleField(field, scheduleFields[i]); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error parsing schedule %q", spec))
+		}
+	}
+
+	// Sunday may be written as 7
+	if masks[4]&(1<<7) != 0 {
+		masks[4] = masks[4]&^(1<<7) | 1
+	}
+
+	return &Schedule{
+		spec:     strings.Join(fields, " "),
+		timeZone: timeZone,
+		location: loc,
+		minutes:  masks[0],
+		hours:    masks[1],
+		doms:     masks[2],
+		months:   masks[3],
+		dows:     masks[4],
+		domStar:  fields[2] == "*",
+		dowStar:  fields[4] == "*",
+	}, nil
+}
+
+func parseScheduleField(field string, f scheduleField) (uint64, error) {
+	mask := uint64(0)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid %v step %q", f.name, part[i+1:])
+			}
+			part = part[:i]
+		}
+
+		low, high := f.min, f.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %v %q", f.name, part)
+			}
+
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid %v %q", f.name, part)
+				}
+			}
+		}
+
+		if low < f.min || high > f.max || low > high {
+			return 0, fmt.Errorf("%v %q out of range %d-%d", f.name, part, f.min, f.max)
+		}
+
+		for v := low; v <= high; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+
+	return mask, nil
+}
+
+// Active returns whether the minute containing t matches the schedule
+func (s *Schedule) Active(t time.Time) bool {
+	t = t.In(s.location)
+	if s.minutes&(1<<uint(t.Minute())) == 0 || s.hours&(1<<uint(t.Hour())) == 0 || s.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dom := s.doms&(1<<uint(t.Day())) != 0
+	dow := s.dows&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// LimitSchedule replaces a limit with Limit while Schedule is active
+type LimitSchedule struct {
+	Schedule *Schedule
+	Limit    Limit
+}
+
+// ScheduleDocument is the serializable form of a LimitSchedule
+type ScheduleDocument struct {
+	Cron     string        `json:"cron"`
+	TimeZone string        `json:"time_zone,omitempty"`
+	Limit    LimitDocument `json:"limit"`
+}
+
+// ScheduleDocumentFromLimitSchedule converts a LimitSchedule to a ScheduleDocument
+func ScheduleDocumentFromLimitSchedule(ls LimitSchedule) ScheduleDocument {
+	return ScheduleDocument{Cron: ls.Schedule.String(), TimeZone: ls.Schedule.timeZone, Limit: LimitDocumentFromLimit(ls.Limit)}
+}
+
+// LimitSchedule converts the document to a LimitSchedule
+func (sd ScheduleDocument) LimitSchedule() (LimitSchedule, error) {
+	schedule, err := ParseSchedule(sd.Cron, sd.TimeZone)
+	if err != nil {
+		return LimitSchedule{}, err
+	}
+
+	limit, err := sd.Limit.Limit()
+	if err != nil {
+		return LimitSchedule{}, errors.Wrap(err, fmt.Sprintf("invalid limit for schedule %q", sd.Cron))
+	}
+
+	return LimitSchedule{Schedule: schedule, Limit: limit}, nil
+}
+
+// scheduledLimit caches the limit in effect for a minute, so schedules are evaluated once a minute rather than for
+// every request
+type scheduledLimit struct {
+	sync.Mutex
+	minute int64
+	limit  Limit
+}
+
+func newScheduledLimit() *scheduledLimit {
+	return &scheduledLimit{minute: -1}
+}
+
+// limitAt returns the limit of the first schedule active at now, or fallback if none are
+func (c *scheduledLimit) limitAt(now time.Time, schedules []LimitSchedule, fallback Limit) Limit {
+	minute := now.Unix() / 60
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.minute == minute {
+		return c.limit
+	}
+
+	c.minute, c.limit = minute, fallback
+	for _, ls := range schedules {
+		if ls.Schedule.Active(now) {
+			c.limit = ls.Limit
+			break
+		}
+	}
+
+	return c.limit
+}
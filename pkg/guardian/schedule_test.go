@@ -0,0 +1,93 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScheduleActive(t *testing.T) {
+	tests := []struct {
+		spec     string
+		timeZone string
+		time     string
+		want     bool
+	}{
+		{spec: "* 0-6 * * *", time: "2019-01-07T03:30:00Z", want: true},
+		{spec: "* 0-6 * * *", time: "2019-01-07T07:00:00Z", want: false},
+		{spec: "* 9-17 * * 1-5", time: "2019-01-07T12:00:00Z", want: true},  // Monday
+		{spec: "* 9-17 * * 1-5", time: "2019-01-06T12:00:00Z", want: false}, // Sunday
+		{spec: "* * * * 7", time: "2019-01-06T12:00:00Z", want: true},
+		{spec: "*/15 * * * *", time: "2019-01-07T12:30:00Z", want: true},
+		{spec: "*/15 * * * *", time: "2019-01-07T12:31:00Z", want: false},
+		{spec: "0,30 12 * * *", time: "2019-01-07T12:30:59Z", want: true},
+		{spec: "* * 1 * 1", time: "2019-01-07T12:00:00Z", want: true}, // restricted day of month or week
+		{spec: "* * 1 * 2", time: "2019-01-07T12:00:00Z", want: false},
+		{spec: "* 0-6 * * *", timeZone: "America/Los_Angeles", time: "2019-01-07T12:00:00Z", want: true}, // 4am
+	}
+
+	for _, test := range tests {
+		schedule, err := ParseSchedule(test.spec, test.timeZone)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		now, _ := time.Parse(time.RFC3339, test.time)
+		if got := schedule.Active(now); got != test.want {
+			t.Errorf("%v at %v expected: %v received: %v", test.spec, test.time, test.want, got)
+		}
+	}
+}
+
+func TestParseScheduleRejectsInvalid(t *testing.T) {
+	for _, spec := range []string{"* * * *", "60 * * * *", "* 5-1 * * *", "* * 0 * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(spec, ""); err == nil {
+			t.Errorf("expected error parsing %v but received nil", spec)
+		}
+	}
+
+	if _, err := ParseSchedule("* * * * *", "Nowhere/Special"); err == nil {
+		t.Error("expected error parsing unknown time zone but received nil")
+	}
+}
+
+func TestRuleEvaluatorScheduledLimit(t *testing.T) {
+	doc := RuleDocument{
+		When:      `true`,
+		Action:    "limit",
+		Limit:     &LimitDocument{Count: 3, Duration: "1m", Enabled: true},
+		Schedules: []ScheduleDocument{{Cron: "* 0-6 * * *", Limit: LimitDocument{Count: 1, Duration: "1m", Enabled: true}}},
+	}
+	rules := []Rule{mustParseRule(t, "scheduled", doc)}
+
+	tests := []struct {
+		name    string
+		now     string
+		allowed int
+	}{
+		{name: "Overnight", now: "2019-01-07T03:00:00Z", allowed: 1},
+		{name: "Daytime", now: "2019-01-07T12:00:00Z", allowed: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now, _ := time.Parse(time.RFC3339, test.now)
+			re := NewRuleEvaluator(&FakeRuleProvider{rules: rules}, &FakeLimitStore{count: make(map[string]uint64)}, &fixedClock{now}, nil, nil, TestingLogger, NullReporter{})
+
+			allowed := 0
+			for i := 0; i < 5; i++ {
+				_, blocked, _, err := re.Evaluate(context.Background(), Request{RemoteAddress: "192.168.1.2"})
+				if err != nil {
+					t.Fatalf("got error: %v", err)
+				}
+				if !blocked {
+					allowed++
+				}
+			}
+
+			if allowed != test.allowed {
+				t.Errorf("expected %d requests allowed, received: %d", test.allowed, allowed)
+			}
+		})
+	}
+}
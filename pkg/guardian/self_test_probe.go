@@ -0,0 +1,121 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+)
+
+const selfTestNamespace = "self_test"
+
+// SelfTestRequestID identifies the synthetic requests of a SelfTest in logs and decision streams
+const SelfTestRequestID = "guardian-self-test"
+
+// DefaultSelfTestRemoteAddress is the remote address of a SelfTest's requests, from a range reserved for
+// documentation so it's never a real client
+const DefaultSelfTestRemoteAddress = "192.0.2.1"
+
+// NewSelfTest creates a new SelfTest sending requests for domain from remoteAddress to srv, expecting them to be
+// blocked if expectBlocked and allowed otherwise, and counting the key of instance in redis, each within timeout
+func NewSelfTest(srv ratelimit.RateLimitServiceServer, redis *redis.Client, domain string, remoteAddress string, expectBlocked bool, instance string, timeout time.Duration, logger logrus.FieldLogger, reporter MetricReporter) *SelfTest {
+	return &SelfTest{
+		srv:           srv,
+		redis:         redis,
+		domain:        domain,
+		remoteAddress: remoteAddress,
+		expectBlocked: expectBlocked,
+		key:           NamespacedKey(selfTestNamespace, instance),
+		timeout:       timeout,
+		logger:        logger,
+		reporter:      reporter,
+	}
+}
+
+// SelfTest periodically runs a synthetic request through the whole rate limit pipeline, and counts a key of its own
+// in Redis, reporting how long each probe took and whether it passed. A pipeline that stops answering or deciding as
+// expected, or a Redis that stops counting, is then noticed without waiting for a client to complain. The key is
+// counted with the client directly rather than through the RedisCounter, whose cache answers without waiting on
+// Redis in asynchronous mode, and whose breaker spills to local counts while Redis is down.
+type SelfTest struct {
+	srv           ratelimit.RateLimitServiceServer
+	redis         *redis.Client
+	domain        string
+	remoteAddress string
+	expectBlocked bool
+	key           string
+	timeout       time.Duration
+	logger        logrus.FieldLogger
+	reporter      MetricReporter
+}
+
+// Run probes every interval until stop is closed
+func (s *SelfTest) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Probe()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Probe runs a single probe, reporting and returning its error if it failed
+func (s *SelfTest) Probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := s.probe(ctx)
+	s.reporter.SelfTest(time.Since(start), err == nil)
+	if err != nil {
+		s.logger.WithError(err).Error("self test failed")
+	}
+
+	return err
+}
+
+func (s *SelfTest) probe(ctx context.Context) error {
+	req := RateLimitRequestFromRequest(s.domain, Request{RemoteAddress: s.remoteAddress, Method: "GET", Path: "/", ID: SelfTestRequestID})
+	resp, err := s.srv.ShouldRateLimit(ctx, req)
+	if err != nil {
+		return errors.Wrap(err, "error deciding self test request")
+	}
+
+	expected := ratelimit.RateLimitResponse_OK
+	if s.expectBlocked {
+		expected = ratelimit.RateLimitResponse_OVER_LIMIT
+	}
+
+	if code := resp.GetOverallCode(); code != expected {
+		return fmt.Errorf("self test request decided with code %v, expected %v", code, expected)
+	}
+
+	if len(resp.GetStatuses()) != len(req.GetDescriptors()) {
+		return fmt.Errorf("self test request answered with %d statuses for %d descriptors", len(resp.GetStatuses()), len(req.GetDescriptors()))
+	}
+
+	// the key is counted twice, so a Redis answering with stale or made up counts fails the probe
+	pipe := s.redis.WithContext(ctx).TxPipeline()
+	first := pipe.Incr(s.key)
+	second := pipe.Incr(s.key)
+	pipe.Expire(s.key, time.Minute)
+	if _, err := pipe.Exec(); err != nil {
+		return errors.Wrap(err, "error counting self test key")
+	}
+
+	if second.Val() != first.Val()+1 {
+		return fmt.Errorf("self test key counted %d after %d", second.Val(), first.Val())
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package guardian
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+
+	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+)
+
+type FakeSelfTestReporter struct {
+	NullReporter
+	passed []bool
+}
+
+func (f *FakeSelfTestReporter) SelfTest(duration time.Duration, passed bool) {
+	f.passed = append(f.passed, passed)
+}
+
+type FakeRateLimitServer struct {
+	err error
+}
+
+func (f *FakeRateLimitServer) ShouldRateLimit(ctx context.Context, relreq *ratelimit.RateLimitRequest) (*ratelimit.RateLimitResponse, error) {
+	return nil, f.err
+}
+
+func TestSelfTest(t *testing.T) {
+	decided := []Request{}
+	server := NewServer(func(c context.Context, r Request) (bool, uint32, error) {
+		decided = append(decided, r)
+		return false, 10, nil
+	}, StaticReportOnlyProvider{false}, "", 0, TestingLogger, NullReporter{})
+
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis: %v", err)
+	}
+	defer s.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	reporter := &FakeSelfTestReporter{}
+	selfTest := NewSelfTest(server, client, "edge", DefaultSelfTestRemoteAddress, false, "host:1", time.Second, TestingLogger, reporter)
+
+	for i := 0; i < 2; i++ {
+		if err := selfTest.Probe(); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+	}
+
+	if len(decided) != 2 || decided[0].RemoteAddress != DefaultSelfTestRemoteAddress || decided[0].ID != SelfTestRequestID {
+		t.Errorf("expected the self test requests to be decided, received: %v", decided)
+	}
+
+	if count, _ := s.Get(NamespacedKey(selfTestNamespace, "host:1")); count != "4" {
+		t.Errorf("expected the self test key to be counted twice per probe in redis, received: %v", count)
+	}
+
+	failing := []*SelfTest{
+		NewSelfTest(&FakeRateLimitServer{err: errors.New("unavailable")}, client, "edge", DefaultSelfTestRemoteAddress, false, "host:1", time.Second, TestingLogger, reporter),
+		NewSelfTest(server, client, "edge", DefaultSelfTestRemoteAddress, true, "host:1", time.Second, TestingLogger, reporter),
+	}
+
+	for i, selfTest := range failing {
+		if err := selfTest.Probe(); err == nil {
+			t.Errorf("self test %d expected error", i)
+		}
+	}
+
+	s.Close()
+	if err := selfTest.Probe(); err == nil {
+		t.Error("expected error with redis down")
+	}
+
+	want := []bool{true, true, false, false, false}
+	if len(reporter.passed) != len(want) {
+		t.Fatalf("expected: %v received: %v", want, reporter.passed)
+	}
+
+	for i := range want {
+		if reporter.passed[i] != want[i] {
+			t.Errorf("expected: %v received: %v", want, reporter.passed)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package guardian
 
 import (
 	"context"
+	"strconv"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
 )
@@ -13,44 +16,73 @@ type ReportOnlyProvider interface {
 	GetReportOnly() bool
 }
 
-func NewServer(blocker RequestBlockerFunc, reportOnlyProvider ReportOnlyProvider, logger logrus.FieldLogger, reporter MetricReporter) *Server {
-	return &Server{blocker: blocker, roProvider: reportOnlyProvider, reporter: reporter, logger: logger}
+// ReportOnlyOverrider is a ReportOnlyProvider whose report only mode can be overridden locally, such as an
+// EnforcementOverride. The Server reports whether it is overridden alongside report only mode.
+type ReportOnlyOverrider interface {
+	ReportOnlyOverridden() bool
+}
+
+// NewServer creates a new Server. Requests carrying debugToken in their DebugHeader have their decision traced and
+// logged at info level, an empty debugToken disables tracing. Blocked decisions carry how long they remain valid, up
+// to maxBlockedHint, in their BlockedForHeader. A zero maxBlockedHint disables the header.
+func NewServer(blocker RequestBlockerFunc, reportOnlyProvider ReportOnlyProvider, debugToken string, maxBlockedHint time.Duration, logger logrus.FieldLogger, reporter MetricReporter) *Server {
+	return &Server{blocker: blocker, roProvider: reportOnlyProvider, debugToken: debugToken, maxBlockedHint: maxBlockedHint, reporter: reporter, logger: logger}
 }
 
 type Server struct {
-	roProvider ReportOnlyProvider
-	logger     logrus.FieldLogger
-	reporter   MetricReporter
-	blocker    RequestBlockerFunc
+	roProvider     ReportOnlyProvider
+	logger         logrus.FieldLogger
+	reporter       MetricReporter
+	blocker        RequestBlockerFunc
+	debugToken     string
+	maxBlockedHint time.Duration
 }
 
 func (s *Server) ShouldRateLimit(ctx context.Context, relreq *ratelimit.RateLimitRequest) (*ratelimit.RateLimitResponse, error) {
 	start := time.Now()
 	req := RequestFromRateLimitRequest(relreq)
+	req.ID = requestID(req)
+	logger := s.logger.WithField("request_id", req.ID)
+
+	logger.Debugf("received rate limit request %v", relreq)
+	logger.Debugf("converted to request %v", req)
 
-	s.logger.Debugf("received rate limit request %v", relreq)
-	s.logger.Debugf("converted to request %v", req)
+	var trace *DecisionTrace
+	if debugRequested(req, s.debugToken) {
+		trace = NewDecisionTrace()
+		ctx = WithDecisionTrace(ctx, trace)
+	}
+
+	hint := NewDecisionHint()
+	ctx = WithDecisionHint(ctx, hint)
 
 	block, remaining, err := s.blocker(ctx, req)
 	if err != nil {
-		s.logger.WithError(err).Error("blocker returned error")
+		logger.WithError(err).Error("blocker returned error")
 	}
 
-	s.logger.Debugf("block: %v, remaining: %v, err: %v", block, remaining, err)
+	logger.Debugf("block: %v, remaining: %v, err: %v", block, remaining, err)
 
 	resp := &ratelimit.RateLimitResponse{
 		OverallCode: ratelimit.RateLimitResponse_OK,
 	}
 
-	reportOnly := s.roProvider.GetReportOnly()
-	s.reporter.CurrentReportOnlyMode(reportOnly)
+	s.reporter.CurrentReportOnlyMode(s.roProvider.GetReportOnly())
+	if o, ok := s.roProvider.(ReportOnlyOverrider); ok {
+		s.reporter.CurrentReportOnlyOverride(o.ReportOnlyOverridden())
+	}
+	reportOnly := reportOnlyForRequest(s.roProvider, req)
 
 	if block && !reportOnly {
 		resp.OverallCode = ratelimit.RateLimitResponse_OVER_LIMIT
 	}
 
 	if block {
-		s.logger.Infof("would block on request %v", req)
+		blockLogger := logger
+		if reason := hint.BlockReason(); reason != nil {
+			blockLogger = blockLogger.WithField("reason", reason.String())
+		}
+		blockLogger.Infof("would block on request %v", req)
 	}
 
 	for i := 0; i < len(relreq.GetDescriptors()); i++ {
@@ -58,7 +90,58 @@ func (s *Server) ShouldRateLimit(ctx context.Context, relreq *ratelimit.RateLimi
 		resp.Statuses = append(resp.Statuses, status)
 	}
 
-	s.logger.Debugf("sending response %v", resp)
-	s.reporter.Duration(req, block, err != nil, time.Since(start))
+	if resp.OverallCode == ratelimit.RateLimitResponse_OVER_LIMIT {
+		s.sendDecisionHeaders(ctx, hint, logger)
+	}
+
+	if trace != nil {
+		trace.Tracef("decided block: %v, report only: %v, remaining: %v, err: %v", block, reportOnly, remaining, err)
+		logger.WithField("trace", trace.Steps()).Infof("decision trace for request %v", req)
+	}
+
+	logger.Debugf("sending response %v", resp)
+	if hint.Exemption() == "" { // exempted requests are only counted by the exemption they matched
+		s.reporter.Duration(req, hint.MatchedRule(), block, err != nil, time.Since(start))
+	}
 	return resp, nil
 }
+
+// sendDecisionHeaders sets the response headers of a blocked request from what the blockers recorded to hint: the
+// BlockedForHeader to how long the request will stay blocked, capped to maxBlockedHint, the BlockReasonHeader to why
+// it was blocked, and the static response headers to the response it should be answered with. Envoy's v2 rate limit
+// filter ignores response headers, so only the Go client honors them.
+func (s *Server) sendDecisionHeaders(ctx context.Context, hint *DecisionHint, logger logrus.FieldLogger) {
+	md := metadata.MD{}
+	if blockedFor, ok := hint.Get(); ok && s.maxBlockedHint > 0 {
+		if blockedFor > s.maxBlockedHint {
+			blockedFor = s.maxBlockedHint
+		}
+
+		if ms := int64(blockedFor / time.Millisecond); ms > 0 {
+			tracef(ctx, "blocked for at least %v", blockedFor)
+			md[BlockedForHeader] = []string{strconv.FormatInt(ms, 10)}
+		}
+	}
+
+	if reason := hint.BlockReason(); reason != nil {
+		tracef(ctx, "blocked because of %v", reason)
+		md[BlockReasonHeader] = []string{reason.String()}
+	}
+
+	if response := hint.StaticResponse(); response != nil {
+		tracef(ctx, "serving static response with status %d", response.Status)
+		md[StaticResponseStatusHeader] = []string{strconv.Itoa(response.Status)}
+		md[StaticResponseBodyHeader] = []string{response.Body}
+		if len(response.Location) > 0 {
+			md[StaticResponseLocationHeader] = []string{response.Location}
+		}
+	}
+
+	if len(md) == 0 {
+		return
+	}
+
+	if err := grpc.SetHeader(ctx, md); err != nil {
+		logger.WithError(err).Debug("error setting decision headers")
+	}
+}
@@ -101,7 +101,7 @@ func TestShouldRateLimit(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			server := NewServer(test.blockerFunc, StaticReportOnlyProvider{test.reportOnly}, TestingLogger, NullReporter{})
+			server := NewServer(test.blockerFunc, StaticReportOnlyProvider{test.reportOnly}, "", 0, TestingLogger, NullReporter{})
 
 			res, err := server.ShouldRateLimit(context.Background(), test.req)
 
@@ -116,3 +116,42 @@ func TestShouldRateLimit(t *testing.T) {
 		})
 	}
 }
+
+func TestShouldRateLimitTracesDebugRequests(t *testing.T) {
+	var traced []*DecisionTrace
+	blockerFunc := func(c context.Context, req Request) (bool, uint32, error) {
+		tracef(c, "evaluated")
+		traced = append(traced, DecisionTraceFromContext(c))
+		return false, 20, nil
+	}
+
+	server := NewServer(blockerFunc, StaticReportOnlyProvider{false}, "secret", 0, TestingLogger, NullReporter{})
+	tests := []struct {
+		name     string
+		token    string
+		expected bool
+	}{
+		{name: "MatchingToken", token: "secret", expected: true},
+		{name: "WrongToken", token: "guess", expected: false},
+		{name: "NoToken", token: "", expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			traced = nil
+			req := RateLimitRequestFromRequest("somedomain", Request{RemoteAddress: "192.168.1.2", Headers: map[string]string{DebugHeader: test.token}})
+			if _, err := server.ShouldRateLimit(context.Background(), req); err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			trace := traced[0]
+			if got := trace != nil; got != test.expected {
+				t.Fatalf("expected traced: %v, received: %v", test.expected, got)
+			}
+
+			if trace != nil && len(trace.Steps()) != 2 {
+				t.Errorf("expected the evaluated and decided steps, received: %v", trace.Steps())
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package guardian
+
+// recordShards is the number of shards of the maps written by every request, such as those of the traffic profile,
+// so concurrent requests mostly lock different shards rather than all contend on one mutex
+const recordShards = 16
+
+// recordShard returns the shard of key, hashing it with FNV-1a without allocating
+func recordShard(key string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+
+	return int(hash % recordShards)
+}
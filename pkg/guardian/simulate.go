@@ -0,0 +1,69 @@
+package guardian
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LimitExplainer explains how the effective limits of a request are resolved
+type LimitExplainer interface {
+	RouteLimitProvider
+
+	// ExplainLimit resolves the effective limit of request, through the route scope of routeLimit unless it is nil,
+	// along with each scope applied
+	ExplainLimit(request Request, routeLimit *RouteLimit) LimitResolution
+}
+
+// NewSimulateHandler creates a new SimulateHandler
+func NewSimulateHandler(explainer LimitExplainer, logger logrus.FieldLogger) *SimulateHandler {
+	return &SimulateHandler{explainer: explainer, logger: logger}
+}
+
+// SimulateHandler is an admin HTTP handler reporting the effective limits of a request, and the scopes they were
+// resolved through, without counting the request. The request is described by the remote_address, authority, method,
+// and path query parameters.
+type SimulateHandler struct {
+	explainer LimitExplainer
+	logger    logrus.FieldLogger
+}
+
+type simulateResponse struct {
+	RemoteAddress string `json:"remote_address"`
+	// Limit is the limit counting all of the remote address's requests
+	Limit LimitResolution `json:"limit"`
+	// Route and RouteLimit are the most specific route matching the request and its limit, omitted if none match
+	Route      string           `json:"route,omitempty"`
+	RouteLimit *LimitResolution `json:"route_limit,omitempty"`
+}
+
+func (h *SimulateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method), h.logger)
+		return
+	}
+
+	q := r.URL.Query()
+	req := Request{
+		RemoteAddress: CanonicalRemoteAddress(q.Get(remoteAddressDescriptor)),
+		Authority:     q.Get(authorityDescriptor),
+		Method:        q.Get(methodDescriptor),
+		Path:          q.Get(pathDescriptor),
+		Headers:       make(map[string]string),
+	}
+
+	if len(req.RemoteAddress) == 0 {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("missing required query parameter %v", remoteAddressDescriptor), h.logger)
+		return
+	}
+
+	res := simulateResponse{RemoteAddress: req.RemoteAddress, Limit: h.explainer.ExplainLimit(req, nil)}
+	if routeLimit, ok := h.explainer.GetRouteMatcher().MatchRequest(req); ok {
+		resolution := h.explainer.ExplainLimit(req, &routeLimit)
+		res.Route = routeLimit.Route.String()
+		res.RouteLimit = &resolution
+	}
+
+	writeJSON(w, http.StatusOK, res, h.logger)
+}
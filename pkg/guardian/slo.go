@@ -0,0 +1,365 @@
+package guardian
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The SLOs an SLOTracker tracks
+const (
+	LatencySLO       = "latency"
+	FailOpenSLO      = "fail_open"
+	ConfStalenessSLO = "conf_staleness"
+)
+
+// sloShortWindow and sloLongWindow are the windows burn rates are measured over. An SLO alerts only when its budget
+// burns fast over both, so a brief spike doesn't page and an alert resolves soon after the burn stops.
+const sloShortWindow = 5 * time.Minute
+const sloLongWindow = time.Hour
+
+// sloBuckets is the number of minutes of events kept, enough for sloLongWindow
+const sloBuckets = int(sloLongWindow / time.Minute)
+
+// SLOObjectives are the objectives of the SLOs tracked. An SLO whose objective is 0 isn't tracked.
+type SLOObjectives struct {
+	// LatencyThreshold is the duration decisions should be made within
+	LatencyThreshold time.Duration
+	// Latency is the fraction of decisions that should be made within LatencyThreshold, e.g. 0.99 for a p99
+	Latency float64
+	// FailOpen is the fraction of decisions that should be made without an error failing them open. Errors swallowed
+	// by limiters count against it too, whether a rule that couldn't count a request let it through or a full
+	// bulkhead blocked it.
+	FailOpen float64
+	// ConfMaxAge is the longest the conf should go without being synced
+	ConfMaxAge time.Duration
+	// ConfFreshness is the fraction of time the conf should have been synced within ConfMaxAge
+	ConfFreshness float64
+	// AlertBurnRate is the burn rate of the error budget over both windows at which an SLO alerts, e.g. 14.4 for a
+	// burn that would exhaust a 30 day budget in about 2 days
+	AlertBurnRate float64
+}
+
+// ConfSyncProvider provides when the conf was last synced, such as a ConfStore
+type ConfSyncProvider interface {
+	SyncedAt() time.Time
+}
+
+// SLOStatus is the state of an SLO's error budget
+type SLOStatus struct {
+	Name          string  `json:"name"`
+	Objective     float64 `json:"objective"`
+	ShortBurnRate float64 `json:"short_burn_rate"`
+	LongBurnRate  float64 `json:"long_burn_rate"`
+	Alerting      bool    `json:"alerting"`
+}
+
+// SLOAlerter notifies someone of an SLO that started or stopped alerting
+type SLOAlerter interface {
+	Alert(context context.Context, status SLOStatus) error
+}
+
+// NewSLOTracker creates a new SLOTracker. conf may be nil if conf staleness isn't tracked, and alerter may be nil if
+// alerts are only reported as metrics.
+func NewSLOTracker(objectives SLOObjectives, conf ConfSyncProvider, alerter SLOAlerter, clock Clock, logger logrus.FieldLogger, reporter MetricReporter) *SLOTracker {
+	t := &SLOTracker{
+		objectives: objectives,
+		conf:       conf,
+		alerter:    alerter,
+		clock:      clock,
+		logger:     logger,
+		reporter:   reporter,
+		started:    clock.Now(),
+		slis:       make(map[string]*sliWindow),
+		alerting:   make(map[string]bool),
+	}
+
+	if objectives.Latency > 0 {
+		t.slis[LatencySLO] = &sliWindow{}
+	}
+	if objectives.FailOpen > 0 {
+		t.slis[FailOpenSLO] = &sliWindow{}
+	}
+	if objectives.ConfFreshness > 0 && conf != nil {
+		t.slis[ConfStalenessSLO] = &sliWindow{}
+	}
+
+	return t
+}
+
+// SLOTracker tracks Guardian's own service level indicators, decision latency, decisions failing open, and conf
+// staleness, against their objectives. It reports how fast each error budget is burning, and alerts when it burns
+// fast enough to need attention, so teams without their own alerting on Guardian's metrics get sane defaults.
+type SLOTracker struct {
+	objectives SLOObjectives
+	conf       ConfSyncProvider
+	alerter    SLOAlerter
+	clock      Clock
+	logger     logrus.FieldLogger
+	reporter   MetricReporter
+	started    time.Time
+	slis       map[string]*sliWindow // not modified once created
+
+	mu       sync.Mutex
+	alerting map[string]bool
+}
+
+// sliWindow counts good and total events per minute over the last sloBuckets minutes. Events are recorded with
+// atomics rather than under a lock, as one is recorded for every decision.
+type sliWindow struct {
+	good    [sloBuckets]uint64
+	total   [sloBuckets]uint64
+	minutes [sloBuckets]int64 // the unix minute each count is for
+}
+
+// record counts an event at now. Events recorded while their minute's bucket is reset by another may be lost, which
+// the burn rates tolerate.
+func (w *sliWindow) record(now time.Time, good bool) {
+	minute := now.Unix() / 60
+	i := minute % int64(sloBuckets)
+	if m := atomic.LoadInt64(&w.minutes[i]); m != minute && atomic.CompareAndSwapInt64(&w.minutes[i], m, minute) {
+		atomic.StoreUint64(&w.good[i], 0)
+		atomic.StoreUint64(&w.total[i], 0)
+	}
+
+	atomic.AddUint64(&w.total[i], 1)
+	if good {
+		atomic.AddUint64(&w.good[i], 1)
+	}
+}
+
+// badRatio returns the fraction of the events within window of now that were bad, 0 if there were none
+func (w *sliWindow) badRatio(now time.Time, window time.Duration) float64 {
+	minute := now.Unix() / 60
+	oldest := minute - int64(window/time.Minute)
+
+	good, total := uint64(0), uint64(0)
+	for i := range w.minutes {
+		if m := atomic.LoadInt64(&w.minutes[i]); m > oldest && m <= minute {
+			good += atomic.LoadUint64(&w.good[i])
+			total += atomic.LoadUint64(&w.total[i])
+		}
+	}
+
+	if good > total {
+		good = total // a bucket being reset or recorded to while it was read
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return float64(total-good) / float64(total)
+}
+
+// TrackSLOs wraps f, recording the latency of each decision it makes in t, and whether an error failed it open, be it
+// returned by f or swallowed by a limiter and recorded to the request's DecisionHint
+func TrackSLOs(f RequestBlockerFunc, t *SLOTracker) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		hint := DecisionHintFromContext(c)
+		if hint == nil {
+			hint = NewDecisionHint()
+			c = WithDecisionHint(c, hint)
+		}
+
+		start := time.Now()
+		blocked, remaining, err := f(c, r)
+		t.RecordDecision(time.Since(start), err != nil || hint.ErrorSwallowed())
+		return blocked, remaining, err
+	}
+}
+
+// RecordDecision records a decision that took duration, and whether an error failed it open
+func (t *SLOTracker) RecordDecision(duration time.Duration, errorOccurred bool) {
+	now := t.clock.Now()
+
+	if sli, ok := t.slis[LatencySLO]; ok {
+		sli.record(now, duration <= t.objectives.LatencyThreshold)
+	}
+	if sli, ok := t.slis[FailOpenSLO]; ok {
+		sli.record(now, !errorOccurred)
+	}
+}
+
+// Run evaluates the SLOs every interval until stop is closed
+func (t *SLOTracker) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.Evaluate()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Evaluate samples the conf staleness, then reports the burn rates of the SLOs and alerts on those that started or
+// stopped alerting
+func (t *SLOTracker) Evaluate() {
+	t.sampleConf()
+
+	for _, status := range t.Statuses() {
+		t.reporter.SLOBurnRate(status.Name, sloShortWindow, status.ShortBurnRate)
+		t.reporter.SLOBurnRate(status.Name, sloLongWindow, status.LongBurnRate)
+		t.reporter.SLOAlerting(status.Name, status.Alerting)
+
+		t.mu.Lock()
+		changed := t.alerting[status.Name] != status.Alerting
+		t.alerting[status.Name] = status.Alerting
+		t.mu.Unlock()
+
+		if changed {
+			t.alert(status)
+		}
+	}
+}
+
+// sampleConf records whether the conf was synced within its max age. Until the first sync, the conf's age is
+// counted from when the tracker was created.
+func (t *SLOTracker) sampleConf() {
+	now := t.clock.Now()
+
+	sli, ok := t.slis[ConfStalenessSLO]
+	if !ok {
+		return
+	}
+
+	syncedAt := t.conf.SyncedAt()
+	if syncedAt.IsZero() {
+		syncedAt = t.started
+	}
+
+	sli.record(now, now.Sub(syncedAt) <= t.objectives.ConfMaxAge)
+}
+
+// Statuses returns the state of the error budget of each SLO tracked, ordered by name
+func (t *SLOTracker) Statuses() []SLOStatus {
+	now := t.clock.Now()
+
+	statuses := []SLOStatus{}
+	for _, name := range []string{ConfStalenessSLO, FailOpenSLO, LatencySLO} {
+		sli, ok := t.slis[name]
+		if !ok {
+			continue
+		}
+
+		objective := t.objective(name)
+		status := SLOStatus{
+			Name:          name,
+			Objective:     objective,
+			ShortBurnRate: burnRate(sli.badRatio(now, sloShortWindow), objective),
+			LongBurnRate:  burnRate(sli.badRatio(now, sloLongWindow), objective),
+		}
+		status.Alerting = t.objectives.AlertBurnRate > 0 && status.ShortBurnRate >= t.objectives.AlertBurnRate && status.LongBurnRate >= t.objectives.AlertBurnRate
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+func (t *SLOTracker) objective(name string) float64 {
+	switch name {
+	case LatencySLO:
+		return t.objectives.Latency
+	case FailOpenSLO:
+		return t.objectives.FailOpen
+	default:
+		return t.objectives.ConfFreshness
+	}
+}
+
+func (t *SLOTracker) alert(status SLOStatus) {
+	logger := t.logger.WithField("slo", status.Name).WithField("short_burn_rate", status.ShortBurnRate).WithField("long_burn_rate", status.LongBurnRate)
+	if status.Alerting {
+		logger.Warnf("slo %v is burning its error budget too fast", status.Name)
+	} else {
+		logger.Infof("slo %v is no longer burning its error budget too fast", status.Name)
+	}
+
+	if t.alerter == nil {
+		return
+	}
+
+	if err := t.alerter.Alert(context.Background(), status); err != nil {
+		logger.WithError(err).Error("error sending slo alert")
+	}
+}
+
+// burnRate returns how many times faster than allowed by objective an error budget burns at badRatio
+func burnRate(badRatio float64, objective float64) float64 {
+	if objective >= 1 {
+		return 0
+	}
+
+	return badRatio / (1 - objective)
+}
+
+// NewWebhookSLOAlerter creates a new WebhookSLOAlerter
+func NewWebhookSLOAlerter(endpoint string, client *http.Client) *WebhookSLOAlerter {
+	return &WebhookSLOAlerter{endpoint: endpoint, client: client}
+}
+
+// WebhookSLOAlerter alerts by posting a JSON SLOStatus to an endpoint, along with a text summary that chat incoming
+// webhooks such as Slack's display as the message
+type WebhookSLOAlerter struct {
+	endpoint string
+	client   *http.Client
+}
+
+type sloAlertBody struct {
+	SLOStatus
+	Text string `json:"text"`
+}
+
+func (w *WebhookSLOAlerter) Alert(context context.Context, status SLOStatus) error {
+	body, err := json.Marshal(sloAlertBody{SLOStatus: status, Text: sloAlertText(status)})
+	if err != nil {
+		return errors.Wrap(err, "error encoding slo alert")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "error creating slo alert request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := w.client.Do(req.WithContext(context))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error sending slo alert for %v", status.Name))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d sending slo alert for %v", res.StatusCode, status.Name)
+	}
+
+	return nil
+}
+
+func sloAlertText(status SLOStatus) string {
+	if !status.Alerting {
+		return fmt.Sprintf("Guardian SLO %v resolved: error budget burning %.1fx over %v and %.1fx over %v", status.Name, status.ShortBurnRate, sloWindowTag(sloShortWindow), status.LongBurnRate, sloWindowTag(sloLongWindow))
+	}
+
+	return fmt.Sprintf("Guardian SLO %v alerting: error budget burning %.1fx over %v and %.1fx over %v, objective %v", status.Name, status.ShortBurnRate, sloWindowTag(sloShortWindow), status.LongBurnRate, sloWindowTag(sloLongWindow), status.Objective)
+}
+
+// sloWindowTag formats window for tags, e.g. 5m or 1h
+func sloWindowTag(window time.Duration) string {
+	if window%time.Hour == 0 {
+		return fmt.Sprintf("%dh", window/time.Hour)
+	}
+
+	return fmt.Sprintf("%dm", window/time.Minute)
+}
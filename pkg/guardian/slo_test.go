@@ -0,0 +1,151 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeConfSync struct {
+	syncedAt time.Time
+}
+
+func (f *fakeConfSync) SyncedAt() time.Time {
+	return f.syncedAt
+}
+
+type fakeSLOAlerter struct {
+	alerts []SLOStatus
+}
+
+func (f *fakeSLOAlerter) Alert(context context.Context, status SLOStatus) error {
+	f.alerts = append(f.alerts, status)
+	return nil
+}
+
+func TestSLOTracker(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 15, 0, time.UTC)}
+	conf := &fakeConfSync{syncedAt: clock.now}
+	alerter := &fakeSLOAlerter{}
+	objectives := SLOObjectives{
+		LatencyThreshold: 10 * time.Millisecond,
+		Latency:          0.9,
+		FailOpen:         0.999,
+		ConfMaxAge:       time.Minute,
+		ConfFreshness:    0.99,
+		AlertBurnRate:    14.4,
+	}
+	tracker := NewSLOTracker(objectives, conf, alerter, clock, TestingLogger, NullReporter{})
+
+	for i := 0; i < 100; i++ {
+		duration := time.Millisecond
+		if i%20 == 0 {
+			duration = time.Second
+		}
+		tracker.RecordDecision(duration, i%10 == 0)
+	}
+	tracker.Evaluate()
+
+	want := map[string]float64{ConfStalenessSLO: 0, FailOpenSLO: 100, LatencySLO: 0.5}
+	statuses := tracker.Statuses()
+	if len(statuses) != len(want) {
+		t.Fatalf("expected %d slos received: %v", len(want), statuses)
+	}
+
+	for _, status := range statuses {
+		if !floatEquals(status.ShortBurnRate, want[status.Name]) || !floatEquals(status.LongBurnRate, want[status.Name]) {
+			t.Errorf("expected burn rate of %v: %v received: %v", status.Name, want[status.Name], status)
+		}
+
+		if status.Alerting != (status.Name == FailOpenSLO) {
+			t.Errorf("unexpected alerting: %v", status)
+		}
+	}
+
+	if len(alerter.alerts) != 1 || alerter.alerts[0].Name != FailOpenSLO || !alerter.alerts[0].Alerting {
+		t.Fatalf("expected the fail open slo to alert, received: %v", alerter.alerts)
+	}
+
+	// once the errors age out of the short window the alert resolves, even though they still burn the long window
+	clock.now = clock.now.Add(10 * time.Minute)
+	conf.syncedAt = clock.now
+	tracker.RecordDecision(time.Millisecond, false)
+	tracker.Evaluate()
+
+	if len(alerter.alerts) != 2 || alerter.alerts[1].Name != FailOpenSLO || alerter.alerts[1].Alerting {
+		t.Fatalf("expected the fail open slo to resolve, received: %v", alerter.alerts)
+	}
+}
+
+func TestTrackSLOsSwallowedErrors(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 15, 0, time.UTC)}
+	tracker := NewSLOTracker(SLOObjectives{FailOpen: 0.9}, nil, nil, clock, TestingLogger, NullReporter{})
+
+	swallowed := false
+	f := TrackSLOs(func(c context.Context, r Request) (bool, uint32, error) {
+		if swallowed {
+			hintErrorSwallowed(c)
+		}
+		return false, RequestsRemainingMax, nil
+	}, tracker)
+
+	f(context.Background(), Request{})
+	swallowed = true
+	f(context.Background(), Request{})
+
+	statuses := tracker.Statuses()
+	if len(statuses) != 1 || !floatEquals(statuses[0].ShortBurnRate, 5) {
+		t.Fatalf("expected a fail open burn rate of 5, received: %v", statuses)
+	}
+}
+
+func TestSLOTrackerConfStaleness(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 15, 0, time.UTC)}
+	conf := &fakeConfSync{}
+	tracker := NewSLOTracker(SLOObjectives{ConfMaxAge: time.Minute, ConfFreshness: 0.9}, conf, nil, clock, TestingLogger, NullReporter{})
+
+	// the conf isn't stale until it's gone unsynced for its max age since the tracker started
+	tracker.Evaluate()
+	clock.now = clock.now.Add(2 * time.Minute)
+	tracker.Evaluate()
+
+	statuses := tracker.Statuses()
+	if len(statuses) != 1 || !floatEquals(statuses[0].ShortBurnRate, 5) {
+		t.Fatalf("expected a conf staleness burn rate of 5, received: %v", statuses)
+	}
+}
+
+func TestWebhookSLOAlerter(t *testing.T) {
+	received := sloAlertBody{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("error decoding alert: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	status := SLOStatus{Name: LatencySLO, Objective: 0.99, ShortBurnRate: 20, LongBurnRate: 15, Alerting: true}
+	if err := NewWebhookSLOAlerter(srv.URL, srv.Client()).Alert(context.Background(), status); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if received.SLOStatus != status || len(received.Text) == 0 {
+		t.Fatalf("expected alert of %v received: %v", status, received)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	if err := NewWebhookSLOAlerter(failing.URL, failing.Client()).Alert(context.Background(), status); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+}
+
+func floatEquals(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}
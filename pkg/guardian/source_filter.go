@@ -0,0 +1,65 @@
+package guardian
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewSourceFilter creates a new SourceFilter. An empty allow list allows every source that isn't denied.
+func NewSourceFilter(handler http.Handler, allow []net.IPNet, deny []net.IPNet, logger logrus.FieldLogger) *SourceFilter {
+	return &SourceFilter{handler: handler, allow: allow, deny: deny, logger: logger}
+}
+
+// SourceFilter is an http.Handler that only serves requests whose source address is allowed, for restricting
+// access to listeners such as the admin API that shouldn't be reachable from everywhere their port is exposed.
+// The connection's address is used and forwarding headers are ignored, since they're set by the client.
+type SourceFilter struct {
+	handler http.Handler
+	allow   []net.IPNet
+	deny    []net.IPNet
+	logger  logrus.FieldLogger
+}
+
+func (f *SourceFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.Allowed(r.RemoteAddr) {
+		f.logger.Warnf("rejected request %v %v from %v", r.Method, r.URL, r.RemoteAddr)
+		writeJSONError(w, http.StatusForbidden, fmt.Errorf("forbidden"), f.logger)
+		return
+	}
+
+	f.handler.ServeHTTP(w, r)
+}
+
+// Allowed returns whether a request from remoteAddr, an IP or host:port, is allowed
+func (f *SourceFilter) Allowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, cidr := range f.deny {
+		if cidr.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(f.allow) == 0 {
+		return true
+	}
+
+	for _, cidr := range f.allow {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
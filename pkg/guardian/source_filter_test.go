@@ -0,0 +1,64 @@
+package guardian
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSourceFilterAllowed(t *testing.T) {
+	allow := parseCIDRs([]string{"10.0.0.0/8", "127.0.0.1/32"})
+	deny := parseCIDRs([]string{"10.1.0.0/16"})
+	f := NewSourceFilter(http.NotFoundHandler(), allow, deny, TestingLogger)
+
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{remoteAddr: "10.0.0.1:1234", want: true},
+		{remoteAddr: "127.0.0.1:1234", want: true},
+		{remoteAddr: "10.1.0.1:1234", want: false},
+		{remoteAddr: "192.168.1.1:1234", want: false},
+		{remoteAddr: "10.0.0.1", want: true},
+		{remoteAddr: "not-an-ip", want: false},
+	}
+
+	for _, test := range tests {
+		if got := f.Allowed(test.remoteAddr); got != test.want {
+			t.Errorf("%v expected: %v received: %v", test.remoteAddr, test.want, got)
+		}
+	}
+}
+
+func TestSourceFilterEmptyAllowList(t *testing.T) {
+	f := NewSourceFilter(http.NotFoundHandler(), nil, parseCIDRs([]string{"10.0.0.0/8"}), TestingLogger)
+
+	if !f.Allowed("192.168.1.1:1234") {
+		t.Error("expected source outside the deny list to be allowed")
+	}
+
+	if f.Allowed("10.0.0.1:1234") {
+		t.Error("expected denied source to be rejected")
+	}
+}
+
+func TestSourceFilterServeHTTP(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	f := NewSourceFilter(handler, parseCIDRs([]string{"10.0.0.0/8"}), nil, TestingLogger)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/counters", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected: %v received: %v", http.StatusForbidden, w.Code)
+	}
+
+	req.RemoteAddr = "10.0.0.1:1234"
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected: %v received: %v", http.StatusNoContent, w.Code)
+	}
+}
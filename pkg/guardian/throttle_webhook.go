@@ -0,0 +1,306 @@
+package guardian
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const throttleNotifiedNamespace = "throttle_notified"
+
+// maxPendingThrottles bounds the keys queued between flushes, so an attack from many addresses can't grow the queue
+// without bound. It is split evenly among the shards keys are queued in, and keys throttled beyond it are notified in a
+// later flush if they're still throttled then.
+const maxPendingThrottles = 1000
+
+// ThrottleNotification is a key throttled by a rule notifying of its throttles
+type ThrottleNotification struct {
+	Rule string `json:"rule"`
+	// LimitKey is the request attribute the rule counts by, such as header.x-api-key, or remote_address
+	LimitKey       string    `json:"limit_key"`
+	Key            string    `json:"key"`
+	FirstBlockedAt time.Time `json:"first_blocked_at"`
+	// Blocked is the number of requests of the key blocked by the rule since it was queued
+	Blocked uint64 `json:"blocked"`
+}
+
+type throttlePair struct {
+	rule string
+	key  string
+}
+
+// NewThrottleNotifier creates a new ThrottleNotifier posting to endpoint. Keys are notified at most once per dedup
+// across the instances sharing redis, and not at all while reportOnly puts requests in report only mode.
+func NewThrottleNotifier(endpoint string, client *http.Client, redis *redis.Client, reportOnly ReportOnlyProvider, dedup time.Duration, clock Clock, logger logrus.FieldLogger, reporter MetricReporter) *ThrottleNotifier {
+	n := &ThrottleNotifier{
+		endpoint:   endpoint,
+		client:     client,
+		redis:      redis,
+		reportOnly: reportOnly,
+		dedup:      dedup,
+		clock:      clock,
+		logger:     logger,
+		reporter:   reporter,
+	}
+	for i := range n.shards {
+		n.shards[i].pending = make(map[throttlePair]*ThrottleNotification)
+		n.shards[i].notified = make(map[throttlePair]time.Time)
+	}
+
+	return n
+}
+
+// ThrottleNotifier posts the keys throttled by rules with Notify set to a webhook, so customers being throttled can be
+// reached out to. Throttles are queued locally and posted in batches, so recording never waits on Redis or the
+// webhook. Throttles are queued in the shard of their key.
+type ThrottleNotifier struct {
+	endpoint   string
+	client     *http.Client
+	redis      *redis.Client
+	reportOnly ReportOnlyProvider
+	dedup      time.Duration
+	clock      Clock
+	logger     logrus.FieldLogger
+	reporter   MetricReporter
+	shards     [recordShards]throttleShard
+}
+
+type throttleShard struct {
+	mu       sync.Mutex
+	pending  map[throttlePair]*ThrottleNotification
+	notified map[throttlePair]time.Time // pairs notified by this instance, to when they may be notified again
+}
+
+type throttleWebhookBody struct {
+	Notifications []ThrottleNotification `json:"notifications"`
+}
+
+// NotifyThrottles wraps f, recording each request it blocks to n along with the rule that decided it
+func NotifyThrottles(f RequestBlockerFunc, n *ThrottleNotifier) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		blocked, remaining, err := f(c, r)
+		if rule := DecisionHintFromContext(c).MatchedRule(); blocked && rule != nil {
+			n.Record(r, *rule)
+		}
+		return blocked, remaining, err
+	}
+}
+
+// Record queues a notification that rule blocked the key of request, unless the rule doesn't notify, the request
+// is in report only mode and wasn't throttled, or the key was notified within the dedup period
+func (n *ThrottleNotifier) Record(request Request, rule Rule) {
+	if !rule.Notify || reportOnlyForRequest(n.reportOnly, request) {
+		return
+	}
+
+	notification := ThrottleNotification{Rule: rule.Name, LimitKey: remoteAddressDescriptor, Key: request.RemoteAddress}
+	if value := rule.limitKeyValue(request); value != request.RemoteAddress {
+		notification.LimitKey = rule.LimitKey
+		notification.Key = strings.TrimPrefix(value, rule.LimitKey+"=")
+	}
+
+	pair := throttlePair{rule: notification.Rule, key: notification.Key}
+	now := n.clock.Now()
+
+	shard := n.shard(pair)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if until, ok := shard.notified[pair]; ok && now.Before(until) {
+		return
+	}
+
+	queued, ok := shard.pending[pair]
+	if !ok {
+		if len(shard.pending) >= maxPendingThrottles/recordShards {
+			return
+		}
+
+		notification.FirstBlockedAt = now.UTC()
+		queued = &notification
+		shard.pending[pair] = queued
+	}
+	queued.Blocked++
+}
+
+// Run flushes the queued notifications every interval until stop is closed
+func (n *ThrottleNotifier) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := n.Flush(context.Background()); err != nil {
+				n.logger.WithError(err).Error("error flushing throttle notifications")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Flush posts the queued notifications of the keys no instance has notified within the dedup period. Notifications
+// that fail to post are queued again for the next flush, while those of keys notified by other instances are dropped.
+func (n *ThrottleNotifier) Flush(context context.Context) error {
+	now := n.clock.Now()
+
+	pending := map[throttlePair]*ThrottleNotification{}
+	for i := range n.shards {
+		shard := &n.shards[i]
+		shard.mu.Lock()
+		for pair, notification := range shard.pending {
+			pending[pair] = notification
+		}
+		shard.pending = make(map[throttlePair]*ThrottleNotification)
+		for pair, until := range shard.notified {
+			if !now.Before(until) {
+				delete(shard.notified, pair)
+			}
+		}
+		shard.mu.Unlock()
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	claimed := n.claim(pending)
+	var err error
+	if len(claimed) > 0 {
+		err = n.post(context, claimed, pending)
+		n.reporter.ThrottleWebhook(len(claimed), err != nil)
+	}
+
+	if err != nil {
+		n.release(claimed)
+	}
+
+	for pair := range pending {
+		shard := n.shard(pair)
+		shard.mu.Lock()
+		shard.notified[pair] = now.Add(n.dedup)
+		shard.mu.Unlock()
+	}
+
+	if err != nil {
+		n.requeue(claimed, pending)
+	}
+
+	return err
+}
+
+// claim returns the pairs of pending no instance has notified within the dedup period, claiming them in Redis so
+// other instances don't notify them too. If Redis fails, every pair is claimed, as a duplicate notification is
+// better than a missing one.
+func (n *ThrottleNotifier) claim(pending map[throttlePair]*ThrottleNotification) []throttlePair {
+	pairs := make([]throttlePair, 0, len(pending))
+	for pair := range pending {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].rule != pairs[j].rule {
+			return pairs[i].rule < pairs[j].rule
+		}
+		return pairs[i].key < pairs[j].key
+	})
+
+	pipe := n.redis.Pipeline()
+	cmds := make([]*redis.BoolCmd, 0, len(pairs))
+	for _, pair := range pairs {
+		cmds = append(cmds, pipe.SetNX(n.notifiedKey(pair), "true", n.dedup))
+	}
+
+	n.logger.Debugf("Sending pipeline of %d SETNX to claim throttle notifications", len(pairs))
+	if _, err := pipe.Exec(); err != nil {
+		n.logger.WithError(err).Error("error claiming throttle notifications, notifying all of them")
+		return pairs
+	}
+
+	claimed := []throttlePair{}
+	for i, cmd := range cmds {
+		if cmd.Val() {
+			claimed = append(claimed, pairs[i])
+		}
+	}
+
+	return claimed
+}
+
+// release removes the claims of pairs, so they can be notified again
+func (n *ThrottleNotifier) release(pairs []throttlePair) {
+	keys := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		keys = append(keys, n.notifiedKey(pair))
+	}
+
+	if err := n.redis.Del(keys...).Err(); err != nil {
+		n.logger.WithError(err).Error("error releasing throttle notifications")
+	}
+}
+
+// requeue queues the notifications of pairs again, adding them to those queued since
+func (n *ThrottleNotifier) requeue(pairs []throttlePair, pending map[throttlePair]*ThrottleNotification) {
+	for _, pair := range pairs {
+		shard := n.shard(pair)
+		shard.mu.Lock()
+		delete(shard.notified, pair)
+
+		notification := pending[pair]
+		if queued, ok := shard.pending[pair]; ok {
+			notification.Blocked += queued.Blocked
+		}
+		shard.pending[pair] = notification
+		shard.mu.Unlock()
+	}
+}
+
+// shard returns the shard pair is queued in
+func (n *ThrottleNotifier) shard(pair throttlePair) *throttleShard {
+	return &n.shards[recordShard(pair.key)]
+}
+
+func (n *ThrottleNotifier) notifiedKey(pair throttlePair) string {
+	return NamespacedKey(throttleNotifiedNamespace, pair.rule+":"+pair.key)
+}
+
+// post posts the notifications of pairs as a single JSON body
+func (n *ThrottleNotifier) post(context context.Context, pairs []throttlePair, pending map[throttlePair]*ThrottleNotification) error {
+	body := throttleWebhookBody{}
+	for _, pair := range pairs {
+		body.Notifications = append(body.Notifications, *pending[pair])
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "error encoding throttle notifications")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "error creating throttle webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := n.client.Do(req.WithContext(context))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error posting %d throttle notifications", len(pairs)))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d posting %d throttle notifications", res.StatusCode, len(pairs))
+	}
+
+	return nil
+}
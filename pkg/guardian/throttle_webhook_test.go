@@ -0,0 +1,152 @@
+package guardian
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+	"github.com/google/go-cmp/cmp"
+)
+
+type throttleWebhookRecorder struct {
+	mu     sync.Mutex
+	status int
+	bodies []throttleWebhookBody
+}
+
+func (rec *throttleWebhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	body := throttleWebhookBody{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err == nil {
+		rec.bodies = append(rec.bodies, body)
+	}
+	w.WriteHeader(rec.status)
+}
+
+func newTestThrottleNotifier(t *testing.T, endpoint string, reportOnly bool, clock Clock) (*ThrottleNotifier, *miniredis.Miniredis) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("error creating miniredis: %v", err)
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	n := NewThrottleNotifier(endpoint, http.DefaultClient, client, StaticReportOnlyProvider{reportOnly: reportOnly}, time.Hour, clock, TestingLogger, NullReporter{})
+	return n, s
+}
+
+func TestThrottleNotifierBatchesAndDeduplicates(t *testing.T) {
+	rec := &throttleWebhookRecorder{status: http.StatusOK}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	now := time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)
+	clock := &fixedClock{now: now}
+	n, s := newTestThrottleNotifier(t, srv.URL, false, clock)
+	defer s.Close()
+
+	rule := mustParseRule(t, "api-keys", RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Count: 10, Duration: "1m", Enabled: true}, LimitKey: "header.x-api-key", Notify: true})
+	quiet := mustParseRule(t, "quiet", RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Count: 10, Duration: "1m", Enabled: true}})
+	keyed := Request{RemoteAddress: "192.168.1.2", Headers: map[string]string{"x-api-key": "customer-1"}}
+	unkeyed := Request{RemoteAddress: "192.168.1.3"}
+
+	n.Record(keyed, rule)
+	n.Record(keyed, rule)
+	n.Record(unkeyed, rule)
+	n.Record(keyed, quiet)
+	if err := n.Flush(context.Background()); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	expected := []throttleWebhookBody{{Notifications: []ThrottleNotification{
+		{Rule: "api-keys", LimitKey: "remote_address", Key: "192.168.1.3", FirstBlockedAt: now, Blocked: 1},
+		{Rule: "api-keys", LimitKey: "header.x-api-key", Key: "customer-1", FirstBlockedAt: now, Blocked: 2},
+	}}}
+	if diff := cmp.Diff(expected, rec.bodies); diff != "" {
+		t.Fatalf("unexpected webhook bodies: %v", diff)
+	}
+
+	// another instance sharing redis doesn't notify the same keys within the hour
+	other := NewThrottleNotifier(srv.URL, http.DefaultClient, n.redis, StaticReportOnlyProvider{}, time.Hour, clock, TestingLogger, NullReporter{})
+	n.Record(keyed, rule)
+	other.Record(keyed, rule)
+	if err := n.Flush(context.Background()); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if err := other.Flush(context.Background()); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if len(rec.bodies) != 1 {
+		t.Fatalf("expected keys to be notified once per hour, received: %v", rec.bodies)
+	}
+
+	clock.now = now.Add(time.Hour)
+	s.FastForward(time.Hour)
+	n.Record(keyed, rule)
+	if err := n.Flush(context.Background()); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if len(rec.bodies) != 2 {
+		t.Fatalf("expected key to be notified again after an hour, received: %v", rec.bodies)
+	}
+}
+
+func TestThrottleNotifierRetriesFailedPosts(t *testing.T) {
+	rec := &throttleWebhookRecorder{status: http.StatusInternalServerError}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	n, s := newTestThrottleNotifier(t, srv.URL, false, clock)
+	defer s.Close()
+
+	rule := mustParseRule(t, "api-keys", RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Count: 10, Duration: "1m", Enabled: true}, Notify: true})
+	n.Record(Request{RemoteAddress: "192.168.1.2"}, rule)
+	if err := n.Flush(context.Background()); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+
+	rec.status = http.StatusOK
+	n.Record(Request{RemoteAddress: "192.168.1.2"}, rule)
+	if err := n.Flush(context.Background()); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	last := rec.bodies[len(rec.bodies)-1]
+	if len(last.Notifications) != 1 || last.Notifications[0].Blocked != 2 {
+		t.Fatalf("expected failed notification to be retried with both blocks, received: %v", last)
+	}
+}
+
+func TestThrottleNotifierSkipsReportOnly(t *testing.T) {
+	rec := &throttleWebhookRecorder{status: http.StatusOK}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n, s := newTestThrottleNotifier(t, srv.URL, true, &fixedClock{now: time.Now()})
+	defer s.Close()
+
+	rule := mustParseRule(t, "api-keys", RuleDocument{When: `true`, Action: "limit", Limit: &LimitDocument{Count: 10, Duration: "1m", Enabled: true}, Notify: true})
+	n.Record(Request{RemoteAddress: "192.168.1.2"}, rule)
+	if err := n.Flush(context.Background()); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(rec.bodies) != 0 {
+		t.Fatalf("expected no notifications in report only mode, received: %v", rec.bodies)
+	}
+}
+
+func TestRuleDocumentRejectsNotifyWithoutLimit(t *testing.T) {
+	doc := RuleDocument{When: `true`, Action: "observe", Limit: &LimitDocument{Count: 10, Duration: "1m", Enabled: true}, Notify: true}
+	if _, err := doc.Rule("observed"); err == nil {
+		t.Fatal("expected error but received nil")
+	}
+}
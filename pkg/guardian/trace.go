@@ -0,0 +1,72 @@
+package guardian
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DebugHeader is the header that requests a decision trace when it carries the configured debug token. Envoy
+// must send it as the "header.x-guardian-debug" descriptor.
+const DebugHeader = "x-guardian-debug"
+
+type decisionTraceKey struct{}
+
+// DecisionTrace records the steps taken deciding a single request, along with when each was taken
+type DecisionTrace struct {
+	mu    sync.Mutex
+	start time.Time
+	steps []string
+}
+
+// NewDecisionTrace creates a new DecisionTrace starting now
+func NewDecisionTrace() *DecisionTrace {
+	return &DecisionTrace{start: time.Now()}
+}
+
+// Tracef records a step. Recording a step on a nil trace does nothing, so callers needn't check whether the
+// request is being traced.
+func (t *DecisionTrace) Tracef(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.steps = append(t.steps, fmt.Sprintf("+%v ", time.Since(t.start))+fmt.Sprintf(format, args...))
+}
+
+// Steps returns the recorded steps in the order they were taken
+func (t *DecisionTrace) Steps() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]string{}, t.steps...)
+}
+
+// WithDecisionTrace returns a copy of ctx that steps deciding a request are recorded to trace with
+func WithDecisionTrace(ctx context.Context, trace *DecisionTrace) context.Context {
+	return context.WithValue(ctx, decisionTraceKey{}, trace)
+}
+
+// DecisionTraceFromContext returns the trace of ctx, or nil if the request isn't being traced
+func DecisionTraceFromContext(ctx context.Context) *DecisionTrace {
+	trace, _ := ctx.Value(decisionTraceKey{}).(*DecisionTrace)
+	return trace
+}
+
+// tracef records a step to the trace of ctx, if any
+func tracef(ctx context.Context, format string, args ...interface{}) {
+	DecisionTraceFromContext(ctx).Tracef(format, args...)
+}
+
+// debugRequested returns whether req carries token in its DebugHeader. An empty token disables tracing.
+func debugRequested(req Request, token string) bool {
+	if len(token) == 0 {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(req.Headers[DebugHeader]), []byte(token)) == 1
+}
@@ -0,0 +1,254 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// trafficProfileMaxWindows is the number of completed windows of each route kept in its profile
+const trafficProfileMaxWindows = 60
+
+// trafficProfileMaxKeys is the number of distinct keys counted per route in a window, bounding memory under attack.
+// It is split evenly among the shards keys are counted in, and routes reaching it report it as their unique keys.
+const trafficProfileMaxKeys = 100000
+
+// NewTrafficProfiler creates a new TrafficProfiler counting requests in windows of window
+func NewTrafficProfiler(routes RouteLimitProvider, clock Clock, window time.Duration, reporter MetricReporter) *TrafficProfiler {
+	p := &TrafficProfiler{
+		routes:   routes,
+		clock:    clock,
+		window:   window,
+		reporter: reporter,
+		history:  make(map[string][]TrafficWindow),
+	}
+	for i := range p.shards {
+		p.shards[i].current = make(map[string]*trafficWindow)
+	}
+
+	return p
+}
+
+// TrafficProfiler tracks the shape of traffic, overall and for each route with a limit, so Redis can be sized and
+// limits chosen from real traffic. Each window it counts the requests and the unique keys, the remote addresses
+// counted by the global limit, of each route. Completed windows are reported as metrics and kept for the profile.
+// Requests are counted in the shard of their key, so keys are unique to a shard and the unique keys of a route are the
+// sum of those of its shards.
+type TrafficProfiler struct {
+	windowStart int64 // accessed atomically, first so it is 64 bit aligned on 32 bit platforms
+
+	routes   RouteLimitProvider
+	clock    Clock
+	window   time.Duration
+	reporter MetricReporter
+	shards   [recordShards]trafficShard
+
+	mu      sync.Mutex                 // guards rolling over windows
+	history map[string][]TrafficWindow // route to its last completed windows, oldest first
+}
+
+type trafficShard struct {
+	mu      sync.Mutex
+	current map[string]*trafficWindow // route to its counts in the current window
+}
+
+type trafficWindow struct {
+	requests uint64
+	keys     map[string]struct{}
+}
+
+// TrafficWindow is the traffic of a route in a completed window
+type TrafficWindow struct {
+	Start      time.Time `json:"start"`
+	Requests   uint64    `json:"requests"`
+	UniqueKeys uint64    `json:"unique_keys"`
+}
+
+// TrafficProfile is the traffic of a route, or of all requests if Route is "*", over its last completed windows with
+// requests
+type TrafficProfile struct {
+	Route         string          `json:"route"`
+	Duration      string          `json:"duration"`
+	RequestsP50   uint64          `json:"requests_p50"`
+	RequestsP99   uint64          `json:"requests_p99"`
+	RequestsMax   uint64          `json:"requests_max"`
+	UniqueKeysP50 uint64          `json:"unique_keys_p50"`
+	UniqueKeysMax uint64          `json:"unique_keys_max"`
+	Windows       []TrafficWindow `json:"windows"`
+}
+
+// Record counts request towards the current window of its routes
+func (p *TrafficProfiler) Record(request Request) {
+	routes := []string{globalRoute}
+	if routeLimit, ok := p.routes.GetRouteMatcher().MatchRequest(request); ok {
+		routes = append(routes, routeLimit.Route.String())
+	}
+
+	if p.windowOf(p.clock.Now()) != atomic.LoadInt64(&p.windowStart) {
+		p.mu.Lock()
+		p.rollover()
+		p.mu.Unlock()
+	}
+
+	shard := &p.shards[recordShard(request.RemoteAddress)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	for _, route := range routes {
+		tw, ok := shard.current[route]
+		if !ok {
+			tw = &trafficWindow{keys: make(map[string]struct{})}
+			shard.current[route] = tw
+		}
+
+		tw.requests += uint64(request.Hits())
+		if len(tw.keys) < trafficProfileMaxKeys/recordShards {
+			tw.keys[request.RemoteAddress] = struct{}{}
+		}
+	}
+}
+
+// Run reports each window as it ends, even when no requests are recorded after it, until stop is closed
+func (p *TrafficProfiler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.window)
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			p.rollover()
+			p.mu.Unlock()
+		case <-stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// windowOf returns the start of the window of now, in nanoseconds
+func (p *TrafficProfiler) windowOf(now time.Time) int64 {
+	return now.UnixNano() / int64(p.window) * int64(p.window)
+}
+
+// rollover adds the counts of the current window to the history, and reports them, once it has ended. It must be
+// called with mu held.
+func (p *TrafficProfiler) rollover() {
+	windowStart := p.windowOf(p.clock.Now())
+	previousStart := atomic.LoadInt64(&p.windowStart)
+	if windowStart == previousStart {
+		return
+	}
+
+	counts := map[string]*TrafficWindow{}
+	for i := range p.shards {
+		shard := &p.shards[i]
+		shard.mu.Lock()
+		current := shard.current
+		shard.current = make(map[string]*trafficWindow)
+		shard.mu.Unlock()
+
+		for route, tw := range current {
+			count, ok := counts[route]
+			if !ok {
+				count = &TrafficWindow{Start: time.Unix(0, previousStart).UTC()}
+				counts[route] = count
+			}
+			count.Requests += tw.requests
+			count.UniqueKeys += uint64(len(tw.keys))
+		}
+	}
+
+	for route, count := range counts {
+		completed := *count
+		history := append(p.history[route], completed)
+		if len(history) > trafficProfileMaxWindows {
+			history = history[len(history)-trafficProfileMaxWindows:]
+		}
+		p.history[route] = history
+
+		p.reporter.TrafficWindow(route, completed.Requests, completed.UniqueKeys)
+	}
+
+	atomic.StoreInt64(&p.windowStart, windowStart)
+}
+
+// Profiles returns the profile of each route observed in a completed window, sorted by route
+func (p *TrafficProfiler) Profiles() []TrafficProfile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rollover()
+	profiles := []TrafficProfile{}
+	for route, history := range p.history {
+		requests := make([]uint64, 0, len(history))
+		keys := make([]uint64, 0, len(history))
+		for _, tw := range history {
+			requests = append(requests, tw.Requests)
+			keys = append(keys, tw.UniqueKeys)
+		}
+		sort.Slice(requests, func(i, j int) bool { return requests[i] < requests[j] })
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+		profiles = append(profiles, TrafficProfile{
+			Route:         route,
+			Duration:      p.window.String(),
+			RequestsP50:   nearestRank(requests, 0.5),
+			RequestsP99:   nearestRank(requests, 0.99),
+			RequestsMax:   requests[len(requests)-1],
+			UniqueKeysP50: nearestRank(keys, 0.5),
+			UniqueKeysMax: keys[len(keys)-1],
+			Windows:       append([]TrafficWindow{}, history...),
+		})
+	}
+
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Route < profiles[j].Route })
+	return profiles
+}
+
+// nearestRank returns the nearest rank percentile of sorted, which must not be empty
+func nearestRank(sorted []uint64, percentile float64) uint64 {
+	rank := int(math.Ceil(percentile * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// ProfileTraffic wraps f, recording each request it decides in profiler
+func ProfileTraffic(f RequestBlockerFunc, profiler *TrafficProfiler) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		profiler.Record(r)
+		return f(c, r)
+	}
+}
+
+// NewTrafficProfileHandler creates a new TrafficProfileHandler
+func NewTrafficProfileHandler(profiler *TrafficProfiler, logger logrus.FieldLogger) *TrafficProfileHandler {
+	return &TrafficProfileHandler{profiler: profiler, logger: logger}
+}
+
+// TrafficProfileHandler is an admin HTTP handler dumping the profiles of a TrafficProfiler as JSON
+type TrafficProfileHandler struct {
+	profiler *TrafficProfiler
+	logger   logrus.FieldLogger
+}
+
+type trafficProfileResponse struct {
+	Profiles []TrafficProfile `json:"profiles"`
+}
+
+func (h *TrafficProfileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method), h.logger)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, trafficProfileResponse{Profiles: h.profiler.Profiles()}, h.logger)
+}
@@ -0,0 +1,109 @@
+package guardian
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTrafficProfilerProfiles(t *testing.T) {
+	limit := Limit{Count: 1, Duration: time.Second, Enabled: true}
+	routes := &FakeRouteLimitProvider{routeLimits: []RouteLimit{{Route: mustParseRoutePattern(t, "/users/{id}"), Limit: LimitOverrideFromLimit(limit)}}}
+	start := time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)
+	clock := &fixedClock{now: start}
+	profiler := NewTrafficProfiler(routes, clock, time.Minute, NullReporter{})
+
+	// 10 clients making 2 requests each, then 1 client making 4 requests to a route with a limit
+	for i := 0; i < 20; i++ {
+		profiler.Record(Request{RemoteAddress: fmt.Sprintf("10.0.0.%d", i%10), Path: "/"})
+	}
+	clock.now = clock.now.Add(time.Minute)
+	for i := 0; i < 4; i++ {
+		profiler.Record(Request{RemoteAddress: "192.168.1.2", Path: "/users/1"})
+	}
+
+	// windows without requests aren't profiled
+	clock.now = clock.now.Add(3 * time.Minute)
+	expected := []TrafficProfile{
+		{
+			Route: "*", Duration: "1m0s", RequestsP50: 4, RequestsP99: 20, RequestsMax: 20, UniqueKeysP50: 1, UniqueKeysMax: 10,
+			Windows: []TrafficWindow{
+				{Start: start, Requests: 20, UniqueKeys: 10},
+				{Start: start.Add(time.Minute), Requests: 4, UniqueKeys: 1},
+			},
+		},
+		{
+			Route: "/users/{id}", Duration: "1m0s", RequestsP50: 4, RequestsP99: 4, RequestsMax: 4, UniqueKeysP50: 1, UniqueKeysMax: 1,
+			Windows: []TrafficWindow{{Start: start.Add(time.Minute), Requests: 4, UniqueKeys: 1}},
+		},
+	}
+
+	if diff := cmp.Diff(expected, profiler.Profiles()); diff != "" {
+		t.Errorf("unexpected profiles (-want +got):\n%s", diff)
+	}
+}
+
+func TestTrafficProfilerKeepsLastWindows(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	profiler := NewTrafficProfiler(&FakeRouteLimitProvider{}, clock, time.Minute, NullReporter{})
+	for i := 0; i < trafficProfileMaxWindows+5; i++ {
+		profiler.Record(Request{RemoteAddress: "192.168.1.2", Path: "/"})
+		clock.now = clock.now.Add(time.Minute)
+	}
+
+	profiles := profiler.Profiles()
+	if len(profiles) != 1 || len(profiles[0].Windows) != trafficProfileMaxWindows {
+		t.Fatalf("expected 1 profile of %d windows, received: %v", trafficProfileMaxWindows, profiles)
+	}
+}
+
+func TestTrafficProfilerRecordsConcurrently(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	profiler := NewTrafficProfiler(&FakeRouteLimitProvider{}, clock, time.Minute, NullReporter{})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				profiler.Record(Request{RemoteAddress: fmt.Sprintf("10.0.0.%d", j%50), Path: "/"})
+			}
+		}()
+	}
+	wg.Wait()
+
+	clock.now = clock.now.Add(time.Minute)
+	profiles := profiler.Profiles()
+	if len(profiles) != 1 || profiles[0].RequestsMax != 800 || profiles[0].UniqueKeysMax != 50 {
+		t.Fatalf("expected 800 requests from 50 keys, received: %v", profiles)
+	}
+}
+
+func TestTrafficProfileHandler(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	profiler := NewTrafficProfiler(&FakeRouteLimitProvider{}, clock, time.Minute, NullReporter{})
+	profiler.Record(Request{RemoteAddress: "192.168.1.2", Path: "/"})
+	clock.now = clock.now.Add(time.Minute)
+
+	rec := httptest.NewRecorder()
+	NewTrafficProfileHandler(profiler, TestingLogger).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/traffic-profile", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %v received: %v", http.StatusOK, rec.Code)
+	}
+
+	res := trafficProfileResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if len(res.Profiles) != 1 || res.Profiles[0].Route != globalRoute || res.Profiles[0].RequestsMax != 1 {
+		t.Errorf("unexpected profiles: %v", res.Profiles)
+	}
+}
@@ -0,0 +1,186 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	envoy_api_v2_ratelimit "github.com/envoyproxy/go-control-plane/envoy/api/v2/ratelimit"
+	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+)
+
+// InvalidRequestAction is the action taken on rate limit requests a RequestValidator finds malformed
+type InvalidRequestAction string
+
+const (
+	// AllowInvalidRequestAction allows the request without evaluating or counting it
+	AllowInvalidRequestAction InvalidRequestAction = "allow"
+	// RejectInvalidRequestAction fails the request with an InvalidArgument error, leaving Envoy to apply its failure
+	// mode
+	RejectInvalidRequestAction InvalidRequestAction = "reject"
+	// TruncateInvalidRequestAction truncates descriptor values longer than RequestLimits.MaxValueLength and decides
+	// the request. It is taken on such requests whatever the configured action, as clients control the length of the
+	// headers forwarded as descriptors, and allowing their requests undecided would let them bypass every limit.
+	TruncateInvalidRequestAction InvalidRequestAction = "truncate"
+)
+
+// InvalidRequestReason is why a rate limit request is malformed, reported with each invalid request
+type InvalidRequestReason string
+
+const (
+	// NoDescriptorsReason is a request without descriptors
+	NoDescriptorsReason InvalidRequestReason = "no_descriptors"
+	// TooManyDescriptorsReason is a request with more descriptors than RequestLimits.MaxDescriptors
+	TooManyDescriptorsReason InvalidRequestReason = "too_many_descriptors"
+	// EmptyDescriptorReason is a request with a descriptor without entries
+	EmptyDescriptorReason InvalidRequestReason = "empty_descriptor"
+	// TooManyEntriesReason is a request with a descriptor of more entries than RequestLimits.MaxEntries
+	TooManyEntriesReason InvalidRequestReason = "too_many_entries"
+	// ValueTooLongReason is a request with a domain, or a descriptor key or value, longer than
+	// RequestLimits.MaxValueLength. Long descriptor values are truncated rather than failing the request.
+	ValueTooLongReason InvalidRequestReason = "value_too_long"
+	// HitsAddendTooLargeReason is a request with a hits addend larger than RequestLimits.MaxHitsAddend
+	HitsAddendTooLargeReason InvalidRequestReason = "hits_addend_too_large"
+	// MissingRemoteAddressReason is a request without a remote_address descriptor, which every limit counts by
+	MissingRemoteAddressReason InvalidRequestReason = "missing_remote_address"
+)
+
+// RequestLimits bound the rate limit requests a RequestValidator passes on. A zero limit is unbounded.
+type RequestLimits struct {
+	MaxDescriptors int
+	MaxEntries     int
+	MaxValueLength int
+	MaxHitsAddend  uint32
+}
+
+// DefaultRequestLimits are well beyond the requests Guardian's Envoy configuration sends, so only malformed requests
+// exceed them
+var DefaultRequestLimits = RequestLimits{MaxDescriptors: 64, MaxEntries: 16, MaxValueLength: 8192, MaxHitsAddend: 100000}
+
+// ValidateRateLimitRequest returns why rlreq is malformed or exceeds limits, and an error describing it, or nil if it
+// is valid
+func ValidateRateLimitRequest(rlreq *ratelimit.RateLimitRequest, limits RequestLimits) (InvalidRequestReason, error) {
+	descriptors := rlreq.GetDescriptors()
+	if len(descriptors) == 0 {
+		return NoDescriptorsReason, fmt.Errorf("request has no descriptors")
+	}
+
+	if limits.MaxDescriptors > 0 && len(descriptors) > limits.MaxDescriptors {
+		return TooManyDescriptorsReason, fmt.Errorf("request has %d descriptors, more than the max of %d", len(descriptors), limits.MaxDescriptors)
+	}
+
+	if limits.MaxHitsAddend > 0 && rlreq.GetHitsAddend() > limits.MaxHitsAddend {
+		return HitsAddendTooLargeReason, fmt.Errorf("hits addend %d is larger than the max of %d", rlreq.GetHitsAddend(), limits.MaxHitsAddend)
+	}
+
+	if limits.MaxValueLength > 0 && len(rlreq.GetDomain()) > limits.MaxValueLength {
+		return ValueTooLongReason, fmt.Errorf("domain of %d bytes is longer than the max of %d", len(rlreq.GetDomain()), limits.MaxValueLength)
+	}
+
+	hasRemoteAddress := false
+	for i, descriptor := range descriptors {
+		entries := descriptor.GetEntries()
+		if len(entries) == 0 {
+			return EmptyDescriptorReason, fmt.Errorf("descriptor %d has no entries", i)
+		}
+
+		if limits.MaxEntries > 0 && len(entries) > limits.MaxEntries {
+			return TooManyEntriesReason, fmt.Errorf("descriptor %d has %d entries, more than the max of %d", i, len(entries), limits.MaxEntries)
+		}
+
+		for _, e := range entries {
+			if limits.MaxValueLength > 0 && (len(e.GetKey()) > limits.MaxValueLength || len(e.GetValue()) > limits.MaxValueLength) {
+				return ValueTooLongReason, fmt.Errorf("descriptor %d has an entry longer than the max of %d bytes", i, limits.MaxValueLength)
+			}
+
+			hasRemoteAddress = hasRemoteAddress || (e.GetKey() == remoteAddressDescriptor && len(e.GetValue()) > 0)
+		}
+	}
+
+	if !hasRemoteAddress {
+		return MissingRemoteAddressReason, fmt.Errorf("request has no %v descriptor", remoteAddressDescriptor)
+	}
+
+	return "", nil
+}
+
+// NewRequestValidator creates a new RequestValidator passing requests within limits to srv
+func NewRequestValidator(srv ratelimit.RateLimitServiceServer, limits RequestLimits, action InvalidRequestAction, logger logrus.FieldLogger, reporter MetricReporter) *RequestValidator {
+	return &RequestValidator{srv: srv, limits: limits, action: action, logger: logger, reporter: reporter}
+}
+
+// RequestValidator checks rate limit requests before they are decided, so malformed requests, such as those
+// without descriptors or with huge values, are neither counted under an empty key nor allowed to grow keys without
+// bound
+type RequestValidator struct {
+	srv      ratelimit.RateLimitServiceServer
+	limits   RequestLimits
+	action   InvalidRequestAction
+	logger   logrus.FieldLogger
+	reporter MetricReporter
+}
+
+func (v *RequestValidator) ShouldRateLimit(ctx context.Context, relreq *ratelimit.RateLimitRequest) (*ratelimit.RateLimitResponse, error) {
+	if truncated, n := truncateLongValues(relreq, v.limits.MaxValueLength); n > 0 {
+		v.logger.Debugf("truncated %d descriptor values longer than %d bytes", n, v.limits.MaxValueLength)
+		v.reporter.InvalidRequest(ValueTooLongReason, TruncateInvalidRequestAction)
+		relreq = truncated
+	}
+
+	reason, err := ValidateRateLimitRequest(relreq, v.limits)
+	if err == nil {
+		return v.srv.ShouldRateLimit(ctx, relreq)
+	}
+
+	v.logger.WithError(err).Debugf("%v invalid request", v.action)
+	v.reporter.InvalidRequest(reason, v.action)
+
+	if v.action == RejectInvalidRequestAction {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+	}
+
+	resp := &ratelimit.RateLimitResponse{OverallCode: ratelimit.RateLimitResponse_OK}
+	for i := 0; i < len(relreq.GetDescriptors()); i++ {
+		resp.Statuses = append(resp.Statuses, &ratelimit.RateLimitResponse_DescriptorStatus{Code: ratelimit.RateLimitResponse_OK})
+	}
+
+	return resp, nil
+}
+
+// truncateLongValues returns a copy of rlreq with the descriptor values longer than maxLength truncated to it, and the
+// number truncated. rlreq is returned as is if none are, or maxLength is 0. Values are truncated rather than dropped so
+// the request keeps its descriptors, and is still counted by its remote address.
+func truncateLongValues(rlreq *ratelimit.RateLimitRequest, maxLength int) (*ratelimit.RateLimitRequest, int) {
+	if maxLength <= 0 {
+		return rlreq, 0
+	}
+
+	truncated := 0
+	descriptors := make([]*envoy_api_v2_ratelimit.RateLimitDescriptor, 0, len(rlreq.GetDescriptors()))
+	for _, descriptor := range rlreq.GetDescriptors() {
+		entries := make([]*envoy_api_v2_ratelimit.RateLimitDescriptor_Entry, 0, len(descriptor.GetEntries()))
+		for _, e := range descriptor.GetEntries() {
+			if len(e.GetValue()) > maxLength {
+				// cut at the start of a character, so multibyte characters aren't split
+				end := maxLength
+				for end > 0 && !utf8.RuneStart(e.GetValue()[end]) {
+					end--
+				}
+				e = &envoy_api_v2_ratelimit.RateLimitDescriptor_Entry{Key: e.GetKey(), Value: e.GetValue()[:end]}
+				truncated++
+			}
+			entries = append(entries, e)
+		}
+		descriptors = append(descriptors, &envoy_api_v2_ratelimit.RateLimitDescriptor{Entries: entries})
+	}
+
+	if truncated == 0 {
+		return rlreq, 0
+	}
+
+	return &ratelimit.RateLimitRequest{Domain: rlreq.GetDomain(), Descriptors: descriptors, HitsAddend: rlreq.GetHitsAddend()}, truncated
+}
@@ -0,0 +1,175 @@
+package guardian
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	envoy_api_v2_ratelimit "github.com/envoyproxy/go-control-plane/envoy/api/v2/ratelimit"
+	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+)
+
+type FakeInvalidRequestReporter struct {
+	NullReporter
+	reasons []InvalidRequestReason
+}
+
+func (f *FakeInvalidRequestReporter) InvalidRequest(reason InvalidRequestReason, action InvalidRequestAction) {
+	f.reasons = append(f.reasons, reason)
+}
+
+func TestValidateRateLimitRequest(t *testing.T) {
+	entry := func(key, value string) *envoy_api_v2_ratelimit.RateLimitDescriptor_Entry {
+		return &envoy_api_v2_ratelimit.RateLimitDescriptor_Entry{Key: key, Value: value}
+	}
+	descriptor := func(entries ...*envoy_api_v2_ratelimit.RateLimitDescriptor_Entry) *envoy_api_v2_ratelimit.RateLimitDescriptor {
+		return &envoy_api_v2_ratelimit.RateLimitDescriptor{Entries: entries}
+	}
+	valid := RateLimitRequestFromRequest("edge", Request{RemoteAddress: "192.168.1.2", Path: "/"})
+	limits := RequestLimits{MaxDescriptors: 3, MaxEntries: 2, MaxValueLength: 16, MaxHitsAddend: 10}
+
+	tests := []struct {
+		name string
+		req  *ratelimit.RateLimitRequest
+		want InvalidRequestReason
+	}{
+		{name: "valid", req: valid, want: ""},
+		{name: "no descriptors", req: &ratelimit.RateLimitRequest{Domain: "edge"}, want: NoDescriptorsReason},
+		{
+			name: "too many descriptors",
+			req: &ratelimit.RateLimitRequest{Descriptors: []*envoy_api_v2_ratelimit.RateLimitDescriptor{
+				descriptor(entry(remoteAddressDescriptor, "192.168.1.2")), descriptor(entry("a", "a")), descriptor(entry("b", "b")), descriptor(entry("c", "c")),
+			}},
+			want: TooManyDescriptorsReason,
+		},
+		{
+			name: "empty descriptor",
+			req:  &ratelimit.RateLimitRequest{Descriptors: []*envoy_api_v2_ratelimit.RateLimitDescriptor{descriptor(entry(remoteAddressDescriptor, "192.168.1.2")), descriptor()}},
+			want: EmptyDescriptorReason,
+		},
+		{
+			name: "too many entries",
+			req:  &ratelimit.RateLimitRequest{Descriptors: []*envoy_api_v2_ratelimit.RateLimitDescriptor{descriptor(entry(remoteAddressDescriptor, "192.168.1.2"), entry("a", "a"), entry("b", "b"))}},
+			want: TooManyEntriesReason,
+		},
+		{
+			name: "value too long",
+			req:  &ratelimit.RateLimitRequest{Descriptors: []*envoy_api_v2_ratelimit.RateLimitDescriptor{descriptor(entry(remoteAddressDescriptor, "192.168.1.2"), entry(pathDescriptor, "/"+strings.Repeat("a", 16)))}},
+			want: ValueTooLongReason,
+		},
+		{
+			name: "domain too long",
+			req:  &ratelimit.RateLimitRequest{Domain: strings.Repeat("a", 17), Descriptors: valid.Descriptors},
+			want: ValueTooLongReason,
+		},
+		{
+			name: "hits addend too large",
+			req:  &ratelimit.RateLimitRequest{HitsAddend: 11, Descriptors: valid.Descriptors},
+			want: HitsAddendTooLargeReason,
+		},
+		{
+			name: "missing remote address",
+			req:  &ratelimit.RateLimitRequest{Descriptors: []*envoy_api_v2_ratelimit.RateLimitDescriptor{descriptor(entry(remoteAddressDescriptor, "")), descriptor(entry(pathDescriptor, "/"))}},
+			want: MissingRemoteAddressReason,
+		},
+	}
+
+	for _, test := range tests {
+		reason, err := ValidateRateLimitRequest(test.req, limits)
+		if reason != test.want || (err == nil) != (test.want == "") {
+			t.Errorf("%v expected reason: %q received: %q, %v", test.name, test.want, reason, err)
+		}
+	}
+
+	huge := &ratelimit.RateLimitRequest{HitsAddend: 1000000, Descriptors: []*envoy_api_v2_ratelimit.RateLimitDescriptor{descriptor(entry(remoteAddressDescriptor, strings.Repeat("1", 100)))}}
+	if _, err := ValidateRateLimitRequest(huge, RequestLimits{}); err != nil {
+		t.Errorf("expected zero limits to be unbounded, received: %v", err)
+	}
+}
+
+func TestRequestValidator(t *testing.T) {
+	decided := 0
+	server := NewServer(func(c context.Context, r Request) (bool, uint32, error) {
+		decided++
+		return true, 0, nil
+	}, StaticReportOnlyProvider{false}, "", 0, TestingLogger, NullReporter{})
+
+	reporter := &FakeInvalidRequestReporter{}
+	validator := NewRequestValidator(server, DefaultRequestLimits, AllowInvalidRequestAction, TestingLogger, reporter)
+
+	res, err := validator.ShouldRateLimit(context.Background(), RateLimitRequestFromRequest("edge", Request{RemoteAddress: "192.168.1.2", Path: "/"}))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if res.OverallCode != ratelimit.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("expected a valid request to be decided, received: %v", res.OverallCode)
+	}
+
+	req := newRateLimitRequest() // has no remote address
+	res, err = validator.ShouldRateLimit(context.Background(), req)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if res.OverallCode != ratelimit.RateLimitResponse_OK || len(res.Statuses) != len(req.Descriptors) {
+		t.Errorf("expected an invalid request to be allowed, received: %v", res)
+	}
+
+	if decided != 1 {
+		t.Errorf("expected only the valid request to be decided, received: %v", decided)
+	}
+
+	if len(reporter.reasons) != 1 || reporter.reasons[0] != MissingRemoteAddressReason {
+		t.Errorf("expected the invalid request to be reported, received: %v", reporter.reasons)
+	}
+
+	validator = NewRequestValidator(server, DefaultRequestLimits, RejectInvalidRequestAction, TestingLogger, NullReporter{})
+	if _, err := validator.ShouldRateLimit(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an invalid request to be rejected, received: %v", err)
+	}
+}
+
+func TestRequestValidatorTruncatesLongValues(t *testing.T) {
+	blacklister := NewIPBlacklister(&FakeBlacklistStore{blacklist: parseCIDRs([]string{"192.168.9.0/24"})}, TestingLogger, NullReporter{})
+	var decided Request
+	server := NewServer(func(c context.Context, r Request) (bool, uint32, error) {
+		decided = r
+		blocked, err := blacklister.IsBlacklisted(c, r)
+		return blocked, 0, err
+	}, StaticReportOnlyProvider{false}, "", 0, TestingLogger, NullReporter{})
+
+	reporter := &FakeInvalidRequestReporter{}
+	validator := NewRequestValidator(server, DefaultRequestLimits, AllowInvalidRequestAction, TestingLogger, reporter)
+
+	token := strings.Repeat("a", 9*1024)
+	req := RateLimitRequestFromRequest("edge", Request{RemoteAddress: "192.168.9.1", Path: "/", Headers: map[string]string{"authorization": token}})
+	res, err := validator.ShouldRateLimit(context.Background(), req)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if res.OverallCode != ratelimit.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("expected a blacklisted request with an oversized header to be blocked, received: %v", res.OverallCode)
+	}
+
+	if got := decided.Headers["authorization"]; got != token[:DefaultRequestLimits.MaxValueLength] {
+		t.Errorf("expected the header to be truncated to %d bytes, received %d", DefaultRequestLimits.MaxValueLength, len(got))
+	}
+
+	if len(reporter.reasons) != 1 || reporter.reasons[0] != ValueTooLongReason {
+		t.Errorf("expected the truncation to be reported, received: %v", reporter.reasons)
+	}
+
+	if len(req.Descriptors[len(req.Descriptors)-1].Entries[0].Value) != len(token) {
+		t.Error("expected the original request to be left unchanged")
+	}
+
+	truncated, n := truncateLongValues(RateLimitRequestFromRequest("edge", Request{RemoteAddress: "192.168.1.2", Path: "/" + strings.Repeat("é", 10)}), 12)
+	if n != 1 || RequestFromRateLimitRequest(truncated).Path != "/ééééé" {
+		t.Errorf("expected the path to be truncated at a character boundary, received: %q, %d truncated", RequestFromRateLimitRequest(truncated).Path, n)
+	}
+}
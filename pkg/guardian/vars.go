@@ -0,0 +1,137 @@
+package guardian
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// decisionRateWindow is the number of seconds decisions per second is averaged over
+const decisionRateWindow = 10
+
+// NewDecisionRate creates a new DecisionRate
+func NewDecisionRate(clock Clock) *DecisionRate {
+	return &DecisionRate{clock: clock}
+}
+
+// DecisionRate counts decisions, tracking the rate they were made at over the last few seconds
+type DecisionRate struct {
+	sync.Mutex
+	clock   Clock
+	total   uint64
+	counts  [decisionRateWindow]uint64
+	seconds [decisionRateWindow]int64 // the unix second each count is for
+}
+
+// Record counts a decision
+func (d *DecisionRate) Record() {
+	sec := d.clock.Now().Unix()
+	i := sec % decisionRateWindow
+
+	d.Lock()
+	defer d.Unlock()
+
+	if d.seconds[i] != sec {
+		d.seconds[i] = sec
+		d.counts[i] = 0
+	}
+	d.counts[i]++
+	d.total++
+}
+
+// Total returns the number of decisions counted
+func (d *DecisionRate) Total() uint64 {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.total
+}
+
+// PerSecond returns the average decisions per second over the last complete seconds of the window
+func (d *DecisionRate) PerSecond() float64 {
+	now := d.clock.Now().Unix()
+
+	d.Lock()
+	defer d.Unlock()
+
+	sum := uint64(0)
+	for i, sec := range d.seconds {
+		if sec < now && sec >= now-decisionRateWindow {
+			sum += d.counts[i]
+		}
+	}
+
+	return float64(sum) / decisionRateWindow
+}
+
+// CountDecisions wraps f, recording each decision it makes in rate
+func CountDecisions(f RequestBlockerFunc, rate *DecisionRate) RequestBlockerFunc {
+	return func(c context.Context, r Request) (bool, uint32, error) {
+		rate.Record()
+		return f(c, r)
+	}
+}
+
+// VarsSnapshot is a snapshot of Guardian's internal counters
+type VarsSnapshot struct {
+	Decisions          uint64  `json:"decisions"`
+	DecisionsPerSecond float64 `json:"decisions_per_second"`
+
+	// ConfAgeSeconds is the time since a synced conf was applied, or -1 if one hasn't been
+	ConfAgeSeconds float64 `json:"conf_age_seconds"`
+
+	RedisCircuit string           `json:"redis_circuit"`
+	RedisPool    *redis.PoolStats `json:"redis_pool"`
+	// ConfRedisPool is the pool conf is synced through
+	ConfRedisPool *redis.PoolStats `json:"conf_redis_pool"`
+
+	SLOs []SLOStatus `json:"slos,omitempty"`
+}
+
+// NewVars creates a new Vars reporting the pool stats of the redis client used for counters and of confRedis used for
+// conf, and the status of the SLOs tracked by slos, if it isn't nil. A nil breaker is reported as always closed.
+func NewVars(rate *DecisionRate, conf ConfStore, breaker *CircuitBreaker, redis *redis.Client, confRedis *redis.Client, slos *SLOTracker) *Vars {
+	return &Vars{rate: rate, conf: conf, breaker: breaker, redis: redis, confRedis: confRedis, slos: slos}
+}
+
+// Vars exposes Guardian's internal counters for inspection without a metrics backend
+type Vars struct {
+	rate      *DecisionRate
+	conf      ConfStore
+	breaker   *CircuitBreaker
+	redis     *redis.Client
+	confRedis *redis.Client
+	slos      *SLOTracker
+}
+
+// Snapshot returns the current value of the counters
+func (v *Vars) Snapshot() VarsSnapshot {
+	confAge := -1.0
+	if syncedAt := v.conf.SyncedAt(); !syncedAt.IsZero() {
+		confAge = time.Since(syncedAt).Seconds()
+	}
+
+	snapshot := VarsSnapshot{
+		Decisions:          v.rate.Total(),
+		DecisionsPerSecond: v.rate.PerSecond(),
+		ConfAgeSeconds:     confAge,
+		RedisCircuit:       v.breaker.State(),
+		RedisPool:          v.redis.PoolStats(),
+		ConfRedisPool:      v.confRedis.PoolStats(),
+	}
+
+	if v.slos != nil {
+		snapshot.SLOs = v.slos.Statuses()
+	}
+
+	return snapshot
+}
+
+// Publish publishes the counters as the "guardian" expvar, served as JSON at /debug/vars of the default
+// ServeMux. It must only be called once.
+func (v *Vars) Publish() {
+	expvar.Publish("guardian", expvar.Func(func() interface{} { return v.Snapshot() }))
+}
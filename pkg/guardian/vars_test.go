@@ -0,0 +1,70 @@
+package guardian
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDecisionRate(t *testing.T) {
+	clock := &fixedClock{now: time.Unix(1000, 0)}
+	rate := NewDecisionRate(clock)
+	blocker := CountDecisions(func(c context.Context, r Request) (bool, uint32, error) {
+		return false, 0, nil
+	}, rate)
+
+	for i := 0; i < 20; i++ {
+		blocker(context.Background(), Request{})
+	}
+
+	if got := rate.PerSecond(); got != 0 {
+		t.Errorf("expected decisions of the current second not to be averaged, received: %v", got)
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	if got := rate.PerSecond(); got != 2 {
+		t.Errorf("expected: %v received: %v", 2, got)
+	}
+
+	clock.now = clock.now.Add(decisionRateWindow * time.Second)
+	if got := rate.PerSecond(); got != 0 {
+		t.Errorf("expected decisions outside the window not to be averaged, received: %v", got)
+	}
+
+	if got := rate.Total(); got != 20 {
+		t.Errorf("expected: %v received: %v", 20, got)
+	}
+}
+
+func TestVarsSnapshot(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	breaker := NewCircuitBreaker(1, time.Hour)
+	vars := NewVars(NewDecisionRate(LocalClock{}), c, breaker, c.redis, c.redis, nil)
+
+	snapshot := vars.Snapshot()
+	if snapshot.ConfAgeSeconds != -1 {
+		t.Errorf("expected conf age of -1 before syncing, received: %v", snapshot.ConfAgeSeconds)
+	}
+
+	if snapshot.RedisCircuit != "closed" {
+		t.Errorf("expected closed circuit, received: %v", snapshot.RedisCircuit)
+	}
+
+	c.UpdateCachedConf()
+	breaker.Failure()
+
+	snapshot = vars.Snapshot()
+	if snapshot.ConfAgeSeconds < 0 || snapshot.ConfAgeSeconds > 1 {
+		t.Errorf("expected conf age of the recent sync, received: %v", snapshot.ConfAgeSeconds)
+	}
+
+	if snapshot.RedisCircuit != "open" {
+		t.Errorf("expected open circuit, received: %v", snapshot.RedisCircuit)
+	}
+
+	if snapshot.RedisPool == nil || snapshot.ConfRedisPool == nil {
+		t.Errorf("expected redis pool stats")
+	}
+}
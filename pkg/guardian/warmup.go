@@ -0,0 +1,79 @@
+package guardian
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+const warmupNamespace = "warmup"
+
+// RequestReportOnlyProvider is a ReportOnlyProvider that can decide report only mode per request. Deciders check for
+// it and ask it about each request they decide.
+type RequestReportOnlyProvider interface {
+	ReportOnlyProvider
+	// GetReportOnlyForRequest returns whether blocking request should only be reported
+	GetReportOnlyForRequest(request Request) bool
+}
+
+// NewWarmup creates a new Warmup starting now, wrapping provider
+func NewWarmup(provider ReportOnlyProvider, reportOnlyDuration time.Duration, rampDuration time.Duration, clock Clock) *Warmup {
+	return &Warmup{provider: provider, start: clock.Now(), reportOnlyDuration: reportOnlyDuration, rampDuration: rampDuration, clock: clock}
+}
+
+// Warmup ramps enforcement in after the process starts, so an instance whose counters and caches haven't warmed up
+// yet, e.g. during a rolling deploy, doesn't mass block legitimate traffic. Blocking is only reported for the first
+// reportOnlyDuration, then enforced for a share of keys growing linearly to all of them over rampDuration. Keys are
+// chosen deterministically by hashing them, so a key stays enforced once it is. Report only mode set by provider
+// applies throughout.
+type Warmup struct {
+	provider           ReportOnlyProvider
+	start              time.Time
+	reportOnlyDuration time.Duration
+	rampDuration       time.Duration
+	clock              Clock
+}
+
+// GetReportOnly returns whether blocking every request should only be reported
+func (w *Warmup) GetReportOnly() bool {
+	return w.provider.GetReportOnly() || w.clock.Now().Sub(w.start) < w.reportOnlyDuration
+}
+
+// GetReportOnlyForRequest returns whether blocking request should only be reported
+func (w *Warmup) GetReportOnlyForRequest(request Request) bool {
+	if w.GetReportOnly() {
+		return true
+	}
+
+	percent := w.EnforcePercent()
+	if percent >= MaxEnforcePercent {
+		return false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(warmupNamespace + ":" + request.RemoteAddress))
+	return uint(h.Sum32()%MaxEnforcePercent) >= percent
+}
+
+// EnforcePercent returns the percentage of keys blocking is enforced for, disregarding report only mode set by the
+// provider
+func (w *Warmup) EnforcePercent() uint {
+	elapsed := w.clock.Now().Sub(w.start) - w.reportOnlyDuration
+	if elapsed < 0 {
+		return 0
+	}
+
+	if elapsed >= w.rampDuration {
+		return MaxEnforcePercent
+	}
+
+	return uint(MaxEnforcePercent * elapsed / w.rampDuration)
+}
+
+// reportOnlyForRequest returns whether provider says blocking request should only be reported
+func reportOnlyForRequest(provider ReportOnlyProvider, request Request) bool {
+	if p, ok := provider.(RequestReportOnlyProvider); ok {
+		return p.GetReportOnlyForRequest(request)
+	}
+
+	return provider.GetReportOnly()
+}
@@ -0,0 +1,83 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+)
+
+func TestWarmupRampsEnforcement(t *testing.T) {
+	start := time.Date(2018, 4, 5, 10, 0, 0, 0, time.UTC)
+	clock := &fixedClock{start}
+	w := NewWarmup(StaticReportOnlyProvider{false}, 30*time.Second, time.Minute, clock)
+
+	enforced := func() int {
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if !w.GetReportOnlyForRequest(Request{RemoteAddress: fmt.Sprintf("10.0.%d.%d", i/256, i%256)}) {
+				n++
+			}
+		}
+		return n
+	}
+
+	if !w.GetReportOnly() || enforced() != 0 {
+		t.Errorf("expected report only mode after starting, received enforced for %d keys", enforced())
+	}
+
+	clock.now = start.Add(time.Minute)
+	if w.GetReportOnly() || w.EnforcePercent() != 50 {
+		t.Errorf("expected enforcement for 50%% of keys half way through the ramp, received: %v", w.EnforcePercent())
+	}
+
+	if got := enforced(); got < 400 || got > 600 {
+		t.Errorf("expected about 500 keys enforced, received: %v", got)
+	}
+
+	key := Request{RemoteAddress: "192.168.1.2"}
+	for w.GetReportOnlyForRequest(key) {
+		clock.now = clock.now.Add(time.Second)
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	if w.GetReportOnlyForRequest(key) {
+		t.Error("expected a key to stay enforced once it is")
+	}
+
+	clock.now = start.Add(90 * time.Second)
+	if got := enforced(); got != 1000 {
+		t.Errorf("expected every key enforced after the ramp, received: %v", got)
+	}
+
+	w = NewWarmup(StaticReportOnlyProvider{true}, 0, 0, clock)
+	if !w.GetReportOnlyForRequest(key) {
+		t.Error("expected report only mode of the provider to apply after warming up")
+	}
+}
+
+func TestShouldRateLimitDuringWarmup(t *testing.T) {
+	clock := &fixedClock{time.Now()}
+	blocker := func(c context.Context, req Request) (bool, uint32, error) {
+		return true, 0, nil
+	}
+
+	server := NewServer(blocker, NewWarmup(StaticReportOnlyProvider{false}, time.Minute, 0, clock), "", 0, TestingLogger, NullReporter{})
+	req := newRateLimitRequest()
+
+	res, err := server.ShouldRateLimit(context.Background(), req)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if res.OverallCode != ratelimit.RateLimitResponse_OK {
+		t.Errorf("expected blocking to be reported only while warming up, received: %v", res.OverallCode)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if res, _ := server.ShouldRateLimit(context.Background(), req); res.OverallCode != ratelimit.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("expected blocking to be enforced after warming up, received: %v", res.OverallCode)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/netip"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -46,14 +48,46 @@ type WhitelistProvider interface {
 	GetWhitelist() []net.IPNet
 }
 
-func NewIPWhitelister(provider WhitelistProvider, logger logrus.FieldLogger, reporter MetricReporter) *IPWhitelister {
-	return &IPWhitelister{provider: provider, logger: logger, reporter: reporter}
+// WhitelistPrefixProvider is implemented by WhitelistProviders that can provide their whitelist as netip.Prefixes without
+// converting or copying it for every request. The returned slice must not be modified.
+type WhitelistPrefixProvider interface {
+	GetWhitelistPrefixes() []netip.Prefix
+}
+
+// NewIPWhitelister creates a new IPWhitelister. Providers that don't implement WhitelistPrefixProvider have their whitelist
+// converted for every request. The addresses of whitelisted hosts, such as those resolved by a HostWhitelist, are
+// also whitelisted, and hosts may be nil. Up to cacheSize remote addresses found not to be whitelisted are cached for
+// cacheTTL, so clients sending many requests skip matching the whitelist on all but the first. Caching is disabled if
+// cacheSize is 0.
+func NewIPWhitelister(provider WhitelistProvider, hosts WhitelistPrefixProvider, cacheSize int, cacheTTL time.Duration, logger logrus.FieldLogger, reporter MetricReporter) *IPWhitelister {
+	prefixes, ok := provider.(WhitelistPrefixProvider)
+	if !ok {
+		prefixes = ipNetWhitelistProvider{provider}
+	}
+
+	w := &IPWhitelister{provider: prefixes, hosts: hosts, logger: logger, reporter: reporter}
+	if cacheSize > 0 {
+		w.negatives = newWhitelistNegativeCache(cacheSize, cacheTTL, LocalClock{})
+	}
+
+	return w
 }
 
 type IPWhitelister struct {
-	provider WhitelistProvider
-	logger   logrus.FieldLogger
-	reporter MetricReporter
+	provider  WhitelistPrefixProvider
+	hosts     WhitelistPrefixProvider
+	negatives *whitelistNegativeCache // nil if disabled
+	logger    logrus.FieldLogger
+	reporter  MetricReporter
+}
+
+// ipNetWhitelistProvider adapts a WhitelistProvider to a WhitelistPrefixProvider
+type ipNetWhitelistProvider struct {
+	WhitelistProvider
+}
+
+func (p ipNetWhitelistProvider) GetWhitelistPrefixes() []netip.Prefix {
+	return PrefixesFromIPNets(p.GetWhitelist())
 }
 
 func (w *IPWhitelister) IsWhitelisted(context context.Context, req Request) (bool, error) {
@@ -65,27 +99,127 @@ func (w *IPWhitelister) IsWhitelisted(context context.Context, req Request) (boo
 	}()
 
 	w.logger.Debugf("checking whitelist for request %#v", req)
-	ip := net.ParseIP(req.RemoteAddress)
+	ip, ok := parseRemoteAddr(req.RemoteAddress)
 	w.logger.Debugf("parsed IP from request %#v", req)
-	if ip == nil {
+	if !ok {
 		errorOccurred = true
 		return false, fmt.Errorf("invalid remote address -- not IP")
 	}
 
 	w.logger.Debug("Getting whitelist")
-	whitelist := w.provider.GetWhitelist()
+	whitelist := w.provider.GetWhitelistPrefixes()
 	w.logger.Debugf("Got whitelist with length %d", len(whitelist))
 	w.reporter.CurrentWhitelist(whitelist)
 
+	var hosts []netip.Prefix
+	if w.hosts != nil {
+		hosts = w.hosts.GetWhitelistPrefixes()
+	}
+
+	if w.negatives.contains(req.RemoteAddress, whitelist, hosts) {
+		tracef(context, "not whitelisted, cached")
+		return false, nil
+	}
+
 	for _, cidr := range whitelist {
 		if cidr.Contains(ip) {
 			w.logger.Debugf("Found %v in cidr %v of whitelist", ip, cidr.String())
+			tracef(context, "whitelisted by cidr %v", cidr.String())
+			whitelisted = true
+			return true, nil
+		}
+	}
+
+	for _, addr := range hosts {
+		if addr.Contains(ip) {
+			w.logger.Debugf("Found %v in addresses of whitelisted hosts", ip)
+			tracef(context, "whitelisted by an address of a whitelisted host")
 			whitelisted = true
 			return true, nil
 		}
-		w.logger.Debugf("CIDR %v does not contain %v of whitelist", cidr.String(), ip)
 	}
 
+	w.negatives.add(req.RemoteAddress, whitelist, hosts)
 	w.logger.Debugf("%v NOT FOUND in whitelist", ip)
+	tracef(context, "not whitelisted by %d cidrs", len(whitelist))
 	return false, nil
 }
+
+func newWhitelistNegativeCache(size int, ttl time.Duration, clock Clock) *whitelistNegativeCache {
+	return &whitelistNegativeCache{ttl: ttl, clock: clock, size: size, entries: newLRUCache(size)}
+}
+
+// whitelistNegativeCache caches the remote addresses recently found not to be whitelisted. Entries are only valid for
+// the whitelist and whitelisted host addresses they were looked up in, so the cache is cleared when either changes.
+// A nil whitelistNegativeCache caches nothing.
+type whitelistNegativeCache struct {
+	ttl   time.Duration
+	clock Clock
+	size  int
+
+	mu        sync.Mutex
+	whitelist []netip.Prefix
+	hosts     []netip.Prefix
+	entries   *lruCache // remote address to when it expires
+}
+
+// contains returns whether remoteAddress was cached as not whitelisted by whitelist and hosts within the ttl
+func (c *whitelistNegativeCache) contains(remoteAddress string, whitelist []netip.Prefix, hosts []netip.Prefix) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sync(whitelist, hosts)
+	cached, ok := c.entries.get(remoteAddress)
+	return ok && c.clock.Now().Before(cached.(time.Time))
+}
+
+// add caches remoteAddress as not whitelisted by whitelist and hosts
+func (c *whitelistNegativeCache) add(remoteAddress string, whitelist []netip.Prefix, hosts []netip.Prefix) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sync(whitelist, hosts)
+	c.entries.add(remoteAddress, c.clock.Now().Add(c.ttl))
+}
+
+// sync clears the cache if whitelist or hosts differ from the lists its entries were looked up in. Lists are
+// compared by identity first, since providers return the same slice until their list changes. The cache must be
+// locked.
+func (c *whitelistNegativeCache) sync(whitelist []netip.Prefix, hosts []netip.Prefix) {
+	if samePrefixSlice(c.whitelist, whitelist) && samePrefixSlice(c.hosts, hosts) {
+		return
+	}
+
+	if !equalPrefixes(c.whitelist, whitelist) || !equalPrefixes(c.hosts, hosts) {
+		c.entries = newLRUCache(c.size)
+	}
+
+	// holding the lists keeps their memory from being reused by different lists that would compare as identical
+	c.whitelist, c.hosts = whitelist, hosts
+}
+
+func samePrefixSlice(a []netip.Prefix, b []netip.Prefix) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+func equalPrefixes(a []netip.Prefix, b []netip.Prefix) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
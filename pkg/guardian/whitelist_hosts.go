@@ -0,0 +1,200 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// whitelistHostStaleRefreshes is how many refresh intervals the addresses of a whitelisted host that fails to resolve
+// are kept for, riding out brief DNS outages without whitelisting addresses a host may have given up long ago
+const whitelistHostStaleRefreshes = 5
+
+const maxHostnameLength = 253
+
+// CanonicalHostname lowercases host and removes any trailing dot, so equivalent hostnames are stored once
+func CanonicalHostname(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// canonicalHostnames returns hosts canonicalized and sorted
+func canonicalHostnames(hosts []string) []string {
+	canonical := make([]string, len(hosts))
+	for i, host := range hosts {
+		canonical[i] = CanonicalHostname(host)
+	}
+
+	sort.Strings(canonical)
+	return canonical
+}
+
+// ValidateHostname returns an error if host isn't a DNS hostname. IP addresses are rejected since they are
+// whitelisted as CIDRs.
+func ValidateHostname(host string) error {
+	host = CanonicalHostname(host)
+	if len(host) == 0 || len(host) > maxHostnameLength {
+		return fmt.Errorf("hostname %q must be between 1 and %d characters", host, maxHostnameLength)
+	}
+
+	if _, ok := parseRemoteAddr(host); ok {
+		return fmt.Errorf("%q is an ip address, whitelist it as a cidr instead", host)
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("hostname %q has an invalid label %q", host, label)
+		}
+
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+				return fmt.Errorf("hostname %q has an invalid character %q", host, c)
+			}
+		}
+	}
+
+	return nil
+}
+
+// WhitelistHostProvider provides the hostnames whose addresses are whitelisted
+type WhitelistHostProvider interface {
+	GetWhitelistHosts() []string
+}
+
+// HostResolver resolves hostnames to their IP addresses. *net.Resolver is a HostResolver.
+type HostResolver interface {
+	LookupIPAddr(context context.Context, host string) ([]net.IPAddr, error)
+}
+
+// NewHostWhitelist creates a new HostWhitelist resolving the hosts of provider with resolver every refresh interval,
+// giving up on lookups that take longer than timeout
+func NewHostWhitelist(provider WhitelistHostProvider, resolver HostResolver, refresh time.Duration, timeout time.Duration, logger logrus.FieldLogger, reporter MetricReporter) *HostWhitelist {
+	return &HostWhitelist{
+		provider: provider,
+		resolver: resolver,
+		refresh:  refresh,
+		timeout:  timeout,
+		logger:   logger,
+		reporter: reporter,
+		resolved: make(map[string]resolvedHost),
+		prefixes: []netip.Prefix{},
+	}
+}
+
+type resolvedHost struct {
+	prefixes   []netip.Prefix
+	resolvedAt time.Time
+}
+
+// HostWhitelist is a WhitelistPrefixProvider of the addresses whitelisted hosts resolve to. Hosts are resolved
+// periodically, off of the request path, so a host's addresses aren't whitelisted until its first lookup completes.
+type HostWhitelist struct {
+	provider WhitelistHostProvider
+	resolver HostResolver
+	refresh  time.Duration
+	timeout  time.Duration
+	logger   logrus.FieldLogger
+	reporter MetricReporter
+
+	mu       sync.RWMutex
+	resolved map[string]resolvedHost
+	// prefixes is replaced, never modified, so it can be shared without copying
+	prefixes []netip.Prefix
+}
+
+// GetWhitelistPrefixes returns the addresses of the whitelisted hosts without copying them. They must not be modified.
+func (hw *HostWhitelist) GetWhitelistPrefixes() []netip.Prefix {
+	hw.mu.RLock()
+	defer hw.mu.RUnlock()
+
+	return hw.prefixes
+}
+
+// Run resolves the whitelisted hosts immediately and then every refresh interval until stop is closed
+func (hw *HostWhitelist) Run(stop <-chan struct{}) {
+	hw.Refresh(time.Now())
+
+	ticker := time.NewTicker(hw.refresh)
+	for {
+		select {
+		case <-ticker.C:
+			hw.Refresh(time.Now())
+		case <-stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// Refresh resolves each of the whitelisted hosts, replacing the whitelisted addresses with those they resolve to.
+// The previous addresses of a host that fails to resolve are kept until they are stale. Hosts that are no longer
+// whitelisted are dropped.
+func (hw *HostWhitelist) Refresh(now time.Time) {
+	hw.mu.RLock()
+	previous := hw.resolved
+	hw.mu.RUnlock()
+
+	resolved := make(map[string]resolvedHost)
+	for _, host := range hw.provider.GetWhitelistHosts() {
+		prefixes, err := hw.lookup(host)
+		if err == nil {
+			hw.logger.Debugf("resolved whitelisted host %v to %v", host, prefixes)
+			resolved[host] = resolvedHost{prefixes: prefixes, resolvedAt: now}
+			continue
+		}
+
+		prev, ok := previous[host]
+		if ok && now.Sub(prev.resolvedAt) < hw.refresh*whitelistHostStaleRefreshes {
+			hw.logger.WithError(err).Warnf("error resolving whitelisted host %v, keeping addresses resolved at %v", host, prev.resolvedAt)
+			resolved[host] = prev
+			continue
+		}
+
+		hw.logger.WithError(err).Errorf("error resolving whitelisted host %v, not whitelisting it", host)
+	}
+
+	prefixes := []netip.Prefix{}
+	for _, rh := range resolved {
+		prefixes = append(prefixes, rh.prefixes...)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return prefixes[i].Addr().Less(prefixes[j].Addr()) })
+
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	hw.resolved = resolved
+	hw.prefixes = prefixes
+}
+
+// lookup resolves host to a single address prefix for each of its addresses
+func (hw *HostWhitelist) lookup(host string) ([]netip.Prefix, error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), hw.timeout)
+	addrs, err := hw.resolver.LookupIPAddr(ctx, host)
+	cancel()
+	hw.reporter.WhitelistHostLookup(time.Now().Sub(start), err != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := []netip.Prefix{}
+	for _, ipAddr := range addrs {
+		addr, ok := netip.AddrFromSlice(ipAddr.IP)
+		if !ok {
+			continue
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("no addresses found for %v", host)
+	}
+
+	return prefixes, nil
+}
@@ -0,0 +1,148 @@
+package guardian
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type FakeHostResolver struct {
+	addrs map[string][]string
+}
+
+func (f *FakeHostResolver) LookupIPAddr(context context.Context, host string) ([]net.IPAddr, error) {
+	addrs, ok := f.addrs[host]
+	if !ok {
+		return nil, fmt.Errorf("no such host %v", host)
+	}
+
+	ipAddrs := []net.IPAddr{}
+	for _, addr := range addrs {
+		ipAddrs = append(ipAddrs, net.IPAddr{IP: net.ParseIP(addr)})
+	}
+	return ipAddrs, nil
+}
+
+type FakeWhitelistHostProvider struct {
+	hosts []string
+}
+
+func (f *FakeWhitelistHostProvider) GetWhitelistHosts() []string {
+	return f.hosts
+}
+
+func TestValidateHostname(t *testing.T) {
+	valid := []string{"partner-gateway.example.com", "Partner.Example.com.", "localhost", "_srv.example.com"}
+	for _, host := range valid {
+		if err := ValidateHostname(host); err != nil {
+			t.Errorf("expected %q to be valid, received: %v", host, err)
+		}
+	}
+
+	invalid := []string{"", "192.168.1.2", "::1", "-partner.example.com", "partner..example.com", "partner example.com", "10.0.0.0/8"}
+	for _, host := range invalid {
+		if err := ValidateHostname(host); err == nil {
+			t.Errorf("expected %q to be invalid", host)
+		}
+	}
+}
+
+func TestHostWhitelistRefresh(t *testing.T) {
+	resolver := &FakeHostResolver{addrs: map[string][]string{
+		"a.example.com": {"10.0.0.1", "2001:db8::1"},
+		"b.example.com": {"10.0.0.2"},
+	}}
+	provider := &FakeWhitelistHostProvider{hosts: []string{"a.example.com", "b.example.com"}}
+	hw := NewHostWhitelist(provider, resolver, time.Minute, time.Second, TestingLogger, NullReporter{})
+	now := time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)
+
+	addrs := func() []string {
+		strs := []string{}
+		for _, p := range hw.GetWhitelistPrefixes() {
+			strs = append(strs, p.String())
+		}
+		return strs
+	}
+
+	if got := addrs(); len(got) != 0 {
+		t.Fatalf("expected no addresses before the first refresh, received: %v", got)
+	}
+
+	hw.Refresh(now)
+	if got, want := addrs(), []string{"10.0.0.1/32", "10.0.0.2/32", "2001:db8::1/128"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected: %v received: %v", want, got)
+	}
+
+	// a.example.com's addresses are kept while they're fresh enough, b.example.com's are replaced
+	delete(resolver.addrs, "a.example.com")
+	resolver.addrs["b.example.com"] = []string{"10.0.0.3"}
+	hw.Refresh(now.Add(time.Minute))
+	if got, want := addrs(), []string{"10.0.0.1/32", "10.0.0.3/32", "2001:db8::1/128"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected: %v received: %v", want, got)
+	}
+
+	hw.Refresh(now.Add(whitelistHostStaleRefreshes * time.Minute))
+	if got, want := addrs(), []string{"10.0.0.3/32"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected stale addresses to be dropped, expected: %v received: %v", want, got)
+	}
+
+	provider.hosts = []string{}
+	hw.Refresh(now.Add(6 * time.Minute))
+	if got := addrs(); len(got) != 0 {
+		t.Errorf("expected addresses of removed hosts to be dropped, received: %v", got)
+	}
+}
+
+func TestIPWhitelisterWithHosts(t *testing.T) {
+	resolver := &FakeHostResolver{addrs: map[string][]string{"partner.example.com": {"192.168.1.2"}}}
+	provider := &FakeWhitelistHostProvider{hosts: []string{"partner.example.com"}}
+	hw := NewHostWhitelist(provider, resolver, time.Minute, time.Second, TestingLogger, NullReporter{})
+	hw.Refresh(time.Now())
+
+	whitelister := NewIPWhitelister(FakeWhitelistStore{whitelist: parseCIDRs([]string{"10.0.0.0/8"})}, hw, 0, 0, TestingLogger, NullReporter{})
+	tests := map[string]bool{"10.1.2.3": true, "192.168.1.2": true, "192.168.1.3": false}
+	for addr, want := range tests {
+		got, err := whitelister.IsWhitelisted(context.Background(), Request{RemoteAddress: addr})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if got != want {
+			t.Errorf("%v expected whitelisted: %v received: %v", addr, want, got)
+		}
+	}
+}
+
+func TestConfStoreWhitelistHosts(t *testing.T) {
+	c, s := newTestConfStore(t)
+	defer s.Close()
+
+	if err := c.AddWhitelistHosts([]string{"Partner.Example.com.", "b.example.com"}); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if err := c.AddWhitelistHosts([]string{"192.168.1.2"}); err == nil {
+		t.Error("expected an error whitelisting an ip address as a host")
+	}
+
+	c.UpdateCachedConf()
+	if got, want := c.GetWhitelistHosts(), []string{"b.example.com", "partner.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected: %v received: %v", want, got)
+	}
+
+	if err := c.RemoveWhitelistHosts([]string{"partner.example.com"}); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	doc, err := c.ExportConfDocument()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if want := []string{"b.example.com"}; !reflect.DeepEqual(doc.WhitelistHosts, want) {
+		t.Errorf("expected exported hosts: %v received: %v", want, doc.WhitelistHosts)
+	}
+}
@@ -2,8 +2,11 @@ package guardian
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"net/netip"
 	"testing"
+	"time"
 )
 
 type FakeWhitelistStore struct {
@@ -16,7 +19,7 @@ func (f FakeWhitelistStore) GetWhitelist() []net.IPNet {
 
 func TestIsWhitelisted(t *testing.T) {
 	store := &FakeWhitelistStore{}
-	whitelister := NewIPWhitelister(store, TestingLogger, NullReporter{})
+	whitelister := NewIPWhitelister(store, nil, 0, 0, TestingLogger, NullReporter{})
 
 	tests := []struct {
 		// test case setup
@@ -42,6 +45,13 @@ func TestIsWhitelisted(t *testing.T) {
 			whitelisted:    false,
 			errored:        false,
 		},
+		{
+			name:           "BracketedIPv6WithZone",
+			storeWhitelist: parseCIDRs([]string{"fe80::/10"}),
+			req:            Request{RemoteAddress: "[fe80::1%eth0]:443"},
+			whitelisted:    true,
+			errored:        false,
+		},
 		{
 			name:           "ErrorFailClosed",
 			storeWhitelist: parseCIDRs([]string{"10.0.0.1/24"}),
@@ -68,7 +78,7 @@ func TestIsWhitelisted(t *testing.T) {
 
 func TestCondStopOnWhitelist(t *testing.T) {
 	store := &FakeWhitelistStore{whitelist: parseCIDRs([]string{"10.0.0.1/24"})}
-	whitelister := NewIPWhitelister(store, TestingLogger, NullReporter{})
+	whitelister := NewIPWhitelister(store, nil, 0, 0, TestingLogger, NullReporter{})
 
 	condFunc := CondStopOnWhitelistFunc(whitelister)
 
@@ -103,3 +113,130 @@ func parseCIDRs(strs []string) []net.IPNet {
 
 	return out
 }
+
+func TestPrefixFromIPNet(t *testing.T) {
+	tests := []struct {
+		ipNet net.IPNet
+		want  string
+	}{
+		{ipNet: parseCIDRs([]string{"10.0.0.1/24"})[0], want: "10.0.0.0/24"},
+		{ipNet: net.IPNet{IP: net.ParseIP("10.0.0.1"), Mask: net.CIDRMask(120, 128)}, want: "10.0.0.0/24"},
+		{ipNet: parseCIDRs([]string{"2001:db8::1/32"})[0], want: "2001:db8::/32"},
+	}
+
+	for _, test := range tests {
+		prefix, ok := PrefixFromIPNet(test.ipNet)
+		if !ok || prefix.String() != test.want {
+			t.Errorf("%v expected: %v received: (%v, %v)", test.ipNet, test.want, prefix, ok)
+		}
+
+		if got := IPNetFromPrefix(prefix); got.String() != test.want {
+			t.Errorf("%v expected: %v received: %v", prefix, test.want, got.String())
+		}
+	}
+
+	if _, ok := PrefixFromIPNet(net.IPNet{}); ok {
+		t.Error("expected empty ipnet to be invalid")
+	}
+}
+
+func TestConfStoreProvidesWhitelistPrefixes(t *testing.T) {
+	c, s := newTestConfStoreWithDefaults(t, parseCIDRs([]string{"10.0.0.0/8"}), []net.IPNet{}, Limit{}, false)
+	defer s.Close()
+
+	whitelister := NewIPWhitelister(c, nil, 0, 0, TestingLogger, NullReporter{})
+	if _, ok := whitelister.provider.(*RedisConfStore); !ok {
+		t.Fatalf("expected the conf store to provide prefixes without conversion, received: %T", whitelister.provider)
+	}
+
+	if whitelisted, err := whitelister.IsWhitelisted(context.Background(), Request{RemoteAddress: "::ffff:10.1.2.3"}); err != nil || !whitelisted {
+		t.Fatalf("expected IPv4-mapped address to be whitelisted, received: (%v, %v)", whitelisted, err)
+	}
+}
+
+type fakeWhitelistPrefixStore struct {
+	prefixes []netip.Prefix
+}
+
+func (f *fakeWhitelistPrefixStore) GetWhitelist() []net.IPNet {
+	return nil
+}
+
+func (f *fakeWhitelistPrefixStore) GetWhitelistPrefixes() []netip.Prefix {
+	return f.prefixes
+}
+
+func TestIsWhitelistedNegativeCache(t *testing.T) {
+	store := &fakeWhitelistPrefixStore{prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	whitelister := NewIPWhitelister(store, nil, 10, time.Minute, TestingLogger, NullReporter{})
+	clock := &fixedClock{now: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)}
+	whitelister.negatives.clock = clock
+	req := Request{RemoteAddress: "192.168.1.2"}
+
+	isWhitelisted := func() bool {
+		whitelisted, err := whitelister.IsWhitelisted(context.Background(), req)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		return whitelisted
+	}
+
+	cached := func() bool {
+		return whitelister.negatives.contains(req.RemoteAddress, store.prefixes, nil)
+	}
+
+	if isWhitelisted() || !cached() {
+		t.Fatal("expected the address to be cached as not whitelisted")
+	}
+
+	// an equal whitelist synced again keeps the cache
+	store.prefixes = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	if !cached() {
+		t.Fatal("expected an equal whitelist to keep the cache")
+	}
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	if cached() {
+		t.Fatal("expected the cached address to expire")
+	}
+
+	isWhitelisted()
+	store.prefixes = append(store.prefixes, netip.MustParsePrefix("192.168.0.0/16"))
+	if !isWhitelisted() {
+		t.Fatal("expected a changed whitelist to clear the cache")
+	}
+}
+
+func BenchmarkIsWhitelisted(b *testing.B) {
+	cidrs := []string{}
+	for i := 0; i < 100; i++ {
+		cidrs = append(cidrs, fmt.Sprintf("10.%d.0.0/16", i))
+	}
+
+	c := NewRedisConfStore(nil, parseCIDRs(cidrs), []net.IPNet{}, Limit{}, false, nil, false, TestingLogger, NullReporter{})
+	whitelister := NewIPWhitelister(c, nil, 0, 0, TestingLogger, NullReporter{})
+	req := Request{RemoteAddress: "192.168.1.2"}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		whitelister.IsWhitelisted(context.Background(), req)
+	}
+}
+
+func BenchmarkIsWhitelistedNegativeCache(b *testing.B) {
+	cidrs := []string{}
+	for i := 0; i < 100; i++ {
+		cidrs = append(cidrs, fmt.Sprintf("10.%d.0.0/16", i))
+	}
+
+	c := NewRedisConfStore(nil, parseCIDRs(cidrs), []net.IPNet{}, Limit{}, false, nil, false, TestingLogger, NullReporter{})
+	whitelister := NewIPWhitelister(c, nil, 1000, time.Minute, TestingLogger, NullReporter{})
+	req := Request{RemoteAddress: "192.168.1.2"}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		whitelister.IsWhitelisted(context.Background(), req)
+	}
+}
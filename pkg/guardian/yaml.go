@@ -0,0 +1,574 @@
+package guardian
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// isYAMLPath returns whether path names a YAML file by its extension, .yaml or .yml
+func isYAMLPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// yamlToJSON converts a YAML document to JSON, so the JSON decoders of conf documents and policy suites, with their
+// checks for unknown fields, read YAML too. It supports the YAML that hand written documents use: block and flow
+// mappings and sequences, plain, single quoted, and double quoted scalars, literal (|) and folded (>) block scalars,
+// and comments. Plain scalars are resolved by the YAML 1.2 core schema, so yes and no are strings. Anchors, aliases,
+// tags, explicit keys, and multiple documents are rejected rather than misread.
+func yamlToJSON(b []byte) ([]byte, error) {
+	p := &yamlParser{}
+	for i, line := range strings.Split(strings.Replace(string(b), "\r\n", "\n", -1), "\n") {
+		text := strings.TrimLeft(line, " ")
+		if strings.HasPrefix(text, "\t") && !yamlBlank(text) {
+			return nil, fmt.Errorf("yaml line %d: tabs can't indent yaml", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(line) - len(text), text: strings.TrimRight(text, " \t")})
+	}
+
+	v, err := p.parseDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string // without its indentation
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// yamlBlank returns whether text is empty or only a comment
+func yamlBlank(text string) bool {
+	text = strings.TrimSpace(text)
+	return len(text) == 0 || strings.HasPrefix(text, "#")
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	num := len(p.lines)
+	if p.pos < len(p.lines) {
+		num = p.lines[p.pos].num
+	}
+	return fmt.Errorf("yaml line %d: %v", num, fmt.Sprintf(format, args...))
+}
+
+// next skips blank and comment lines, returning whether a line is left
+func (p *yamlParser) next() bool {
+	for p.pos < len(p.lines) && yamlBlank(p.lines[p.pos].text) {
+		p.pos++
+	}
+	return p.pos < len(p.lines)
+}
+
+// nextIn skips blank and comment lines, returning whether a line is left that is indented by indent and isn't a
+// document marker
+func (p *yamlParser) nextIn(indent int) bool {
+	if !p.next() || p.lines[p.pos].indent != indent {
+		return false
+	}
+	return indent > 0 || !yamlMarker(p.lines[p.pos].text, "---") && !yamlMarker(p.lines[p.pos].text, "...")
+}
+
+func (p *yamlParser) parseDocument() (interface{}, error) {
+	if p.next() && p.lines[p.pos].indent == 0 && yamlMarker(p.lines[p.pos].text, "---") {
+		p.pos++
+	}
+	if !p.next() {
+		return nil, nil
+	}
+
+	v, err := p.parseBlock(p.lines[p.pos].indent, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.next() && p.lines[p.pos].indent == 0 && yamlMarker(p.lines[p.pos].text, "...") {
+		p.pos++
+	}
+	if p.next() {
+		if p.lines[p.pos].indent == 0 && yamlMarker(p.lines[p.pos].text, "---") {
+			return nil, p.errorf("multiple yaml documents aren't supported")
+		}
+		return nil, p.errorf("unexpected indentation or content %q", p.lines[p.pos].text)
+	}
+
+	return v, nil
+}
+
+// yamlMarker returns whether text is the document marker, optionally followed by a comment
+func yamlMarker(text, marker string) bool {
+	return text == marker || strings.HasPrefix(text, marker+" ") && yamlBlank(text[len(marker):])
+}
+
+// parseBlock parses the node starting at the current line, indented by indent, within a parent node indented by
+// parent
+func (p *yamlParser) parseBlock(indent, parent int) (interface{}, error) {
+	text := p.lines[p.pos].text
+	if yamlSequenceItem(text) {
+		return p.parseSequence(indent)
+	}
+
+	if _, _, ok, err := splitYAMLKey(text); err != nil {
+		return nil, p.errorf("%v", err)
+	} else if ok {
+		return p.parseMapping(indent)
+	}
+
+	return p.parseValue(text, parent)
+}
+
+// yamlSequenceItem returns whether text is an item of a block sequence
+func yamlSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for p.nextIn(indent) && yamlSequenceItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		rest := strings.TrimLeft(line.text[1:], " ")
+
+		var item interface{}
+		var err error
+		switch {
+		case yamlBlank(rest):
+			p.pos++
+			if p.next() && p.lines[p.pos].indent > indent {
+				item, err = p.parseBlock(p.lines[p.pos].indent, indent)
+			}
+		default:
+			// the item's content is parsed as if it started a line of its own, indented past the dash
+			p.lines[p.pos] = yamlLine{num: line.num, indent: line.indent + len(line.text) - len(rest), text: rest}
+			item, err = p.parseBlock(p.lines[p.pos].indent, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	if p.next() && p.lines[p.pos].indent > indent {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return items, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.nextIn(indent) && !yamlSequenceItem(p.lines[p.pos].text) {
+		key, rest, ok, err := splitYAMLKey(p.lines[p.pos].text)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		if !ok {
+			return nil, p.errorf("expected a key but found %q", p.lines[p.pos].text)
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("key %q appears more than once", key)
+		}
+
+		var v interface{}
+		if yamlBlank(rest) {
+			p.pos++
+			switch {
+			case !p.next():
+			case p.lines[p.pos].indent > indent:
+				v, err = p.parseBlock(p.lines[p.pos].indent, indent)
+			case p.nextIn(indent) && yamlSequenceItem(p.lines[p.pos].text):
+				// a sequence may be indented as much as the key of its mapping
+				v, err = p.parseSequence(indent)
+			}
+		} else {
+			v, err = p.parseValue(strings.TrimLeft(rest, " "), indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+
+	if p.next() && p.lines[p.pos].indent > indent {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return m, nil
+}
+
+// parseValue parses the value starting with text on the current line, a scalar or a flow collection, of a node
+// within a parent indented by parent. Block scalars and flow collections continue on the lines after it.
+func (p *yamlParser) parseValue(text string, parent int) (interface{}, error) {
+	switch text[0] {
+	case '|', '>':
+		return p.parseBlockScalar(text, parent)
+	case '[', '{':
+		flow := p.gatherFlow(text)
+		f := &yamlFlow{s: flow}
+		v, err := f.parse()
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		if f.skipSpace(); !yamlBlank(f.s[f.pos:]) {
+			return nil, p.errorf("unexpected %q after flow collection", f.s[f.pos:])
+		}
+		return v, nil
+	}
+
+	v, rest, err := parseYAMLScalar(text, false)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	if !yamlBlank(rest) {
+		return nil, p.errorf("unexpected %q after value", rest)
+	}
+
+	p.pos++
+	return v, nil
+}
+
+// gatherFlow returns the flow collection starting with text, joining the lines it continues on until its brackets
+// are balanced, and moves past them
+func (p *yamlParser) gatherFlow(text string) string {
+	parts := []string{}
+	depth, quote := 0, byte(0)
+	for {
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			switch {
+			case quote == '"' && c == '\\':
+				i++
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '"' || c == '\'':
+				quote = c
+			case c == '#' && (i == 0 || text[i-1] == ' '):
+				text = text[:i]
+			case c == '[' || c == '{':
+				depth++
+			case c == ']' || c == '}':
+				depth--
+			}
+		}
+		parts = append(parts, text)
+		p.pos++
+
+		if depth <= 0 || p.pos >= len(p.lines) {
+			return strings.Join(parts, " ")
+		}
+		text = strings.TrimSpace(p.lines[p.pos].text)
+	}
+}
+
+// parseBlockScalar parses a literal or folded block scalar with the header text, whose content is indented past
+// parent
+func (p *yamlParser) parseBlockScalar(header string, parent int) (interface{}, error) {
+	if i := strings.Index(header, " #"); i >= 0 {
+		header = header[:i]
+	}
+	header = strings.TrimSpace(header)
+	if len(header) > 2 || len(header) == 2 && header[1] != '-' && header[1] != '+' {
+		return nil, p.errorf("unsupported block scalar header %q", header)
+	}
+	folded, chomp := header[0] == '>', header[1:]
+
+	lines := []string{}
+	indent := -1
+	for p.pos++; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		if len(line.text) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		if line.indent <= parent || indent >= 0 && line.indent < indent {
+			break
+		}
+		if indent < 0 {
+			indent = line.indent
+		}
+		lines = append(lines, strings.Repeat(" ", line.indent-indent)+line.text)
+	}
+
+	// trailing blank lines belong to the next node unless they're kept
+	content := len(lines)
+	for content > 0 && lines[content-1] == "" {
+		content--
+	}
+	trailing := len(lines) - content
+	lines = lines[:content]
+
+	// folding joins lines with a space, while a blank line, which replaces the line break before it, and more
+	// indented lines keep their line breaks
+	s := ""
+	for i, line := range lines {
+		prevFolds := i > 0 && lines[i-1] != "" && !strings.HasPrefix(lines[i-1], " ")
+		switch {
+		case i == 0:
+		case folded && prevFolds && line != "" && !strings.HasPrefix(line, " "):
+			s += " "
+		case folded && prevFolds && line == "":
+		default:
+			s += "\n"
+		}
+		s += line
+	}
+
+	switch {
+	case len(lines) == 0:
+	case chomp == "-":
+	case chomp == "+":
+		s += strings.Repeat("\n", trailing+1)
+	default:
+		s += "\n"
+	}
+	return s, nil
+}
+
+// splitYAMLKey splits text into the key of a mapping entry and the rest of the line after its colon, returning
+// whether text is a mapping entry
+func splitYAMLKey(text string) (string, string, bool, error) {
+	if text[0] == '"' || text[0] == '\'' {
+		key, rest, err := parseYAMLQuoted(text)
+		if err != nil {
+			return "", "", false, err
+		}
+		rest = strings.TrimLeft(rest, " ")
+		if !strings.HasPrefix(rest, ":") || len(rest) > 1 && rest[1] != ' ' {
+			return "", "", false, nil
+		}
+		return key, rest[1:], true, nil
+	}
+
+	if strings.ContainsRune("[{|>#", rune(text[0])) {
+		return "", "", false, nil
+	}
+	if strings.ContainsRune("?&*!%@`", rune(text[0])) {
+		return "", "", false, fmt.Errorf("unsupported yaml indicator %q", text[0])
+	}
+
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '#' && i > 0 && text[i-1] == ' ':
+			return "", "", false, nil
+		case text[i] == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return strings.TrimRight(text[:i], " "), text[i+1:], true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// parseYAMLScalar parses the scalar starting text, returning it and the rest of text after it. Plain scalars end at
+// a comment, and in flow collections at a flow indicator or a colon followed by a space.
+func parseYAMLScalar(text string, flow bool) (interface{}, string, error) {
+	if text[0] == '"' || text[0] == '\'' {
+		s, rest, err := parseYAMLQuoted(text)
+		return s, rest, err
+	}
+
+	if strings.ContainsRune("&*!?%@`|>", rune(text[0])) || flow && strings.ContainsRune("[{", rune(text[0])) {
+		return nil, "", fmt.Errorf("unsupported yaml indicator %q", text[0])
+	}
+
+	end := len(text)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '#' && i > 0 && text[i-1] == ' ' ||
+			flow && (c == ',' || c == ']' || c == '}' || c == ':' && (i == len(text)-1 || strings.ContainsRune(" ,]}", rune(text[i+1])))) {
+			end = i
+			break
+		}
+	}
+
+	v, err := resolveYAMLPlain(strings.TrimRight(text[:end], " "))
+	return v, text[end:], err
+}
+
+// parseYAMLQuoted parses the single or double quoted scalar starting text, returning it and the rest of text after it
+func parseYAMLQuoted(text string) (string, string, error) {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '\'' && text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case quote == '\'' && text[i] == '\'':
+			return strings.Replace(text[1:i], "''", "'", -1), text[i+1:], nil
+		case quote == '"' && text[i] == '\\':
+			i++
+		case quote == '"' && text[i] == '"':
+			s, err := unquoteYAML(text[:i+1])
+			return s, text[i+1:], err
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quoted string %v", text)
+}
+
+// unquoteYAML unquotes a double quoted scalar, whose escapes are Go's along with \/, \e, and an escaped space
+func unquoteYAML(quoted string) (string, error) {
+	quoted = strings.NewReplacer(`\/`, `/`, `\e`, `\x1b`, `\ `, ` `).Replace(quoted)
+	s, err := strconv.Unquote(quoted)
+	if err != nil {
+		return "", fmt.Errorf("invalid double quoted string %v", quoted)
+	}
+	return s, nil
+}
+
+var (
+	yamlInt        = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloat      = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+	yamlJSONNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+)
+
+// resolveYAMLPlain resolves a plain scalar to null, a bool, a number, or a string by the YAML 1.2 core schema
+func resolveYAMLPlain(s string) (interface{}, error) {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case ".inf", ".Inf", ".INF", "+.inf", "+.Inf", "+.INF", "-.inf", "-.Inf", "-.INF", ".nan", ".NaN", ".NAN":
+		return nil, fmt.Errorf("%v can't be represented in a conf document", s)
+	}
+
+	switch {
+	case yamlJSONNumber.MatchString(s):
+		return json.Number(s), nil
+	case yamlInt.MatchString(s):
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %v", s)
+		}
+		return json.Number(strconv.FormatInt(n, 10)), nil
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0o"):
+		base := 16
+		if s[1] == 'o' {
+			base = 8
+		}
+		if n, err := strconv.ParseUint(s[2:], base, 64); err == nil {
+			return json.Number(strconv.FormatUint(n, 10)), nil
+		}
+	case yamlFloat.MatchString(s):
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("invalid number %v", s)
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	}
+
+	return s, nil
+}
+
+// yamlFlow parses a flow collection, such as [a, b] or {a: 1}, gathered onto a single line
+type yamlFlow struct {
+	s   string
+	pos int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.s) && f.s[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) parse() (interface{}, error) {
+	f.skipSpace()
+	if f.pos >= len(f.s) {
+		return nil, fmt.Errorf("unterminated flow collection")
+	}
+
+	switch f.s[f.pos] {
+	case '[':
+		return f.parseCollection(']')
+	case '{':
+		return f.parseCollection('}')
+	}
+
+	v, rest, err := parseYAMLScalar(f.s[f.pos:], true)
+	f.pos = len(f.s) - len(rest)
+	return v, err
+}
+
+// parseCollection parses the flow sequence or mapping at pos, closed by end
+func (f *yamlFlow) parseCollection(end byte) (interface{}, error) {
+	f.pos++
+	items, m := []interface{}{}, map[string]interface{}{}
+	for {
+		f.skipSpace()
+		if f.pos >= len(f.s) {
+			return nil, fmt.Errorf("unterminated flow collection")
+		}
+		if f.s[f.pos] == end {
+			f.pos++
+			break
+		}
+
+		if end == ']' {
+			item, err := f.parse()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		} else {
+			key, err := f.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := m[key]; dup {
+				return nil, fmt.Errorf("key %q appears more than once", key)
+			}
+			if m[key], err = f.parse(); err != nil {
+				return nil, err
+			}
+		}
+
+		f.skipSpace()
+		switch {
+		case f.pos < len(f.s) && f.s[f.pos] == ',':
+			f.pos++
+		case f.pos < len(f.s) && f.s[f.pos] == end:
+		default:
+			return nil, fmt.Errorf("expected %q or %q in flow collection at %q", ",", end, f.s[f.pos:])
+		}
+	}
+
+	if end == ']' {
+		return items, nil
+	}
+	return m, nil
+}
+
+// parseKey parses the key of a flow mapping entry and its colon
+func (f *yamlFlow) parseKey() (string, error) {
+	v, rest, err := parseYAMLScalar(f.s[f.pos:], true)
+	if err != nil {
+		return "", err
+	}
+	f.pos = len(f.s) - len(rest)
+
+	f.skipSpace()
+	if f.pos >= len(f.s) || f.s[f.pos] != ':' {
+		return "", fmt.Errorf("expected %q after flow mapping key", ":")
+	}
+	f.pos++
+
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, _ := json.Marshal(v)
+	return string(b), nil
+}
@@ -0,0 +1,146 @@
+package guardian
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{name: "Empty", yaml: "# nothing here\n", want: `null`},
+		{name: "Scalars", yaml: `
+string: scrapers
+spaced: a plain string # with a comment
+quoted: "a \"double\" quoted\tstring # not a comment"
+single: 'it''s'
+int: 10
+plus: +5
+zeros: 007
+hex: 0x1f
+float: .5
+exponent: 1e3
+bool: true
+capitalized: False
+yes: no
+null: ~
+empty:
+colon: a:b
+url: http://example.com/a#b
+expr: req.path.startsWith("/admin") && req.method == "POST"
+`, want: `{"string": "scrapers", "spaced": "a plain string", "quoted": "a \"double\" quoted\tstring # not a comment",
+	"single": "it's", "int": 10, "plus": 5, "zeros": 7, "hex": 31, "float": 0.5, "exponent": 1000, "bool": true,
+	"capitalized": false, "yes": "no", "null": null, "empty": null, "colon": "a:b", "url": "http://example.com/a#b",
+	"expr": "req.path.startsWith(\"/admin\") && req.method == \"POST\""}`},
+		{name: "Nested", yaml: `---
+cases:
+- name: admin
+  request:
+    remote_address: 192.168.1.2
+    headers:
+      x-api-key: abc
+  expect: {decision: block, reason: "rule:block-admin"}
+-   name: posts
+    repeat: 3
+    expect:
+      decision: allow
+rules:
+  list:
+    - - nested
+      - 1
+    -
+      key: value
+    - []
+...
+`, want: `{"cases": [
+	{"name": "admin", "request": {"remote_address": "192.168.1.2", "headers": {"x-api-key": "abc"}}, "expect": {"decision": "block", "reason": "rule:block-admin"}},
+	{"name": "posts", "repeat": 3, "expect": {"decision": "allow"}}
+], "rules": {"list": [["nested", 1], {"key": "value"}, []]}}`},
+		{name: "Flow", yaml: `
+whitelist: [10.0.0.0/8, "192.168.0.0/16",
+  # a comment between lines
+  172.16.0.0/12]
+limit: {count: 10, "duration": 1m, enabled: true, tags: [a, b], empty: {}}
+trailing: [a, b, ]
+`, want: `{"whitelist": ["10.0.0.0/8", "192.168.0.0/16", "172.16.0.0/12"], "limit": {"count": 10, "duration": "1m", "enabled": true, "tags": ["a", "b"], "empty": {}}, "trailing": ["a", "b"]}`},
+		{name: "JSON", yaml: `{
+  "limit": {"count": 10, "duration": "1m", "enabled": true},
+  "blacklist": ["192.168.9.0/24"]
+}`, want: `{"limit": {"count": 10, "duration": "1m", "enabled": true}, "blacklist": ["192.168.9.0/24"]}`},
+		{name: "BlockScalars", yaml: `
+literal: |
+  line one
+    indented
+
+  line three
+stripped: |-
+  no newline
+kept: |+
+  kept
+
+folded: >
+  folded
+  lines
+
+  paragraph
+`, want: `{"literal": "line one\n  indented\n\nline three\n", "stripped": "no newline", "kept": "kept\n\n", "folded": "folded lines\nparagraph\n"}`},
+		{name: "SequenceOfScalars", yaml: "- a\n- |\n  block\n- \"quoted\"\n-\n", want: `["a", "block\n", "quoted", null]`},
+		{name: "Scalar", yaml: "'.5'", want: `".5"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := yamlToJSON([]byte(test.yaml))
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			var got, want interface{}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("error decoding %s: %v", b, err)
+			}
+			if err := json.Unmarshal([]byte(test.want), &want); err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("unexpected json (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestYAMLToJSONRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{name: "Anchor", yaml: "a: &x 1\nb: *x\n"},
+		{name: "Tag", yaml: "a: !!str 1\n"},
+		{name: "ExplicitKey", yaml: "? a\n: b\n"},
+		{name: "MultipleDocuments", yaml: "a: 1\n---\nb: 2\n"},
+		{name: "Tabs", yaml: "a:\n\tb: 1\n"},
+		{name: "DuplicateKey", yaml: "a: 1\na: 2\n"},
+		{name: "DuplicateFlowKey", yaml: "a: {b: 1, b: 2}\n"},
+		{name: "BadIndentation", yaml: "a:\n    b: 1\n  c: 2\n"},
+		{name: "MixedBlock", yaml: "a: 1\n- b\n"},
+		{name: "UnterminatedQuote", yaml: "a: \"b\n"},
+		{name: "UnterminatedFlow", yaml: "a: [b, c\n"},
+		{name: "ContinuedPlainScalar", yaml: "a: b\n  c\n"},
+		{name: "Infinity", yaml: "a: .inf\n"},
+		{name: "TrailingContent", yaml: "a: \"b\" c\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if b, err := yamlToJSON([]byte(test.yaml)); err == nil {
+				t.Fatalf("expected error but received %s", b)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+// Package guardianclient is a client for requesting rate limit decisions from Guardian over the rate limit service
+// protocol, for Go services that aren't behind Envoy.
+//
+//	client, err := guardianclient.Dial("guardian:3000", guardianclient.DefaultConfig())
+//	decision, err := client.ShouldRateLimit(ctx, guardian.Request{RemoteAddress: ip, Path: path})
+//	if decision.Blocked { ... }
+package guardianclient
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/dollarshaveclub/guardian/pkg/guardian"
+	"github.com/dollarshaveclub/guardian/pkg/rate_limit_grpc"
+)
+
+const maxCachedDecisions = 10000
+
+// Config configures a Client
+type Config struct {
+	// Domain is the rate limit domain sent with every request
+	Domain string
+	// Timeout bounds each attempt to get a decision
+	Timeout time.Duration
+	// Retries is the number of additional attempts made when Guardian is unavailable or times out
+	Retries int
+	// RetryBackoff is the time waited between attempts
+	RetryBackoff time.Duration
+	// CacheTTL is how long a blocked decision is remembered and reused if Guardian can't be reached.
+	// When no decision is cached the client fails open.
+	CacheTTL time.Duration
+}
+
+// DefaultConfig returns a Config with sensible defaults
+func DefaultConfig() Config {
+	return Config{
+		Domain:       "guardian_client",
+		Timeout:      50 * time.Millisecond,
+		Retries:      1,
+		RetryBackoff: 10 * time.Millisecond,
+		CacheTTL:     time.Second,
+	}
+}
+
+// Decision is a rate limit decision
+type Decision struct {
+	Blocked   bool
+	Remaining uint32
+	// FailedOpen is true when Guardian could not be reached and the decision was made locally
+	FailedOpen bool
+	// Response is the static response Guardian asked a blocked request be answered with instead of a 429, nil if none
+	Response *guardian.StaticResponse
+	// Reason is why Guardian blocked the request, in the format of the guardian.BlockReasonHeader, empty if not given
+	Reason string
+	// Cached is true when Guardian hinted that an earlier blocked decision for the same request was still valid, so it
+	// wasn't asked again
+	Cached bool
+}
+
+// Dial connects to the Guardian at address and returns a Client
+func Dial(address string, config Config) (*Client, error) {
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		return nil, errors.Wrap(err, "error dialing guardian")
+	}
+
+	return NewClient(conn, config), nil
+}
+
+// NewClient creates a new Client using conn
+func NewClient(conn *grpc.ClientConn, config Config) *Client {
+	return &Client{conn: conn, config: config, cache: make(map[string]cachedDecision)}
+}
+
+type cachedDecision struct {
+	decision Decision
+	expireAt time.Time
+	// validUntil is when Guardian hinted the decision stops being valid, zero if it didn't
+	validUntil time.Time
+}
+
+// Client requests rate limit decisions from Guardian
+type Client struct {
+	conn   *grpc.ClientConn
+	config Config
+
+	mu    sync.Mutex
+	cache map[string]cachedDecision
+}
+
+// ShouldRateLimit requests a decision for req. Requests Guardian hinted will stay blocked are blocked locally without
+// asking Guardian again. If Guardian can't be reached the client fails open, unless it recently blocked the same
+// request, and the error is returned alongside the local decision.
+func (c *Client) ShouldRateLimit(ctx context.Context, req guardian.Request) (Decision, error) {
+	key := cacheKey(req)
+	if decision, ok := c.stillBlocked(key); ok {
+		return decision, nil
+	}
+
+	rlreq := guardian.RateLimitRequestFromRequest(c.config.Domain, req)
+
+	var err error
+	for attempt := 0; attempt <= c.config.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(c.config.RetryBackoff):
+			case <-ctx.Done():
+				return c.failOpen(key), ctx.Err()
+			}
+		}
+
+		var res *ratelimit.RateLimitResponse
+		var header metadata.MD
+		res, header, err = c.invoke(ctx, rlreq)
+		if err == nil {
+			decision := decisionFromResponse(res)
+			decision.Response = staticResponseFromHeader(header)
+			decision.Reason = reasonFromHeader(header)
+			c.remember(key, decision, blockedForFromHeader(header))
+			return decision, nil
+		}
+
+		if !retryable(err) {
+			break
+		}
+	}
+
+	return c.failOpen(key), errors.Wrap(err, "error requesting decision from guardian")
+}
+
+// Close closes the underlying connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// invoke requests a decision from Guardian, returning it along with the response headers hinting about it
+func (c *Client) invoke(ctx context.Context, rlreq *ratelimit.RateLimitRequest) (*ratelimit.RateLimitResponse, metadata.MD, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	res := &ratelimit.RateLimitResponse{}
+	header := metadata.MD{}
+	if err := c.conn.Invoke(ctx, rate_limit_grpc.ShouldRateLimitFullMethod, rlreq, res, grpc.Header(&header)); err != nil {
+		return nil, nil, err
+	}
+
+	return res, header, nil
+}
+
+// stillBlocked returns the cached decision for key if Guardian hinted it is still valid
+func (c *Client) stillBlocked(key string) (Decision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.cache[key]
+	if !ok || !cached.validUntil.After(time.Now()) {
+		return Decision{}, false
+	}
+
+	decision := cached.decision
+	decision.Cached = true
+	return decision, true
+}
+
+func (c *Client) remember(key string, decision Decision, blockedFor time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !decision.Blocked {
+		delete(c.cache, key)
+		return
+	}
+
+	if len(c.cache) >= maxCachedDecisions {
+		now := time.Now()
+		for k, v := range c.cache {
+			if v.expireAt.Before(now) {
+				delete(c.cache, k)
+			}
+		}
+
+		if len(c.cache) >= maxCachedDecisions {
+			return
+		}
+	}
+
+	now := time.Now()
+	cached := cachedDecision{decision: decision, expireAt: now.Add(c.config.CacheTTL)}
+	if blockedFor > 0 {
+		cached.validUntil = now.Add(blockedFor)
+		if cached.validUntil.After(cached.expireAt) {
+			cached.expireAt = cached.validUntil
+		}
+	}
+
+	c.cache[key] = cached
+}
+
+func (c *Client) failOpen(key string) Decision {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.cache[key]
+	if ok && cached.expireAt.After(time.Now()) {
+		decision := cached.decision
+		decision.FailedOpen = true
+		return decision
+	}
+
+	return Decision{Blocked: false, Remaining: guardian.RequestsRemainingMax, FailedOpen: true}
+}
+
+func decisionFromResponse(res *ratelimit.RateLimitResponse) Decision {
+	remaining := guardian.RequestsRemainingMax
+	for _, status := range res.GetStatuses() {
+		if status.GetLimitRemaining() < remaining {
+			remaining = status.GetLimitRemaining()
+		}
+	}
+
+	return Decision{Blocked: res.GetOverallCode() == ratelimit.RateLimitResponse_OVER_LIMIT, Remaining: remaining}
+}
+
+// blockedForFromHeader returns how long the decision was hinted to stay blocked for by the guardian.BlockedForHeader,
+// zero if it is missing or invalid
+func blockedForFromHeader(header metadata.MD) time.Duration {
+	values := header[guardian.BlockedForHeader]
+	if len(values) == 0 {
+		return 0
+	}
+
+	ms, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil || ms <= 0 {
+		return 0
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// staticResponseFromHeader returns the static response given by the guardian.StaticResponseStatusHeader,
+// guardian.StaticResponseBodyHeader, and guardian.StaticResponseLocationHeader, nil if there is none
+func staticResponseFromHeader(header metadata.MD) *guardian.StaticResponse {
+	statuses := header[guardian.StaticResponseStatusHeader]
+	if len(statuses) == 0 {
+		return nil
+	}
+
+	status, err := strconv.Atoi(statuses[0])
+	if err != nil {
+		return nil
+	}
+
+	response := &guardian.StaticResponse{Status: status}
+	if bodies := header[guardian.StaticResponseBodyHeader]; len(bodies) > 0 {
+		response.Body = bodies[0]
+	}
+
+	if locations := header[guardian.StaticResponseLocationHeader]; len(locations) > 0 {
+		response.Location = locations[0]
+	}
+
+	return response
+}
+
+// reasonFromHeader returns the block reason given by the guardian.BlockReasonHeader, empty if there is none
+func reasonFromHeader(header metadata.MD) string {
+	if reasons := header[guardian.BlockReasonHeader]; len(reasons) > 0 {
+		return reasons[0]
+	}
+
+	return ""
+}
+
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+
+	return false
+}
+
+func cacheKey(req guardian.Request) string {
+	return req.RemoteAddress + "|" + req.Authority + "|" + req.Method + "|" + req.Path
+}
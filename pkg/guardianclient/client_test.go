@@ -0,0 +1,221 @@
+package guardianclient
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"github.com/dollarshaveclub/guardian/pkg/guardian"
+	"github.com/dollarshaveclub/guardian/pkg/rate_limit_grpc"
+)
+
+type fakeRateLimitServer struct {
+	received []guardian.Request
+	code     ratelimit.RateLimitResponse_Code
+}
+
+func (f *fakeRateLimitServer) ShouldRateLimit(ctx context.Context, req *ratelimit.RateLimitRequest) (*ratelimit.RateLimitResponse, error) {
+	f.received = append(f.received, guardian.RequestFromRateLimitRequest(req))
+	status := &ratelimit.RateLimitResponse_DescriptorStatus{Code: f.code, LimitRemaining: 7}
+	return &ratelimit.RateLimitResponse{OverallCode: f.code, Statuses: []*ratelimit.RateLimitResponse_DescriptorStatus{status}}, nil
+}
+
+func newTestServer(t *testing.T, fake *fakeRateLimitServer) (string, *grpc.Server) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	srv := rate_limit_grpc.NewRateLimitServer(fake)
+	go srv.Serve(l)
+	return l.Addr().String(), srv
+}
+
+func TestShouldRateLimit(t *testing.T) {
+	fake := &fakeRateLimitServer{code: ratelimit.RateLimitResponse_OVER_LIMIT}
+	addr, srv := newTestServer(t, fake)
+	defer srv.Stop()
+
+	client, err := Dial(addr, DefaultConfig())
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer client.Close()
+
+	config := client.config
+	config.Timeout = time.Second
+	client.config = config
+
+	req := guardian.Request{RemoteAddress: "10.0.0.1", Path: "/foo", Headers: map[string]string{}}
+	decision, err := client.ShouldRateLimit(context.Background(), req)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	want := Decision{Blocked: true, Remaining: 7}
+	if decision != want {
+		t.Fatalf("expected: %v received: %v", want, decision)
+	}
+
+	if len(fake.received) != 1 || fake.received[0].RemoteAddress != "10.0.0.1" || fake.received[0].Path != "/foo" {
+		t.Fatalf("unexpected requests received by server: %v", fake.received)
+	}
+}
+
+func TestShouldRateLimitFailsOpenWithCachedBlock(t *testing.T) {
+	fake := &fakeRateLimitServer{code: ratelimit.RateLimitResponse_OVER_LIMIT}
+	addr, srv := newTestServer(t, fake)
+
+	config := DefaultConfig()
+	config.Timeout = time.Second
+	config.CacheTTL = time.Minute
+	client, err := Dial(addr, config)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer client.Close()
+
+	blockedReq := guardian.Request{RemoteAddress: "10.0.0.1"}
+	if _, err := client.ShouldRateLimit(context.Background(), blockedReq); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	srv.Stop()
+	client.config.Timeout = 50 * time.Millisecond
+
+	decision, err := client.ShouldRateLimit(context.Background(), blockedReq)
+	if err == nil {
+		t.Fatal("expected error but received nil")
+	}
+
+	if !decision.Blocked || !decision.FailedOpen {
+		t.Fatalf("expected cached block, received: %v", decision)
+	}
+
+	decision, _ = client.ShouldRateLimit(context.Background(), guardian.Request{RemoteAddress: "10.0.0.2"})
+	if decision.Blocked || !decision.FailedOpen {
+		t.Fatalf("expected to fail open, received: %v", decision)
+	}
+}
+
+type neverReportOnly struct{}
+
+func (neverReportOnly) GetReportOnly() bool {
+	return false
+}
+
+// newTestGuardian serves a Guardian server deciding with blocker and returns a client connected to it
+func newTestGuardian(t *testing.T, blocker guardian.RequestBlockerFunc, maxBlockedHint time.Duration) (*Client, *grpc.Server) {
+	t.Helper()
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	server := guardian.NewServer(blocker, neverReportOnly{}, "", maxBlockedHint, logger, guardian.NullReporter{})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	srv := rate_limit_grpc.NewRateLimitServer(server)
+	go srv.Serve(l)
+
+	config := DefaultConfig()
+	config.Timeout = time.Second
+	client, err := Dial(l.Addr().String(), config)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	return client, srv
+}
+
+func TestShouldRateLimitHonorsBlockedHint(t *testing.T) {
+	calls := 0
+	blocker := func(ctx context.Context, req guardian.Request) (bool, uint32, error) {
+		calls++
+		guardian.DecisionHintFromContext(ctx).BlockedFor(time.Hour)
+		return true, 0, nil
+	}
+
+	// the hint is capped to the server's max
+	client, srv := newTestGuardian(t, blocker, 200*time.Millisecond)
+	defer srv.Stop()
+	defer client.Close()
+
+	req := guardian.Request{RemoteAddress: "10.0.0.1"}
+	tests := []struct {
+		sleep time.Duration
+		want  Decision
+		calls int
+	}{
+		{want: Decision{Blocked: true}, calls: 1},
+		{want: Decision{Blocked: true, Cached: true}, calls: 1},
+		{sleep: 250 * time.Millisecond, want: Decision{Blocked: true}, calls: 2},
+	}
+
+	for i, test := range tests {
+		time.Sleep(test.sleep)
+		decision, err := client.ShouldRateLimit(context.Background(), req)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if decision != test.want || calls != test.calls {
+			t.Fatalf("%d expected: %v after %d calls received: %v after %d calls", i, test.want, test.calls, decision, calls)
+		}
+	}
+}
+
+func TestShouldRateLimitReturnsStaticResponse(t *testing.T) {
+	response := guardian.StaticResponse{Status: 200, Body: "\x00honeypot"}
+	blocker := func(ctx context.Context, req guardian.Request) (bool, uint32, error) {
+		guardian.DecisionHintFromContext(ctx).Serve(response)
+		guardian.DecisionHintFromContext(ctx).Block(guardian.BlockReason{Kind: guardian.RuleBlockReason, Name: "honeypot"})
+		return true, 0, nil
+	}
+
+	client, srv := newTestGuardian(t, blocker, 0)
+	defer srv.Stop()
+	defer client.Close()
+
+	decision, err := client.ShouldRateLimit(context.Background(), guardian.Request{RemoteAddress: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if !decision.Blocked || decision.Response == nil || *decision.Response != response {
+		t.Fatalf("expected blocked decision with response %v received: %v", response, decision)
+	}
+
+	if decision.Reason != "rule:honeypot" {
+		t.Fatalf("expected reason: %v received: %v", "rule:honeypot", decision.Reason)
+	}
+}
+
+func TestShouldRateLimitReturnsChallengeRedirect(t *testing.T) {
+	response := guardian.StaticResponse{Status: guardian.ChallengeResponseStatus, Location: "https://captcha.example.com/?return_to=%2F"}
+	blocker := func(ctx context.Context, req guardian.Request) (bool, uint32, error) {
+		guardian.DecisionHintFromContext(ctx).Serve(response)
+		return true, 0, nil
+	}
+
+	client, srv := newTestGuardian(t, blocker, 0)
+	defer srv.Stop()
+	defer client.Close()
+
+	decision, err := client.ShouldRateLimit(context.Background(), guardian.Request{RemoteAddress: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if !decision.Blocked || decision.Response == nil || *decision.Response != response {
+		t.Fatalf("expected blocked decision with response %v received: %v", response, decision)
+	}
+}
@@ -0,0 +1,82 @@
+package guardiantest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dollarshaveclub/guardian/pkg/guardian"
+)
+
+var testingLogger = &logrus.Logger{Out: ioutil.Discard}
+
+func TestFakesDecideRequests(t *testing.T) {
+	conf := NewFakeConfStore()
+	conf.SetLimit(guardian.Limit{Count: 1, Duration: time.Minute, Enabled: true})
+	_, blacklisted, _ := net.ParseCIDR("11.0.0.1/32")
+	conf.SetBlacklist([]net.IPNet{*blacklisted})
+
+	store := NewFakeLimitStore(guardian.Limit{})
+	reporter := NewFakeReporter()
+	whitelister := guardian.NewIPWhitelister(conf, nil, 0, 0, testingLogger, reporter)
+	blacklister := guardian.NewIPBlacklister(conf, testingLogger, reporter)
+	rateLimiter := guardian.NewIPRateLimiter(conf, store, guardian.LocalClock{}, nil, testingLogger, reporter)
+	blocker := guardian.DefaultCondChain(whitelister, blacklister, rateLimiter)
+
+	tests := []struct {
+		remoteAddress string
+		blocked       bool
+	}{
+		{remoteAddress: "11.0.0.1", blocked: true},
+		{remoteAddress: "12.0.0.1", blocked: false},
+		{remoteAddress: "12.0.0.1", blocked: true},
+	}
+
+	for i, test := range tests {
+		blocked, _, err := blocker(context.Background(), guardian.Request{RemoteAddress: test.remoteAddress})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+
+		if blocked != test.blocked {
+			t.Errorf("request %d expected blocked: %v received: %v", i, test.blocked, blocked)
+		}
+	}
+
+	if incrs := store.Incrs(); len(incrs) != 2 || incrs[0].Key != incrs[1].Key {
+		t.Errorf("expected the rate limited address to be counted twice under the same key, received: %v", incrs)
+	}
+
+	ratelimited := reporter.CallsTo("HandledRatelimit")
+	if len(ratelimited) != 2 || ratelimited[1].Args[1] != true {
+		t.Errorf("expected the second request of the address to be reported rate limited, received: %v", ratelimited)
+	}
+
+	if got := len(reporter.CallsTo("HandledBlacklist")); got != 3 {
+		t.Errorf("expected every request to be reported by the blacklister, received: %v", got)
+	}
+}
+
+func TestFakeLimitStoreErr(t *testing.T) {
+	store := NewFakeLimitStore(guardian.Limit{Count: 1, Duration: time.Minute, Enabled: true})
+	store.SetErr(fmt.Errorf("unavailable"))
+
+	if _, _, err := store.Incr(context.Background(), "key", 1, 1, time.Minute); err == nil {
+		t.Error("expected the injected error")
+	}
+
+	store.SetErr(nil)
+	if count, _, err := store.Incr(context.Background(), "key", 1, 1, time.Minute); err != nil || count != 1 {
+		t.Errorf("expected count 1, received: %v err: %v", count, err)
+	}
+
+	store.Reset()
+	if len(store.Counts()) != 0 || len(store.Incrs()) != 0 {
+		t.Errorf("expected reset to clear counts and calls, received: %v %v", store.Counts(), store.Incrs())
+	}
+}
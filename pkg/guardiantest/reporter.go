@@ -0,0 +1,219 @@
+package guardiantest
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/dollarshaveclub/guardian/pkg/guardian"
+)
+
+var _ guardian.MetricReporter = (*FakeReporter)(nil)
+
+// ReporterCall records a call to a guardian.MetricReporter method, with its arguments in order
+type ReporterCall struct {
+	Method string
+	Args   []interface{}
+}
+
+// NewFakeReporter creates a new FakeReporter
+func NewFakeReporter() *FakeReporter {
+	return &FakeReporter{}
+}
+
+// FakeReporter is a guardian.MetricReporter recording every call made to it
+type FakeReporter struct {
+	mu    sync.Mutex
+	calls []ReporterCall
+}
+
+// Calls returns the calls made in order
+func (f *FakeReporter) Calls() []ReporterCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]ReporterCall{}, f.calls...)
+}
+
+// CallsTo returns the calls made to method in order
+func (f *FakeReporter) CallsTo(method string) []ReporterCall {
+	calls := []ReporterCall{}
+	for _, call := range f.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
+
+// Reset clears the recorded calls
+func (f *FakeReporter) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = nil
+}
+
+func (f *FakeReporter) record(method string, args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, ReporterCall{Method: method, Args: args})
+}
+
+func (f *FakeReporter) Duration(request guardian.Request, rule *guardian.Rule, blocked bool, errorOccurred bool, duration time.Duration) {
+	f.record("Duration", request, rule, blocked, errorOccurred, duration)
+}
+
+func (f *FakeReporter) HandledWhitelist(request guardian.Request, whitelisted bool, errorOccurred bool, duration time.Duration) {
+	f.record("HandledWhitelist", request, whitelisted, errorOccurred, duration)
+}
+
+func (f *FakeReporter) HandledBlacklist(request guardian.Request, blacklisted bool, errorOccurred bool, duration time.Duration) {
+	f.record("HandledBlacklist", request, blacklisted, errorOccurred, duration)
+}
+
+func (f *FakeReporter) HandledRatelimit(request guardian.Request, ratelimited bool, errorOccurred bool, duration time.Duration) {
+	f.record("HandledRatelimit", request, ratelimited, errorOccurred, duration)
+}
+
+func (f *FakeReporter) HandledRatelimitCanary(request guardian.Request, enforced bool) {
+	f.record("HandledRatelimitCanary", request, enforced)
+}
+
+func (f *FakeReporter) LimitExperiment(request guardian.Request, primary guardian.Limit, primaryBlocked bool, alternate guardian.Limit, alternateBlocked bool) {
+	f.record("LimitExperiment", request, primary, primaryBlocked, alternate, alternateBlocked)
+}
+
+func (f *FakeReporter) HandledRule(request guardian.Request, rule guardian.Rule, blocked bool, errorOccurred bool, duration time.Duration) {
+	f.record("HandledRule", request, rule, blocked, errorOccurred, duration)
+}
+
+func (f *FakeReporter) ObservedRule(request guardian.Request, rule guardian.Rule, count uint64, overLimit bool) {
+	f.record("ObservedRule", request, rule, count, overLimit)
+}
+
+func (f *FakeReporter) RuleKeyCardinalityExceeded(rule guardian.Rule, estimate uint64) {
+	f.record("RuleKeyCardinalityExceeded", rule, estimate)
+}
+
+func (f *FakeReporter) HandledRouteRatelimit(request guardian.Request, route string, ratelimited bool, errorOccurred bool, duration time.Duration) {
+	f.record("HandledRouteRatelimit", request, route, ratelimited, errorOccurred, duration)
+}
+
+func (f *FakeReporter) Bulkhead(name string, saturation float64, rejected bool) {
+	f.record("Bulkhead", name, saturation, rejected)
+}
+
+func (f *FakeReporter) RedisCounterIncr(duration time.Duration, errorOccurred bool) {
+	f.record("RedisCounterIncr", duration, errorOccurred)
+}
+
+func (f *FakeReporter) RedisCounterHedged(command string, hedgeWon bool) {
+	f.record("RedisCounterHedged", command, hedgeWon)
+}
+
+func (f *FakeReporter) RedisCounterPruned(duration time.Duration, cacheSize float64, prunedCounted float64) {
+	f.record("RedisCounterPruned", duration, cacheSize, prunedCounted)
+}
+
+func (f *FakeReporter) RedisMemoryBudget(used float64, budget float64, shortened float64, errorOccurred bool) {
+	f.record("RedisMemoryBudget", used, budget, shortened, errorOccurred)
+}
+
+func (f *FakeReporter) RedisCounterJanitor(duration time.Duration, scanned float64, orphans float64, errorOccurred bool) {
+	f.record("RedisCounterJanitor", duration, scanned, orphans, errorOccurred)
+}
+
+func (f *FakeReporter) RedisCounterSpillMerged(duration time.Duration, merged float64, errorOccurred bool) {
+	f.record("RedisCounterSpillMerged", duration, merged, errorOccurred)
+}
+
+func (f *FakeReporter) ReputationLookup(duration time.Duration, errorOccurred bool) {
+	f.record("ReputationLookup", duration, errorOccurred)
+}
+
+func (f *FakeReporter) PlanLookup(duration time.Duration, errorOccurred bool) {
+	f.record("PlanLookup", duration, errorOccurred)
+}
+
+func (f *FakeReporter) WhitelistHostLookup(duration time.Duration, errorOccurred bool) {
+	f.record("WhitelistHostLookup", duration, errorOccurred)
+}
+
+func (f *FakeReporter) DecisionStreamDropped(dropped int) {
+	f.record("DecisionStreamDropped", dropped)
+}
+
+func (f *FakeReporter) DecisionCacheLookup(hit bool) {
+	f.record("DecisionCacheLookup", hit)
+}
+
+func (f *FakeReporter) ExemptedRequest(exemption string) {
+	f.record("ExemptedRequest", exemption)
+}
+
+func (f *FakeReporter) TrafficWindow(route string, requests uint64, uniqueKeys uint64) {
+	f.record("TrafficWindow", route, requests, uniqueKeys)
+}
+
+func (f *FakeReporter) KeyDecisions(rule string, key string, allowed uint64, blocked uint64) {
+	f.record("KeyDecisions", rule, key, allowed, blocked)
+}
+
+func (f *FakeReporter) UnknownDomain(domain string, action guardian.UnknownDomainAction) {
+	f.record("UnknownDomain", domain, action)
+}
+
+func (f *FakeReporter) InvalidRequest(reason guardian.InvalidRequestReason, action guardian.InvalidRequestAction) {
+	f.record("InvalidRequest", reason, action)
+}
+
+func (f *FakeReporter) ListDecision(decision guardian.ListDecision, errorOccurred bool) {
+	f.record("ListDecision", decision, errorOccurred)
+}
+
+func (f *FakeReporter) SelfTest(duration time.Duration, passed bool) {
+	f.record("SelfTest", duration, passed)
+}
+
+func (f *FakeReporter) ThrottleWebhook(notifications int, errorOccurred bool) {
+	f.record("ThrottleWebhook", notifications, errorOccurred)
+}
+
+func (f *FakeReporter) CurrentLimit(limit guardian.Limit) {
+	f.record("CurrentLimit", limit)
+}
+
+func (f *FakeReporter) CurrentWhitelist(whitelist []netip.Prefix) {
+	f.record("CurrentWhitelist", whitelist)
+}
+
+func (f *FakeReporter) CurrentBlacklist(blacklist []netip.Prefix) {
+	f.record("CurrentBlacklist", blacklist)
+}
+
+func (f *FakeReporter) CurrentReportOnlyMode(reportOnly bool) {
+	f.record("CurrentReportOnlyMode", reportOnly)
+}
+
+func (f *FakeReporter) CurrentReportOnlyOverride(overridden bool) {
+	f.record("CurrentReportOnlyOverride", overridden)
+}
+
+func (f *FakeReporter) ConfSync(rejected bool) {
+	f.record("ConfSync", rejected)
+}
+
+func (f *FakeReporter) CurrentLeader(leader bool) {
+	f.record("CurrentLeader", leader)
+}
+
+func (f *FakeReporter) SLOBurnRate(slo string, window time.Duration, burnRate float64) {
+	f.record("SLOBurnRate", slo, window, burnRate)
+}
+
+func (f *FakeReporter) SLOAlerting(slo string, alerting bool) {
+	f.record("SLOAlerting", slo, alerting)
+}
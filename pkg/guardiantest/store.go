@@ -0,0 +1,235 @@
+// Package guardiantest provides fakes of Guardian's stores and metric reporter, so services embedding Guardian can
+// unit test their integration without running Redis.
+//
+//	store := guardiantest.NewFakeLimitStore(guardian.Limit{Count: 2, Duration: time.Minute, Enabled: true})
+//	reporter := guardiantest.NewFakeReporter()
+//	limiter := guardian.NewIPRateLimiter(store, store, guardian.LocalClock{}, nil, logger, reporter)
+//
+// The fakes are safe for concurrent use.
+package guardiantest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dollarshaveclub/guardian/pkg/guardian"
+)
+
+const routeMatchCacheSize = 4096
+
+// IncrCall records a call to FakeLimitStore.Incr
+type IncrCall struct {
+	Key            string
+	IncrBy         uint
+	MaxBeforeBlock uint64
+	ExpireIn       time.Duration
+}
+
+// NewFakeLimitStore creates a new FakeLimitStore providing limit
+func NewFakeLimitStore(limit guardian.Limit) *FakeLimitStore {
+	return &FakeLimitStore{limit: limit, counts: make(map[string]uint64)}
+}
+
+// FakeLimitStore is an in memory guardian.LimitProvider and guardian.Counter. Counts never expire.
+type FakeLimitStore struct {
+	mu         sync.Mutex
+	limit      guardian.Limit
+	counts     map[string]uint64
+	incrs      []IncrCall
+	err        error
+	forceBlock bool
+}
+
+// GetLimit returns the limit
+func (f *FakeLimitStore) GetLimit() guardian.Limit {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.limit
+}
+
+// SetLimit sets the limit
+func (f *FakeLimitStore) SetLimit(limit guardian.Limit) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.limit = limit
+}
+
+// Incr increments key by incrBy, returning the error set by SetErr if any
+func (f *FakeLimitStore) Incr(context context.Context, key string, incrBy uint, maxBeforeBlock uint64, expireIn time.Duration) (uint64, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.incrs = append(f.incrs, IncrCall{Key: key, IncrBy: incrBy, MaxBeforeBlock: maxBeforeBlock, ExpireIn: expireIn})
+	if f.err != nil {
+		return 0, false, f.err
+	}
+
+	f.counts[key] += uint64(incrBy)
+	return f.counts[key], f.forceBlock, nil
+}
+
+// Count returns the count of key, returning the error set by SetErr if any
+func (f *FakeLimitStore) Count(context context.Context, key string) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	return f.counts[key], nil
+}
+
+// SetErr makes Incr and Count return err, or succeed again if it is nil
+func (f *FakeLimitStore) SetErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+}
+
+// SetForceBlock sets whether Incr tells the limiter to block regardless of the count, as a counter that knows a key
+// is over its limit does
+func (f *FakeLimitStore) SetForceBlock(forceBlock bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.forceBlock = forceBlock
+}
+
+// Counts returns a copy of the count of every key
+func (f *FakeLimitStore) Counts() map[string]uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	counts := make(map[string]uint64, len(f.counts))
+	for key, count := range f.counts {
+		counts[key] = count
+	}
+
+	return counts
+}
+
+// Incrs returns the calls made to Incr in order
+func (f *FakeLimitStore) Incrs() []IncrCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]IncrCall{}, f.incrs...)
+}
+
+// Reset clears the counts and recorded calls
+func (f *FakeLimitStore) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.counts = make(map[string]uint64)
+	f.incrs = nil
+}
+
+// NewFakeConfStore creates a new FakeConfStore with an empty conf
+func NewFakeConfStore() *FakeConfStore {
+	return &FakeConfStore{routeMatcher: guardian.NewRouteMatcher(nil, routeMatchCacheSize)}
+}
+
+// FakeConfStore is an in memory conf store, providing the whitelist, blacklist, limit, report only mode, route limits,
+// and rules like guardian.RedisConfStore does once conf is synced. Changes are seen immediately.
+type FakeConfStore struct {
+	mu           sync.RWMutex
+	whitelist    []net.IPNet
+	blacklist    []net.IPNet
+	limit        guardian.Limit
+	reportOnly   bool
+	routeMatcher *guardian.RouteMatcher
+	rules        []guardian.Rule
+}
+
+func (f *FakeConfStore) GetWhitelist() []net.IPNet {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.whitelist
+}
+
+func (f *FakeConfStore) SetWhitelist(whitelist []net.IPNet) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.whitelist = whitelist
+}
+
+func (f *FakeConfStore) GetBlacklist() []net.IPNet {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.blacklist
+}
+
+func (f *FakeConfStore) SetBlacklist(blacklist []net.IPNet) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.blacklist = blacklist
+}
+
+func (f *FakeConfStore) GetLimit() guardian.Limit {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.limit
+}
+
+func (f *FakeConfStore) SetLimit(limit guardian.Limit) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.limit = limit
+}
+
+func (f *FakeConfStore) GetReportOnly() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.reportOnly
+}
+
+func (f *FakeConfStore) SetReportOnly(reportOnly bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reportOnly = reportOnly
+}
+
+func (f *FakeConfStore) GetRouteMatcher() *guardian.RouteMatcher {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.routeMatcher
+}
+
+// SetRouteLimits replaces the route limits
+func (f *FakeConfStore) SetRouteLimits(routeLimits []guardian.RouteLimit) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.routeMatcher = guardian.NewRouteMatcher(routeLimits, routeMatchCacheSize)
+}
+
+func (f *FakeConfStore) GetRules() []guardian.Rule {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.rules
+}
+
+// SetRules replaces the rules, which are evaluated in order
+func (f *FakeConfStore) SetRules(rules []guardian.Rule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rules = rules
+}
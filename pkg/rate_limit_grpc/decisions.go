@@ -0,0 +1,93 @@
+package rate_limit_grpc
+
+import (
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// DecisionStreamServiceName is the name of the gRPC service streaming Guardian's decisions to subscribers
+const DecisionStreamServiceName = "guardian.v1.DecisionStream"
+
+// DecisionStreamSubscribeFullMethod is the full gRPC method name subscribers call to tail decisions
+const DecisionStreamSubscribeFullMethod = "/guardian.v1.DecisionStream/Subscribe"
+
+// The decision stream messages are written by hand, like the health checking messages, so subscribers can be
+// generated from the equivalent proto:
+//
+//	service DecisionStream {
+//	  rpc Subscribe(SubscribeRequest) returns (stream DecisionEvent);
+//	}
+
+// SubscribeRequest subscribes to the decision stream, to only blocked decisions if BlockedOnly is set
+type SubscribeRequest struct {
+	BlockedOnly bool `protobuf:"varint,1,opt,name=blocked_only,json=blockedOnly" json:"blocked_only,omitempty"`
+}
+
+func (m *SubscribeRequest) Reset()         { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()    {}
+
+// DecisionEvent is a decision Guardian made. Dropped is the number of decisions dropped for the subscriber since the
+// previous event it was sent, because it fell behind.
+type DecisionEvent struct {
+	TimestampUnixNano int64  `protobuf:"varint,1,opt,name=timestamp_unix_nano,json=timestampUnixNano" json:"timestamp_unix_nano,omitempty"`
+	RemoteAddress     string `protobuf:"bytes,2,opt,name=remote_address,json=remoteAddress" json:"remote_address,omitempty"`
+	Authority         string `protobuf:"bytes,3,opt,name=authority" json:"authority,omitempty"`
+	Method            string `protobuf:"bytes,4,opt,name=method" json:"method,omitempty"`
+	Path              string `protobuf:"bytes,5,opt,name=path" json:"path,omitempty"`
+	Blocked           bool   `protobuf:"varint,6,opt,name=blocked" json:"blocked,omitempty"`
+	Remaining         uint32 `protobuf:"varint,7,opt,name=remaining" json:"remaining,omitempty"`
+	Error             string `protobuf:"bytes,8,opt,name=error" json:"error,omitempty"`
+	Dropped           uint64 `protobuf:"varint,9,opt,name=dropped" json:"dropped,omitempty"`
+	RequestId         string `protobuf:"bytes,10,opt,name=request_id,json=requestId" json:"request_id,omitempty"`
+}
+
+func (m *DecisionEvent) Reset()         { *m = DecisionEvent{} }
+func (m *DecisionEvent) String() string { return proto.CompactTextString(m) }
+func (*DecisionEvent) ProtoMessage()    {}
+
+// DecisionStreamServer is the server API of the guardian.v1.DecisionStream service
+type DecisionStreamServer interface {
+	Subscribe(*SubscribeRequest, DecisionStream_SubscribeServer) error
+}
+
+// DecisionStream_SubscribeServer is the server side of a Subscribe stream
+type DecisionStream_SubscribeServer interface {
+	Send(*DecisionEvent) error
+	grpc.ServerStream
+}
+
+type decisionStreamSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *decisionStreamSubscribeServer) Send(m *DecisionEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// RegisterDecisionStreamServer registers d as the guardian.v1.DecisionStream service of s
+func RegisterDecisionStreamServer(s *grpc.Server, d DecisionStreamServer) {
+	s.RegisterService(&_decisionStream_serviceDesc, d)
+}
+
+func _decisionStream_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DecisionStreamServer).Subscribe(m, &decisionStreamSubscribeServer{stream})
+}
+
+var _decisionStream_serviceDesc = grpc.ServiceDesc{
+	ServiceName: DecisionStreamServiceName,
+	HandlerType: (*DecisionStreamServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _decisionStream_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "guardian/v1/decisions.proto",
+}
@@ -2,17 +2,39 @@ package rate_limit_grpc
 
 import (
 	"context"
+	"time"
 
 	ratelimit "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
 	"google.golang.org/grpc"
 )
 
-func NewRateLimitServer(srv ratelimit.RateLimitServiceServer) *grpc.Server {
-	g := grpc.NewServer()
+// RateLimitServiceName is the name of the gRPC service Envoy calls for rate limit decisions
+const RateLimitServiceName = "pb.lyft.ratelimit.RateLimitService"
+
+// ShouldRateLimitFullMethod is the full gRPC method name Envoy calls to request a rate limit decision
+const ShouldRateLimitFullMethod = "/pb.lyft.ratelimit.RateLimitService/ShouldRateLimit"
+
+// NewRateLimitServer creates a gRPC server configured with opts serving srv as the rate limit service
+func NewRateLimitServer(srv ratelimit.RateLimitServiceServer, opts ...grpc.ServerOption) *grpc.Server {
+	g := grpc.NewServer(opts...)
 	registerRateLimitServiceServer(g, srv)
 	return g
 }
 
+// RequestTimeoutInterceptor bounds the deadline of each unary RPC to timeout, so a request is abandoned rather than
+// left waiting on a slow store when the caller set no deadline or a longer one
+func RequestTimeoutInterceptor(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= timeout {
+			return handler(ctx, req)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
 // So this is mostly copy past from https://github.com/envoyproxy/go-control-plane/blob/v0.1/envoy/service/ratelimit/v2/rls.pb.go#L286
 // but with the correct ServiceName and FullMethod
 // Envoy will eventually switch to the proto linked above: https://github.com/envoyproxy/envoy/issues/1034
@@ -30,7 +52,7 @@ func _rateLimitService_ShouldRateLimit_Handler(srv interface{}, ctx context.Cont
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/pb.lyft.ratelimit.RateLimitService/ShouldRateLimit",
+		FullMethod: ShouldRateLimitFullMethod,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
 		return srv.(ratelimit.RateLimitServiceServer).ShouldRateLimit(ctx, req.(*ratelimit.RateLimitRequest))
@@ -39,7 +61,7 @@ func _rateLimitService_ShouldRateLimit_Handler(srv interface{}, ctx context.Cont
 }
 
 var _rateLimitService_serviceDesc = grpc.ServiceDesc{
-	ServiceName: "pb.lyft.ratelimit.RateLimitService",
+	ServiceName: RateLimitServiceName,
 	HandlerType: (*ratelimit.RateLimitServiceServer)(nil),
 	Methods: []grpc.MethodDesc{
 		{
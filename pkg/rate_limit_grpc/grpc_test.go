@@ -0,0 +1,56 @@
+package rate_limit_grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestRequestTimeoutInterceptor(t *testing.T) {
+	interceptor := RequestTimeoutInterceptor(time.Second)
+	info := &grpc.UnaryServerInfo{FullMethod: ShouldRateLimitFullMethod}
+
+	remaining := func(ctx context.Context) time.Duration {
+		var got time.Duration
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("expected handler context to have a deadline")
+			}
+			got = time.Until(deadline)
+			return nil, nil
+		}
+
+		if _, err := interceptor(ctx, nil, info, handler); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		return got
+	}
+
+	tests := []struct {
+		name    string
+		timeout time.Duration // of the caller, 0 for none
+		max     time.Duration
+	}{
+		{name: "NoDeadline", max: time.Second},
+		{name: "LongerDeadline", timeout: time.Hour, max: time.Second},
+		{name: "ShorterDeadline", timeout: 100 * time.Millisecond, max: 100 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+
+			if got := remaining(ctx); got > test.max || got < test.max-50*time.Millisecond {
+				t.Errorf("expected deadline in about %v, received: %v", test.max, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package rate_limit_grpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HealthCheckFullMethod is the full gRPC method name of the standard health check used by Envoy and Kubernetes
+const HealthCheckFullMethod = "/grpc.health.v1.Health/Check"
+
+// The health checking messages are written by hand from https://github.com/grpc/grpc/blob/master/src/proto/grpc/health/v1/health.proto
+// since the grpc health packages aren't vendored
+
+// HealthCheckRequest requests the serving status of Service, or of the server as a whole if Service is empty
+type HealthCheckRequest struct {
+	Service string `protobuf:"bytes,1,opt,name=service" json:"service,omitempty"`
+}
+
+func (m *HealthCheckRequest) Reset()         { *m = HealthCheckRequest{} }
+func (m *HealthCheckRequest) String() string { return proto.CompactTextString(m) }
+func (*HealthCheckRequest) ProtoMessage()    {}
+
+type HealthCheckResponse_ServingStatus int32
+
+const (
+	HealthCheckResponse_UNKNOWN         HealthCheckResponse_ServingStatus = 0
+	HealthCheckResponse_SERVING         HealthCheckResponse_ServingStatus = 1
+	HealthCheckResponse_NOT_SERVING     HealthCheckResponse_ServingStatus = 2
+	HealthCheckResponse_SERVICE_UNKNOWN HealthCheckResponse_ServingStatus = 3
+)
+
+var HealthCheckResponse_ServingStatus_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+func (x HealthCheckResponse_ServingStatus) String() string {
+	return proto.EnumName(HealthCheckResponse_ServingStatus_name, int32(x))
+}
+
+// HealthCheckResponse is the serving status of the requested service
+type HealthCheckResponse struct {
+	Status HealthCheckResponse_ServingStatus `protobuf:"varint,1,opt,name=status,enum=grpc.health.v1.HealthCheckResponse_ServingStatus" json:"status,omitempty"`
+}
+
+func (m *HealthCheckResponse) Reset()         { *m = HealthCheckResponse{} }
+func (m *HealthCheckResponse) String() string { return proto.CompactTextString(m) }
+func (*HealthCheckResponse) ProtoMessage()    {}
+
+// HealthCheckServer is the server API of the grpc.health.v1.Health service
+type HealthCheckServer interface {
+	Check(context.Context, *HealthCheckRequest) (*HealthCheckResponse, error)
+}
+
+// NewHealthServer creates a new HealthServer with the server as a whole, the empty service name, serving
+func NewHealthServer() *HealthServer {
+	return &HealthServer{statuses: map[string]HealthCheckResponse_ServingStatus{"": HealthCheckResponse_SERVING}}
+}
+
+// HealthServer implements the Check method of the grpc.health.v1.Health service
+type HealthServer struct {
+	mu       sync.RWMutex
+	statuses map[string]HealthCheckResponse_ServingStatus
+}
+
+// SetServingStatus sets the serving status of service, the empty string being the server as a whole
+func (h *HealthServer) SetServingStatus(service string, status HealthCheckResponse_ServingStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.statuses[service] = status
+}
+
+// Shutdown sets all services to NOT_SERVING so health checks fail while the server drains
+func (h *HealthServer) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for service := range h.statuses {
+		h.statuses[service] = HealthCheckResponse_NOT_SERVING
+	}
+}
+
+// Check returns the serving status of the requested service, or a NotFound error if it is unknown
+func (h *HealthServer) Check(ctx context.Context, req *HealthCheckRequest) (*HealthCheckResponse, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	s, ok := h.statuses[req.Service]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %v", req.Service)
+	}
+
+	return &HealthCheckResponse{Status: s}, nil
+}
+
+// RegisterHealthServer registers h as the grpc.health.v1.Health service of s
+func RegisterHealthServer(s *grpc.Server, h HealthCheckServer) {
+	s.RegisterService(&_health_serviceDesc, h)
+}
+
+func _health_Check_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthCheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HealthCheckServer).Check(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HealthCheckFullMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HealthCheckServer).Check(ctx, req.(*HealthCheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _health_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "grpc.health.v1.Health",
+	HandlerType: (*HealthCheckServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Check",
+			Handler:    _health_Check_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "grpc/health/v1/health.proto",
+}
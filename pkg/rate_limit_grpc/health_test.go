@@ -0,0 +1,52 @@
+package rate_limit_grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHealthCheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	health := NewHealthServer()
+	health.SetServingStatus(RateLimitServiceName, HealthCheckResponse_SERVING)
+	srv := grpc.NewServer()
+	RegisterHealthServer(srv, health)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer conn.Close()
+
+	check := func(service string) (HealthCheckResponse_ServingStatus, error) {
+		resp := &HealthCheckResponse{}
+		err := grpc.Invoke(context.Background(), HealthCheckFullMethod, &HealthCheckRequest{Service: service}, resp, conn)
+		return resp.Status, err
+	}
+
+	for _, service := range []string{"", RateLimitServiceName} {
+		if got, err := check(service); err != nil || got != HealthCheckResponse_SERVING {
+			t.Errorf("expected %q to be serving, received: %v, err: %v", service, got, err)
+		}
+	}
+
+	if _, err := check("unknown"); status.Code(err) != codes.NotFound {
+		t.Errorf("expected not found for an unknown service, received: %v", err)
+	}
+
+	health.Shutdown()
+	if got, err := check(""); err != nil || got != HealthCheckResponse_NOT_SERVING {
+		t.Errorf("expected not serving after shutdown, received: %v, err: %v", got, err)
+	}
+}